@@ -0,0 +1,154 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// configFile.go reads the config file. It's TOML, limited to what the
+// settings need: tables one level deep, strings, numbers, booleans and
+// arrays on one line.
+
+type fileValue struct {
+	value string
+	line  int
+}
+
+// parseConfigFile returns the values of the file by their setting name. Keys
+// under a table are prefixed with its name, max_age under [session] is
+// session_max_age. Arrays are joined with commas, like ADMIN_USERS.
+func parseConfigFile(path string) (map[string]fileValue, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config: %w", err)
+	}
+
+	values := map[string]fileValue{}
+	var table string
+	for i, line := range strings.Split(string(data), "\n") {
+		lineNo := i + 1
+		line = strings.TrimSpace(line)
+		if line == "" || line[0] == '#' {
+			continue
+		}
+
+		if line[0] == '[' {
+			name, rest, ok := strings.Cut(line[1:], "]")
+			name = strings.TrimSpace(name)
+			if !ok || !validConfigKey(name) || !onlyComment(rest) {
+				return nil, fmt.Errorf("%s:%d: invalid table header, expected [name]", path, lineNo)
+			}
+			table = strings.ReplaceAll(name, ".", "_") + "_"
+			continue
+		}
+
+		key, raw, ok := strings.Cut(line, "=")
+		key = strings.TrimSpace(key)
+		if !ok || !validConfigKey(key) {
+			return nil, fmt.Errorf("%s:%d: expected key = value", path, lineNo)
+		}
+		value, err := parseConfigValue(strings.TrimSpace(raw))
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, lineNo, err)
+		}
+		name := table + strings.ReplaceAll(key, ".", "_")
+		if previous, ok := values[name]; ok {
+			return nil, fmt.Errorf("%s:%d: %s is already set on line %d", path, lineNo, key, previous.line)
+		}
+		values[name] = fileValue{value: value, line: lineNo}
+	}
+	return values, nil
+}
+
+func validConfigKey(key string) bool {
+	if key == "" {
+		return false
+	}
+	for _, r := range key {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '_' || r == '-' || r == '.') {
+			return false
+		}
+	}
+	return true
+}
+
+func onlyComment(s string) bool {
+	s = strings.TrimSpace(s)
+	return s == "" || s[0] == '#'
+}
+
+func parseConfigValue(s string) (string, error) {
+	if strings.HasPrefix(s, "[") {
+		var elems []string
+		rest := strings.TrimSpace(s[1:])
+		for !strings.HasPrefix(rest, "]") {
+			elem, after, err := parseConfigScalar(rest)
+			if err != nil {
+				return "", err
+			}
+			elems = append(elems, elem)
+			rest = strings.TrimSpace(after)
+			if strings.HasPrefix(rest, ",") {
+				rest = strings.TrimSpace(rest[1:])
+			} else if !strings.HasPrefix(rest, "]") {
+				return "", errors.New("expected , or ] in array, arrays must be on one line")
+			}
+		}
+		if !onlyComment(rest[1:]) {
+			return "", errors.New("unexpected text after array")
+		}
+		return strings.Join(elems, ","), nil
+	}
+
+	value, rest, err := parseConfigScalar(s)
+	if err != nil {
+		return "", err
+	}
+	if !onlyComment(rest) {
+		return "", errors.New("unexpected text after value")
+	}
+	return value, nil
+}
+
+// parseConfigScalar parses the string, number or boolean s starts with,
+// returning the rest.
+func parseConfigScalar(s string) (value, rest string, err error) {
+	switch {
+	case s == "":
+		return "", "", errors.New("missing value")
+	case s[0] == '"':
+		for i := 1; i < len(s); i++ {
+			switch s[i] {
+			case '\\':
+				i++
+			case '"':
+				value, err := strconv.Unquote(s[:i+1])
+				if err != nil {
+					return "", "", fmt.Errorf("invalid string %s", s[:i+1])
+				}
+				return value, s[i+1:], nil
+			}
+		}
+		return "", "", errors.New("unterminated string")
+	case s[0] == '\'':
+		value, rest, ok := strings.Cut(s[1:], "'")
+		if !ok {
+			return "", "", errors.New("unterminated string")
+		}
+		return value, rest, nil
+	}
+
+	end := strings.IndexAny(s, " \t,]#")
+	if end < 0 {
+		end = len(s)
+	}
+	value = s[:end]
+	if value != "true" && value != "false" && !strings.ContainsAny(value[:1], "0123456789+-") {
+		return "", "", fmt.Errorf("invalid value %s, strings must be quoted", value)
+	}
+	// Underscores separate digits in TOML numbers, 10_000.
+	return strings.ReplaceAll(value, "_", ""), s[end:], nil
+}
@@ -0,0 +1,70 @@
+// hibp-bloom builds the breached password filter from the Have I Been Pwned
+// SHA-1 password list, for HIBP_BLOOM_PATH.
+//
+//	hibp-bloom [-fp 0.001] pwned-passwords-sha1.txt passwords.bloom
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/egemengol/kindlepathy/internal/password"
+)
+
+func main() {
+	falsePositive := flag.Float64("fp", 0.001, "false positive rate")
+	flag.Parse()
+	if flag.NArg() != 2 {
+		fmt.Fprintf(os.Stderr, "usage: hibp-bloom [-fp rate] <hashes.txt> <out.bloom>\n")
+		os.Exit(2)
+	}
+	if err := run(flag.Arg(0), flag.Arg(1), *falsePositive); err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		os.Exit(1)
+	}
+}
+
+func run(inPath, outPath string, falsePositive float64) error {
+	in, err := os.Open(inPath)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	// The filter is sized up front, count the hashes first.
+	var n uint64
+	scanner := bufio.NewScanner(in)
+	for scanner.Scan() {
+		n++
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	if n == 0 {
+		return fmt.Errorf("%s has no hashes", inPath)
+	}
+	if _, err := in.Seek(0, 0); err != nil {
+		return err
+	}
+
+	out, err := os.Create(outPath)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(out)
+	if err := password.BuildBloom(in, n, falsePositive, w); err != nil {
+		out.Close()
+		return err
+	}
+	if err := w.Flush(); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	fmt.Printf("wrote %d hashes to %s\n", n, outPath)
+	return nil
+}
@@ -2,8 +2,9 @@ package main
 
 import (
 	"context"
-	"database/sql"
 	_ "embed"
+	"errors"
+	"flag"
 	"fmt"
 	"io"
 	"log"
@@ -11,50 +12,73 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
 	"time"
 
 	"github.com/dgraph-io/badger/v4"
 	_ "github.com/mattn/go-sqlite3"
 
+	"github.com/egemengol/kindlepathy/internal/client"
 	"github.com/egemengol/kindlepathy/internal/core"
 	migrate "github.com/egemengol/kindlepathy/internal/db"
 	db "github.com/egemengol/kindlepathy/internal/db/generated"
+	"github.com/egemengol/kindlepathy/internal/matrix"
 	"github.com/egemengol/kindlepathy/internal/server"
+	"github.com/egemengol/kindlepathy/internal/smtpd"
+	"github.com/egemengol/kindlepathy/internal/telegram"
+	"github.com/egemengol/kindlepathy/web"
 )
 
 func main() {
 	ctx := context.Background()
 
-	readabilityPath := os.Getenv("READABILITY_PATH")
-	dbPath := os.Getenv("DB_PATH")
-	cachePath := os.Getenv("CACHE_PATH")
-	port := os.Getenv("PORT")
-	if port == "" {
-		port = "8080"
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		if err := runMigrate(ctx, os.Args[2:], os.Getenv, os.Stdout); err != nil {
+			fmt.Fprintf(os.Stderr, "%s\n", err)
+			os.Exit(1)
+		}
+		return
 	}
-	portInt := 0
-	_, err := fmt.Sscanf(port, "%d", &portInt)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "invalid port number: %s\n", port)
-		os.Exit(1)
+	// Arguments other than flags are commands of the client.
+	if len(os.Args) > 1 && !strings.HasPrefix(os.Args[1], "-") {
+		if err := client.Run(ctx, os.Args[1:], os.Stdout); err != nil {
+			fmt.Fprintf(os.Stderr, "%s\n", err)
+			os.Exit(1)
+		}
+		return
 	}
-	sessionStoreSecret := []byte(os.Getenv("SESSION_SECRET"))
-	if len(sessionStoreSecret) == 0 {
-		// Use a default secret for development - DO NOT use in production
-		sessionStoreSecret = []byte("dev-secret-key-32-bytes-long!!!")
-		fmt.Fprintf(os.Stderr, "Warning: SESSION_SECRET not set, using default (development only)\n")
+
+	config, err := loadConfig(os.Args[1:], os.Getenv)
+	if errors.Is(err, flag.ErrHelp) {
+		return
 	}
-	if len(sessionStoreSecret) < 32 {
-		fmt.Fprintf(os.Stderr, "SESSION_SECRET must be at least 32 bytes long\n")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
 		os.Exit(1)
 	}
 
-	config := &Config{
-		ReadabilityPath:    readabilityPath,
-		DBPath:             dbPath,
-		Port:               portInt,
-		CachePath:          cachePath,
-		SessionStoreSecret: sessionStoreSecret,
+	// The embedded engine needs no sidecar.
+	if _, err := os.Stat(config.ReadabilityPath); config.ReadabilityEngine == "sidecar" && (config.ReadabilityPath == "" || err != nil) {
+		// Without the sidecar pages are read with the built-in extractor,
+		// which gets most articles right but not all.
+		if !config.ReadabilityDownload {
+			fmt.Fprintf(os.Stderr, "%s\nStarting with the built-in extractor until then.\n", core.ReadabilityGuidance(config.ReadabilityPath))
+		} else {
+			// Installed into the data directory, next to the database.
+			dataDir := "."
+			if config.DBPath != "" {
+				dataDir = filepath.Dir(config.DBPath)
+			}
+			fmt.Fprintf(os.Stderr, "Downloading the readability sidecar (%s) into %s\n", core.ReadabilityRelease, dataDir)
+			path, err := core.InstallReadability(ctx, &http.Client{Timeout: 5 * time.Minute}, dataDir)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "failed to install readability: %s\n%s\nStarting with the built-in extractor until then.\n", err, core.ReadabilityGuidance(config.ReadabilityPath))
+			} else {
+				config.ReadabilityPath = path
+			}
+		}
 	}
 
 	if err := run(ctx, os.Stdout, config); err != nil {
@@ -64,11 +88,49 @@ func main() {
 }
 
 type Config struct {
-	ReadabilityPath    string
-	DBPath             string
-	Port               int
-	CachePath          string
-	SessionStoreSecret []byte
+	// ReadabilityEngine is what runs Readability.js, "sidecar" or
+	// "embedded".
+	ReadabilityEngine   string
+	ReadabilityPath     string
+	ReadabilityDownload bool
+	// ReadabilityTransport is how the sidecar is spoken to, "uds" or "stdio".
+	ReadabilityTransport string
+	ReadabilityLimits    core.ReadabilityLimits
+	ReadabilityWorkers   int
+	DBPath               string
+	Port                 int
+	CachePath            string
+	CacheEncryptionKey   []byte
+	CacheKeyRotation     time.Duration
+	CacheGCInterval      time.Duration
+	CacheTTLs            map[string]time.Duration
+	SessionStoreSecret   []byte
+	AuthConfig           server.AuthConfig
+	AdminUsers           []string
+	FeatureFlags         []string
+	SyncInterval         time.Duration
+	FetchTimeout         time.Duration
+	FetchWorkers         int
+	JobWorkers           int
+	PipelinesPath        string
+	Pipelines            *core.Pipelines
+	FetchHeaders         http.Header
+	FetchMaxBytes        int64
+	FetchPolicy          core.FetchPolicy
+	FetchRetries         core.FetchRetries
+	FetchProxies         core.FetchProxies
+	ScreenshotURL        string
+	RenderURL            string
+	WebDir               string
+	CompareExtractors    bool
+	UpdateCheck          bool
+	InboundSMTPAddr      string
+	InboundEmailDomain   string
+	TelegramBotToken     string
+	PublicURL            string
+	MatrixHomeserver     string
+	MatrixAccessToken    string
+	SMTP                 *core.SMTPConfig
 }
 
 func run(ctx context.Context, w io.Writer, config *Config) error {
@@ -80,34 +142,147 @@ func run(ctx context.Context, w io.Writer, config *Config) error {
 	}))
 	loggerReadability := log.Default()
 
-	// TODO WAL and foreign keys
-	sqlDB, err := sql.Open("sqlite3", config.DBPath)
+	sqlDB, err := migrate.Open(ctx, config.DBPath)
 	if err != nil {
 		return err
 	}
-	err = migrate.Migrate(ctx, sqlDB)
+	if err := migrate.CheckIntegrity(ctx, sqlDB, logger); err != nil {
+		sqlDB.Close()
+		return err
+	}
+	if err := migrate.Migrate(ctx, sqlDB); err != nil {
+		sqlDB.Close()
+		return err
+	}
 	queries := db.New(sqlDB)
 
-	logger.Info("Initializing Readability service...")
-	readability, err := core.NewReadabilityClient(ctx, logger, loggerReadability, os.TempDir(), config.ReadabilityPath, "readability")
-	if err != nil {
-		log.Fatal(err)
+	// Admins are promoted on every start, create their accounts first.
+	for _, username := range config.AdminUsers {
+		if err := queries.UsersSetAdminByName(ctx, username); err != nil {
+			return fmt.Errorf("failed to promote admin %s: %w", username, err)
+		}
 	}
 
+	build := core.GetBuildInfo()
+	logger.Info("Starting kindlepathy", "version", build.Version, "commit", build.Commit)
+
+	logger.Info("Initializing Readability service...", "engine", config.ReadabilityEngine, "transport", config.ReadabilityTransport)
+	// Started again from READABILITY_PATH on reloads, each with a socket of
+	// its own, for upgrading the binary in place. READABILITY_WORKERS of them
+	// parse pages at once.
+	readability := core.NewSwappableReadability(logger, func(ctx context.Context) (core.ReadabilitySidecar, error) {
+		return core.NewReadabilityPool(ctx, logger, config.ReadabilityWorkers, func(ctx context.Context) (core.ReadabilitySidecar, error) {
+			if config.ReadabilityEngine == "embedded" {
+				return core.NewReadabilityEmbedded(logger, config.ReadabilityLimits)
+			}
+			if config.ReadabilityTransport == "stdio" {
+				return core.NewReadabilityStdioClient(ctx, logger, loggerReadability, config.ReadabilityPath, config.ReadabilityLimits)
+			}
+			return core.NewReadabilityClient(ctx, logger, loggerReadability, os.TempDir(), config.ReadabilityPath, "", config.ReadabilityLimits)
+		})
+	})
+	if err := readability.Reload(ctx); err != nil {
+		logger.Warn("Readability is unavailable, using the built-in extractor", "error", err)
+	}
+	hangup := make(chan os.Signal, 1)
+	signal.Notify(hangup, syscall.SIGHUP)
+	defer signal.Stop(hangup)
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-hangup:
+				logger.Info("Received SIGHUP, reloading Readability service...")
+				if err := readability.Reload(ctx); err != nil {
+					logger.Error("Failed to reload Readability service", "error", err)
+				}
+			}
+		}
+	}()
+
 	httpClient := &http.Client{
-		Timeout: 10 * time.Second,
+		Timeout:   config.FetchTimeout,
+		Transport: core.NewFetchTransport(config.FetchProxies),
 	}
 
 	var cache *badger.DB
 	if config.CachePath != "" {
-		cache, err = badger.Open(badger.DefaultOptions(config.CachePath))
+		cache, err = core.OpenCache(core.CacheConfig{
+			Path:          config.CachePath,
+			EncryptionKey: config.CacheEncryptionKey,
+			KeyRotation:   config.CacheKeyRotation,
+		}, logger)
+		if err != nil {
+			readability.Close(ctx)
+			return err
+		}
 	}
 
 	coreSingleton := core.NewCore(
-		httpClient, readability, queries, logger, cache,
+		httpClient, readability, queries, logger, cache, config.SMTP,
 	)
 
-	srv := server.NewServer(coreSingleton, logger, queries, config.SessionStoreSecret)
+	for _, name := range config.FeatureFlags {
+		if err := coreSingleton.SetInstanceFlag(ctx, name, true); err != nil {
+			readability.Close(ctx)
+			return err
+		}
+	}
+
+	if config.Pipelines != nil {
+		coreSingleton.SetPipelines(config.Pipelines)
+		go coreSingleton.WatchPipelines(ctx, config.PipelinesPath, 10*time.Second)
+	}
+	coreSingleton.SetPublicURL(config.PublicURL)
+	coreSingleton.SetFetchHeaders(config.FetchHeaders)
+	coreSingleton.SetFetchMaxBytes(config.FetchMaxBytes)
+	coreSingleton.SetFetchPolicy(config.FetchPolicy)
+	coreSingleton.SetFetchRetries(config.FetchRetries)
+	coreSingleton.SetCacheTTLs(config.CacheTTLs)
+	coreSingleton.SetCompareExtractors(config.CompareExtractors)
+	if config.ScreenshotURL != "" {
+		coreSingleton.SetScreenshotter(&core.Screenshotter{
+			Endpoint: config.ScreenshotURL,
+			Client:   &http.Client{Timeout: 2 * time.Minute},
+		})
+	}
+
+	if config.RenderURL != "" {
+		coreSingleton.SetRenderer(&core.Renderer{
+			Endpoint: config.RenderURL,
+			Client:   &http.Client{Timeout: time.Minute},
+		})
+	}
+
+	go coreSingleton.RunIntegrationSync(ctx, config.SyncInterval)
+	go coreSingleton.RunFetchQueue(ctx, config.FetchWorkers)
+	go coreSingleton.RunJobQueue(ctx, config.JobWorkers)
+	if config.CacheGCInterval > 0 {
+		go coreSingleton.RunCacheGC(ctx, config.CacheGCInterval)
+	}
+	if config.UpdateCheck {
+		go coreSingleton.RunUpdateCheck(ctx, 24*time.Hour)
+	}
+
+	if config.InboundSMTPAddr != "" {
+		coreSingleton.SetInboundDomain(config.InboundEmailDomain)
+		inbound, err := smtpd.Listen(config.InboundSMTPAddr, config.InboundEmailDomain, coreSingleton, logger)
+		if err != nil {
+			readability.Close(ctx)
+			return err
+		}
+		go inbound.Serve(ctx)
+	}
+
+	if config.TelegramBotToken != "" {
+		go telegram.NewBot(config.TelegramBotToken, config.PublicURL, coreSingleton, logger).Run(ctx)
+	}
+	if config.MatrixHomeserver != "" && config.MatrixAccessToken != "" {
+		go matrix.NewBot(config.MatrixHomeserver, config.MatrixAccessToken, coreSingleton, logger).Run(ctx)
+	}
+
+	srv := server.NewServer(coreSingleton, logger, queries, config.SessionStoreSecret, config.AuthConfig, web.Assets(config.WebDir))
 
 	httpServer := &http.Server{
 		Addr:    fmt.Sprintf(":%d", config.Port),
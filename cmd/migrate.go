@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+
+	migrate "github.com/egemengol/kindlepathy/internal/db"
+)
+
+const migrateUsage = `usage:
+  kindlepathy migrate status [flags]
+  kindlepathy migrate up [flags]
+  kindlepathy migrate down <version> [flags]
+
+The database is DB_PATH, or -db-path. The server migrates up on start, down
+undoes the migrations after the version, 0 empties the database.`
+
+// runMigrate runs the migrate command, for looking at and rolling back the
+// schema of the database without starting the server.
+func runMigrate(ctx context.Context, args []string, getenv func(string) string, w io.Writer) error {
+	if len(args) == 0 {
+		return errors.New(migrateUsage)
+	}
+	command, args := args[0], args[1:]
+	if command != "status" && command != "up" && command != "down" {
+		return errors.New(migrateUsage)
+	}
+	target := 0
+	if command == "down" {
+		if len(args) == 0 {
+			return errors.New(migrateUsage)
+		}
+		var err error
+		target, err = strconv.Atoi(args[0])
+		if err != nil || target < 0 {
+			return fmt.Errorf("invalid version: %s", args[0])
+		}
+		args = args[1:]
+	}
+
+	s, err := readSettings(args, getenv)
+	if err != nil {
+		return err
+	}
+	dbPath := s.get("DB_PATH")
+	if dbPath == "" {
+		return errors.New("DB_PATH must be set")
+	}
+	if _, err := os.Stat(dbPath); err != nil && command != "up" {
+		return fmt.Errorf("failed to open database: %w", err)
+	}
+	sqlDB, err := migrate.Open(ctx, dbPath)
+	if err != nil {
+		return err
+	}
+	defer sqlDB.Close()
+
+	switch command {
+	case "status":
+	case "up":
+		err = migrate.Migrate(ctx, sqlDB)
+	case "down":
+		err = migrate.MigrateDown(ctx, sqlDB, target)
+	}
+	if err != nil {
+		return err
+	}
+
+	migrations, err := migrate.Migrations()
+	if err != nil {
+		return err
+	}
+	current, err := migrate.SchemaVersion(ctx, sqlDB)
+	if err != nil {
+		return err
+	}
+	for _, m := range migrations {
+		state := "pending"
+		if m.Version <= current {
+			state = "applied"
+		}
+		fmt.Fprintf(w, "%04d_%s\t%s\n", m.Version, m.Name, state)
+	}
+	return nil
+}
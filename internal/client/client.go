@@ -0,0 +1,344 @@
+package client
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// client.go implements the command line client that talks to a remote
+// instance through the token authenticated v1 API.
+
+const usage = `usage:
+  kindlepathy login <server-url> <api-token>
+  kindlepathy add <url>...       add the URLs, or the ones on stdin with -
+  kindlepathy list
+  kindlepathy read <id>
+  kindlepathy epub <id> [file]   save the item as an EPUB, to stdout with -
+  kindlepathy send <id> [email]  mail the item, to the kindle address by default
+  kindlepathy migrate status|up|down <version>
+
+With no arguments, or only flags, the server is started, see
+kindlepathy -help.`
+
+// Config is stored in ~/.config/kindlepathy/config.json. KINDLEPATHY_URL,
+// KINDLEPATHY_TOKEN and KINDLEPATHY_KINDLE override it.
+type Config struct {
+	URL   string `json:"url"`
+	Token string `json:"token"`
+	// Kindle is the Send to Kindle address items are sent to by default.
+	Kindle string `json:"kindle,omitempty"`
+}
+
+type item struct {
+	ID     int64      `json:"id"`
+	Title  string     `json:"title"`
+	URL    string     `json:"url"`
+	Added  time.Time  `json:"added"`
+	Read   *time.Time `json:"read"`
+	Active bool       `json:"active"`
+	Tags   []string   `json:"tags"`
+	// Chapters is set for serials with a known chapter list.
+	Chapters *struct {
+		Number int `json:"number"`
+		Total  int `json:"total"`
+	} `json:"chapters"`
+}
+
+type Client struct {
+	config     Config
+	httpClient *http.Client
+}
+
+// Run executes a client command.
+func Run(ctx context.Context, args []string, w io.Writer) error {
+	if len(args) == 0 {
+		return errors.New(usage)
+	}
+
+	if args[0] == "help" {
+		fmt.Fprintln(w, usage)
+		return nil
+	}
+	if args[0] == "login" {
+		if len(args) != 3 {
+			return errors.New(usage)
+		}
+		return login(ctx, Config{URL: strings.TrimRight(args[1], "/"), Token: args[2]}, w)
+	}
+
+	config, err := loadConfig()
+	if err != nil {
+		return err
+	}
+	c := &Client{
+		config:     config,
+		httpClient: &http.Client{Timeout: 30 * time.Second},
+	}
+
+	switch args[0] {
+	case "add":
+		if len(args) < 2 {
+			return errors.New(usage)
+		}
+		rawurls := args[1:]
+		if len(rawurls) == 1 && rawurls[0] == "-" {
+			rawurls, err = readURLs(os.Stdin)
+			if err != nil {
+				return err
+			}
+		}
+		for _, rawurl := range rawurls {
+			added, err := c.add(ctx, rawurl)
+			if err != nil {
+				return fmt.Errorf("failed to add %s: %w", rawurl, err)
+			}
+			fmt.Fprintf(w, "Added %d: %s\n", added.ID, added.Title)
+		}
+		return nil
+	case "list":
+		items, err := c.list(ctx)
+		if err != nil {
+			return err
+		}
+		for _, i := range items {
+			status := " "
+			if i.Active {
+				status = ">"
+			} else if i.Read != nil {
+				status = "✓"
+			}
+			title := i.Title
+			if title == "" {
+				title = i.URL
+			}
+			if i.Chapters != nil {
+				title += fmt.Sprintf(" (chapter %d of %d)", i.Chapters.Number, i.Chapters.Total)
+			}
+			fmt.Fprintf(w, "%6d %s %s\n", i.ID, status, title)
+		}
+		return nil
+	case "read":
+		if len(args) != 2 {
+			return errors.New(usage)
+		}
+		return c.request(ctx, "GET", "/api/v1/items/"+args[1]+"/text", nil, w)
+	case "epub":
+		if len(args) != 2 && len(args) != 3 {
+			return errors.New(usage)
+		}
+		path := args[1] + ".epub"
+		if len(args) == 3 {
+			path = args[2]
+		}
+		return c.epub(ctx, args[1], path, w)
+	case "send":
+		if len(args) != 2 && len(args) != 3 {
+			return errors.New(usage)
+		}
+		to := c.config.Kindle
+		if len(args) == 3 {
+			to = args[2]
+		}
+		if to == "" {
+			return errors.New("no address to send to, give one or set \"kindle\" in the config")
+		}
+		body, err := json.Marshal(map[string]string{"to": to})
+		if err != nil {
+			return err
+		}
+		if err := c.request(ctx, "POST", "/api/v1/items/"+args[1]+"/send", body, io.Discard); err != nil {
+			return err
+		}
+		fmt.Fprintf(w, "Sending %s to %s\n", args[1], to)
+		return nil
+	default:
+		return errors.New(usage)
+	}
+}
+
+func configPath() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "kindlepathy", "config.json"), nil
+}
+
+func loadConfig() (Config, error) {
+	var config Config
+	path, err := configPath()
+	if err != nil {
+		return config, err
+	}
+	data, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return config, fmt.Errorf("failed to read config: %w", err)
+	}
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &config); err != nil {
+			return config, fmt.Errorf("failed to parse %s: %w", path, err)
+		}
+	}
+
+	if v := os.Getenv("KINDLEPATHY_URL"); v != "" {
+		config.URL = strings.TrimRight(v, "/")
+	}
+	if v := os.Getenv("KINDLEPATHY_TOKEN"); v != "" {
+		config.Token = v
+	}
+	if v := os.Getenv("KINDLEPATHY_KINDLE"); v != "" {
+		config.Kindle = v
+	}
+	if config.URL == "" || config.Token == "" {
+		return config, errors.New("not logged in, run: kindlepathy login <server-url> <api-token>")
+	}
+	return config, nil
+}
+
+// login checks the token against the server before saving it.
+func login(ctx context.Context, config Config, w io.Writer) error {
+	c := &Client{
+		config:     config,
+		httpClient: &http.Client{Timeout: 30 * time.Second},
+	}
+	if _, err := c.list(ctx); err != nil {
+		return fmt.Errorf("failed to log in: %w", err)
+	}
+
+	path, err := configPath()
+	if err != nil {
+		return err
+	}
+	// The Kindle address outlives logging in again.
+	if data, err := os.ReadFile(path); err == nil {
+		var old Config
+		if json.Unmarshal(data, &old) == nil {
+			config.Kindle = old.Kindle
+		}
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return fmt.Errorf("failed to create config dir: %w", err)
+	}
+	data, err := json.MarshalIndent(config, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		return fmt.Errorf("failed to write config: %w", err)
+	}
+	fmt.Fprintf(w, "Logged in to %s, config saved to %s\n", config.URL, path)
+	return nil
+}
+
+func (c *Client) add(ctx context.Context, rawurl string) (*item, error) {
+	body, err := json.Marshal(map[string]string{"url": rawurl})
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if err := c.request(ctx, "POST", "/api/v1/items", body, &buf); err != nil {
+		return nil, err
+	}
+	var added item
+	if err := json.Unmarshal(buf.Bytes(), &added); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+	return &added, nil
+}
+
+func (c *Client) list(ctx context.Context) ([]item, error) {
+	var buf bytes.Buffer
+	if err := c.request(ctx, "GET", "/api/v1/items", nil, &buf); err != nil {
+		return nil, err
+	}
+	var items []item
+	if err := json.Unmarshal(buf.Bytes(), &items); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+	return items, nil
+}
+
+// readURLs reads the URLs to add from r, one per line. Blank lines and
+// lines starting with # are skipped.
+func readURLs(r io.Reader) ([]string, error) {
+	var rawurls []string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		rawurls = append(rawurls, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read URLs: %w", err)
+	}
+	return rawurls, nil
+}
+
+// epub saves the item as an EPUB to path, or writes it to w when path is
+// "-". The file is only created once the server has made the book.
+func (c *Client) epub(ctx context.Context, id, path string, w io.Writer) error {
+	var buf bytes.Buffer
+	if err := c.request(ctx, "GET", "/api/v1/items/"+id+"/epub", nil, &buf); err != nil {
+		return err
+	}
+	if path == "-" {
+		_, err := w.Write(buf.Bytes())
+		return err
+	}
+	if err := os.WriteFile(path, buf.Bytes(), 0o644); err != nil {
+		return fmt.Errorf("failed to write EPUB: %w", err)
+	}
+	fmt.Fprintf(w, "Saved %s\n", path)
+	return nil
+}
+
+// request calls the API and copies the response body to w.
+func (c *Client) request(ctx context.Context, method, path string, body []byte, w io.Writer) error {
+	var reqBody io.Reader
+	if body != nil {
+		reqBody = bytes.NewReader(body)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.config.URL+path, reqBody)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+c.config.Token)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		// The API's errors come as {"error": {"code": ..., "message": ...}},
+		// a proxy's in front of it may not.
+		var apiErr struct {
+			Error struct {
+				Message string `json:"message"`
+			} `json:"error"`
+		}
+		if json.Unmarshal(msg, &apiErr) == nil && apiErr.Error.Message != "" {
+			msg = []byte(apiErr.Error.Message)
+		}
+		return fmt.Errorf("server returned %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	_, err = io.Copy(w, resp.Body)
+	return err
+}
@@ -0,0 +1,187 @@
+package core
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	db "github.com/egemengol/kindlepathy/internal/db/generated"
+)
+
+// Landing pages a user can choose to open on "/" and after logging in.
+const (
+	LandingLibrary = "library"
+	LandingReader  = "read"
+)
+
+func (c *Core) SetLandingPage(ctx context.Context, userID int64, page string) error {
+	if page != LandingLibrary && page != LandingReader {
+		return fmt.Errorf("invalid landing page: %q", page)
+	}
+	return c.queries.UsersSetLandingPage(ctx, db.UsersSetLandingPageParams{
+		LandingPage: page,
+		ID:          userID,
+	})
+}
+
+// UsernameChangeCooldown is how long a user waits between username changes.
+const UsernameChangeCooldown = 30 * 24 * time.Hour
+
+var (
+	ErrUsernameTaken    = errors.New("username is already taken")
+	ErrUsernameCooldown = errors.New("username was changed recently")
+)
+
+type UsernameChange struct {
+	OldUsername string
+	NewUsername string
+	Changed     time.Time
+}
+
+// ListUsernameChanges returns the previous usernames of the user, latest
+// first.
+func (c *Core) ListUsernameChanges(ctx context.Context, userID int64) ([]UsernameChange, error) {
+	rows, err := c.queries.UsernameHistoryListPerUser(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list username changes: %w", err)
+	}
+	changes := make([]UsernameChange, len(rows))
+	for i, row := range rows {
+		changes[i] = UsernameChange{
+			OldUsername: row.OldUsername,
+			NewUsername: row.NewUsername,
+			Changed:     time.Unix(row.ChangedTs, 0),
+		}
+	}
+	return changes, nil
+}
+
+// NextUsernameChange returns when the user can change their username again,
+// nil if they can now.
+func (c *Core) NextUsernameChange(ctx context.Context, userID int64, now time.Time) (*time.Time, error) {
+	changes, err := c.ListUsernameChanges(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if len(changes) == 0 {
+		return nil, nil
+	}
+	next := changes[0].Changed.Add(UsernameChangeCooldown)
+	if !next.After(now) {
+		return nil, nil
+	}
+	return &next, nil
+}
+
+// ChangeUsername renames the user, keeping the old name in the history.
+// Sessions and ownership are keyed on the user ID, so they are unaffected.
+func (c *Core) ChangeUsername(ctx context.Context, userID int64, username string, now time.Time) error {
+	username = strings.TrimSpace(username)
+	if username == "" || len(username) > 255 {
+		return fmt.Errorf("invalid username")
+	}
+
+	user, err := c.queries.UsersGet(ctx, userID)
+	if err != nil {
+		return fmt.Errorf("failed to get user: %w", err)
+	}
+	if user.Username == username {
+		return nil
+	}
+
+	next, err := c.NextUsernameChange(ctx, userID, now)
+	if err != nil {
+		return err
+	}
+	if next != nil {
+		return fmt.Errorf("%w, try again after %s", ErrUsernameCooldown, next.Format("Jan 2, 2006"))
+	}
+
+	_, err = c.queries.UsersGetByName(ctx, username)
+	if err == nil {
+		return ErrUsernameTaken
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("failed to check username: %w", err)
+	}
+
+	err = c.queries.UsersSetUsername(ctx, db.UsersSetUsernameParams{
+		Username: username,
+		ID:       userID,
+	})
+	if err != nil {
+		// Lost a race for the name against another rename or signup.
+		if strings.Contains(err.Error(), "UNIQUE constraint failed") {
+			return ErrUsernameTaken
+		}
+		return fmt.Errorf("failed to change username: %w", err)
+	}
+	return c.queries.UsernameHistoryAdd(ctx, db.UsernameHistoryAddParams{
+		UserID:      userID,
+		OldUsername: user.Username,
+		NewUsername: username,
+		ChangedTs:   now.Unix(),
+	})
+}
+
+// DeleteAccount removes the user and, by the cascades of the schema,
+// everything of theirs: items with their uploaded content, sessions, tokens
+// and integrations. Pages cached for the user alone go too.
+func (c *Core) DeleteAccount(ctx context.Context, userID int64) error {
+	if err := c.queries.UsersDelete(ctx, userID); err != nil {
+		return fmt.Errorf("failed to delete user: %w", err)
+	}
+
+	c.flags.mu.Lock()
+	delete(c.flags.users, userID)
+	c.flags.mu.Unlock()
+
+	if c.cache != nil {
+		if err := c.cache.DropPrefix([]byte(userCachePrefix(userID) + ":")); err != nil {
+			c.Logger.Warn("failed to drop cached pages of deleted user", "error", err, "userID", userID)
+		}
+	}
+	return nil
+}
+
+// UserUsage is an account as listed to admins, with what it stores.
+type UserUsage struct {
+	ID                 int64
+	Username           string
+	Created            time.Time
+	IsAdmin            bool
+	Disabled           *time.Time
+	MustChangePassword bool
+	Items              int64
+	// StorageBytes is the size of the pages uploaded to the user's items and
+	// of their screenshots, the content fetched from the web is only cached.
+	StorageBytes int64
+}
+
+// ListUsers returns every account by username, for the admin panel.
+func (c *Core) ListUsers(ctx context.Context) ([]UserUsage, error) {
+	rows, err := c.queries.UsersListWithUsage(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list users: %w", err)
+	}
+	users := make([]UserUsage, len(rows))
+	for i, row := range rows {
+		users[i] = UserUsage{
+			ID:                 row.ID,
+			Username:           row.Username,
+			Created:            row.CreatedAt.Time,
+			IsAdmin:            row.IsAdmin,
+			MustChangePassword: row.MustChangePassword,
+			Items:              row.ItemCount,
+			StorageBytes:       row.UploadedBytes + row.ScreenshotBytes,
+		}
+		if ts, ok := row.DisabledTs.(int64); ok {
+			disabled := time.Unix(ts, 0)
+			users[i].Disabled = &disabled
+		}
+	}
+	return users, nil
+}
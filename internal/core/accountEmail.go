@@ -0,0 +1,159 @@
+package core
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/mail"
+	"strings"
+	"time"
+
+	db "github.com/egemengol/kindlepathy/internal/db/generated"
+)
+
+// Accounts can have an email address, optional, for anything the server
+// needs to tell the user. An address is only used once verified.
+
+const emailVerificationTTL = 24 * time.Hour
+
+// ErrNoAccountEmail is returned when mailing a user without a verified address.
+var ErrNoAccountEmail = errors.New("account has no verified email address")
+
+// ErrNoPublicURL is returned when mailing a link without knowing the URL
+// the server is reached at.
+var ErrNoPublicURL = errors.New("the public URL of this server is not configured")
+
+// SetPublicURL sets the URL the server is reached at, which verification
+// links point to. Taking it from a request's Host would let anyone asking
+// for a link to someone's address point it at their own server.
+func (c *Core) SetPublicURL(publicURL string) {
+	c.publicURL = strings.TrimRight(publicURL, "/")
+}
+
+type AccountEmail struct {
+	// Address is the verified address, empty if there is none.
+	Address  string
+	Verified time.Time
+	// Pending is an address waiting for verification.
+	Pending string
+}
+
+func (c *Core) GetAccountEmail(ctx context.Context, userID int64, now time.Time) (*AccountEmail, error) {
+	user, err := c.queries.UsersGet(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get user: %w", err)
+	}
+	email := &AccountEmail{}
+	if address, ok := user.Email.(string); ok {
+		email.Address = address
+		if ts, ok := user.EmailVerifiedTs.(int64); ok {
+			email.Verified = time.Unix(ts, 0)
+		}
+	}
+
+	pending, err := c.queries.EmailVerificationsGetPerUser(ctx, db.EmailVerificationsGetPerUserParams{
+		UserID:    userID,
+		ExpiresTs: now.Unix(),
+	})
+	if err == nil {
+		email.Pending = pending.Email
+	} else if !errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("failed to get pending verification: %w", err)
+	}
+	return email, nil
+}
+
+// StartEmailVerification mails a verification link for the address to it,
+// on the public URL of the server.
+func (c *Core) StartEmailVerification(ctx context.Context, userID int64, address string, now time.Time) error {
+	if c.smtp == nil {
+		return ErrMailNotConfigured
+	}
+	if c.publicURL == "" {
+		return ErrNoPublicURL
+	}
+	parsed, err := mail.ParseAddress(address)
+	if err != nil {
+		return fmt.Errorf("invalid email address: %q", address)
+	}
+
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return fmt.Errorf("failed to generate token: %w", err)
+	}
+	token := hex.EncodeToString(buf)
+
+	// Only the latest requested address can be verified.
+	if err := c.queries.EmailVerificationsDeletePerUser(ctx, userID); err != nil {
+		return fmt.Errorf("failed to delete verifications: %w", err)
+	}
+	err = c.queries.EmailVerificationsAdd(ctx, db.EmailVerificationsAddParams{
+		TokenHash: HashAPIToken(token),
+		UserID:    userID,
+		Email:     parsed.Address,
+		ExpiresTs: now.Add(emailVerificationTTL).Unix(),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to store verification: %w", err)
+	}
+
+	body := fmt.Sprintf("Open this link to use this address for your Kindlepathy account:\n\n%s/settings/account/email/verify?token=%s\n\nThe link expires in 24 hours. If you didn't ask for this, ignore this email.", c.publicURL, token)
+	msg := buildTextMessage(c.smtp.From, parsed.Address, "Verify your email address", body, now)
+	return c.sendMail(parsed.Address, msg)
+}
+
+// VerifyEmail makes the address of the verification token the address of
+// its account.
+func (c *Core) VerifyEmail(ctx context.Context, token string, now time.Time) error {
+	verification, err := c.queries.EmailVerificationsGet(ctx, HashAPIToken(token))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return fmt.Errorf("invalid verification link")
+		}
+		return fmt.Errorf("failed to get verification: %w", err)
+	}
+	if now.Unix() > verification.ExpiresTs {
+		return fmt.Errorf("verification link has expired")
+	}
+
+	err = c.queries.UsersSetEmail(ctx, db.UsersSetEmailParams{
+		Email:           verification.Email,
+		EmailVerifiedTs: now.Unix(),
+		ID:              verification.UserID,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to set email: %w", err)
+	}
+	return c.queries.EmailVerificationsDeletePerUser(ctx, verification.UserID)
+}
+
+// RemoveEmail forgets the address of the user, including pending ones.
+func (c *Core) RemoveEmail(ctx context.Context, userID int64) error {
+	if err := c.queries.EmailVerificationsDeletePerUser(ctx, userID); err != nil {
+		return fmt.Errorf("failed to delete verifications: %w", err)
+	}
+	return c.queries.UsersSetEmail(ctx, db.UsersSetEmailParams{
+		Email:           nil,
+		EmailVerifiedTs: nil,
+		ID:              userID,
+	})
+}
+
+// MailUser sends a plain text email to the verified address of the user.
+func (c *Core) MailUser(ctx context.Context, userID int64, subject, body string, now time.Time) error {
+	if c.smtp == nil {
+		return ErrMailNotConfigured
+	}
+	user, err := c.queries.UsersGet(ctx, userID)
+	if err != nil {
+		return fmt.Errorf("failed to get user: %w", err)
+	}
+	address, ok := user.Email.(string)
+	if !ok || address == "" {
+		return ErrNoAccountEmail
+	}
+	return c.sendMail(address, buildTextMessage(c.smtp.From, address, subject, body, now))
+}
@@ -0,0 +1,96 @@
+package core
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+
+	db "github.com/egemengol/kindlepathy/internal/db/generated"
+)
+
+// apiTokenPrefix makes tokens recognizable, e.g. in config files.
+const apiTokenPrefix = "kp_"
+
+type APIToken struct {
+	ID       int64
+	Name     string
+	Created  time.Time
+	LastUsed *time.Time
+}
+
+// HashAPIToken returns the form a token is stored in. Only the hash is kept,
+// so a leaked database doesn't leak working tokens.
+func HashAPIToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// CreateAPIToken creates a token for API clients such as the CLI. The token is
+// only returned here, it cannot be retrieved later.
+func (c *Core) CreateAPIToken(ctx context.Context, userID int64, name string, now time.Time) (string, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return "", fmt.Errorf("token name cannot be empty")
+	}
+
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("failed to generate token: %w", err)
+	}
+	token := apiTokenPrefix + hex.EncodeToString(buf)
+
+	err := c.queries.ApiTokensAdd(ctx, db.ApiTokensAddParams{
+		UserID:    userID,
+		Name:      name,
+		TokenHash: HashAPIToken(token),
+		CreatedTs: now.Unix(),
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to store token: %w", err)
+	}
+	return token, nil
+}
+
+// RenewAPIToken creates a token in place of the user's tokens with the same
+// name, for clients that log in again instead of keeping their token.
+func (c *Core) RenewAPIToken(ctx context.Context, userID int64, name string, now time.Time) (string, error) {
+	err := c.queries.ApiTokensDeleteByName(ctx, db.ApiTokensDeleteByNameParams{
+		UserID: userID,
+		Name:   strings.TrimSpace(name),
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to delete old tokens: %w", err)
+	}
+	return c.CreateAPIToken(ctx, userID, name, now)
+}
+
+func (c *Core) ListAPITokens(ctx context.Context, userID int64) ([]APIToken, error) {
+	rows, err := c.queries.ApiTokensListPerUser(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list tokens: %w", err)
+	}
+	tokens := make([]APIToken, len(rows))
+	for i, row := range rows {
+		tokens[i] = APIToken{
+			ID:      row.ID,
+			Name:    row.Name,
+			Created: time.Unix(row.CreatedTs, 0),
+		}
+		if row.LastUsedTs != nil {
+			t := time.Unix(row.LastUsedTs.(int64), 0)
+			tokens[i].LastUsed = &t
+		}
+	}
+	return tokens, nil
+}
+
+func (c *Core) DeleteAPIToken(ctx context.Context, userID int64, tokenID int64) error {
+	return c.queries.ApiTokensDelete(ctx, db.ApiTokensDeleteParams{
+		ID:     tokenID,
+		UserID: userID,
+	})
+}
@@ -0,0 +1,375 @@
+package core
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+	db "github.com/egemengol/kindlepathy/internal/db/generated"
+)
+
+// Books uploaded as EPUB files are read like serials: every chapter of the
+// spine is a page of a single item, stored like uploaded content, and the
+// reader's next and previous buttons go through them. The pages have book:
+// URLs, the book's hash as the host and the chapter's path in the EPUB as
+// the path, so navigation, the history and the chapter progress work on
+// them as on the pages of a site.
+
+// bookScheme is the scheme of the URLs of the pages of books.
+const bookScheme = "book"
+
+// MaxBookBytes is the largest book that can be uploaded.
+const MaxBookBytes = 50 << 20
+
+// maxBookImageBytes is the largest image kept in a page, inlined. Larger
+// ones are left out.
+const maxBookImageBytes = 1 << 20
+
+// ErrUnsupportedBook is returned for books in other formats than EPUB.
+var ErrUnsupportedBook = errors.New("only EPUB books can be uploaded, MOBI files can be converted with calibre")
+
+// isBookURL tells whether the URL is of a page of an uploaded book.
+func isBookURL(rawURL string) bool {
+	return strings.HasPrefix(rawURL, bookScheme+"://")
+}
+
+type book struct {
+	Title    string
+	Author   string
+	Chapters []bookChapter
+}
+
+type bookChapter struct {
+	// Path is where the chapter is in the EPUB.
+	Path  string
+	Title string
+	HTML  string
+}
+
+type epubContainerXML struct {
+	Rootfiles []struct {
+		FullPath string `xml:"full-path,attr"`
+	} `xml:"rootfiles>rootfile"`
+}
+
+type epubPackageXML struct {
+	Metadata struct {
+		Title   []string `xml:"title"`
+		Creator []string `xml:"creator"`
+	} `xml:"metadata"`
+	Manifest []struct {
+		ID        string `xml:"id,attr"`
+		Href      string `xml:"href,attr"`
+		MediaType string `xml:"media-type,attr"`
+	} `xml:"manifest>item"`
+	Spine []struct {
+		IDRef  string `xml:"idref,attr"`
+		Linear string `xml:"linear,attr"`
+	} `xml:"spine>itemref"`
+}
+
+// parseEPUB reads the metadata and the chapters of the spine of an EPUB.
+// Images are inlined in the chapters, links between them are dropped since
+// the reader goes from page to page with its buttons.
+func parseEPUB(r io.ReaderAt, size int64) (*book, error) {
+	archive, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, fmt.Errorf("not an EPUB file: %w", err)
+	}
+	files := make(map[string]*zip.File, len(archive.File))
+	for _, f := range archive.File {
+		files[f.Name] = f
+	}
+	readFile := func(name string) ([]byte, error) {
+		f, ok := files[name]
+		if !ok {
+			return nil, fmt.Errorf("%s is missing", name)
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return nil, err
+		}
+		defer rc.Close()
+		return io.ReadAll(io.LimitReader(rc, MaxBookBytes))
+	}
+
+	containerXML, err := readFile("META-INF/container.xml")
+	if err != nil {
+		return nil, fmt.Errorf("not an EPUB file: %w", err)
+	}
+	var container epubContainerXML
+	if err := xml.Unmarshal(containerXML, &container); err != nil || len(container.Rootfiles) == 0 {
+		return nil, fmt.Errorf("not an EPUB file: no package in its container")
+	}
+	opfPath := container.Rootfiles[0].FullPath
+	opfXML, err := readFile(opfPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read package: %w", err)
+	}
+	var pkg epubPackageXML
+	if err := xml.Unmarshal(opfXML, &pkg); err != nil {
+		return nil, fmt.Errorf("failed to parse package: %w", err)
+	}
+
+	b := &book{}
+	if len(pkg.Metadata.Title) > 0 {
+		b.Title = strings.TrimSpace(pkg.Metadata.Title[0])
+	}
+	if len(pkg.Metadata.Creator) > 0 {
+		b.Author = strings.TrimSpace(pkg.Metadata.Creator[0])
+	}
+
+	// Paths in the package are relative to it and URL-encoded.
+	opfDir := path.Dir(opfPath)
+	resolve := func(dir, href string) string {
+		if unescaped, err := url.PathUnescape(href); err == nil {
+			href = unescaped
+		}
+		return path.Join(dir, href)
+	}
+	type manifestItem struct{ path, mediaType string }
+	manifest := make(map[string]manifestItem, len(pkg.Manifest))
+	mediaTypes := make(map[string]string, len(pkg.Manifest))
+	for _, item := range pkg.Manifest {
+		p := resolve(opfDir, item.Href)
+		manifest[item.ID] = manifestItem{p, item.MediaType}
+		mediaTypes[p] = item.MediaType
+	}
+	image := func(p string) (string, bool) {
+		f, ok := files[p]
+		if !ok || f.UncompressedSize64 > maxBookImageBytes {
+			return "", false
+		}
+		data, err := readFile(p)
+		if err != nil {
+			return "", false
+		}
+		mediaType := mediaTypes[p]
+		if mediaType == "" {
+			mediaType = mime.TypeByExtension(path.Ext(p))
+		}
+		if !strings.HasPrefix(mediaType, "image/") {
+			return "", false
+		}
+		return "data:" + mediaType + ";base64," + base64.StdEncoding.EncodeToString(data), true
+	}
+
+	for _, ref := range pkg.Spine {
+		item, ok := manifest[ref.IDRef]
+		if !ok || ref.Linear == "no" || (item.mediaType != "application/xhtml+xml" && item.mediaType != "text/html") {
+			continue
+		}
+		data, err := readFile(item.path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read chapter: %w", err)
+		}
+		chapter, ok, err := parseEPUBChapter(item.path, data, image)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			b.Chapters = append(b.Chapters, chapter)
+		}
+	}
+	if len(b.Chapters) == 0 {
+		return nil, fmt.Errorf("the book has no chapters")
+	}
+	return b, nil
+}
+
+// parseEPUBChapter reads the body of a chapter, ok is false when it's
+// empty.
+func parseEPUBChapter(chapterPath string, data []byte, image func(path string) (string, bool)) (bookChapter, bool, error) {
+	doc, err := goquery.NewDocumentFromReader(bytes.NewReader(data))
+	if err != nil {
+		return bookChapter{}, false, fmt.Errorf("failed to parse chapter %s: %w", chapterPath, err)
+	}
+	dir := path.Dir(chapterPath)
+	body := doc.Find("body")
+
+	body.Find("img[src]").Each(func(_ int, img *goquery.Selection) {
+		src := img.AttrOr("src", "")
+		if u, err := url.Parse(src); err == nil && u.Scheme == "" {
+			if unescaped, err := url.PathUnescape(u.Path); err == nil {
+				if inlined, ok := image(path.Join(dir, unescaped)); ok {
+					img.SetAttr("src", inlined)
+					img.RemoveAttr("srcset")
+					return
+				}
+			}
+		}
+		if !strings.HasPrefix(src, "http://") && !strings.HasPrefix(src, "https://") {
+			img.Remove()
+		}
+	})
+	body.Find("a[href]").Each(func(_ int, a *goquery.Selection) {
+		u, err := url.Parse(a.AttrOr("href", ""))
+		if err != nil || (u.Scheme == "" && u.Path != "") {
+			a.RemoveAttr("href")
+		}
+	})
+
+	if strings.TrimSpace(body.Text()) == "" && body.Find("img").Length() == 0 {
+		return bookChapter{}, false, nil
+	}
+	title := strings.TrimSpace(body.Find("h1, h2, h3").First().Text())
+	if title == "" {
+		title = strings.TrimSpace(doc.Find("title").First().Text())
+	}
+	contentHTML, err := body.Html()
+	if err != nil {
+		return bookChapter{}, false, fmt.Errorf("failed to render chapter %s: %w", chapterPath, err)
+	}
+	return bookChapter{Path: chapterPath, Title: strings.Join(strings.Fields(title), " "), HTML: contentHTML}, true, nil
+}
+
+// AddBook adds an EPUB book to the library as an item read chapter by
+// chapter, and makes it the active item. The same book uploaded again is
+// replaced.
+func (c *Core) AddBook(ctx context.Context, userID int64, r io.ReaderAt, size int64, now time.Time) (int64, error) {
+	if size > MaxBookBytes {
+		return 0, fmt.Errorf("book is too large, at most %d MB", MaxBookBytes>>20)
+	}
+	head := make([]byte, 68)
+	if n, _ := r.ReadAt(head, 0); n == len(head) && string(head[60:68]) == "BOOKMOBI" {
+		return 0, ErrUnsupportedBook
+	}
+	h := sha256.New()
+	if _, err := io.Copy(h, io.NewSectionReader(r, 0, size)); err != nil {
+		return 0, fmt.Errorf("failed to read book: %w", err)
+	}
+	bookID := hex.EncodeToString(h.Sum(nil))[:16]
+
+	b, err := parseEPUB(r, size)
+	if err != nil {
+		return 0, err
+	}
+	if b.Title == "" {
+		b.Title = b.Chapters[0].Title
+	}
+
+	var pages []db.ItemPagesAddParams
+	var all strings.Builder
+	for i, chapter := range b.Chapters {
+		contentHTML, err := SanitizeHTML(chapter.HTML)
+		if err != nil {
+			return 0, fmt.Errorf("failed to sanitize chapter: %w", err)
+		}
+		pageURL := (&url.URL{Scheme: bookScheme, Host: bookID, Path: "/" + chapter.Path}).String()
+		hash, err := c.storeContent(ctx, pageURL, contentHTML, now)
+		if err != nil {
+			return 0, err
+		}
+		pages = append(pages, db.ItemPagesAddParams{
+			Position:    int64(i),
+			Url:         pageURL,
+			Title:       chapter.Title,
+			ContentHash: hash,
+		})
+		all.WriteString(contentHTML)
+	}
+
+	itemID, err := c.queries.ItemsAddWithUploadedContent(ctx, db.ItemsAddWithUploadedContentParams{
+		UserID:      userID,
+		Title:       &b.Title,
+		Url:         pages[0].Url,
+		AddedTs:     now.Unix(),
+		ContentHash: pages[0].ContentHash,
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to add book: %w", err)
+	}
+	if err := c.queries.ItemPagesDelete(ctx, itemID); err != nil {
+		return 0, fmt.Errorf("failed to delete pages: %w", err)
+	}
+	for _, page := range pages {
+		page.ItemID = itemID
+		if err := c.queries.ItemPagesAdd(ctx, page); err != nil {
+			return 0, fmt.Errorf("failed to add page: %w", err)
+		}
+	}
+	if err := c.setBookChapterList(ctx, itemID, pages, all.String(), now); err != nil {
+		c.Logger.Warn("failed to store chapter list of book", "error", err, "itemID", itemID)
+	}
+	c.indexItem(ctx, itemID, b.Title, all.String())
+	// Listed as a book rather than by the made up host of its pages.
+	c.setItemMetadata(ctx, itemID, &Clean{ContentHTML: all.String(), Byline: b.Author, SiteName: "Book"})
+
+	err = c.queries.UsersSetActiveItem(ctx, db.UsersSetActiveItemParams{
+		ActiveItemID: itemID,
+		ID:           userID,
+	})
+	if err != nil {
+		c.Logger.Warn("failed to set active item", "error", err, "userID", userID)
+	}
+	c.Logger.Info("added book", "userID", userID, "itemID", itemID, "chapters", len(pages))
+	return itemID, nil
+}
+
+// setBookChapterList stores the chapters of a book as its chapter list, the
+// library tells how many are left like for serials.
+func (c *Core) setBookChapterList(ctx context.Context, itemID int64, pages []db.ItemPagesAddParams, contentHTML string, now time.Time) error {
+	chapters := make([]Chapter, len(pages))
+	for i, page := range pages {
+		chapters[i] = Chapter{URL: page.Url, Title: page.Title}
+	}
+	chaptersJSON, err := json.Marshal(chapters)
+	if err != nil {
+		return fmt.Errorf("failed to encode chapter list: %w", err)
+	}
+	err = c.queries.ChapterListsSet(ctx, db.ChapterListsSetParams{
+		ItemID:    itemID,
+		Chapters:  string(chaptersJSON),
+		FetchedTs: now.Unix(),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to store chapter list: %w", err)
+	}
+	if minutes := EstimateReadingMinutes(contentHTML) / len(pages); minutes > 0 {
+		err := c.queries.ChapterListsSetMinutes(ctx, db.ChapterListsSetMinutesParams{ChapterMinutes: int64(minutes), ItemID: itemID})
+		if err != nil {
+			return fmt.Errorf("failed to store chapter reading time: %w", err)
+		}
+	}
+	return nil
+}
+
+// bookPage returns the page of the book being read, with the links to the
+// pages around it. ok is false when the item has no such page.
+func (c *Core) bookPage(ctx context.Context, item db.Item) (clean *Clean, ok bool, err error) {
+	page, err := c.queries.ItemPagesGet(ctx, db.ItemPagesGetParams{ItemID: item.ID, Url: item.Url})
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to get page: %w", err)
+	}
+	compressed, err := c.queries.ContentsGet(ctx, page.ContentHash)
+	if err != nil {
+		return nil, true, fmt.Errorf("failed to get content: %w", err)
+	}
+	contentHTML, err := DecompressHTML(compressed)
+	if err != nil {
+		return nil, true, fmt.Errorf("failed to decompress content: %w", err)
+	}
+	clean = &Clean{ContentHTML: contentHTML}
+	clean.Title, _ = item.Title.(string)
+	clean.NavPrev, _ = page.PrevUrl.(string)
+	clean.NavNext, _ = page.NextUrl.(string)
+	return clean, true, nil
+}
@@ -0,0 +1,123 @@
+package core
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	db "github.com/egemengol/kindlepathy/internal/db/generated"
+)
+
+// MaxBulkAdd is the most URLs added at once.
+const MaxBulkAdd = 500
+
+// bulkAddWorkers is how many URLs are added at once. The pages themselves
+// are fetched by the fetch queue later.
+const bulkAddWorkers = 4
+
+// AddResult is what became of a URL of a bulk add.
+type AddResult struct {
+	URL    string
+	ItemID int64
+	// Existing is set when the URL was already in the library.
+	Existing bool
+	Error    string
+}
+
+// ParseURLList reads a list of URLs, one per line. Blank lines, lines
+// starting with # and repeated URLs are skipped.
+func ParseURLList(r io.Reader) ([]string, error) {
+	var urls []string
+	seen := map[string]bool{}
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") || seen[line] {
+			continue
+		}
+		seen[line] = true
+		urls = append(urls, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read URL list: %w", err)
+	}
+	if len(urls) > MaxBulkAdd {
+		return nil, fmt.Errorf("too many URLs, at most %d at once", MaxBulkAdd)
+	}
+	return urls, nil
+}
+
+// AddItems adds the URLs to the library a few at a time and queues them for
+// fetching, returning the result of each in order. The first one added
+// becomes the active item, to start reading a backlog from its beginning.
+func (c *Core) AddItems(ctx context.Context, userID int64, urls []string, now time.Time) []AddResult {
+	results := make([]AddResult, len(urls))
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for range min(bulkAddWorkers, len(urls)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				// A second apart in the order of the list, as if added
+				// one by one.
+				addedAt := now.Add(time.Duration(i-len(urls)+1) * time.Second)
+				results[i] = c.addListedItem(ctx, userID, urls[i], addedAt)
+			}
+		}()
+	}
+	for i := range urls {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	for _, result := range results {
+		if result.Error != "" {
+			continue
+		}
+		err := c.queries.UsersSetActiveItem(ctx, db.UsersSetActiveItemParams{
+			ActiveItemID: result.ItemID,
+			ID:           userID,
+		})
+		if err != nil {
+			c.Logger.Warn("failed to set active item", "error", err, "userID", userID)
+		}
+		break
+	}
+
+	c.Logger.Info("added items", "userID", userID, "urls", len(urls))
+	return results
+}
+
+func (c *Core) addListedItem(ctx context.Context, userID int64, rawurl string, now time.Time) AddResult {
+	result := AddResult{URL: rawurl}
+	if err := ctx.Err(); err != nil {
+		result.Error = err.Error()
+		return result
+	}
+
+	_, err := c.queries.ItemsFindByUrl(ctx, db.ItemsFindByUrlParams{UserID: userID, Url: rawurl})
+	if err != nil && err != sql.ErrNoRows {
+		result.Error = fmt.Sprintf("failed to find item: %v", err)
+		return result
+	}
+	result.Existing = err == nil
+
+	result.ItemID, err = c.AddItem(ctx, userID, rawurl, now)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	if !result.Existing {
+		if err := c.enqueueFetch(ctx, result.ItemID, now); err != nil {
+			c.Logger.Warn("failed to queue item for fetching", "error", err, "itemID", result.ItemID)
+		}
+	}
+	return result
+}
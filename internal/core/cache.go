@@ -0,0 +1,256 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	badger "github.com/dgraph-io/badger/v4"
+)
+
+// CacheConfig is where the page cache is kept and how it's encrypted. Cached
+// pages can be fetched with a user's cookies, the encryption keeps them
+// unreadable on disk.
+type CacheConfig struct {
+	Path string
+	// EncryptionKey encrypts the cache when set, 16, 24 or 32 bytes for
+	// AES-128, 192 or 256.
+	EncryptionKey []byte
+	// KeyRotation is how often Badger rotates the data keys it encrypts with
+	// under EncryptionKey, Badger's default of 10 days when zero.
+	KeyRotation time.Duration
+}
+
+// indexCacheSize is required by Badger with encryption, it keeps the
+// decrypted indices in memory.
+const indexCacheSize = 64 << 20
+
+// OpenCache opens the page cache. A cache written with another key, or
+// without encryption, is emptied, which is also how the key is changed,
+// when its directory holds nothing but the cache.
+func OpenCache(config CacheConfig, logger *slog.Logger) (*badger.DB, error) {
+	opts := badger.DefaultOptions(config.Path)
+	if len(config.EncryptionKey) > 0 {
+		switch len(config.EncryptionKey) {
+		case 16, 24, 32:
+		default:
+			return nil, fmt.Errorf("cache encryption key must be 16, 24 or 32 bytes, not %d", len(config.EncryptionKey))
+		}
+		opts = opts.WithEncryptionKey(config.EncryptionKey).WithIndexCacheSize(indexCacheSize)
+		if config.KeyRotation > 0 {
+			opts = opts.WithEncryptionKeyRotationDuration(config.KeyRotation)
+		}
+	}
+
+	cache, err := badger.Open(opts)
+	if errors.Is(err, badger.ErrEncryptionKeyMismatch) {
+		if err := emptyCache(config.Path); err != nil {
+			return nil, err
+		}
+		logger.Warn("cache was written with another encryption key, emptied it", "path", config.Path)
+		cache, err = badger.Open(opts)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open cache: %w", err)
+	}
+	return cache, nil
+}
+
+// isBadgerFile tells whether the file is one Badger keeps a database in.
+func isBadgerFile(name string) bool {
+	switch name {
+	case "MANIFEST", "MANIFEST-REWRITE", "KEYREGISTRY", "KEYREGISTRY-REWRITE", "DISCARD", "LOCK":
+		return true
+	}
+	ext := filepath.Ext(name)
+	return ext == ".vlog" || ext == ".sst" || ext == ".mem"
+}
+
+// emptyCache removes Badger's files from the cache directory. CACHE_PATH
+// set to a directory holding anything else, the data directory say, is
+// left alone for the operator to point it elsewhere or clear it.
+func emptyCache(path string) error {
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		return fmt.Errorf("failed to empty cache: %w", err)
+	}
+	for _, entry := range entries {
+		if entry.IsDir() || !isBadgerFile(entry.Name()) {
+			return fmt.Errorf("cache at %s was written with another encryption key and holds %s, which isn't the cache's: clear the directory of the cache or set CACHE_PATH to an empty one", path, entry.Name())
+		}
+	}
+	for _, entry := range entries {
+		if err := os.Remove(filepath.Join(path, entry.Name())); err != nil {
+			return fmt.Errorf("failed to empty cache: %w", err)
+		}
+	}
+	return nil
+}
+
+// The cache holds the cleans of items under item:<url>, or item:<user>:<url>
+// when fetched with the user's cookies, archived copies under archive:<url>
+// and proxied images under img:<url>. Admins can purge what was cached of a
+// page or a site, a bad parse stays until its TTL otherwise.
+
+// CacheStats is the size of the cache on disk and how many entries of each
+// kind it holds.
+type CacheStats struct {
+	LSMBytes  int64          `json:"lsm_bytes"`
+	VlogBytes int64          `json:"vlog_bytes"`
+	Entries   map[string]int `json:"entries"`
+}
+
+// ErrNoCache is returned by the cache administration without a cache.
+var ErrNoCache = errors.New("the cache is off")
+
+// splitCacheKey splits a cache key into its kind, item, archive or img, and
+// the URL it holds.
+func splitCacheKey(key string) (kind, pageURL string) {
+	kind, pageURL, _ = strings.Cut(key, ":")
+	if kind == "item" {
+		if user, rest, ok := strings.Cut(pageURL, ":"); ok {
+			if _, err := strconv.ParseInt(user, 10, 64); err == nil {
+				pageURL = rest
+			}
+		}
+	}
+	return kind, pageURL
+}
+
+func (c *Core) CacheStats() (*CacheStats, error) {
+	if c.cache == nil {
+		return nil, ErrNoCache
+	}
+	lsm, vlog := c.cache.Size()
+	stats := &CacheStats{LSMBytes: lsm, VlogBytes: vlog, Entries: map[string]int{}}
+	err := c.cache.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.PrefetchValues = false
+		it := txn.NewIterator(opts)
+		defer it.Close()
+		for it.Rewind(); it.Valid(); it.Next() {
+			kind, _ := splitCacheKey(string(it.Item().Key()))
+			stats.Entries[kind]++
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to count cache entries: %w", err)
+	}
+	return stats, nil
+}
+
+// PurgeCacheURL drops what was cached of the page, for every user.
+func (c *Core) PurgeCacheURL(pageURL string) (int, error) {
+	return c.purgeCache(func(u string) bool { return u == pageURL })
+}
+
+// PurgeCachePrefix drops what was cached of the pages whose URLs start with
+// prefix.
+func (c *Core) PurgeCachePrefix(prefix string) (int, error) {
+	return c.purgeCache(func(u string) bool { return strings.HasPrefix(u, prefix) })
+}
+
+// PurgeCacheDomain drops what was cached of the pages of the domain and its
+// subdomains.
+func (c *Core) PurgeCacheDomain(domain string) (int, error) {
+	domain = normalizeDomain(domain)
+	return c.purgeCache(func(u string) bool {
+		parsed, err := url.Parse(u)
+		if err != nil {
+			return false
+		}
+		host := normalizeDomain(parsed.Hostname())
+		return host == domain || strings.HasSuffix(host, "."+domain)
+	})
+}
+
+func (c *Core) purgeCache(match func(pageURL string) bool) (int, error) {
+	if c.cache == nil {
+		return 0, ErrNoCache
+	}
+	var keys [][]byte
+	err := c.cache.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.PrefetchValues = false
+		it := txn.NewIterator(opts)
+		defer it.Close()
+		for it.Rewind(); it.Valid(); it.Next() {
+			if _, pageURL := splitCacheKey(string(it.Item().Key())); match(pageURL) {
+				keys = append(keys, it.Item().KeyCopy(nil))
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to list cache entries: %w", err)
+	}
+
+	batch := c.cache.NewWriteBatch()
+	defer batch.Cancel()
+	for _, key := range keys {
+		if err := batch.Delete(key); err != nil {
+			return 0, fmt.Errorf("failed to purge cache entry: %w", err)
+		}
+	}
+	if err := batch.Flush(); err != nil {
+		return 0, fmt.Errorf("failed to purge cache entries: %w", err)
+	}
+	c.Logger.Info("purged cache entries", "entries", len(keys))
+	return len(keys), nil
+}
+
+// cacheGCDiscardRatio is the share of a value log file that has to be
+// stale for Badger to rewrite it.
+const cacheGCDiscardRatio = 0.5
+
+// CollectCacheGarbage rewrites the value log files that are mostly expired
+// or purged entries, returning how many were.
+func (c *Core) CollectCacheGarbage() (int, error) {
+	if c.cache == nil {
+		return 0, ErrNoCache
+	}
+	rewritten := 0
+	for {
+		err := c.cache.RunValueLogGC(cacheGCDiscardRatio)
+		if errors.Is(err, badger.ErrNoRewrite) {
+			return rewritten, nil
+		}
+		if err != nil {
+			return rewritten, fmt.Errorf("failed to collect cache garbage: %w", err)
+		}
+		rewritten++
+	}
+}
+
+// RunCacheGC collects the garbage of the cache every interval until ctx is
+// cancelled. Expired pages take disk space until then.
+func (c *Core) RunCacheGC(ctx context.Context, interval time.Duration) {
+	if c.cache == nil {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			rewritten, err := c.CollectCacheGarbage()
+			if err != nil {
+				c.Logger.Warn("failed to collect cache garbage", "error", err)
+				continue
+			}
+			if rewritten > 0 {
+				c.Logger.Info("collected cache garbage", "files", rewritten)
+			}
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package core
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	db "github.com/egemengol/kindlepathy/internal/db/generated"
+)
+
+// Moving an item to the next page used to forget the page it was on. Every
+// page the item moves through is kept as a chapter of its history, and the
+// item can be moved back to any of them.
+
+var ErrChapterNotFound = errors.New("chapter not found")
+
+type ChapterVisit struct {
+	ID           int64
+	URL          string
+	Title        string
+	FirstVisited time.Time
+	LastVisited  time.Time
+	// Current is the page the item is on.
+	Current bool
+}
+
+func (c *Core) visitChapter(ctx context.Context, itemID int64, pageURL, title string, now time.Time) error {
+	err := c.queries.ChaptersVisit(ctx, db.ChaptersVisitParams{
+		ItemID:         itemID,
+		Url:            pageURL,
+		Title:          title,
+		FirstVisitedTs: now.Unix(),
+		VisitedTs:      now.Unix(),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to record chapter: %w", err)
+	}
+	return nil
+}
+
+// ChapterHistory returns the pages the item has been on, in the order they
+// were first read.
+func (c *Core) ChapterHistory(ctx context.Context, itemID int64) ([]ChapterVisit, error) {
+	item, err := c.queries.ItemsGet(ctx, itemID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get item: %w", err)
+	}
+	chapters, err := c.queries.ChaptersList(ctx, itemID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list chapters: %w", err)
+	}
+	history := make([]ChapterVisit, 0, len(chapters))
+	for _, ch := range chapters {
+		history = append(history, ChapterVisit{
+			ID:           ch.ID,
+			URL:          ch.Url,
+			Title:        ch.Title,
+			FirstVisited: time.Unix(ch.FirstVisitedTs, 0),
+			LastVisited:  time.Unix(ch.VisitedTs, 0),
+			Current:      ch.Url == item.Url,
+		})
+	}
+	return history, nil
+}
+
+// JumpToChapter moves the item back (or forward) to a page of its history.
+func (c *Core) JumpToChapter(ctx context.Context, itemID, chapterID int64, now time.Time) error {
+	ch, err := c.queries.ChaptersGet(ctx, db.ChaptersGetParams{ID: chapterID, ItemID: itemID})
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrChapterNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get chapter: %w", err)
+	}
+	if err := c.visitChapter(ctx, itemID, ch.Url, "", now); err != nil {
+		return err
+	}
+	err = c.queries.ItemsSetUrl(ctx, db.ItemsSetUrlParams{
+		Url: ch.Url,
+		ID:  itemID,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to update item: %w", err)
+	}
+	return nil
+}
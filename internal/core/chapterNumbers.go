@@ -0,0 +1,173 @@
+package core
+
+import (
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Chapter numbers are read from titles ("Ch. 12.5", "Chapter 2460: ...") and
+// URLs (".../chapter-2460/"), so a chapter list can be ordered by them, the
+// same chapter under two URLs recognized, and missing chapters noticed.
+
+var (
+	// titleChapterNumber matches a number labeled as a chapter, with a
+	// decimal part for the in-between chapters some serials have.
+	titleChapterNumber = regexp.MustCompile(`(?i)\b(?:chapter|chapitre|chap|ch)\b\.?\s*#?\s*(\d+(?:\.\d+)?)`)
+	// titleEpisodeNumber is the fallback for serials counting otherwise,
+	// after the chapter label so "Part 2, Chapter 5" is chapter 5.
+	titleEpisodeNumber = regexp.MustCompile(`(?i)\b(?:episode|ep|part)\b\.?\s*#?\s*(\d+(?:\.\d+)?)`)
+	// titleLeadingNumber matches titles that are only numbered, "12" or
+	// "12 - The Title".
+	titleLeadingNumber = regexp.MustCompile(`^\W*(\d+(?:\.\d+)?)\s*(?:$|[-–—:.)]\s)`)
+	// urlChapterDecimal matches a path segment ending in a labeled number
+	// with a decimal part, "chapter-12-5" is 12.5.
+	urlChapterDecimal = regexp.MustCompile(`(?i)(?:^|[^a-z])(?:chapter|chapitre|chap|ch|episode|ep|c)[-_]?(\d+)[-_.](\d{1,2})(?:\.html?)?$`)
+	// urlChapterNumber matches a labeled number in a path segment, a slug
+	// may follow.
+	urlChapterNumber = regexp.MustCompile(`(?i)(?:^|[^a-z])(?:chapter|chapitre|chap|ch|episode|ep|c)[-_]?(\d+)(?:$|[^0-9])`)
+)
+
+// parseChapterNumber reads the chapter number from the title, or else the
+// URL.
+func parseChapterNumber(rawURL, title string) (float64, bool) {
+	title = strings.TrimSpace(title)
+	for _, re := range []*regexp.Regexp{titleChapterNumber, titleEpisodeNumber, titleLeadingNumber} {
+		if m := re.FindStringSubmatch(title); m != nil {
+			return parseNumber(m[1])
+		}
+	}
+
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return 0, false
+	}
+	segments := strings.Split(strings.Trim(u.Path, "/"), "/")
+	// The last segments name the chapter, earlier ones the series.
+	for i := len(segments) - 1; i >= 0; i-- {
+		if m := urlChapterDecimal.FindStringSubmatch(segments[i]); m != nil {
+			return parseNumber(m[1] + "." + m[2])
+		}
+		if m := urlChapterNumber.FindStringSubmatch(segments[i]); m != nil {
+			return parseNumber(m[1])
+		}
+	}
+	return 0, false
+}
+
+func parseNumber(s string) (float64, bool) {
+	n, err := strconv.ParseFloat(s, 64)
+	return n, err == nil
+}
+
+// orderChapters puts the list in reading order. Numbered lists are sorted by
+// number, dropping repeated numbers, which are the same chapter linked twice;
+// others are only turned around when they read newest first.
+func orderChapters(chapters []Chapter) []Chapter {
+	numbered := 0
+	for i := range chapters {
+		if n, ok := parseChapterNumber(chapters[i].URL, chapters[i].Title); ok {
+			chapters[i].Number = n
+			numbered++
+		}
+	}
+
+	// A few unnumbered entries, a prologue or an afterword, keep their
+	// place relative to their neighbours.
+	if numbered*5 < len(chapters)*4 {
+		first, last := chapters[0].Number, chapters[len(chapters)-1].Number
+		if first > last && last > 0 {
+			reverseChapters(chapters)
+		}
+		return chapters
+	}
+	if descending(chapters) {
+		reverseChapters(chapters)
+	}
+	// Unnumbered entries sort with the chapter before them, a prologue
+	// stays first.
+	type keyed struct {
+		key     float64
+		chapter Chapter
+	}
+	sorted := make([]keyed, len(chapters))
+	var key float64
+	for i, ch := range chapters {
+		if ch.Number != 0 {
+			key = ch.Number
+		}
+		sorted[i] = keyed{key, ch}
+	}
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].key < sorted[j].key })
+
+	seen := map[float64]bool{}
+	ordered := chapters[:0]
+	for _, k := range sorted {
+		ch := k.chapter
+		if ch.Number != 0 && seen[ch.Number] {
+			continue
+		}
+		seen[ch.Number] = true
+		ordered = append(ordered, ch)
+	}
+	return ordered
+}
+
+// descending tells whether the numbered chapters mostly go down.
+func descending(chapters []Chapter) bool {
+	down, up := 0, 0
+	var prev float64
+	for _, ch := range chapters {
+		if ch.Number == 0 {
+			continue
+		}
+		if prev != 0 {
+			if ch.Number < prev {
+				down++
+			} else if ch.Number > prev {
+				up++
+			}
+		}
+		prev = ch.Number
+	}
+	return down > up
+}
+
+func reverseChapters(chapters []Chapter) {
+	for i, j := 0, len(chapters)-1; i < j; i, j = i+1, j-1 {
+		chapters[i], chapters[j] = chapters[j], chapters[i]
+	}
+}
+
+// chapterGaps returns the whole chapter numbers missing between the first
+// and the last numbered chapter.
+func chapterGaps(chapters []Chapter) []int {
+	have := map[int]bool{}
+	lowest, highest := 0, 0
+	for _, ch := range chapters {
+		if ch.Number == 0 {
+			continue
+		}
+		n := int(ch.Number)
+		have[n] = true
+		if lowest == 0 || n < lowest {
+			lowest = n
+		}
+		if n > highest {
+			highest = n
+		}
+	}
+	// Numbers far apart are IDs rather than a sequence.
+	if highest-lowest > 10*len(chapters) {
+		return nil
+	}
+	var gaps []int
+	for n := lowest + 1; n < highest; n++ {
+		if !have[n] {
+			gaps = append(gaps, n)
+		}
+	}
+	return gaps
+}
@@ -0,0 +1,418 @@
+package core
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+	db "github.com/egemengol/kindlepathy/internal/db/generated"
+)
+
+// Serial items, the ones with nav links, get their chapter list from the
+// series' table of contents: either a list on the chapter page itself, like
+// a chapter dropdown, or a page it links to as the contents. Knowing the
+// list, the reader tells how many chapters are left, and any chapter can be
+// picked from it. An item saved at the table of contents itself, a page
+// mostly of numbered chapter links in sequence, gets the list too.
+
+type Chapter struct {
+	URL   string `json:"url"`
+	Title string `json:"title"`
+	// Number is the chapter number read from the title or URL, zero if
+	// there's none.
+	Number float64 `json:"number,omitempty"`
+}
+
+// ChapterProgress is where the item's current URL is in its chapter list.
+type ChapterProgress struct {
+	// Number is the 1-based position of the current chapter.
+	Number    int `json:"number"`
+	Total     int `json:"total"`
+	Remaining int `json:"remaining"`
+	// RemainingMinutes estimates the reading time of the remaining
+	// chapters from the current one, zero if unknown.
+	RemainingMinutes int `json:"remaining_minutes"`
+	// ChapterNumber is the series' own number for the current chapter,
+	// zero if it has none.
+	ChapterNumber float64 `json:"chapter_number,omitempty"`
+}
+
+const (
+	// chapterListTTL is how often the list is fetched again for new
+	// chapters.
+	chapterListTTL = 24 * time.Hour
+	// chapterListMissTTL is how soon the list is fetched again when the
+	// current chapter isn't on it, it may be brand new.
+	chapterListMissTTL = time.Hour
+	// minChapters keeps menus and related-post lists from passing for a
+	// chapter list.
+	minChapters = 3
+	// minIndexChapters is how many chapters a page that isn't one of them
+	// lists to pass for the series' table of contents.
+	minIndexChapters = 10
+)
+
+var (
+	tocLinkText     = regexp.MustCompile(`(?i)^\W*(table of contents|contents|toc|index|chapter list|chapters|all chapters|chapter index)\W*$`)
+	chapterLinkText = regexp.MustCompile(`(?i)\b(chapter|chap|ch|episode|ep|part|vol|volume)\b\.?\s*\d+|^\W*\d+\W*$|^\W*\d+\s*[-:.]`)
+)
+
+// ReadChapterProgress finds the item's current chapter in its chapter list,
+// nil if the list isn't known (yet). A refresh of the list is queued when
+// it's missing or stale, for serial content.
+func (c *Core) ReadChapterProgress(ctx context.Context, itemID int64, clean *Clean, now time.Time) (*ChapterProgress, error) {
+	item, err := c.queries.ItemsGet(ctx, itemID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get item: %w", err)
+	}
+	list, err := c.queries.ChapterListsGet(ctx, itemID)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("failed to get chapter list: %w", err)
+	}
+	known := err == nil
+
+	serial := clean.NavNext != "" || clean.NavPrev != "" || clean.ChapterIndex
+	var progress *ChapterProgress
+	age := now.Sub(time.Unix(list.FetchedTs, 0))
+	if known {
+		minutes := int64(EstimateReadingMinutes(clean.ContentHTML))
+		if minutes > 0 && minutes != list.ChapterMinutes {
+			err := c.queries.ChapterListsSetMinutes(ctx, db.ChapterListsSetMinutesParams{ChapterMinutes: minutes, ItemID: itemID})
+			if err != nil {
+				c.Logger.Warn("failed to store chapter reading time", "error", err, "itemID", itemID)
+			}
+			list.ChapterMinutes = minutes
+		}
+		progress = chapterProgress(list, item.Url)
+	}
+	// The table of contents isn't one of its chapters.
+	missing := progress == nil && !clean.ChapterIndex
+	stale := !known || age > chapterListTTL || (missing && age > chapterListMissTTL)
+	// The chapters of books are known from the start.
+	if serial && stale && !isBookURL(item.Url) {
+		job := chapterListJob{ItemID: itemID, Minutes: EstimateReadingMinutes(clean.ContentHTML)}
+		if err := c.enqueueJob(ctx, JobChapterList, job, now); err != nil {
+			c.Logger.Warn("failed to queue chapter list refresh", "error", err, "itemID", itemID)
+		}
+	}
+	return progress, nil
+}
+
+// addChapterProgress fills in the chapter progress of the user's items.
+func (c *Core) addChapterProgress(ctx context.Context, userID int64, items []Item) error {
+	lists, err := c.queries.ChapterListsListPerUser(ctx, userID)
+	if err != nil {
+		return fmt.Errorf("failed to list chapter lists: %w", err)
+	}
+	byItem := make(map[int64]db.ChapterList, len(lists))
+	for _, list := range lists {
+		byItem[list.ItemID] = list
+	}
+	for i := range items {
+		if list, ok := byItem[items[i].ID]; ok {
+			items[i].Chapters = chapterProgress(list, items[i].URL)
+		}
+	}
+	return nil
+}
+
+func chapterProgress(list db.ChapterList, currentURL string) *ChapterProgress {
+	var chapters []Chapter
+	if err := json.Unmarshal([]byte(list.Chapters), &chapters); err != nil {
+		return nil
+	}
+	at := chapterAt(chapters, currentURL)
+	if at < 0 {
+		return nil
+	}
+	remaining := len(chapters) - at - 1
+	return &ChapterProgress{
+		Number:           at + 1,
+		Total:            len(chapters),
+		Remaining:        remaining,
+		RemainingMinutes: remaining * int(list.ChapterMinutes),
+		ChapterNumber:    chapters[at].Number,
+	}
+}
+
+// chapterAt returns the position of the chapter at the URL in the list, -1
+// if it isn't on it.
+func chapterAt(chapters []Chapter, currentURL string) int {
+	current := chapterKey(currentURL)
+	for i, ch := range chapters {
+		if chapterKey(ch.URL) == current {
+			return i
+		}
+	}
+	// The chapter may be linked under another URL, a numbered one is
+	// still found by its number.
+	if number, ok := parseChapterNumber(currentURL, ""); ok {
+		for i, ch := range chapters {
+			if ch.Number == number {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// TableOfContents is the chapter list of an item.
+type TableOfContents struct {
+	// URL is the page the list was found on.
+	URL      string
+	Chapters []Chapter
+	// Current is the position of the chapter being read, -1 when it
+	// isn't on the list.
+	Current int
+}
+
+// ItemTableOfContents returns the chapter list of the item, nil if it
+// isn't known or has no chapters.
+func (c *Core) ItemTableOfContents(ctx context.Context, itemID int64) (*TableOfContents, error) {
+	item, err := c.queries.ItemsGet(ctx, itemID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get item: %w", err)
+	}
+	list, err := c.queries.ChapterListsGet(ctx, itemID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get chapter list: %w", err)
+	}
+	var chapters []Chapter
+	if err := json.Unmarshal([]byte(list.Chapters), &chapters); err != nil {
+		return nil, fmt.Errorf("failed to decode chapter list: %w", err)
+	}
+	if len(chapters) == 0 {
+		return nil, nil
+	}
+	return &TableOfContents{URL: list.TocUrl, Chapters: chapters, Current: chapterAt(chapters, item.Url)}, nil
+}
+
+// JumpToListedChapter moves the item to a chapter of its chapter list.
+func (c *Core) JumpToListedChapter(ctx context.Context, itemID int64, chapterURL string) error {
+	toc, err := c.ItemTableOfContents(ctx, itemID)
+	if err != nil {
+		return err
+	}
+	if toc == nil || !containsChapter(toc.Chapters, chapterURL) {
+		return ErrChapterNotFound
+	}
+	return c.NavigateItem(ctx, itemID, chapterURL)
+}
+
+// chapterKey is the URL as compared between the list and the item, sites
+// are loose about schemes, www and trailing slashes.
+func chapterKey(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return rawURL
+	}
+	host := strings.TrimPrefix(strings.ToLower(u.Host), "www.")
+	key := host + strings.TrimSuffix(u.Path, "/")
+	if u.RawQuery != "" {
+		key += "?" + u.RawQuery
+	}
+	return key
+}
+
+// chapterListJob looks for the chapter list of a serial. Minutes is the
+// reading time of the chapter being read.
+type chapterListJob struct {
+	ItemID  int64 `json:"item_id"`
+	Minutes int   `json:"minutes"`
+}
+
+// refreshChapterList looks for the chapter list of the item from its page
+// and stores it. Failing to find one isn't an error, the empty list is
+// stored so it isn't looked for on every read.
+func (c *Core) refreshChapterList(ctx context.Context, itemID int64, minutes int) error {
+	item, err := c.queries.ItemsGet(ctx, itemID)
+	if err != nil {
+		return fmt.Errorf("failed to get item: %w", err)
+	}
+
+	tocURL, chapters, err := c.findChapterList(ctx, item.Url)
+	if err != nil {
+		c.Logger.Warn("failed to find chapter list", "error", err, "itemID", itemID)
+	}
+	chaptersJSON, err := json.Marshal(chapters)
+	if err != nil {
+		return fmt.Errorf("failed to encode chapter list: %w", err)
+	}
+	err = c.queries.ChapterListsSet(ctx, db.ChapterListsSetParams{
+		ItemID:    itemID,
+		TocUrl:    tocURL,
+		Chapters:  string(chaptersJSON),
+		FetchedTs: time.Now().Unix(),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to store chapter list: %w", err)
+	}
+	if minutes > 0 {
+		err := c.queries.ChapterListsSetMinutes(ctx, db.ChapterListsSetMinutesParams{ChapterMinutes: int64(minutes), ItemID: itemID})
+		if err != nil {
+			c.Logger.Warn("failed to store chapter reading time", "error", err, "itemID", itemID)
+		}
+	}
+	c.Logger.Info("found chapter list", "itemID", itemID, "toc", tocURL, "chapters", len(chapters))
+	if gaps := chapterGaps(chapters); len(gaps) > 0 {
+		c.Logger.Info("chapter list has gaps", "itemID", itemID, "missing", gaps)
+	}
+	return nil
+}
+
+// findChapterList looks for the chapter list on the page, then on the table
+// of contents it links to.
+func (c *Core) findChapterList(ctx context.Context, pageURL string) (string, []Chapter, error) {
+	body, err := c.fetch(ctx, pageURL)
+	if err != nil {
+		return "", nil, err
+	}
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(body))
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to parse page: %w", err)
+	}
+	if chapters := extractChapters(doc, pageURL); containsChapter(chapters, pageURL) || isSeriesIndex(chapters) {
+		return pageURL, chapters, nil
+	}
+
+	tocURL := findTOCLink(doc, pageURL)
+	if tocURL == "" {
+		return "", nil, nil
+	}
+	body, err = c.fetch(ctx, tocURL)
+	if err != nil {
+		return tocURL, nil, err
+	}
+	doc, err = goquery.NewDocumentFromReader(strings.NewReader(body))
+	if err != nil {
+		return tocURL, nil, fmt.Errorf("failed to parse table of contents: %w", err)
+	}
+	return tocURL, extractChapters(doc, tocURL), nil
+}
+
+func containsChapter(chapters []Chapter, pageURL string) bool {
+	key := chapterKey(pageURL)
+	for _, ch := range chapters {
+		if chapterKey(ch.URL) == key {
+			return true
+		}
+	}
+	return false
+}
+
+// isSeriesIndex tells whether the chapters listed on a page that isn't one
+// of them make it the table of contents of the series: many of them, most
+// numbered one after the other.
+func isSeriesIndex(chapters []Chapter) bool {
+	if len(chapters) < minIndexChapters {
+		return false
+	}
+	sequential := 0
+	for i := 1; i < len(chapters); i++ {
+		if n := chapters[i].Number; n != 0 && n == chapters[i-1].Number+1 {
+			sequential++
+		}
+	}
+	return sequential*2 >= len(chapters)
+}
+
+// seriesIndexPage tells whether the page is the table of contents of a
+// series.
+func seriesIndexPage(body, pageURL string) bool {
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(body))
+	if err != nil {
+		return false
+	}
+	chapters := extractChapters(doc, pageURL)
+	return !containsChapter(chapters, pageURL) && isSeriesIndex(chapters)
+}
+
+// findTOCLink finds the link to the table of contents on a chapter page.
+func findTOCLink(doc *goquery.Document, pageURL string) string {
+	var found string
+	doc.Find(`a[rel~="contents"], a[rel~="index"], link[rel~="contents"]`).EachWithBreak(func(_ int, s *goquery.Selection) bool {
+		found = sameHostURL(s.AttrOr("href", ""), pageURL)
+		return found == ""
+	})
+	if found != "" {
+		return found
+	}
+	doc.Find("a[href]").EachWithBreak(func(_ int, s *goquery.Selection) bool {
+		text := strings.TrimSpace(s.Text())
+		if text == "" {
+			text = s.AttrOr("title", "")
+		}
+		if tocLinkText.MatchString(text) {
+			found = sameHostURL(s.AttrOr("href", ""), pageURL)
+		}
+		return found == ""
+	})
+	return found
+}
+
+// extractChapters picks the list on the page with the most chapter-like
+// links to the same site, in reading order.
+func extractChapters(doc *goquery.Document, pageURL string) []Chapter {
+	var best []Chapter
+	doc.Find("ul, ol, table, select, dl").Each(func(_ int, list *goquery.Selection) {
+		var chapters []Chapter
+		seen := map[string]bool{}
+		add := func(href, title string) {
+			title = strings.Join(strings.Fields(title), " ")
+			u := sameHostURL(href, pageURL)
+			if u == "" || !chapterLinkText.MatchString(title) && !chapterLinkText.MatchString(u) {
+				return
+			}
+			if key := chapterKey(u); !seen[key] {
+				seen[key] = true
+				chapters = append(chapters, Chapter{URL: u, Title: title})
+			}
+		}
+		if goquery.NodeName(list) == "select" {
+			list.Find("option[value]").Each(func(_ int, o *goquery.Selection) {
+				add(o.AttrOr("value", ""), o.Text())
+			})
+		} else {
+			list.Find("a[href]").Each(func(_ int, a *goquery.Selection) {
+				add(a.AttrOr("href", ""), a.Text())
+			})
+		}
+		// Nested lists count for their parent too, the innermost one with
+		// as many chapters wins.
+		if len(chapters) > len(best) {
+			best = chapters
+		}
+	})
+	if len(best) < minChapters {
+		return nil
+	}
+	return orderChapters(best)
+}
+
+func sameHostURL(href, pageURL string) string {
+	href = strings.TrimSpace(href)
+	if href == "" || strings.HasPrefix(href, "#") || strings.HasPrefix(strings.ToLower(href), "javascript:") {
+		return ""
+	}
+	resolved, err := ResolveURL(pageURL, href)
+	if err != nil {
+		return ""
+	}
+	u, err := url.Parse(resolved)
+	base, baseErr := url.Parse(pageURL)
+	if err != nil || baseErr != nil || !strings.EqualFold(strings.TrimPrefix(u.Host, "www."), strings.TrimPrefix(base.Host, "www.")) {
+		return ""
+	}
+	u.Fragment = ""
+	return u.String()
+}
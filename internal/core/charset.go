@@ -0,0 +1,157 @@
+package core
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/net/html/charset"
+)
+
+// Pages and mail are read in the charset they declare, by the labels
+// browsers know. Many older sites declare none and serve GBK, Shift_JIS or
+// Windows-1251, their charset is guessed from their bytes: which of the
+// multi-byte charsets they're mostly the common characters of, else
+// whether their letters read as Cyrillic words.
+
+// multiByteMinScore is the share of the characters that must be common
+// ones for a page to be read in a multi-byte charset.
+const multiByteMinScore = 0.5
+
+var multiByteCharsets = []struct {
+	name  string
+	score func([]byte) float64
+}{
+	{"gbk", gbkScore},
+	{"shift_jis", shiftJISScore},
+}
+
+// decodeCharset returns data in charset as UTF-8, guessing the charset
+// when it's unknown.
+func decodeCharset(label string, data []byte) string {
+	enc, _ := charset.Lookup(label)
+	if enc == nil {
+		enc, _ = charset.Lookup(detectCharset(data))
+	}
+	text, err := enc.NewDecoder().Bytes(data)
+	if err != nil {
+		return strings.ToValidUTF8(string(data), "�")
+	}
+	return string(text)
+}
+
+// charsetReader reads the encoded words of mail headers.
+func charsetReader(label string, input io.Reader) (io.Reader, error) {
+	data, err := io.ReadAll(input)
+	if err != nil {
+		return nil, err
+	}
+	return bytes.NewReader([]byte(decodeCharset(label, data))), nil
+}
+
+// detectCharset guesses the charset of text that declares none.
+func detectCharset(data []byte) string {
+	if utf8.Valid(data) {
+		return "utf-8"
+	}
+	best, bestScore := "", 0.0
+	for _, c := range multiByteCharsets {
+		if score := c.score(data); score > bestScore {
+			best, bestScore = c.name, score
+		}
+	}
+	if bestScore >= multiByteMinScore {
+		return best
+	}
+	if cyrillicScore(data) >= 0.5 {
+		return "windows-1251"
+	}
+	return "windows-1252"
+}
+
+// gbkScore is the share of the characters of data in GBK that are in
+// GB2312's punctuation rows or its 3755 most used characters.
+func gbkScore(data []byte) float64 {
+	var chars, common int
+	for i := 0; i < len(data); i++ {
+		lead := data[i]
+		if lead < 0x80 {
+			continue
+		}
+		chars++
+		if lead == 0x80 || lead == 0xFF || i+1 == len(data) {
+			continue
+		}
+		trail := data[i+1]
+		if trail < 0x40 || trail == 0x7F || trail == 0xFF {
+			continue
+		}
+		i++
+		if trail >= 0xA1 && (lead >= 0xA1 && lead <= 0xA3 || lead >= 0xB0 && lead <= 0xD7) {
+			common++
+		}
+	}
+	return share(common, chars)
+}
+
+// shiftJISScore is the share of the characters of data in Shift_JIS that
+// are punctuation, kana or the first level of kanji.
+func shiftJISScore(data []byte) float64 {
+	var chars, common int
+	for i := 0; i < len(data); i++ {
+		lead := data[i]
+		if lead < 0x80 {
+			continue
+		}
+		chars++
+		// Half-width katakana are a byte each, and rare in pages.
+		if lead >= 0xA1 && lead <= 0xDF {
+			continue
+		}
+		if !(lead >= 0x81 && lead <= 0x9F || lead >= 0xE0 && lead <= 0xFC) || i+1 == len(data) {
+			continue
+		}
+		trail := data[i+1]
+		if trail < 0x40 || trail == 0x7F || trail > 0xFC {
+			continue
+		}
+		i++
+		if lead <= 0x83 || lead >= 0x88 && lead <= 0x98 {
+			common++
+		}
+	}
+	return share(common, chars)
+}
+
+// cyrillicScore is the share of the letters of data in Windows-1251 that
+// are in words of three letters or more. Cyrillic words are all high
+// bytes, accented Latin letters come one at a time.
+func cyrillicScore(data []byte) float64 {
+	isLetter := func(b byte) bool { return b >= 0xC0 || b == 0xA8 || b == 0xB8 }
+	var letters, inWords, run int
+	for i, b := range data {
+		if !isLetter(b) {
+			continue
+		}
+		letters++
+		if i > 0 && isLetter(data[i-1]) {
+			run++
+		} else {
+			run = 1
+		}
+		if run == 3 {
+			inWords += 3
+		} else if run > 3 {
+			inWords++
+		}
+	}
+	return share(inWords, letters)
+}
+
+func share(n, of int) float64 {
+	if of == 0 {
+		return 0
+	}
+	return float64(n) / float64(of)
+}
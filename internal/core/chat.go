@@ -0,0 +1,129 @@
+package core
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	db "github.com/egemengol/kindlepathy/internal/db/generated"
+)
+
+const linkCodeTTL = 15 * time.Minute
+
+// ErrChatNotLinked is returned when a chat has not been linked to a user yet.
+var ErrChatNotLinked = fmt.Errorf("chat is not linked to an account")
+
+type ChatLink struct {
+	Platform string
+	ChatID   string
+	Linked   time.Time
+}
+
+// CreateLinkCode returns a short lived code the user sends to a chat bot to
+// link the chat to their account.
+func (c *Core) CreateLinkCode(ctx context.Context, userID int64, now time.Time) (string, error) {
+	buf := make([]byte, 4)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("failed to generate code: %w", err)
+	}
+	code := hex.EncodeToString(buf)
+	err := c.queries.LinkCodesAdd(ctx, db.LinkCodesAddParams{
+		Code:      code,
+		UserID:    userID,
+		ExpiresTs: now.Add(linkCodeTTL).Unix(),
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to store code: %w", err)
+	}
+	return code, nil
+}
+
+// LinkChat consumes a link code and links the chat to the code's owner.
+func (c *Core) LinkChat(ctx context.Context, platform, chatID, code string, now time.Time) (int64, error) {
+	userID, err := c.queries.LinkCodesConsume(ctx, db.LinkCodesConsumeParams{
+		Code:      code,
+		ExpiresTs: now.Unix(),
+	})
+	if err == sql.ErrNoRows {
+		return 0, fmt.Errorf("invalid or expired code")
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to consume code: %w", err)
+	}
+
+	err = c.queries.ChatLinksUpsert(ctx, db.ChatLinksUpsertParams{
+		Platform: platform,
+		ChatID:   chatID,
+		UserID:   userID,
+		LinkedTs: now.Unix(),
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to link chat: %w", err)
+	}
+	return userID, nil
+}
+
+// ChatUser returns the user a chat is linked to, or ErrChatNotLinked.
+func (c *Core) ChatUser(ctx context.Context, platform, chatID string) (int64, error) {
+	userID, err := c.queries.ChatLinksGetUser(ctx, db.ChatLinksGetUserParams{
+		Platform: platform,
+		ChatID:   chatID,
+	})
+	if err == sql.ErrNoRows {
+		return 0, ErrChatNotLinked
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to get chat link: %w", err)
+	}
+	return userID, nil
+}
+
+func (c *Core) ListChatLinks(ctx context.Context, userID int64) ([]ChatLink, error) {
+	rows, err := c.queries.ChatLinksListPerUser(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list chat links: %w", err)
+	}
+	links := make([]ChatLink, len(rows))
+	for i, row := range rows {
+		links[i] = ChatLink{
+			Platform: row.Platform,
+			ChatID:   row.ChatID,
+			Linked:   time.Unix(row.LinkedTs, 0),
+		}
+	}
+	return links, nil
+}
+
+func (c *Core) UnlinkChat(ctx context.Context, userID int64, platform, chatID string) error {
+	return c.queries.ChatLinksDelete(ctx, db.ChatLinksDeleteParams{
+		Platform: platform,
+		ChatID:   chatID,
+		UserID:   userID,
+	})
+}
+
+// AddItemFromChat adds every URL in a chat message to the linked user's
+// library, making the last one active.
+func (c *Core) AddItemFromChat(ctx context.Context, platform, chatID, text string, now time.Time) ([]*ItemSummary, error) {
+	userID, err := c.ChatUser(ctx, platform, chatID)
+	if err != nil {
+		return nil, err
+	}
+
+	var summaries []*ItemSummary
+	for _, rawurl := range ExtractURLs(text) {
+		itemID, err := c.AddItemWithTitleSetActive(ctx, userID, rawurl, now)
+		if err != nil {
+			return summaries, fmt.Errorf("failed to add %s: %w", rawurl, err)
+		}
+		summary, err := c.GetItemSummary(ctx, itemID)
+		if err != nil {
+			return summaries, err
+		}
+		summaries = append(summaries, summary)
+	}
+	return summaries, nil
+}
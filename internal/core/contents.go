@@ -0,0 +1,70 @@
+package core
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	db "github.com/egemengol/kindlepathy/internal/db/generated"
+)
+
+// Uploaded content is stored once per URL and HTML, in the contents table,
+// rather than on each item. When many users of an instance save the same
+// page through the extension or an import, its content is kept once and
+// their items refer to it by its hash. Fetched pages are shared through the
+// cache already, unless fetched with a user's cookies.
+
+// contentHash is the key of the content of a page, the hex SHA-256 of its
+// URL and its sanitized HTML.
+func contentHash(url, html string) string {
+	h := sha256.New()
+	h.Write([]byte(url))
+	h.Write([]byte{0})
+	h.Write([]byte(html))
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// storeContent stores the sanitized HTML of a page, unless it's stored
+// already, and returns its hash.
+func (c *Core) storeContent(ctx context.Context, url, html string, now time.Time) (string, error) {
+	hash := contentHash(url, html)
+	compressed, err := CompressHTML(html)
+	if err != nil {
+		return "", fmt.Errorf("failed to compress content: %w", err)
+	}
+	err = c.queries.ContentsAdd(ctx, db.ContentsAddParams{
+		Hash:       hash,
+		Url:        url,
+		HtmlBrotli: compressed,
+		CreatedTs:  now.Unix(),
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to store content: %w", err)
+	}
+	return hash, nil
+}
+
+// uploadedContent returns the uploaded content of the item, from the
+// contents table or, for items uploaded before it, from the item itself.
+// ok is false when the item has none.
+func (c *Core) uploadedContent(ctx context.Context, item db.Item) (html string, ok bool, err error) {
+	var compressed []byte
+	switch {
+	case item.ContentHash != nil:
+		compressed, err = c.queries.ContentsGet(ctx, item.ContentHash.(string))
+		if err != nil {
+			return "", true, fmt.Errorf("failed to get content: %w", err)
+		}
+	case item.UploadedHtmlBrotli != nil:
+		compressed = item.UploadedHtmlBrotli.([]byte)
+	default:
+		return "", false, nil
+	}
+	html, err = DecompressHTML(compressed)
+	if err != nil {
+		return "", true, fmt.Errorf("failed to decompress uploaded content: %w", err)
+	}
+	return html, true, nil
+}
@@ -5,10 +5,12 @@ import (
 	"database/sql"
 	"encoding/json"
 	"fmt"
-	"io"
 	"log/slog"
 	"net/http"
 	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	badger "github.com/dgraph-io/badger/v4"
@@ -17,17 +19,51 @@ import (
 
 type Core struct {
 	httpClient        *http.Client
-	readabilityClient *ReadabilityClient
+	readabilityClient Readability
 	queries           *db.Queries
 	Logger            *slog.Logger
 	cache             *badger.DB
+	smtp              *SMTPConfig
+	// inboundDomain is the domain of the users' addresses for mail to
+	// their library, empty when mail isn't received.
+	inboundDomain string
+	// publicURL is the URL the server is reached at, for the links of the
+	// mail it sends. Empty when it isn't configured.
+	publicURL         string
+	flags             flagCache
+	pipelines         atomic.Pointer[Pipelines]
+	compareExtractors atomic.Bool
+	// fetchWake and jobWake tell the fetch and job queues there's new
+	// work.
+	fetchWake     chan struct{}
+	jobWake       chan struct{}
+	screenshotter *Screenshotter
+	// latestRelease is the latest release found by the update check.
+	latestRelease atomic.Pointer[Release]
+	// fetchHeaders are set on every fetch, the defaults when nil.
+	fetchHeaders http.Header
+	renderer     *Renderer
+	// cacheTTLs are how long the cleans of a domain stay fresh, and
+	// revalidating the cache keys being refreshed.
+	cacheTTLs    map[string]time.Duration
+	revalidating sync.Map
+	// fetchMaxBytes is the largest page fetched, 0 for no limit.
+	fetchMaxBytes int64
+	// fetchPolicy is how politely pages are fetched, fetchHosts what's
+	// known of the hosts they're fetched from.
+	fetchPolicy  FetchPolicy
+	fetchHostsMu sync.Mutex
+	fetchHosts   map[string]*fetchHost
+	// fetchRetries is how failed fetches are retried.
+	fetchRetries FetchRetries
 }
 
 func NewCore(httpClient *http.Client,
-	readabilityClient *ReadabilityClient,
+	readabilityClient Readability,
 	queries *db.Queries,
 	logger *slog.Logger,
 	cache *badger.DB,
+	smtp *SMTPConfig,
 ) *Core {
 	return &Core{
 		httpClient:        httpClient,
@@ -35,6 +71,11 @@ func NewCore(httpClient *http.Client,
 		queries:           queries,
 		Logger:            logger,
 		cache:             cache,
+		smtp:              smtp,
+		fetchWake:         make(chan struct{}, 1),
+		jobWake:           make(chan struct{}, 1),
+		fetchMaxBytes:     DefaultFetchMaxBytes,
+		fetchRetries:      DefaultFetchRetries,
 	}
 }
 
@@ -44,7 +85,7 @@ func (c *Core) AddItem(ctx context.Context, userID int64, rawurl string, now tim
 	}
 	u, err := url.Parse(rawurl)
 	if err != nil || u.Scheme == "" || u.Host == "" {
-		return 0, fmt.Errorf("invalid url: %w", err)
+		return 0, fmt.Errorf("invalid url: %q", rawurl)
 	}
 	return c.queries.ItemsAdd(ctx, db.ItemsAddParams{
 		UserID:  userID,
@@ -53,32 +94,39 @@ func (c *Core) AddItem(ctx context.Context, userID int64, rawurl string, now tim
 	})
 }
 
+// AddItemWithTitleSetActive adds the item as the active one and queues it
+// for fetching, the title is filled in once it's fetched.
 func (c *Core) AddItemWithTitleSetActive(ctx context.Context, userID int64, rawurl string, now time.Time) (int64, error) {
-	// First add the item
 	itemID, err := c.AddItem(ctx, userID, rawurl, now)
 	if err != nil {
 		return 0, fmt.Errorf("failed to add item: %w", err)
 	}
 
-	// Get and clean the content to extract the title
-	clean, err := c.getAndCleanCached(ctx, rawurl, "item", 10*time.Minute)
-	if err != nil {
-		c.Logger.Warn("failed to clean document for title extraction", "error", err, "url", rawurl)
-		// Return the item ID even if cleaning fails
-		return itemID, nil
+	if err := c.enqueueFetch(ctx, itemID, now); err != nil {
+		c.Logger.Warn("failed to queue item for fetching", "error", err, "itemID", itemID)
 	}
 
-	// Update the title
-	_, err = c.queries.ItemsUpdateTitle(ctx, db.ItemsUpdateTitleParams{
-		Title: clean.Title,
-		ID:    itemID,
+	err = c.queries.UsersSetActiveItem(ctx, db.UsersSetActiveItemParams{
+		ActiveItemID: itemID,
+		ID:           userID,
 	})
 	if err != nil {
-		c.Logger.Warn("failed to update item title", "error", err, "itemID", itemID)
-		// Return the item ID even if title update fails
-		return itemID, nil
+		c.Logger.Warn("failed to set active item", "error", err, "userID", userID)
+	}
+
+	return itemID, nil
+}
+
+// AddItemWithUploadedContent adds an item with pre-processed uploaded content.
+// The user's item of the same URL, if any, gets the new title and content
+// instead, created reports which happened.
+func (c *Core) AddItemWithUploadedContent(ctx context.Context, userID int64, title, rawurl, htmlContent string, now time.Time) (itemID int64, created bool, err error) {
+	itemID, created, err = c.addItemWithUploadedContent(ctx, userID, title, rawurl, htmlContent, now)
+	if err != nil {
+		return 0, false, err
 	}
 
+	// Set as active item
 	err = c.queries.UsersSetActiveItem(ctx, db.UsersSetActiveItemParams{
 		ActiveItemID: itemID,
 		ID:           userID,
@@ -87,46 +135,48 @@ func (c *Core) AddItemWithTitleSetActive(ctx context.Context, userID int64, rawu
 		c.Logger.Warn("failed to set active item", "error", err, "userID", userID)
 	}
 
-	return itemID, nil
+	return itemID, created, nil
 }
 
-// AddItemWithUploadedContent adds an item with pre-processed uploaded content
-func (c *Core) AddItemWithUploadedContent(ctx context.Context, userID int64, title, rawurl, htmlContent string, now time.Time) (int64, error) {
+func (c *Core) addItemWithUploadedContent(ctx context.Context, userID int64, title, rawurl, htmlContent string, now time.Time) (int64, bool, error) {
 	if rawurl == "" {
-		return 0, fmt.Errorf("url cannot be empty")
+		return 0, false, fmt.Errorf("url cannot be empty")
 	}
 	u, err := url.Parse(rawurl)
 	if err != nil || u.Scheme == "" || u.Host == "" {
-		return 0, fmt.Errorf("invalid url: %w", err)
+		return 0, false, fmt.Errorf("invalid url: %q", rawurl)
 	}
 
-	// Compress the HTML content
-	compressedContent, err := CompressHTML(htmlContent)
+	// Uploaded content is stored sanitized, like the fetched content is
+	// cached, and once for all the users who upload it.
+	htmlContent, err = SanitizeHTML(htmlContent)
 	if err != nil {
-		return 0, fmt.Errorf("failed to compress content: %w", err)
+		return 0, false, fmt.Errorf("failed to sanitize content: %w", err)
 	}
-
-	itemID, err := c.queries.ItemsAddWithUploadedContent(ctx, db.ItemsAddWithUploadedContentParams{
-		UserID:             userID,
-		Title:              &title,
-		Url:                rawurl,
-		AddedTs:            now.Unix(),
-		UploadedHtmlBrotli: compressedContent,
-	})
+	hash, err := c.storeContent(ctx, rawurl, htmlContent, now)
 	if err != nil {
-		return 0, fmt.Errorf("failed to add item with uploaded content: %w", err)
+		return 0, false, err
 	}
 
-	// Set as active item
-	err = c.queries.UsersSetActiveItem(ctx, db.UsersSetActiveItemParams{
-		ActiveItemID: itemID,
-		ID:           userID,
+	_, err = c.queries.ItemsFindByUrl(ctx, db.ItemsFindByUrlParams{UserID: userID, Url: rawurl})
+	if err != nil && err != sql.ErrNoRows {
+		return 0, false, fmt.Errorf("failed to find item: %w", err)
+	}
+	created := err != nil
+
+	itemID, err := c.queries.ItemsAddWithUploadedContent(ctx, db.ItemsAddWithUploadedContentParams{
+		UserID:      userID,
+		Title:       &title,
+		Url:         rawurl,
+		AddedTs:     now.Unix(),
+		ContentHash: hash,
 	})
 	if err != nil {
-		c.Logger.Warn("failed to set active item", "error", err, "userID", userID)
+		return 0, false, fmt.Errorf("failed to add item with uploaded content: %w", err)
 	}
-
-	return itemID, nil
+	c.indexItem(ctx, itemID, title, htmlContent)
+	c.setItemMetadata(ctx, itemID, &Clean{ContentHTML: htmlContent})
+	return itemID, created, nil
 }
 
 type Item struct {
@@ -136,6 +186,24 @@ type Item struct {
 	AddedTs  time.Time
 	ReadTs   *time.Time
 	IsActive bool
+	Tags     []string
+	// Status is pending, fetching, ready or failed, FetchError says why
+	// the last fetch failed.
+	Status     string
+	FetchError string
+	// State is unread, archived or favorite.
+	State string
+	// Note is what the user jotted about the item.
+	Note string
+	// Author, SiteName and PublishedAt are known once the page was fetched,
+	// ReadingMinutes once its words were counted.
+	Author         string
+	SiteName       string
+	PublishedAt    *time.Time
+	ReadingMinutes int
+	// Chapters is where the item is in its series, nil unless a chapter
+	// list is known.
+	Chapters *ChapterProgress
 }
 
 func (c *Core) ListItems(ctx context.Context, userID int64) ([]Item, error) {
@@ -152,27 +220,251 @@ func (c *Core) ListItems(ctx context.Context, userID int64) ([]Item, error) {
 		return nil, err
 	}
 
+	itemTags, err := c.queries.ItemTagsListPerUser(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list tags: %w", err)
+	}
+	tagsByItem := make(map[int64][]string)
+	for _, it := range itemTags {
+		tagsByItem[it.ItemID] = append(tagsByItem[it.ItemID], it.Name)
+	}
+
 	parsed := make([]Item, len(items))
 	for i, item := range items {
-		var title string
-		if item.Title != nil {
-			title = item.Title.(string)
+		parsed[i] = itemFromRow(item, activeItemID != nil && item.ID == *activeItemID)
+		parsed[i].Tags = tagsByItem[item.ID]
+	}
+	if err := c.addChapterProgress(ctx, userID, parsed); err != nil {
+		return nil, err
+	}
+	return parsed, nil
+}
+
+// AddTags attaches the named tags to an item, creating missing tags for the user.
+func (c *Core) AddTags(ctx context.Context, userID int64, itemID int64, tags []string) error {
+	for _, name := range tags {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
 		}
-		var readTs *time.Time
-		if item.ReadTs != nil {
-			t := time.Unix(item.ReadTs.(int64), 0)
-			readTs = &t
+		tagID, err := c.queries.TagsUpsert(ctx, db.TagsUpsertParams{
+			UserID: userID,
+			Name:   name,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to upsert tag %q: %w", name, err)
 		}
-		parsed[i] = Item{
-			ID:       item.ID,
-			Title:    title,
-			URL:      item.Url,
-			AddedTs:  time.Unix(item.AddedTs, 0),
-			ReadTs:   readTs,
-			IsActive: activeItemID != nil && item.ID == *activeItemID,
+		err = c.queries.ItemTagsAdd(ctx, db.ItemTagsAddParams{
+			ItemID: itemID,
+			TagID:  tagID,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to tag item: %w", err)
 		}
 	}
-	return parsed, nil
+	return nil
+}
+
+// RemoveTags detaches the named tags from an item, the tags themselves stay.
+func (c *Core) RemoveTags(ctx context.Context, userID int64, itemID int64, tags []string) error {
+	for _, name := range tags {
+		err := c.queries.ItemTagsDelete(ctx, db.ItemTagsDeleteParams{
+			ItemID: itemID,
+			UserID: userID,
+			Name:   strings.TrimSpace(name),
+		})
+		if err != nil {
+			return fmt.Errorf("failed to untag item: %w", err)
+		}
+	}
+	return nil
+}
+
+// SetItemRead marks the item read at readTs, or unread when it's nil.
+func (c *Core) SetItemRead(ctx context.Context, itemID int64, readTs *time.Time) error {
+	var ts any
+	if readTs != nil {
+		ts = readTs.Unix()
+	}
+	if err := c.queries.ItemsSetRead(ctx, db.ItemsSetReadParams{ReadTs: ts, ID: itemID}); err != nil {
+		return fmt.Errorf("failed to set item read: %w", err)
+	}
+	return nil
+}
+
+func (c *Core) SetItemTitle(ctx context.Context, itemID int64, title string) error {
+	_, err := c.queries.ItemsUpdateTitle(ctx, db.ItemsUpdateTitleParams{Title: title, ID: itemID})
+	if err != nil {
+		return fmt.Errorf("failed to update item title: %w", err)
+	}
+	return nil
+}
+
+// GetItem returns an item of the user as the library lists it.
+func (c *Core) GetItem(ctx context.Context, userID int64, itemID int64) (*Item, error) {
+	row, err := c.queries.ItemsGet(ctx, itemID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get item: %w", err)
+	}
+	activeItem, err := c.queries.UsersGetActiveItem(ctx, userID)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, fmt.Errorf("failed to get active item: %w", err)
+	}
+	item := itemFromRow(row, err == nil && activeItem.ID == itemID)
+
+	itemTags, err := c.queries.ItemTagsListPerUser(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list tags: %w", err)
+	}
+	for _, it := range itemTags {
+		if it.ItemID == itemID {
+			item.Tags = append(item.Tags, it.Name)
+		}
+	}
+	list, err := c.queries.ChapterListsGet(ctx, itemID)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, fmt.Errorf("failed to get chapter list: %w", err)
+	}
+	if err == nil {
+		item.Chapters = chapterProgress(list, item.URL)
+	}
+	return &item, nil
+}
+
+func itemFromRow(item db.Item, isActive bool) Item {
+	var title string
+	if item.Title != nil {
+		title = item.Title.(string)
+	}
+	var readTs *time.Time
+	if item.ReadTs != nil {
+		t := time.Unix(item.ReadTs.(int64), 0)
+		readTs = &t
+	}
+	var fetchError string
+	if item.FetchError != nil {
+		fetchError = item.FetchError.(string)
+	}
+	author, _ := item.Author.(string)
+	siteName, _ := item.SiteName.(string)
+	var publishedAt *time.Time
+	if item.PublishedTs != nil {
+		t := time.Unix(item.PublishedTs.(int64), 0)
+		publishedAt = &t
+	}
+	words, _ := item.WordCount.(int64)
+	note, _ := item.Note.(string)
+	return Item{
+		ID:         item.ID,
+		Title:      title,
+		URL:        item.Url,
+		AddedTs:    time.Unix(item.AddedTs, 0),
+		ReadTs:     readTs,
+		IsActive:   isActive,
+		Status:     item.Status,
+		FetchError: fetchError,
+		State:      item.State,
+		Note:       note,
+
+		Author:         author,
+		SiteName:       siteName,
+		PublishedAt:    publishedAt,
+		ReadingMinutes: readingMinutes(int(words)),
+	}
+}
+
+// ItemSummary is an item with the details needed to present it on its own,
+// like the continue-reading card or a chat bot reply.
+type ItemSummary struct {
+	Item
+	Host string
+	// ProgressPercent is how far the user read into the page and
+	// MinutesLeft the estimated time to read the rest, for the item to
+	// continue reading only.
+	ProgressPercent int
+	MinutesLeft     int
+}
+
+// GetContinueReading returns the user's active item, or nil if there is none.
+func (c *Core) GetContinueReading(ctx context.Context, userID int64) (*ItemSummary, error) {
+	activeItem, err := c.queries.UsersGetActiveItem(ctx, userID)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get active item: %w", err)
+	}
+	summary := c.summarizeItem(ctx, activeItem, true)
+	c.setProgress(ctx, summary, activeItem)
+	return summary, nil
+}
+
+// GetItemSummary summarizes a single item.
+func (c *Core) GetItemSummary(ctx context.Context, itemID int64) (*ItemSummary, error) {
+	item, err := c.queries.ItemsGet(ctx, itemID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get item: %w", err)
+	}
+	return c.summarizeItem(ctx, item, false), nil
+}
+
+// summarizeItem never fetches: items whose words weren't counted yet get a
+// reading time estimate from uploaded content or an already cached clean.
+func (c *Core) summarizeItem(ctx context.Context, item db.Item, isActive bool) *ItemSummary {
+	summary := &ItemSummary{
+		Item: itemFromRow(item, isActive),
+	}
+	if u, err := url.Parse(item.Url); err == nil {
+		summary.Host = u.Host
+	}
+
+	if summary.ReadingMinutes == 0 {
+		if contentHTML := c.localContent(ctx, item); contentHTML != "" {
+			summary.ReadingMinutes = EstimateReadingMinutes(contentHTML)
+		}
+	}
+	if summary.Title == "" {
+		summary.Title = item.Url
+	}
+
+	return summary
+}
+
+// LocalItemContent returns the content of the item when it's at hand,
+// uploaded or cached, and "" otherwise. Unlike GetItemContent it never
+// fetches, for listing many items at once.
+func (c *Core) LocalItemContent(ctx context.Context, itemID int64) (string, error) {
+	item, err := c.queries.ItemsGet(ctx, itemID)
+	if err != nil {
+		return "", fmt.Errorf("failed to get item: %w", err)
+	}
+	return c.localContent(ctx, item), nil
+}
+
+func (c *Core) localContent(ctx context.Context, item db.Item) string {
+	if isBookURL(item.Url) {
+		clean, ok, err := c.bookPage(ctx, item)
+		if err != nil {
+			c.Logger.Warn("failed to get page of book", "error", err, "itemID", item.ID)
+		}
+		if ok {
+			return clean.ContentHTML
+		}
+	}
+	contentHTML, uploaded, err := c.uploadedContent(ctx, item)
+	if err != nil {
+		c.Logger.Warn("failed to get uploaded content", "error", err, "itemID", item.ID)
+	}
+	if uploaded {
+		return contentHTML
+	}
+	// The page as fetched with the user's cookies comes first.
+	for _, prefix := range []string{userCachePrefix(item.UserID), "item"} {
+		if clean, _ := c.getCached(fmt.Sprintf("%s:%s", prefix, item.Url)); clean != nil {
+			return clean.ContentHTML
+		}
+	}
+	return ""
 }
 
 func (c *Core) DeleteItem(ctx context.Context, itemID int64) error {
@@ -192,102 +484,209 @@ type Clean struct {
 	ContentHTML string `json:"content_html"`
 	NavNext     string `json:"nav_next"`
 	NavPrev     string `json:"nav_prev"`
+	// NavNextConfidence and NavPrevConfidence are how sure extract-nav was
+	// of the links, 0 when it didn't pick them.
+	NavNextConfidence float64 `json:"nav_next_confidence,omitempty"`
+	NavPrevConfidence float64 `json:"nav_prev_confidence,omitempty"`
+	// NavNextAlternatives and NavPrevAlternatives are the other likely
+	// links when it was unsure.
+	NavNextAlternatives []NavLink `json:"nav_next_alternatives,omitempty"`
+	NavPrevAlternatives []NavLink `json:"nav_prev_alternatives,omitempty"`
+	// ChapterIndex is set when the page is the table of contents of a
+	// series rather than one of its chapters.
+	ChapterIndex bool `json:"chapter_index,omitempty"`
+	// Degraded is set when no extractor could read the page, and the
+	// content is its text as it is.
+	Degraded bool `json:"degraded,omitempty"`
+	// Fallback is where the content came from when the site failed to
+	// serve the page, empty when it didn't.
+	Fallback string `json:"-"`
+	// The byline, site name and publish date, when the extractor found
+	// them.
+	Byline        string `json:"byline,omitempty"`
+	SiteName      string `json:"site_name,omitempty"`
+	PublishedTime string `json:"published_time,omitempty"`
+	// ETag and LastModified are the validators of the response the clean
+	// was made from, sent back when refetching it.
+	ETag         string `json:"etag,omitempty"`
+	LastModified string `json:"last_modified,omitempty"`
 }
 
 func (c *Core) getAndClean(ctx context.Context, url string) (*Clean, error) {
+	return c.getAndCleanSince(ctx, url, nil)
+}
+
+// getAndCleanSince refetches the page of a cached clean, which is returned
+// as it is when the site answers that the page didn't change.
+func (c *Core) getAndCleanSince(ctx context.Context, url string, cached *Clean) (*Clean, error) {
+	page, err := c.fetchPage(ctx, url, cached)
+	if err != nil {
+		return nil, err
+	}
+	if page.NotModified {
+		return cached, nil
+	}
+	body := page.Body
+
+	clean, comparisons, err := c.extract(ctx, body, url)
+	if emptyShell(clean, err) && c.renders(ctx) {
+		rendered, renderErr := c.render(ctx, url)
+		if renderErr != nil {
+			c.Logger.Warn("failed to render page", "error", renderErr, "url", url)
+		} else {
+			c.Logger.Info("read the page rendered in a headless browser", "url", url)
+			body = rendered
+			clean, comparisons, err = c.extract(ctx, body, url)
+		}
+	}
+	c.storeComparisons(ctx, url, comparisons, time.Now())
+	paywalled := paywallMarkers.MatchString(body)
+	if err != nil && !paywalled {
+		clean, err = c.degradedClean(ctx, body, url, comparisons, err)
+	}
+	if err != nil {
+		kind := FetchErrorParse
+		if paywalled {
+			kind = FetchErrorPaywall
+		}
+		return nil, &FetchError{Kind: kind, URL: url, Err: err}
+	}
+	if suspectPaywall(body, clean) {
+		return nil, &FetchError{Kind: FetchErrorPaywall, URL: url, Err: fmt.Errorf("content looks like a paywall teaser")}
+	}
+
+	doc := &Document{URL: url, Raw: body, Clean: clean}
+	if err := c.postClean(ctx, doc); err != nil {
+		return nil, err
+	}
+	c.guessChapterNav(ctx, doc)
+	doc.Clean.ChapterIndex = seriesIndexPage(body, url)
+	doc.Clean.ETag = page.ETag
+	doc.Clean.LastModified = page.LastModified
+	c.Logger.Debug("cleaned document", "url", url, "next", doc.Clean.NavNext, "prev", doc.Clean.NavPrev)
+	return doc.Clean, nil
+}
+
+// fetchedPage is a fetched page with the validators to refetch it with.
+type fetchedPage struct {
+	Body         string
+	ETag         string
+	LastModified string
+	// NotModified is set when the page is the same as the cached clean's.
+	NotModified bool
+}
+
+// fetch gets the page through the PreFetch hooks.
+func (c *Core) fetch(ctx context.Context, url string) (string, error) {
+	page, err := c.fetchPage(ctx, url, nil)
+	if err != nil {
+		return "", err
+	}
+	return page.Body, nil
+}
+
+// fetchPage gets the page through the PreFetch hooks, only if it changed
+// since the cached clean was made when there is one with validators.
+func (c *Core) fetchPage(ctx context.Context, url string, cached *Clean) (*fetchedPage, error) {
 	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create GET request: %w", err)
 	}
-	resp, err := c.httpClient.Do(req)
+	c.setFetchHeaders(req)
+	conditional := false
+	if cached != nil && cached.ETag != "" {
+		req.Header.Set("If-None-Match", cached.ETag)
+		conditional = true
+	}
+	if cached != nil && cached.LastModified != "" {
+		req.Header.Set("If-Modified-Since", cached.LastModified)
+		conditional = true
+	}
+	if err := c.setUserCookies(ctx, req); err != nil {
+		return nil, err
+	}
+	if err := c.preFetch(ctx, req); err != nil {
+		return nil, err
+	}
+	resp, err := c.doRetrying(req)
 	if err != nil {
-		return nil, fmt.Errorf("failed to fetch url: %w", err)
+		return nil, classifyFetchError(url, fmt.Errorf("failed to fetch url: %w", err))
 	}
 	defer resp.Body.Close()
 
+	if conditional && resp.StatusCode == http.StatusNotModified {
+		return &fetchedPage{NotModified: true}, nil
+	}
 	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("non-200 response fetching url: %d", resp.StatusCode)
+		return nil, statusFetchError(url, resp.StatusCode)
 	}
 
-	bodyBytes, err := io.ReadAll(resp.Body)
+	body, err := c.readPageBody(url, resp)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read response body: %w", err)
+		return nil, err
 	}
-	body := string(bodyBytes)
+	return &fetchedPage{
+		Body:         body,
+		ETag:         resp.Header.Get("ETag"),
+		LastModified: resp.Header.Get("Last-Modified"),
+	}, nil
+}
 
-	parsed, err := c.readabilityClient.Parse(ctx, body, url)
-	if err != nil {
-		return nil, fmt.Errorf("failed to parse document: %w", err)
+// getCached returns the clean stored under cacheKey, or nil, and whether
+// it's still fresh. Stale cleans are kept cacheStaleFor past their TTL.
+func (c *Core) getCached(cacheKey string) (*Clean, bool) {
+	if c.cache == nil {
+		return nil, false
 	}
+	var cachedClean *Clean
+	var fresh bool
+	err := c.cache.View(func(txn *badger.Txn) error {
+		item, err := txn.Get([]byte(cacheKey))
+		if err != nil {
+			return err
+		}
 
-	nav := extractNav(body, url)
+		expiresAt := time.Unix(int64(item.ExpiresAt()), 0)
+		if time.Now().After(expiresAt) {
+			return badger.ErrKeyNotFound
+		}
+		fresh = time.Now().Before(expiresAt.Add(-cacheStaleFor))
 
-	clean := Clean{
-		Title:       parsed.Title,
-		ContentHTML: parsed.Content,
-		NavNext:     nav.Next,
-		NavPrev:     nav.Prev,
+		return item.Value(func(val []byte) error {
+			return json.Unmarshal(val, &cachedClean)
+		})
+	})
+	if err != nil {
+		return nil, false
 	}
-	c.Logger.Debug("cleaned document", "url", url, "next", nav.Next, "prev", nav.Prev)
-	return &clean, nil
+	return cachedClean, fresh
 }
 
+// getAndCleanCached returns the cached clean of the page, fetching it when
+// there's none. A stale one is returned right away and refreshed in the
+// background. The TTL configured for the page's domain replaces ttl.
 func (c *Core) getAndCleanCached(ctx context.Context, url string, prefix string, ttl time.Duration) (*Clean, error) {
 	cacheKey := fmt.Sprintf("%s:%s", prefix, url)
+	ttl = c.cacheTTL(url, ttl)
 
-	if c.cache != nil {
-		var cachedClean *Clean
-		err := c.cache.View(func(txn *badger.Txn) error {
-			item, err := txn.Get([]byte(cacheKey))
-			if err != nil {
-				return err
-			}
-
-			if time.Now().After(time.Unix(int64(item.ExpiresAt()), 0)) {
-				return badger.ErrKeyNotFound
-			}
-
-			return item.Value(func(val []byte) error {
-				return json.Unmarshal(val, &cachedClean)
-			})
-		})
-
-		if err == nil && cachedClean != nil {
-			return cachedClean, nil
+	if cachedClean, fresh := c.getCached(cacheKey); cachedClean != nil {
+		if !fresh {
+			c.revalidate(ctx, url, cacheKey, ttl, cachedClean)
 		}
+		return cachedClean, nil
 	}
 
 	clean, err := c.getAndClean(ctx, url)
 	if err != nil {
 		return nil, err
 	}
-
-	if c.cache != nil {
-		cleanBytes, err := json.Marshal(clean)
-		if err != nil {
-			c.Logger.Warn("failed to marshal clean data for caching", "error", err)
-		} else {
-			err = c.cache.Update(func(txn *badger.Txn) error {
-				entry := badger.NewEntry([]byte(cacheKey), cleanBytes).WithTTL(ttl)
-				return txn.SetEntry(entry)
-			})
-			if err != nil {
-				c.Logger.Warn("failed to cache clean data", "error", err, "key", cacheKey)
-			}
-		}
-	}
+	c.storeCached(cacheKey, clean, ttl)
 	return clean, nil
 }
 
 func (c *Core) ReadItem(ctx context.Context, itemID int64, now time.Time) (*Clean, error) {
-	// First check if this item has uploaded content
-	item, err := c.queries.ItemsGet(ctx, itemID)
-	if err != nil {
-		return nil, fmt.Errorf("failed to get item: %w", err)
-	}
-
 	// Mark as read
-	_, err = c.queries.ItemsGetUrlSetRead(ctx, db.ItemsGetUrlSetReadParams{
+	_, err := c.queries.ItemsGetUrlSetRead(ctx, db.ItemsGetUrlSetReadParams{
 		ReadTs: now.Unix(),
 		ID:     itemID,
 	})
@@ -295,31 +694,73 @@ func (c *Core) ReadItem(ctx context.Context, itemID int64, now time.Time) (*Clea
 		return nil, fmt.Errorf("failed to mark item as read: %w", err)
 	}
 
-	// Check if item has uploaded content
-	if item.UploadedHtmlBrotli != nil {
-		// Decompress and return uploaded content
-		htmlContent, err := DecompressHTML(item.UploadedHtmlBrotli.([]byte))
+	return c.GetItemContent(ctx, itemID)
+}
+
+// GetItemContent returns the clean content of an item without marking it
+// read, for exports and sharing.
+func (c *Core) GetItemContent(ctx context.Context, itemID int64) (*Clean, error) {
+	item, err := c.queries.ItemsGet(ctx, itemID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get item: %w", err)
+	}
+
+	// Books are read page by page
+	if isBookURL(item.Url) {
+		clean, ok, err := c.bookPage(ctx, item)
 		if err != nil {
-			return nil, fmt.Errorf("failed to decompress uploaded content: %w", err)
+			return nil, err
 		}
+		if ok {
+			return c.preRender(ctx, item.Url, clean)
+		}
+	}
+
+	// Check if item has uploaded content
+	htmlContent, uploaded, err := c.uploadedContent(ctx, item)
+	if err != nil {
+		return nil, err
+	}
+	if uploaded {
 
 		var title string
 		if item.Title != nil {
 			title = item.Title.(string)
 		}
 
-		return &Clean{
+		return c.preRender(ctx, item.Url, &Clean{
 			Title:       title,
 			ContentHTML: htmlContent,
 			NavNext:     "", // No nav for uploaded content
 			NavPrev:     "", // No nav for uploaded content
-		}, nil
+		})
+	}
+
+	// Pages no extractor could read are kept as a screenshot
+	hasScreenshot, err := c.queries.ItemScreenshotsExists(ctx, itemID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check for a screenshot: %w", err)
+	}
+	if hasScreenshot == 1 {
+		title := item.Url
+		if item.Title != nil {
+			title = item.Title.(string)
+		}
+		return screenshotContent(itemID, title), nil
 	}
 
 	// Fall back to normal fetch and clean
-	clean, err := c.getAndCleanCached(ctx, item.Url, "item", 10*time.Minute)
+	prefix, err := c.itemCachePrefix(ctx, item)
 	if err != nil {
-		return nil, fmt.Errorf("failed to clean document: %w", err)
+		return nil, err
+	}
+	clean, err := c.getAndCleanCached(withFetchUser(ctx, item.UserID), item.Url, prefix, 10*time.Minute)
+	if err != nil {
+		fallback, fallbackErr := c.fallbackContent(ctx, item, err)
+		if fallbackErr != nil {
+			return nil, fmt.Errorf("failed to clean document: %w", err)
+		}
+		return c.preRender(ctx, item.Url, fallback)
 	}
 
 	_, err = c.queries.ItemsUpdateTitle(ctx, db.ItemsUpdateTitleParams{
@@ -329,8 +770,24 @@ func (c *Core) ReadItem(ctx context.Context, itemID int64, now time.Time) (*Clea
 	if err != nil {
 		return nil, fmt.Errorf("failed to update item title: %w", err)
 	}
+	err = c.queries.ChaptersSetTitle(ctx, db.ChaptersSetTitleParams{
+		Title:  clean.Title,
+		ItemID: itemID,
+		Url:    item.Url,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to update chapter title: %w", err)
+	}
+	c.indexItem(ctx, itemID, clean.Title, clean.ContentHTML)
+	c.setItemMetadata(ctx, itemID, clean)
+	// A failed item that reads fine now is ready.
+	if item.Status != ItemReady {
+		if err := c.queries.ItemsFetchDone(ctx, itemID); err != nil {
+			return nil, fmt.Errorf("failed to update item status: %w", err)
+		}
+	}
 
-	return clean, nil
+	return c.preRender(ctx, item.Url, clean)
 }
 
 func (c *Core) NavigateItem(ctx context.Context, itemID int64, targetPathRel string) error {
@@ -342,6 +799,16 @@ func (c *Core) NavigateItem(ctx context.Context, itemID int64, targetPathRel str
 	if err != nil {
 		return fmt.Errorf("failed to resolve URL: %w", err)
 	}
+	// The page being left is recorded too, the item may predate the
+	// history.
+	now := time.Now()
+	title, _ := item.Title.(string)
+	if err := c.visitChapter(ctx, itemID, item.Url, title, now); err != nil {
+		return err
+	}
+	if err := c.visitChapter(ctx, itemID, newURL, "", now); err != nil {
+		return err
+	}
 	err = c.queries.ItemsSetUrl(ctx, db.ItemsSetUrlParams{
 		Url: newURL,
 		ID:  itemID,
@@ -0,0 +1,147 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"time"
+)
+
+// ExtractReport is a dry run of fetching and cleaning a page, step by step,
+// for developing per-domain rules. Nothing is cached or stored.
+type ExtractReport struct {
+	URL         string                      `json:"url"`
+	Fetched     bool                        `json:"fetched"`
+	Readability *ReadabilityResponseSuccess `json:"readability,omitempty"`
+	// Extractors compares every extractor on the page, PinnedExtractor is
+	// the one set for the domain, if any.
+	Extractors      []ExtractorResult `json:"extractors"`
+	PinnedExtractor string            `json:"pinned_extractor,omitempty"`
+	// Pipeline is the processors of the domain, in order.
+	Pipeline        []string      `json:"pipeline"`
+	SiteScript      string        `json:"site_script,omitempty"`
+	SiteScriptError string        `json:"site_script_error,omitempty"`
+	Nav             Nav           `json:"nav"`
+	NavCandidates   *NavReport    `json:"nav_candidates,omitempty"`
+	Clean           *Clean        `json:"clean,omitempty"`
+	Steps           []ExtractStep `json:"steps"`
+	// Error is the step that failed, the report shows how far it got.
+	Error string `json:"error,omitempty"`
+}
+
+type ExtractStep struct {
+	Name       string  `json:"name"`
+	DurationMs float64 `json:"duration_ms"`
+	Error      string  `json:"error,omitempty"`
+}
+
+// DebugExtract cleans the page at pageURL, fetching it unless rawHTML is
+// given.
+func (c *Core) DebugExtract(ctx context.Context, pageURL, rawHTML string) (*ExtractReport, error) {
+	u, err := url.Parse(pageURL)
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return nil, fmt.Errorf("invalid url: %q", pageURL)
+	}
+	report := &ExtractReport{URL: pageURL, Steps: []ExtractStep{}}
+	step := func(name string, fn func() error) bool {
+		start := time.Now()
+		err := fn()
+		s := ExtractStep{Name: name, DurationMs: float64(time.Since(start).Microseconds()) / 1000}
+		if err != nil {
+			s.Error = err.Error()
+			report.Error = name + ": " + err.Error()
+		}
+		report.Steps = append(report.Steps, s)
+		return err == nil
+	}
+
+	pipeline := c.pipeline(pageURL)
+	for _, p := range pipeline {
+		report.Pipeline = append(report.Pipeline, p.Name())
+	}
+
+	if rawHTML == "" {
+		report.Fetched = true
+		ok := step("fetch", func() (err error) {
+			rawHTML, err = c.fetch(ctx, pageURL)
+			return err
+		})
+		if !ok {
+			return report, nil
+		}
+	}
+
+	ok := step("readability", func() (err error) {
+		report.Readability, err = c.readabilityClient.Parse(ctx, rawHTML, pageURL)
+		return err
+	})
+	if !ok {
+		return report, nil
+	}
+
+	strategies, _ := c.navStrategies(pageURL)
+	report.NavCandidates = extractNavReport(rawHTML, pageURL, strategies)
+
+	report.PinnedExtractor, _ = c.domainExtractor(ctx, pageURL)
+	step("compare-extractors", func() error {
+		report.Extractors, _ = c.CompareExtractors(ctx, rawHTML, pageURL)
+		return nil
+	})
+	var clean *Clean
+	ok = step("extract", func() (err error) {
+		clean, _, err = c.extract(ctx, rawHTML, pageURL)
+		return err
+	})
+	if !ok {
+		return report, nil
+	}
+
+	doc := &Document{URL: pageURL, Raw: rawHTML, Clean: clean}
+	for _, p := range pipeline {
+		if !step("post-clean:"+p.Name(), func() error { return p.PostClean(ctx, doc) }) {
+			return report, nil
+		}
+	}
+
+	if s, err := c.siteScript(ctx, pageURL); err != nil {
+		report.SiteScriptError = err.Error()
+	} else if s != nil {
+		report.SiteScript = s.Domain
+		step("site-script", func() error {
+			if err := c.runSiteScript(ctx, doc); err != nil {
+				report.SiteScriptError = err.Error()
+			}
+			return nil
+		})
+	}
+	report.Nav = Nav{
+		Next:           doc.Clean.NavNext,
+		Prev:           doc.Clean.NavPrev,
+		NextConfidence: doc.Clean.NavNextConfidence,
+		PrevConfidence: doc.Clean.NavPrevConfidence,
+	}
+
+	step("pre-render", func() (err error) {
+		report.Clean, err = c.preRender(ctx, pageURL, doc.Clean)
+		return err
+	})
+	return report, nil
+}
+
+// DebugNav refetches the page of the item and reports how its nav links
+// were picked.
+func (c *Core) DebugNav(ctx context.Context, itemID int64) (*NavReport, error) {
+	item, err := c.queries.ItemsGet(ctx, itemID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get item: %w", err)
+	}
+	if item.UploadedHtmlBrotli != nil || item.ContentHash != nil {
+		return nil, fmt.Errorf("uploaded content has no nav links")
+	}
+	body, err := c.fetch(ctx, item.Url)
+	if err != nil {
+		return nil, err
+	}
+	strategies, _ := c.navStrategies(item.Url)
+	return extractNavReport(body, item.Url, strategies), nil
+}
@@ -0,0 +1,124 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"html"
+	"strings"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// A chapter the extractors fail on is still better read ugly than not at
+// all. Its text is read as it is, a block at a time, and the reader says
+// the page couldn't be cleaned up. The failure is recorded with the
+// extraction comparisons, for admins to look into.
+
+// degradedBlocks are the elements read as a block of text each, with
+// their tag, the text between them is read a paragraph a line.
+var degradedBlocks = map[string]string{
+	"h1": "h1", "h2": "h2", "h3": "h3", "h4": "h4", "h5": "h5", "h6": "h6",
+	"p": "p", "pre": "pre", "blockquote": "blockquote",
+	"li": "p", "dt": "p", "dd": "p", "td": "p", "th": "p", "figcaption": "p",
+}
+
+// degradedSkipped are the elements of the page that aren't read.
+const degradedSkipped = "script, style, noscript, template, iframe, frame, object, embed, svg, canvas, form, button, select, textarea, nav"
+
+// degradedExtractor keeps the headings, paragraphs, lists, quotes and code
+// of the page as plain text, without its scripts, forms and frames.
+type degradedExtractor struct{}
+
+func (degradedExtractor) Name() string { return "degraded" }
+
+func (degradedExtractor) Extract(_ context.Context, rawHTML, _ string) (*Clean, error) {
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(rawHTML))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse page: %w", err)
+	}
+	title := strings.Join(strings.Fields(doc.Find("title").First().Text()), " ")
+	body := doc.Find("body")
+	body.Find(degradedSkipped).Remove()
+
+	var b strings.Builder
+	block := func(tag, text string) {
+		fmt.Fprintf(&b, "<%s>%s</%s>\n", tag, html.EscapeString(text), tag)
+	}
+	var line strings.Builder
+	endLine := func() {
+		if text := strings.Join(strings.Fields(line.String()), " "); text != "" {
+			block("p", text)
+		}
+		line.Reset()
+	}
+	var walk func(*goquery.Selection)
+	walk = func(s *goquery.Selection) {
+		s.Contents().Each(func(_ int, child *goquery.Selection) {
+			name := goquery.NodeName(child)
+			switch {
+			case name == "#text":
+				line.WriteString(child.Text())
+			case name == "pre":
+				endLine()
+				if text := strings.Trim(child.Text(), "\n"); strings.TrimSpace(text) != "" {
+					block("pre", text)
+				}
+			case degradedBlocks[name] != "":
+				endLine()
+				if text := strings.Join(strings.Fields(child.Text()), " "); text != "" {
+					block(degradedBlocks[name], text)
+				}
+			case name == "br" || name == "hr":
+				endLine()
+			case inlineElements[name]:
+				walk(child)
+			default:
+				// Other elements, divs and sections, end the line.
+				endLine()
+				walk(child)
+				endLine()
+			}
+		})
+	}
+	walk(body)
+	endLine()
+	if b.Len() == 0 {
+		return nil, fmt.Errorf("page has no text")
+	}
+	return &Clean{Title: title, ContentHTML: b.String(), Degraded: true}, nil
+}
+
+// inlineElements are the elements the text flows through.
+var inlineElements = map[string]bool{
+	"a": true, "abbr": true, "b": true, "bdi": true, "bdo": true, "cite": true, "code": true,
+	"data": true, "dfn": true, "em": true, "font": true, "i": true, "kbd": true, "mark": true,
+	"q": true, "rp": true, "rt": true, "ruby": true, "s": true, "samp": true, "small": true,
+	"span": true, "strike": true, "strong": true, "sub": true, "sup": true, "time": true,
+	"tt": true, "u": true, "var": true, "wbr": true,
+}
+
+// degradedClean reads the page the extractors failed on as its text, and
+// records their failure. comparisons are the results of the extractors
+// when comparison mode is on, the failure is in them already.
+func (c *Core) degradedClean(ctx context.Context, rawHTML, pageURL string, comparisons []ExtractorResult, extractErr error) (*Clean, error) {
+	start := time.Now()
+	clean, err := degradedExtractor{}.Extract(ctx, rawHTML, pageURL)
+	if err != nil {
+		return nil, extractErr
+	}
+	c.Logger.Warn("extractors failed, reading the page as it is", "error", extractErr, "url", pageURL)
+	var results []ExtractorResult
+	if len(comparisons) == 0 {
+		results = append(results, ExtractorResult{Extractor: "extract", Error: extractErr.Error()})
+	}
+	_, words := wordSet(clean.ContentHTML)
+	results = append(results, ExtractorResult{
+		Extractor:  degradedExtractor{}.Name(),
+		Words:      words,
+		DurationMs: time.Since(start).Milliseconds(),
+		Picked:     true,
+	})
+	c.storeComparisons(ctx, pageURL, results, time.Now())
+	return clean, nil
+}
@@ -0,0 +1,128 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	_ "image/gif"
+	"image/jpeg"
+	"image/png"
+
+	db "github.com/egemengol/kindlepathy/internal/db/generated"
+)
+
+// Full resolution color photos are slow to load on a Kindle and look muddy
+// on its screen. In e-ink mode the proxy scales images down to the width of
+// the screen and turns them gray, and dithers them to the 16 grays of the
+// screen when asked, which keeps gradients from banding.
+
+// E-ink modes for the images of the reader.
+const (
+	EinkOff       = "off"
+	EinkGrayscale = "grayscale"
+	EinkDither    = "dither"
+)
+
+const (
+	// einkMaxWidth is the width images are scaled down to, the portrait
+	// width of the Paperwhite screens.
+	einkMaxWidth    = 1072
+	einkJPEGQuality = 80
+)
+
+// einkPalette is the 16 grays of a Kindle screen.
+var einkPalette = func() color.Palette {
+	palette := make(color.Palette, 16)
+	for i := range palette {
+		palette[i] = color.Gray{Y: uint8(i * 17)}
+	}
+	return palette
+}()
+
+func validEinkMode(mode string) bool {
+	return mode == EinkOff || mode == EinkGrayscale || mode == EinkDither
+}
+
+func (c *Core) SetEinkMode(ctx context.Context, userID int64, mode string) error {
+	if !validEinkMode(mode) {
+		return fmt.Errorf("invalid e-ink mode: %q", mode)
+	}
+	return c.queries.UsersSetEinkMode(ctx, db.UsersSetEinkModeParams{
+		EinkMode: mode,
+		ID:       userID,
+	})
+}
+
+// einkImage returns the image prepared for an e-ink screen in the mode.
+// Images Go can't decode, like WebP, are returned as they are.
+func einkImage(img *ProxiedImage, mode string) (*ProxiedImage, error) {
+	if mode != EinkGrayscale && mode != EinkDither {
+		return img, nil
+	}
+	src, format, err := image.Decode(bytes.NewReader(img.Data))
+	if err != nil {
+		return img, nil
+	}
+	gray := grayScaled(src, einkMaxWidth)
+
+	var buf bytes.Buffer
+	switch {
+	case mode == EinkDither:
+		dithered := image.NewPaletted(gray.Bounds(), einkPalette)
+		draw.FloydSteinberg.Draw(dithered, gray.Bounds(), gray, image.Point{})
+		err = png.Encode(&buf, dithered)
+	case format == "jpeg":
+		err = jpeg.Encode(&buf, gray, &jpeg.Options{Quality: einkJPEGQuality})
+	default:
+		// Line art and screenshots keep their sharp edges as PNG.
+		err = png.Encode(&buf, gray)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode image: %w", err)
+	}
+	contentType := "image/png"
+	if mode == EinkGrayscale && format == "jpeg" {
+		contentType = "image/jpeg"
+	}
+	return &ProxiedImage{ContentType: contentType, Data: buf.Bytes()}, nil
+}
+
+// grayScaled returns the image in gray, scaled down to maxWidth by averaging
+// the pixels that fall on each. Transparency is laid over white, like the
+// page around the image.
+func grayScaled(src image.Image, maxWidth int) *image.Gray {
+	bounds := src.Bounds()
+	sw, sh := bounds.Dx(), bounds.Dy()
+	dw, dh := sw, sh
+	if sw > maxWidth {
+		dw = maxWidth
+		dh = max(1, sh*maxWidth/sw)
+	}
+
+	sums := make([]uint64, dw*dh)
+	counts := make([]uint32, dw*dh)
+	for y := 0; y < sh; y++ {
+		row := y * dh / sh * dw
+		for x := 0; x < sw; x++ {
+			r, g, b, a := src.At(bounds.Min.X+x, bounds.Min.Y+y).RGBA()
+			white := 0xffff - a
+			r, g, b = r+white, g+white, b+white
+			// The luma weights of color.GrayModel.
+			lum := (19595*r + 38470*g + 7471*b + 1<<15) >> 24
+			i := row + x*dw/sw
+			sums[i] += uint64(lum)
+			counts[i]++
+		}
+	}
+
+	gray := image.NewGray(image.Rect(0, 0, dw, dh))
+	for i := range sums {
+		if counts[i] > 0 {
+			gray.Pix[i] = uint8(sums[i] / uint64(counts[i]))
+		}
+	}
+	return gray
+}
@@ -0,0 +1,166 @@
+package core
+
+import (
+	"archive/zip"
+	"context"
+	"fmt"
+	"io"
+	"mime"
+	"path"
+	"slices"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// An item as an EPUB is for e-reader apps that read books rather than web
+// pages, like KOReader. The article is the only chapter, with its images
+// saved in the book like in the site export, and the note of the item is
+// its description.
+
+var epubContainer = `<?xml version="1.0" encoding="UTF-8"?>
+<container version="1.0" xmlns="urn:oasis:names:tc:opendocument:xmlns:container">
+<rootfiles>
+<rootfile full-path="content.opf" media-type="application/oebps-package+xml"/>
+</rootfiles>
+</container>
+`
+
+// The templates are text, html/template would escape the XML declaration.
+// The html function escapes for XML as well.
+
+var epubPackageTemplate = template.Must(template.New("opf").Parse(`<?xml version="1.0" encoding="UTF-8"?>
+<package xmlns="http://www.idpf.org/2007/opf" version="3.0" unique-identifier="id">
+<metadata xmlns:dc="http://purl.org/dc/elements/1.1/">
+<dc:identifier id="id">{{html .URL}}</dc:identifier>
+<dc:title>{{html .Title}}</dc:title>
+<dc:language>und</dc:language>
+<dc:source>{{html .URL}}</dc:source>{{if .Host}}
+<dc:publisher>{{html .Host}}</dc:publisher>{{end}}{{if .Note}}
+<dc:description>{{html .Note}}</dc:description>{{end}}
+<meta property="dcterms:modified">{{html .Modified}}</meta>
+</metadata>
+<manifest>
+<item id="nav" href="nav.xhtml" media-type="application/xhtml+xml" properties="nav"/>
+<item id="text" href="text/item.xhtml" media-type="application/xhtml+xml"/>
+{{range $i, $asset := .Assets}}<item id="asset{{$i}}" href="{{html $asset.Path}}" media-type="{{html $asset.MediaType}}"/>
+{{end}}</manifest>
+<spine>
+<itemref idref="text"/>
+</spine>
+</package>
+`))
+
+var epubNavTemplate = template.Must(template.New("nav").Parse(`<?xml version="1.0" encoding="UTF-8"?>
+<html xmlns="http://www.w3.org/1999/xhtml" xmlns:epub="http://www.idpf.org/2007/ops">
+<head><title>{{html .Title}}</title></head>
+<body>
+<nav epub:type="toc"><ol><li><a href="text/item.xhtml">{{html .Title}}</a></li></ol></nav>
+</body>
+</html>
+`))
+
+var epubTextTemplate = template.Must(template.New("text").Parse(`<?xml version="1.0" encoding="UTF-8"?>
+<html xmlns="http://www.w3.org/1999/xhtml">
+<head><title>{{html .Title}}</title></head>
+<body>
+<h1>{{html .Title}}</h1>
+{{.Content}}
+</body>
+</html>
+`))
+
+type epubAsset struct {
+	Path      string
+	MediaType string
+}
+
+// ExportEPUB writes the item as an EPUB, fetching it when it isn't cached.
+func (c *Core) ExportEPUB(ctx context.Context, itemID int64, w io.Writer) error {
+	summary, err := c.GetItemSummary(ctx, itemID)
+	if err != nil {
+		return err
+	}
+	clean, err := c.GetItemContent(ctx, itemID)
+	if err != nil {
+		return err
+	}
+	title := summary.Title
+	if clean.Title != "" && title == summary.URL {
+		title = clean.Title
+	}
+
+	zw := zip.NewWriter(w)
+	// The mimetype comes first and uncompressed, readers identify the book
+	// by it.
+	f, err := zw.CreateHeader(&zip.FileHeader{Name: "mimetype", Method: zip.Store})
+	if err != nil {
+		return fmt.Errorf("failed to add mimetype: %w", err)
+	}
+	if _, err := io.WriteString(f, "application/epub+zip"); err != nil {
+		return fmt.Errorf("failed to write mimetype: %w", err)
+	}
+	f, err = zw.Create("META-INF/container.xml")
+	if err != nil {
+		return fmt.Errorf("failed to add container: %w", err)
+	}
+	if _, err := io.WriteString(f, epubContainer); err != nil {
+		return fmt.Errorf("failed to write container: %w", err)
+	}
+
+	// The text is in text/, where the "../assets/" the images are pointed
+	// to finds them.
+	assets := &exportAssets{zw: zw, saved: map[string]string{}, client: c.httpClient}
+	content, err := assets.localize(ctx, clean)
+	if err != nil {
+		return err
+	}
+	f, err = zw.Create("text/item.xhtml")
+	if err != nil {
+		return fmt.Errorf("failed to add text: %w", err)
+	}
+	err = epubTextTemplate.Execute(f, struct {
+		Title   string
+		Content string
+	}{title, content})
+	if err != nil {
+		return fmt.Errorf("failed to write text: %w", err)
+	}
+
+	f, err = zw.Create("nav.xhtml")
+	if err != nil {
+		return fmt.Errorf("failed to add nav: %w", err)
+	}
+	if err := epubNavTemplate.Execute(f, struct{ Title string }{title}); err != nil {
+		return fmt.Errorf("failed to write nav: %w", err)
+	}
+
+	var saved []epubAsset
+	for _, assetPath := range assets.saved {
+		if assetPath == "" {
+			continue
+		}
+		mediaType := mime.TypeByExtension(path.Ext(assetPath))
+		if mediaType == "" {
+			mediaType = "application/octet-stream"
+		}
+		saved = append(saved, epubAsset{Path: assetPath, MediaType: mediaType})
+	}
+	slices.SortFunc(saved, func(a, b epubAsset) int { return strings.Compare(a.Path, b.Path) })
+	f, err = zw.Create("content.opf")
+	if err != nil {
+		return fmt.Errorf("failed to add package: %w", err)
+	}
+	err = epubPackageTemplate.Execute(f, struct {
+		Title    string
+		URL      string
+		Host     string
+		Note     string
+		Modified string
+		Assets   []epubAsset
+	}{title, summary.URL, summary.Host, summary.Note, time.Now().UTC().Format("2006-01-02T15:04:05Z"), saved})
+	if err != nil {
+		return fmt.Errorf("failed to write package: %w", err)
+	}
+	return zw.Close()
+}
@@ -0,0 +1,100 @@
+package core
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// ItemMarkdown is an Obsidian-compatible note for an item: YAML frontmatter
+// with the source URL, then the title and a link back to the source.
+type ItemMarkdown struct {
+	Filename string
+	Content  string
+}
+
+func itemMarkdown(item Item) ItemMarkdown {
+	title := item.Title
+	if title == "" {
+		title = item.URL
+	}
+
+	var b strings.Builder
+	b.WriteString("---\n")
+	fmt.Fprintf(&b, "title: %s\n", yamlString(title))
+	fmt.Fprintf(&b, "source: %s\n", yamlString(item.URL))
+	fmt.Fprintf(&b, "added: %s\n", item.AddedTs.Format("2006-01-02"))
+	if item.ReadTs != nil {
+		fmt.Fprintf(&b, "read: %s\n", item.ReadTs.Format("2006-01-02"))
+	}
+	if len(item.Tags) > 0 {
+		b.WriteString("tags:\n")
+		for _, tag := range item.Tags {
+			fmt.Fprintf(&b, "  - %s\n", yamlString(tag))
+		}
+	}
+	b.WriteString("---\n\n")
+	fmt.Fprintf(&b, "# %s\n\n", title)
+	fmt.Fprintf(&b, "<%s>\n", item.URL)
+	if item.Note != "" {
+		fmt.Fprintf(&b, "\n%s\n", item.Note)
+	}
+
+	return ItemMarkdown{
+		Filename: Slugify(title) + ".md",
+		Content:  b.String(),
+	}
+}
+
+// yamlString quotes a string for YAML. JSON strings are valid YAML.
+func yamlString(s string) string {
+	quoted, _ := json.Marshal(s)
+	return string(quoted)
+}
+
+// GetItemMarkdown returns the Markdown note of one of the user's items.
+func (c *Core) GetItemMarkdown(ctx context.Context, userID, itemID int64) (*ItemMarkdown, error) {
+	items, err := c.ListItems(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	for _, item := range items {
+		if item.ID == itemID {
+			md := itemMarkdown(item)
+			return &md, nil
+		}
+	}
+	return nil, fmt.Errorf("item not found")
+}
+
+// ExportMarkdown writes a zip with a Markdown note per item in the library,
+// ready to drop into an Obsidian vault.
+func (c *Core) ExportMarkdown(ctx context.Context, userID int64, w io.Writer) error {
+	items, err := c.ListItems(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	zw := zip.NewWriter(w)
+	seen := make(map[string]bool)
+	for _, item := range items {
+		md := itemMarkdown(item)
+		// Titles of chapters often repeat, keep every note.
+		if seen[md.Filename] {
+			md.Filename = fmt.Sprintf("%s-%d.md", strings.TrimSuffix(md.Filename, ".md"), item.ID)
+		}
+		seen[md.Filename] = true
+
+		f, err := zw.Create(md.Filename)
+		if err != nil {
+			return fmt.Errorf("failed to add %s: %w", md.Filename, err)
+		}
+		if _, err := io.WriteString(f, md.Content); err != nil {
+			return fmt.Errorf("failed to write %s: %w", md.Filename, err)
+		}
+	}
+	return zw.Close()
+}
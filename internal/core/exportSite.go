@@ -0,0 +1,264 @@
+package core
+
+import (
+	"archive/zip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// The static site export is the library as plain HTML files in a zip: an
+// index page and a page per item, with the images saved next to them, so it
+// opens on a device without a connection or years later from cold storage.
+
+// maxExportImageSize skips images larger than this, they'd only bloat the
+// export.
+const maxExportImageSize = 10 << 20
+
+const exportSiteStyle = `body { max-width: 40em; margin: 0 auto; padding: 1em; font-family: Georgia, serif; line-height: 1.5; }
+img { max-width: 100%; height: auto; }
+.meta { color: #555; font-size: 0.9em; }
+ul.items li { margin-bottom: 0.5em; }
+`
+
+var exportIndexTemplate = template.Must(template.New("index").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Title}}</title>
+<link rel="stylesheet" href="style.css">
+</head>
+<body>
+<h1>{{.Title}}</h1>
+<ul class="items">
+{{range .Items}}<li><a href="{{.Path}}">{{.Title}}</a><br><span class="meta">{{.Host}} · added {{.Added}}{{if .Tags}} · {{.Tags}}{{end}}</span></li>
+{{end}}</ul>
+</body>
+</html>
+`))
+
+var exportItemTemplate = template.Must(template.New("item").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Title}}</title>
+<link rel="stylesheet" href="../style.css">
+</head>
+<body>
+<p class="meta"><a href="../index.html">Library</a> · <a href="{{.URL}}">{{.URL}}</a></p>
+<h1>{{.Title}}</h1>
+{{if .Error}}<p><em>The content couldn't be saved: {{.Error}}</em></p>{{end}}
+{{.Content}}
+</body>
+</html>
+`))
+
+type exportIndexEntry struct {
+	Path  string
+	Title string
+	Host  string
+	Added string
+	Tags  string
+}
+
+// ExportSite writes a zip with the library as a static site, only the items
+// with the tag when one is given. Items are fetched if they aren't cached,
+// one that can't be is exported with a link to the original.
+func (c *Core) ExportSite(ctx context.Context, userID int64, tag string, w io.Writer) error {
+	items, err := c.ListItems(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	zw := zip.NewWriter(w)
+	assets := &exportAssets{zw: zw, saved: map[string]string{}, client: c.httpClient}
+	var index []exportIndexEntry
+	seen := map[string]bool{}
+	for _, item := range items {
+		if tag != "" && !slices.Contains(item.Tags, tag) {
+			continue
+		}
+		page := struct {
+			Title   string
+			URL     string
+			Content template.HTML
+			Error   string
+		}{Title: item.Title, URL: item.URL}
+		clean, err := c.GetItemContent(ctx, item.ID)
+		if err != nil {
+			c.Logger.Warn("failed to get content for export", "error", err, "itemID", item.ID)
+			page.Error = err.Error()
+			var fetchErr *FetchError
+			if errors.As(err, &fetchErr) {
+				page.Error = fetchErr.Reason()
+			}
+		} else {
+			content, err := assets.localize(ctx, clean)
+			if err != nil {
+				return err
+			}
+			page.Content = template.HTML(content)
+			if page.Title == "" {
+				page.Title = clean.Title
+			}
+		}
+		if page.Title == "" {
+			page.Title = item.URL
+		}
+
+		name := Slugify(page.Title)
+		if name == "" || seen[name] {
+			name = fmt.Sprintf("%s-%d", name, item.ID)
+		}
+		seen[name] = true
+		path := "items/" + name + ".html"
+		var host string
+		if u, err := url.Parse(item.URL); err == nil {
+			host = u.Host
+		}
+
+		f, err := zw.Create(path)
+		if err != nil {
+			return fmt.Errorf("failed to add %s: %w", path, err)
+		}
+		if err := exportItemTemplate.Execute(f, page); err != nil {
+			return fmt.Errorf("failed to write %s: %w", path, err)
+		}
+		index = append(index, exportIndexEntry{
+			Path:  path,
+			Title: page.Title,
+			Host:  host,
+			Added: item.AddedTs.Format("2006-01-02"),
+			Tags:  strings.Join(item.Tags, ", "),
+		})
+	}
+
+	indexTitle := "Library"
+	if tag != "" {
+		indexTitle = "Library: " + tag
+	}
+	f, err := zw.Create("index.html")
+	if err != nil {
+		return fmt.Errorf("failed to add index: %w", err)
+	}
+	err = exportIndexTemplate.Execute(f, struct {
+		Title string
+		Items []exportIndexEntry
+	}{indexTitle, index})
+	if err != nil {
+		return fmt.Errorf("failed to write index: %w", err)
+	}
+	f, err = zw.Create("style.css")
+	if err != nil {
+		return fmt.Errorf("failed to add style: %w", err)
+	}
+	if _, err := io.WriteString(f, exportSiteStyle); err != nil {
+		return fmt.Errorf("failed to write style: %w", err)
+	}
+	return zw.Close()
+}
+
+var imageExtensions = map[string]string{
+	"image/png":  ".png",
+	"image/jpeg": ".jpg",
+	"image/gif":  ".gif",
+	"image/webp": ".webp",
+}
+
+// exportAssets saves the images of the exported items once each.
+type exportAssets struct {
+	zw     *zip.Writer
+	client *http.Client
+	// saved maps image URLs to their path in the export, "" for the ones
+	// that couldn't be saved.
+	saved map[string]string
+}
+
+// localize points the images of the content to saved copies, the ones that
+// can't be downloaded keep their URL.
+func (a *exportAssets) localize(ctx context.Context, clean *Clean) (string, error) {
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(clean.ContentHTML))
+	if err != nil {
+		return "", fmt.Errorf("failed to parse content: %w", err)
+	}
+	var saveErr error
+	doc.Find("img[src]").Each(func(_ int, img *goquery.Selection) {
+		src := img.AttrOr("src", "")
+		path, err := a.save(ctx, src)
+		if err != nil {
+			saveErr = err
+			return
+		}
+		if path != "" {
+			img.SetAttr("src", "../"+path)
+			img.RemoveAttr("srcset")
+		}
+	})
+	if saveErr != nil {
+		return "", saveErr
+	}
+	return doc.Find("body").Html()
+}
+
+// save downloads the image into the export, returning its path, or "" when
+// it can't be downloaded. Only writing the export fails it.
+func (a *exportAssets) save(ctx context.Context, src string) (string, error) {
+	if path, ok := a.saved[src]; ok {
+		return path, nil
+	}
+	a.saved[src] = ""
+	if !strings.HasPrefix(src, "http://") && !strings.HasPrefix(src, "https://") {
+		return "", nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "GET", src, nil)
+	if err != nil {
+		return "", nil
+	}
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return "", nil
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", nil
+	}
+	image, err := io.ReadAll(io.LimitReader(resp.Body, maxExportImageSize+1))
+	if err != nil || len(image) > maxExportImageSize {
+		return "", nil
+	}
+	contentType := http.DetectContentType(image)
+	if !strings.HasPrefix(contentType, "image/") {
+		return "", nil
+	}
+	ext, ok := imageExtensions[contentType]
+	if !ok {
+		ext = ".img"
+		if exts, _ := mime.ExtensionsByType(contentType); len(exts) > 0 {
+			ext = exts[0]
+		}
+	}
+
+	sum := sha256.Sum256([]byte(src))
+	path := "assets/" + hex.EncodeToString(sum[:8]) + ext
+	f, err := a.zw.Create(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to add %s: %w", path, err)
+	}
+	if _, err := f.Write(image); err != nil {
+		return "", fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	a.saved[src] = path
+	return path, nil
+}
@@ -1,6 +1,7 @@
 package core
 
 import (
+	"fmt"
 	"net/url"
 	"strings"
 
@@ -27,9 +28,6 @@ var patternsNext = []string{
 	"proceed",
 	"advance",
 	"onward",
-	"siguiente",
-	"weiter",
-	"suivant",
 }
 
 var patternsPrev = []string{
@@ -57,9 +55,6 @@ var patternsPrev = []string{
 	"prev section",
 	"return",
 	"go back",
-	"anterior",
-	"zurück",
-	"précédent",
 }
 
 const selector = `
@@ -105,10 +100,20 @@ const selector = `
 	input[type='submit']
 `
 
+// ScoredLink is a nav candidate with how its score came about.
 type ScoredLink struct {
-	URL     string
-	Score   int
-	Element *goquery.Selection
+	URL     string             `json:"url"`
+	Text    string             `json:"text"`
+	Score   int                `json:"score"`
+	Reasons []ScoreReason      `json:"reasons"`
+	Element *goquery.Selection `json:"-"`
+}
+
+type ScoreReason struct {
+	// Strategy is the nav strategy that gave the points.
+	Strategy string `json:"strategy"`
+	Reason   string `json:"reason"`
+	Points   int    `json:"points"`
 }
 
 func isURLsameSiteDiffPage(pageURL string, elemURL string) bool {
@@ -122,7 +127,8 @@ func isURLsameSiteDiffPage(pageURL string, elemURL string) bool {
 		return false
 	}
 
-	return elemU.Host == baseU.Host && elemU.Path != baseU.Path
+	// ?page=3 is another page than ?page=2.
+	return elemU.Host == baseU.Host && (elemU.Path != baseU.Path || elemU.RawQuery != baseU.RawQuery)
 }
 
 func getURLfromElem(s *goquery.Selection) string {
@@ -208,22 +214,20 @@ func resolveURL(elemURL string, baseURL string) string {
 	return resolved.String()
 }
 
-func scoreElement(s *goquery.Selection, patterns []string) int {
+func scoreElement(s *goquery.Selection, patterns []string) (int, []ScoreReason) {
 	score := 0
-	// debugInfo := []string{}
-
-	// Tier 1: Semantic Attributes (Highest Priority)
-	rel := strings.ToLower(s.AttrOr("rel", ""))
-	if rel == "next" || rel == "prev" {
-		score += 1000
-		// debugInfo = append(debugInfo, fmt.Sprintf("rel=%s (+1000)", rel))
+	var reasons []ScoreReason
+	add := func(points int, format string, args ...interface{}) {
+		score += points
+		reasons = append(reasons, ScoreReason{Reason: fmt.Sprintf(format, args...), Points: points})
 	}
 
+	// rel=next and rel=prev are scored by the rel strategy.
+
 	// Tier 2: Navigation Context (High Priority)
 	// Check if element is inside nav tag
 	if s.Closest("nav").Length() > 0 {
-		score += 500
-		// debugInfo = append(debugInfo, "inside nav (+500)")
+		add(500, "inside nav")
 	}
 
 	// Check for navigation classes
@@ -231,8 +235,7 @@ func scoreElement(s *goquery.Selection, patterns []string) int {
 	navClasses := []string{"nav-next", "nav-previous", "navigation", "pager", "pagination"}
 	for _, navClass := range navClasses {
 		if strings.Contains(class, navClass) {
-			score += 300
-			// debugInfo = append(debugInfo, fmt.Sprintf("nav class %s (+300)", navClass))
+			add(300, "nav class %s", navClass)
 			break
 		}
 	}
@@ -242,21 +245,19 @@ func scoreElement(s *goquery.Selection, patterns []string) int {
 	text := strings.ToLower(strings.TrimSpace(s.Text()))
 	if text != "" {
 		bestRatio := 0.0
-		// matchedPattern := ""
+		matchedPattern := ""
 		for _, pattern := range patterns {
 			pattern = strings.ToLower(strings.TrimSpace(pattern))
 			if strings.Contains(text, pattern) {
 				ratio := float64(len(pattern)) / float64(len(text))
 				if ratio > bestRatio {
 					bestRatio = ratio
-					// matchedPattern = pattern
+					matchedPattern = pattern
 				}
 			}
 		}
 		if bestRatio > 0 {
-			textScore := int(100 * bestRatio)
-			score += textScore
-			// debugInfo = append(debugInfo, fmt.Sprintf("text ratio %s in '%s' (+%d)", matchedPattern, text, textScore))
+			add(int(100*bestRatio), "text matches %q", matchedPattern)
 		}
 	}
 
@@ -269,7 +270,8 @@ func scoreElement(s *goquery.Selection, patterns []string) int {
 		strings.ToLower(s.AttrOr("alt", "")),
 	}
 
-	for _, field := range searchFields {
+	fieldNames := []string{"id", "class", "title", "aria-label", "alt"}
+	for i, field := range searchFields {
 		if field != "" {
 			bestRatio := 0.0
 			for _, pattern := range patterns {
@@ -282,9 +284,7 @@ func scoreElement(s *goquery.Selection, patterns []string) int {
 				}
 			}
 			if bestRatio > 0 {
-				attrScore := int(50 * bestRatio)
-				score += attrScore
-				// debugInfo = append(debugInfo, fmt.Sprintf("attr match (+%d)", attrScore))
+				add(int(50*bestRatio), "%s matches", fieldNames[i])
 			}
 		}
 	}
@@ -294,8 +294,7 @@ func scoreElement(s *goquery.Selection, patterns []string) int {
 	sidebarSelectors := []string{"#sidebar", "#secondary", ".widget-area", ".sidebar"}
 	for _, selector := range sidebarSelectors {
 		if s.Closest(selector).Length() > 0 {
-			score -= 200
-			// debugInfo = append(debugInfo, fmt.Sprintf("in sidebar %s (-200)", selector))
+			add(-200, "in sidebar %s", selector)
 			break
 		}
 	}
@@ -305,99 +304,9 @@ func scoreElement(s *goquery.Selection, patterns []string) int {
 	if parentList.Length() > 0 {
 		linkCount := parentList.Find("a").Length()
 		if linkCount > 10 { // Arbitrary threshold for "many links"
-			score -= 100
-			// debugInfo = append(debugInfo, fmt.Sprintf("large list %d links (-100)", linkCount))
-		}
-	}
-
-	// Debug logging
-	// href := s.AttrOr("href", "")
-	// if href != "" {
-	// 	log.Printf("SCORE: %d for %s | %s | %v", score, href, strings.Join(debugInfo, ", "), text)
-	// }
-
-	return score
-}
-
-func hasSemanticNavAttributes(s *goquery.Selection, direction string) bool {
-	rel := strings.ToLower(s.AttrOr("rel", ""))
-	if direction == "next" && rel == "next" {
-		return true
-	}
-	if direction == "prev" && rel == "prev" {
-		return true
-	}
-	return false
-}
-
-type Nav struct {
-	Next string
-	Prev string
-}
-
-func extractNav(htmlContent string, baseURL string) *Nav {
-	doc, err := goquery.NewDocumentFromReader(strings.NewReader(htmlContent))
-	if err != nil {
-		return &Nav{}
-	}
-
-	var nextCandidates []ScoredLink
-	var prevCandidates []ScoredLink
-
-	doc.Find(selector).Each(func(i int, s *goquery.Selection) {
-		elemURL := getURLfromElem(s)
-		if elemURL == "" || !isURLsameSiteDiffPage(baseURL, elemURL) {
-			return
-		}
-
-		resolvedURL := resolveURL(elemURL, baseURL)
-
-		// Check if it matches next patterns OR has semantic next attributes
-		if matchesPatterns(s, patternsNext) || hasSemanticNavAttributes(s, "next") {
-			score := scoreElement(s, patternsNext)
-			nextCandidates = append(nextCandidates, ScoredLink{
-				URL:     resolvedURL,
-				Score:   score,
-				Element: s,
-			})
-		}
-
-		// Check if it matches prev patterns OR has semantic prev attributes
-		if matchesPatterns(s, patternsPrev) || hasSemanticNavAttributes(s, "prev") {
-			score := scoreElement(s, patternsPrev)
-			prevCandidates = append(prevCandidates, ScoredLink{
-				URL:     resolvedURL,
-				Score:   score,
-				Element: s,
-			})
-		}
-	})
-
-	result := &Nav{}
-
-	// Find highest scoring next link
-	if len(nextCandidates) > 0 {
-		bestNext := nextCandidates[0]
-		for _, candidate := range nextCandidates[1:] {
-			if candidate.Score > bestNext.Score {
-				bestNext = candidate
-			}
-		}
-		result.Next = bestNext.URL
-		// log.Printf("SELECTED NEXT: %s (score: %d)", bestNext.URL, bestNext.Score)
-	}
-
-	// Find highest scoring prev link
-	if len(prevCandidates) > 0 {
-		bestPrev := prevCandidates[0]
-		for _, candidate := range prevCandidates[1:] {
-			if candidate.Score > bestPrev.Score {
-				bestPrev = candidate
-			}
+			add(-100, "in a list of %d links", linkCount)
 		}
-		result.Prev = bestPrev.URL
-		// log.Printf("SELECTED PREV: %s (score: %d)", bestPrev.URL, bestPrev.Score)
 	}
 
-	return result
+	return score, reasons
 }
@@ -0,0 +1,429 @@
+package core
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+	db "github.com/egemengol/kindlepathy/internal/db/generated"
+)
+
+// Extractors pull the readable content out of a page. Readability is the
+// default, the density extractor stands in when the sidecar is missing or
+// fails on a page; in comparison mode every extractor runs on each fetched page,
+// the results are stored, and the one the others agree with most wins.
+// Admins can pin the extractor of a domain.
+
+type Extractor interface {
+	Name() string
+	Extract(ctx context.Context, rawHTML, pageURL string) (*Clean, error)
+}
+
+// ExtractorNames are the extractors a domain can be pinned to.
+var ExtractorNames = []string{"readability", "density", "selector"}
+
+type readabilityExtractor struct {
+	client Readability
+}
+
+func (readabilityExtractor) Name() string { return "readability" }
+
+func (e readabilityExtractor) Extract(ctx context.Context, rawHTML, pageURL string) (*Clean, error) {
+	if e.client == nil {
+		return nil, ErrReadabilityUnavailable
+	}
+	parsed, err := e.client.Parse(ctx, rawHTML, pageURL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse document: %w", err)
+	}
+	return &Clean{
+		Title:         parsed.Title,
+		ContentHTML:   parsed.Content,
+		Byline:        parsed.Byline,
+		SiteName:      parsed.SiteName,
+		PublishedTime: parsed.PublishedTime,
+	}, nil
+}
+
+// densityExtractor picks the element with the most paragraph text, a
+// simple take on what readability does, without the sidecar.
+type densityExtractor struct{}
+
+func (densityExtractor) Name() string { return "density" }
+
+const densityParagraphs = "p, pre, blockquote"
+
+func (densityExtractor) Extract(ctx context.Context, rawHTML, pageURL string) (*Clean, error) {
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(rawHTML))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse page: %w", err)
+	}
+	title := pageTitle(doc)
+	doc.Find("script, style, noscript, nav, header, footer, aside, form, iframe").Remove()
+
+	paragraphText := func(s *goquery.Selection) int {
+		n := 0
+		s.ChildrenFiltered(densityParagraphs).Each(func(_ int, p *goquery.Selection) {
+			// Short paragraphs are bylines and captions more often than not.
+			if l := len(strings.TrimSpace(p.Text())); l >= 25 {
+				n += l
+			}
+		})
+		return n
+	}
+	var best *goquery.Selection
+	bestScore := 0.0
+	doc.Find("body, main, article, section, div, td").Each(func(_ int, s *goquery.Selection) {
+		text := paragraphText(s)
+		s.Children().Each(func(_ int, child *goquery.Selection) {
+			text += paragraphText(child) / 2
+		})
+		// Like readability, mostly links is a menu, and the class and id
+		// names tell the article from the comments around it.
+		score := float64(text) * (1 - linkDensity(s)) * nameWeight(s)
+		if score > bestScore {
+			best, bestScore = s, score
+		}
+	})
+	if best == nil {
+		return nil, fmt.Errorf("no paragraphs found")
+	}
+	best.Find("*").Each(func(_ int, s *goquery.Selection) {
+		if nameWeight(s) < 1 && linkDensity(s) > 0.3 {
+			s.Remove()
+		}
+	})
+	content, err := goquery.OuterHtml(best)
+	if err != nil {
+		return nil, fmt.Errorf("failed to render content: %w", err)
+	}
+	return &Clean{Title: title, ContentHTML: content}, nil
+}
+
+var (
+	contentNames = regexp.MustCompile(`(?i)article|content|entry|main|post|story|text|body`)
+	asideNames   = regexp.MustCompile(`(?i)comment|sidebar|footer|share|social|related|promo|sponsor|advert|widget|newsletter|popup|cookie`)
+)
+
+// nameWeight weighs an element by its class and id, above 1 for the names
+// of content and below for the names of what's around it.
+func nameWeight(s *goquery.Selection) float64 {
+	names := s.AttrOr("class", "") + " " + s.AttrOr("id", "")
+	switch {
+	case asideNames.MatchString(names):
+		return 0.5
+	case contentNames.MatchString(names):
+		return 1.25
+	}
+	return 1
+}
+
+// linkDensity is the share of the element's text in links.
+func linkDensity(s *goquery.Selection) float64 {
+	text := len(strings.TrimSpace(s.Text()))
+	if text == 0 {
+		return 0
+	}
+	links := 0
+	s.Find("a").Each(func(_ int, a *goquery.Selection) {
+		links += len(strings.TrimSpace(a.Text()))
+	})
+	return min(float64(links)/float64(text), 1)
+}
+
+func pageTitle(doc *goquery.Document) string {
+	if title, ok := doc.Find(`meta[property="og:title"]`).Attr("content"); ok && strings.TrimSpace(title) != "" {
+		return strings.TrimSpace(title)
+	}
+	if title := strings.TrimSpace(doc.Find("title").First().Text()); title != "" {
+		return title
+	}
+	return strings.TrimSpace(doc.Find("h1").First().Text())
+}
+
+// selectorExtractor takes the content by the selector of the domain's
+// selector-extract step.
+type selectorExtractor struct {
+	selector string
+}
+
+func (selectorExtractor) Name() string { return "selector" }
+
+func (e selectorExtractor) Extract(ctx context.Context, rawHTML, pageURL string) (*Clean, error) {
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(rawHTML))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse page: %w", err)
+	}
+	var content strings.Builder
+	doc.Find(e.selector).Each(func(_ int, s *goquery.Selection) {
+		if html, err := goquery.OuterHtml(s); err == nil {
+			content.WriteString(html)
+		}
+	})
+	if content.Len() == 0 {
+		return nil, fmt.Errorf("nothing matches %q", e.selector)
+	}
+	return &Clean{Title: pageTitle(doc), ContentHTML: content.String()}, nil
+}
+
+// extractors returns the extractors that can run on the page, the selector
+// one only if the domain's pipeline has a selector.
+func (c *Core) extractors(pageURL string) []Extractor {
+	extractors := []Extractor{readabilityExtractor{client: c.readabilityClient}, densityExtractor{}}
+	for _, p := range c.pipeline(pageURL) {
+		if s, ok := p.(selectorProcessor); ok && s.selector != "" {
+			extractors = append(extractors, selectorExtractor{selector: s.selector})
+			break
+		}
+	}
+	return extractors
+}
+
+// SetCompareExtractors turns comparison mode on or off.
+func (c *Core) SetCompareExtractors(compare bool) {
+	c.compareExtractors.Store(compare)
+}
+
+// extract runs the extractor pinned to the domain, or compares them all in
+// comparison mode, falling back to readability. The comparison results are
+// returned for storing.
+func (c *Core) extract(ctx context.Context, rawHTML, pageURL string) (*Clean, []ExtractorResult, error) {
+	if name, err := c.domainExtractor(ctx, pageURL); err != nil {
+		c.Logger.Warn("failed to get domain extractor", "error", err)
+	} else if name != "" {
+		for _, e := range c.extractors(pageURL) {
+			if e.Name() != name {
+				continue
+			}
+			clean, err := e.Extract(ctx, rawHTML, pageURL)
+			if err == nil {
+				return clean, nil, nil
+			}
+			c.Logger.Warn("pinned extractor failed, using readability", "extractor", name, "error", err, "url", pageURL)
+		}
+	}
+
+	if c.compareExtractors.Load() {
+		results, best := c.CompareExtractors(ctx, rawHTML, pageURL)
+		if best == nil {
+			return nil, results, fmt.Errorf("every extractor failed")
+		}
+		return best, results, nil
+	}
+
+	clean, err := readabilityExtractor{client: c.readabilityClient}.Extract(ctx, rawHTML, pageURL)
+	if err == nil {
+		return clean, nil, nil
+	}
+	// Without readability, or when it fails on the page, the density
+	// extractor still reads most articles.
+	if !errors.Is(err, ErrReadabilityUnavailable) {
+		c.Logger.Warn("readability failed, using the density extractor", "error", err, "url", pageURL)
+	}
+	clean, fallbackErr := densityExtractor{}.Extract(ctx, rawHTML, pageURL)
+	if fallbackErr != nil {
+		return nil, nil, errors.Join(err, fallbackErr)
+	}
+	return clean, nil, nil
+}
+
+func (c *Core) storeComparisons(ctx context.Context, pageURL string, results []ExtractorResult, now time.Time) {
+	for _, r := range results {
+		err := c.queries.ExtractionComparisonsAdd(ctx, db.ExtractionComparisonsAddParams{
+			Url:        pageURL,
+			Extractor:  r.Extractor,
+			Words:      int64(r.Words),
+			Similarity: r.Similarity,
+			DurationMs: r.DurationMs,
+			Error:      r.Error,
+			Picked:     r.Picked,
+			CreatedTs:  now.Unix(),
+		})
+		if err != nil {
+			c.Logger.Warn("failed to store extraction comparison", "error", err)
+		}
+	}
+}
+
+func (c *Core) domainExtractor(ctx context.Context, pageURL string) (string, error) {
+	u, err := url.Parse(pageURL)
+	if err != nil {
+		return "", nil
+	}
+	host := normalizeDomain(u.Hostname())
+	for host != "" {
+		name, err := c.queries.DomainExtractorsGet(ctx, host)
+		if err == nil {
+			return name, nil
+		}
+		if !errors.Is(err, sql.ErrNoRows) {
+			return "", err
+		}
+		_, parent, ok := strings.Cut(host, ".")
+		if !ok {
+			break
+		}
+		host = parent
+	}
+	return "", nil
+}
+
+type ExtractorResult struct {
+	Extractor string `json:"extractor"`
+	Words     int    `json:"words"`
+	// Similarity is the mean overlap of the words with the other results,
+	// 0 to 1.
+	Similarity float64 `json:"similarity"`
+	DurationMs int64   `json:"duration_ms"`
+	Error      string  `json:"error,omitempty"`
+	Picked     bool    `json:"picked"`
+}
+
+// CompareExtractors runs every extractor on the page and picks the best
+// result: long, and agreeing with the others, which keeps a menu or a
+// comment section from winning on length alone.
+func (c *Core) CompareExtractors(ctx context.Context, rawHTML, pageURL string) ([]ExtractorResult, *Clean) {
+	extractors := c.extractors(pageURL)
+	results := make([]ExtractorResult, len(extractors))
+	cleans := make([]*Clean, len(extractors))
+	words := make([]map[string]bool, len(extractors))
+	for i, e := range extractors {
+		start := time.Now()
+		clean, err := e.Extract(ctx, rawHTML, pageURL)
+		results[i] = ExtractorResult{Extractor: e.Name(), DurationMs: time.Since(start).Milliseconds()}
+		if err != nil {
+			results[i].Error = err.Error()
+			continue
+		}
+		cleans[i] = clean
+		words[i], results[i].Words = wordSet(clean.ContentHTML)
+	}
+
+	best := -1
+	bestScore := 0.0
+	for i := range results {
+		if cleans[i] == nil {
+			continue
+		}
+		total, others := 0.0, 0
+		for j := range results {
+			if j != i && cleans[j] != nil {
+				total += jaccard(words[i], words[j])
+				others++
+			}
+		}
+		if others > 0 {
+			results[i].Similarity = total / float64(others)
+		}
+		score := float64(results[i].Words) * (0.5 + results[i].Similarity)
+		if best < 0 || score > bestScore {
+			best, bestScore = i, score
+		}
+	}
+	if best < 0 {
+		return results, nil
+	}
+	results[best].Picked = true
+	return results, cleans[best]
+}
+
+func wordSet(html string) (map[string]bool, int) {
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(html))
+	if err != nil {
+		return nil, 0
+	}
+	fields := strings.Fields(strings.ToLower(doc.Text()))
+	set := make(map[string]bool, len(fields))
+	for _, f := range fields {
+		set[f] = true
+	}
+	return set, len(fields)
+}
+
+func jaccard(a, b map[string]bool) float64 {
+	if len(a) == 0 && len(b) == 0 {
+		return 0
+	}
+	shared := 0
+	for w := range a {
+		if b[w] {
+			shared++
+		}
+	}
+	return float64(shared) / float64(len(a)+len(b)-shared)
+}
+
+type DomainExtractor struct {
+	Domain    string
+	Extractor string
+}
+
+func (c *Core) ListDomainExtractors(ctx context.Context) ([]DomainExtractor, error) {
+	rows, err := c.queries.DomainExtractorsList(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list domain extractors: %w", err)
+	}
+	pinned := make([]DomainExtractor, len(rows))
+	for i, row := range rows {
+		pinned[i] = DomainExtractor{Domain: row.Domain, Extractor: row.Extractor}
+	}
+	return pinned, nil
+}
+
+// SetDomainExtractor pins the extractor of the domain, an empty name goes
+// back to the default.
+func (c *Core) SetDomainExtractor(ctx context.Context, domain, name string) error {
+	domain = normalizeDomain(domain)
+	if domain == "" || strings.ContainsAny(domain, "/: ") {
+		return fmt.Errorf("invalid domain: %q", domain)
+	}
+	if name == "" {
+		return c.queries.DomainExtractorsDelete(ctx, domain)
+	}
+	known := false
+	for _, n := range ExtractorNames {
+		known = known || n == name
+	}
+	if !known {
+		return fmt.Errorf("unknown extractor: %q", name)
+	}
+	return c.queries.DomainExtractorsSet(ctx, db.DomainExtractorsSetParams{Domain: domain, Extractor: name})
+}
+
+type ExtractionComparison struct {
+	URL        string
+	Extractor  string
+	Words      int64
+	Similarity float64
+	DurationMs int64
+	Error      string
+	Picked     bool
+	Time       time.Time
+}
+
+func (c *Core) ListExtractionComparisons(ctx context.Context, limit int) ([]ExtractionComparison, error) {
+	rows, err := c.queries.ExtractionComparisonsList(ctx, int64(limit))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list extraction comparisons: %w", err)
+	}
+	comparisons := make([]ExtractionComparison, len(rows))
+	for i, row := range rows {
+		comparisons[i] = ExtractionComparison{
+			URL:        row.Url,
+			Extractor:  row.Extractor,
+			Words:      row.Words,
+			Similarity: row.Similarity,
+			DurationMs: row.DurationMs,
+			Error:      row.Error,
+			Picked:     row.Picked,
+			Time:       time.Unix(row.CreatedTs, 0),
+		}
+	}
+	return comparisons, nil
+}
@@ -0,0 +1,402 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	db "github.com/egemengol/kindlepathy/internal/db/generated"
+	"golang.org/x/net/html/charset"
+)
+
+// Users follow RSS and Atom feeds here as well as through an RSS reader's
+// integration, each sync adding the entries new since the last one to the
+// library. The first sync of a feed only takes note of the entries it has,
+// following a feed doesn't bury the library under its archive. The feeds
+// move from and to RSS readers as OPML.
+
+const (
+	// MaxFeeds is the most feeds a user can follow, each is fetched on every
+	// sync.
+	MaxFeeds = 500
+	// maxOPMLFeeds is the most feeds of an OPML file looked at, an import
+	// can't add more than MaxFeeds anyway.
+	maxOPMLFeeds = 2 * MaxFeeds
+)
+
+// ErrTooManyFeeds is returned when following a feed over MaxFeeds.
+var ErrTooManyFeeds = fmt.Errorf("at most %d feeds can be followed", MaxFeeds)
+
+type Feed struct {
+	ID       int64
+	URL      string
+	Title    string
+	LastSync *time.Time
+}
+
+func feedFromRow(row db.Feed) Feed {
+	feed := Feed{
+		ID:    row.ID,
+		URL:   row.Url,
+		Title: row.Title,
+	}
+	if row.LastSyncTs != nil {
+		t := time.Unix(row.LastSyncTs.(int64), 0)
+		feed.LastSync = &t
+	}
+	return feed
+}
+
+// ListFeeds returns the feeds the user follows, by title.
+func (c *Core) ListFeeds(ctx context.Context, userID int64) ([]Feed, error) {
+	rows, err := c.queries.FeedsListPerUser(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list feeds: %w", err)
+	}
+	feeds := make([]Feed, 0, len(rows))
+	for _, row := range rows {
+		feeds = append(feeds, feedFromRow(row))
+	}
+	return feeds, nil
+}
+
+// AddFeed follows the feed at feedURL, returning false when the user
+// already did. The title is the feed's own after its first sync when empty.
+func (c *Core) AddFeed(ctx context.Context, userID int64, feedURL, title string, now time.Time) (bool, error) {
+	feedURL = strings.TrimSpace(feedURL)
+	u, err := url.Parse(feedURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return false, fmt.Errorf("invalid feed url: %q", feedURL)
+	}
+	count, err := c.queries.FeedsCountPerUser(ctx, userID)
+	if err != nil {
+		return false, fmt.Errorf("failed to count feeds: %w", err)
+	}
+	if count >= MaxFeeds {
+		return false, ErrTooManyFeeds
+	}
+	added, err := c.queries.FeedsAdd(ctx, db.FeedsAddParams{
+		UserID:    userID,
+		Url:       feedURL,
+		Title:     strings.TrimSpace(title),
+		CreatedTs: now.Unix(),
+	})
+	if err != nil {
+		return false, fmt.Errorf("failed to add feed: %w", err)
+	}
+	return added > 0, nil
+}
+
+// DeleteFeed stops following the feed, the items it added stay.
+func (c *Core) DeleteFeed(ctx context.Context, userID, feedID int64) error {
+	return c.queries.FeedsDelete(ctx, db.FeedsDeleteParams{ID: feedID, UserID: userID})
+}
+
+// SyncFeed runs a single sync of the feed right away.
+func (c *Core) SyncFeed(ctx context.Context, feedID int64, now time.Time) error {
+	feed, err := c.queries.FeedsGet(ctx, feedID)
+	if err != nil {
+		return fmt.Errorf("failed to get feed: %w", err)
+	}
+	return c.syncFeed(ctx, feed, now)
+}
+
+type syncFeedJob struct {
+	FeedID int64 `json:"feed_id"`
+}
+
+func (c *Core) syncFeed(ctx context.Context, feed db.Feed, now time.Time) error {
+	parsed, err := c.fetchFeed(ctx, feed.Url)
+	if err != nil {
+		return err
+	}
+	if parsed.Title != "" {
+		if err := c.queries.FeedsSetTitle(ctx, db.FeedsSetTitleParams{Title: parsed.Title, ID: feed.ID}); err != nil {
+			return fmt.Errorf("failed to set feed title: %w", err)
+		}
+	}
+
+	first := feed.LastSyncTs == nil
+	for _, entry := range parsed.Entries {
+		if err := c.addFeedEntry(ctx, feed, entry, !first, now); err != nil {
+			c.Logger.Warn("failed to add feed entry", "error", err, "feed", feed.Url, "url", entry.URL)
+		}
+	}
+
+	return c.queries.FeedsSetSynced(ctx, db.FeedsSetSyncedParams{
+		LastSyncTs: now.Unix(),
+		ID:         feed.ID,
+	})
+}
+
+// addFeedEntry adds the entry to the library unless it was seen before,
+// only taking note of it without add. Like the entries of integrations, an entry is
+// added once, deleting its item doesn't bring it back.
+func (c *Core) addFeedEntry(ctx context.Context, feed db.Feed, entry feedEntry, add bool, now time.Time) error {
+	seen, err := c.queries.FeedEntriesSeen(ctx, db.FeedEntriesSeenParams{FeedID: feed.ID, EntryID: entry.ID})
+	if err != nil {
+		return fmt.Errorf("failed to check entry: %w", err)
+	}
+	if seen != 0 {
+		return nil
+	}
+	if add {
+		if _, err := c.importBookmark(ctx, feed.UserID, bookmark{URL: entry.URL, Title: entry.Title, AddedAt: now}); err != nil {
+			return err
+		}
+	}
+	return c.queries.FeedEntriesAdd(ctx, db.FeedEntriesAddParams{FeedID: feed.ID, EntryID: entry.ID})
+}
+
+// feedEntry is an entry of a feed, ID being its guid or id, its link when
+// it has neither.
+type feedEntry struct {
+	ID    string
+	URL   string
+	Title string
+}
+
+type parsedFeed struct {
+	Title   string
+	Entries []feedEntry
+}
+
+// feedXML reads RSS 2.0, RSS 1.0 and Atom alike, they differ in where the
+// entries are and what their links look like.
+type feedXML struct {
+	XMLName xml.Name
+	// Atom has its title and entries at the root, RSS 1.0 its items.
+	Title   string         `xml:"title"`
+	Entries []feedXMLEntry `xml:"entry"`
+	Items   []feedXMLEntry `xml:"item"`
+	Channel struct {
+		Title string         `xml:"title"`
+		Items []feedXMLEntry `xml:"item"`
+	} `xml:"channel"`
+}
+
+type feedXMLEntry struct {
+	Title string `xml:"title"`
+	ID    string `xml:"id"`
+	GUID  string `xml:"guid"`
+	// RSS links are text, Atom links attributes, with alternate the page.
+	Links []struct {
+		Href string `xml:"href,attr"`
+		Rel  string `xml:"rel,attr"`
+		Text string `xml:",chardata"`
+	} `xml:"link"`
+}
+
+func (e feedXMLEntry) link() string {
+	for _, link := range e.Links {
+		if text := strings.TrimSpace(link.Text); text != "" {
+			return text
+		}
+		if link.Href != "" && (link.Rel == "" || link.Rel == "alternate") {
+			return strings.TrimSpace(link.Href)
+		}
+	}
+	return ""
+}
+
+// parseFeed reads the entries of an RSS or Atom feed, with their links made
+// absolute against the feed's URL.
+func parseFeed(data []byte, feedURL string) (*parsedFeed, error) {
+	decoder := xml.NewDecoder(bytes.NewReader(data))
+	decoder.CharsetReader = charset.NewReaderLabel
+	decoder.Strict = false
+	var doc feedXML
+	if err := decoder.Decode(&doc); err != nil {
+		return nil, fmt.Errorf("failed to parse feed: %w", err)
+	}
+
+	var title string
+	var entries []feedXMLEntry
+	switch doc.XMLName.Local {
+	case "feed":
+		title, entries = doc.Title, doc.Entries
+	case "rss":
+		title, entries = doc.Channel.Title, doc.Channel.Items
+	case "RDF":
+		title, entries = doc.Channel.Title, doc.Items
+	default:
+		return nil, fmt.Errorf("not an RSS or Atom feed: <%s>", doc.XMLName.Local)
+	}
+
+	parsed := &parsedFeed{Title: strings.Join(strings.Fields(title), " ")}
+	for _, e := range entries {
+		link := e.link()
+		if link == "" {
+			continue
+		}
+		entry := feedEntry{
+			ID:    strings.TrimSpace(e.ID),
+			URL:   resolveURL(link, feedURL),
+			Title: strings.Join(strings.Fields(e.Title), " "),
+		}
+		if entry.ID == "" {
+			entry.ID = strings.TrimSpace(e.GUID)
+		}
+		if entry.ID == "" {
+			entry.ID = entry.URL
+		}
+		parsed.Entries = append(parsed.Entries, entry)
+	}
+	return parsed, nil
+}
+
+// maxFeedBytes is the largest feed read, a feed with its entries' content
+// is larger than a page but not by much.
+const maxFeedBytes = 10 << 20
+
+// fetchFeed gets and parses the feed. Feeds aren't pages, they're fetched
+// with the User-Agent of pages but not read as HTML.
+func (c *Core) fetchFeed(ctx context.Context, feedURL string) (*parsedFeed, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, feedURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("User-Agent", DefaultUserAgent)
+	req.Header.Set("Accept", "application/rss+xml, application/atom+xml, application/xml;q=0.9, */*;q=0.8")
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch feed: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("feed returned status %d", resp.StatusCode)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxFeedBytes+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read feed: %w", err)
+	}
+	if len(data) > maxFeedBytes {
+		return nil, fmt.Errorf("feed is larger than %d MB", maxFeedBytes>>20)
+	}
+	return parseFeed(data, feedURL)
+}
+
+// OPML is the outline format RSS readers import and export their feeds in,
+// a feed being an outline with an xmlUrl, in outlines standing for folders
+// or not.
+type opml struct {
+	XMLName xml.Name `xml:"opml"`
+	Version string   `xml:"version,attr"`
+	Head    struct {
+		Title       string `xml:"title"`
+		DateCreated string `xml:"dateCreated,omitempty"`
+	} `xml:"head"`
+	Body struct {
+		Outlines []opmlOutline `xml:"outline"`
+	} `xml:"body"`
+}
+
+type opmlOutline struct {
+	Text     string        `xml:"text,attr"`
+	Title    string        `xml:"title,attr,omitempty"`
+	Type     string        `xml:"type,attr,omitempty"`
+	XMLURL   string        `xml:"xmlUrl,attr,omitempty"`
+	HTMLURL  string        `xml:"htmlUrl,attr,omitempty"`
+	Outlines []opmlOutline `xml:"outline"`
+}
+
+// ErrNotOPML is returned for imports that aren't OPML.
+var ErrNotOPML = errors.New("not an OPML file")
+
+// errStopImport ends the walk of an OPML file at the feed caps.
+var errStopImport = errors.New("stop import")
+
+// ImportOPML follows the feeds of an OPML export, of any folder. Feeds
+// already followed aren't counted as imported. Past maxOPMLFeeds, or once
+// the user follows MaxFeeds, the rest of the file is left out.
+func (c *Core) ImportOPML(ctx context.Context, userID int64, r io.Reader, now time.Time) (*ImportResult, error) {
+	decoder := xml.NewDecoder(r)
+	decoder.CharsetReader = charset.NewReaderLabel
+	decoder.Strict = false
+	var doc opml
+	if err := decoder.Decode(&doc); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrNotOPML, err)
+	}
+
+	result := &ImportResult{}
+	seen := 0
+	var walk func(outlines []opmlOutline) error
+	walk = func(outlines []opmlOutline) error {
+		for _, outline := range outlines {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			if outline.XMLURL != "" {
+				if seen++; seen > maxOPMLFeeds {
+					result.fail(outline.XMLURL, fmt.Errorf("only the first %d feeds of a file are imported", maxOPMLFeeds))
+					return errStopImport
+				}
+				title := outline.Title
+				if title == "" {
+					title = outline.Text
+				}
+				added, err := c.AddFeed(ctx, userID, outline.XMLURL, title, now)
+				if errors.Is(err, ErrTooManyFeeds) {
+					result.fail(outline.XMLURL, err)
+					return errStopImport
+				}
+				if err != nil {
+					result.fail(outline.XMLURL, err)
+				} else if added {
+					result.Imported++
+				}
+			}
+			if err := walk(outline.Outlines); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if err := walk(doc.Body.Outlines); err != nil && !errors.Is(err, errStopImport) {
+		return result, err
+	}
+	c.Logger.Info("imported feeds", "userID", userID, "imported", result.Imported, "failed", len(result.Failed))
+	return result, nil
+}
+
+// ExportOPML writes the feeds the user follows as OPML 2.0.
+func (c *Core) ExportOPML(ctx context.Context, userID int64, w io.Writer, now time.Time) error {
+	feeds, err := c.ListFeeds(ctx, userID)
+	if err != nil {
+		return err
+	}
+	doc := opml{Version: "2.0"}
+	doc.Head.Title = "Kindlepathy feeds"
+	doc.Head.DateCreated = now.UTC().Format(time.RFC1123Z)
+	for _, feed := range feeds {
+		text := feed.Title
+		if text == "" {
+			text = feed.URL
+		}
+		doc.Body.Outlines = append(doc.Body.Outlines, opmlOutline{
+			Text:   text,
+			Title:  feed.Title,
+			Type:   "rss",
+			XMLURL: feed.URL,
+		})
+	}
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	encoder := xml.NewEncoder(w)
+	encoder.Indent("", "  ")
+	if err := encoder.Encode(doc); err != nil {
+		return fmt.Errorf("failed to write OPML: %w", err)
+	}
+	_, err = io.WriteString(w, "\n")
+	return err
+}
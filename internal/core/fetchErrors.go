@@ -0,0 +1,224 @@
+package core
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"time"
+
+	db "github.com/egemengol/kindlepathy/internal/db/generated"
+)
+
+// FetchErrorKind tells why a page couldn't be read, so the reader can be
+// told something better than "Internal server error".
+type FetchErrorKind string
+
+const (
+	FetchErrorDNS       FetchErrorKind = "dns"
+	FetchErrorTimeout   FetchErrorKind = "timeout"
+	FetchErrorForbidden FetchErrorKind = "forbidden"
+	FetchErrorNotFound  FetchErrorKind = "not-found"
+	FetchErrorPaywall   FetchErrorKind = "paywall"
+	FetchErrorParse     FetchErrorKind = "parse-failed"
+	FetchErrorArchive   FetchErrorKind = "not-archived"
+	FetchErrorTooLarge  FetchErrorKind = "too-large"
+	FetchErrorNotHTML   FetchErrorKind = "not-html"
+	FetchErrorRobots    FetchErrorKind = "robots"
+	FetchErrorOther     FetchErrorKind = "other"
+)
+
+type FetchError struct {
+	Kind FetchErrorKind
+	URL  string
+	// Status is the HTTP status of the response, 0 if there was none.
+	Status int
+	Err    error
+}
+
+func (e *FetchError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *FetchError) Unwrap() error {
+	return e.Err
+}
+
+// Reason is the error for readers.
+func (e *FetchError) Reason() string {
+	switch e.Kind {
+	case FetchErrorDNS:
+		return "The site's address couldn't be found. It may be down, or the link may have a typo."
+	case FetchErrorTimeout:
+		return "The site took too long to answer."
+	case FetchErrorForbidden:
+		return "The site refused to serve the page, it may block readers like this one."
+	case FetchErrorNotFound:
+		return "The page doesn't exist anymore."
+	case FetchErrorPaywall:
+		return "The page looks paywalled, only a teaser came through."
+	case FetchErrorParse:
+		return "The page was fetched, but no readable content could be found in it."
+	case FetchErrorArchive:
+		return "The archive has no copy of the page."
+	case FetchErrorTooLarge:
+		return "The page is too large to read."
+	case FetchErrorNotHTML:
+		return "The link isn't a web page, it may be a PDF, an image or a download."
+	case FetchErrorRobots:
+		return "The site asks bots not to fetch the page in its robots.txt, and this server respects it."
+	}
+	if e.Status != 0 {
+		return fmt.Sprintf("The site answered with an error (%d).", e.Status)
+	}
+	return "The page couldn't be fetched."
+}
+
+// classifyFetchError wraps an error of fetching the page.
+func classifyFetchError(pageURL string, err error) *FetchError {
+	var fetchErr *FetchError
+	if errors.As(err, &fetchErr) {
+		return fetchErr
+	}
+	kind := FetchErrorOther
+	var dnsErr *net.DNSError
+	var netErr net.Error
+	switch {
+	case errors.As(err, &dnsErr):
+		kind = FetchErrorDNS
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &netErr) && netErr.Timeout():
+		kind = FetchErrorTimeout
+	}
+	return &FetchError{Kind: kind, URL: pageURL, Err: err}
+}
+
+func statusFetchError(pageURL string, status int) *FetchError {
+	kind := FetchErrorOther
+	switch status {
+	case http.StatusUnauthorized, http.StatusForbidden, http.StatusTooManyRequests:
+		kind = FetchErrorForbidden
+	case http.StatusNotFound, http.StatusGone:
+		kind = FetchErrorNotFound
+	case http.StatusPaymentRequired:
+		kind = FetchErrorPaywall
+	}
+	return &FetchError{
+		Kind:   kind,
+		URL:    pageURL,
+		Status: status,
+		Err:    fmt.Errorf("non-200 response fetching url: %d", status),
+	}
+}
+
+var paywallMarkers = regexp.MustCompile(`(?i)"isAccessibleForFree"\s*:\s*"?false|class="[^"]*paywall|subscribe to (continue|keep) reading|subscribers only`)
+
+// paywallMinWords is the length below which content from a page with
+// paywall markers is taken for a teaser.
+const paywallMinWords = 150
+
+// suspectPaywall tells whether the cleaned content looks like the teaser
+// of a paywalled page.
+func suspectPaywall(rawHTML string, clean *Clean) bool {
+	if !paywallMarkers.MatchString(rawHTML) {
+		return false
+	}
+	_, words := wordSet(clean.ContentHTML)
+	return words < paywallMinWords
+}
+
+// ReadItemFromArchive reads the latest Wayback Machine snapshot of the item,
+// for pages that are gone or blocked.
+func (c *Core) ReadItemFromArchive(ctx context.Context, itemID int64, now time.Time) (*Clean, error) {
+	item, err := c.queries.ItemsGet(ctx, itemID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get item: %w", err)
+	}
+	if _, err := c.queries.ItemsGetUrlSetRead(ctx, db.ItemsGetUrlSetReadParams{ReadTs: now.Unix(), ID: itemID}); err != nil {
+		return nil, fmt.Errorf("failed to mark item as read: %w", err)
+	}
+
+	archived, err := c.archivedClean(ctx, item.Url)
+	if err != nil {
+		return nil, err
+	}
+	return c.preRender(ctx, item.Url, archived)
+}
+
+// archivedClean reads the latest snapshot of the page.
+func (c *Core) archivedClean(ctx context.Context, pageURL string) (*Clean, error) {
+	snapshot, err := c.archiveSnapshot(ctx, pageURL)
+	if err != nil {
+		return nil, err
+	}
+	clean, err := c.getAndCleanCached(ctx, snapshot, "archive", time.Hour)
+	if err != nil {
+		return nil, err
+	}
+	// The nav links point into the archive, back to the site they go.
+	archived := *clean
+	archived.NavNext = unarchiveURL(clean.NavNext)
+	archived.NavPrev = unarchiveURL(clean.NavPrev)
+	archived.NavNextAlternatives = unarchiveLinks(clean.NavNextAlternatives)
+	archived.NavPrevAlternatives = unarchiveLinks(clean.NavPrevAlternatives)
+	return &archived, nil
+}
+
+// archiveSnapshot finds the latest snapshot of the page.
+func (c *Core) archiveSnapshot(ctx context.Context, pageURL string) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", "https://archive.org/wayback/available?url="+url.QueryEscape(pageURL), nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to create GET request: %w", err)
+	}
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return "", classifyFetchError(pageURL, fmt.Errorf("failed to reach the archive: %w", err))
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", statusFetchError(pageURL, resp.StatusCode)
+	}
+
+	var available struct {
+		ArchivedSnapshots struct {
+			Closest struct {
+				Available bool   `json:"available"`
+				URL       string `json:"url"`
+				Timestamp string `json:"timestamp"`
+			} `json:"closest"`
+		} `json:"archived_snapshots"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&available); err != nil {
+		return "", fmt.Errorf("failed to decode archive response: %w", err)
+	}
+	closest := available.ArchivedSnapshots.Closest
+	if !closest.Available || closest.Timestamp == "" {
+		return "", &FetchError{Kind: FetchErrorArchive, URL: pageURL, Err: fmt.Errorf("no archived copy of %s", pageURL)}
+	}
+	// if_ leaves the archive's toolbar out, but still points the links
+	// into the archive, unarchiveURL takes them back.
+	return "https://web.archive.org/web/" + closest.Timestamp + "if_/" + pageURL, nil
+}
+
+var archivedURL = regexp.MustCompile(`^https?://web\.archive\.org/web/\d+[a-z_]*/(https?://.+)$`)
+
+func unarchiveURL(u string) string {
+	if m := archivedURL.FindStringSubmatch(u); m != nil {
+		return m[1]
+	}
+	return u
+}
+
+func unarchiveLinks(links []NavLink) []NavLink {
+	if links == nil {
+		return nil
+	}
+	unarchived := make([]NavLink, len(links))
+	for i, link := range links {
+		unarchived[i] = NavLink{URL: unarchiveURL(link.URL), Text: link.Text}
+	}
+	return unarchived
+}
@@ -0,0 +1,111 @@
+package core
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Many sites answer Go's default User-Agent with a 403. Pages are fetched
+// with a browser's User-Agent instead, and operators can set the headers of
+// every fetch with FETCH_USER_AGENT and FETCH_HEADERS, and the headers of a
+// site with the header processor in its pipeline:
+//
+//	{
+//	  "example.com": ["header(User-Agent: Mozilla/5.0 ...)", "header(Referer: https://example.com/)", "extract-nav", "absolute-images"]
+//	}
+//
+// Site headers are set after the global ones, they win.
+
+// DefaultUserAgent is the User-Agent of fetches when none is configured.
+const DefaultUserAgent = "Mozilla/5.0 (X11; Linux x86_64; rv:128.0) Gecko/20100101 Firefox/128.0"
+
+var defaultFetchHeaders = http.Header{
+	"User-Agent":      {DefaultUserAgent},
+	"Accept":          {"text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8"},
+	"Accept-Language": {"en-US,en;q=0.5"},
+}
+
+// ParseFetchHeaders reads headers from a JSON object of names to values, like
+// {"Accept-Language": "de-DE"}.
+func ParseFetchHeaders(data string) (http.Header, error) {
+	var raw map[string]string
+	if err := json.Unmarshal([]byte(data), &raw); err != nil {
+		return nil, fmt.Errorf("invalid headers: %w", err)
+	}
+	headers := make(http.Header, len(raw))
+	for name, value := range raw {
+		if err := validHeader(name, value); err != nil {
+			return nil, err
+		}
+		headers.Set(name, value)
+	}
+	return headers, nil
+}
+
+// SetFetchHeaders sets headers of every fetch on top of the defaults, an
+// empty value drops a default header.
+func (c *Core) SetFetchHeaders(headers http.Header) {
+	merged := defaultFetchHeaders.Clone()
+	for name, values := range headers {
+		if len(values) == 0 || values[0] == "" {
+			merged.Del(name)
+			continue
+		}
+		merged[name] = values
+	}
+	c.fetchHeaders = merged
+}
+
+// setFetchHeaders puts the global headers on a request to a site.
+func (c *Core) setFetchHeaders(req *http.Request) {
+	headers := c.fetchHeaders
+	if headers == nil {
+		headers = defaultFetchHeaders
+	}
+	for name, values := range headers {
+		req.Header[name] = values
+	}
+}
+
+func validHeader(name, value string) error {
+	if name == "" || strings.ContainsAny(name, " \t\r\n:") {
+		return fmt.Errorf("invalid header name: %q", name)
+	}
+	if strings.ContainsAny(value, "\r\n") {
+		return fmt.Errorf("invalid value of header %s", name)
+	}
+	return nil
+}
+
+// headerProcessor sets a header on the site's requests, its argument is
+// "Name: value".
+type headerProcessor struct {
+	NopProcessor
+	name  string
+	value string
+}
+
+func (headerProcessor) Name() string { return "header" }
+
+func (headerProcessor) Configure(arg string) (Processor, error) {
+	name, value, ok := strings.Cut(arg, ":")
+	if !ok {
+		return nil, fmt.Errorf("header %q is not Name: value", arg)
+	}
+	name, value = strings.TrimSpace(name), strings.TrimSpace(value)
+	if err := validHeader(name, value); err != nil {
+		return nil, err
+	}
+	return headerProcessor{name: http.CanonicalHeaderKey(name), value: value}, nil
+}
+
+func (p headerProcessor) PreFetch(ctx context.Context, req *http.Request) error {
+	if p.name == "" {
+		return nil
+	}
+	req.Header.Set(p.name, p.value)
+	return nil
+}
@@ -0,0 +1,331 @@
+package core
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Instances prefetching chapters and polling feeds fetch a lot from the
+// same few sites. Operators can make the page fetches polite: pages the
+// site's robots.txt disallows aren't fetched, requests to a host start at
+// least an interval apart, its Crawl-delay when it's longer, and a host
+// gets only so many requests at once. The policy is off unless configured,
+// the pages are fetched for a reader who asked for them.
+
+// FetchPolicy is how politely pages are fetched.
+type FetchPolicy struct {
+	// Robots makes the pages robots.txt disallows fail, and its
+	// Crawl-delay the least time between requests to the site.
+	Robots bool
+	// HostInterval is the least time between the requests to a host, 0
+	// for none.
+	HostInterval time.Duration
+	// HostConcurrency is how many requests a host gets at once, 0 for no
+	// limit.
+	HostConcurrency int
+}
+
+const (
+	// robotsAgent is the product token the groups of robots.txt are
+	// matched against, "*" otherwise.
+	robotsAgent = "kindlepathy"
+	// robotsTTL is how long a robots.txt is used before it's fetched
+	// again, robotsRetryTTL when the site failed to serve it.
+	robotsTTL      = 24 * time.Hour
+	robotsRetryTTL = time.Hour
+	// robotsMaxBytes is how much of a robots.txt is read.
+	robotsMaxBytes = 512 << 10
+	// maxCrawlDelay caps the Crawl-delay of a site, readers wait on it.
+	maxCrawlDelay = 10 * time.Second
+)
+
+// SetFetchPolicy sets how politely pages are fetched.
+func (c *Core) SetFetchPolicy(policy FetchPolicy) {
+	c.fetchHostsMu.Lock()
+	defer c.fetchHostsMu.Unlock()
+	c.fetchPolicy = policy
+	c.fetchHosts = nil
+}
+
+// fetchHost is what's known of a host the pages are fetched from.
+type fetchHost struct {
+	// slots holds a value for each request to the host in flight, nil
+	// when there's no limit.
+	slots chan struct{}
+
+	mu sync.Mutex
+	// next is when the next request to the host may start.
+	next time.Time
+
+	robotsMu      sync.Mutex
+	robots        *robotsRules
+	robotsExpires time.Time
+}
+
+// doPolitely sends a request for a page through the fetch policy. The
+// response body must be closed, the host's slot is taken until then.
+func (c *Core) doPolitely(req *http.Request) (*http.Response, error) {
+	policy, host := c.fetchHost(req.URL)
+	interval := policy.HostInterval
+	if policy.Robots {
+		rules := c.hostRobots(req.Context(), host, req.URL)
+		if !rules.allowed(req.URL) {
+			return nil, &FetchError{Kind: FetchErrorRobots, URL: req.URL.String(), Err: fmt.Errorf("disallowed by robots.txt")}
+		}
+		interval = max(interval, rules.crawlDelay)
+	}
+
+	release, err := host.wait(req.Context(), interval)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		release()
+		return nil, err
+	}
+	resp.Body = &releasingBody{ReadCloser: resp.Body, release: release}
+	return resp, nil
+}
+
+// fetchHost returns the policy and the state of the host of u.
+func (c *Core) fetchHost(u *url.URL) (FetchPolicy, *fetchHost) {
+	key := strings.ToLower(u.Host)
+	c.fetchHostsMu.Lock()
+	defer c.fetchHostsMu.Unlock()
+	if c.fetchHosts == nil {
+		c.fetchHosts = map[string]*fetchHost{}
+	}
+	host, ok := c.fetchHosts[key]
+	if !ok {
+		host = &fetchHost{}
+		if c.fetchPolicy.HostConcurrency > 0 {
+			host.slots = make(chan struct{}, c.fetchPolicy.HostConcurrency)
+		}
+		c.fetchHosts[key] = host
+	}
+	return c.fetchPolicy, host
+}
+
+// wait takes a slot of the host and waits for its turn, returning the
+// function giving the slot back.
+func (h *fetchHost) wait(ctx context.Context, interval time.Duration) (func(), error) {
+	if h.slots != nil {
+		select {
+		case h.slots <- struct{}{}:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	var once sync.Once
+	release := func() {
+		once.Do(func() {
+			if h.slots != nil {
+				<-h.slots
+			}
+		})
+	}
+	if interval <= 0 {
+		return release, nil
+	}
+
+	h.mu.Lock()
+	now := time.Now()
+	start := now
+	if h.next.After(now) {
+		start = h.next
+	}
+	h.next = start.Add(interval)
+	h.mu.Unlock()
+	if start.Equal(now) {
+		return release, nil
+	}
+	timer := time.NewTimer(start.Sub(now))
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return release, nil
+	case <-ctx.Done():
+		release()
+		return nil, ctx.Err()
+	}
+}
+
+type releasingBody struct {
+	io.ReadCloser
+	release func()
+}
+
+func (b *releasingBody) Close() error {
+	defer b.release()
+	return b.ReadCloser.Close()
+}
+
+// hostRobots returns the rules of the host's robots.txt, fetching it when
+// it isn't known or is old.
+func (c *Core) hostRobots(ctx context.Context, host *fetchHost, u *url.URL) *robotsRules {
+	host.robotsMu.Lock()
+	defer host.robotsMu.Unlock()
+	if host.robots != nil && time.Now().Before(host.robotsExpires) {
+		return host.robots
+	}
+	robotsURL := (&url.URL{Scheme: u.Scheme, Host: u.Host, Path: "/robots.txt"}).String()
+	rules, err := c.fetchRobots(ctx, robotsURL)
+	ttl := robotsTTL
+	if err != nil {
+		// A site failing to serve its robots.txt doesn't keep its
+		// readers from its pages.
+		c.Logger.Warn("failed to fetch robots.txt", "error", err, "url", robotsURL)
+		rules, ttl = &robotsRules{}, robotsRetryTTL
+	}
+	host.robots, host.robotsExpires = rules, time.Now().Add(ttl)
+	return rules
+}
+
+// fetchRobots fetches and parses a robots.txt, a site without one allows
+// everything.
+func (c *Core) fetchRobots(ctx context.Context, robotsURL string) (*robotsRules, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", robotsURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create GET request: %w", err)
+	}
+	c.setFetchHeaders(req)
+	req.Header.Set("Accept", "text/plain")
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	switch {
+	case resp.StatusCode == http.StatusOK:
+		return parseRobots(io.LimitReader(resp.Body, robotsMaxBytes), robotsAgent)
+	case resp.StatusCode >= 400 && resp.StatusCode < 500:
+		return &robotsRules{}, nil
+	}
+	return nil, fmt.Errorf("non-200 response fetching robots.txt: %d", resp.StatusCode)
+}
+
+// robotsRules are the rules of a robots.txt for an agent.
+type robotsRules struct {
+	rules      []robotsRule
+	crawlDelay time.Duration
+}
+
+type robotsRule struct {
+	allow   bool
+	pattern string
+	match   *regexp.Regexp
+}
+
+// allowed tells whether the rules allow the page. The rule with the longest
+// pattern matching it wins, allowing on a tie, as in RFC 9309.
+func (r *robotsRules) allowed(u *url.URL) bool {
+	path := u.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	if path == "/robots.txt" {
+		return true
+	}
+	if u.RawQuery != "" {
+		path += "?" + u.RawQuery
+	}
+	allow, longest := true, -1
+	for _, rule := range r.rules {
+		if !rule.match.MatchString(path) {
+			continue
+		}
+		if n := len(rule.pattern); n > longest || n == longest && rule.allow {
+			allow, longest = rule.allow, n
+		}
+	}
+	return allow
+}
+
+// parseRobots reads the rules of the groups of a robots.txt for agent, or
+// of the "*" groups when none names it.
+func parseRobots(r io.Reader, agent string) (*robotsRules, error) {
+	var named, wildcard robotsRules
+	// A group naming the agent wins even when it allows everything.
+	namedGroup := false
+	var groupAgents []string
+	inRules := false
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64<<10), robotsMaxBytes)
+	for scanner.Scan() {
+		line, _, _ := strings.Cut(scanner.Text(), "#")
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		key, value = strings.ToLower(strings.TrimSpace(key)), strings.TrimSpace(value)
+		if key == "user-agent" {
+			// A user-agent after rules starts a new group.
+			if inRules {
+				groupAgents, inRules = nil, false
+			}
+			groupAgents = append(groupAgents, strings.ToLower(value))
+			continue
+		}
+		if len(groupAgents) == 0 {
+			continue
+		}
+		inRules = true
+		for _, a := range groupAgents {
+			var into *robotsRules
+			switch a {
+			case agent:
+				into, namedGroup = &named, true
+			case "*":
+				into = &wildcard
+			default:
+				continue
+			}
+			into.add(key, value)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read robots.txt: %w", err)
+	}
+	if namedGroup {
+		return &named, nil
+	}
+	return &wildcard, nil
+}
+
+// add adds a line of a group to the rules.
+func (r *robotsRules) add(key, value string) {
+	switch key {
+	case "allow", "disallow":
+		// An empty Disallow disallows nothing.
+		if value == "" {
+			return
+		}
+		r.rules = append(r.rules, robotsRule{allow: key == "allow", pattern: value, match: robotsPattern(value)})
+	case "crawl-delay":
+		if seconds, err := strconv.ParseFloat(value, 64); err == nil && seconds > 0 {
+			r.crawlDelay = min(time.Duration(seconds*float64(time.Second)), maxCrawlDelay)
+		}
+	}
+}
+
+// robotsPattern compiles a path pattern of robots.txt, where * matches
+// anything and a trailing $ the end of the path.
+func robotsPattern(pattern string) *regexp.Regexp {
+	end := strings.HasSuffix(pattern, "$")
+	pattern = strings.TrimSuffix(pattern, "$")
+	expr := "^" + strings.ReplaceAll(regexp.QuoteMeta(pattern), `\*`, ".*")
+	if end {
+		expr += "$"
+	}
+	return regexp.MustCompile(expr)
+}
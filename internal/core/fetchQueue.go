@@ -0,0 +1,170 @@
+package core
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	db "github.com/egemengol/kindlepathy/internal/db/generated"
+)
+
+// Items added by URL are fetched in the background, so adding doesn't wait
+// on the site: an item is pending until a worker claims it, fetching while
+// the worker cleans it, then ready, or failed and retried later. The queue
+// lives in the items table and survives restarts.
+
+const (
+	ItemPending  = "pending"
+	ItemFetching = "fetching"
+	ItemReady    = "ready"
+	ItemFailed   = "failed"
+)
+
+// fetchRetryDelays are the waits before retrying a failed fetch, the item
+// stays failed after the last one.
+var fetchRetryDelays = []time.Duration{time.Minute, 10 * time.Minute, time.Hour}
+
+// fetchQueuePoll is how often idle workers look for retries that are due.
+const fetchQueuePoll = 15 * time.Second
+
+// fetchQueueTTL keeps the content long enough for a large import to be read
+// without refetching.
+const fetchQueueTTL = 24 * time.Hour
+
+// enqueueFetch schedules the item to be fetched now.
+func (c *Core) enqueueFetch(ctx context.Context, itemID int64, now time.Time) error {
+	err := c.queries.ItemsEnqueueFetch(ctx, db.ItemsEnqueueFetchParams{
+		NextFetchTs: now.Unix(),
+		ID:          itemID,
+	})
+	if err != nil {
+		return err
+	}
+	select {
+	case c.fetchWake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// RetryItem fetches a failed item again right away, dropping its screenshot
+// to give extraction another chance.
+func (c *Core) RetryItem(ctx context.Context, itemID int64, now time.Time) error {
+	if err := c.queries.ItemScreenshotsDelete(ctx, itemID); err != nil {
+		return fmt.Errorf("failed to delete screenshot: %w", err)
+	}
+	return c.enqueueFetch(ctx, itemID, now)
+}
+
+// RunFetchQueue fetches queued items with the given number of workers until
+// ctx is cancelled.
+func (c *Core) RunFetchQueue(ctx context.Context, workers int) {
+	// Fetches cut short by a restart start over.
+	if err := c.queries.ItemsResetFetching(ctx); err != nil {
+		c.Logger.Error("failed to reset interrupted fetches", "error", err)
+	}
+
+	var wg sync.WaitGroup
+	for range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ticker := time.NewTicker(fetchQueuePoll)
+			defer ticker.Stop()
+			for {
+				for c.fetchNext(ctx, time.Now()) {
+				}
+				select {
+				case <-ctx.Done():
+					return
+				case <-c.fetchWake:
+				case <-ticker.C:
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// fetchNext fetches the next due item, telling whether there was one.
+func (c *Core) fetchNext(ctx context.Context, now time.Time) bool {
+	item, err := c.queries.ItemsClaimFetch(ctx, now.Unix())
+	if errors.Is(err, sql.ErrNoRows) {
+		return false
+	}
+	if err != nil {
+		if ctx.Err() == nil {
+			c.Logger.Error("failed to claim item to fetch", "error", err)
+		}
+		return false
+	}
+
+	prefix, err := c.itemCachePrefix(ctx, item)
+	if err != nil {
+		c.fetchFailed(ctx, item, err, now)
+		return true
+	}
+	clean, err := c.getAndCleanCached(withFetchUser(ctx, item.UserID), item.Url, prefix, fetchQueueTTL)
+	var fetchErr *FetchError
+	unreadable := errors.As(err, &fetchErr) && fetchErr.Kind == FetchErrorParse || err == nil && clean.Degraded
+	if unreadable && c.screenshotter != nil {
+		// The page is there but unreadable, a screenshot at least keeps
+		// more of it than its text.
+		shotErr := c.CaptureItemScreenshot(ctx, item.ID, now)
+		if shotErr == nil {
+			return true
+		}
+		c.Logger.Warn("failed to take screenshot", "error", shotErr, "itemID", item.ID)
+	}
+	if err != nil {
+		c.fetchFailed(ctx, item, err, now)
+		return true
+	}
+	// Imported items keep the title of the export.
+	title := clean.Title
+	if item.Title == nil {
+		_, err = c.queries.ItemsUpdateTitle(ctx, db.ItemsUpdateTitleParams{
+			Title: clean.Title,
+			ID:    item.ID,
+		})
+		if err != nil {
+			c.Logger.Warn("failed to update item title", "error", err, "itemID", item.ID)
+		}
+	} else if t, ok := item.Title.(string); ok {
+		title = t
+	}
+	c.indexItem(ctx, item.ID, title, clean.ContentHTML)
+	c.setItemMetadata(ctx, item.ID, clean)
+	if err := c.queries.ItemsFetchDone(ctx, item.ID); err != nil {
+		c.Logger.Error("failed to mark item fetched", "error", err, "itemID", item.ID)
+	}
+	return true
+}
+
+func (c *Core) fetchFailed(ctx context.Context, item db.Item, fetchErr error, now time.Time) {
+	reason := fetchErr.Error()
+	retry := item.FetchAttempts < int64(len(fetchRetryDelays))
+	var fe *FetchError
+	if errors.As(fetchErr, &fe) {
+		reason = fe.Reason()
+		// Gone pages don't come back.
+		retry = retry && fe.Kind != FetchErrorNotFound
+	}
+	var next interface{}
+	if retry {
+		next = now.Add(fetchRetryDelays[item.FetchAttempts]).Unix()
+	}
+	c.Logger.Warn("failed to fetch item", "error", fetchErr, "itemID", item.ID, "attempts", item.FetchAttempts+1, "retry", retry)
+
+	err := c.queries.ItemsFetchFailed(ctx, db.ItemsFetchFailedParams{
+		FetchError:  reason,
+		NextFetchTs: next,
+		ID:          item.ID,
+	})
+	if err != nil {
+		c.Logger.Error("failed to mark item failed", "error", err, "itemID", item.ID)
+	}
+}
@@ -0,0 +1,157 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"syscall"
+	"time"
+
+	db "github.com/egemengol/kindlepathy/internal/db/generated"
+)
+
+// A flaky site shouldn't leave the reader with an error. Fetches failing in
+// a way that may pass, a timeout, a 429 or a 5xx, are retried after a
+// backoff doubling each time, or the Retry-After of the site. When the page
+// still can't be fetched the reader gets an earlier copy of it from the
+// cache, or else the latest Wayback Machine snapshot.
+
+// FetchRetries is how fetches failing in a way that may pass are retried.
+type FetchRetries struct {
+	// Retries is how many times a failed fetch is tried again, 0 for
+	// none.
+	Retries int
+	// Backoff is the wait before the first retry, doubled for each next
+	// one.
+	Backoff time.Duration
+}
+
+// The fallbacks of pages the site failed to serve.
+const (
+	FallbackCache   = "cache"
+	FallbackArchive = "archive"
+)
+
+// DefaultFetchRetries are the retries unless configured otherwise.
+var DefaultFetchRetries = FetchRetries{Retries: 2, Backoff: 500 * time.Millisecond}
+
+// maxRetryBackoff caps the wait before a retry, a site asking for a longer
+// one with Retry-After isn't retried.
+const maxRetryBackoff = 10 * time.Second
+
+// SetFetchRetries sets how failed fetches are retried.
+func (c *Core) SetFetchRetries(retries FetchRetries) {
+	c.fetchRetries = retries
+}
+
+// doRetrying sends a request for a page, again after a backoff while it
+// fails in a way that may pass.
+func (c *Core) doRetrying(req *http.Request) (*http.Response, error) {
+	backoff := c.fetchRetries.Backoff
+	for retry := 0; ; retry++ {
+		resp, err := c.doPolitely(req)
+		if retry >= c.fetchRetries.Retries || !transientFailure(resp, err) {
+			return resp, err
+		}
+		// Half the backoff is random, clients failing together don't
+		// retry together.
+		wait := backoff/2 + rand.N(backoff/2+1)
+		status := 0
+		if resp != nil {
+			status = resp.StatusCode
+			if after, ok := retryAfter(resp.Header.Get("Retry-After"), time.Now()); ok {
+				wait = after
+			}
+		}
+		if wait > maxRetryBackoff {
+			return resp, err
+		}
+		if resp != nil {
+			resp.Body.Close()
+		}
+		c.Logger.Info("retrying fetch", "url", req.URL.String(), "status", status, "error", err, "wait", wait)
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-req.Context().Done():
+			timer.Stop()
+			return nil, req.Context().Err()
+		}
+		backoff = min(backoff*2, maxRetryBackoff)
+	}
+}
+
+// transientFailure tells whether a fetch failed in a way that may pass
+// when tried again.
+func transientFailure(resp *http.Response, err error) bool {
+	if err != nil {
+		if errors.Is(err, context.Canceled) {
+			return false
+		}
+		var fetchErr *FetchError
+		if errors.As(err, &fetchErr) {
+			// The fetch policy's refusals don't change.
+			return false
+		}
+		return classifyFetchError("", err).Kind == FetchErrorTimeout ||
+			errors.Is(err, syscall.ECONNRESET) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF)
+	}
+	return transientStatus(resp.StatusCode)
+}
+
+func transientStatus(status int) bool {
+	return status == http.StatusTooManyRequests ||
+		status >= 500 && status != http.StatusNotImplemented && status != http.StatusHTTPVersionNotSupported
+}
+
+// retryAfter reads a Retry-After header, seconds or a date.
+func retryAfter(value string, now time.Time) (time.Duration, bool) {
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+	if at, err := http.ParseTime(value); err == nil {
+		return max(at.Sub(now), 0), true
+	}
+	return 0, false
+}
+
+// transientFetchError tells whether reading the page failed in a way that
+// may pass, for which an earlier copy is better than nothing.
+func transientFetchError(err error) bool {
+	var fetchErr *FetchError
+	if !errors.As(err, &fetchErr) {
+		return false
+	}
+	return fetchErr.Kind == FetchErrorTimeout || transientStatus(fetchErr.Status)
+}
+
+// fallbackContent is the earlier copy of the item's page, from the cache
+// or the archive, read when fetching it failed in a way that may pass.
+func (c *Core) fallbackContent(ctx context.Context, item db.Item, readErr error) (*Clean, error) {
+	if !transientFetchError(readErr) {
+		return nil, readErr
+	}
+	for _, prefix := range []string{userCachePrefix(item.UserID), "item"} {
+		if clean, _ := c.getCached(fmt.Sprintf("%s:%s", prefix, item.Url)); clean != nil {
+			c.Logger.Info("read an earlier copy of the page", "url", item.Url, "error", readErr)
+			fallback := *clean
+			fallback.Fallback = FallbackCache
+			return &fallback, nil
+		}
+	}
+	archived, err := c.archivedClean(ctx, item.Url)
+	if err != nil {
+		c.Logger.Warn("failed to read the archived page", "error", err, "url", item.Url)
+		return nil, readErr
+	}
+	c.Logger.Info("read the archived page", "url", item.Url, "error", readErr)
+	archived.Fallback = FallbackArchive
+	return archived, nil
+}
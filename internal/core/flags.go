@@ -0,0 +1,201 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	db "github.com/egemengol/kindlepathy/internal/db/generated"
+)
+
+// Feature flags let experimental subsystems ship dark. A flag is off unless
+// enabled for the instance, and a per-user setting overrides the instance
+// one either way.
+
+type Flag struct {
+	Name        string
+	Description string
+}
+
+// Flags are the known feature flags, setting any other is an error.
+var Flags = []Flag{
+	{Name: "headless_render", Description: "Render JavaScript-heavy pages in a headless browser"},
+	{Name: "tts", Description: "Listen to items with text to speech"},
+	{Name: "translations", Description: "Translate items to the reader's language"},
+}
+
+func knownFlag(name string) bool {
+	for _, f := range Flags {
+		if f.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// FlagSet is the resolved flags of a user, unknown flags are off. Templates
+// can use {{if .Flags.tts}}.
+type FlagSet map[string]bool
+
+func (s FlagSet) Enabled(name string) bool {
+	return s[name]
+}
+
+// flagCache keeps the flags in memory, they are read on every request and
+// change rarely. Writes go through Core, which drops the cached copies.
+type flagCache struct {
+	mu       sync.RWMutex
+	instance map[string]bool
+	users    map[int64]map[string]bool
+}
+
+func (c *Core) instanceFlags(ctx context.Context) (map[string]bool, error) {
+	c.flags.mu.RLock()
+	instance := c.flags.instance
+	c.flags.mu.RUnlock()
+	if instance != nil {
+		return instance, nil
+	}
+
+	rows, err := c.queries.FeatureFlagsList(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list feature flags: %w", err)
+	}
+	instance = make(map[string]bool, len(rows))
+	for _, row := range rows {
+		instance[row.Name] = row.Enabled
+	}
+
+	c.flags.mu.Lock()
+	c.flags.instance = instance
+	c.flags.mu.Unlock()
+	return instance, nil
+}
+
+func (c *Core) userFlagOverrides(ctx context.Context, userID int64) (map[string]bool, error) {
+	c.flags.mu.RLock()
+	overrides, ok := c.flags.users[userID]
+	c.flags.mu.RUnlock()
+	if ok {
+		return overrides, nil
+	}
+
+	rows, err := c.queries.UserFeatureFlagsListPerUser(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list user feature flags: %w", err)
+	}
+	overrides = make(map[string]bool, len(rows))
+	for _, row := range rows {
+		overrides[row.Name] = row.Enabled
+	}
+
+	c.flags.mu.Lock()
+	if c.flags.users == nil {
+		c.flags.users = make(map[int64]map[string]bool)
+	}
+	c.flags.users[userID] = overrides
+	c.flags.mu.Unlock()
+	return overrides, nil
+}
+
+// UserFlags resolves every known flag for the user.
+func (c *Core) UserFlags(ctx context.Context, userID int64) (FlagSet, error) {
+	instance, err := c.instanceFlags(ctx)
+	if err != nil {
+		return nil, err
+	}
+	overrides, err := c.userFlagOverrides(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	flags := make(FlagSet, len(Flags))
+	for _, f := range Flags {
+		enabled := instance[f.Name]
+		if override, ok := overrides[f.Name]; ok {
+			enabled = override
+		}
+		flags[f.Name] = enabled
+	}
+	return flags, nil
+}
+
+// FlagEnabled reports whether the flag is on for the user. Errors count as
+// off, so a broken flag can't turn a subsystem on.
+func (c *Core) FlagEnabled(ctx context.Context, userID int64, name string) bool {
+	flags, err := c.UserFlags(ctx, userID)
+	if err != nil {
+		c.Logger.Warn("Error resolving feature flags", "error", err)
+		return false
+	}
+	return flags[name]
+}
+
+// InstanceFlags returns the instance setting of every known flag.
+func (c *Core) InstanceFlags(ctx context.Context) (FlagSet, error) {
+	instance, err := c.instanceFlags(ctx)
+	if err != nil {
+		return nil, err
+	}
+	flags := make(FlagSet, len(Flags))
+	for _, f := range Flags {
+		flags[f.Name] = instance[f.Name]
+	}
+	return flags, nil
+}
+
+func (c *Core) SetInstanceFlag(ctx context.Context, name string, enabled bool) error {
+	if !knownFlag(name) {
+		return fmt.Errorf("unknown feature flag: %q", name)
+	}
+	err := c.queries.FeatureFlagsSet(ctx, db.FeatureFlagsSetParams{Name: name, Enabled: enabled})
+	if err != nil {
+		return fmt.Errorf("failed to set feature flag: %w", err)
+	}
+
+	c.flags.mu.Lock()
+	c.flags.instance = nil
+	c.flags.mu.Unlock()
+	return nil
+}
+
+// SetUserFlag overrides the flag for the user, a nil enabled goes back to
+// the instance setting.
+func (c *Core) SetUserFlag(ctx context.Context, userID int64, name string, enabled *bool) error {
+	if !knownFlag(name) {
+		return fmt.Errorf("unknown feature flag: %q", name)
+	}
+	var err error
+	if enabled == nil {
+		err = c.queries.UserFeatureFlagsDelete(ctx, db.UserFeatureFlagsDeleteParams{UserID: userID, Name: name})
+	} else {
+		err = c.queries.UserFeatureFlagsSet(ctx, db.UserFeatureFlagsSetParams{UserID: userID, Name: name, Enabled: *enabled})
+	}
+	if err != nil {
+		return fmt.Errorf("failed to set user feature flag: %w", err)
+	}
+
+	c.flags.mu.Lock()
+	delete(c.flags.users, userID)
+	c.flags.mu.Unlock()
+	return nil
+}
+
+type UserFlagOverride struct {
+	Username string
+	Name     string
+	Enabled  bool
+}
+
+// ListUserFlagOverrides returns every per-user setting, for admins.
+func (c *Core) ListUserFlagOverrides(ctx context.Context) ([]UserFlagOverride, error) {
+	rows, err := c.queries.UserFeatureFlagsList(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list user feature flags: %w", err)
+	}
+	overrides := make([]UserFlagOverride, len(rows))
+	for i, row := range rows {
+		overrides[i] = UserFlagOverride{Username: row.Username, Name: row.Name, Enabled: row.Enabled}
+	}
+	return overrides, nil
+}
@@ -0,0 +1,170 @@
+package core
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	db "github.com/egemengol/kindlepathy/internal/db/generated"
+)
+
+const (
+	greaderStreamStarred = "user/-/state/com.google/starred"
+	greaderStreamAll     = "user/-/state/com.google/reading-list"
+	greaderStateRead     = "user/-/state/com.google/read"
+)
+
+type greaderItem struct {
+	ID        string `json:"id"`
+	Title     string `json:"title"`
+	Canonical []struct {
+		Href string `json:"href"`
+	} `json:"canonical"`
+	Alternate []struct {
+		Href string `json:"href"`
+	} `json:"alternate"`
+}
+
+func (i greaderItem) url() string {
+	if len(i.Canonical) > 0 {
+		return i.Canonical[0].Href
+	}
+	if len(i.Alternate) > 0 {
+		return i.Alternate[0].Href
+	}
+	return ""
+}
+
+// syncGReader pulls starred or unread items from a Google Reader compatible
+// API, such as FreshRSS's /api/greader.php.
+func (c *Core) syncGReader(ctx context.Context, integration db.Integration) error {
+	auth, err := c.greaderLogin(ctx, integration)
+	if err != nil {
+		return fmt.Errorf("failed to log in: %w", err)
+	}
+
+	query := url.Values{"output": {"json"}, "n": {"100"}}
+	stream := greaderStreamStarred
+	if integration.FetchFilter == "unread" {
+		stream = greaderStreamAll
+		query.Set("xt", greaderStateRead)
+	}
+
+	var contents struct {
+		Items []greaderItem `json:"items"`
+	}
+	path := "/reader/api/0/stream/contents/" + stream + "?" + query.Encode()
+	if err := c.greaderRequest(ctx, integration, auth, "GET", path, nil, &contents); err != nil {
+		return fmt.Errorf("failed to get stream contents: %w", err)
+	}
+
+	for _, item := range contents.Items {
+		b := bookmark{
+			URL:     item.url(),
+			Title:   item.Title,
+			AddedAt: time.Now(),
+		}
+		if err := c.addIntegrationItem(ctx, integration, item.ID, b); err != nil {
+			c.Logger.Warn("failed to add greader item", "error", err, "itemID", item.ID, "url", b.URL)
+		}
+	}
+
+	read, err := c.readIntegrationItems(ctx, integration)
+	if err != nil || len(read) == 0 {
+		return err
+	}
+
+	var token string
+	if err := c.greaderRequest(ctx, integration, auth, "GET", "/reader/api/0/token", nil, &token); err != nil {
+		return fmt.Errorf("failed to get edit token: %w", err)
+	}
+	form := url.Values{"a": {greaderStateRead}, "T": {token}}
+	for _, r := range read {
+		form.Add("i", r.ExternalID)
+	}
+	if err := c.greaderRequest(ctx, integration, auth, "POST", "/reader/api/0/edit-tag", form, nil); err != nil {
+		return fmt.Errorf("failed to mark items read: %w", err)
+	}
+	for _, r := range read {
+		if err := c.queries.ItemSourcesSetSyncedRead(ctx, r.ItemID); err != nil {
+			return fmt.Errorf("failed to mark item synced: %w", err)
+		}
+	}
+	return nil
+}
+
+// greaderLogin exchanges the username and password for an auth token using
+// the ClientLogin endpoint.
+func (c *Core) greaderLogin(ctx context.Context, integration db.Integration) (string, error) {
+	form := url.Values{"Email": {integration.Username}, "Passwd": {integration.Token}}
+	req, err := http.NewRequestWithContext(ctx, "POST", integration.BaseUrl+"/accounts/ClientLogin", strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("greader returned status %d", resp.StatusCode)
+	}
+
+	scanner := bufio.NewScanner(io.LimitReader(resp.Body, maxIntegrationResponseBytes))
+	for scanner.Scan() {
+		if auth, ok := strings.CutPrefix(scanner.Text(), "Auth="); ok {
+			return auth, nil
+		}
+	}
+	return "", fmt.Errorf("no auth token in login response")
+}
+
+// greaderRequest calls the API with the auth token. A *string out receives
+// the raw body, anything else is decoded as JSON.
+func (c *Core) greaderRequest(ctx context.Context, integration db.Integration, auth, method, path string, form url.Values, out any) error {
+	var body io.Reader
+	if form != nil {
+		body = strings.NewReader(form.Encode())
+	}
+	req, err := http.NewRequestWithContext(ctx, method, integration.BaseUrl+path, body)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Authorization", "GoogleLogin auth="+auth)
+	if form != nil {
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("greader returned status %d", resp.StatusCode)
+	}
+
+	switch out := out.(type) {
+	case nil:
+		return nil
+	case *string:
+		bodyBytes, err := io.ReadAll(io.LimitReader(resp.Body, maxIntegrationResponseBytes))
+		if err != nil {
+			return fmt.Errorf("failed to read response: %w", err)
+		}
+		*out = strings.TrimSpace(string(bodyBytes))
+		return nil
+	default:
+		return json.NewDecoder(io.LimitReader(resp.Body, maxIntegrationResponseBytes)).Decode(out)
+	}
+}
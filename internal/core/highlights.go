@@ -0,0 +1,347 @@
+package core
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/PuerkitoBio/goquery"
+	db "github.com/egemengol/kindlepathy/internal/db/generated"
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// Highlights are kept as the quoted text and a little of the text before
+// it, rather than offsets into the content, so they're found again after a
+// refetch or a new extractor changed the markup around them. Whitespace is
+// left out of the matching, the browsers' selections and the markup break
+// lines differently.
+
+const (
+	// MaxHighlightBytes is the longest passage kept in one highlight.
+	MaxHighlightBytes = 10000
+	// MaxHighlightNoteBytes is the longest note of a highlight.
+	MaxHighlightNoteBytes = 5000
+	// highlightPrefixBytes is how much of the text before a highlight is
+	// kept to tell repeated passages apart.
+	highlightPrefixBytes = 64
+)
+
+type Highlight struct {
+	ID      int64
+	ItemID  int64
+	Quote   string
+	Prefix  string
+	Note    string
+	Created time.Time
+}
+
+// ItemHighlights are the highlights of an item, for the review page and the
+// export.
+type ItemHighlights struct {
+	ItemID     int64
+	Title      string
+	URL        string
+	Highlights []Highlight
+}
+
+// AddHighlight keeps a passage of an item, with an optional note. The
+// prefix is the text right before the passage in the page.
+func (c *Core) AddHighlight(ctx context.Context, userID, itemID int64, quote, prefix, note string, now time.Time) (int64, error) {
+	quote = strings.TrimSpace(quote)
+	note = strings.TrimSpace(note)
+	switch {
+	case quote == "":
+		return 0, fmt.Errorf("highlight is empty")
+	case len(quote) > MaxHighlightBytes:
+		return 0, fmt.Errorf("highlight is too long, at most %d bytes", MaxHighlightBytes)
+	case len(note) > MaxHighlightNoteBytes:
+		return 0, fmt.Errorf("note is too long, at most %d bytes", MaxHighlightNoteBytes)
+	}
+	// Only the end of the prefix is kept, on a rune boundary.
+	if len(prefix) > highlightPrefixBytes {
+		prefix = prefix[len(prefix)-highlightPrefixBytes:]
+		for len(prefix) > 0 && !utf8.RuneStart(prefix[0]) {
+			prefix = prefix[1:]
+		}
+	}
+
+	params := db.HighlightsAddParams{
+		UserID:    userID,
+		ItemID:    itemID,
+		Quote:     quote,
+		Prefix:    prefix,
+		CreatedTs: now.Unix(),
+	}
+	if note != "" {
+		params.Note = note
+	}
+	id, err := c.queries.HighlightsAdd(ctx, params)
+	if err != nil {
+		return 0, fmt.Errorf("failed to add highlight: %w", err)
+	}
+	return id, nil
+}
+
+// ListItemHighlights returns the highlights of an item in the order they
+// were made.
+func (c *Core) ListItemHighlights(ctx context.Context, itemID int64) ([]Highlight, error) {
+	rows, err := c.queries.HighlightsListPerItem(ctx, itemID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list highlights: %w", err)
+	}
+	highlights := make([]Highlight, len(rows))
+	for i, row := range rows {
+		highlights[i] = Highlight{
+			ID:      row.ID,
+			ItemID:  row.ItemID,
+			Quote:   row.Quote,
+			Prefix:  row.Prefix,
+			Created: time.Unix(row.CreatedTs, 0),
+		}
+		if row.Note != nil {
+			highlights[i].Note = row.Note.(string)
+		}
+	}
+	return highlights, nil
+}
+
+// ListHighlights returns the highlights of the user by item, the items
+// added last first.
+func (c *Core) ListHighlights(ctx context.Context, userID int64) ([]ItemHighlights, error) {
+	rows, err := c.queries.HighlightsListPerUser(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list highlights: %w", err)
+	}
+	var items []ItemHighlights
+	for _, row := range rows {
+		if len(items) == 0 || items[len(items)-1].ItemID != row.ItemID {
+			item := ItemHighlights{ItemID: row.ItemID, Title: row.Url, URL: row.Url}
+			if row.Title != nil && row.Title.(string) != "" {
+				item.Title = row.Title.(string)
+			}
+			items = append(items, item)
+		}
+		highlight := Highlight{
+			ID:      row.ID,
+			ItemID:  row.ItemID,
+			Quote:   row.Quote,
+			Created: time.Unix(row.CreatedTs, 0),
+		}
+		if row.Note != nil {
+			highlight.Note = row.Note.(string)
+		}
+		items[len(items)-1].Highlights = append(items[len(items)-1].Highlights, highlight)
+	}
+	return items, nil
+}
+
+// DeleteHighlight deletes one of the user's highlights, sql.ErrNoRows when
+// they have no such highlight.
+func (c *Core) DeleteHighlight(ctx context.Context, userID, highlightID int64) error {
+	deleted, err := c.queries.HighlightsDelete(ctx, db.HighlightsDeleteParams{
+		ID:     highlightID,
+		UserID: userID,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to delete highlight: %w", err)
+	}
+	if deleted == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// HighlightsMarkdown writes the highlights as Markdown, a section of quotes
+// per item with their notes under them.
+func HighlightsMarkdown(items []ItemHighlights) string {
+	var b strings.Builder
+	b.WriteString("# Highlights\n")
+	for _, item := range items {
+		fmt.Fprintf(&b, "\n## %s\n\n<%s>\n", item.Title, item.URL)
+		for _, highlight := range item.Highlights {
+			b.WriteString("\n")
+			for _, line := range strings.Split(highlight.Quote, "\n") {
+				fmt.Fprintf(&b, "> %s\n", strings.TrimSpace(line))
+			}
+			if highlight.Note != "" {
+				fmt.Fprintf(&b, "\n%s\n", highlight.Note)
+			}
+		}
+	}
+	return b.String()
+}
+
+// highlightSpan is the part of a highlight in one text node.
+type highlightSpan struct {
+	start, end int
+	highlight  Highlight
+	// first is set on the span the highlight starts in.
+	first bool
+}
+
+// RenderHighlights marks the highlights in the content, each with a <mark>
+// per text node it spans. Highlights that aren't found anymore, or overlap
+// one made before, are left out.
+func RenderHighlights(contentHTML string, highlights []Highlight) (string, error) {
+	if len(highlights) == 0 {
+		return contentHTML, nil
+	}
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(contentHTML))
+	if err != nil {
+		return "", fmt.Errorf("failed to parse content: %w", err)
+	}
+	body := doc.Find("body")
+	if body.Length() == 0 {
+		return contentHTML, nil
+	}
+
+	// The text of the content without whitespace, and where each of its
+	// bytes is in the text nodes.
+	type textPos struct {
+		node   *html.Node
+		offset int
+	}
+	var text strings.Builder
+	var positions []textPos
+	var walk func(n *html.Node)
+	walk = func(n *html.Node) {
+		switch {
+		case n.Type == html.TextNode:
+			for i, r := range n.Data {
+				if unicode.IsSpace(r) {
+					continue
+				}
+				_, size := utf8.DecodeRuneInString(n.Data[i:])
+				text.WriteString(n.Data[i : i+size])
+				for j := range size {
+					positions = append(positions, textPos{n, i + j})
+				}
+			}
+			return
+		case n.Type == html.ElementNode && (n.DataAtom == atom.Script || n.DataAtom == atom.Style):
+			return
+		}
+		for child := n.FirstChild; child != nil; child = child.NextSibling {
+			walk(child)
+		}
+	}
+	walk(body.Nodes[0])
+
+	type match struct {
+		start, end int
+		highlight  Highlight
+	}
+	var matches []match
+	for _, highlight := range highlights {
+		if start := findHighlight(text.String(), highlight); start >= 0 {
+			matches = append(matches, match{start, start + len(stripSpace(highlight.Quote)), highlight})
+		}
+	}
+	// The highlight made first wins where two overlap.
+	var kept []match
+	for _, m := range matches {
+		if !slices.ContainsFunc(kept, func(k match) bool { return m.start < k.end && k.start < m.end }) {
+			kept = append(kept, m)
+		}
+	}
+
+	spans := make(map[*html.Node][]highlightSpan)
+	var nodes []*html.Node
+	for _, m := range kept {
+		for i := m.start; i < m.end; {
+			node := positions[i].node
+			j := i
+			for j+1 < m.end && positions[j+1].node == node {
+				j++
+			}
+			if _, ok := spans[node]; !ok {
+				nodes = append(nodes, node)
+			}
+			spans[node] = append(spans[node], highlightSpan{
+				start:     positions[i].offset,
+				end:       positions[j].offset + 1,
+				highlight: m.highlight,
+				first:     i == m.start,
+			})
+			i = j + 1
+		}
+	}
+	for _, node := range nodes {
+		markTextNode(node, spans[node])
+	}
+
+	return body.Html()
+}
+
+// findHighlight returns where the quote of the highlight is in the text,
+// the occurrence after its prefix when the quote repeats, or -1.
+func findHighlight(text string, highlight Highlight) int {
+	quote := stripSpace(highlight.Quote)
+	prefix := stripSpace(highlight.Prefix)
+	if quote == "" {
+		return -1
+	}
+	first := -1
+	for from := 0; from < len(text); {
+		i := strings.Index(text[from:], quote)
+		if i < 0 {
+			break
+		}
+		i += from
+		if prefix == "" || strings.HasSuffix(text[:i], prefix) {
+			return i
+		}
+		if first < 0 {
+			first = i
+		}
+		from = i + 1
+	}
+	return first
+}
+
+func stripSpace(s string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsSpace(r) {
+			return -1
+		}
+		return r
+	}, s)
+}
+
+// markTextNode splits the text node around the spans, wrapping them in
+// <mark>s.
+func markTextNode(node *html.Node, spans []highlightSpan) {
+	slices.SortFunc(spans, func(a, b highlightSpan) int { return a.start - b.start })
+	parent := node.Parent
+	at := 0
+	for _, span := range spans {
+		if span.start > at {
+			parent.InsertBefore(&html.Node{Type: html.TextNode, Data: node.Data[at:span.start]}, node)
+		}
+		mark := &html.Node{
+			Type:     html.ElementNode,
+			Data:     "mark",
+			DataAtom: atom.Mark,
+			Attr:     []html.Attribute{{Key: "class", Val: "highlight"}},
+		}
+		if span.first {
+			mark.Attr = append(mark.Attr, html.Attribute{Key: "id", Val: fmt.Sprintf("highlight-%d", span.highlight.ID)})
+		}
+		if span.highlight.Note != "" {
+			mark.Attr = append(mark.Attr, html.Attribute{Key: "title", Val: span.highlight.Note})
+		}
+		mark.AppendChild(&html.Node{Type: html.TextNode, Data: node.Data[span.start:span.end]})
+		parent.InsertBefore(mark, node)
+		at = span.end
+	}
+	if at < len(node.Data) {
+		parent.InsertBefore(&html.Node{Type: html.TextNode, Data: node.Data[at:]}, node)
+	}
+	parent.RemoveChild(node)
+}
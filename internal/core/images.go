@@ -0,0 +1,169 @@
+package core
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+	badger "github.com/dgraph-io/badger/v4"
+)
+
+// The Kindle browser can't load many images: it fails on newer TLS, and
+// CDNs with hotlink protection turn it away. The reader points images to
+// the /img proxy instead, which fetches them on the server and keeps them
+// in the cache. The small ones already cached are inlined as data URIs, a
+// request less each over a slow connection.
+
+// ImageProxyPath is where the server proxies images, with the image URL in
+// src, the page it's on in ref and the e-ink mode in eink.
+const ImageProxyPath = "/img"
+
+const (
+	// maxProxiedImageSize refuses larger images, the Kindle couldn't show
+	// them anyway.
+	maxProxiedImageSize = 5 << 20
+	// maxInlineImageSize is the largest image inlined as a data URI.
+	maxInlineImageSize = 8 << 10
+	imageCacheTTL      = 30 * 24 * time.Hour
+)
+
+var (
+	// ErrNotAnImage is returned when the proxied URL isn't an image.
+	ErrNotAnImage = errors.New("not an image")
+	// ErrInvalidImageURL is returned for URLs that aren't on the web.
+	ErrInvalidImageURL = errors.New("invalid image URL")
+)
+
+type ProxiedImage struct {
+	ContentType string
+	Data        []byte
+}
+
+func imageCacheKey(src string) []byte {
+	return []byte("img:" + src)
+}
+
+// ProxyImages points the images of the content to the proxy, pageURL is
+// sent as the referer hotlink protection expects and the images are
+// prepared for the e-ink mode. Images that aren't on the web, like
+// screenshots, are left alone.
+func (c *Core) ProxyImages(contentHTML, pageURL, einkMode string) string {
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(contentHTML))
+	if err != nil {
+		c.Logger.Warn("failed to parse content for the image proxy", "error", err)
+		return contentHTML
+	}
+	doc.Find("img[src]").Each(func(_ int, img *goquery.Selection) {
+		src := img.AttrOr("src", "")
+		if !strings.HasPrefix(src, "http://") && !strings.HasPrefix(src, "https://") {
+			return
+		}
+		// The proxy picks no size, the source is the one to fetch.
+		img.RemoveAttr("srcset")
+		if image := c.cachedImage(src); image != nil && len(image.Data) <= maxInlineImageSize {
+			if image, err := einkImage(image, einkMode); err == nil {
+				img.SetAttr("src", "data:"+image.ContentType+";base64,"+base64.StdEncoding.EncodeToString(image.Data))
+				return
+			}
+		}
+		img.SetAttr("src", proxiedImageURL(src, pageURL, einkMode))
+	})
+	content, err := doc.Find("body").Html()
+	if err != nil {
+		c.Logger.Warn("failed to render content for the image proxy", "error", err)
+		return contentHTML
+	}
+	return content
+}
+
+func proxiedImageURL(src, pageURL, einkMode string) string {
+	query := url.Values{"src": {src}}
+	if pageURL != "" {
+		query.Set("ref", pageURL)
+	}
+	if einkMode != "" && einkMode != EinkOff {
+		query.Set("eink", einkMode)
+	}
+	return ImageProxyPath + "?" + query.Encode()
+}
+
+// ProxyImage returns the image at src prepared for the e-ink mode, from the
+// cache when it was proxied before. referer is the page the image is on, it
+// may be empty. The cache keeps the original, so changing the mode doesn't
+// fetch again.
+func (c *Core) ProxyImage(ctx context.Context, src, referer, einkMode string) (*ProxiedImage, error) {
+	u, err := url.Parse(src)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidImageURL, src)
+	}
+	if image := c.cachedImage(src); image != nil {
+		return einkImage(image, einkMode)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "GET", src, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create GET request: %w", err)
+	}
+	c.setFetchHeaders(req)
+	if referer != "" {
+		req.Header.Set("Referer", referer)
+	}
+	req.Header.Set("Accept", "image/*")
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch image: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to fetch image: status %d", resp.StatusCode)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxProxiedImageSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read image: %w", err)
+	}
+	if len(data) > maxProxiedImageSize {
+		return nil, fmt.Errorf("image is larger than %d bytes", maxProxiedImageSize)
+	}
+	// The server's word isn't taken for it, the proxy only serves images.
+	contentType := http.DetectContentType(data)
+	if !strings.HasPrefix(contentType, "image/") {
+		return nil, ErrNotAnImage
+	}
+
+	if c.cache != nil {
+		err := c.cache.Update(func(txn *badger.Txn) error {
+			return txn.SetEntry(badger.NewEntry(imageCacheKey(src), data).WithTTL(imageCacheTTL))
+		})
+		if err != nil {
+			c.Logger.Warn("failed to cache image", "error", err, "src", src)
+		}
+	}
+	return einkImage(&ProxiedImage{ContentType: contentType, Data: data}, einkMode)
+}
+
+// cachedImage returns the cached image at src, or nil.
+func (c *Core) cachedImage(src string) *ProxiedImage {
+	if c.cache == nil {
+		return nil
+	}
+	var data []byte
+	err := c.cache.View(func(txn *badger.Txn) error {
+		item, err := txn.Get(imageCacheKey(src))
+		if err != nil {
+			return err
+		}
+		data, err = item.ValueCopy(nil)
+		return err
+	})
+	if err != nil {
+		return nil
+	}
+	return &ProxiedImage{ContentType: http.DetectContentType(data), Data: data}
+}
@@ -0,0 +1,175 @@
+package core
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+	db "github.com/egemengol/kindlepathy/internal/db/generated"
+)
+
+// bookmark is the common shape of an entry coming from a bookmark manager export.
+type bookmark struct {
+	URL     string
+	Title   string
+	Tags    []string
+	AddedAt time.Time
+	// ReadAt is set for entries that were read, or archived.
+	ReadAt *time.Time
+}
+
+// linkdingBookmark is one entry of linkding's JSON export, the same shape its
+// /api/bookmarks/ endpoint returns.
+type linkdingBookmark struct {
+	URL          string   `json:"url"`
+	Title        string   `json:"title"`
+	WebsiteTitle string   `json:"website_title"`
+	TagNames     []string `json:"tag_names"`
+	DateAdded    string   `json:"date_added"`
+}
+
+// ImportLinkding imports a linkding JSON export, either a bare array of
+// bookmarks or an API page with a "results" array. When fetch is set the
+// content of each imported URL is fetched in the background.
+func (c *Core) ImportLinkding(ctx context.Context, userID int64, r io.Reader, fetch bool, now time.Time) (*ImportResult, error) {
+	raw, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read export: %w", err)
+	}
+
+	var entries []linkdingBookmark
+	if err := json.Unmarshal(raw, &entries); err != nil {
+		var page struct {
+			Results []linkdingBookmark `json:"results"`
+		}
+		if err := json.Unmarshal(raw, &page); err != nil {
+			return nil, fmt.Errorf("not a linkding JSON export: %w", err)
+		}
+		entries = page.Results
+	}
+
+	bookmarks := make([]bookmark, 0, len(entries))
+	for _, entry := range entries {
+		b := bookmark{
+			URL:     entry.URL,
+			Title:   entry.Title,
+			Tags:    entry.TagNames,
+			AddedAt: now,
+		}
+		if b.Title == "" {
+			b.Title = entry.WebsiteTitle
+		}
+		if t, err := time.Parse(time.RFC3339, entry.DateAdded); err == nil {
+			b.AddedAt = t
+		}
+		bookmarks = append(bookmarks, b)
+	}
+
+	return c.importBookmarks(ctx, userID, bookmarks, fetch)
+}
+
+// ImportShaarli imports a Shaarli export, which uses the Netscape bookmark
+// HTML format with comma or space separated TAGS attributes.
+func (c *Core) ImportShaarli(ctx context.Context, userID int64, r io.Reader, fetch bool, now time.Time) (*ImportResult, error) {
+	doc, err := goquery.NewDocumentFromReader(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse export: %w", err)
+	}
+
+	var bookmarks []bookmark
+	doc.Find("dt > a[href], a[href][add_date]").Each(func(i int, s *goquery.Selection) {
+		b := bookmark{
+			URL:     s.AttrOr("href", ""),
+			Title:   strings.TrimSpace(s.Text()),
+			AddedAt: now,
+		}
+		if addDate, err := strconv.ParseInt(s.AttrOr("add_date", ""), 10, 64); err == nil {
+			b.AddedAt = time.Unix(addDate, 0)
+		}
+		b.Tags = strings.FieldsFunc(s.AttrOr("tags", ""), func(r rune) bool {
+			return r == ',' || r == ' '
+		})
+		bookmarks = append(bookmarks, b)
+	})
+	if len(bookmarks) == 0 {
+		return nil, fmt.Errorf("no bookmarks found, not a Netscape bookmark file")
+	}
+
+	return c.importBookmarks(ctx, userID, bookmarks, fetch)
+}
+
+func (c *Core) importBookmarks(ctx context.Context, userID int64, bookmarks []bookmark, fetch bool) (*ImportResult, error) {
+	result := &ImportResult{}
+	seen := make(map[string]bool, len(bookmarks))
+	var toFetch []int64
+	for _, b := range bookmarks {
+		if err := ctx.Err(); err != nil {
+			return result, err
+		}
+		if seen[b.URL] {
+			continue
+		}
+		seen[b.URL] = true
+
+		itemID, err := c.importBookmark(ctx, userID, b)
+		if err != nil {
+			result.fail(b.URL, err)
+			continue
+		}
+		result.Imported++
+		toFetch = append(toFetch, itemID)
+	}
+
+	c.Logger.Info("imported bookmarks", "userID", userID, "imported", result.Imported, "failed", len(result.Failed))
+	// The fetch queue warms the cache a couple of items at a time, so a
+	// large import doesn't hammer the origin sites or the readability server.
+	if fetch {
+		for _, itemID := range toFetch {
+			if err := c.enqueueFetch(ctx, itemID, time.Now()); err != nil {
+				c.Logger.Warn("failed to queue imported item", "error", err, "itemID", itemID)
+			}
+		}
+	}
+	return result, nil
+}
+
+// importBookmark adds a link without content, keeping the title from the export.
+func (c *Core) importBookmark(ctx context.Context, userID int64, b bookmark) (int64, error) {
+	itemID, err := c.AddItem(ctx, userID, b.URL, b.AddedAt)
+	if err != nil {
+		return 0, err
+	}
+	if b.Title != "" {
+		_, err = c.queries.ItemsUpdateTitle(ctx, db.ItemsUpdateTitleParams{
+			Title: b.Title,
+			ID:    itemID,
+		})
+		if err != nil {
+			return 0, fmt.Errorf("failed to update item title: %w", err)
+		}
+	}
+	if err := c.AddTags(ctx, userID, itemID, b.Tags); err != nil {
+		return 0, err
+	}
+	if err := c.setImportedRead(ctx, itemID, b.ReadAt); err != nil {
+		return 0, err
+	}
+	return itemID, nil
+}
+
+// setImportedRead marks an imported item read when it was in the export.
+func (c *Core) setImportedRead(ctx context.Context, itemID int64, readAt *time.Time) error {
+	if readAt == nil {
+		return nil
+	}
+	err := c.queries.ItemsSetRead(ctx, db.ItemsSetReadParams{ReadTs: readAt.Unix(), ID: itemID})
+	if err != nil {
+		return fmt.Errorf("failed to mark item read: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,151 @@
+package core
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+	"time"
+)
+
+// MaxImportBytes is the largest export file that can be imported.
+const MaxImportBytes = 100 << 20
+
+// ImportResult summarizes an import run.
+type ImportResult struct {
+	Imported int
+	Failed   []ImportFailure
+}
+
+const (
+	// maxOmnivoreFiles is the most files an Omnivore export can have, and
+	// maxOmnivoreFileBytes the largest of them read, for an archive
+	// expanding to more than the disk and memory of the server to fail.
+	maxOmnivoreFiles     = 100000
+	maxOmnivoreFileBytes = 20 << 20
+)
+
+type ImportFailure struct {
+	URL   string
+	Error string
+}
+
+func (r *ImportResult) fail(url string, err error) {
+	r.Failed = append(r.Failed, ImportFailure{URL: url, Error: err.Error()})
+}
+
+// omnivoreItem is one entry of the metadata_*.json files in an Omnivore export.
+type omnivoreItem struct {
+	Slug    string   `json:"slug"`
+	Title   string   `json:"title"`
+	URL     string   `json:"url"`
+	Labels  []string `json:"labels"`
+	SavedAt string   `json:"savedAt"`
+}
+
+// ImportOmnivore imports an Omnivore export archive. Saved content under
+// content/<slug>.html is stored as uploaded content so nothing is refetched,
+// and labels become tags.
+func (c *Core) ImportOmnivore(ctx context.Context, userID int64, archive *zip.Reader, now time.Time) (*ImportResult, error) {
+	if len(archive.File) > maxOmnivoreFiles {
+		return nil, fmt.Errorf("export has %d files, at most %d can be imported", len(archive.File), maxOmnivoreFiles)
+	}
+	files := make(map[string]*zip.File, len(archive.File))
+	var metadataFiles []*zip.File
+	for _, f := range archive.File {
+		files[f.Name] = f
+		base := path.Base(f.Name)
+		if strings.HasPrefix(base, "metadata_") && strings.HasSuffix(base, ".json") {
+			metadataFiles = append(metadataFiles, f)
+		}
+	}
+	if len(metadataFiles) == 0 {
+		return nil, fmt.Errorf("no metadata_*.json found, not an Omnivore export")
+	}
+
+	result := &ImportResult{}
+	for _, mf := range metadataFiles {
+		var entries []omnivoreItem
+		if err := readZipJSON(mf, &entries); err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", mf.Name, err)
+		}
+
+		for _, entry := range entries {
+			if err := ctx.Err(); err != nil {
+				return result, err
+			}
+			contentFile := files[path.Join(path.Dir(mf.Name), "content", entry.Slug+".html")]
+			if err := c.importOmnivoreItem(ctx, userID, entry, contentFile, now); err != nil {
+				result.fail(entry.URL, err)
+				continue
+			}
+			result.Imported++
+		}
+	}
+
+	c.Logger.Info("imported omnivore export", "userID", userID, "imported", result.Imported, "failed", len(result.Failed))
+	return result, nil
+}
+
+func (c *Core) importOmnivoreItem(ctx context.Context, userID int64, entry omnivoreItem, contentFile *zip.File, now time.Time) error {
+	addedAt := now
+	if entry.SavedAt != "" {
+		if t, err := time.Parse(time.RFC3339, entry.SavedAt); err == nil {
+			addedAt = t
+		}
+	}
+
+	if contentFile != nil && entry.Slug != "" {
+		content, err := readZipFile(contentFile)
+		if err != nil {
+			return fmt.Errorf("failed to read content: %w", err)
+		}
+		itemID, _, err := c.addItemWithUploadedContent(ctx, userID, entry.Title, entry.URL, string(content), addedAt)
+		if err != nil {
+			return err
+		}
+		return c.AddTags(ctx, userID, itemID, entry.Labels)
+	}
+
+	// No saved content, keep the link and let the reader fetch it later
+	_, err := c.importBookmark(ctx, userID, bookmark{
+		URL:     entry.URL,
+		Title:   entry.Title,
+		Tags:    entry.Labels,
+		AddedAt: addedAt,
+	})
+	return err
+}
+
+// readZipFile reads a file of an export, failing on one over
+// maxOmnivoreFileBytes. The size in the archive isn't trusted, the read
+// stops past it either way.
+func readZipFile(f *zip.File) ([]byte, error) {
+	if f.UncompressedSize64 > maxOmnivoreFileBytes {
+		return nil, fmt.Errorf("%s is larger than %d MB", f.Name, maxOmnivoreFileBytes>>20)
+	}
+	rc, err := f.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	data, err := io.ReadAll(io.LimitReader(rc, maxOmnivoreFileBytes+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxOmnivoreFileBytes {
+		return nil, fmt.Errorf("%s is larger than %d MB", f.Name, maxOmnivoreFileBytes>>20)
+	}
+	return data, nil
+}
+
+func readZipJSON(f *zip.File, v any) error {
+	data, err := readZipFile(f)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
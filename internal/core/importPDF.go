@@ -0,0 +1,62 @@
+package core
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/egemengol/kindlepathy/internal/pdf"
+)
+
+// PDFs, papers and reports mostly, are read as articles: their text is put
+// back together into headings and paragraphs when they're uploaded, and
+// kept as uploaded content. Their items have pdf: URLs, the file's hash as
+// the host and its name as the path, so the same file uploaded again
+// replaces its item.
+
+// pdfScheme is the scheme of the URLs of uploaded PDFs.
+const pdfScheme = "pdf"
+
+// MaxPDFBytes is the largest PDF that can be uploaded.
+const MaxPDFBytes = 50 << 20
+
+// AddPDF adds the text of a PDF to the library as an item with uploaded
+// content, and makes it the active item.
+func (c *Core) AddPDF(ctx context.Context, userID int64, r io.ReaderAt, size int64, filename string, now time.Time) (int64, error) {
+	if size > MaxPDFBytes {
+		return 0, fmt.Errorf("PDF is too large, at most %d MB", MaxPDFBytes>>20)
+	}
+	h := sha256.New()
+	if _, err := io.Copy(h, io.NewSectionReader(r, 0, size)); err != nil {
+		return 0, fmt.Errorf("failed to read PDF: %w", err)
+	}
+	doc, err := pdf.Extract(r, size)
+	if err != nil {
+		return 0, err
+	}
+
+	filename = path.Base(strings.ReplaceAll(filename, "\\", "/"))
+	title := doc.Title
+	if title == "" {
+		title = strings.TrimSuffix(filename, path.Ext(filename))
+	}
+	rawurl := (&url.URL{Scheme: pdfScheme, Host: hex.EncodeToString(h.Sum(nil))[:16], Path: "/" + filename}).String()
+	itemID, _, err := c.AddItemWithUploadedContent(ctx, userID, title, rawurl, doc.HTML, now)
+	if err != nil {
+		return 0, err
+	}
+
+	clean := &Clean{ContentHTML: doc.HTML, Byline: doc.Author, SiteName: "PDF"}
+	if doc.Created != nil {
+		clean.PublishedTime = doc.Created.Format(time.RFC3339)
+	}
+	c.setItemMetadata(ctx, itemID, clean)
+	c.Logger.Info("added PDF", "userID", userID, "itemID", itemID, "pages", doc.Pages)
+	return itemID, nil
+}
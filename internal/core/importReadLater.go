@@ -0,0 +1,285 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// Exports of other read-it-later services. Archived entries are imported as
+// read, favorites get the "starred" tag like they do through the Wallabag
+// API, and the time they were saved is kept.
+
+const starredTag = "starred"
+
+// DetectImportFormat guesses the format of an export from its name and its
+// first bytes: omnivore, linkding, shaarli, pocket, instapaper or wallabag.
+// It returns "" when it can't tell.
+func DetectImportFormat(name string, head []byte) string {
+	head = bytes.TrimPrefix(head, []byte("\xef\xbb\xbf"))
+	trimmed := bytes.TrimSpace(head)
+	lower := bytes.ToLower(trimmed)
+	firstLine, _, _ := bytes.Cut(lower, []byte("\n"))
+	switch {
+	case bytes.HasPrefix(head, []byte("PK\x03\x04")):
+		return "omnivore"
+	case bytes.HasPrefix(trimmed, []byte("[")) || bytes.HasPrefix(trimmed, []byte("{")):
+		if bytes.Contains(head, []byte(`"is_archived"`)) {
+			return "wallabag"
+		}
+		if bytes.Contains(head, []byte(`"tag_names"`)) || bytes.Contains(head, []byte(`"results"`)) {
+			return "linkding"
+		}
+	case bytes.HasPrefix(firstLine, []byte("url,title,selection,folder")):
+		return "instapaper"
+	case bytes.Contains(firstLine, []byte("url")) && bytes.Contains(firstLine, []byte("time_added")):
+		return "pocket"
+	case bytes.Contains(lower, []byte("<title>pocket export</title>")) || bytes.Contains(lower, []byte("time_added=")):
+		return "pocket"
+	case bytes.Contains(lower, []byte("netscape-bookmark-file")):
+		return "shaarli"
+	}
+	if strings.HasSuffix(strings.ToLower(name), ".zip") {
+		return "omnivore"
+	}
+	return ""
+}
+
+// ImportPocket imports a Pocket export: the ril_export.html of the old
+// exporter, with "Unread" and "Read Archive" lists, or the CSV of the new
+// one, with title, url, time_added, tags and status columns.
+func (c *Core) ImportPocket(ctx context.Context, userID int64, r io.Reader, fetch bool, now time.Time) (*ImportResult, error) {
+	raw, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read export: %w", err)
+	}
+	if bytes.HasPrefix(bytes.TrimSpace(raw), []byte("<")) {
+		return c.importPocketHTML(ctx, userID, raw, fetch, now)
+	}
+
+	rows, err := readCSV(raw)
+	if err != nil {
+		return nil, err
+	}
+	var bookmarks []bookmark
+	for _, row := range rows {
+		b := bookmark{
+			URL:     row["url"],
+			Title:   row["title"],
+			Tags:    strings.Split(row["tags"], "|"),
+			AddedAt: unixOr(row["time_added"], now),
+		}
+		if row["status"] == "archive" {
+			b.ReadAt = &b.AddedAt
+		}
+		bookmarks = append(bookmarks, b)
+	}
+	if len(bookmarks) == 0 {
+		return nil, fmt.Errorf("no entries found, not a Pocket export")
+	}
+	return c.importBookmarks(ctx, userID, bookmarks, fetch)
+}
+
+func (c *Core) importPocketHTML(ctx context.Context, userID int64, raw []byte, fetch bool, now time.Time) (*ImportResult, error) {
+	doc, err := goquery.NewDocumentFromReader(bytes.NewReader(raw))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse export: %w", err)
+	}
+
+	var bookmarks []bookmark
+	doc.Find("a[href]").Each(func(i int, s *goquery.Selection) {
+		b := bookmark{
+			URL:     s.AttrOr("href", ""),
+			Title:   strings.TrimSpace(s.Text()),
+			Tags:    strings.Split(s.AttrOr("tags", ""), ","),
+			AddedAt: unixOr(s.AttrOr("time_added", ""), now),
+		}
+		// The list is under the heading of its state.
+		heading := s.ParentsFiltered("ul").PrevAllFiltered("h1").First().Text()
+		if strings.Contains(strings.ToLower(heading), "archive") {
+			b.ReadAt = &b.AddedAt
+		}
+		bookmarks = append(bookmarks, b)
+	})
+	if len(bookmarks) == 0 {
+		return nil, fmt.Errorf("no links found, not a Pocket export")
+	}
+	return c.importBookmarks(ctx, userID, bookmarks, fetch)
+}
+
+// ImportInstapaper imports Instapaper's CSV export. Entries in the Archive
+// folder are read, those in Starred get the starred tag, and other folders
+// become tags.
+func (c *Core) ImportInstapaper(ctx context.Context, userID int64, r io.Reader, fetch bool, now time.Time) (*ImportResult, error) {
+	raw, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read export: %w", err)
+	}
+	rows, err := readCSV(raw)
+	if err != nil {
+		return nil, err
+	}
+
+	var bookmarks []bookmark
+	for _, row := range rows {
+		b := bookmark{
+			URL:     row["url"],
+			Title:   row["title"],
+			AddedAt: unixOr(row["timestamp"], now),
+		}
+		switch folder := row["folder"]; folder {
+		case "", "Unread":
+		case "Archive":
+			b.ReadAt = &b.AddedAt
+		case "Starred":
+			b.Tags = append(b.Tags, starredTag)
+		default:
+			b.Tags = append(b.Tags, folder)
+		}
+		// Newer exports have a Tags column, a JSON array.
+		var tags []string
+		if json.Unmarshal([]byte(row["tags"]), &tags) == nil {
+			b.Tags = append(b.Tags, tags...)
+		}
+		bookmarks = append(bookmarks, b)
+	}
+	if len(bookmarks) == 0 {
+		return nil, fmt.Errorf("no entries found, not an Instapaper export")
+	}
+	return c.importBookmarks(ctx, userID, bookmarks, fetch)
+}
+
+// wallabagEntry is one entry of Wallabag's JSON export. Its booleans are 0
+// or 1 in older versions.
+type wallabagEntry struct {
+	URL        string          `json:"url"`
+	Title      string          `json:"title"`
+	Content    string          `json:"content"`
+	Tags       []string        `json:"tags"`
+	IsArchived json.RawMessage `json:"is_archived"`
+	IsStarred  json.RawMessage `json:"is_starred"`
+	CreatedAt  string          `json:"created_at"`
+	ArchivedAt string          `json:"archived_at"`
+	UpdatedAt  string          `json:"updated_at"`
+}
+
+// ImportWallabag imports Wallabag's JSON export. The content it saved is
+// kept, so nothing is refetched.
+func (c *Core) ImportWallabag(ctx context.Context, userID int64, r io.Reader, fetch bool, now time.Time) (*ImportResult, error) {
+	var entries []wallabagEntry
+	if err := json.NewDecoder(r).Decode(&entries); err != nil {
+		return nil, fmt.Errorf("not a Wallabag JSON export: %w", err)
+	}
+
+	result := &ImportResult{}
+	var bookmarks []bookmark
+	for _, entry := range entries {
+		if err := ctx.Err(); err != nil {
+			return result, err
+		}
+		b := bookmark{
+			URL:     entry.URL,
+			Title:   entry.Title,
+			Tags:    entry.Tags,
+			AddedAt: parseWallabagTime(entry.CreatedAt, now),
+		}
+		if wallabagTrue(entry.IsStarred) {
+			b.Tags = append(b.Tags, starredTag)
+		}
+		if wallabagTrue(entry.IsArchived) {
+			readAt := parseWallabagTime(entry.ArchivedAt, parseWallabagTime(entry.UpdatedAt, b.AddedAt))
+			b.ReadAt = &readAt
+		}
+		if entry.Content == "" {
+			bookmarks = append(bookmarks, b)
+			continue
+		}
+
+		if b.Title == "" {
+			b.Title = b.URL
+		}
+		itemID, _, err := c.addItemWithUploadedContent(ctx, userID, b.Title, b.URL, entry.Content, b.AddedAt)
+		if err == nil {
+			err = c.AddTags(ctx, userID, itemID, b.Tags)
+		}
+		if err == nil {
+			err = c.setImportedRead(ctx, itemID, b.ReadAt)
+		}
+		if err != nil {
+			result.fail(entry.URL, err)
+			continue
+		}
+		result.Imported++
+	}
+
+	if len(bookmarks) > 0 {
+		linked, err := c.importBookmarks(ctx, userID, bookmarks, fetch)
+		if linked != nil {
+			result.Imported += linked.Imported
+			result.Failed = append(result.Failed, linked.Failed...)
+		}
+		if err != nil {
+			return result, err
+		}
+	}
+	c.Logger.Info("imported wallabag export", "userID", userID, "imported", result.Imported, "failed", len(result.Failed))
+	return result, nil
+}
+
+func wallabagTrue(v json.RawMessage) bool {
+	s := string(v)
+	return s == "1" || s == "true"
+}
+
+// parseWallabagTime parses the times of the export, which lack the colon of
+// RFC 3339 in their offsets.
+func parseWallabagTime(s string, def time.Time) time.Time {
+	for _, layout := range []string{"2006-01-02T15:04:05-0700", time.RFC3339} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t
+		}
+	}
+	return def
+}
+
+// readCSV reads a CSV with a header into rows keyed by the lowercased
+// column names.
+func readCSV(raw []byte) ([]map[string]string, error) {
+	r := csv.NewReader(bytes.NewReader(bytes.TrimPrefix(raw, []byte("\xef\xbb\xbf"))))
+	r.FieldsPerRecord = -1
+	records, err := r.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse CSV: %w", err)
+	}
+	if len(records) == 0 {
+		return nil, nil
+	}
+	header := records[0]
+	rows := make([]map[string]string, 0, len(records)-1)
+	for _, record := range records[1:] {
+		row := make(map[string]string, len(header))
+		for i, name := range header {
+			if i < len(record) {
+				row[strings.ToLower(strings.TrimSpace(name))] = strings.TrimSpace(record[i])
+			}
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+func unixOr(s string, def time.Time) time.Time {
+	ts, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || ts <= 0 {
+		return def
+	}
+	return time.Unix(ts, 0)
+}
@@ -0,0 +1,64 @@
+package core
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Text pasted into the library, from an email or a chat mostly, is kept as
+// uploaded content: plain text as its paragraphs, Markdown as the HTML it
+// stands for. Its items have text: URLs with the hash of the text as the
+// host, so the same text pasted again replaces its item.
+
+// textScheme is the scheme of the URLs of pasted texts.
+const textScheme = "text"
+
+// MaxTextBytes is the longest text that can be pasted.
+const MaxTextBytes = 1 << 20
+
+// The formats a pasted text can be in.
+const (
+	TextFormatPlain    = "text"
+	TextFormatMarkdown = "markdown"
+)
+
+// AddText adds a pasted text to the library as an item with uploaded
+// content, and makes it the active item.
+func (c *Core) AddText(ctx context.Context, userID int64, title, text, format string, now time.Time) (int64, error) {
+	title = strings.TrimSpace(title)
+	if title == "" {
+		return 0, fmt.Errorf("title is required")
+	}
+	if strings.TrimSpace(text) == "" {
+		return 0, fmt.Errorf("text is required")
+	}
+	if len(text) > MaxTextBytes {
+		return 0, fmt.Errorf("text is too long, at most %d MB", MaxTextBytes>>20)
+	}
+
+	var contentHTML string
+	switch format {
+	case TextFormatPlain:
+		contentHTML = plainTextHTML(text)
+	case TextFormatMarkdown:
+		contentHTML = markdownHTML(text)
+	default:
+		return 0, fmt.Errorf("unknown text format: %q", format)
+	}
+
+	sum := sha256.Sum256([]byte(format + "\n" + text))
+	rawurl := (&url.URL{Scheme: textScheme, Host: hex.EncodeToString(sum[:])[:16]}).String()
+	itemID, _, err := c.AddItemWithUploadedContent(ctx, userID, title, rawurl, contentHTML, now)
+	if err != nil {
+		return 0, err
+	}
+
+	c.setItemMetadata(ctx, itemID, &Clean{ContentHTML: contentHTML, SiteName: "Pasted"})
+	c.Logger.Info("added pasted text", "userID", userID, "itemID", itemID, "format", format)
+	return itemID, nil
+}
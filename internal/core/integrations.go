@@ -0,0 +1,228 @@
+package core
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	db "github.com/egemengol/kindlepathy/internal/db/generated"
+)
+
+const (
+	IntegrationMiniflux = "miniflux"
+	IntegrationFever    = "fever"
+	IntegrationGReader  = "greader"
+)
+
+// IntegrationKinds lists the integrations that can be configured.
+var IntegrationKinds = []string{IntegrationMiniflux, IntegrationFever, IntegrationGReader}
+
+// maxIntegrationResponseBytes is the most read of a response of an RSS
+// reader, a page of entries is far smaller.
+const maxIntegrationResponseBytes = 20 << 20
+
+type Integration struct {
+	Kind        string
+	BaseURL     string
+	Username    string
+	Token       string
+	FetchFilter string
+	MarkRead    bool
+	LastSync    *time.Time
+}
+
+func integrationFromRow(row db.Integration) Integration {
+	integration := Integration{
+		Kind:        row.Kind,
+		BaseURL:     row.BaseUrl,
+		Username:    row.Username,
+		Token:       row.Token,
+		FetchFilter: row.FetchFilter,
+		MarkRead:    row.MarkRead,
+	}
+	if row.LastSyncTs != nil {
+		t := time.Unix(row.LastSyncTs.(int64), 0)
+		integration.LastSync = &t
+	}
+	return integration
+}
+
+// GetIntegration returns the user's integration of the given kind, or nil if
+// it is not configured.
+func (c *Core) GetIntegration(ctx context.Context, userID int64, kind string) (*Integration, error) {
+	row, err := c.queries.IntegrationsGet(ctx, db.IntegrationsGetParams{
+		UserID: userID,
+		Kind:   kind,
+	})
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get integration: %w", err)
+	}
+	integration := integrationFromRow(row)
+	return &integration, nil
+}
+
+func (c *Core) SaveIntegration(ctx context.Context, userID int64, integration Integration) error {
+	u, err := url.Parse(integration.BaseURL)
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return fmt.Errorf("invalid base url: %q", integration.BaseURL)
+	}
+	if integration.Token == "" {
+		return fmt.Errorf("token cannot be empty")
+	}
+	switch integration.Kind {
+	case IntegrationMiniflux:
+	case IntegrationFever, IntegrationGReader:
+		if integration.Username == "" {
+			return fmt.Errorf("username cannot be empty")
+		}
+	default:
+		return fmt.Errorf("unknown integration: %q", integration.Kind)
+	}
+	if integration.FetchFilter != "starred" && integration.FetchFilter != "unread" {
+		return fmt.Errorf("invalid fetch filter: %q", integration.FetchFilter)
+	}
+
+	return c.queries.IntegrationsUpsert(ctx, db.IntegrationsUpsertParams{
+		UserID:      userID,
+		Kind:        integration.Kind,
+		BaseUrl:     strings.TrimRight(integration.BaseURL, "/"),
+		Username:    integration.Username,
+		Token:       integration.Token,
+		FetchFilter: integration.FetchFilter,
+		MarkRead:    integration.MarkRead,
+	})
+}
+
+func (c *Core) DeleteIntegration(ctx context.Context, userID int64, kind string) error {
+	return c.queries.IntegrationsDelete(ctx, db.IntegrationsDeleteParams{
+		UserID: userID,
+		Kind:   kind,
+	})
+}
+
+// SyncIntegration runs a single sync of the user's integration right away.
+func (c *Core) SyncIntegration(ctx context.Context, userID int64, kind string, now time.Time) error {
+	row, err := c.queries.IntegrationsGet(ctx, db.IntegrationsGetParams{
+		UserID: userID,
+		Kind:   kind,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to get integration: %w", err)
+	}
+	return c.syncIntegration(ctx, row, now)
+}
+
+type syncIntegrationJob struct {
+	UserID int64  `json:"user_id"`
+	Kind   string `json:"kind"`
+}
+
+// RunIntegrationSync queues a sync of every configured integration and of
+// every feed followed each interval until ctx is cancelled.
+func (c *Core) RunIntegrationSync(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		integrations, err := c.queries.IntegrationsList(ctx)
+		if err != nil {
+			c.Logger.Error("failed to list integrations", "error", err)
+		}
+		for _, integration := range integrations {
+			job := syncIntegrationJob{UserID: integration.UserID, Kind: integration.Kind}
+			if err := c.enqueueJob(ctx, JobSyncIntegration, job, time.Now()); err != nil {
+				c.Logger.Warn("failed to queue integration sync", "error", err, "kind", integration.Kind, "userID", integration.UserID)
+			}
+		}
+
+		feeds, err := c.queries.FeedsList(ctx)
+		if err != nil {
+			c.Logger.Error("failed to list feeds", "error", err)
+		}
+		for _, feed := range feeds {
+			if err := c.enqueueJob(ctx, JobSyncFeed, syncFeedJob{FeedID: feed.ID}, time.Now()); err != nil {
+				c.Logger.Warn("failed to queue feed sync", "error", err, "feed", feed.Url, "userID", feed.UserID)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (c *Core) syncIntegration(ctx context.Context, integration db.Integration, now time.Time) error {
+	var err error
+	switch integration.Kind {
+	case IntegrationMiniflux:
+		err = c.syncMiniflux(ctx, integration)
+	case IntegrationFever:
+		err = c.syncFever(ctx, integration)
+	case IntegrationGReader:
+		err = c.syncGReader(ctx, integration)
+	default:
+		err = fmt.Errorf("unknown integration: %q", integration.Kind)
+	}
+	if err != nil {
+		return err
+	}
+
+	return c.queries.IntegrationsSetSynced(ctx, db.IntegrationsSetSyncedParams{
+		LastSyncTs: now.Unix(),
+		ID:         integration.ID,
+	})
+}
+
+// addIntegrationItem adds a link pulled from an integration and remembers
+// which remote entry it came from. Entries are only ever added once, so
+// deleting an item here doesn't bring it back on the next sync.
+func (c *Core) addIntegrationItem(ctx context.Context, integration db.Integration, externalID string, b bookmark) error {
+	seen, err := c.queries.IntegrationEntriesSeen(ctx, db.IntegrationEntriesSeenParams{
+		IntegrationID: integration.ID,
+		ExternalID:    externalID,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to check entry: %w", err)
+	}
+	if seen != 0 {
+		return nil
+	}
+
+	itemID, err := c.importBookmark(ctx, integration.UserID, b)
+	if err != nil {
+		return err
+	}
+	err = c.queries.ItemSourcesAdd(ctx, db.ItemSourcesAddParams{
+		ItemID:        itemID,
+		IntegrationID: integration.ID,
+		ExternalID:    externalID,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to record item source: %w", err)
+	}
+	return c.queries.IntegrationEntriesAdd(ctx, db.IntegrationEntriesAddParams{
+		IntegrationID: integration.ID,
+		ExternalID:    externalID,
+	})
+}
+
+// readIntegrationItems returns the items from an integration that were read
+// here but not yet marked read upstream.
+func (c *Core) readIntegrationItems(ctx context.Context, integration db.Integration) ([]db.ItemSourcesListReadUnsyncedRow, error) {
+	if !integration.MarkRead {
+		return nil, nil
+	}
+	read, err := c.queries.ItemSourcesListReadUnsynced(ctx, integration.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list read items: %w", err)
+	}
+	return read, nil
+}
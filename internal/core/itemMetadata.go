@@ -0,0 +1,61 @@
+package core
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	db "github.com/egemengol/kindlepathy/internal/db/generated"
+)
+
+// The byline, site name and publish date the extractor found are kept on
+// the item with its word count, so the library can tell items apart and say
+// how long they take to read without having their content at hand.
+
+// publishedLayouts are the layouts of the publish dates of the pages,
+// from their article:published_time, JSON-LD or meta tags.
+var publishedLayouts = []string{
+	time.RFC3339,
+	"2006-01-02T15:04:05Z0700",
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05",
+	"2006-01-02",
+	time.RFC1123Z,
+	time.RFC1123,
+}
+
+// ParsePublishedTime parses the publish date of a page, nil when there is
+// none or its format isn't known.
+func ParsePublishedTime(s string) *time.Time {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return nil
+	}
+	for _, layout := range publishedLayouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			return &t
+		}
+	}
+	return nil
+}
+
+// setItemMetadata stores the metadata of the item's clean content. It only
+// logs failures, like indexItem.
+func (c *Core) setItemMetadata(ctx context.Context, itemID int64, clean *Clean) {
+	params := db.ItemsSetMetadataParams{ID: itemID}
+	if byline := strings.TrimSpace(clean.Byline); byline != "" {
+		params.Author = byline
+	}
+	if siteName := strings.TrimSpace(clean.SiteName); siteName != "" {
+		params.SiteName = siteName
+	}
+	if published := ParsePublishedTime(clean.PublishedTime); published != nil {
+		params.PublishedTs = published.Unix()
+	}
+	if words := CountWords(clean.ContentHTML); words > 0 {
+		params.WordCount = words
+	}
+	if err := c.queries.ItemsSetMetadata(ctx, params); err != nil {
+		c.Logger.Warn("failed to store item metadata", "error", err, "itemID", itemID)
+	}
+}
@@ -0,0 +1,76 @@
+package core
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+
+	db "github.com/egemengol/kindlepathy/internal/db/generated"
+)
+
+// MaxNoteBytes is the longest note of an item.
+const MaxNoteBytes = 10000
+
+// SetItemNote sets the note of one of the user's items, an empty one
+// removes it. It returns sql.ErrNoRows when the user has no such item.
+func (c *Core) SetItemNote(ctx context.Context, userID, itemID int64, note string) error {
+	note = strings.TrimSpace(note)
+	if len(note) > MaxNoteBytes {
+		return fmt.Errorf("note is too long, at most %d bytes", MaxNoteBytes)
+	}
+	params := db.ItemsSetNoteParams{ID: itemID, UserID: userID}
+	if note != "" {
+		params.Note = note
+	}
+	n, err := c.queries.ItemsSetNote(ctx, params)
+	if err != nil {
+		return fmt.Errorf("failed to set item note: %w", err)
+	}
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+	c.indexItemNote(ctx, itemID, note)
+	return nil
+}
+
+// indexItemNote updates the note in the item's search entry, or adds an
+// entry with only the title and the note when its content wasn't indexed
+// yet. It only logs failures, like indexItem.
+func (c *Core) indexItemNote(ctx context.Context, itemID int64, note string) {
+	n, err := c.queries.ItemsSearchIndexSetNote(ctx, db.ItemsSearchIndexSetNoteParams{
+		Note:   note,
+		ItemID: itemID,
+	})
+	if err != nil {
+		c.Logger.Warn("failed to index item note for search", "error", err, "itemID", itemID)
+		return
+	}
+	if n > 0 {
+		return
+	}
+	item, err := c.queries.ItemsGet(ctx, itemID)
+	if err != nil {
+		c.Logger.Warn("failed to index item note for search", "error", err, "itemID", itemID)
+		return
+	}
+	title, _ := item.Title.(string)
+	err = c.queries.ItemsSearchIndexAdd(ctx, db.ItemsSearchIndexAddParams{
+		ItemID: itemID,
+		Title:  title,
+		Note:   note,
+	})
+	if err != nil {
+		c.Logger.Warn("failed to index item note for search", "error", err, "itemID", itemID)
+	}
+}
+
+// GetItemNote returns the note of the item, "" when it has none.
+func (c *Core) GetItemNote(ctx context.Context, itemID int64) (string, error) {
+	item, err := c.queries.ItemsGet(ctx, itemID)
+	if err != nil {
+		return "", fmt.Errorf("failed to get item: %w", err)
+	}
+	note, _ := item.Note.(string)
+	return note, nil
+}
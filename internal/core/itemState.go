@@ -0,0 +1,55 @@
+package core
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	db "github.com/egemengol/kindlepathy/internal/db/generated"
+)
+
+// Items are unread until archived or made a favorite, so what's done with
+// can leave the list without being deleted. Reading an item doesn't change
+// its state, read_ts only says when it was last opened.
+
+// Item states.
+const (
+	StateUnread   = "unread"
+	StateArchived = "archived"
+	StateFavorite = "favorite"
+)
+
+func ValidItemState(state string) bool {
+	return state == StateUnread || state == StateArchived || state == StateFavorite
+}
+
+// SetItemState sets the state of one of the user's items. It returns
+// sql.ErrNoRows when the user has no such item.
+func (c *Core) SetItemState(ctx context.Context, userID, itemID int64, state string) error {
+	if !ValidItemState(state) {
+		return fmt.Errorf("invalid item state: %q", state)
+	}
+	n, err := c.queries.ItemsSetState(ctx, db.ItemsSetStateParams{
+		State:  state,
+		ID:     itemID,
+		UserID: userID,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to set item state: %w", err)
+	}
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// FilterItemsByState keeps the items in the state, in their order.
+func FilterItemsByState(items []Item, state string) []Item {
+	var filtered []Item
+	for _, item := range items {
+		if item.State == state {
+			filtered = append(filtered, item)
+		}
+	}
+	return filtered
+}
@@ -0,0 +1,275 @@
+package core
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	db "github.com/egemengol/kindlepathy/internal/db/generated"
+)
+
+// Work that outlives the request asking for it goes to the job queue:
+// sending an item by email, looking for the chapter list of a serial,
+// syncing an integration. Jobs live in the jobs table and survive restarts,
+// failed ones are retried with a backoff and listed at /admin/jobs. Items to
+// fetch, imported ones included, have a queue of their own in the items
+// table.
+
+const (
+	JobPending = "pending"
+	JobRunning = "running"
+	JobDone    = "done"
+	JobFailed  = "failed"
+)
+
+// Kinds of jobs.
+const (
+	JobEmailItem       = "email_item"
+	JobChapterList     = "chapter_list"
+	JobSyncIntegration = "sync_integration"
+	JobSyncFeed        = "sync_feed"
+)
+
+// jobRetryDelays are the waits before running a failed job again, the job
+// fails for good after the last one.
+var jobRetryDelays = []time.Duration{time.Minute, 10 * time.Minute, time.Hour}
+
+// jobQueuePoll is how often idle workers look for retries that are due.
+const jobQueuePoll = 15 * time.Second
+
+// jobTimeout bounds a run of a job.
+const jobTimeout = 5 * time.Minute
+
+// jobsKept is how long finished jobs stay listed.
+const jobsKept = 7 * 24 * time.Hour
+
+type Job struct {
+	ID       int64
+	Kind     string
+	Payload  string
+	Status   string
+	Attempts int
+	// Error is why the last run failed.
+	Error   string
+	RunAt   time.Time
+	Created time.Time
+	Updated time.Time
+}
+
+func jobFromRow(row db.Job) Job {
+	job := Job{
+		ID:       row.ID,
+		Kind:     row.Kind,
+		Payload:  row.Payload,
+		Status:   row.Status,
+		Attempts: int(row.Attempts),
+		RunAt:    time.Unix(row.RunTs, 0),
+		Created:  time.Unix(row.CreatedTs, 0),
+		Updated:  time.Unix(row.UpdatedTs, 0),
+	}
+	if row.Error != nil {
+		job.Error = row.Error.(string)
+	}
+	return job
+}
+
+// enqueueJob schedules a job to run now, with its payload as JSON. A job of
+// the same kind and payload that is pending or running isn't added again.
+func (c *Core) enqueueJob(ctx context.Context, kind string, payload any, now time.Time) error {
+	payloadJSON, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("failed to encode job: %w", err)
+	}
+	err = c.queries.JobsAdd(ctx, db.JobsAddParams{
+		Kind:      kind,
+		Payload:   string(payloadJSON),
+		RunTs:     now.Unix(),
+		CreatedTs: now.Unix(),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to add job: %w", err)
+	}
+	select {
+	case c.jobWake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// RunJobQueue runs queued jobs with the given number of workers until ctx is
+// cancelled, and deletes the finished ones after a while.
+func (c *Core) RunJobQueue(ctx context.Context, workers int) {
+	// Jobs cut short by a restart start over.
+	if err := c.queries.JobsResetRunning(ctx); err != nil {
+		c.Logger.Error("failed to reset interrupted jobs", "error", err)
+	}
+
+	var wg sync.WaitGroup
+	for range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ticker := time.NewTicker(jobQueuePoll)
+			defer ticker.Stop()
+			for {
+				for c.runNextJob(ctx, time.Now()) {
+				}
+				select {
+				case <-ctx.Done():
+					return
+				case <-c.jobWake:
+				case <-ticker.C:
+				}
+			}
+		}()
+	}
+
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+	for {
+		deleted, err := c.queries.JobsDeleteFinished(ctx, time.Now().Add(-jobsKept).Unix())
+		if err != nil && ctx.Err() == nil {
+			c.Logger.Warn("failed to delete finished jobs", "error", err)
+		} else if deleted > 0 {
+			c.Logger.Debug("deleted finished jobs", "jobs", deleted)
+		}
+		select {
+		case <-ctx.Done():
+			wg.Wait()
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// runNextJob runs the next due job, telling whether there was one.
+func (c *Core) runNextJob(ctx context.Context, now time.Time) bool {
+	job, err := c.queries.JobsClaim(ctx, now.Unix())
+	if errors.Is(err, sql.ErrNoRows) {
+		return false
+	}
+	if err != nil {
+		if ctx.Err() == nil {
+			c.Logger.Error("failed to claim job", "error", err)
+		}
+		return false
+	}
+
+	runCtx, cancel := context.WithTimeout(ctx, jobTimeout)
+	err = c.runJob(runCtx, job)
+	cancel()
+	if err != nil {
+		c.jobFailed(ctx, job, err, time.Now())
+		return true
+	}
+	err = c.queries.JobsDone(ctx, db.JobsDoneParams{UpdatedTs: time.Now().Unix(), ID: job.ID})
+	if err != nil {
+		c.Logger.Error("failed to mark job done", "error", err, "jobID", job.ID)
+	}
+	return true
+}
+
+func (c *Core) runJob(ctx context.Context, job db.Job) error {
+	var err error
+	switch job.Kind {
+	case JobEmailItem:
+		var payload emailItemJob
+		if err = json.Unmarshal([]byte(job.Payload), &payload); err == nil {
+			err = c.EmailItem(ctx, payload.ItemID, payload.To, time.Now())
+		}
+	case JobChapterList:
+		var payload chapterListJob
+		if err = json.Unmarshal([]byte(job.Payload), &payload); err == nil {
+			err = c.refreshChapterList(ctx, payload.ItemID, payload.Minutes)
+		}
+	case JobSyncIntegration:
+		var payload syncIntegrationJob
+		if err = json.Unmarshal([]byte(job.Payload), &payload); err == nil {
+			err = c.SyncIntegration(ctx, payload.UserID, payload.Kind, time.Now())
+		}
+	case JobSyncFeed:
+		var payload syncFeedJob
+		if err = json.Unmarshal([]byte(job.Payload), &payload); err == nil {
+			err = c.SyncFeed(ctx, payload.FeedID, time.Now())
+		}
+	default:
+		err = fmt.Errorf("unknown job: %q", job.Kind)
+	}
+	// The item, the integration or the feed was deleted since, there's
+	// nothing left to do.
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	}
+	return err
+}
+
+func (c *Core) jobFailed(ctx context.Context, job db.Job, jobErr error, now time.Time) {
+	retry := job.Attempts < int64(len(jobRetryDelays))
+	params := db.JobsFailedParams{
+		Status:    JobFailed,
+		Error:     jobErr.Error(),
+		RunTs:     job.RunTs,
+		UpdatedTs: now.Unix(),
+		ID:        job.ID,
+	}
+	if retry {
+		params.Status = JobPending
+		params.RunTs = now.Add(jobRetryDelays[job.Attempts]).Unix()
+	}
+	c.Logger.Warn("job failed", "error", jobErr, "jobID", job.ID, "kind", job.Kind, "attempts", job.Attempts+1, "retry", retry)
+
+	if err := c.queries.JobsFailed(ctx, params); err != nil {
+		c.Logger.Error("failed to mark job failed", "error", err, "jobID", job.ID)
+	}
+}
+
+// ListJobs returns the latest jobs, the last added first.
+func (c *Core) ListJobs(ctx context.Context, limit int) ([]Job, error) {
+	rows, err := c.queries.JobsList(ctx, int64(limit))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list jobs: %w", err)
+	}
+	jobs := make([]Job, len(rows))
+	for i, row := range rows {
+		jobs[i] = jobFromRow(row)
+	}
+	return jobs, nil
+}
+
+// CountJobs returns how many jobs there are by status.
+func (c *Core) CountJobs(ctx context.Context) (map[string]int64, error) {
+	rows, err := c.queries.JobsCount(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to count jobs: %w", err)
+	}
+	counts := make(map[string]int64, len(rows))
+	for _, row := range rows {
+		counts[row.Status] = row.Count
+	}
+	return counts, nil
+}
+
+// RetryJob runs a failed job again right away, with all of its attempts. It
+// returns sql.ErrNoRows when there is no such failed job.
+func (c *Core) RetryJob(ctx context.Context, jobID int64, now time.Time) error {
+	retried, err := c.queries.JobsRetry(ctx, db.JobsRetryParams{
+		RunTs:     now.Unix(),
+		UpdatedTs: now.Unix(),
+		ID:        jobID,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to retry job: %w", err)
+	}
+	if retried == 0 {
+		return sql.ErrNoRows
+	}
+	select {
+	case c.jobWake <- struct{}{}:
+	default:
+	}
+	return nil
+}
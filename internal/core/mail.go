@@ -0,0 +1,189 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"html/template"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SMTPConfig is the mail server used for sending items by email.
+type SMTPConfig struct {
+	Host     string
+	Port     int
+	Username string
+	Password string
+	From     string
+}
+
+// ErrMailNotConfigured is returned when sending mail without SMTP settings.
+var ErrMailNotConfigured = fmt.Errorf("email is not configured on this server")
+
+var itemDocumentTemplate = template.Must(template.New("item").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Title}}</title>
+</head>
+<body>
+<h1>{{.Title}}</h1>
+<p><a href="{{.URL}}">{{.URL}}</a></p>
+{{.Content}}
+</body>
+</html>
+`))
+
+// itemDocument renders the clean content of an item as a standalone HTML
+// document, which Send to Kindle and most mail clients can open.
+func itemDocument(title, url, contentHTML string) ([]byte, error) {
+	var buf bytes.Buffer
+	err := itemDocumentTemplate.Execute(&buf, struct {
+		Title   string
+		URL     string
+		Content template.HTML
+	}{
+		Title:   title,
+		URL:     url,
+		Content: template.HTML(contentHTML),
+	})
+	return buf.Bytes(), err
+}
+
+// MailConfigured reports whether the server can send email.
+func (c *Core) MailConfigured() bool {
+	return c.smtp != nil
+}
+
+// EmailVerificationConfigured reports whether the server can mail
+// verification links, which needs its public URL on top of mail.
+func (c *Core) EmailVerificationConfigured() bool {
+	return c.smtp != nil && c.publicURL != ""
+}
+
+type emailItemJob struct {
+	ItemID int64  `json:"item_id"`
+	To     string `json:"to"`
+}
+
+// QueueEmailItem checks the address and queues the item to be sent to it,
+// the job queue retries when the mail server is unreachable.
+func (c *Core) QueueEmailItem(ctx context.Context, itemID int64, to string, now time.Time) error {
+	if c.smtp == nil {
+		return ErrMailNotConfigured
+	}
+	toAddr, err := mail.ParseAddress(to)
+	if err != nil {
+		return fmt.Errorf("invalid email address: %q", to)
+	}
+	return c.enqueueJob(ctx, JobEmailItem, emailItemJob{ItemID: itemID, To: toAddr.Address}, now)
+}
+
+// EmailItem sends the item as an HTML attachment to an arbitrary address.
+func (c *Core) EmailItem(ctx context.Context, itemID int64, to string, now time.Time) error {
+	if c.smtp == nil {
+		return ErrMailNotConfigured
+	}
+	toAddr, err := mail.ParseAddress(to)
+	if err != nil {
+		return fmt.Errorf("invalid email address: %q", to)
+	}
+
+	item, err := c.queries.ItemsGet(ctx, itemID)
+	if err != nil {
+		return fmt.Errorf("failed to get item: %w", err)
+	}
+	clean, err := c.GetItemContent(ctx, itemID)
+	if err != nil {
+		return err
+	}
+	title := clean.Title
+	if title == "" {
+		title = item.Url
+	}
+
+	doc, err := itemDocument(title, item.Url, clean.ContentHTML)
+	if err != nil {
+		return fmt.Errorf("failed to render item: %w", err)
+	}
+
+	msg, err := buildMessage(c.smtp.From, toAddr.Address, title, item.Url, Slugify(title)+".html", doc, now)
+	if err != nil {
+		return fmt.Errorf("failed to build message: %w", err)
+	}
+
+	return c.sendMail(toAddr.Address, msg)
+}
+
+func (c *Core) sendMail(to string, msg []byte) error {
+	var auth smtp.Auth
+	if c.smtp.Username != "" {
+		auth = smtp.PlainAuth("", c.smtp.Username, c.smtp.Password, c.smtp.Host)
+	}
+	addr := net.JoinHostPort(c.smtp.Host, strconv.Itoa(c.smtp.Port))
+	if err := smtp.SendMail(addr, auth, c.smtp.From, []string{to}, msg); err != nil {
+		return fmt.Errorf("failed to send email: %w", err)
+	}
+	return nil
+}
+
+func buildMessage(from, to, subject, body, filename string, attachment []byte, now time.Time) ([]byte, error) {
+	var buf bytes.Buffer
+	w := multipart.NewWriter(&buf)
+
+	fmt.Fprintf(&buf, "From: %s\r\n", from)
+	fmt.Fprintf(&buf, "To: %s\r\n", to)
+	fmt.Fprintf(&buf, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", subject))
+	fmt.Fprintf(&buf, "Date: %s\r\n", now.Format(time.RFC1123Z))
+	fmt.Fprintf(&buf, "MIME-Version: 1.0\r\n")
+	fmt.Fprintf(&buf, "Content-Type: multipart/mixed; boundary=%s\r\n\r\n", w.Boundary())
+
+	part, err := w.CreatePart(textproto.MIMEHeader{
+		"Content-Type": {"text/plain; charset=utf-8"},
+	})
+	if err != nil {
+		return nil, err
+	}
+	fmt.Fprintf(part, "%s\r\n\r\nSent from Kindlepathy.\r\n", body)
+
+	part, err = w.CreatePart(textproto.MIMEHeader{
+		"Content-Type":              {mime.FormatMediaType("text/html", map[string]string{"charset": "utf-8", "name": filename})},
+		"Content-Disposition":       {mime.FormatMediaType("attachment", map[string]string{"filename": filename})},
+		"Content-Transfer-Encoding": {"base64"},
+	})
+	if err != nil {
+		return nil, err
+	}
+	encoded := base64.StdEncoding.EncodeToString(attachment)
+	for len(encoded) > 76 {
+		fmt.Fprintf(part, "%s\r\n", encoded[:76])
+		encoded = encoded[76:]
+	}
+	fmt.Fprintf(part, "%s\r\n", encoded)
+
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func buildTextMessage(from, to, subject, body string, now time.Time) []byte {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "From: %s\r\n", from)
+	fmt.Fprintf(&buf, "To: %s\r\n", to)
+	fmt.Fprintf(&buf, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", subject))
+	fmt.Fprintf(&buf, "Date: %s\r\n", now.Format(time.RFC1123Z))
+	fmt.Fprintf(&buf, "MIME-Version: 1.0\r\n")
+	fmt.Fprintf(&buf, "Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	fmt.Fprintf(&buf, "%s\r\n\r\nSent from Kindlepathy.\r\n", strings.ReplaceAll(body, "\n", "\r\n"))
+	return buf.Bytes()
+}
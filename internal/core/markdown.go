@@ -0,0 +1,709 @@
+package core
+
+import (
+	"html"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Pasted Markdown is turned into HTML here, as much of CommonMark as notes
+// and messages use: ATX and setext headings, paragraphs, fenced code,
+// block quotes, nested lists, rules, emphasis, code spans, links, images
+// and autolinks, bare URLs included. Indented code and raw HTML are left
+// as text, since pasted plain text is often indented and full of angle
+// brackets. The result goes through the sanitizer like any other content.
+
+var (
+	mdHeading     = regexp.MustCompile(`^(#{1,6})(?:[ \t]+(.*?))?(?:[ \t]+#+)?[ \t]*$`)
+	mdRule        = regexp.MustCompile(`^(?:(?:\*[ \t]*){3,}|(?:-[ \t]*){3,}|(?:_[ \t]*){3,})$`)
+	mdSetext      = regexp.MustCompile(`^(=+|-+)[ \t]*$`)
+	mdBullet      = regexp.MustCompile(`^([-*+])(?:[ \t]+|$)`)
+	mdOrdered     = regexp.MustCompile(`^([0-9]{1,9})([.)])(?:[ \t]+|$)`)
+	mdBareURL     = regexp.MustCompile(`^https?://[^\s<>"]+`)
+	mdAutolinkURL = regexp.MustCompile(`^<([a-zA-Z][a-zA-Z0-9+.-]{1,31}:[^\s<>]*)>`)
+	mdAutolinkAt  = regexp.MustCompile(`^<([a-zA-Z0-9.!#$%&'*+/=?^_{|}~-]+@[a-zA-Z0-9](?:[a-zA-Z0-9.-]*[a-zA-Z0-9])?)>`)
+)
+
+// markdownHTML returns the HTML of a Markdown text.
+func markdownHTML(text string) string {
+	var b strings.Builder
+	markdownBlocks(&b, markdownLines(text), false)
+	return b.String()
+}
+
+// plainTextHTML returns the HTML of a plain text: its paragraphs, split on
+// blank lines, with their line breaks kept.
+func plainTextHTML(text string) string {
+	var b strings.Builder
+	var para []string
+	flush := func() {
+		if len(para) == 0 {
+			return
+		}
+		b.WriteString("<p>")
+		for i, line := range para {
+			if i > 0 {
+				b.WriteString("<br>\n")
+			}
+			b.WriteString(html.EscapeString(line))
+		}
+		b.WriteString("</p>\n")
+		para = para[:0]
+	}
+	for _, line := range markdownLines(text) {
+		if strings.TrimSpace(line) == "" {
+			flush()
+			continue
+		}
+		para = append(para, strings.TrimRight(line, " \t"))
+	}
+	flush()
+	return b.String()
+}
+
+// markdownLines splits a text into lines, with its line endings and tabs
+// made plain.
+func markdownLines(text string) []string {
+	text = strings.ReplaceAll(text, "\r\n", "\n")
+	text = strings.ReplaceAll(text, "\r", "\n")
+	text = strings.ReplaceAll(text, "\t", "    ")
+	return strings.Split(strings.TrimRight(text, "\n"), "\n")
+}
+
+// mdIndent returns the number of leading spaces of a line, and the line
+// without them.
+func mdIndent(line string) (int, string) {
+	trimmed := strings.TrimLeft(line, " ")
+	return len(line) - len(trimmed), trimmed
+}
+
+func mdBlank(line string) bool {
+	return strings.TrimSpace(line) == ""
+}
+
+// mdFence returns the fence a line opens a code block with, if it does.
+func mdFence(trimmed string) (string, string, bool) {
+	if !strings.HasPrefix(trimmed, "```") && !strings.HasPrefix(trimmed, "~~~") {
+		return "", "", false
+	}
+	n := len(trimmed) - len(strings.TrimLeft(trimmed, trimmed[:1]))
+	info := strings.TrimSpace(trimmed[n:])
+	if trimmed[0] == '`' && strings.Contains(info, "`") {
+		return "", "", false
+	}
+	return trimmed[:n], info, true
+}
+
+// mdListMarker is the marker a line starts a list item with: whether the
+// list is ordered, its delimiter and start number, and the width of the
+// marker with the spaces after it.
+type mdListMarker struct {
+	ordered bool
+	delim   string
+	start   int
+	width   int
+	empty   bool
+}
+
+func mdListItem(trimmed string) (mdListMarker, bool) {
+	if mdRule.MatchString(trimmed) {
+		return mdListMarker{}, false
+	}
+	var m []string
+	var marker mdListMarker
+	if m = mdBullet.FindStringSubmatch(trimmed); m != nil {
+		marker.delim = m[1]
+	} else if m = mdOrdered.FindStringSubmatch(trimmed); m != nil {
+		marker.ordered = true
+		marker.delim = m[2]
+		marker.start, _ = strconv.Atoi(m[1])
+	} else {
+		return mdListMarker{}, false
+	}
+	markerLen := len(strings.TrimRight(m[0], " \t"))
+	marker.width = len(m[0])
+	marker.empty = strings.TrimSpace(trimmed[markerLen:]) == ""
+	if marker.width-markerLen > 4 || marker.empty {
+		// Code after the marker, or an empty item: the content starts a
+		// space after it.
+		marker.width = markerLen + 1
+	}
+	return marker, true
+}
+
+// mdInterrupts reports whether a line starts a block that ends a paragraph.
+func mdInterrupts(line string) bool {
+	indent, trimmed := mdIndent(line)
+	if indent >= 4 {
+		return false
+	}
+	if _, _, ok := mdFence(trimmed); ok {
+		return true
+	}
+	if mdHeading.MatchString(trimmed) || mdRule.MatchString(trimmed) || strings.HasPrefix(trimmed, ">") {
+		return true
+	}
+	// Only lists that start with their first item and have some content
+	// interrupt a paragraph, so that a number starting a line doesn't.
+	if marker, ok := mdListItem(trimmed); ok && !marker.empty {
+		return !marker.ordered || marker.start == 1
+	}
+	return false
+}
+
+// markdownBlocks writes the HTML of the blocks of the lines. In tight
+// lists, paragraphs are written without their <p>.
+func markdownBlocks(b *strings.Builder, lines []string, tight bool) {
+	for i := 0; i < len(lines); {
+		line := lines[i]
+		if mdBlank(line) {
+			i++
+			continue
+		}
+		indent, trimmed := mdIndent(line)
+		if indent >= 4 {
+			// Indented code is taken as text.
+			indent, trimmed = 0, strings.TrimLeft(trimmed, " ")
+		}
+
+		if fence, info, ok := mdFence(trimmed); ok {
+			i++
+			var code []string
+			for ; i < len(lines); i++ {
+				_, t := mdIndent(lines[i])
+				if strings.HasPrefix(t, fence) && mdBlank(strings.TrimLeft(t, fence[:1])) {
+					i++
+					break
+				}
+				code = append(code, mdDedent(lines[i], indent))
+			}
+			b.WriteString("<pre><code")
+			if lang, _, _ := strings.Cut(info, " "); lang != "" {
+				b.WriteString(` class="language-` + html.EscapeString(lang) + `"`)
+			}
+			b.WriteString(">")
+			for _, l := range code {
+				b.WriteString(html.EscapeString(l) + "\n")
+			}
+			b.WriteString("</code></pre>\n")
+			continue
+		}
+
+		if m := mdHeading.FindStringSubmatch(trimmed); m != nil {
+			level := strconv.Itoa(len(m[1]))
+			b.WriteString("<h" + level + ">" + markdownInline(m[2]) + "</h" + level + ">\n")
+			i++
+			continue
+		}
+
+		if mdRule.MatchString(trimmed) {
+			b.WriteString("<hr>\n")
+			i++
+			continue
+		}
+
+		if strings.HasPrefix(trimmed, ">") {
+			var quoted []string
+			for ; i < len(lines); i++ {
+				_, t := mdIndent(lines[i])
+				if strings.HasPrefix(t, ">") {
+					t = strings.TrimPrefix(t[1:], " ")
+					quoted = append(quoted, t)
+					continue
+				}
+				// Lines that go on a quoted paragraph are quoted too.
+				if mdBlank(lines[i]) || len(quoted) == 0 || mdBlank(quoted[len(quoted)-1]) || mdInterrupts(lines[i]) {
+					break
+				}
+				quoted = append(quoted, t)
+			}
+			b.WriteString("<blockquote>\n")
+			markdownBlocks(b, quoted, false)
+			b.WriteString("</blockquote>\n")
+			continue
+		}
+
+		if marker, ok := mdListItem(trimmed); ok {
+			i = markdownList(b, lines, i, marker)
+			continue
+		}
+
+		// A paragraph, or a setext heading if it's underlined.
+		para := []string{trimmed}
+		heading := ""
+		for i++; i < len(lines); i++ {
+			if mdBlank(lines[i]) {
+				break
+			}
+			_, t := mdIndent(lines[i])
+			if m := mdSetext.FindStringSubmatch(t); m != nil && (m[1][0] == '=' || mdRule.MatchString(t)) {
+				heading = "h1"
+				if m[1][0] == '-' {
+					heading = "h2"
+				}
+				i++
+				break
+			}
+			if mdInterrupts(lines[i]) {
+				break
+			}
+			para = append(para, t)
+		}
+		content := markdownInline(strings.TrimRight(strings.Join(para, "\n"), " "))
+		switch {
+		case heading != "":
+			b.WriteString("<" + heading + ">" + content + "</" + heading + ">\n")
+		case tight:
+			b.WriteString(content + "\n")
+		default:
+			b.WriteString("<p>" + content + "</p>\n")
+		}
+	}
+}
+
+// markdownList writes the list that starts at lines[i], and returns the
+// index of the line after it.
+func markdownList(b *strings.Builder, lines []string, i int, first mdListMarker) int {
+	type listItem struct {
+		lines []string
+	}
+	var items []listItem
+	tight := true
+	for i < len(lines) {
+		indent, trimmed := mdIndent(lines[i])
+		marker, ok := mdListItem(trimmed)
+		if !ok || marker.ordered != first.ordered || marker.delim != first.delim {
+			break
+		}
+		contentIndent := indent + marker.width
+		item := listItem{lines: []string{trimmed[min(marker.width, len(trimmed)):]}}
+		i++
+		for i < len(lines) {
+			line := lines[i]
+			if mdBlank(line) {
+				item.lines = append(item.lines, "")
+				i++
+				continue
+			}
+			lineIndent, lineTrimmed := mdIndent(line)
+			if lineIndent >= contentIndent {
+				item.lines = append(item.lines, mdDedent(line, contentIndent))
+				i++
+				continue
+			}
+			// A line that goes on the item's last paragraph belongs to it.
+			last := item.lines[len(item.lines)-1]
+			if !mdBlank(last) && !mdInterrupts(line) {
+				if _, isItem := mdListItem(lineTrimmed); !isItem {
+					item.lines = append(item.lines, lineTrimmed)
+					i++
+					continue
+				}
+			}
+			break
+		}
+		// Blank lines between the items, or between the blocks of one,
+		// make the list loose.
+		trailing := 0
+		for len(item.lines) > 0 && mdBlank(item.lines[len(item.lines)-1]) {
+			item.lines = item.lines[:len(item.lines)-1]
+			trailing++
+		}
+		if trailing > 0 && i < len(lines) {
+			if _, t := mdIndent(lines[i]); mdIsSameList(t, first) {
+				tight = false
+			}
+		}
+		if mdHasInnerBlankLine(item.lines) {
+			tight = false
+		}
+		items = append(items, item)
+	}
+
+	if first.ordered {
+		if first.start != 1 {
+			b.WriteString(`<ol start="` + strconv.Itoa(first.start) + `">` + "\n")
+		} else {
+			b.WriteString("<ol>\n")
+		}
+	} else {
+		b.WriteString("<ul>\n")
+	}
+	for _, item := range items {
+		b.WriteString("<li>")
+		if !tight {
+			b.WriteString("\n")
+		}
+		markdownBlocks(b, item.lines, tight)
+		b.WriteString("</li>\n")
+	}
+	if first.ordered {
+		b.WriteString("</ol>\n")
+	} else {
+		b.WriteString("</ul>\n")
+	}
+	return i
+}
+
+func mdIsSameList(trimmed string, first mdListMarker) bool {
+	marker, ok := mdListItem(trimmed)
+	return ok && marker.ordered == first.ordered && marker.delim == first.delim
+}
+
+// mdHasInnerBlankLine reports whether a blank line separates two blocks of
+// an item, outside its fenced code.
+func mdHasInnerBlankLine(lines []string) bool {
+	fence := ""
+	for j, line := range lines {
+		_, t := mdIndent(line)
+		if fence != "" {
+			if strings.HasPrefix(t, fence) {
+				fence = ""
+			}
+			continue
+		}
+		if f, _, ok := mdFence(t); ok {
+			fence = f
+			continue
+		}
+		if mdBlank(line) && j > 0 && j < len(lines)-1 {
+			// Blank lines inside a nested list are the nested list's.
+			if indent, _ := mdIndent(lines[j+1]); indent == 0 {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// mdDedent removes up to n leading spaces from a line.
+func mdDedent(line string, n int) string {
+	indent, _ := mdIndent(line)
+	return line[min(indent, n):]
+}
+
+// mdMaxSpan is how far a code span, link or emphasis can reach, so that
+// the delimiters that are never closed don't take quadratic time.
+const mdMaxSpan = 1 << 10
+
+// mdWindow returns s from i on, up to mdMaxSpan long.
+func mdWindow(s string, i int) string {
+	return s[i:min(len(s), i+mdMaxSpan)]
+}
+
+// markdownInline returns the HTML of the inline content of a block.
+func markdownInline(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); {
+		c := s[i]
+		switch {
+		case c == '\\' && i+1 < len(s) && s[i+1] == '\n':
+			b.WriteString("<br>\n")
+			i += 2
+			continue
+
+		case c == '\\' && i+1 < len(s) && mdEscapable(s[i+1]):
+			b.WriteString(html.EscapeString(s[i+1 : i+2]))
+			i += 2
+			continue
+
+		case c == '\n':
+			if strings.HasSuffix(s[:i], "  ") {
+				b.WriteString("<br>")
+			}
+			b.WriteString("\n")
+			i++
+			continue
+
+		case c == '`':
+			n := len(s[i:]) - len(strings.TrimLeft(s[i:], "`"))
+			ticks := s[i : i+n]
+			if end := mdClosingTicks(mdWindow(s, i+n), ticks); end >= 0 {
+				code := strings.ReplaceAll(s[i+n:i+n+end], "\n", " ")
+				if len(code) > 2 && code[0] == ' ' && code[len(code)-1] == ' ' && strings.TrimSpace(code) != "" {
+					code = code[1 : len(code)-1]
+				}
+				b.WriteString("<code>" + html.EscapeString(code) + "</code>")
+				i += n + end + n
+				continue
+			}
+			b.WriteString(ticks)
+			i += n
+			continue
+
+		case c == '!' && strings.HasPrefix(s[i+1:], "["):
+			if text, dest, title, n, ok := mdLink(mdWindow(s, i+1)); ok {
+				b.WriteString(`<img src="` + html.EscapeString(dest) + `" alt="` + html.EscapeString(mdPlain(text)) + `"`)
+				if title != "" {
+					b.WriteString(` title="` + html.EscapeString(title) + `"`)
+				}
+				b.WriteString(">")
+				i += 1 + n
+				continue
+			}
+
+		case c == '[':
+			if text, dest, title, n, ok := mdLink(mdWindow(s, i)); ok {
+				b.WriteString(`<a href="` + html.EscapeString(dest) + `"`)
+				if title != "" {
+					b.WriteString(` title="` + html.EscapeString(title) + `"`)
+				}
+				b.WriteString(">" + markdownInline(text) + "</a>")
+				i += n
+				continue
+			}
+
+		case c == '<':
+			if m := mdAutolinkURL.FindStringSubmatch(s[i:]); m != nil {
+				b.WriteString(`<a href="` + html.EscapeString(m[1]) + `">` + html.EscapeString(m[1]) + "</a>")
+				i += len(m[0])
+				continue
+			}
+			if m := mdAutolinkAt.FindStringSubmatch(s[i:]); m != nil {
+				b.WriteString(`<a href="mailto:` + html.EscapeString(m[1]) + `">` + html.EscapeString(m[1]) + "</a>")
+				i += len(m[0])
+				continue
+			}
+
+		case c == 'h' && (i == 0 || !mdWordByte(s[i-1])):
+			if m := mdBareURL.FindString(s[i:]); m != "" {
+				m = mdTrimURL(m)
+				b.WriteString(`<a href="` + html.EscapeString(m) + `">` + html.EscapeString(m) + "</a>")
+				i += len(m)
+				continue
+			}
+
+		case c == '*' || c == '_':
+			if open, close, inner, n, ok := mdEmphasis(s, i); ok {
+				b.WriteString(open + markdownInline(inner) + close)
+				i += n
+				continue
+			}
+			n := len(s[i:]) - len(strings.TrimLeft(s[i:], s[i:i+1]))
+			b.WriteString(s[i : i+n])
+			i += n
+			continue
+		}
+
+		_, size := utf8.DecodeRuneInString(s[i:])
+		b.WriteString(html.EscapeString(s[i : i+size]))
+		i += size
+	}
+	return b.String()
+}
+
+// mdClosingTicks returns where the run of backticks that closes a code
+// span starts in s, or -1.
+func mdClosingTicks(s, ticks string) int {
+	for i := 0; i < len(s); {
+		j := strings.Index(s[i:], ticks)
+		if j < 0 {
+			return -1
+		}
+		j += i
+		end := j + len(ticks)
+		if end < len(s) && s[end] == '`' {
+			i = end + len(s[end:]) - len(strings.TrimLeft(s[end:], "`"))
+			continue
+		}
+		return j
+	}
+	return -1
+}
+
+// mdLink parses a [text](destination "title") at the start of s, returning
+// its parts and its length.
+func mdLink(s string) (text, dest, title string, n int, ok bool) {
+	depth := 0
+	closing := -1
+	for i := 0; i < len(s) && closing < 0; i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case '`':
+			// Brackets in code spans don't count.
+			if end := mdClosingTicks(s[i+1:], "`"); end >= 0 {
+				i += end + 1
+			}
+		case '[':
+			depth++
+		case ']':
+			depth--
+			if depth == 0 {
+				closing = i
+			}
+		}
+	}
+	if closing < 0 || closing+1 >= len(s) || s[closing+1] != '(' {
+		return "", "", "", 0, false
+	}
+	text = s[1:closing]
+	rest := s[closing+2:]
+	i := len(rest) - len(strings.TrimLeft(rest, " \n"))
+	if i < len(rest) && rest[i] == '<' {
+		end := strings.IndexAny(rest[i+1:], ">\n")
+		if end < 0 || rest[i+1+end] != '>' {
+			return "", "", "", 0, false
+		}
+		dest = rest[i+1 : i+1+end]
+		i += end + 2
+	} else {
+		parens := 0
+		start := i
+	dest:
+		for ; i < len(rest); i++ {
+			switch rest[i] {
+			case '\\':
+				i++
+			case '(':
+				parens++
+			case ')':
+				if parens == 0 {
+					break dest
+				}
+				parens--
+			case ' ', '\n':
+				break dest
+			}
+		}
+		if i > len(rest) {
+			return "", "", "", 0, false
+		}
+		dest = rest[start:i]
+	}
+	i += len(rest[i:]) - len(strings.TrimLeft(rest[i:], " \n"))
+	if i < len(rest) && (rest[i] == '"' || rest[i] == '\'' || rest[i] == '(') {
+		closer := rest[i]
+		if closer == '(' {
+			closer = ')'
+		}
+		end := strings.IndexByte(rest[i+1:], closer)
+		if end < 0 {
+			return "", "", "", 0, false
+		}
+		title = mdUnescape(rest[i+1 : i+1+end])
+		i += end + 2
+		i += len(rest[i:]) - len(strings.TrimLeft(rest[i:], " \n"))
+	}
+	if i >= len(rest) || rest[i] != ')' {
+		return "", "", "", 0, false
+	}
+	return text, mdUnescape(dest), title, closing + 2 + i + 1, true
+}
+
+// mdEmphasis parses the emphasis or strong emphasis that starts at s[i],
+// returning its tags, the text inside it and its length.
+func mdEmphasis(s string, i int) (open, close, inner string, n int, ok bool) {
+	c := s[i : i+1]
+	run := len(s[i:]) - len(strings.TrimLeft(s[i:], c))
+	after := s[i+run:]
+	// An opener is followed by text, and an underscore one isn't inside
+	// a word, like snake_case.
+	if after == "" || unicode.IsSpace(mdFirstRune(after)) {
+		return "", "", "", 0, false
+	}
+	if c == "_" && i > 0 && mdWordByte(s[i-1]) {
+		return "", "", "", 0, false
+	}
+	for _, size := range []int{3, 2, 1} {
+		if run < size {
+			continue
+		}
+		delim := strings.Repeat(c, size)
+		body := mdWindow(s, i+size)
+		for from := 1; from < len(body); {
+			j := strings.Index(body[from:], delim)
+			if j < 0 {
+				break
+			}
+			j += from
+			end := j + size
+			closes := !unicode.IsSpace(mdLastRune(body[:j]))
+			if c == "_" && end < len(body) && mdWordByte(body[end]) {
+				closes = false
+			}
+			// A single delimiter doesn't close on the first of a pair,
+			// which belongs to a strong emphasis inside.
+			if size == 1 && end < len(body) && body[end] == c[0] {
+				pair := len(body[j:]) - len(strings.TrimLeft(body[j:], c))
+				if pair%2 == 0 {
+					from = j + pair
+					continue
+				}
+				j += pair - 1
+				end = j + 1
+			}
+			if closes && strings.Count(body[:j], "`")%2 == 0 {
+				switch size {
+				case 3:
+					return "<em><strong>", "</strong></em>", body[:j], size + end, true
+				case 2:
+					return "<strong>", "</strong>", body[:j], size + end, true
+				}
+				return "<em>", "</em>", body[:j], size + end, true
+			}
+			from = j + 1
+		}
+	}
+	return "", "", "", 0, false
+}
+
+// mdPlain returns the text of inline Markdown, for alt texts.
+func mdPlain(s string) string {
+	return strings.NewReplacer("*", "", "_", "", "`", "").Replace(s)
+}
+
+// mdUnescape removes the backslashes that escape punctuation.
+func mdUnescape(s string) string {
+	if !strings.Contains(s, "\\") {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+1 < len(s) && mdEscapable(s[i+1]) {
+			i++
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+// mdTrimURL removes the punctuation that ends a sentence after a bare URL,
+// and the closing parenthesis of one written in parentheses.
+func mdTrimURL(u string) string {
+	for len(u) > 0 {
+		last := u[len(u)-1]
+		switch {
+		case strings.IndexByte(".,:;!?'*_~", last) >= 0:
+			u = u[:len(u)-1]
+		case last == ')' && strings.Count(u, ")") > strings.Count(u, "("):
+			u = u[:len(u)-1]
+		default:
+			return u
+		}
+	}
+	return u
+}
+
+// mdEscapable reports whether a backslash escapes the character, ASCII
+// punctuation.
+func mdEscapable(c byte) bool {
+	return c < 0x80 && (unicode.IsPunct(rune(c)) || unicode.IsSymbol(rune(c)))
+}
+
+func mdWordByte(c byte) bool {
+	return c >= 0x80 || c == '_' || c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+
+func mdFirstRune(s string) rune {
+	r, _ := utf8.DecodeRuneInString(s)
+	return r
+}
+
+func mdLastRune(s string) rune {
+	r, _ := utf8.DecodeLastRuneInString(s)
+	return r
+}
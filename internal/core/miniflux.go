@@ -0,0 +1,117 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	db "github.com/egemengol/kindlepathy/internal/db/generated"
+)
+
+type minifluxEntry struct {
+	ID    int64  `json:"id"`
+	Title string `json:"title"`
+	URL   string `json:"url"`
+}
+
+// syncMiniflux pulls starred or unread entries changed since the last sync,
+// then marks entries that were read here as read in Miniflux if enabled.
+func (c *Core) syncMiniflux(ctx context.Context, integration db.Integration) error {
+	query := url.Values{}
+	query.Set("limit", "100")
+	query.Set("order", "changed_at")
+	query.Set("direction", "desc")
+	if integration.FetchFilter == "unread" {
+		query.Set("status", "unread")
+	} else {
+		query.Set("starred", "true")
+	}
+	if integration.LastSyncTs != nil {
+		query.Set("changed_after", strconv.FormatInt(integration.LastSyncTs.(int64), 10))
+	}
+
+	var page struct {
+		Entries []minifluxEntry `json:"entries"`
+	}
+	if err := c.minifluxRequest(ctx, integration, "GET", "/v1/entries?"+query.Encode(), nil, &page); err != nil {
+		return fmt.Errorf("failed to list entries: %w", err)
+	}
+
+	for _, entry := range page.Entries {
+		b := bookmark{
+			URL:     entry.URL,
+			Title:   entry.Title,
+			AddedAt: time.Now(),
+		}
+		err := c.addIntegrationItem(ctx, integration, strconv.FormatInt(entry.ID, 10), b)
+		if err != nil {
+			c.Logger.Warn("failed to add miniflux entry", "error", err, "entryID", entry.ID, "url", entry.URL)
+		}
+	}
+
+	read, err := c.readIntegrationItems(ctx, integration)
+	if err != nil || len(read) == 0 {
+		return err
+	}
+	entryIDs := make([]int64, 0, len(read))
+	for _, r := range read {
+		entryID, err := strconv.ParseInt(r.ExternalID, 10, 64)
+		if err != nil {
+			continue
+		}
+		entryIDs = append(entryIDs, entryID)
+	}
+	body := map[string]any{
+		"entry_ids": entryIDs,
+		"status":    "read",
+	}
+	if err := c.minifluxRequest(ctx, integration, "PUT", "/v1/entries", body, nil); err != nil {
+		return fmt.Errorf("failed to mark entries read: %w", err)
+	}
+	for _, r := range read {
+		if err := c.queries.ItemSourcesSetSyncedRead(ctx, r.ItemID); err != nil {
+			return fmt.Errorf("failed to mark item synced: %w", err)
+		}
+	}
+	return nil
+}
+
+func (c *Core) minifluxRequest(ctx context.Context, integration db.Integration, method, path string, body any, out any) error {
+	var reqBody io.Reader
+	if body != nil {
+		bodyBytes, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reqBody = bytes.NewReader(bodyBytes)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, integration.BaseUrl+path, reqBody)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("X-Auth-Token", integration.Token)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("miniflux returned status %d", resp.StatusCode)
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(io.LimitReader(resp.Body, maxIntegrationResponseBytes)).Decode(out)
+}
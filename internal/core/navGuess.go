@@ -0,0 +1,178 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Novel aggregators often have no next link readability or extract-nav
+// can find. A page without one gets the URL of the page with its chapter
+// number one up, chapter-2460 after chapter-2459, /page/7 after /page/6,
+// if the site has a page there, asked with a HEAD request. The previous
+// link is guessed the same way.
+
+// navGuessedConfidence is the confidence of a guessed link, a page is at
+// the URL but nothing on the page links to it.
+const navGuessedConfidence = 0.6
+
+// urlNumberLabel is a path segment naming the number in the next one, the
+// "page" of /page/7.
+var urlNumberLabel = regexp.MustCompile(`(?i)^(?:chapter|chapitre|chap|ch|episode|ep|part|page)s?$`)
+
+// urlNumberParams are the query parameters numbering the chapters, in
+// order.
+var urlNumberParams = []string{"chapter", "ch", "episode", "ep", "page"}
+
+// guessChapterNav fills in the nav links the page has none of.
+func (c *Core) guessChapterNav(ctx context.Context, doc *Document) {
+	if _, ok := c.navStrategies(doc.URL); !ok {
+		return
+	}
+	if doc.Clean.NavNext == "" {
+		if next, ok := c.findChapterURL(ctx, doc.URL, 1); ok {
+			doc.Clean.NavNext, doc.Clean.NavNextConfidence = next, navGuessedConfidence
+		}
+	}
+	if doc.Clean.NavPrev == "" {
+		if prev, ok := c.findChapterURL(ctx, doc.URL, -1); ok {
+			doc.Clean.NavPrev, doc.Clean.NavPrevConfidence = prev, navGuessedConfidence
+		}
+	}
+}
+
+// findChapterURL returns the first of the URLs of the page with its
+// chapter number changed by step that the site has a page at.
+func (c *Core) findChapterURL(ctx context.Context, pageURL string, step int) (string, bool) {
+	from, err := url.Parse(pageURL)
+	if err != nil {
+		return "", false
+	}
+	for _, guess := range chapterURLGuesses(from, step) {
+		found, err := c.pageAt(ctx, guess)
+		if err != nil {
+			c.Logger.Debug("no chapter at guessed URL", "url", guess, "error", err)
+			continue
+		}
+		// A site sending every missing chapter to its index has no
+		// chapter there.
+		if got, ok := chapterStep(from, found); ok && got == step {
+			return found.String(), true
+		}
+	}
+	return "", false
+}
+
+// pageAt asks the site for the page with a HEAD request, or a GET when it
+// doesn't take HEAD, returning the URL it ends up at after redirects.
+func (c *Core) pageAt(ctx context.Context, pageURL string) (*url.URL, error) {
+	do := func(method string) (*http.Response, error) {
+		req, err := http.NewRequestWithContext(ctx, method, pageURL, nil)
+		if err != nil {
+			return nil, err
+		}
+		c.setFetchHeaders(req)
+		if err := c.setUserCookies(ctx, req); err != nil {
+			return nil, err
+		}
+		if err := c.preFetch(ctx, req); err != nil {
+			return nil, err
+		}
+		resp, err := c.doPolitely(req)
+		if err != nil {
+			return nil, err
+		}
+		resp.Body.Close()
+		return resp, nil
+	}
+	resp, err := do(http.MethodHead)
+	if err == nil && (resp.StatusCode == http.StatusMethodNotAllowed || resp.StatusCode == http.StatusNotImplemented) {
+		resp, err = do(http.MethodGet)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("status %d", resp.StatusCode)
+	}
+	return resp.Request.URL, nil
+}
+
+// chapterURLGuesses returns the URLs the chapter step away from the page
+// may be at: the labeled number of the last path segment that has one
+// changed, keeping the chapter's slug and without it, or the number after
+// a label segment, or the number of a query parameter.
+func chapterURLGuesses(from *url.URL, step int) []string {
+	with := func(path string, query url.Values) string {
+		u := *from
+		u.Path, u.RawPath, u.Fragment = path, "", ""
+		if query != nil {
+			u.RawQuery = query.Encode()
+		}
+		return u.String()
+	}
+
+	segments := strings.Split(from.Path, "/")
+	for i := len(segments) - 1; i >= 0; i-- {
+		segment := segments[i]
+		if m := urlChapterNumber.FindStringSubmatchIndex(segment); m != nil {
+			number, ok := stepNumber(segment[m[2]:m[3]], step)
+			if !ok {
+				return nil
+			}
+			prefix, rest := segment[:m[2]], segment[m[3]:]
+			var guesses []string
+			for _, changed := range []string{prefix + number + rest, prefix + number} {
+				segments[i] = changed
+				guess := with(strings.Join(segments, "/"), nil)
+				if len(guesses) == 0 || guesses[0] != guess {
+					guesses = append(guesses, guess)
+				}
+				// An extension isn't a slug.
+				if rest == "" || strings.HasPrefix(rest, ".") {
+					break
+				}
+			}
+			return guesses
+		}
+		if i > 0 && isNumber(segment) && urlNumberLabel.MatchString(segments[i-1]) {
+			number, ok := stepNumber(segment, step)
+			if !ok {
+				return nil
+			}
+			segments[i] = number
+			return []string{with(strings.Join(segments, "/"), nil)}
+		}
+	}
+
+	query := from.Query()
+	for _, param := range urlNumberParams {
+		if value := query.Get(param); isNumber(value) {
+			number, ok := stepNumber(value, step)
+			if !ok {
+				return nil
+			}
+			query.Set(param, number)
+			return []string{with(from.Path, query)}
+		}
+	}
+	return nil
+}
+
+// stepNumber changes the number by step, keeping its zero padding, "009"
+// is followed by "010". Numbers don't go below 0.
+func stepNumber(s string, step int) (string, bool) {
+	n, err := strconv.Atoi(s)
+	if err != nil || n+step < 0 {
+		return "", false
+	}
+	return fmt.Sprintf("%0*d", len(s), n+step), true
+}
+
+func isNumber(s string) bool {
+	return s != "" && len(s) <= 9 && strings.Trim(s, "0123456789") == ""
+}
@@ -0,0 +1,228 @@
+package core
+
+import (
+	"maps"
+	"slices"
+	"strings"
+	"unicode"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// Serials in other languages link their chapters in words of their own.
+// The language of a page is the one it declares and the one its text looks
+// like, many themes declare English whatever they're written in, and its
+// links are matched against the English patterns and those of its
+// languages. The links of a page in no known language are matched against
+// all of them.
+
+type navLanguage struct {
+	next []string
+	prev []string
+	// words are common words of the language, for telling the languages
+	// in Latin script apart.
+	words []string
+}
+
+var navLanguages = map[string]navLanguage{
+	// The English patterns are matched on every page.
+	"en": {
+		words: []string{"the", "and", "of", "to", "was", "that", "his", "her", "with", "you", "it", "he"},
+	},
+	"de": {
+		next:  []string{"weiter", "nächste", "nächstes kapitel", "nächster teil"},
+		prev:  []string{"zurück", "vorherige", "vorheriges kapitel", "vorheriger teil"},
+		words: []string{"der", "die", "das", "und", "ist", "nicht", "ein", "eine", "ich", "sie", "mit", "den", "auf"},
+	},
+	"es": {
+		next:  []string{"siguiente", "capítulo siguiente", "próximo capítulo"},
+		prev:  []string{"anterior", "capítulo anterior"},
+		words: []string{"el", "los", "las", "que", "y", "una", "por", "con", "del", "se", "pero", "como", "muy"},
+	},
+	"fr": {
+		next:  []string{"suivant", "suivante", "chapitre suivant"},
+		prev:  []string{"précédent", "précédente", "chapitre précédent"},
+		words: []string{"le", "les", "et", "est", "une", "des", "que", "pas", "dans", "il", "elle", "qui", "au"},
+	},
+	"id": {
+		next:  []string{"selanjutnya", "berikutnya", "bab selanjutnya"},
+		prev:  []string{"sebelumnya", "bab sebelumnya"},
+		words: []string{"yang", "dan", "di", "itu", "dengan", "tidak", "ini", "dari", "untuk", "aku", "akan", "ke"},
+	},
+	"it": {
+		next:  []string{"successivo", "prossimo", "capitolo successivo", "avanti"},
+		prev:  []string{"precedente", "capitolo precedente", "indietro"},
+		words: []string{"il", "di", "che", "non", "un", "per", "sono", "della", "gli", "è", "anche", "ma", "nel"},
+	},
+	"ja": {
+		next: []string{"次へ", "次の話", "次話", "次のページ", "次の章", "次のエピソード"},
+		prev: []string{"前へ", "前の話", "前話", "前のページ", "前の章", "前のエピソード"},
+	},
+	"ko": {
+		next: []string{"다음화", "다음 화", "다음 장", "다음 편", "다음"},
+		prev: []string{"이전화", "이전 화", "이전 장", "이전 편", "이전"},
+	},
+	"pl": {
+		next:  []string{"następny", "następna", "następny rozdział", "dalej"},
+		prev:  []string{"poprzedni", "poprzednia", "poprzedni rozdział", "wstecz"},
+		words: []string{"i", "w", "nie", "się", "na", "że", "z", "do", "jest", "jak", "ale", "to"},
+	},
+	"pt": {
+		next:  []string{"próximo", "próxima", "seguinte", "próximo capítulo"},
+		prev:  []string{"anterior", "capítulo anterior", "voltar"},
+		words: []string{"o", "não", "uma", "com", "os", "do", "da", "para", "em", "que", "e", "mas", "ele"},
+	},
+	"ru": {
+		next: []string{"следующая", "следующая глава", "далее", "дальше", "вперёд", "вперед"},
+		prev: []string{"предыдущая", "предыдущая глава", "назад"},
+	},
+	"tr": {
+		next:  []string{"sonraki", "sonraki bölüm", "ileri"},
+		prev:  []string{"önceki", "önceki bölüm", "geri dön"},
+		words: []string{"ve", "bir", "bu", "için", "ile", "çok", "ne", "gibi", "ama", "olan", "daha", "değil"},
+	},
+	"uk": {
+		next: []string{"наступна", "наступний", "наступний розділ", "далі", "вперед"},
+		prev: []string{"попередня", "попередній", "попередній розділ", "назад"},
+	},
+	"vi": {
+		next:  []string{"chương sau", "chương tiếp", "tiếp theo"},
+		prev:  []string{"chương trước"},
+		words: []string{"của", "và", "là", "không", "có", "những", "một", "người", "được", "trong"},
+	},
+	"zh": {
+		next: []string{"下一章", "下一页", "下一頁", "下一篇", "下一节", "下一節", "下章", "下页"},
+		prev: []string{"上一章", "上一页", "上一頁", "上一篇", "上一节", "上一節", "上章", "上页"},
+	},
+}
+
+// languageSampleBytes is how much of the text of a page its language is
+// guessed from.
+const languageSampleBytes = 64 << 10
+
+// navPatterns returns the patterns of the next and previous links of pages
+// in langs.
+func navPatterns(langs []string) (next, prev []string) {
+	next, prev = slices.Clip(patternsNext), slices.Clip(patternsPrev)
+	known := false
+	for _, lang := range langs {
+		if l, ok := navLanguages[lang]; ok {
+			next, prev = append(next, l.next...), append(prev, l.prev...)
+			known = true
+		}
+	}
+	if known {
+		return next, prev
+	}
+	for _, lang := range slices.Sorted(maps.Keys(navLanguages)) {
+		next, prev = append(next, navLanguages[lang].next...), append(prev, navLanguages[lang].prev...)
+	}
+	return next, prev
+}
+
+// documentLanguages returns the language the page declares and the one
+// its text looks like, as primary subtags like "de", once each.
+func documentLanguages(doc *goquery.Document) []string {
+	var langs []string
+	add := func(lang string) {
+		if lang != "" && !slices.Contains(langs, lang) {
+			langs = append(langs, lang)
+		}
+	}
+	html := doc.Find("html")
+	for _, declared := range []string{
+		html.AttrOr("lang", ""),
+		html.AttrOr("xml:lang", ""),
+		doc.Find(`meta[http-equiv="content-language" i]`).AttrOr("content", ""),
+		doc.Find(`meta[property="og:locale"]`).AttrOr("content", ""),
+	} {
+		if lang := primaryLanguage(declared); lang != "" {
+			add(lang)
+			break
+		}
+	}
+	add(guessLanguage(doc.Find("body").Text()))
+	return langs
+}
+
+// primaryLanguage returns the primary subtag of a language tag, "pt" of
+// "pt-BR" or "pt_BR", the first of a list.
+func primaryLanguage(tag string) string {
+	tag, _, _ = strings.Cut(tag, ",")
+	tag = strings.ToLower(strings.TrimSpace(tag))
+	tag, _, _ = strings.Cut(strings.ReplaceAll(tag, "_", "-"), "-")
+	if len(tag) < 2 || len(tag) > 3 || strings.Trim(tag, "abcdefghijklmnopqrstuvwxyz") != "" {
+		return ""
+	}
+	return tag
+}
+
+// guessLanguage guesses the language of text from its script, and from
+// its common words for the ones in Latin script, "" when it can't tell.
+func guessLanguage(text string) string {
+	if len(text) > languageSampleBytes {
+		text = strings.ToValidUTF8(text[:languageSampleBytes], "")
+	}
+	var latin, cyrillic, ukrainian, han, kana, hangul int
+	for _, r := range text {
+		switch {
+		case r < 0x80:
+			if unicode.IsLetter(r) {
+				latin++
+			}
+		case unicode.Is(unicode.Hiragana, r), unicode.Is(unicode.Katakana, r):
+			kana++
+		case unicode.Is(unicode.Han, r):
+			han++
+		case unicode.Is(unicode.Hangul, r):
+			hangul++
+		case unicode.Is(unicode.Cyrillic, r):
+			cyrillic++
+			if strings.ContainsRune("іїєґІЇЄҐ", r) {
+				ukrainian++
+			}
+		case unicode.Is(unicode.Latin, r):
+			latin++
+		}
+	}
+
+	cjk := han + kana + hangul
+	switch {
+	case cjk > latin && cjk > cyrillic:
+		switch {
+		case hangul > han+kana:
+			return "ko"
+		case kana*20 > han:
+			return "ja"
+		}
+		return "zh"
+	case cyrillic > latin:
+		if ukrainian*100 > cyrillic {
+			return "uk"
+		}
+		return "ru"
+	case latin == 0:
+		return ""
+	}
+
+	counts := map[string]int{}
+	for _, word := range strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r)
+	}) {
+		for lang, l := range navLanguages {
+			if slices.Contains(l.words, word) {
+				counts[lang]++
+			}
+		}
+	}
+	best, bestCount := "", 0
+	for _, lang := range slices.Sorted(maps.Keys(counts)) {
+		if counts[lang] > bestCount {
+			best, bestCount = lang, counts[lang]
+		}
+	}
+	if bestCount < 5 {
+		return ""
+	}
+	return best
+}
@@ -0,0 +1,413 @@
+package core
+
+import (
+	"fmt"
+	"net/url"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// The nav links of a page are found by strategies, each scoring the links
+// it has an opinion on its own way: the rel attributes of the links, the
+// site's selectors in its pipeline, the chapter number in the URLs and the
+// words and places of the links. The points of a link add up over the
+// strategies, and the pick is as sure as its lead over the next best link.
+// Unsure picks come with the other likely links, for the reader to choose
+// the right one instead of following a wrong guess.
+
+type Nav struct {
+	Next string `json:"next"`
+	Prev string `json:"prev"`
+	// NextConfidence and PrevConfidence are how sure the picks are, from
+	// 0 to 1.
+	NextConfidence float64 `json:"next_confidence"`
+	PrevConfidence float64 `json:"prev_confidence"`
+}
+
+// NavReport is every nav candidate of a page, best first, for diagnosing
+// wrong picks.
+type NavReport struct {
+	Nav
+	// Languages are the languages of the page whose patterns were matched.
+	Languages      []string     `json:"languages"`
+	NextCandidates []ScoredLink `json:"next_candidates"`
+	PrevCandidates []ScoredLink `json:"prev_candidates"`
+}
+
+// NavLink is a link the reader can take instead of an unsure pick.
+type NavLink struct {
+	URL  string `json:"url"`
+	Text string `json:"text"`
+}
+
+// NavUnsureBelow is the confidence below which a pick is unsure.
+const NavUnsureBelow = 0.5
+
+// navSureLead is the lead in points over the next best link that makes a
+// pick sure.
+const navSureLead = 200
+
+// navMaxAlternatives is how many other links come with an unsure pick.
+const navMaxAlternatives = 3
+
+type navDirection string
+
+const (
+	navNext navDirection = "next"
+	navPrev navDirection = "prev"
+)
+
+// navPage is a page being looked through for its nav links.
+type navPage struct {
+	URL string
+	Doc *goquery.Document
+	// Links are the elements linking to other pages of the site.
+	Links    []navLink
+	patterns map[navDirection][]string
+}
+
+type navLink struct {
+	URL     string
+	Text    string
+	Element *goquery.Selection
+}
+
+// A navStrategy finds nav links its own way.
+type navStrategy interface {
+	Name() string
+	// Score scores the links of the page it has an opinion on.
+	Score(page *navPage, dir navDirection) []ScoredLink
+}
+
+var defaultNavStrategies = []navStrategy{relNavStrategy{}, chapterURLNavStrategy{}, heuristicNavStrategy{}}
+
+func extractNavReport(htmlContent string, baseURL string, strategies []navStrategy) *NavReport {
+	report := &NavReport{NextCandidates: []ScoredLink{}, PrevCandidates: []ScoredLink{}}
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(htmlContent))
+	if err != nil {
+		return report
+	}
+
+	report.Languages = documentLanguages(doc)
+	patternsNext, patternsPrev := navPatterns(report.Languages)
+	page := &navPage{
+		URL:      baseURL,
+		Doc:      doc,
+		patterns: map[navDirection][]string{navNext: patternsNext, navPrev: patternsPrev},
+	}
+	doc.Find(selector).Each(func(i int, s *goquery.Selection) {
+		elemURL := getURLfromElem(s)
+		if elemURL == "" || !isURLsameSiteDiffPage(baseURL, elemURL) {
+			return
+		}
+		page.Links = append(page.Links, navLink{
+			URL:     resolveURL(elemURL, baseURL),
+			Text:    strings.Join(strings.Fields(s.Text()), " "),
+			Element: s,
+		})
+	})
+
+	report.NextCandidates = rankNavCandidates(page, navNext, strategies)
+	report.PrevCandidates = rankNavCandidates(page, navPrev, strategies)
+	if len(report.NextCandidates) > 0 {
+		report.Next = report.NextCandidates[0].URL
+		report.NextConfidence = navConfidence(report.NextCandidates)
+	}
+	if len(report.PrevCandidates) > 0 {
+		report.Prev = report.PrevCandidates[0].URL
+		report.PrevConfidence = navConfidence(report.PrevCandidates)
+	}
+	return report
+}
+
+// rankNavCandidates adds up the points of the links over the strategies,
+// best first. A link found twice by a strategy, at the top and the bottom
+// of the page, gets its points once.
+func rankNavCandidates(page *navPage, dir navDirection, strategies []navStrategy) []ScoredLink {
+	candidates := []ScoredLink{}
+	index := map[string]int{}
+	for _, strategy := range strategies {
+		best := map[string]ScoredLink{}
+		var order []string
+		for _, link := range strategy.Score(page, dir) {
+			for i := range link.Reasons {
+				link.Reasons[i].Strategy = strategy.Name()
+			}
+			prev, seen := best[link.URL]
+			if !seen {
+				order = append(order, link.URL)
+			}
+			if !seen || link.Score > prev.Score {
+				best[link.URL] = link
+			}
+		}
+		for _, linkURL := range order {
+			link := best[linkURL]
+			i, ok := index[linkURL]
+			if !ok {
+				index[linkURL] = len(candidates)
+				candidates = append(candidates, link)
+				continue
+			}
+			candidates[i].Score += link.Score
+			candidates[i].Reasons = append(candidates[i].Reasons, link.Reasons...)
+		}
+	}
+	// Highest score first, the first one found wins ties.
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].Score > candidates[j].Score
+	})
+	return candidates
+}
+
+// navConfidence is how sure the pick of the candidates is, its lead over
+// the next best in parts of navSureLead.
+func navConfidence(candidates []ScoredLink) float64 {
+	lead := candidates[0].Score
+	if len(candidates) > 1 {
+		lead -= max(candidates[1].Score, 0)
+	}
+	return min(max(float64(lead)/navSureLead, 0.01), 1)
+}
+
+// navAlternatives returns the likely links after the pick when it's
+// unsure.
+func navAlternatives(candidates []ScoredLink, confidence float64) []NavLink {
+	if confidence >= NavUnsureBelow {
+		return nil
+	}
+	var links []NavLink
+	for _, candidate := range candidates[min(1, len(candidates)):] {
+		if candidate.Score <= 0 || len(links) == navMaxAlternatives {
+			break
+		}
+		links = append(links, NavLink{URL: candidate.URL, Text: candidate.Text})
+	}
+	return links
+}
+
+func scoredLink(link navLink, points int, format string, args ...any) ScoredLink {
+	return ScoredLink{
+		URL:     link.URL,
+		Text:    link.Text,
+		Score:   points,
+		Reasons: []ScoreReason{{Reason: fmt.Sprintf(format, args...), Points: points}},
+		Element: link.Element,
+	}
+}
+
+// relNavStrategy takes the links the page marks rel=next and rel=prev, in
+// its body and its head.
+type relNavStrategy struct{}
+
+func (relNavStrategy) Name() string { return "rel" }
+
+func (relNavStrategy) Score(page *navPage, dir navDirection) []ScoredLink {
+	rels := []string{string(dir)}
+	if dir == navPrev {
+		rels = append(rels, "previous")
+	}
+	hasRel := func(s *goquery.Selection) bool {
+		for _, rel := range strings.Fields(strings.ToLower(s.AttrOr("rel", ""))) {
+			if slices.Contains(rels, rel) {
+				return true
+			}
+		}
+		return false
+	}
+
+	var scored []ScoredLink
+	for _, link := range page.Links {
+		if hasRel(link.Element) {
+			scored = append(scored, scoredLink(link, 1000, "rel=%s", dir))
+		}
+	}
+	page.Doc.Find("link[rel][href]").Each(func(_ int, s *goquery.Selection) {
+		href := s.AttrOr("href", "")
+		if !hasRel(s) || !isURLsameSiteDiffPage(page.URL, href) {
+			return
+		}
+		link := navLink{URL: resolveURL(href, page.URL), Element: s}
+		scored = append(scored, scoredLink(link, 1000, "<link rel=%s>", dir))
+	})
+	return scored
+}
+
+// chapterURLNavStrategy takes the links whose URL is the page's with the
+// chapter number one up or down, /chapter-12 to /chapter-13.
+type chapterURLNavStrategy struct{}
+
+func (chapterURLNavStrategy) Name() string { return "chapter-url" }
+
+func (chapterURLNavStrategy) Score(page *navPage, dir navDirection) []ScoredLink {
+	pageURL, err := url.Parse(page.URL)
+	if err != nil {
+		return nil
+	}
+	want := 1
+	if dir == navPrev {
+		want = -1
+	}
+	var scored []ScoredLink
+	for _, link := range page.Links {
+		linkURL, err := url.Parse(link.URL)
+		if err != nil {
+			continue
+		}
+		if step, ok := chapterStep(pageURL, linkURL); ok && step == want {
+			scored = append(scored, scoredLink(link, 400, "chapter number %+d", step))
+		}
+	}
+	return scored
+}
+
+// chapterStep returns by how much the number in the URL of a page goes up
+// in the URL of a link, when the URLs differ only in it and what follows
+// it in its path segment, a chapter's slug. Numbers in the query count as
+// well when the paths are the same, ?page=2 to ?page=3.
+func chapterStep(pageURL, linkURL *url.URL) (int, bool) {
+	if pageURL.Host != linkURL.Host {
+		return 0, false
+	}
+	if pageURL.Path == linkURL.Path {
+		return queryStep(pageURL.Query(), linkURL.Query())
+	}
+	from := strings.Split(strings.Trim(pageURL.Path, "/"), "/")
+	to := strings.Split(strings.Trim(linkURL.Path, "/"), "/")
+	if len(from) != len(to) {
+		return 0, false
+	}
+	step, found := 0, false
+	for i := range from {
+		if from[i] == to[i] {
+			continue
+		}
+		if found {
+			return 0, false
+		}
+		var ok bool
+		if step, ok = segmentStep(from[i], to[i]); !ok {
+			return 0, false
+		}
+		found = true
+	}
+	return step, found
+}
+
+// segmentStep returns the difference of the numbers where two path
+// segments start to differ, "chapter-12-a" and "chapter-13-b" differ by 1.
+func segmentStep(from, to string) (int, bool) {
+	at := 0
+	for at < len(from) && at < len(to) && from[at] == to[at] {
+		at++
+	}
+	// Back to the start of the number, 12 and 13 share the 1.
+	for at > 0 && isDigit(from[at-1]) {
+		at--
+	}
+	a, okA := leadingNumber(from[at:])
+	b, okB := leadingNumber(to[at:])
+	if !okA || !okB {
+		return 0, false
+	}
+	return b - a, true
+}
+
+func queryStep(from, to url.Values) (int, bool) {
+	if len(from) != len(to) {
+		return 0, false
+	}
+	step, found := 0, false
+	for key := range from {
+		a, b := from.Get(key), to.Get(key)
+		if _, ok := to[key]; !ok {
+			return 0, false
+		}
+		if a == b {
+			continue
+		}
+		x, errA := strconv.Atoi(a)
+		y, errB := strconv.Atoi(b)
+		if found || errA != nil || errB != nil {
+			return 0, false
+		}
+		step, found = y-x, true
+	}
+	return step, found
+}
+
+func leadingNumber(s string) (int, bool) {
+	end := 0
+	for end < len(s) && end < 9 && isDigit(s[end]) {
+		end++
+	}
+	if end == 0 {
+		return 0, false
+	}
+	n, err := strconv.Atoi(s[:end])
+	return n, err == nil
+}
+
+func isDigit(b byte) bool { return b >= '0' && b <= '9' }
+
+// heuristicNavStrategy scores the links by their words, in the languages
+// of the page, and where they are on it.
+type heuristicNavStrategy struct{}
+
+func (heuristicNavStrategy) Name() string { return "heuristic" }
+
+func (heuristicNavStrategy) Score(page *navPage, dir navDirection) []ScoredLink {
+	patterns := page.patterns[dir]
+	var scored []ScoredLink
+	for _, link := range page.Links {
+		if !matchesPatterns(link.Element, patterns) {
+			continue
+		}
+		score, reasons := scoreElement(link.Element, patterns)
+		scored = append(scored, ScoredLink{
+			URL:     link.URL,
+			Text:    link.Text,
+			Score:   score,
+			Reasons: reasons,
+			Element: link.Element,
+		})
+	}
+	return scored
+}
+
+// selectorNavStrategy takes the links matching the selectors of a site's
+// rule, or the first link inside the elements matching them.
+type selectorNavStrategy struct {
+	next string
+	prev string
+}
+
+func (selectorNavStrategy) Name() string { return "site-rule" }
+
+func (r selectorNavStrategy) Score(page *navPage, dir navDirection) []ScoredLink {
+	sel := r.next
+	if dir == navPrev {
+		sel = r.prev
+	}
+	if sel == "" {
+		return nil
+	}
+	var scored []ScoredLink
+	page.Doc.Find(sel).Each(func(_ int, s *goquery.Selection) {
+		elemURL := getURLfromElem(s)
+		if elemURL == "" {
+			s = s.Find("a[href]").First()
+			elemURL = s.AttrOr("href", "")
+		}
+		if elemURL == "" || !isURLsameSiteDiffPage(page.URL, elemURL) {
+			return
+		}
+		link := navLink{URL: resolveURL(elemURL, page.URL), Text: strings.Join(strings.Fields(s.Text()), " "), Element: s}
+		scored = append(scored, scoredLink(link, 2000, "matches %s", sel))
+	})
+	return scored
+}
@@ -0,0 +1,289 @@
+package core
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"net/textproto"
+	"net/url"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	db "github.com/egemengol/kindlepathy/internal/db/generated"
+)
+
+// Newsletters come by mail, they have no URL to add. Every user can get an
+// address at the instance's inbound domain, and the mail sent to it is
+// added to their library: the HTML of a message is read through the
+// extractors like a fetched page, a message with only text is kept as its
+// paragraphs. Their items have mail: URLs with the hash of the Message-ID
+// as the host, so a message delivered twice is added once.
+
+// mailScheme is the scheme of the URLs of mailed items.
+const mailScheme = "mail"
+
+// MaxNewsletterBytes is the largest message accepted, attachments included.
+const MaxNewsletterBytes = 25 << 20
+
+// maxMIMEDepth is how deep multipart messages are looked into.
+const maxMIMEDepth = 8
+
+// ErrUnknownRecipient is returned for addresses no user has.
+var ErrUnknownRecipient = fmt.Errorf("no user has this address")
+
+// ErrInvalidMessage is returned for messages that can't be read, which
+// sending them again won't help.
+var ErrInvalidMessage = fmt.Errorf("invalid message")
+
+// SetInboundDomain sets the domain of the users' addresses, mail can't be
+// received when it's empty.
+func (c *Core) SetInboundDomain(domain string) {
+	c.inboundDomain = strings.ToLower(strings.TrimSpace(domain))
+}
+
+// InboundDomain returns the domain of the users' addresses, empty when
+// mail can't be received.
+func (c *Core) InboundDomain() string {
+	return c.inboundDomain
+}
+
+// InboundAddress returns the user's address, empty when they have none.
+func (c *Core) InboundAddress(ctx context.Context, userID int64) (string, error) {
+	if c.inboundDomain == "" {
+		return "", nil
+	}
+	localPart, err := c.queries.InboundAddressesGetPerUser(ctx, userID)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to get address: %w", err)
+	}
+	return localPart + "@" + c.inboundDomain, nil
+}
+
+// NewInboundAddress gives the user a new random address, the one they had
+// stops receiving mail.
+func (c *Core) NewInboundAddress(ctx context.Context, userID int64, now time.Time) (string, error) {
+	if c.inboundDomain == "" {
+		return "", fmt.Errorf("receiving mail is not configured on this server")
+	}
+	buf := make([]byte, 8)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("failed to generate address: %w", err)
+	}
+	localPart := hex.EncodeToString(buf)
+	err := c.queries.InboundAddressesUpsert(ctx, db.InboundAddressesUpsertParams{
+		LocalPart: localPart,
+		UserID:    userID,
+		CreatedTs: now.Unix(),
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to store address: %w", err)
+	}
+	return localPart + "@" + c.inboundDomain, nil
+}
+
+// InboundRecipient returns the user an address belongs to, or
+// ErrUnknownRecipient. A +tag after the local part is ignored, for telling
+// the newsletters apart in the sender's settings.
+func (c *Core) InboundRecipient(ctx context.Context, address string) (int64, error) {
+	at := strings.LastIndexByte(address, '@')
+	if c.inboundDomain == "" || at < 0 || !strings.EqualFold(address[at+1:], c.inboundDomain) {
+		return 0, ErrUnknownRecipient
+	}
+	localPart, _, _ := strings.Cut(strings.ToLower(address[:at]), "+")
+	userID, err := c.queries.InboundAddressesGetUser(ctx, localPart)
+	if err == sql.ErrNoRows {
+		return 0, ErrUnknownRecipient
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to get address: %w", err)
+	}
+	return userID, nil
+}
+
+// AddNewsletter adds a mail message to the user's library.
+func (c *Core) AddNewsletter(ctx context.Context, userID int64, r io.Reader, now time.Time) (int64, error) {
+	msg, err := mail.ReadMessage(r)
+	if err != nil {
+		return 0, fmt.Errorf("%w: %v", ErrInvalidMessage, err)
+	}
+	// Headers should be ASCII, with encoded words for the rest, but 8-bit
+	// ones are taken as Windows-1252, like mail clients do.
+	for key, values := range msg.Header {
+		for i, v := range values {
+			if !utf8.ValidString(v) {
+				msg.Header[key][i] = decodeCharset("windows-1252", []byte(v))
+			}
+		}
+	}
+	decoder := &mime.WordDecoder{CharsetReader: charsetReader}
+	subject, err := decoder.DecodeHeader(msg.Header.Get("Subject"))
+	if err != nil {
+		subject = msg.Header.Get("Subject")
+	}
+	var sender string
+	if from, err := (&mail.AddressParser{WordDecoder: decoder}).Parse(msg.Header.Get("From")); err == nil {
+		sender = from.Name
+		if sender == "" {
+			sender = from.Address
+		}
+	}
+
+	var body mailBody
+	if err := body.read(textproto.MIMEHeader(msg.Header), msg.Body, 0); err != nil {
+		return 0, fmt.Errorf("%w: %v", ErrInvalidMessage, err)
+	}
+
+	id := msg.Header.Get("Message-Id")
+	if id == "" {
+		id = body.html + body.text
+	}
+	sum := sha256.Sum256([]byte(id))
+	rawurl := (&url.URL{Scheme: mailScheme, Host: hex.EncodeToString(sum[:])[:16]}).String()
+
+	clean := &Clean{}
+	switch {
+	case strings.TrimSpace(body.html) != "":
+		extracted, _, err := c.extract(ctx, body.html, rawurl)
+		if err != nil || strings.TrimSpace(extracted.ContentHTML) == "" {
+			c.Logger.Warn("failed to extract newsletter, keeping it whole", "error", err, "userID", userID)
+			extracted = &Clean{ContentHTML: body.html}
+		}
+		clean = extracted
+	case strings.TrimSpace(body.text) != "":
+		clean.ContentHTML = plainTextHTML(body.text)
+	default:
+		return 0, fmt.Errorf("%w: message has no text", ErrInvalidMessage)
+	}
+
+	title := strings.TrimSpace(subject)
+	if title == "" {
+		title = clean.Title
+	}
+	if title == "" && sender != "" {
+		title = "Mail from " + sender
+	}
+	if title == "" {
+		title = "Mail"
+	}
+	itemID, _, err := c.addItemWithUploadedContent(ctx, userID, title, rawurl, clean.ContentHTML, now)
+	if err != nil {
+		return 0, err
+	}
+
+	if clean.Byline == "" {
+		clean.Byline = sender
+	}
+	clean.SiteName = sender
+	if date, err := msg.Header.Date(); err == nil {
+		clean.PublishedTime = date.Format(time.RFC3339)
+	}
+	c.setItemMetadata(ctx, itemID, clean)
+	c.Logger.Info("added newsletter", "userID", userID, "itemID", itemID, "from", sender)
+	return itemID, nil
+}
+
+// mailBody is the text of a message: its first HTML part and its first
+// plain text part.
+type mailBody struct {
+	html string
+	text string
+}
+
+// read reads a part of a message, and the parts in it when it's a
+// multipart one. Attachments are skipped.
+func (b *mailBody) read(header textproto.MIMEHeader, r io.Reader, depth int) error {
+	mediaType, params, err := mime.ParseMediaType(header.Get("Content-Type"))
+	if err != nil {
+		mediaType, params = "text/plain", map[string]string{}
+	}
+	if disposition, _, _ := mime.ParseMediaType(header.Get("Content-Disposition")); disposition == "attachment" {
+		return nil
+	}
+
+	if strings.HasPrefix(mediaType, "multipart/") {
+		if depth >= maxMIMEDepth {
+			return nil
+		}
+		mr := multipart.NewReader(r, params["boundary"])
+		for {
+			part, err := mr.NextRawPart()
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				// What was read before a broken part is still the message.
+				if b.html != "" || b.text != "" {
+					return nil
+				}
+				return err
+			}
+			if err := b.read(part.Header, part, depth+1); err != nil {
+				return err
+			}
+		}
+	}
+
+	if mediaType != "text/html" && mediaType != "text/plain" {
+		return nil
+	}
+	if mediaType == "text/html" && b.html != "" || mediaType == "text/plain" && b.text != "" {
+		return nil
+	}
+	data, err := io.ReadAll(transferDecoder(header.Get("Content-Transfer-Encoding"), r))
+	if err != nil {
+		return fmt.Errorf("failed to decode %s part: %w", mediaType, err)
+	}
+	text := decodeCharset(params["charset"], data)
+	if mediaType == "text/html" {
+		b.html = text
+	} else {
+		b.text = text
+	}
+	return nil
+}
+
+// transferDecoder undoes the Content-Transfer-Encoding of a part.
+func transferDecoder(encoding string, r io.Reader) io.Reader {
+	switch strings.ToLower(strings.TrimSpace(encoding)) {
+	case "base64":
+		return base64.NewDecoder(base64.StdEncoding, &base64Cleaner{r: r})
+	case "quoted-printable":
+		return quotedprintable.NewReader(r)
+	}
+	return r
+}
+
+// base64Cleaner drops the line breaks and stray characters of base64 parts
+// that the decoder would stop at.
+type base64Cleaner struct {
+	r io.Reader
+}
+
+func (c *base64Cleaner) Read(p []byte) (int, error) {
+	for {
+		n, err := c.r.Read(p)
+		kept := 0
+		for _, ch := range p[:n] {
+			if ch >= 'A' && ch <= 'Z' || ch >= 'a' && ch <= 'z' || ch >= '0' && ch <= '9' || ch == '+' || ch == '/' || ch == '=' {
+				p[kept] = ch
+				kept++
+			}
+		}
+		if kept > 0 || err != nil {
+			return kept, err
+		}
+	}
+}
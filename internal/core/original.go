@@ -0,0 +1,114 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+	db "github.com/egemengol/kindlepathy/internal/db/generated"
+)
+
+// The extractors keep the article and drop what looks like the rest of the
+// page, and a table, a code listing or a figure the reader needs goes with
+// it now and then. The reader can switch to the page as the site serves it,
+// fetched again and cached like its clean, with its markup sanitized and
+// its links and images made absolute, nothing else taken out.
+
+// ErrNoOriginal is returned for items without a page on the web, uploaded
+// content and books.
+var ErrNoOriginal = errors.New("item has no original page")
+
+// hasOriginal tells whether the item's page can be fetched as it is.
+func hasOriginal(item db.Item) bool {
+	return !isBookURL(item.Url) && item.ContentHash == nil && item.UploadedHtmlBrotli == nil
+}
+
+// ItemHasOriginal tells whether the item can be read as the site serves
+// its page.
+func (c *Core) ItemHasOriginal(ctx context.Context, itemID int64) (bool, error) {
+	item, err := c.queries.ItemsGet(ctx, itemID)
+	if err != nil {
+		return false, fmt.Errorf("failed to get item: %w", err)
+	}
+	return hasOriginal(item), nil
+}
+
+// ReadItemOriginal marks the item read and returns its page as the site
+// serves it, sanitized. The nav links are the ones of its clean, when it
+// was read before.
+func (c *Core) ReadItemOriginal(ctx context.Context, itemID int64, now time.Time) (*Clean, error) {
+	item, err := c.queries.ItemsGet(ctx, itemID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get item: %w", err)
+	}
+	if !hasOriginal(item) {
+		return nil, ErrNoOriginal
+	}
+	if _, err := c.queries.ItemsGetUrlSetRead(ctx, db.ItemsGetUrlSetReadParams{ReadTs: now.Unix(), ID: itemID}); err != nil {
+		return nil, fmt.Errorf("failed to mark item as read: %w", err)
+	}
+
+	// Under the item's prefix, a page fetched with the user's cookies goes
+	// with their account.
+	prefix, err := c.itemCachePrefix(ctx, item)
+	if err != nil {
+		return nil, err
+	}
+	cacheKey := fmt.Sprintf("%s:original:%s", prefix, item.Url)
+	original, fresh := c.getCached(cacheKey)
+	if original == nil || !fresh {
+		body, err := c.fetch(withFetchUser(ctx, item.UserID), item.Url)
+		if err != nil {
+			return nil, err
+		}
+		original, err = originalClean(ctx, body, item.Url)
+		if err != nil {
+			return nil, err
+		}
+		c.storeCached(cacheKey, original, c.cacheTTL(item.Url, 10*time.Minute))
+	}
+
+	if original.Title == "" {
+		original.Title, _ = item.Title.(string)
+	}
+	if clean, _ := c.getCached(fmt.Sprintf("%s:%s", prefix, item.Url)); clean != nil {
+		original.NavNext, original.NavPrev = clean.NavNext, clean.NavPrev
+	}
+	return original, nil
+}
+
+// originalClean is the body of the page with only the allowed markup, its
+// lazy images loaded eagerly and its links and images absolute.
+func originalClean(ctx context.Context, rawHTML, pageURL string) (*Clean, error) {
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(rawHTML))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse page: %w", err)
+	}
+	body, err := doc.Find("body").Html()
+	if err != nil {
+		return nil, fmt.Errorf("failed to render page: %w", err)
+	}
+	original := &Clean{
+		Title:       strings.Join(strings.Fields(doc.Find("title").First().Text()), " "),
+		ContentHTML: body,
+	}
+	if err := (imagesProcessor{}).PostClean(ctx, &Document{URL: pageURL, Raw: rawHTML, Clean: original}); err != nil {
+		return nil, err
+	}
+	err = editContent(original, func(content *goquery.Selection) {
+		content.Find("a[href]").Each(func(_ int, a *goquery.Selection) {
+			// Links within the page stay on it.
+			if href := a.AttrOr("href", ""); !strings.HasPrefix(href, "#") {
+				a.SetAttr("href", resolveURL(href, pageURL))
+			}
+		})
+		sanitizeContent(content)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return original, nil
+}
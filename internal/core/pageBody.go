@@ -0,0 +1,93 @@
+package core
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"regexp"
+
+	"golang.org/x/net/html/charset"
+)
+
+// Page bodies are read up to a limit, a link to a video or a huge dump
+// would be read into memory whole otherwise, and only HTML is read: PDFs
+// and images have nothing for the extractors. The body is decoded to UTF-8
+// from the charset of its Content-Type, or of its <meta> tag when the
+// header has none, or the one guessed from its bytes when neither has.
+
+// DefaultFetchMaxBytes is the largest page fetched unless configured
+// otherwise.
+const DefaultFetchMaxBytes = 20 << 20
+
+// SetFetchMaxBytes sets the largest page fetched, 0 for no limit.
+func (c *Core) SetFetchMaxBytes(n int64) {
+	c.fetchMaxBytes = n
+}
+
+// charsetSniffLen is how far into the page its <meta> charset is looked
+// for, as browsers do.
+const charsetSniffLen = 1024
+
+var utf8BOM = []byte("\xEF\xBB\xBF")
+
+var metaCharset = regexp.MustCompile(`(?i)<meta[^>]+charset\s*=\s*["']?\s*([a-z0-9_:.\-]+)`)
+
+// readPageBody reads the HTML of a 200 response as UTF-8.
+func (c *Core) readPageBody(pageURL string, resp *http.Response) (string, error) {
+	limit := c.fetchMaxBytes
+	if limit > 0 && resp.ContentLength > limit {
+		return "", pageTooLarge(pageURL, limit)
+	}
+	var reader io.Reader = resp.Body
+	if limit > 0 {
+		reader = io.LimitReader(resp.Body, limit+1)
+	}
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		return "", classifyFetchError(pageURL, fmt.Errorf("failed to read response body: %w", err))
+	}
+	if limit > 0 && int64(len(data)) > limit {
+		return "", pageTooLarge(pageURL, limit)
+	}
+
+	contentType := resp.Header.Get("Content-Type")
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	if !isHTML(mediaType) {
+		// Servers label pages wrong, what the body looks like decides.
+		sniffed, _, _ := mime.ParseMediaType(http.DetectContentType(data))
+		if !isHTML(sniffed) {
+			if mediaType == "" {
+				mediaType = sniffed
+			}
+			return "", &FetchError{Kind: FetchErrorNotHTML, URL: pageURL, Err: fmt.Errorf("not a web page: %s", mediaType)}
+		}
+	}
+	label := pageCharset(contentType, data)
+	return decodeCharset(label, bytes.TrimPrefix(data, utf8BOM)), nil
+}
+
+func isHTML(mediaType string) bool {
+	return mediaType == "text/html" || mediaType == "application/xhtml+xml"
+}
+
+func pageTooLarge(pageURL string, limit int64) *FetchError {
+	return &FetchError{Kind: FetchErrorTooLarge, URL: pageURL, Err: fmt.Errorf("page is larger than %d MB", limit>>20)}
+}
+
+// pageCharset returns the charset of the page, the one of its byte order
+// mark, else of the header, else of its <meta> tag, else the one its bytes
+// look like.
+func pageCharset(contentType string, data []byte) string {
+	if _, name, certain := charset.DetermineEncoding(data, contentType); certain {
+		return name
+	}
+	head := data[:min(len(data), charsetSniffLen)]
+	if m := metaCharset.FindSubmatch(head); m != nil {
+		if enc, name := charset.Lookup(string(m[1])); enc != nil {
+			return name
+		}
+	}
+	return detectCharset(data)
+}
@@ -0,0 +1,51 @@
+package core
+
+import (
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// MaxPartBytes is the size of content the Kindle browser renders reliably,
+// longer chapters are read in parts.
+const MaxPartBytes = 200 << 10
+
+// SplitContent splits long content into parts of at most maxBytes between
+// its top level blocks. A block larger than maxBytes is a part of its own.
+// Content that fits is returned as the only part.
+func SplitContent(contentHTML string, maxBytes int) []string {
+	if len(contentHTML) <= maxBytes {
+		return []string{contentHTML}
+	}
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(contentHTML))
+	if err != nil {
+		return []string{contentHTML}
+	}
+
+	// The blocks are under the wrappers extractors put the content in.
+	root := doc.Find("body")
+	for root.Children().Length() == 1 && strings.TrimSpace(root.Text()) == strings.TrimSpace(root.Children().Text()) {
+		root = root.Children().First()
+	}
+
+	var parts []string
+	var part strings.Builder
+	root.Contents().Each(func(_ int, s *goquery.Selection) {
+		block, err := goquery.OuterHtml(s)
+		if err != nil {
+			return
+		}
+		if part.Len() > 0 && part.Len()+len(block) > maxBytes {
+			parts = append(parts, part.String())
+			part.Reset()
+		}
+		part.WriteString(block)
+	})
+	if part.Len() > 0 {
+		parts = append(parts, part.String())
+	}
+	if len(parts) == 0 {
+		return []string{contentHTML}
+	}
+	return parts
+}
@@ -0,0 +1,279 @@
+package core
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/andybalholm/cascadia"
+)
+
+// Operators can pick the processors of a domain, in order, in a JSON file:
+//
+//	{
+//	  "*": ["extract-nav", "absolute-images"],
+//	  "royalroad.com": ["selector-extract(.chapter-content)", "strip-author-notes", "extract-nav", "absolute-images"]
+//	}
+//
+// "*" replaces the default pipeline, extract-nav, absolute-images and
+// sanitize. A domain also matches its subdomains, the most specific domain
+// wins. Steps take an argument in parentheses if their processor is
+// Configurable. Sanitizing is not optional, pipelines without it get it at
+// the end.
+
+// Configurable processors take an argument in pipelines, like a selector.
+type Configurable interface {
+	Configure(arg string) (Processor, error)
+}
+
+type Pipelines struct {
+	Default []Processor
+	domains map[string][]Processor
+}
+
+var defaultPipeline = []string{"extract-nav", "absolute-images", "sanitize"}
+
+// DefaultPipelines runs the default pipeline on every domain.
+func DefaultPipelines() *Pipelines {
+	pipeline, err := parsePipeline(defaultPipeline)
+	if err != nil {
+		panic(err)
+	}
+	return &Pipelines{Default: pipeline}
+}
+
+// ParsePipelines validates a pipelines file, every step must name a
+// registered processor.
+func ParsePipelines(data []byte) (*Pipelines, error) {
+	var raw map[string][]string
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("invalid pipelines: %w", err)
+	}
+	pipelines := DefaultPipelines()
+	pipelines.domains = make(map[string][]Processor)
+	for domain, steps := range raw {
+		pipeline, err := parsePipeline(steps)
+		if err != nil {
+			return nil, fmt.Errorf("pipeline of %s: %w", domain, err)
+		}
+		if domain == "*" {
+			pipelines.Default = pipeline
+			continue
+		}
+		pipelines.domains[normalizeDomain(domain)] = pipeline
+	}
+	return pipelines, nil
+}
+
+func parsePipeline(steps []string) ([]Processor, error) {
+	pipeline := make([]Processor, 0, len(steps)+1)
+	sanitized := false
+	for _, step := range steps {
+		name, arg, hasArg := strings.Cut(strings.TrimSpace(step), "(")
+		p, ok := LookupProcessor(name)
+		if !ok {
+			return nil, fmt.Errorf("unknown processor: %q", name)
+		}
+		if hasArg {
+			if !strings.HasSuffix(arg, ")") {
+				return nil, fmt.Errorf("unclosed argument: %q", step)
+			}
+			configurable, ok := p.(Configurable)
+			if !ok {
+				return nil, fmt.Errorf("processor %s takes no argument", name)
+			}
+			var err error
+			p, err = configurable.Configure(strings.TrimSuffix(arg, ")"))
+			if err != nil {
+				return nil, fmt.Errorf("processor %s: %w", name, err)
+			}
+		} else if _, ok := p.(Configurable); ok {
+			return nil, fmt.Errorf("processor %s needs an argument", name)
+		}
+		sanitized = sanitized || name == "sanitize"
+		pipeline = append(pipeline, p)
+	}
+	if !sanitized {
+		pipeline = append(pipeline, sanitizeProcessor{})
+	}
+	return pipeline, nil
+}
+
+func LoadPipelines(path string) (*Pipelines, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read pipelines: %w", err)
+	}
+	return ParsePipelines(data)
+}
+
+func normalizeDomain(domain string) string {
+	return strings.TrimPrefix(strings.ToLower(strings.TrimSpace(domain)), "www.")
+}
+
+// For returns the pipeline of the page's domain.
+func (p *Pipelines) For(pageURL string) []Processor {
+	if pipeline, ok := lookupDomain(p.domains, pageURL); ok {
+		return pipeline
+	}
+	return p.Default
+}
+
+// lookupDomain finds the entry of the page's domain, or of the closest
+// parent domain that has one.
+func lookupDomain[T any](domains map[string]T, pageURL string) (T, bool) {
+	var zero T
+	u, err := url.Parse(pageURL)
+	if err != nil {
+		return zero, false
+	}
+	host := normalizeDomain(u.Hostname())
+	for host != "" {
+		if v, ok := domains[host]; ok {
+			return v, true
+		}
+		_, parent, ok := strings.Cut(host, ".")
+		if !ok {
+			break
+		}
+		host = parent
+	}
+	return zero, false
+}
+
+func (c *Core) pipeline(pageURL string) []Processor {
+	pipelines := c.pipelines.Load()
+	if pipelines == nil {
+		pipelines = DefaultPipelines()
+	}
+	return pipelines.For(pageURL)
+}
+
+// SetPipelines replaces the pipelines, cleans already cached keep their old
+// processing until they expire.
+func (c *Core) SetPipelines(pipelines *Pipelines) {
+	c.pipelines.Store(pipelines)
+}
+
+// WatchPipelines reloads the pipelines file whenever it changes. A broken
+// file is logged and the previous pipelines are kept.
+func (c *Core) WatchPipelines(ctx context.Context, path string, interval time.Duration) {
+	var lastMod time.Time
+	if info, err := os.Stat(path); err == nil {
+		lastMod = info.ModTime()
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		info, err := os.Stat(path)
+		if err != nil {
+			c.Logger.Warn("failed to stat pipelines", "error", err, "path", path)
+			continue
+		}
+		if info.ModTime().Equal(lastMod) {
+			continue
+		}
+		lastMod = info.ModTime()
+
+		pipelines, err := LoadPipelines(path)
+		if err != nil {
+			c.Logger.Error("failed to reload pipelines, keeping the previous ones", "error", err, "path", path)
+			continue
+		}
+		c.SetPipelines(pipelines)
+		c.Logger.Info("reloaded pipelines", "path", path)
+	}
+}
+
+// selectorProcessor takes the content straight from the page by selector,
+// for sites readability gets wrong.
+type selectorProcessor struct {
+	NopProcessor
+	selector string
+}
+
+func (selectorProcessor) Name() string { return "selector-extract" }
+
+func (selectorProcessor) Configure(arg string) (Processor, error) {
+	if err := validSelector(arg); err != nil {
+		return nil, err
+	}
+	return selectorProcessor{selector: arg}, nil
+}
+
+func (p selectorProcessor) PostClean(ctx context.Context, doc *Document) error {
+	if p.selector == "" || doc.Raw == "" {
+		return nil
+	}
+	page, err := goquery.NewDocumentFromReader(strings.NewReader(doc.Raw))
+	if err != nil {
+		return fmt.Errorf("failed to parse page: %w", err)
+	}
+	var content strings.Builder
+	page.Find(p.selector).Each(func(_ int, s *goquery.Selection) {
+		if html, err := goquery.OuterHtml(s); err == nil {
+			content.WriteString(html)
+		}
+	})
+	// Keep readability's content when the site changed its markup.
+	if content.Len() > 0 {
+		doc.Clean.ContentHTML = content.String()
+	}
+	return nil
+}
+
+// removeProcessor drops the elements matching its selector from the content.
+type removeProcessor struct {
+	NopProcessor
+	name     string
+	selector string
+}
+
+func (p removeProcessor) Name() string { return p.name }
+
+func (p removeProcessor) Configure(arg string) (Processor, error) {
+	if err := validSelector(arg); err != nil {
+		return nil, err
+	}
+	return removeProcessor{name: p.name, selector: arg}, nil
+}
+
+func (p removeProcessor) PostClean(ctx context.Context, doc *Document) error {
+	if p.selector == "" {
+		return nil
+	}
+	return editContent(doc.Clean, func(content *goquery.Selection) {
+		content.Find(p.selector).Remove()
+	})
+}
+
+// authorNotesProcessor drops the author's notes around web novel chapters.
+type authorNotesProcessor struct{ NopProcessor }
+
+func (authorNotesProcessor) Name() string { return "strip-author-notes" }
+
+const authorNotesSelector = ".author-note-portlet, .author-note, .authors-note, [class*=author-note], [id*=author-note]"
+
+func (authorNotesProcessor) PostClean(ctx context.Context, doc *Document) error {
+	return editContent(doc.Clean, func(content *goquery.Selection) {
+		content.Find(authorNotesSelector).Remove()
+	})
+}
+
+func validSelector(selector string) error {
+	if _, err := cascadia.Compile(selector); err != nil {
+		return fmt.Errorf("invalid selector %q: %w", selector, err)
+	}
+	return nil
+}
@@ -0,0 +1,278 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// Processors transform a document on its way from the site to the reader.
+// Each hook runs at a step of fetching and showing an item:
+//
+//   - PreFetch, on the request before the page is fetched
+//   - PostClean, after readability, with the raw page at hand; its result
+//     is cached
+//   - PreRender, every time the content is shown, uploaded content included
+//
+// Processors embed NopProcessor and implement the hooks they need.
+
+// Document is an item's content as it goes through the processors.
+type Document struct {
+	// URL is the page the content came from.
+	URL string
+	// Raw is the fetched page. It is empty in PreRender and for uploaded
+	// content.
+	Raw   string
+	Clean *Clean
+}
+
+type Processor interface {
+	Name() string
+	PreFetch(ctx context.Context, req *http.Request) error
+	PostClean(ctx context.Context, doc *Document) error
+	PreRender(ctx context.Context, doc *Document) error
+}
+
+// NopProcessor does nothing on every hook.
+type NopProcessor struct{}
+
+func (NopProcessor) PreFetch(ctx context.Context, req *http.Request) error { return nil }
+func (NopProcessor) PostClean(ctx context.Context, doc *Document) error    { return nil }
+func (NopProcessor) PreRender(ctx context.Context, doc *Document) error    { return nil }
+
+var processorRegistry = struct {
+	mu     sync.RWMutex
+	byName map[string]Processor
+	order  []string
+}{byName: make(map[string]Processor)}
+
+// RegisterProcessor makes a processor available by name to pipelines. It
+// panics if the name is taken, like registering a database driver.
+func RegisterProcessor(p Processor) {
+	processorRegistry.mu.Lock()
+	defer processorRegistry.mu.Unlock()
+	name := p.Name()
+	if _, ok := processorRegistry.byName[name]; ok {
+		panic(fmt.Sprintf("core: processor %q registered twice", name))
+	}
+	processorRegistry.byName[name] = p
+	processorRegistry.order = append(processorRegistry.order, name)
+}
+
+// LookupProcessor returns the registered processor with the name.
+func LookupProcessor(name string) (Processor, bool) {
+	processorRegistry.mu.RLock()
+	defer processorRegistry.mu.RUnlock()
+	p, ok := processorRegistry.byName[name]
+	return p, ok
+}
+
+// Processors returns every registered processor, in registration order.
+func Processors() []Processor {
+	processorRegistry.mu.RLock()
+	defer processorRegistry.mu.RUnlock()
+	ps := make([]Processor, len(processorRegistry.order))
+	for i, name := range processorRegistry.order {
+		ps[i] = processorRegistry.byName[name]
+	}
+	return ps
+}
+
+func init() {
+	RegisterProcessor(navProcessor{})
+	RegisterProcessor(navSelectorProcessor{})
+	RegisterProcessor(imagesProcessor{})
+	RegisterProcessor(sanitizeProcessor{})
+	RegisterProcessor(selectorProcessor{})
+	RegisterProcessor(removeProcessor{name: "remove"})
+	RegisterProcessor(authorNotesProcessor{})
+	RegisterProcessor(headerProcessor{})
+}
+
+func (c *Core) preFetch(ctx context.Context, req *http.Request) error {
+	for _, p := range c.pipeline(req.URL.String()) {
+		if err := p.PreFetch(ctx, req); err != nil {
+			return fmt.Errorf("processor %s: %w", p.Name(), err)
+		}
+	}
+	return nil
+}
+
+func (c *Core) postClean(ctx context.Context, doc *Document) error {
+	for _, p := range c.pipeline(doc.URL) {
+		if err := p.PostClean(ctx, doc); err != nil {
+			return fmt.Errorf("processor %s: %w", p.Name(), err)
+		}
+	}
+	// A broken site script shouldn't keep the item from being read.
+	if err := c.runSiteScript(ctx, doc); err != nil {
+		c.Logger.Warn("site script failed", "error", err, "url", doc.URL)
+	}
+	return nil
+}
+
+// preRender returns a copy of the clean prepared for the reader, the cached
+// clean is left as is.
+func (c *Core) preRender(ctx context.Context, pageURL string, clean *Clean) (*Clean, error) {
+	rendered := *clean
+	doc := &Document{URL: pageURL, Clean: &rendered}
+	for _, p := range c.pipeline(pageURL) {
+		if err := p.PreRender(ctx, doc); err != nil {
+			return nil, fmt.Errorf("processor %s: %w", p.Name(), err)
+		}
+	}
+	return &rendered, nil
+}
+
+// navProcessor finds the links to the next and previous pages, see
+// navStrategies.go.
+type navProcessor struct {
+	NopProcessor
+	// site are the selectors of the site's rule, if any.
+	site *selectorNavStrategy
+}
+
+func (navProcessor) Name() string { return "extract-nav" }
+
+// navStrategies are the strategies of the processor, the site's rule
+// first.
+func (p navProcessor) navStrategies() []navStrategy {
+	if p.site == nil {
+		return defaultNavStrategies
+	}
+	return append([]navStrategy{*p.site}, defaultNavStrategies...)
+}
+
+func (p navProcessor) PostClean(ctx context.Context, doc *Document) error {
+	report := extractNavReport(doc.Raw, doc.URL, p.navStrategies())
+	doc.Clean.NavNext = report.Next
+	doc.Clean.NavPrev = report.Prev
+	doc.Clean.NavNextConfidence = report.NextConfidence
+	doc.Clean.NavPrevConfidence = report.PrevConfidence
+	doc.Clean.NavNextAlternatives = navAlternatives(report.NextCandidates, report.NextConfidence)
+	doc.Clean.NavPrevAlternatives = navAlternatives(report.PrevCandidates, report.PrevConfidence)
+	return nil
+}
+
+// navStrategies are the strategies of the nav processor in the pipeline of
+// the page, the default ones and false when it has none.
+func (c *Core) navStrategies(pageURL string) ([]navStrategy, bool) {
+	for _, p := range c.pipeline(pageURL) {
+		if nav, ok := p.(interface{ navStrategies() []navStrategy }); ok {
+			return nav.navStrategies(), true
+		}
+	}
+	return defaultNavStrategies, false
+}
+
+// navSelectorProcessor is extract-nav with the selectors of the site's
+// nav links, like "next: a.next-chapter; prev: a.prev-chapter", their
+// links winning over the ones the other strategies find.
+type navSelectorProcessor struct{ navProcessor }
+
+func (navSelectorProcessor) Name() string { return "nav-selector" }
+
+func (navSelectorProcessor) Configure(arg string) (Processor, error) {
+	var site selectorNavStrategy
+	for _, part := range strings.Split(arg, ";") {
+		dir, sel, ok := strings.Cut(part, ":")
+		sel = strings.TrimSpace(sel)
+		if !ok || sel == "" {
+			return nil, fmt.Errorf("%q is not next: selector or prev: selector", strings.TrimSpace(part))
+		}
+		if err := validSelector(sel); err != nil {
+			return nil, err
+		}
+		switch strings.TrimSpace(dir) {
+		case "next":
+			site.next = sel
+		case "prev":
+			site.prev = sel
+		default:
+			return nil, fmt.Errorf("unknown nav link %q, want next or prev", strings.TrimSpace(dir))
+		}
+	}
+	return navSelectorProcessor{navProcessor{site: &site}}, nil
+}
+
+// imagesProcessor makes image sources absolute, readability keeps them
+// relative to the page, and loads lazy images eagerly.
+type imagesProcessor struct{ NopProcessor }
+
+func (imagesProcessor) Name() string { return "absolute-images" }
+
+var lazySrcAttrs = []string{"data-src", "data-lazy-src", "data-original"}
+
+func (imagesProcessor) PostClean(ctx context.Context, doc *Document) error {
+	return editContent(doc.Clean, func(content *goquery.Selection) {
+		content.Find("img").Each(func(_ int, img *goquery.Selection) {
+			for _, attr := range lazySrcAttrs {
+				if lazy, ok := img.Attr(attr); ok && lazy != "" {
+					img.SetAttr("src", lazy)
+					img.RemoveAttr(attr)
+					break
+				}
+			}
+			if src, ok := img.Attr("src"); ok {
+				img.SetAttr("src", resolveURL(src, doc.URL))
+			}
+			img.RemoveAttr("loading")
+		})
+		content.Find("img[srcset], source[srcset]").Each(func(_ int, s *goquery.Selection) {
+			srcset, _ := s.Attr("srcset")
+			s.SetAttr("srcset", resolveSrcset(srcset, doc.URL))
+		})
+	})
+}
+
+func resolveSrcset(srcset, baseURL string) string {
+	candidates := strings.Split(srcset, ",")
+	for i, candidate := range candidates {
+		fields := strings.Fields(candidate)
+		if len(fields) == 0 {
+			continue
+		}
+		fields[0] = resolveURL(fields[0], baseURL)
+		candidates[i] = strings.Join(fields, " ")
+	}
+	return strings.Join(candidates, ", ")
+}
+
+// sanitizeProcessor keeps only the allowed markup, see sanitize.go. It runs
+// on the fetched content before it's cached, and before every render for
+// the content cached before and the uploaded content.
+type sanitizeProcessor struct{ NopProcessor }
+
+func (sanitizeProcessor) Name() string { return "sanitize" }
+
+func (sanitizeProcessor) PostClean(ctx context.Context, doc *Document) error {
+	return editContent(doc.Clean, sanitizeContent)
+}
+
+func (sanitizeProcessor) PreRender(ctx context.Context, doc *Document) error {
+	return editContent(doc.Clean, sanitizeContent)
+}
+
+// editContent parses the content of the clean, lets edit change it and
+// writes it back.
+func editContent(clean *Clean, edit func(content *goquery.Selection)) error {
+	if clean.ContentHTML == "" {
+		return nil
+	}
+	d, err := goquery.NewDocumentFromReader(strings.NewReader(clean.ContentHTML))
+	if err != nil {
+		return fmt.Errorf("failed to parse content: %w", err)
+	}
+	body := d.Find("body")
+	edit(body)
+	content, err := body.Html()
+	if err != nil {
+		return fmt.Errorf("failed to render content: %w", err)
+	}
+	clean.ContentHTML = content
+	return nil
+}
@@ -0,0 +1,117 @@
+package core
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+	db "github.com/egemengol/kindlepathy/internal/db/generated"
+)
+
+// The Kindle browser forgets where it was on every reload, so the reader
+// saves the paragraph being read and the page opens there again. Paragraphs
+// are counted rather than the scroll offset, which changes with the font
+// size.
+
+// ReadingProgress is where the reader was in an item.
+type ReadingProgress struct {
+	// Part is the part of a long page, from 1.
+	Part int `json:"part"`
+	// Paragraph is the index of the first block on screen in the part.
+	Paragraph int `json:"paragraph"`
+}
+
+// SaveProgress remembers the position in the page the item is on.
+func (c *Core) SaveProgress(ctx context.Context, itemID int64, progress ReadingProgress, now time.Time) error {
+	if progress.Part < 1 || progress.Paragraph < 0 {
+		return fmt.Errorf("invalid position: part %d, paragraph %d", progress.Part, progress.Paragraph)
+	}
+	item, err := c.queries.ItemsGet(ctx, itemID)
+	if err != nil {
+		return fmt.Errorf("failed to get item: %w", err)
+	}
+	err = c.queries.ItemProgressSet(ctx, db.ItemProgressSetParams{
+		ItemID:    itemID,
+		Url:       item.Url,
+		Part:      int64(progress.Part),
+		Paragraph: int64(progress.Paragraph),
+		UpdatedTs: now.Unix(),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to save progress: %w", err)
+	}
+	return nil
+}
+
+// GetProgress returns where the reader was in the page the item is on, nil
+// when it wasn't saved or was saved on another page.
+func (c *Core) GetProgress(ctx context.Context, itemID int64) (*ReadingProgress, error) {
+	progress, err := c.queries.ItemProgressGet(ctx, itemID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get progress: %w", err)
+	}
+	item, err := c.queries.ItemsGet(ctx, itemID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get item: %w", err)
+	}
+	if item.Url != progress.Url {
+		return nil, nil
+	}
+	return &ReadingProgress{Part: int(progress.Part), Paragraph: int(progress.Paragraph)}, nil
+}
+
+// progressBlocks are the blocks the reader counts paragraphs of, the ones
+// read.html saves the position in.
+const progressBlocks = "p, h2, h3, h4, li, pre, blockquote, img"
+
+// readFraction is how far into the content the position is, from 0 to 1.
+func readFraction(contentHTML string, progress ReadingProgress) float64 {
+	parts := SplitContent(contentHTML, MaxPartBytes)
+	read, total := 0, 0
+	for i, part := range parts {
+		doc, err := goquery.NewDocumentFromReader(strings.NewReader(part))
+		if err != nil {
+			return 0
+		}
+		blocks := doc.Find(progressBlocks).Length()
+		switch {
+		case i+1 < progress.Part:
+			read += blocks
+		case i+1 == progress.Part:
+			read += min(progress.Paragraph, blocks)
+		}
+		total += blocks
+	}
+	if total == 0 {
+		return 0
+	}
+	return float64(read) / float64(total)
+}
+
+// setProgress fills in how far the user got in the item and the time left,
+// when its content is at hand.
+func (c *Core) setProgress(ctx context.Context, summary *ItemSummary, item db.Item) {
+	progress, err := c.GetProgress(ctx, item.ID)
+	if err != nil {
+		c.Logger.Warn("failed to get reading progress", "error", err, "itemID", item.ID)
+		return
+	}
+	if progress == nil {
+		return
+	}
+	contentHTML := c.localContent(ctx, item)
+	if contentHTML == "" {
+		return
+	}
+	fraction := readFraction(contentHTML, *progress)
+	summary.ProgressPercent = int(fraction * 100)
+	summary.MinutesLeft = int(math.Ceil((1 - fraction) * float64(summary.ReadingMinutes)))
+}
@@ -25,9 +25,28 @@ const TIMEOUT_REQUEST = 2 * time.Second
 const TIMEOUT_SIGTERM_SIGKILL = 1 * time.Second        // Maybe slightly longer?
 const TIMEOUT_WAIT_AFTER_KILL = 500 * time.Millisecond // Shorter wait after kill
 
+// Readability extracts the article of a page the way Mozilla's Readability
+// does, in the sidecar, a Bun process spoken to over a unix socket or stdio,
+// or in-process with ReadabilityEmbedded.
+type Readability interface {
+	Parse(ctx context.Context, htmlBody string, url string) (*ReadabilityResponseSuccess, error)
+}
+
+// ErrReadabilityUnavailable is returned when there's no readability sidecar
+// running, extraction falls back to the density extractor.
+var ErrReadabilityUnavailable = errors.New("readability is unavailable")
+
+// ReadabilitySidecar is a Readability run as a child process, the one over a
+// unix socket or the one over stdio.
+type ReadabilitySidecar interface {
+	Readability
+	Close(ctx context.Context) error
+}
+
 type ReadabilityClient struct {
 	cmd        *exec.Cmd
 	httpClient *http.Client
+	breaker    *readabilityBreaker
 	mu         sync.Mutex
 
 	udsPath string
@@ -41,6 +60,7 @@ func NewReadabilityClient(
 	tempDir string,
 	serverBinaryPath string,
 	uid string,
+	limits ReadabilityLimits,
 ) (*ReadabilityClient, error) {
 	if uid == "" {
 		uid = uuid.New().String()
@@ -80,14 +100,17 @@ func NewReadabilityClient(
 		MaxConnsPerHost: 1,
 	}
 
+	// Requests time out with their context. The sidecar parses a page at a
+	// time, a connection is enough; ReadabilityPool runs several sidecars
+	// to parse pages at once.
 	httpClient := &http.Client{
 		Transport: transport,
-		Timeout:   TIMEOUT_REQUEST,
 	}
 
 	client := &ReadabilityClient{
 		cmd:        cmd,
 		httpClient: httpClient,
+		breaker:    newReadabilityBreaker(limits, logger),
 		mu:         sync.Mutex{},
 		udsPath:    udsPath,
 		logger:     logger,
@@ -198,8 +221,8 @@ func (rc *ReadabilityClient) Close(ctx context.Context) error {
 }
 
 type ReadabilityResponseSuccess struct {
-	Title string `json:"title"`
-	// Byline        string    `json:"byline"`
+	Title  string `json:"title"`
+	Byline string `json:"byline"`
 	// Dir           *string   `json:"dir"`
 	// Lang          string    `json:"lang"`
 	TextContent string `json:"textContent"`
@@ -216,12 +239,27 @@ type ReadabilityResponseError struct {
 }
 
 func (rc *ReadabilityClient) Parse(ctx context.Context, htmlBody string, url string) (*ReadabilityResponseSuccess, error) {
+	if err := rc.breaker.allow(htmlBody, time.Now()); err != nil {
+		return nil, err
+	}
+	// The lock only guards cmd: the requests wait for the connection with
+	// their context instead, and Close needn't wait for them.
 	rc.mu.Lock()
-	defer rc.mu.Unlock()
-	if rc.cmd == nil {
+	closed := rc.cmd == nil
+	rc.mu.Unlock()
+	if closed {
 		return nil, fmt.Errorf("readability client is closed or server process exited")
 	}
 
+	parent := ctx
+	ctx, cancel := rc.breaker.timeout(parent)
+	defer cancel()
+	resp, err := rc.parse(ctx, htmlBody, url)
+	rc.breaker.record(parent, ctx, err, time.Now())
+	return resp, err
+}
+
+func (rc *ReadabilityClient) parse(ctx context.Context, htmlBody string, url string) (*ReadabilityResponseSuccess, error) {
 	reqURL := "http://localhost/" // Dummy URL for UDS
 	req, err := http.NewRequestWithContext(ctx, "POST", reqURL, strings.NewReader(htmlBody))
 	if err != nil {
@@ -279,6 +317,12 @@ func (rc *ReadabilityClient) Parse(ctx context.Context, htmlBody string, url str
 }
 
 func (rc *ReadabilityClient) healthcheck(ctx context.Context) error {
+	return readabilityHealthcheck(ctx, rc, rc.logger)
+}
+
+// readabilityHealthcheck retries parsing a small document until it succeeds
+// or ctx ends, for a sidecar that is still starting.
+func readabilityHealthcheck(ctx context.Context, r Readability, logger *slog.Logger) error {
 	const retryDelay = 200 * time.Millisecond
 	const attemptTimeout = 100 * time.Millisecond
 	const dummyHTML = "<html><body>health check</body></html>"
@@ -292,12 +336,12 @@ func (rc *ReadabilityClient) healthcheck(ctx context.Context) error {
 
 	for {
 		attemptCtx, attemptCancel := context.WithTimeout(ctx, attemptTimeout)
-		_, parseErr := rc.Parse(attemptCtx, dummyHTML, dummyURL)
+		_, parseErr := r.Parse(attemptCtx, dummyHTML, dummyURL)
 		attemptCancel()
 
 		if parseErr == nil {
 			duration := time.Since(startTime)
-			rc.logger.Info("Healthcheck passed", "duration", duration)
+			logger.Info("Healthcheck passed", "duration", duration)
 			return nil
 		}
 
@@ -307,7 +351,7 @@ func (rc *ReadabilityClient) healthcheck(ctx context.Context) error {
 		case <-ctx.Done():
 			contextErr := ctx.Err()
 			totalDuration := time.Since(startTime)
-			rc.logger.Error("Healthcheck failed: context ended", "duration", totalDuration, "lastError", lastErr, "contextError", contextErr)
+			logger.Error("Healthcheck failed: context ended", "duration", totalDuration, "lastError", lastErr, "contextError", contextErr)
 			return fmt.Errorf("healthcheck failed after %v: context %v (last error: %w)", totalDuration, contextErr, lastErr)
 		case <-ticker.C:
 			continue
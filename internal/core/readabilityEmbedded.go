@@ -0,0 +1,216 @@
+package core
+
+import (
+	"context"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dop251/goja"
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// The sidecar is a Bun binary downloaded at startup, or built along with
+// the server, and a process to keep running. ReadabilityEmbedded runs the
+// same Readability.js in the server instead, on goja, a JavaScript runtime
+// in Go, against the little of the DOM it uses. It's slower than the
+// sidecar on Bun and needs nothing but the server binary.
+
+//go:embed readabilityjs/Readability.js
+var readabilityJS string
+
+//go:embed readabilityjs/dom.js
+var readabilityDOMJS string
+
+// readabilityPrograms are Readability.js and the DOM it runs against,
+// compiled once for every runtime.
+var readabilityPrograms = sync.OnceValues(func() ([]*goja.Program, error) {
+	var programs []*goja.Program
+	for _, src := range []struct{ name, code string }{
+		{"dom.js", readabilityDOMJS},
+		{"Readability.js", readabilityJS},
+	} {
+		program, err := goja.Compile(src.name, src.code, false)
+		if err != nil {
+			return nil, fmt.Errorf("failed to compile %s: %w", src.name, err)
+		}
+		programs = append(programs, program)
+	}
+	return programs, nil
+})
+
+// ReadabilityEmbedded is a Readability run in-process. A page is parsed in
+// a runtime of its own, pages parse at once on as many goroutines.
+type ReadabilityEmbedded struct {
+	breaker *readabilityBreaker
+	logger  *slog.Logger
+}
+
+// NewReadabilityEmbedded compiles Readability.js, failing when it doesn't.
+func NewReadabilityEmbedded(logger *slog.Logger, limits ReadabilityLimits) (*ReadabilityEmbedded, error) {
+	if _, err := readabilityPrograms(); err != nil {
+		return nil, err
+	}
+	return &ReadabilityEmbedded{
+		breaker: newReadabilityBreaker(limits, logger),
+		logger:  logger,
+	}, nil
+}
+
+func (re *ReadabilityEmbedded) Parse(ctx context.Context, htmlBody string, url string) (*ReadabilityResponseSuccess, error) {
+	if err := re.breaker.allow(htmlBody, time.Now()); err != nil {
+		return nil, err
+	}
+	parent := ctx
+	ctx, cancel := re.breaker.timeout(parent)
+	defer cancel()
+	resp, err := re.parse(ctx, htmlBody, url)
+	re.breaker.record(parent, ctx, err, time.Now())
+	return resp, err
+}
+
+func (re *ReadabilityEmbedded) parse(ctx context.Context, htmlBody string, pageURL string) (*ReadabilityResponseSuccess, error) {
+	programs, err := readabilityPrograms()
+	if err != nil {
+		return nil, err
+	}
+	doc, err := html.Parse(strings.NewReader(htmlBody))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse page: %w", err)
+	}
+	root := doc.FirstChild
+	for root != nil && root.Type != html.ElementNode {
+		root = root.NextSibling
+	}
+	if root == nil {
+		return nil, fmt.Errorf("page has no root element")
+	}
+	tree, err := json.Marshal(domTree(root))
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode page: %w", err)
+	}
+
+	vm := goja.New()
+	// The runtime stops where it is when the parse times out.
+	stop := context.AfterFunc(ctx, func() { vm.Interrupt(ctx.Err()) })
+	defer stop()
+	if err := vm.Set("__parseFragment", parseFragment); err != nil {
+		return nil, err
+	}
+	if err := vm.Set("__resolveURL", func(ref, base string) string {
+		resolved, err := resolveAbsoluteURL(ref, base)
+		if err != nil {
+			panic(vm.NewTypeError("Invalid URL: %s", ref))
+		}
+		return resolved
+	}); err != nil {
+		return nil, err
+	}
+	for _, program := range programs {
+		if _, err := vm.RunProgram(program); err != nil {
+			return nil, readabilityScriptError(ctx, err)
+		}
+	}
+	parseArticle, ok := goja.AssertFunction(vm.Get("parseArticle"))
+	if !ok {
+		return nil, fmt.Errorf("parseArticle is not a function")
+	}
+	result, err := parseArticle(goja.Undefined(), vm.ToValue(string(tree)), vm.ToValue(pageURL))
+	if err != nil {
+		return nil, readabilityScriptError(ctx, err)
+	}
+
+	// Like the sidecar's null, a page without an article is an empty one.
+	var successResp ReadabilityResponseSuccess
+	if err := json.Unmarshal([]byte(result.String()), &successResp); err != nil {
+		return nil, fmt.Errorf("failed to parse article JSON: %w", err)
+	}
+	re.logger.Info("successfully parsed document",
+		"title", successResp.Title,
+		"siteName", successResp.SiteName)
+	return &successResp, nil
+}
+
+// Close has nothing to stop, the runtimes go with their parses.
+func (re *ReadabilityEmbedded) Close(ctx context.Context) error {
+	return nil
+}
+
+// readabilityScriptError is the error of a script, the context's when it
+// was interrupted.
+func readabilityScriptError(ctx context.Context, err error) error {
+	var interrupted *goja.InterruptedError
+	if errors.As(err, &interrupted) {
+		return fmt.Errorf("request cancelled or timed out: %w", ctx.Err())
+	}
+	return fmt.Errorf("readability failed: %w", err)
+}
+
+// domTree is the node as dom.js builds it, comments and doctypes left out.
+func domTree(n *html.Node) any {
+	if n.Type == html.TextNode {
+		return []any{3, n.Data}
+	}
+	attrs := make([]string, 0, 2*len(n.Attr))
+	for _, attr := range n.Attr {
+		name := attr.Key
+		if attr.Namespace != "" {
+			name = attr.Namespace + ":" + attr.Key
+		}
+		attrs = append(attrs, name, attr.Val)
+	}
+	children := []any{}
+	for child := n.FirstChild; child != nil; child = child.NextSibling {
+		if child.Type == html.ElementNode || child.Type == html.TextNode {
+			children = append(children, domTree(child))
+		}
+	}
+	return []any{1, n.Data, attrs, children}
+}
+
+// parseFragment is the markup set through innerHTML as nodes of dom.js,
+// in JSON.
+func parseFragment(markup string) (string, error) {
+	nodes, err := html.ParseFragment(strings.NewReader(markup), &html.Node{Type: html.ElementNode, Data: "body", DataAtom: atom.Body})
+	if err != nil {
+		return "", err
+	}
+	trees := []any{}
+	for _, n := range nodes {
+		if n.Type == html.ElementNode || n.Type == html.TextNode {
+			trees = append(trees, domTree(n))
+		}
+	}
+	tree, err := json.Marshal(trees)
+	return string(tree), err
+}
+
+// resolveAbsoluteURL resolves ref against base, like the URL constructor,
+// failing when the result isn't absolute.
+func resolveAbsoluteURL(ref, base string) (string, error) {
+	u, err := url.Parse(strings.TrimSpace(ref))
+	if err != nil {
+		return "", err
+	}
+	if base != "" {
+		b, err := url.Parse(strings.TrimSpace(base))
+		if err != nil {
+			return "", err
+		}
+		if !b.IsAbs() {
+			return "", fmt.Errorf("base %q is not absolute", base)
+		}
+		u = b.ResolveReference(u)
+	}
+	if !u.IsAbs() {
+		return "", fmt.Errorf("%q is not absolute", ref)
+	}
+	return u.String(), nil
+}
@@ -0,0 +1,186 @@
+package core
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// The readability sidecar is a compiled Bun program, the hardest part of
+// running kindlepathy outside Docker. InstallReadability downloads the
+// prebuilt one for the platform from a pinned release instead, checking it
+// against the release's checksums.
+
+// ReadabilityRelease is the release the sidecar is downloaded from, bumped
+// along with readability/server.ts.
+const ReadabilityRelease = "readability-v1"
+
+const readabilityReleaseURL = "https://github.com/egemengol/kindlepathy/releases/download/" + ReadabilityRelease + "/"
+
+// readabilityPlatforms are the platforms Bun compiles the sidecar for.
+var readabilityPlatforms = []string{"linux/amd64", "linux/arm64", "darwin/amd64", "darwin/arm64"}
+
+// maxReadabilityArchive caps the download, the sidecar embeds a runtime.
+const maxReadabilityArchive = 200 << 20
+
+// ErrReadabilityPlatform is returned when there's no prebuilt sidecar for the
+// platform.
+var ErrReadabilityPlatform = fmt.Errorf("no prebuilt readability sidecar for %s/%s", runtime.GOOS, runtime.GOARCH)
+
+// ReadabilityGuidance tells how to get the sidecar when it's missing.
+func ReadabilityGuidance(path string) string {
+	where := "READABILITY_PATH is not set"
+	if path != "" {
+		where = fmt.Sprintf("the readability sidecar was not found at %s", path)
+	}
+	return where + `. Either:
+  - build it with Bun: cd readability && bun install && make ./readability, then set READABILITY_PATH=readability/readability
+  - set READABILITY_DOWNLOAD=true to download the prebuilt sidecar (` + strings.Join(readabilityPlatforms, ", ") + `) into the data directory
+  - run the Docker image, which includes it`
+}
+
+// InstallReadability makes sure the sidecar of the pinned release is in dir,
+// downloading it when it isn't, and returns the path of the binary.
+func InstallReadability(ctx context.Context, client *http.Client, dir string) (string, error) {
+	platform := runtime.GOOS + "/" + runtime.GOARCH
+	supported := false
+	for _, p := range readabilityPlatforms {
+		supported = supported || p == platform
+	}
+	if !supported {
+		return "", ErrReadabilityPlatform
+	}
+
+	installDir := filepath.Join(dir, ReadabilityRelease)
+	binary := filepath.Join(installDir, "readability")
+	if _, err := os.Stat(binary); err == nil {
+		return binary, nil
+	}
+
+	asset := fmt.Sprintf("readability-%s-%s.tar.gz", runtime.GOOS, runtime.GOARCH)
+	sums, err := download(ctx, client, readabilityReleaseURL+"SHA256SUMS", 1<<20)
+	if err != nil {
+		return "", fmt.Errorf("failed to download checksums: %w", err)
+	}
+	want, err := checksumOf(sums, asset)
+	if err != nil {
+		return "", err
+	}
+	archive, err := download(ctx, client, readabilityReleaseURL+asset, maxReadabilityArchive)
+	if err != nil {
+		return "", fmt.Errorf("failed to download %s: %w", asset, err)
+	}
+	sum := sha256.Sum256(archive)
+	if got := hex.EncodeToString(sum[:]); got != want {
+		return "", fmt.Errorf("checksum mismatch for %s: expected %s, got %s", asset, want, got)
+	}
+
+	// Extracted next to the final directory and renamed, an interrupted
+	// install doesn't leave a half written binary behind.
+	tmpDir, err := os.MkdirTemp(dir, ".readability-")
+	if err != nil {
+		return "", fmt.Errorf("failed to create install directory: %w", err)
+	}
+	defer os.RemoveAll(tmpDir)
+	if err := extractTarGz(archive, tmpDir); err != nil {
+		return "", fmt.Errorf("failed to extract %s: %w", asset, err)
+	}
+	if _, err := os.Stat(filepath.Join(tmpDir, "readability")); err != nil {
+		return "", fmt.Errorf("%s has no readability binary", asset)
+	}
+	if err := os.Rename(tmpDir, installDir); err != nil {
+		return "", fmt.Errorf("failed to install readability: %w", err)
+	}
+	return binary, nil
+}
+
+func download(ctx context.Context, client *http.Client, url string, limit int64) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create GET request: %w", err)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("non-200 response: %d", resp.StatusCode)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, limit+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(body)) > limit {
+		return nil, fmt.Errorf("larger than %d bytes", limit)
+	}
+	return body, nil
+}
+
+// checksumOf finds the file's checksum in sha256sum output.
+func checksumOf(sums []byte, name string) (string, error) {
+	scanner := bufio.NewScanner(bytes.NewReader(sums))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 2 && strings.TrimPrefix(fields[1], "*") == name {
+			return strings.ToLower(fields[0]), nil
+		}
+	}
+	return "", fmt.Errorf("no checksum for %s in the release", name)
+}
+
+// extractTarGz extracts the regular files and directories of the archive
+// into dir, refusing paths that would land outside it.
+func extractTarGz(archive []byte, dir string) error {
+	gz, err := gzip.NewReader(bytes.NewReader(archive))
+	if err != nil {
+		return err
+	}
+	tr := tar.NewReader(gz)
+	for {
+		header, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dir, filepath.FromSlash(header.Name))
+		if !strings.HasPrefix(target, filepath.Clean(dir)+string(os.PathSeparator)) {
+			return fmt.Errorf("%s is outside the archive", header.Name)
+		}
+		switch header.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(target, 0o755); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+				return err
+			}
+			f, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, os.FileMode(header.Mode)&0o755)
+			if err != nil {
+				return err
+			}
+			_, err = io.Copy(f, tr)
+			if closeErr := f.Close(); err == nil {
+				err = closeErr
+			}
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
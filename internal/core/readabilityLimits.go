@@ -0,0 +1,100 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// The sidecars parse a page at a time over the socket, one page that takes
+// long holds up the others. Pages too large for it go to the density
+// extractor right away, and after timeouts in a row every page does for a
+// while, the breaker being open, instead of queueing behind a sidecar that
+// can't keep up.
+
+// ReadabilityLimits bound the work given to a readability sidecar.
+type ReadabilityLimits struct {
+	// Timeout is how long parsing a page may take.
+	Timeout time.Duration
+	// MaxBytes is the largest page parsed, 0 for no limit.
+	MaxBytes int
+	// After BreakerFailures timeouts in a row pages aren't parsed for
+	// BreakerCooldown. 0 disables the breaker.
+	BreakerFailures int
+	BreakerCooldown time.Duration
+}
+
+// DefaultReadabilityLimits are the limits unless configured otherwise.
+var DefaultReadabilityLimits = ReadabilityLimits{
+	Timeout:         TIMEOUT_REQUEST,
+	MaxBytes:        5 << 20,
+	BreakerFailures: 5,
+	BreakerCooldown: time.Minute,
+}
+
+// ErrReadabilityTooLarge is returned for pages larger than the limit.
+var ErrReadabilityTooLarge = errors.New("page is too large for readability")
+
+// errReadabilityBreakerOpen is returned while the breaker is open. It's an
+// ErrReadabilityUnavailable, the pages are read with the density extractor
+// without a warning each.
+var errReadabilityBreakerOpen = fmt.Errorf("%w: too many timeouts, paused", ErrReadabilityUnavailable)
+
+// readabilityBreaker counts the timeouts in a row of a sidecar.
+type readabilityBreaker struct {
+	limits ReadabilityLimits
+	logger *slog.Logger
+
+	mu        sync.Mutex
+	failures  int
+	openUntil time.Time
+}
+
+func newReadabilityBreaker(limits ReadabilityLimits, logger *slog.Logger) *readabilityBreaker {
+	return &readabilityBreaker{limits: limits, logger: logger}
+}
+
+// allow returns why a page can't be parsed now, or nil.
+func (b *readabilityBreaker) allow(htmlBody string, now time.Time) error {
+	if b.limits.MaxBytes > 0 && len(htmlBody) > b.limits.MaxBytes {
+		return fmt.Errorf("%w: %d KB, at most %d KB", ErrReadabilityTooLarge, (len(htmlBody)+1023)>>10, b.limits.MaxBytes>>10)
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if now.Before(b.openUntil) {
+		return errReadabilityBreakerOpen
+	}
+	return nil
+}
+
+// timeout returns the context a parse runs in, ending at the timeout.
+func (b *readabilityBreaker) timeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if b.limits.Timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, b.limits.Timeout)
+}
+
+// record counts the outcome of a parse run in ctx, the context timeout made
+// of parent. Only the parses that ran out of time count as failures, a page
+// without an article or a request its caller gave up on don't.
+func (b *readabilityBreaker) record(parent, ctx context.Context, err error, now time.Time) {
+	timedOut := err != nil && parent.Err() == nil && errors.Is(ctx.Err(), context.DeadlineExceeded)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !timedOut {
+		if err == nil {
+			b.failures = 0
+		}
+		return
+	}
+	b.failures++
+	if b.limits.BreakerFailures > 0 && b.failures >= b.limits.BreakerFailures {
+		b.failures = 0
+		b.openUntil = now.Add(b.limits.BreakerCooldown)
+		b.logger.Warn("readability timed out too many times in a row, pausing it", "timeouts", b.limits.BreakerFailures, "until", b.openUntil)
+	}
+}
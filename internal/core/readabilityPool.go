@@ -0,0 +1,100 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+)
+
+// A sidecar parses one page at a time, its JavaScript running on a single
+// thread. ReadabilityPool runs several, each page going to one that's
+// free, so users reading at once don't wait on each other's pages.
+type ReadabilityPool struct {
+	sidecars []ReadabilitySidecar
+	// idle holds the sidecars not parsing a page.
+	idle chan ReadabilitySidecar
+}
+
+// NewReadabilityPool starts size sidecars with start. The pool serves with
+// the ones that started, failing only when none did.
+func NewReadabilityPool(ctx context.Context, logger *slog.Logger, size int, start func(ctx context.Context) (ReadabilitySidecar, error)) (*ReadabilityPool, error) {
+	size = max(size, 1)
+	var (
+		mu       sync.Mutex
+		wg       sync.WaitGroup
+		sidecars []ReadabilitySidecar
+		errs     []error
+	)
+	for range size {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			sidecar, err := start(ctx)
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				errs = append(errs, err)
+				return
+			}
+			sidecars = append(sidecars, sidecar)
+		}()
+	}
+	wg.Wait()
+	if len(sidecars) == 0 {
+		return nil, errors.Join(errs...)
+	}
+	if len(errs) > 0 {
+		logger.Warn("some readability servers failed to start", "started", len(sidecars), "size", size, "error", errors.Join(errs...))
+	}
+
+	pool := &ReadabilityPool{
+		sidecars: sidecars,
+		idle:     make(chan ReadabilitySidecar, len(sidecars)),
+	}
+	for _, sidecar := range sidecars {
+		pool.idle <- sidecar
+	}
+	return pool, nil
+}
+
+func (p *ReadabilityPool) Parse(ctx context.Context, htmlBody string, url string) (*ReadabilityResponseSuccess, error) {
+	var sidecar ReadabilitySidecar
+	select {
+	case sidecar = <-p.idle:
+	case <-ctx.Done():
+		return nil, fmt.Errorf("gave up waiting for a free readability server: %w", ctx.Err())
+	}
+	resp, err := sidecar.Parse(ctx, htmlBody, url)
+	// A sidecar paused after timeouts passes the page to another free one,
+	// without waiting for one.
+retry:
+	for tries := 1; errors.Is(err, errReadabilityBreakerOpen) && tries < len(p.sidecars); tries++ {
+		select {
+		case next := <-p.idle:
+			p.idle <- sidecar
+			sidecar = next
+		default:
+			break retry
+		}
+		resp, err = sidecar.Parse(ctx, htmlBody, url)
+	}
+	p.idle <- sidecar
+	return resp, err
+}
+
+// Close closes the sidecars at once, each within ctx.
+func (p *ReadabilityPool) Close(ctx context.Context) error {
+	errs := make([]error, len(p.sidecars))
+	var wg sync.WaitGroup
+	for i, sidecar := range p.sidecars {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = sidecar.Close(ctx)
+		}()
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
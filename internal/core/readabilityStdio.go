@@ -0,0 +1,252 @@
+package core
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"os"
+	"os/exec"
+	"sync"
+	"time"
+)
+
+// The stdio transport speaks JSON-RPC 2.0 with the sidecar over its stdin and
+// stdout, a message per line. It needs no socket file and no signals, the
+// sidecar exits when its stdin is closed, so it also works where unix sockets
+// and SIGTERM don't, like Windows.
+
+// maxReadabilityResponse caps a response line, the article and its text.
+const maxReadabilityResponse = 64 << 20
+
+type rpcRequest struct {
+	JSONRPC string `json:"jsonrpc"`
+	ID      int64  `json:"id"`
+	Method  string `json:"method"`
+	Params  any    `json:"params"`
+}
+
+type rpcParseParams struct {
+	HTML string `json:"html"`
+	URL  string `json:"url,omitempty"`
+}
+
+type rpcResponse struct {
+	ID     int64           `json:"id"`
+	Result json.RawMessage `json:"result"`
+	Error  *rpcError       `json:"error"`
+}
+
+type rpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+type ReadabilityStdioClient struct {
+	cmd     *exec.Cmd
+	stdin   io.WriteCloser
+	logger  *slog.Logger
+	breaker *readabilityBreaker
+	// writeMu keeps requests whole on stdin.
+	writeMu sync.Mutex
+	// exited is closed when the sidecar's stdout ends.
+	exited chan struct{}
+
+	mu      sync.Mutex
+	nextID  int64
+	pending map[int64]chan rpcResponse
+	closed  bool
+	// stopping is set by the first Close.
+	stopping bool
+}
+
+func NewReadabilityStdioClient(
+	ctx context.Context,
+	logger *slog.Logger,
+	childLogger *log.Logger,
+	serverBinaryPath string,
+	limits ReadabilityLimits,
+) (*ReadabilityStdioClient, error) {
+	if _, err := os.Stat(serverBinaryPath); os.IsNotExist(err) {
+		return nil, fmt.Errorf("%s readability binary does not exist", serverBinaryPath)
+	}
+
+	cmd := exec.Command(serverBinaryPath, "--stdio")
+	if childLogger != nil {
+		cmd.Stderr = childLogger.Writer()
+	} else {
+		logger.Warn("readability binary logs are suppressed")
+	}
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, fmt.Errorf("failed to open readability stdin: %w", err)
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, fmt.Errorf("failed to open readability stdout: %w", err)
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("failed to start readability server: %w", err)
+	}
+
+	client := &ReadabilityStdioClient{
+		cmd:     cmd,
+		stdin:   stdin,
+		logger:  logger,
+		breaker: newReadabilityBreaker(limits, logger),
+		exited:  make(chan struct{}),
+		pending: map[int64]chan rpcResponse{},
+	}
+	go client.readResponses(stdout)
+
+	if err := readabilityHealthcheck(ctx, client, logger); err != nil {
+		ctxClose, cancelClose := context.WithTimeout(context.Background(), 3*time.Second)
+		defer cancelClose()
+		_ = client.Close(ctxClose)
+		return nil, fmt.Errorf("server failed health check: %w", err)
+	}
+	return client, nil
+}
+
+// readResponses hands the responses to the requests waiting for them, the
+// ones nobody waits for anymore, after a timeout, are dropped.
+func (rc *ReadabilityStdioClient) readResponses(stdout io.Reader) {
+	defer close(rc.exited)
+	scanner := bufio.NewScanner(stdout)
+	scanner.Buffer(make([]byte, 64<<10), maxReadabilityResponse)
+	for scanner.Scan() {
+		var resp rpcResponse
+		if err := json.Unmarshal(scanner.Bytes(), &resp); err != nil {
+			rc.logger.Warn("invalid response from readability server", "error", err)
+			continue
+		}
+		rc.mu.Lock()
+		ch, ok := rc.pending[resp.ID]
+		delete(rc.pending, resp.ID)
+		rc.mu.Unlock()
+		if ok {
+			ch <- resp
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		rc.logger.Error("failed to read from readability server", "error", err)
+	}
+
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	rc.closed = true
+	for id, ch := range rc.pending {
+		close(ch)
+		delete(rc.pending, id)
+	}
+}
+
+func (rc *ReadabilityStdioClient) Parse(ctx context.Context, htmlBody string, url string) (*ReadabilityResponseSuccess, error) {
+	if err := rc.breaker.allow(htmlBody, time.Now()); err != nil {
+		return nil, err
+	}
+	parent := ctx
+	ctx, cancel := rc.breaker.timeout(parent)
+	defer cancel()
+	resp, err := rc.parse(ctx, htmlBody, url)
+	rc.breaker.record(parent, ctx, err, time.Now())
+	return resp, err
+}
+
+func (rc *ReadabilityStdioClient) parse(ctx context.Context, htmlBody string, url string) (*ReadabilityResponseSuccess, error) {
+	rc.mu.Lock()
+	if rc.closed {
+		rc.mu.Unlock()
+		return nil, fmt.Errorf("readability client is closed or server process exited")
+	}
+	rc.nextID++
+	id := rc.nextID
+	ch := make(chan rpcResponse, 1)
+	rc.pending[id] = ch
+	rc.mu.Unlock()
+	forget := func() {
+		rc.mu.Lock()
+		delete(rc.pending, id)
+		rc.mu.Unlock()
+	}
+
+	line, err := json.Marshal(rpcRequest{
+		JSONRPC: "2.0",
+		ID:      id,
+		Method:  "parse",
+		Params:  rpcParseParams{HTML: htmlBody, URL: url},
+	})
+	if err != nil {
+		forget()
+		return nil, fmt.Errorf("failed to encode request: %w", err)
+	}
+	rc.writeMu.Lock()
+	_, err = rc.stdin.Write(append(line, '\n'))
+	rc.writeMu.Unlock()
+	if err != nil {
+		forget()
+		return nil, fmt.Errorf("failed to send request to readability server: %w", err)
+	}
+
+	select {
+	case resp, ok := <-ch:
+		if !ok {
+			return nil, fmt.Errorf("readability server exited")
+		}
+		if resp.Error != nil {
+			return nil, fmt.Errorf("server returned error %d: %s", resp.Error.Code, resp.Error.Message)
+		}
+		var successResp ReadabilityResponseSuccess
+		if err := json.Unmarshal(resp.Result, &successResp); err != nil {
+			return nil, fmt.Errorf("failed to parse successful response JSON: %w", err)
+		}
+		rc.logger.Info("successfully parsed document",
+			"title", successResp.Title,
+			"siteName", successResp.SiteName)
+		return &successResp, nil
+	case <-ctx.Done():
+		forget()
+		return nil, fmt.Errorf("request cancelled or timed out: %w", ctx.Err())
+	}
+}
+
+// Close closes the sidecar's stdin for it to exit, killing it when it hasn't
+// by the time ctx ends.
+func (rc *ReadabilityStdioClient) Close(ctx context.Context) error {
+	rc.mu.Lock()
+	if rc.stopping {
+		rc.mu.Unlock()
+		return nil
+	}
+	rc.stopping = true
+	rc.closed = true
+	rc.mu.Unlock()
+
+	pid := rc.cmd.Process.Pid
+	rc.logger.Info("Closing readability server process", "pid", pid)
+	rc.writeMu.Lock()
+	_ = rc.stdin.Close()
+	rc.writeMu.Unlock()
+
+	var closeErr error
+	select {
+	case <-rc.exited:
+	case <-ctx.Done():
+		rc.logger.Warn("Graceful shutdown timed out, killing", "pid", pid)
+		if err := rc.cmd.Process.Kill(); err != nil {
+			rc.logger.Error("Failed to kill readability server", "pid", pid, "error", err)
+		}
+		closeErr = fmt.Errorf("readability server closed via kill after timeout: %w", ctx.Err())
+		select {
+		case <-rc.exited:
+		case <-time.After(TIMEOUT_WAIT_AFTER_KILL):
+			return fmt.Errorf("readability server timeout, killed but it did not exit: %w", ctx.Err())
+		}
+	}
+	waitErr := rc.cmd.Wait()
+	rc.logger.Info("Readability server closed", "pid", pid, "exitErr", waitErr)
+	return closeErr
+}
@@ -0,0 +1,113 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// ErrReadabilityNotReloadable is returned when the readability in use can't be
+// restarted in place.
+var ErrReadabilityNotReloadable = errors.New("readability can't be reloaded")
+
+// readabilityStartTimeout is how long a new sidecar has to pass its health
+// check, the constructors retry it until their context ends.
+const readabilityStartTimeout = 30 * time.Second
+
+// SwappableReadability is a readability sidecar that can be replaced while
+// serving. Reload starts a new one, switches to it once it's healthy and
+// retires the old one after the requests it's serving, so upgrading the
+// readability binary doesn't take the server down.
+type SwappableReadability struct {
+	start  func(ctx context.Context) (ReadabilitySidecar, error)
+	logger *slog.Logger
+	// reloading keeps reloads one at a time.
+	reloading sync.Mutex
+
+	mu      sync.RWMutex
+	current *readabilityGeneration
+}
+
+type readabilityGeneration struct {
+	sidecar  ReadabilitySidecar
+	inflight sync.WaitGroup
+}
+
+// NewSwappableReadability returns a SwappableReadability that starts
+// sidecars with start. There's none until the first Reload, Parse returns
+// ErrReadabilityUnavailable until then.
+func NewSwappableReadability(logger *slog.Logger, start func(ctx context.Context) (ReadabilitySidecar, error)) *SwappableReadability {
+	return &SwappableReadability{start: start, logger: logger}
+}
+
+func (s *SwappableReadability) Parse(ctx context.Context, htmlBody string, url string) (*ReadabilityResponseSuccess, error) {
+	s.mu.RLock()
+	gen := s.current
+	if gen == nil {
+		s.mu.RUnlock()
+		return nil, ErrReadabilityUnavailable
+	}
+	gen.inflight.Add(1)
+	s.mu.RUnlock()
+	defer gen.inflight.Done()
+	return gen.sidecar.Parse(ctx, htmlBody, url)
+}
+
+// Reload starts a new sidecar and switches to it, or starts the first one.
+// When the new one doesn't start or isn't healthy, the old one keeps
+// serving.
+func (s *SwappableReadability) Reload(ctx context.Context) error {
+	s.reloading.Lock()
+	defer s.reloading.Unlock()
+
+	s.logger.Info("starting a new readability server")
+	startCtx, cancelStart := context.WithTimeout(ctx, readabilityStartTimeout)
+	defer cancelStart()
+	sidecar, err := s.start(startCtx)
+	if err != nil {
+		return fmt.Errorf("failed to start the new readability server: %w", err)
+	}
+
+	s.mu.Lock()
+	old := s.current
+	s.current = &readabilityGeneration{sidecar: sidecar}
+	s.mu.Unlock()
+	if old == nil {
+		return nil
+	}
+	s.logger.Info("switched to the new readability server, retiring the old one")
+
+	old.inflight.Wait()
+	closeCtx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	if err := old.sidecar.Close(closeCtx); err != nil {
+		s.logger.Warn("failed to close the old readability server", "error", err)
+	}
+	return nil
+}
+
+func (s *SwappableReadability) Close(ctx context.Context) error {
+	s.reloading.Lock()
+	defer s.reloading.Unlock()
+	s.mu.RLock()
+	gen := s.current
+	s.mu.RUnlock()
+	if gen == nil {
+		return nil
+	}
+	return gen.sidecar.Close(ctx)
+}
+
+// ReloadReadability restarts the readability sidecar without downtime.
+func (c *Core) ReloadReadability(ctx context.Context) error {
+	reloader, ok := c.readabilityClient.(interface {
+		Reload(ctx context.Context) error
+	})
+	if !ok {
+		return ErrReadabilityNotReloadable
+	}
+	return reloader.Reload(ctx)
+}
@@ -0,0 +1,498 @@
+// The little of the DOM Readability.js uses, for running it on goja without
+// a browser. The page is parsed on the Go side, handed over as a tree of
+// arrays, [1, tag, [name, value, ...], [children]] for an element and
+// [3, text] for text, and built here into nodes. Markup set through
+// innerHTML is parsed on the Go side too, by __parseFragment, and URLs are
+// resolved by __resolveURL.
+//
+// Like JSDOMParser, the document Readability.js ships for its tests, the
+// node lists are arrays changed in place and __JSDOMParser__ is set, for
+// Readability.js to rename elements instead of replacing them.
+
+var VOID_ELEMENTS = {
+  area: true, base: true, br: true, col: true, embed: true, hr: true, img: true,
+  input: true, link: true, meta: true, param: true, source: true, track: true, wbr: true,
+};
+
+// The text of these is left as it is, noscript's included, the page being
+// parsed with scripting on.
+var RAW_TEXT_ELEMENTS = {
+  script: true, style: true, xmp: true, iframe: true, noembed: true,
+  noframes: true, plaintext: true, noscript: true,
+};
+
+function escapeText(s) {
+  return s.replace(/&/g, "&amp;").replace(/</g, "&lt;").replace(/>/g, "&gt;").replace(/\u00a0/g, "&nbsp;");
+}
+
+function escapeAttribute(s) {
+  return s.replace(/&/g, "&amp;").replace(/"/g, "&quot;").replace(/\u00a0/g, "&nbsp;");
+}
+
+class Attr {
+  constructor(name, value) {
+    this.name = name;
+    this.value = value;
+  }
+
+  cloneNode() {
+    return new Attr(this.name, this.value);
+  }
+}
+
+class Node {
+  constructor() {
+    this.parentNode = null;
+    this.nextSibling = null;
+    this.previousSibling = null;
+    this.childNodes = [];
+    this.children = [];
+    this.ownerDocument = null;
+  }
+
+  get firstChild() {
+    return this.childNodes.length ? this.childNodes[0] : null;
+  }
+
+  get lastChild() {
+    return this.childNodes.length ? this.childNodes[this.childNodes.length - 1] : null;
+  }
+
+  get firstElementChild() {
+    return this.children.length ? this.children[0] : null;
+  }
+
+  get lastElementChild() {
+    return this.children.length ? this.children[this.children.length - 1] : null;
+  }
+
+  get nextElementSibling() {
+    var node = this.nextSibling;
+    while (node && node.nodeType !== Node.ELEMENT_NODE) {
+      node = node.nextSibling;
+    }
+    return node;
+  }
+
+  get previousElementSibling() {
+    var node = this.previousSibling;
+    while (node && node.nodeType !== Node.ELEMENT_NODE) {
+      node = node.previousSibling;
+    }
+    return node;
+  }
+
+  appendChild(child) {
+    if (child.parentNode) {
+      child.parentNode.removeChild(child);
+    }
+    var last = this.lastChild;
+    if (last) {
+      last.nextSibling = child;
+    }
+    child.previousSibling = last;
+    child.nextSibling = null;
+    child.parentNode = this;
+    this.childNodes.push(child);
+    if (child.nodeType === Node.ELEMENT_NODE) {
+      this.children.push(child);
+    }
+    return child;
+  }
+
+  removeChild(child) {
+    var i = this.childNodes.indexOf(child);
+    if (i === -1) {
+      throw new Error("removeChild: node not found");
+    }
+    this.childNodes.splice(i, 1);
+    if (child.nodeType === Node.ELEMENT_NODE) {
+      this.children.splice(this.children.indexOf(child), 1);
+    }
+    if (child.previousSibling) {
+      child.previousSibling.nextSibling = child.nextSibling;
+    }
+    if (child.nextSibling) {
+      child.nextSibling.previousSibling = child.previousSibling;
+    }
+    child.parentNode = child.nextSibling = child.previousSibling = null;
+    return child;
+  }
+
+  replaceChild(newChild, oldChild) {
+    if (newChild === oldChild) {
+      return oldChild;
+    }
+    if (newChild.parentNode) {
+      newChild.parentNode.removeChild(newChild);
+    }
+    var i = this.childNodes.indexOf(oldChild);
+    if (i === -1) {
+      throw new Error("replaceChild: node not found");
+    }
+    this.childNodes[i] = newChild;
+    newChild.parentNode = this;
+    newChild.previousSibling = oldChild.previousSibling;
+    newChild.nextSibling = oldChild.nextSibling;
+    if (newChild.previousSibling) {
+      newChild.previousSibling.nextSibling = newChild;
+    }
+    if (newChild.nextSibling) {
+      newChild.nextSibling.previousSibling = newChild;
+    }
+    oldChild.parentNode = oldChild.nextSibling = oldChild.previousSibling = null;
+    this._updateChildren();
+    return oldChild;
+  }
+
+  remove() {
+    if (this.parentNode) {
+      this.parentNode.removeChild(this);
+    }
+  }
+
+  // _updateChildren brings children in line with childNodes, in place for
+  // the ones holding it.
+  _updateChildren() {
+    var elements = this.childNodes.filter(function (node) {
+      return node.nodeType === Node.ELEMENT_NODE;
+    });
+    this.children.length = 0;
+    Array.prototype.push.apply(this.children, elements);
+  }
+
+  getElementsByTagName(tag) {
+    tag = tag.toLowerCase();
+    var all = tag === "*";
+    var found = [];
+    var stack = this.children.slice().reverse();
+    while (stack.length) {
+      var node = stack.pop();
+      if (all || node.localName === tag) {
+        found.push(node);
+      }
+      for (var i = node.children.length - 1; i >= 0; i--) {
+        stack.push(node.children[i]);
+      }
+    }
+    return found;
+  }
+
+  get textContent() {
+    var text = [];
+    var walk = function (node) {
+      for (var i = 0; i < node.childNodes.length; i++) {
+        var child = node.childNodes[i];
+        if (child.nodeType === Node.TEXT_NODE) {
+          text.push(child.data);
+        } else {
+          walk(child);
+        }
+      }
+    };
+    walk(this);
+    return text.join("");
+  }
+
+  set textContent(text) {
+    while (this.childNodes.length) {
+      this.removeChild(this.childNodes[0]);
+    }
+    if (text !== "") {
+      this.appendChild(this.ownerDocument.createTextNode(text));
+    }
+  }
+}
+
+Node.prototype.__JSDOMParser__ = true;
+Node.ELEMENT_NODE = Node.prototype.ELEMENT_NODE = 1;
+Node.TEXT_NODE = Node.prototype.TEXT_NODE = 3;
+Node.DOCUMENT_NODE = Node.prototype.DOCUMENT_NODE = 9;
+
+class Text extends Node {
+  constructor(data) {
+    super();
+    this.data = data;
+  }
+
+  get nodeType() {
+    return Node.TEXT_NODE;
+  }
+
+  get nodeName() {
+    return "#text";
+  }
+
+  get nodeValue() {
+    return this.data;
+  }
+
+  set nodeValue(data) {
+    this.data = data;
+  }
+
+  get textContent() {
+    return this.data;
+  }
+
+  set textContent(data) {
+    this.data = data;
+  }
+}
+
+class Element extends Node {
+  constructor(tag) {
+    super();
+    this.localName = tag.toLowerCase();
+    this.tagName = tag.toUpperCase();
+    this.attributes = [];
+  }
+
+  get nodeType() {
+    return Node.ELEMENT_NODE;
+  }
+
+  get nodeName() {
+    return this.tagName;
+  }
+
+  _findAttribute(name) {
+    name = name.toLowerCase();
+    for (var i = 0; i < this.attributes.length; i++) {
+      if (this.attributes[i].name === name) {
+        return i;
+      }
+    }
+    return -1;
+  }
+
+  getAttribute(name) {
+    var i = this._findAttribute(name);
+    return i === -1 ? null : this.attributes[i].value;
+  }
+
+  hasAttribute(name) {
+    return this._findAttribute(name) !== -1;
+  }
+
+  setAttribute(name, value) {
+    var i = this._findAttribute(name);
+    if (i === -1) {
+      this.attributes.push(new Attr(name.toLowerCase(), String(value)));
+    } else {
+      this.attributes[i].value = String(value);
+    }
+  }
+
+  setAttributeNode(attr) {
+    this.setAttribute(attr.name, attr.value);
+  }
+
+  removeAttribute(name) {
+    var i = this._findAttribute(name);
+    if (i !== -1) {
+      this.attributes.splice(i, 1);
+    }
+  }
+
+  get id() {
+    return this.getAttribute("id") || "";
+  }
+
+  set id(value) {
+    this.setAttribute("id", value);
+  }
+
+  get className() {
+    return this.getAttribute("class") || "";
+  }
+
+  set className(value) {
+    this.setAttribute("class", value);
+  }
+
+  get src() {
+    return this.getAttribute("src") || "";
+  }
+
+  set src(value) {
+    this.setAttribute("src", value);
+  }
+
+  get srcset() {
+    return this.getAttribute("srcset") || "";
+  }
+
+  set srcset(value) {
+    this.setAttribute("srcset", value);
+  }
+
+  get href() {
+    return this.getAttribute("href") || "";
+  }
+
+  set href(value) {
+    this.setAttribute("href", value);
+  }
+
+  // style holds the declarations of the style attribute, by their names in
+  // camel case.
+  get style() {
+    var style = { display: "", visibility: "" };
+    var declarations = (this.getAttribute("style") || "").split(";");
+    for (var i = 0; i < declarations.length; i++) {
+      var colon = declarations[i].indexOf(":");
+      if (colon === -1) {
+        continue;
+      }
+      var name = declarations[i].substring(0, colon).trim().toLowerCase();
+      name = name.replace(/-([a-z])/g, function (_, c) {
+        return c.toUpperCase();
+      });
+      style[name] = declarations[i].substring(colon + 1).replace(/!important/i, "").trim();
+    }
+    return style;
+  }
+
+  get innerHTML() {
+    var html = [];
+    var serialize = function (node) {
+      for (var i = 0; i < node.childNodes.length; i++) {
+        var child = node.childNodes[i];
+        if (child.nodeType === Node.TEXT_NODE) {
+          html.push(RAW_TEXT_ELEMENTS[node.localName] ? child.data : escapeText(child.data));
+          continue;
+        }
+        html.push("<" + child.localName);
+        for (var j = 0; j < child.attributes.length; j++) {
+          var attr = child.attributes[j];
+          html.push(" " + attr.name + '="' + escapeAttribute(attr.value) + '"');
+        }
+        html.push(">");
+        if (VOID_ELEMENTS[child.localName]) {
+          continue;
+        }
+        serialize(child);
+        html.push("</" + child.localName + ">");
+      }
+    };
+    serialize(this);
+    return html.join("");
+  }
+
+  set innerHTML(html) {
+    while (this.childNodes.length) {
+      this.removeChild(this.childNodes[0]);
+    }
+    var nodes = JSON.parse(__parseFragment(String(html)));
+    for (var i = 0; i < nodes.length; i++) {
+      this.appendChild(this.ownerDocument._build(nodes[i]));
+    }
+  }
+}
+
+class Document extends Node {
+  constructor(url) {
+    super();
+    this.ownerDocument = null;
+    this.documentURI = url;
+  }
+
+  get nodeType() {
+    return Node.DOCUMENT_NODE;
+  }
+
+  get nodeName() {
+    return "#document";
+  }
+
+  get documentElement() {
+    return this.firstElementChild;
+  }
+
+  get head() {
+    return this._child("head");
+  }
+
+  get body() {
+    return this._child("body");
+  }
+
+  _child(tag) {
+    var root = this.documentElement;
+    if (!root) {
+      return null;
+    }
+    for (var i = 0; i < root.children.length; i++) {
+      if (root.children[i].localName === tag) {
+        return root.children[i];
+      }
+    }
+    return null;
+  }
+
+  get title() {
+    var title = this.getElementsByTagName("title")[0];
+    return title ? title.textContent.replace(/[\t\n\f\r ]+/g, " ").trim() : "";
+  }
+
+  get baseURI() {
+    var base = this.getElementsByTagName("base")[0];
+    var href = base && base.getAttribute("href");
+    if (href) {
+      try {
+        return new URL(href, this.documentURI).href;
+      } catch (ex) {
+        // The page's own URL is the base.
+      }
+    }
+    return this.documentURI;
+  }
+
+  createElement(tag) {
+    var element = new Element(tag);
+    element.ownerDocument = this;
+    return element;
+  }
+
+  createTextNode(data) {
+    var text = new Text(String(data));
+    text.ownerDocument = this;
+    return text;
+  }
+
+  // _build makes a node of its tree from the Go side.
+  _build(tree) {
+    if (tree[0] === Node.TEXT_NODE) {
+      return this.createTextNode(tree[1]);
+    }
+    var element = this.createElement(tree[1]);
+    var attrs = tree[2];
+    for (var i = 0; i < attrs.length; i += 2) {
+      element.attributes.push(new Attr(attrs[i], attrs[i + 1]));
+    }
+    var children = tree[3];
+    for (var j = 0; j < children.length; j++) {
+      element.appendChild(this._build(children[j]));
+    }
+    return element;
+  }
+}
+
+class URL {
+  constructor(url, base) {
+    this.href = __resolveURL(String(url), base === undefined ? "" : String(base));
+  }
+
+  toString() {
+    return this.href;
+  }
+}
+
+// parseArticle runs Readability.js on the page given as a tree, returning
+// the article as JSON, "null" when there's none.
+function parseArticle(tree, url) {
+  var doc = new Document(url);
+  doc.appendChild(doc._build(JSON.parse(tree)));
+  return JSON.stringify(new Readability(doc).parse());
+}
@@ -0,0 +1,141 @@
+package core
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"slices"
+
+	db "github.com/egemengol/kindlepathy/internal/db/generated"
+)
+
+// The reader is styled by the server from the reading preferences of the
+// user. The Kindle browser runs little of what scripts do to a page and
+// forgets its localStorage now and then, so the preferences are kept with
+// the account and rendered into the CSS of every page.
+
+// Fonts of the reader.
+const (
+	FontBookerly  = "bookerly"
+	FontSerif     = "serif"
+	FontSansSerif = "sans-serif"
+	FontMonospace = "monospace"
+)
+
+// Margins on the sides of the text of the reader.
+const (
+	MarginNarrow = "narrow"
+	MarginNormal = "normal"
+	MarginWide   = "wide"
+)
+
+// Themes of the reader.
+const (
+	ThemeLight = "light"
+	ThemeDark  = "dark"
+)
+
+// ReadingFonts, ReadingMargins and ReadingThemes are the choices of the
+// settings, in the order they're offered.
+var (
+	ReadingFonts   = []string{FontBookerly, FontSerif, FontSansSerif, FontMonospace}
+	ReadingMargins = []string{MarginNarrow, MarginNormal, MarginWide}
+	ReadingThemes  = []string{ThemeLight, ThemeDark}
+)
+
+// Bounds of the font size and the line height, in percent.
+const (
+	MinFontSize   = 80
+	MaxFontSize   = 200
+	MinLineHeight = 100
+	MaxLineHeight = 250
+)
+
+// ReadingSettings are the reading preferences of a user.
+type ReadingSettings struct {
+	FontFamily string
+	// FontSize is in percent of the root font size, LineHeight in percent
+	// of the font size.
+	FontSize   int
+	LineHeight int
+	Margin     string
+	Justify    bool
+	Theme      string
+}
+
+// DefaultReadingSettings are the settings of users who didn't change them,
+// the reader as it always looked.
+var DefaultReadingSettings = ReadingSettings{
+	FontFamily: FontBookerly,
+	FontSize:   120,
+	LineHeight: 150,
+	Margin:     MarginNormal,
+	Justify:    false,
+	Theme:      ThemeLight,
+}
+
+func (s ReadingSettings) validate() error {
+	switch {
+	case !slices.Contains(ReadingFonts, s.FontFamily):
+		return fmt.Errorf("invalid font: %q", s.FontFamily)
+	case s.FontSize < MinFontSize || s.FontSize > MaxFontSize:
+		return fmt.Errorf("font size must be between %d%% and %d%%", MinFontSize, MaxFontSize)
+	case s.LineHeight < MinLineHeight || s.LineHeight > MaxLineHeight:
+		return fmt.Errorf("line height must be between %d%% and %d%%", MinLineHeight, MaxLineHeight)
+	case !slices.Contains(ReadingMargins, s.Margin):
+		return fmt.Errorf("invalid margin: %q", s.Margin)
+	case !slices.Contains(ReadingThemes, s.Theme):
+		return fmt.Errorf("invalid theme: %q", s.Theme)
+	}
+	return nil
+}
+
+// GetReadingSettings returns the reading preferences of the user, the
+// defaults when they never set them.
+func (c *Core) GetReadingSettings(ctx context.Context, userID int64) (ReadingSettings, error) {
+	row, err := c.queries.UserSettingsGet(ctx, userID)
+	if err == sql.ErrNoRows {
+		return DefaultReadingSettings, nil
+	}
+	if err != nil {
+		return ReadingSettings{}, fmt.Errorf("failed to get reading settings: %w", err)
+	}
+	return ReadingSettings{
+		FontFamily: row.FontFamily,
+		FontSize:   int(row.FontSize),
+		LineHeight: int(row.LineHeight),
+		Margin:     row.Margin,
+		Justify:    row.Justify,
+		Theme:      row.Theme,
+	}, nil
+}
+
+func (c *Core) SetReadingSettings(ctx context.Context, userID int64, s ReadingSettings) error {
+	if err := s.validate(); err != nil {
+		return err
+	}
+	err := c.queries.UserSettingsSet(ctx, db.UserSettingsSetParams{
+		UserID:     userID,
+		FontFamily: s.FontFamily,
+		FontSize:   int64(s.FontSize),
+		LineHeight: int64(s.LineHeight),
+		Margin:     s.Margin,
+		Justify:    s.Justify,
+		Theme:      s.Theme,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to set reading settings: %w", err)
+	}
+	return nil
+}
+
+// SetReadingFontSize changes only the font size, as the A- and A+ buttons
+// of the reader do. The size is kept within its bounds.
+func (c *Core) SetReadingFontSize(ctx context.Context, userID int64, size int) error {
+	s, err := c.GetReadingSettings(ctx, userID)
+	if err != nil {
+		return err
+	}
+	s.FontSize = max(MinFontSize, min(MaxFontSize, size))
+	return c.SetReadingSettings(ctx, userID, s)
+}
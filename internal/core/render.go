@@ -0,0 +1,137 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// Many sites send an empty shell and build the article with JavaScript, the
+// fetched page reads as nothing. With a renderer configured, such pages are
+// loaded in a headless browser service speaking the browserless /content
+// API, and the page it ends up with is read instead. Users get it with the
+// headless_render flag.
+
+const (
+	// renderMinWords is the length below which the content is taken for
+	// the empty shell of a JavaScript app.
+	renderMinWords = 50
+	// maxRenderedSize caps the rendered page.
+	maxRenderedSize = 10 << 20
+)
+
+type Renderer struct {
+	// Endpoint is the URL pages are rendered by, with any token.
+	Endpoint string
+	Client   *http.Client
+}
+
+type renderCookie struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+	URL   string `json:"url"`
+}
+
+// Render returns the HTML of the page once its scripts have run, loaded
+// with the cookies of a Cookie header.
+func (r *Renderer) Render(ctx context.Context, pageURL, cookies string) (string, error) {
+	request := map[string]any{
+		"url": pageURL,
+		"gotoOptions": map[string]any{
+			"waitUntil": "networkidle2",
+		},
+	}
+	if cookies != "" {
+		parsed, err := http.ParseCookie(cookies)
+		if err != nil {
+			return "", fmt.Errorf("invalid cookies: %w", err)
+		}
+		renderCookies := make([]renderCookie, len(parsed))
+		for i, cookie := range parsed {
+			renderCookies[i] = renderCookie{Name: cookie.Name, Value: cookie.Value, URL: pageURL}
+		}
+		request["cookies"] = renderCookies
+	}
+	body, err := json.Marshal(request)
+	if err != nil {
+		return "", err
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", r.Endpoint, bytes.NewReader(body))
+	if err != nil {
+		return "", fmt.Errorf("failed to create render request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	client := r.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to reach the render service: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return "", fmt.Errorf("render service answered %d: %s", resp.StatusCode, strings.TrimSpace(string(msg)))
+	}
+
+	page, err := io.ReadAll(io.LimitReader(resp.Body, maxRenderedSize+1))
+	if err != nil {
+		return "", fmt.Errorf("failed to read rendered page: %w", err)
+	}
+	if len(page) > maxRenderedSize {
+		return "", fmt.Errorf("rendered page is larger than %d bytes", maxRenderedSize)
+	}
+	return string(page), nil
+}
+
+// SetRenderer enables rendering pages in a headless browser.
+func (c *Core) SetRenderer(r *Renderer) {
+	c.renderer = r
+}
+
+// renders tells whether empty pages are rendered for the fetching user, or
+// for the instance when there's no user.
+func (c *Core) renders(ctx context.Context) bool {
+	if c.renderer == nil {
+		return false
+	}
+	if userID, ok := ctx.Value(fetchUserKey{}).(int64); ok {
+		return c.FlagEnabled(ctx, userID, "headless_render")
+	}
+	flags, err := c.InstanceFlags(ctx)
+	if err != nil {
+		c.Logger.Warn("Error resolving feature flags", "error", err)
+		return false
+	}
+	return flags.Enabled("headless_render")
+}
+
+// emptyShell tells whether the extraction came out empty, like from the
+// shell of a JavaScript app.
+func emptyShell(clean *Clean, err error) bool {
+	if err != nil {
+		return true
+	}
+	_, words := wordSet(clean.ContentHTML)
+	return words < renderMinWords
+}
+
+// render fetches the page through the renderer, with the fetching user's
+// cookies.
+func (c *Core) render(ctx context.Context, pageURL string) (string, error) {
+	var cookies string
+	if userID, ok := ctx.Value(fetchUserKey{}).(int64); ok {
+		var err error
+		cookies, err = c.siteCookies(ctx, userID, pageURL)
+		if err != nil {
+			return "", err
+		}
+	}
+	return c.renderer.Render(ctx, pageURL, cookies)
+}
@@ -0,0 +1,131 @@
+package core
+
+import (
+	"net/url"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// Content is shown unescaped in the reader, so it goes through an allowlist
+// before it's stored and again before every render: the elements and
+// attributes of an article are kept, the ones that could run code or load
+// a page are dropped with their content, and any other element is replaced
+// by its content. Links and images keep http(s) and relative URLs only.
+
+// droppedElements are removed along with their content.
+const droppedElements = "script, style, noscript, template, iframe, frame, frameset, object, embed, applet, " +
+	"base, meta, link, title, head, form, input, button, textarea, select, option, " +
+	"svg, math, canvas, audio, video, track, dialog"
+
+// allowedElements are kept, with the global and their own allowed
+// attributes. Other elements are unwrapped.
+var allowedElements = map[string][]string{
+	"a": {"href", "name"}, "abbr": nil, "address": nil, "article": nil, "aside": nil,
+	"b": nil, "bdi": nil, "bdo": nil, "blockquote": {"cite"}, "br": nil,
+	"caption": nil, "center": nil, "cite": nil, "code": nil, "col": {"span"}, "colgroup": {"span"},
+	"dd": nil, "del": {"cite", "datetime"}, "details": {"open"}, "dfn": nil, "div": nil, "dl": nil, "dt": nil,
+	"em": nil, "figcaption": nil, "figure": nil, "footer": nil,
+	"h1": nil, "h2": nil, "h3": nil, "h4": nil, "h5": nil, "h6": nil, "header": nil, "hr": nil,
+	"i": nil, "img": {"src", "srcset", "sizes", "alt", "width", "height"}, "ins": {"cite", "datetime"},
+	"kbd": nil, "li": {"value"}, "main": nil, "mark": nil,
+	"ol": {"start", "reversed", "type"}, "p": nil, "picture": nil, "pre": nil, "q": {"cite"},
+	"rp": nil, "rt": nil, "ruby": nil, "s": nil, "samp": nil, "section": nil, "small": nil,
+	"source": {"srcset", "sizes", "media", "type"}, "span": nil, "strike": nil, "strong": nil,
+	"sub": nil, "summary": nil, "sup": nil,
+	"table": nil, "tbody": nil, "td": {"colspan", "rowspan", "headers"}, "tfoot": nil,
+	"th": {"colspan", "rowspan", "headers", "scope"}, "thead": nil, "time": {"datetime"}, "tr": nil,
+	"tt": nil, "u": nil, "ul": nil, "var": nil, "wbr": nil,
+}
+
+// globalAttrs are allowed on every kept element.
+var globalAttrs = []string{"id", "class", "title", "lang", "dir"}
+
+// SanitizeHTML returns the content with only the allowed markup.
+func SanitizeHTML(contentHTML string) (string, error) {
+	clean := &Clean{ContentHTML: contentHTML}
+	if err := editContent(clean, sanitizeContent); err != nil {
+		return "", err
+	}
+	return clean.ContentHTML, nil
+}
+
+func sanitizeContent(content *goquery.Selection) {
+	content.Find(droppedElements).Remove()
+
+	var unwrap []*goquery.Selection
+	content.Find("*").Each(func(_ int, s *goquery.Selection) {
+		node := s.Get(0)
+		allowed, ok := allowedElements[node.Data]
+		if !ok {
+			unwrap = append(unwrap, s)
+			return
+		}
+		attrs := node.Attr[:0]
+		for _, attr := range node.Attr {
+			key := strings.ToLower(attr.Key)
+			if attr.Namespace != "" || !(contains(globalAttrs, key) || contains(allowed, key)) {
+				continue
+			}
+			switch key {
+			case "href", "src", "cite":
+				if !safeURL(attr.Val, node.Data == "img" && key == "src") {
+					continue
+				}
+			case "srcset":
+				if !safeSrcset(attr.Val) {
+					continue
+				}
+			}
+			attr.Key = key
+			attrs = append(attrs, attr)
+		}
+		node.Attr = attrs
+	})
+	// Innermost first, an element's children are in place by the time
+	// it's replaced by them.
+	for i := len(unwrap) - 1; i >= 0; i-- {
+		s := unwrap[i]
+		if children := s.Contents(); children.Length() > 0 {
+			s.ReplaceWithSelection(children)
+		} else {
+			s.Remove()
+		}
+	}
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// safeURL tells whether a link or image URL is http(s), mail, a relative URL
+// or, for images, an inline image.
+func safeURL(raw string, image bool) bool {
+	raw = strings.Join(strings.Fields(raw), "")
+	if image && strings.HasPrefix(strings.ToLower(raw), "data:image/") {
+		return true
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		return false
+	}
+	switch strings.ToLower(u.Scheme) {
+	case "", "http", "https", "mailto":
+		return true
+	}
+	return false
+}
+
+func safeSrcset(srcset string) bool {
+	for _, candidate := range strings.Split(srcset, ",") {
+		if fields := strings.Fields(candidate); len(fields) > 0 && !safeURL(fields[0], true) {
+			return false
+		}
+	}
+	return true
+}
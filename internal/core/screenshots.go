@@ -0,0 +1,151 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	db "github.com/egemengol/kindlepathy/internal/db/generated"
+)
+
+// Pages that no extractor can read, canvas or WebGL apps and hostile
+// markup, are kept as a full-page screenshot so the content isn't lost. The
+// screenshot is taken by a headless browser service speaking the
+// browserless /screenshot API.
+
+// maxScreenshotSize caps the stored image, long pages make huge PNGs.
+const maxScreenshotSize = 20 << 20
+
+type Screenshotter struct {
+	// Endpoint is the URL screenshots are requested from, with any token.
+	Endpoint string
+	Client   *http.Client
+}
+
+// Capture takes a full-page screenshot of the page, returning the image and
+// its content type.
+func (s *Screenshotter) Capture(ctx context.Context, pageURL string) ([]byte, string, error) {
+	body, err := json.Marshal(map[string]any{
+		"url":     pageURL,
+		"options": map[string]any{"fullPage": true, "type": "png"},
+		"gotoOptions": map[string]any{
+			"waitUntil": "networkidle2",
+		},
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", s.Endpoint, bytes.NewReader(body))
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to create screenshot request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	client := s.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to reach the screenshot service: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return nil, "", fmt.Errorf("screenshot service answered %d: %s", resp.StatusCode, strings.TrimSpace(string(msg)))
+	}
+
+	image, err := io.ReadAll(io.LimitReader(resp.Body, maxScreenshotSize+1))
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to read screenshot: %w", err)
+	}
+	if len(image) > maxScreenshotSize {
+		return nil, "", fmt.Errorf("screenshot is larger than %d bytes", maxScreenshotSize)
+	}
+	// Sniffed rather than trusted, an SVG could carry scripts.
+	contentType := http.DetectContentType(image)
+	switch contentType {
+	case "image/png", "image/jpeg", "image/webp":
+		return image, contentType, nil
+	}
+	return nil, "", fmt.Errorf("screenshot service didn't return an image, got %s", contentType)
+}
+
+// SetScreenshotter enables screenshots.
+func (c *Core) SetScreenshotter(s *Screenshotter) {
+	c.screenshotter = s
+}
+
+func (c *Core) ScreenshotsConfigured() bool {
+	return c.screenshotter != nil
+}
+
+// CaptureItemScreenshot takes and stores a screenshot of the item's page,
+// which is then served in place of its content.
+func (c *Core) CaptureItemScreenshot(ctx context.Context, itemID int64, now time.Time) error {
+	if c.screenshotter == nil {
+		return fmt.Errorf("screenshots are not configured")
+	}
+	item, err := c.queries.ItemsGet(ctx, itemID)
+	if err != nil {
+		return fmt.Errorf("failed to get item: %w", err)
+	}
+	image, contentType, err := c.screenshotter.Capture(ctx, item.Url)
+	if err != nil {
+		return err
+	}
+	err = c.queries.ItemScreenshotsSet(ctx, db.ItemScreenshotsSetParams{
+		ItemID:      itemID,
+		Image:       image,
+		ContentType: contentType,
+		CapturedTs:  now.Unix(),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to store screenshot: %w", err)
+	}
+	if err := c.queries.ItemsFetchDone(ctx, itemID); err != nil {
+		return fmt.Errorf("failed to update item status: %w", err)
+	}
+	c.Logger.Info("stored screenshot", "itemID", itemID, "bytes", len(image))
+	return nil
+}
+
+type ItemScreenshot struct {
+	Image       []byte
+	ContentType string
+	Captured    time.Time
+}
+
+// GetItemScreenshot returns the screenshot of the item, nil if it has none.
+func (c *Core) GetItemScreenshot(ctx context.Context, itemID int64) (*ItemScreenshot, error) {
+	row, err := c.queries.ItemScreenshotsGet(ctx, itemID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get screenshot: %w", err)
+	}
+	return &ItemScreenshot{
+		Image:       row.Image,
+		ContentType: row.ContentType,
+		Captured:    time.Unix(row.CapturedTs, 0),
+	}, nil
+}
+
+// screenshotContent is the content of an item kept as a screenshot, tapping
+// the image opens it on its own to zoom in.
+func screenshotContent(itemID int64, title string) *Clean {
+	src := fmt.Sprintf("/library/%d/screenshot", itemID)
+	return &Clean{
+		Title: title,
+		ContentHTML: fmt.Sprintf(`<p><em>This page couldn't be read, it's kept as a screenshot. Tap it to zoom.</em></p>`+
+			`<p><a href="%s"><img src="%s" alt="Screenshot of the page" style="width: 100%%"></a></p>`, src, src),
+	}
+}
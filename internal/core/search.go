@@ -0,0 +1,102 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+	db "github.com/egemengol/kindlepathy/internal/db/generated"
+)
+
+// Items are indexed for full-text search when their content is saved: when
+// fetched, uploaded or read again, and when their note changes. Items saved
+// before the index existed are found once they're read.
+
+// maxSearchResults caps the results of a search, ranked by relevance.
+const maxSearchResults = 50
+
+type SearchResult struct {
+	Item Item
+	// Snippet is the text around the match.
+	Snippet string
+}
+
+// indexItem replaces the item's entry in the search index. It only logs
+// failures, search is a nicety next to saving the item.
+func (c *Core) indexItem(ctx context.Context, itemID int64, title, contentHTML string) {
+	text := contentHTML
+	if doc, err := goquery.NewDocumentFromReader(strings.NewReader(contentHTML)); err == nil {
+		text = doc.Text()
+	}
+	text = strings.Join(strings.Fields(text), " ")
+
+	// The note is kept in the entry, it's set apart from the content.
+	var note string
+	if item, err := c.queries.ItemsGet(ctx, itemID); err == nil {
+		note, _ = item.Note.(string)
+	}
+
+	if err := c.queries.ItemsSearchIndexDelete(ctx, itemID); err != nil {
+		c.Logger.Warn("failed to index item for search", "error", err, "itemID", itemID)
+		return
+	}
+	err := c.queries.ItemsSearchIndexAdd(ctx, db.ItemsSearchIndexAddParams{
+		ItemID:  itemID,
+		Title:   title,
+		Content: text,
+		Note:    note,
+	})
+	if err != nil {
+		c.Logger.Warn("failed to index item for search", "error", err, "itemID", itemID)
+	}
+}
+
+// SearchItems returns the user's items matching every word of the query in
+// their title or text, best matches first. The last word matches as a prefix.
+func (c *Core) SearchItems(ctx context.Context, userID int64, query string) ([]SearchResult, error) {
+	match := searchMatch(query)
+	if match == "" {
+		return nil, nil
+	}
+	rows, err := c.queries.ItemsSearch(ctx, db.ItemsSearchParams{
+		Query:  match,
+		UserID: userID,
+		Limit:  maxSearchResults,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to search items: %w", err)
+	}
+	if len(rows) == 0 {
+		return nil, nil
+	}
+
+	items, err := c.ListItems(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	byID := make(map[int64]Item, len(items))
+	for _, item := range items {
+		byID[item.ID] = item
+	}
+	results := make([]SearchResult, 0, len(rows))
+	for _, row := range rows {
+		if item, ok := byID[row.ID]; ok {
+			results = append(results, SearchResult{Item: item, Snippet: row.Snippet})
+		}
+	}
+	return results, nil
+}
+
+// searchMatch turns the words of a query into an FTS5 query, each quoted so
+// the syntax of FTS5 can't be typed by accident.
+func searchMatch(query string) string {
+	words := strings.Fields(query)
+	for i, word := range words {
+		words[i] = `"` + strings.ReplaceAll(word, `"`, `""`) + `"`
+	}
+	if len(words) > 0 {
+		words[len(words)-1] += "*"
+	}
+	return strings.Join(words, " ")
+}
@@ -0,0 +1,238 @@
+package core
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	db "github.com/egemengol/kindlepathy/internal/db/generated"
+)
+
+// Chapters saved as items of their own, like a serial imported from a
+// bookmark export, are grouped into series: numbered items under the same
+// path of the same site. A series lists its chapters in order and tells which
+// ones are missing.
+
+type Series struct {
+	// Key is the site and path the chapters share.
+	Key   string
+	Items []Item
+	Gaps  []ChapterGap
+}
+
+// ChapterGap is a run of missing chapters, URLs has those that are known.
+type ChapterGap struct {
+	From int
+	To   int
+	URLs []string
+}
+
+// seriesPattern matches a path segment that is only a labeled chapter
+// number, which another chapter's URL is made from by changing the number.
+var seriesPattern = regexp.MustCompile(`(?i)^((?:chapter|chapitre|chap|ch|episode|ep|c)[-_]?)(\d+)(\.html?)?$`)
+
+// seriesKey is the series of the chapter at the URL: its site and the path
+// above the chapter.
+func seriesKey(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return ""
+	}
+	host := strings.TrimPrefix(strings.ToLower(u.Host), "www.")
+	return host + path.Dir("/"+strings.Trim(u.Path, "/"))
+}
+
+// ListSeries returns the user's items grouped into series, the items not in
+// any are left out.
+func (c *Core) ListSeries(ctx context.Context, userID int64) ([]Series, error) {
+	items, err := c.ListItems(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	type numbered struct {
+		item   Item
+		number float64
+	}
+	groups := map[string][]numbered{}
+	for _, item := range items {
+		n, ok := parseChapterNumber(item.URL, item.Title)
+		if !ok {
+			continue
+		}
+		key := seriesKey(item.URL)
+		groups[key] = append(groups[key], numbered{item, n})
+	}
+
+	known, err := c.knownChapters(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	var series []Series
+	for key, group := range groups {
+		if len(group) < 2 {
+			continue
+		}
+		sort.SliceStable(group, func(i, j int) bool {
+			if group[i].number != group[j].number {
+				return group[i].number < group[j].number
+			}
+			return group[i].item.AddedTs.Before(group[j].item.AddedTs)
+		})
+		s := Series{Key: key}
+		chapters := make([]Chapter, len(group))
+		for i, n := range group {
+			s.Items = append(s.Items, n.item)
+			chapters[i] = Chapter{URL: n.item.URL, Number: n.number}
+		}
+		s.Gaps = seriesGaps(chapters, known[key])
+		series = append(series, s)
+	}
+	sort.Slice(series, func(i, j int) bool { return series[i].Key < series[j].Key })
+	return series, nil
+}
+
+// knownChapters returns the chapters of the user's chapter lists by series,
+// for the URLs of missing chapters.
+func (c *Core) knownChapters(ctx context.Context, userID int64) (map[string]map[int]string, error) {
+	lists, err := c.queries.ChapterListsListPerUser(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list chapter lists: %w", err)
+	}
+	known := map[string]map[int]string{}
+	for _, list := range lists {
+		var chapters []Chapter
+		if err := json.Unmarshal([]byte(list.Chapters), &chapters); err != nil {
+			continue
+		}
+		for _, ch := range chapters {
+			if ch.Number == 0 || ch.Number != float64(int(ch.Number)) {
+				continue
+			}
+			key := seriesKey(ch.URL)
+			if known[key] == nil {
+				known[key] = map[int]string{}
+			}
+			known[key][int(ch.Number)] = ch.URL
+		}
+	}
+	return known, nil
+}
+
+// seriesGaps groups the missing chapters into runs, with their URLs from a
+// chapter list or else made from the URL of a neighbouring chapter.
+func seriesGaps(chapters []Chapter, known map[int]string) []ChapterGap {
+	var gaps []ChapterGap
+	for _, n := range chapterGaps(chapters) {
+		u := known[n]
+		if u == "" {
+			u = guessChapterURL(chapters, n)
+		}
+		if last := len(gaps) - 1; last >= 0 && gaps[last].To == n-1 {
+			gaps[last].To = n
+		} else {
+			gaps = append(gaps, ChapterGap{From: n, To: n})
+		}
+		if u != "" {
+			gaps[len(gaps)-1].URLs = append(gaps[len(gaps)-1].URLs, u)
+		}
+	}
+	return gaps
+}
+
+// guessChapterURL makes the URL of chapter n from a chapter whose last path
+// segment is only its number, ".../chapter-12/" gives ".../chapter-13/".
+func guessChapterURL(chapters []Chapter, n int) string {
+	for _, ch := range chapters {
+		u, err := url.Parse(ch.URL)
+		if err != nil {
+			continue
+		}
+		trimmed := strings.TrimSuffix(u.Path, "/")
+		dir, last := path.Split(trimmed)
+		m := seriesPattern.FindStringSubmatch(last)
+		if m == nil || m[2] != strconv.Itoa(int(ch.Number)) {
+			continue
+		}
+		u.Path = dir + m[1] + strconv.Itoa(n) + m[3] + strings.TrimPrefix(u.Path, trimmed)
+		u.RawQuery = ""
+		u.Fragment = ""
+		return u.String()
+	}
+	return ""
+}
+
+// BackfillSeries adds the missing chapters of the series with known URLs,
+// they're fetched in the background. It returns how many were added.
+func (c *Core) BackfillSeries(ctx context.Context, userID int64, key string, now time.Time) (int, error) {
+	series, err := c.ListSeries(ctx, userID)
+	if err != nil {
+		return 0, err
+	}
+	added := 0
+	for _, s := range series {
+		if s.Key != key {
+			continue
+		}
+		for _, gap := range s.Gaps {
+			for _, u := range gap.URLs {
+				itemID, err := c.AddItem(ctx, userID, u, now)
+				if err != nil {
+					return added, fmt.Errorf("failed to add %s: %w", u, err)
+				}
+				if err := c.enqueueFetch(ctx, itemID, now); err != nil {
+					c.Logger.Warn("failed to queue item for fetching", "error", err, "itemID", itemID)
+				}
+				added++
+			}
+		}
+	}
+	return added, nil
+}
+
+// MarkPreviousChapters marks the chapters before the item in its series read,
+// or unread. Chapters already read keep when they were read. It returns how
+// many were changed.
+func (c *Core) MarkPreviousChapters(ctx context.Context, userID, itemID int64, read bool, now time.Time) (int, error) {
+	series, err := c.ListSeries(ctx, userID)
+	if err != nil {
+		return 0, err
+	}
+	for _, s := range series {
+		at := -1
+		for i, item := range s.Items {
+			if item.ID == itemID {
+				at = i
+				break
+			}
+		}
+		if at < 0 {
+			continue
+		}
+
+		changed := 0
+		for _, item := range s.Items[:at] {
+			if read == (item.ReadTs != nil) {
+				continue
+			}
+			var readTs interface{}
+			if read {
+				readTs = now.Unix()
+			}
+			if err := c.queries.ItemsSetRead(ctx, db.ItemsSetReadParams{ReadTs: readTs, ID: item.ID}); err != nil {
+				return changed, fmt.Errorf("failed to update read state: %w", err)
+			}
+			changed++
+		}
+		return changed, nil
+	}
+	return 0, fmt.Errorf("item %d is not in a series", itemID)
+}
@@ -0,0 +1,236 @@
+package core
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	db "github.com/egemengol/kindlepathy/internal/db/generated"
+)
+
+// Pages behind a login, or a free registration wall, are fetched with the
+// cookies of the user's browser session: pasted for a site, or imported from
+// a cookies.txt export. Pages fetched with a user's cookies are cached for
+// that user alone.
+
+type SiteCredential struct {
+	ID     int64
+	Domain string
+	// CookieNames are the names of the cookies, their values aren't shown.
+	CookieNames []string
+	Updated     time.Time
+}
+
+type fetchUserKey struct{}
+
+// withFetchUser makes the fetches of ctx use the user's cookies.
+func withFetchUser(ctx context.Context, userID int64) context.Context {
+	return context.WithValue(ctx, fetchUserKey{}, userID)
+}
+
+func (c *Core) ListSiteCredentials(ctx context.Context, userID int64) ([]SiteCredential, error) {
+	rows, err := c.queries.SiteCredentialsListPerUser(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list site credentials: %w", err)
+	}
+	credentials := make([]SiteCredential, len(rows))
+	for i, row := range rows {
+		credentials[i] = SiteCredential{
+			ID:      row.ID,
+			Domain:  row.Domain,
+			Updated: time.Unix(row.UpdatedTs, 0),
+		}
+		for _, cookie := range strings.Split(row.Cookies, "; ") {
+			name, _, _ := strings.Cut(cookie, "=")
+			credentials[i].CookieNames = append(credentials[i].CookieNames, name)
+		}
+	}
+	return credentials, nil
+}
+
+// SetSiteCookies stores the cookies of a site, a Cookie header like
+// "session=abc; remember=1", in place of the ones stored before.
+func (c *Core) SetSiteCookies(ctx context.Context, userID int64, domain, cookies string, now time.Time) error {
+	domain, err := cookieDomain(domain)
+	if err != nil {
+		return err
+	}
+	parsed, err := http.ParseCookie(strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(cookies), "Cookie:")))
+	if err != nil {
+		return fmt.Errorf("invalid cookies: %w", err)
+	}
+	pairs := make([]string, len(parsed))
+	for i, cookie := range parsed {
+		pairs[i] = cookie.String()
+	}
+	return c.setSiteCookies(ctx, userID, domain, pairs, now)
+}
+
+// ImportCookiesTxt stores the cookies of a cookies.txt export, the Netscape
+// format browser extensions and curl write, replacing the stored cookies of
+// the sites in it. It returns the number of sites.
+func (c *Core) ImportCookiesTxt(ctx context.Context, userID int64, r io.Reader, now time.Time) (int, error) {
+	var domains []string
+	byDomain := make(map[string][]string)
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		// HttpOnly cookies are written commented out.
+		line = strings.TrimPrefix(line, "#HttpOnly_")
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		// domain, subdomains, path, secure, expiry, name, value
+		fields := strings.Split(line, "\t")
+		if len(fields) != 7 {
+			return 0, fmt.Errorf("invalid cookies.txt line: %q", line)
+		}
+		expiry, err := strconv.ParseInt(fields[4], 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid cookie expiry: %q", fields[4])
+		}
+		if expiry != 0 && expiry < now.Unix() {
+			continue
+		}
+		domain, err := cookieDomain(fields[0])
+		if err != nil {
+			return 0, err
+		}
+		cookie := &http.Cookie{Name: fields[5], Value: fields[6]}
+		if cookie.Valid() != nil {
+			continue
+		}
+		if _, ok := byDomain[domain]; !ok {
+			domains = append(domains, domain)
+		}
+		byDomain[domain] = append(byDomain[domain], cookie.String())
+	}
+	if err := scanner.Err(); err != nil {
+		return 0, fmt.Errorf("failed to read cookies.txt: %w", err)
+	}
+	for _, domain := range domains {
+		if err := c.setSiteCookies(ctx, userID, domain, byDomain[domain], now); err != nil {
+			return 0, err
+		}
+	}
+	return len(domains), nil
+}
+
+func (c *Core) setSiteCookies(ctx context.Context, userID int64, domain string, pairs []string, now time.Time) error {
+	if len(pairs) == 0 {
+		return fmt.Errorf("no cookies for %s", domain)
+	}
+	err := c.queries.SiteCredentialsSet(ctx, db.SiteCredentialsSetParams{
+		UserID:    userID,
+		Domain:    domain,
+		Cookies:   strings.Join(pairs, "; "),
+		UpdatedTs: now.Unix(),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to store cookies: %w", err)
+	}
+	return nil
+}
+
+func (c *Core) DeleteSiteCredential(ctx context.Context, userID, credentialID int64) error {
+	err := c.queries.SiteCredentialsDelete(ctx, db.SiteCredentialsDeleteParams{
+		ID:     credentialID,
+		UserID: userID,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to delete site credential: %w", err)
+	}
+	return nil
+}
+
+// cookieDomain takes a domain, a cookie domain like ".example.com" or a URL
+// pasted from the address bar.
+func cookieDomain(raw string) (string, error) {
+	raw = strings.TrimSpace(raw)
+	if u, err := url.Parse(raw); err == nil && u.Host != "" {
+		raw = u.Hostname()
+	}
+	domain := normalizeDomain(strings.TrimPrefix(raw, "."))
+	if domain == "" || strings.ContainsAny(domain, " /:") {
+		return "", fmt.Errorf("invalid domain: %q", raw)
+	}
+	return domain, nil
+}
+
+// siteCookies returns the Cookie header of the user's credentials for the
+// page, "" without any. Cookies of a subdomain win over the parent's.
+func (c *Core) siteCookies(ctx context.Context, userID int64, pageURL string) (string, error) {
+	u, err := url.Parse(pageURL)
+	if err != nil {
+		return "", nil
+	}
+	host := normalizeDomain(u.Hostname())
+	rows, err := c.queries.SiteCredentialsListPerUser(ctx, userID)
+	if err != nil {
+		return "", fmt.Errorf("failed to list site credentials: %w", err)
+	}
+	byDomain := make(map[string]string, len(rows))
+	for _, row := range rows {
+		byDomain[row.Domain] = row.Cookies
+	}
+
+	// From the most specific domain up, the first cookie of a name wins.
+	var pairs []string
+	seen := make(map[string]bool)
+	for host != "" {
+		if cookies, ok := byDomain[host]; ok {
+			for _, pair := range strings.Split(cookies, "; ") {
+				name, _, _ := strings.Cut(pair, "=")
+				if !seen[name] {
+					seen[name] = true
+					pairs = append(pairs, pair)
+				}
+			}
+		}
+		_, parent, ok := strings.Cut(host, ".")
+		if !ok {
+			break
+		}
+		host = parent
+	}
+	return strings.Join(pairs, "; "), nil
+}
+
+// setUserCookies puts the cookies of the fetching user on a request.
+func (c *Core) setUserCookies(ctx context.Context, req *http.Request) error {
+	userID, ok := ctx.Value(fetchUserKey{}).(int64)
+	if !ok {
+		return nil
+	}
+	cookies, err := c.siteCookies(ctx, userID, req.URL.String())
+	if err != nil {
+		return err
+	}
+	if cookies != "" {
+		req.Header.Set("Cookie", cookies)
+	}
+	return nil
+}
+
+// itemCachePrefix is the cache prefix of the item's page: its user's own
+// when the user has cookies for the site, the content may be theirs alone.
+func (c *Core) itemCachePrefix(ctx context.Context, item db.Item) (string, error) {
+	cookies, err := c.siteCookies(ctx, item.UserID, item.Url)
+	if err != nil {
+		return "", err
+	}
+	if cookies != "" {
+		return userCachePrefix(item.UserID), nil
+	}
+	return "item", nil
+}
+
+func userCachePrefix(userID int64) string {
+	return fmt.Sprintf("item:%d", userID)
+}
@@ -0,0 +1,278 @@
+package core
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+	db "github.com/egemengol/kindlepathy/internal/db/generated"
+	"github.com/egemengol/kindlepathy/internal/script"
+	"go.starlark.net/starlark"
+)
+
+// Site scripts fix stubborn sites that selectors can't, a small Starlark
+// script per domain transforms the cleaned document or picks the nav links.
+// Scripts run after the domain's pipeline, sandboxed and under limits:
+//
+//	for a in page.select("a.chapter-nav"):
+//	    if "Next" in a.text():
+//	        set_next(a.attr("href"))
+//	for note in content.select(".translator-note"):
+//	    note.remove()
+//
+// Globals: url, title, page (the fetched page), content (the cleaned
+// content, editable), set_title, set_content, set_next, set_prev and
+// resolve. Elements have select, select_one, text, html, attr, set_attr and
+// remove.
+
+type SiteScript struct {
+	Domain    string
+	Source    string
+	UpdatedBy string
+	Updated   time.Time
+}
+
+func (c *Core) ListSiteScripts(ctx context.Context) ([]SiteScript, error) {
+	rows, err := c.queries.SiteScriptsList(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list site scripts: %w", err)
+	}
+	scripts := make([]SiteScript, len(rows))
+	for i, row := range rows {
+		scripts[i] = SiteScript{
+			Domain:    row.Domain,
+			Source:    row.Source,
+			UpdatedBy: row.Username,
+			Updated:   time.Unix(row.UpdatedTs, 0),
+		}
+	}
+	return scripts, nil
+}
+
+// SetSiteScript stores the script of the domain, refusing scripts that
+// don't parse.
+func (c *Core) SetSiteScript(ctx context.Context, userID int64, domain, source string, now time.Time) error {
+	domain = normalizeDomain(domain)
+	if domain == "" || strings.ContainsAny(domain, "/: ") {
+		return fmt.Errorf("invalid domain: %q", domain)
+	}
+	if _, err := script.Parse(source); err != nil {
+		return fmt.Errorf("invalid script: %w", err)
+	}
+	err := c.queries.SiteScriptsSet(ctx, db.SiteScriptsSetParams{
+		Domain:    domain,
+		Source:    source,
+		UpdatedBy: userID,
+		UpdatedTs: now.Unix(),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to store site script: %w", err)
+	}
+	return nil
+}
+
+func (c *Core) DeleteSiteScript(ctx context.Context, domain string) error {
+	return c.queries.SiteScriptsDelete(ctx, normalizeDomain(domain))
+}
+
+// siteScript returns the script of the page's domain, the most specific one
+// if its parent domains have scripts too.
+func (c *Core) siteScript(ctx context.Context, pageURL string) (*db.SiteScript, error) {
+	u, err := url.Parse(pageURL)
+	if err != nil {
+		return nil, nil
+	}
+	host := normalizeDomain(u.Hostname())
+	for host != "" {
+		s, err := c.queries.SiteScriptsGet(ctx, host)
+		if err == nil {
+			return &s, nil
+		}
+		if !errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf("failed to get site script: %w", err)
+		}
+		_, parent, ok := strings.Cut(host, ".")
+		if !ok {
+			break
+		}
+		host = parent
+	}
+	return nil, nil
+}
+
+// runSiteScript applies the script of the document's domain, if any. The
+// document is only changed if the script succeeds.
+func (c *Core) runSiteScript(ctx context.Context, doc *Document) error {
+	s, err := c.siteScript(ctx, doc.URL)
+	if err != nil || s == nil {
+		return err
+	}
+	program, err := script.Parse(s.Source)
+	if err != nil {
+		return fmt.Errorf("site script of %s: %w", s.Domain, err)
+	}
+
+	page, err := goquery.NewDocumentFromReader(strings.NewReader(doc.Raw))
+	if err != nil {
+		return fmt.Errorf("failed to parse page: %w", err)
+	}
+	content, err := goquery.NewDocumentFromReader(strings.NewReader(doc.Clean.ContentHTML))
+	if err != nil {
+		return fmt.Errorf("failed to parse content: %w", err)
+	}
+	result := *doc.Clean
+	var newContent *string
+
+	setter := func(name string, set func(v string)) *starlark.Builtin {
+		return starlark.NewBuiltin(name, func(_ *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+			var v string
+			if err := starlark.UnpackPositionalArgs(b.Name(), args, kwargs, 1, &v); err != nil {
+				return nil, err
+			}
+			set(v)
+			return starlark.None, nil
+		})
+	}
+	globals := starlark.StringDict{
+		"url":     starlark.String(doc.URL),
+		"title":   starlark.String(doc.Clean.Title),
+		"page":    &scriptElement{sel: page.Selection},
+		"content": &scriptElement{sel: content.Find("body")},
+		"set_title": setter("set_title", func(v string) {
+			result.Title = v
+		}),
+		"set_content": setter("set_content", func(v string) {
+			newContent = &v
+		}),
+		// The script's links aren't guesses.
+		"set_next": setter("set_next", func(v string) {
+			result.NavNext = resolveURL(v, doc.URL)
+			result.NavNextConfidence, result.NavNextAlternatives = 0, nil
+		}),
+		"set_prev": setter("set_prev", func(v string) {
+			result.NavPrev = resolveURL(v, doc.URL)
+			result.NavPrevConfidence, result.NavPrevAlternatives = 0, nil
+		}),
+		"resolve": starlark.NewBuiltin("resolve", func(_ *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+			var v string
+			if err := starlark.UnpackPositionalArgs(b.Name(), args, kwargs, 1, &v); err != nil {
+				return nil, err
+			}
+			return starlark.String(resolveURL(v, doc.URL)), nil
+		}),
+	}
+	if err := program.Run(ctx, globals, script.DefaultLimits); err != nil {
+		return fmt.Errorf("site script of %s: %w", s.Domain, err)
+	}
+
+	if newContent != nil {
+		result.ContentHTML = *newContent
+	} else {
+		html, err := content.Find("body").Html()
+		if err != nil {
+			return fmt.Errorf("failed to render content: %w", err)
+		}
+		result.ContentHTML = html
+	}
+	*doc.Clean = result
+	return nil
+}
+
+// scriptElement is an HTML element as scripts see it.
+type scriptElement struct {
+	sel *goquery.Selection
+}
+
+var elementMethods = []string{"attr", "html", "remove", "select", "select_one", "set_attr", "text"}
+
+func (e *scriptElement) String() string        { return "<element>" }
+func (e *scriptElement) Type() string          { return "element" }
+func (e *scriptElement) Freeze()               {}
+func (e *scriptElement) Truth() starlark.Bool  { return starlark.True }
+func (e *scriptElement) AttrNames() []string   { return elementMethods }
+func (e *scriptElement) Hash() (uint32, error) { return 0, fmt.Errorf("unhashable type: element") }
+
+func (e *scriptElement) Attr(name string) (starlark.Value, error) {
+	method := func(fn func(b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error)) *starlark.Builtin {
+		return starlark.NewBuiltin(name, func(_ *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+			return fn(b, args, kwargs)
+		}).BindReceiver(e)
+	}
+	noArgs := func(b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) error {
+		return starlark.UnpackPositionalArgs(b.Name(), args, kwargs, 0)
+	}
+
+	switch name {
+	case "select", "select_one":
+		return method(func(b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+			var selector string
+			if err := starlark.UnpackPositionalArgs(b.Name(), args, kwargs, 1, &selector); err != nil {
+				return nil, err
+			}
+			if err := validSelector(selector); err != nil {
+				return nil, err
+			}
+			found := e.sel.Find(selector)
+			if name == "select_one" {
+				if found.Length() == 0 {
+					return starlark.None, nil
+				}
+				return &scriptElement{sel: found.First()}, nil
+			}
+			elems := make([]starlark.Value, 0, found.Length())
+			found.Each(func(_ int, s *goquery.Selection) {
+				elems = append(elems, &scriptElement{sel: s})
+			})
+			return starlark.NewList(elems), nil
+		}), nil
+	case "text":
+		return method(func(b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+			if err := noArgs(b, args, kwargs); err != nil {
+				return nil, err
+			}
+			return starlark.String(strings.TrimSpace(e.sel.Text())), nil
+		}), nil
+	case "html":
+		return method(func(b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+			if err := noArgs(b, args, kwargs); err != nil {
+				return nil, err
+			}
+			html, err := e.sel.Html()
+			return starlark.String(html), err
+		}), nil
+	case "attr":
+		return method(func(b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+			var attr string
+			if err := starlark.UnpackPositionalArgs(b.Name(), args, kwargs, 1, &attr); err != nil {
+				return nil, err
+			}
+			if v, ok := e.sel.Attr(attr); ok {
+				return starlark.String(v), nil
+			}
+			return starlark.None, nil
+		}), nil
+	case "set_attr":
+		return method(func(b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+			var attr, value string
+			if err := starlark.UnpackPositionalArgs(b.Name(), args, kwargs, 2, &attr, &value); err != nil {
+				return nil, err
+			}
+			e.sel.SetAttr(attr, value)
+			return starlark.None, nil
+		}), nil
+	case "remove":
+		return method(func(b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+			if err := noArgs(b, args, kwargs); err != nil {
+				return nil, err
+			}
+			e.sel.Remove()
+			return starlark.None, nil
+		}), nil
+	}
+	return nil, nil
+}
@@ -0,0 +1,104 @@
+package core
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	badger "github.com/dgraph-io/badger/v4"
+)
+
+// Cleans stay in the cache past their TTL. A stale one is served right away
+// while it's refetched in the background, a reader is better off with a
+// page some minutes old than waiting on the site and the extractor. The TTL
+// of a domain can be set with CACHE_DOMAIN_TTLS, like
+// news.ycombinator.com=5m,royalroad.com=24h, for pages that change more or
+// less often than the defaults assume.
+
+// cacheStaleFor is how long a clean is served stale after its TTL.
+const cacheStaleFor = 7 * 24 * time.Hour
+
+// revalidateTimeout bounds a background refresh, rendering included.
+const revalidateTimeout = 2 * time.Minute
+
+// ParseCacheTTLs reads domain=duration pairs. A domain also covers its
+// subdomains.
+func ParseCacheTTLs(pairs []string) (map[string]time.Duration, error) {
+	ttls := make(map[string]time.Duration, len(pairs))
+	for _, pair := range pairs {
+		domain, value, ok := strings.Cut(pair, "=")
+		domain = normalizeDomain(domain)
+		if !ok || domain == "" {
+			return nil, fmt.Errorf("invalid cache TTL: %q, expected domain=duration", pair)
+		}
+		ttl, err := time.ParseDuration(strings.TrimSpace(value))
+		if err != nil || ttl <= 0 {
+			return nil, fmt.Errorf("invalid cache TTL of %s: %q", domain, value)
+		}
+		ttls[domain] = ttl
+	}
+	return ttls, nil
+}
+
+// SetCacheTTLs sets how long the cleans of the domains stay fresh, in place
+// of what the callers ask for.
+func (c *Core) SetCacheTTLs(ttls map[string]time.Duration) {
+	c.cacheTTLs = ttls
+}
+
+func (c *Core) cacheTTL(pageURL string, def time.Duration) time.Duration {
+	if ttl, ok := lookupDomain(c.cacheTTLs, pageURL); ok {
+		return ttl
+	}
+	return def
+}
+
+// storeCached caches the clean, fresh for ttl and stale for cacheStaleFor
+// after.
+func (c *Core) storeCached(cacheKey string, clean *Clean, ttl time.Duration) {
+	if c.cache == nil {
+		return
+	}
+	cleanBytes, err := json.Marshal(clean)
+	if err != nil {
+		c.Logger.Warn("failed to marshal clean data for caching", "error", err)
+		return
+	}
+	err = c.cache.Update(func(txn *badger.Txn) error {
+		entry := badger.NewEntry([]byte(cacheKey), cleanBytes).WithTTL(ttl + cacheStaleFor)
+		return txn.SetEntry(entry)
+	})
+	if err != nil {
+		c.Logger.Warn("failed to cache clean data", "error", err, "key", cacheKey)
+	}
+}
+
+// revalidate refetches a stale clean in the background, once at a time per
+// key, asking the site for the page only if it changed. The stale clean
+// stays when the refetch fails, and is fresh again when the page didn't
+// change.
+func (c *Core) revalidate(ctx context.Context, url, cacheKey string, ttl time.Duration, stale *Clean) {
+	if _, busy := c.revalidating.LoadOrStore(cacheKey, struct{}{}); busy {
+		return
+	}
+	// The request that found the clean stale is gone by the time the
+	// refetch ends, its values, like the user fetched as, are kept.
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), revalidateTimeout)
+	go func() {
+		defer cancel()
+		defer c.revalidating.Delete(cacheKey)
+		clean, err := c.getAndCleanSince(ctx, url, stale)
+		if err != nil {
+			c.Logger.Warn("failed to refresh stale page", "error", err, "url", url)
+			return
+		}
+		c.storeCached(cacheKey, clean, ttl)
+		if clean == stale {
+			c.Logger.Debug("stale page not modified", "url", url)
+		} else {
+			c.Logger.Debug("refreshed stale page", "url", url)
+		}
+	}()
+}
@@ -5,10 +5,16 @@ import (
 	"fmt"
 	"io"
 	"net/url"
+	"regexp"
+	"strings"
 
+	"github.com/PuerkitoBio/goquery"
 	"github.com/andybalholm/brotli"
 )
 
+// WordsPerMinute is the reading speed assumed for reading time estimates.
+const WordsPerMinute = 230
+
 // ResolveURL takes a base absolute URL (e.g. "https://example.com/foo/bar")
 // and an actual target URL (which can be absolute or relative),
 // and returns the absolute resolved form.
@@ -68,10 +74,117 @@ func DecompressHTML(compressed []byte) (string, error) {
 
 	reader := brotli.NewReader(bytes.NewReader(compressed))
 
-	decompressed, err := io.ReadAll(reader)
-	if err != nil {
+	// Built in place, reading into a byte slice and converting would hold
+	// a large chapter twice.
+	var decompressed strings.Builder
+	if _, err := io.Copy(&decompressed, reader); err != nil {
 		return "", fmt.Errorf("failed to decompress brotli content: %w", err)
 	}
 
-	return string(decompressed), nil
+	return decompressed.String(), nil
+}
+
+// CountWords returns the number of whitespace separated words in the text of
+// an HTML fragment.
+func CountWords(html string) int {
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(html))
+	if err != nil {
+		return 0
+	}
+	return len(strings.Fields(doc.Text()))
+}
+
+// EstimateReadingMinutes estimates how long an HTML fragment takes to read,
+// rounded up to whole minutes.
+func EstimateReadingMinutes(html string) int {
+	return readingMinutes(CountWords(html))
+}
+
+func readingMinutes(words int) int {
+	if words <= 0 {
+		return 0
+	}
+	return (words + WordsPerMinute - 1) / WordsPerMinute
+}
+
+var urlPattern = regexp.MustCompile(`https?://[^\s<>"']+`)
+
+// ExtractURLs returns the http(s) URLs found in free text, such as a chat
+// message, with trailing punctuation trimmed.
+func ExtractURLs(text string) []string {
+	var urls []string
+	for _, match := range urlPattern.FindAllString(text, -1) {
+		urls = append(urls, strings.TrimRight(match, ".,;:!?)]}"))
+	}
+	return urls
+}
+
+var textBlockElements = map[string]bool{
+	"p": true, "div": true, "section": true, "article": true, "blockquote": true,
+	"h1": true, "h2": true, "h3": true, "h4": true, "h5": true, "h6": true,
+	"ul": true, "ol": true, "li": true, "pre": true, "figure": true, "figcaption": true,
+	"table": true, "tr": true, "hr": true,
+}
+
+// HTMLToText renders an HTML fragment as plain text with a blank line between
+// blocks, for reading in a terminal.
+func HTMLToText(html string) string {
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(html))
+	if err != nil {
+		return ""
+	}
+
+	var b strings.Builder
+	var walk func(s *goquery.Selection)
+	walk = func(s *goquery.Selection) {
+		s.Contents().Each(func(_ int, child *goquery.Selection) {
+			name := goquery.NodeName(child)
+			switch {
+			case name == "#text":
+				// Newlines in the source are just whitespace, only <br> breaks lines.
+				b.WriteString(strings.ReplaceAll(child.Text(), "\n", " "))
+			case name == "br":
+				b.WriteString("\n")
+			case name == "script" || name == "style":
+			case textBlockElements[name]:
+				b.WriteString("\n\n")
+				if name == "li" {
+					b.WriteString("- ")
+				}
+				walk(child)
+				b.WriteString("\n\n")
+			default:
+				walk(child)
+			}
+		})
+	}
+	walk(doc.Selection)
+
+	var paragraphs []string
+	for _, block := range strings.Split(b.String(), "\n\n") {
+		var lines []string
+		for _, line := range strings.Split(block, "\n") {
+			if line = strings.Join(strings.Fields(line), " "); line != "" {
+				lines = append(lines, line)
+			}
+		}
+		if len(lines) > 0 {
+			paragraphs = append(paragraphs, strings.Join(lines, "\n"))
+		}
+	}
+	return strings.Join(paragraphs, "\n\n")
+}
+
+var slugPattern = regexp.MustCompile(`[^a-z0-9]+`)
+
+// Slugify turns a title into a string safe to use as a file name.
+func Slugify(title string) string {
+	slug := strings.Trim(slugPattern.ReplaceAllString(strings.ToLower(title), "-"), "-")
+	if len(slug) > 80 {
+		slug = strings.TrimRight(slug[:80], "-")
+	}
+	if slug == "" {
+		return "article"
+	}
+	return slug
 }
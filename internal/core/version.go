@@ -0,0 +1,142 @@
+package core
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"runtime"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Version and Commit are set at build time:
+//
+//	go build -ldflags "-X github.com/egemengol/kindlepathy/internal/core.Version=v1.2.0 -X github.com/egemengol/kindlepathy/internal/core.Commit=abc1234" ./cmd
+var (
+	Version = "dev"
+	Commit  = ""
+)
+
+// releasesURL is where the update check finds the latest release.
+const releasesURL = "https://api.github.com/repos/egemengol/kindlepathy/releases/latest"
+
+type BuildInfo struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit,omitempty"`
+	GoVersion string `json:"go_version"`
+}
+
+// GetBuildInfo returns the version of the running binary. Builds without a
+// commit set fall back to the one Go records from the checkout.
+func GetBuildInfo() BuildInfo {
+	info := BuildInfo{Version: Version, Commit: Commit, GoVersion: runtime.Version()}
+	if info.Commit == "" {
+		if build, ok := debug.ReadBuildInfo(); ok {
+			for _, setting := range build.Settings {
+				if setting.Key == "vcs.revision" && len(setting.Value) >= 7 {
+					info.Commit = setting.Value[:7]
+				}
+			}
+		}
+	}
+	return info
+}
+
+type Release struct {
+	Version   string    `json:"version"`
+	URL       string    `json:"url"`
+	CheckedAt time.Time `json:"checked_at"`
+}
+
+// CheckForUpdate asks GitHub for the latest release and remembers it.
+func (c *Core) CheckForUpdate(ctx context.Context, now time.Time) (*Release, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", releasesURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create GET request: %w", err)
+	}
+	req.Header.Set("Accept", "application/vnd.github+json")
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check for updates: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("update check answered %d", resp.StatusCode)
+	}
+
+	var latest struct {
+		TagName string `json:"tag_name"`
+		HTMLURL string `json:"html_url"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&latest); err != nil {
+		return nil, fmt.Errorf("failed to decode release: %w", err)
+	}
+	release := &Release{Version: latest.TagName, URL: latest.HTMLURL, CheckedAt: now}
+	c.latestRelease.Store(release)
+	return release, nil
+}
+
+// RunUpdateCheck checks for updates every interval until ctx is cancelled.
+func (c *Core) RunUpdateCheck(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if _, err := c.CheckForUpdate(ctx, time.Now()); err != nil && ctx.Err() == nil {
+			c.Logger.Warn("failed to check for updates", "error", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// AvailableUpdate returns the latest release when it's newer than the
+// running version, nil otherwise or when updates aren't checked.
+func (c *Core) AvailableUpdate() *Release {
+	release := c.latestRelease.Load()
+	if release == nil || !newerVersion(release.Version, Version) {
+		return nil
+	}
+	return release
+}
+
+// newerVersion tells whether the release is newer than the current version,
+// both "v1.2.3" style. Development builds aren't compared.
+func newerVersion(release, current string) bool {
+	r, ok := parseVersion(release)
+	if !ok {
+		return false
+	}
+	cur, ok := parseVersion(current)
+	if !ok {
+		return false
+	}
+	for i := range r {
+		if r[i] != cur[i] {
+			return r[i] > cur[i]
+		}
+	}
+	return false
+}
+
+func parseVersion(v string) ([3]int, bool) {
+	var parsed [3]int
+	v, _, _ = strings.Cut(strings.TrimPrefix(v, "v"), "-")
+	parts := strings.Split(v, ".")
+	if len(parts) == 0 || len(parts) > 3 {
+		return parsed, false
+	}
+	for i, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil {
+			return parsed, false
+		}
+		parsed[i] = n
+	}
+	return parsed, true
+}
@@ -3,42 +3,176 @@ package db
 import (
 	"context"
 	"database/sql"
-	_ "embed"
+	"embed"
+	"fmt"
+	"io/fs"
+	"regexp"
+	"slices"
+	"strconv"
+	"time"
 )
 
-//go:embed schema.sql
-var ddl string
+// The schema is built by the numbered migrations in migrations/, each an
+// NNNN_name.up.sql and the NNNN_name.down.sql undoing it. A change to the
+// schema is a new pair, never an edit of an applied one. The versions
+// applied are kept in schema_migrations.
 
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+var migrationName = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)\.sql$`)
+
+type Migration struct {
+	Version int
+	Name    string
+	up      string
+	down    string
+}
+
+// Migrations returns the migrations of this build by version.
+func Migrations() ([]Migration, error) {
+	entries, err := fs.ReadDir(migrationFiles, "migrations")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read migrations: %w", err)
+	}
+	byVersion := map[int]*Migration{}
+	for _, entry := range entries {
+		m := migrationName.FindStringSubmatch(entry.Name())
+		if m == nil {
+			return nil, fmt.Errorf("invalid migration file name: %s", entry.Name())
+		}
+		version, _ := strconv.Atoi(m[1])
+		data, err := fs.ReadFile(migrationFiles, "migrations/"+entry.Name())
+		if err != nil {
+			return nil, fmt.Errorf("failed to read migration: %w", err)
+		}
+		migration, ok := byVersion[version]
+		if !ok {
+			migration = &Migration{Version: version, Name: m[2]}
+			byVersion[version] = migration
+		} else if migration.Name != m[2] {
+			return nil, fmt.Errorf("migrations %s and %s share version %d", migration.Name, m[2], version)
+		}
+		if m[3] == "up" {
+			migration.up = string(data)
+		} else {
+			migration.down = string(data)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, migration := range byVersion {
+		if migration.up == "" || migration.down == "" {
+			return nil, fmt.Errorf("migration %d_%s needs both an up and a down file", migration.Version, migration.Name)
+		}
+		migrations = append(migrations, *migration)
+	}
+	slices.SortFunc(migrations, func(a, b Migration) int { return a.Version - b.Version })
+	return migrations, nil
+}
+
+func ensureMigrationsTable(ctx context.Context, sqlDB *sql.DB) error {
+	_, err := sqlDB.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (
+    version INTEGER PRIMARY KEY,
+    name TEXT NOT NULL,
+    applied_ts INTEGER NOT NULL
+)`)
+	if err != nil {
+		return fmt.Errorf("failed to create schema_migrations: %w", err)
+	}
+	return nil
+}
+
+// SchemaVersion returns the version of the last migration applied, zero for
+// an empty database.
+func SchemaVersion(ctx context.Context, sqlDB *sql.DB) (int, error) {
+	if err := ensureMigrationsTable(ctx, sqlDB); err != nil {
+		return 0, err
+	}
+	var version int
+	err := sqlDB.QueryRowContext(ctx, "SELECT COALESCE(MAX(version), 0) FROM schema_migrations").Scan(&version)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read schema version: %w", err)
+	}
+	return version, nil
+}
+
+// Migrate applies the migrations the database doesn't have yet, each in a
+// transaction of its own. A database migrated by a newer build is an error,
+// its schema is unknown to this one.
 func Migrate(ctx context.Context, sqlDB *sql.DB) error {
-	if _, err := sqlDB.ExecContext(ctx, ddl); err != nil {
+	migrations, err := Migrations()
+	if err != nil {
 		return err
 	}
+	current, err := SchemaVersion(ctx, sqlDB)
+	if err != nil {
+		return err
+	}
+	if latest := migrations[len(migrations)-1].Version; current > latest {
+		return fmt.Errorf("database is at schema version %d, newer than this build's %d", current, latest)
+	}
 
-	// https://readnovelfull.com/shadow-slave/chapter-2460-on-the-count-of-three.html
-	// // Add user 1 if not exists
-	// if _, err := sqlDB.ExecContext(ctx, `
-	// 	INSERT OR IGNORE INTO users (id, username, password) VALUES (1, 'default', 'password')
-	// `); err != nil {
-	// 	return err
-	// }
-
-	// now := time.Now().Unix()
-
-	// // Add three items for user 1
-	// items := []string{
-	// 	"https://egemengol.com/blog/kindlepathy/",
-	// 	"https://readnovelfull.com/lets-manage-the-tower/prologue.html",
-	// 	"https://w21.read-onepiece.net/manga/one-piece-chapter-1022/",
-	// }
-
-	// for _, url := range items {
-	// 	if _, err := sqlDB.ExecContext(ctx, `
-	// 		INSERT OR IGNORE INTO items (user_id, url, added_ts)
-	// 		VALUES (1, ?, ?)
-	// 	`, url, now); err != nil {
-	// 		return err
-	// 	}
-	// }
+	for _, migration := range migrations {
+		if migration.Version <= current {
+			continue
+		}
+		err := inTx(ctx, sqlDB, func(tx *sql.Tx) error {
+			if _, err := tx.ExecContext(ctx, migration.up); err != nil {
+				return err
+			}
+			_, err := tx.ExecContext(ctx, "INSERT INTO schema_migrations (version, name, applied_ts) VALUES (?, ?, ?)",
+				migration.Version, migration.Name, time.Now().Unix())
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("failed to apply migration %d_%s: %w", migration.Version, migration.Name, err)
+		}
+	}
+	return nil
+}
+
+// MigrateDown undoes the migrations after version, newest first. Down to
+// zero the database is empty.
+func MigrateDown(ctx context.Context, sqlDB *sql.DB, version int) error {
+	migrations, err := Migrations()
+	if err != nil {
+		return err
+	}
+	current, err := SchemaVersion(ctx, sqlDB)
+	if err != nil {
+		return err
+	}
+	if latest := migrations[len(migrations)-1].Version; current > latest {
+		return fmt.Errorf("database is at schema version %d, newer than this build's %d", current, latest)
+	}
 
+	for _, migration := range slices.Backward(migrations) {
+		if migration.Version <= version || migration.Version > current {
+			continue
+		}
+		err := inTx(ctx, sqlDB, func(tx *sql.Tx) error {
+			if _, err := tx.ExecContext(ctx, migration.down); err != nil {
+				return err
+			}
+			_, err := tx.ExecContext(ctx, "DELETE FROM schema_migrations WHERE version = ?", migration.Version)
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("failed to undo migration %d_%s: %w", migration.Version, migration.Name, err)
+		}
+	}
 	return nil
 }
+
+func inTx(ctx context.Context, sqlDB *sql.DB, f func(tx *sql.Tx) error) error {
+	tx, err := sqlDB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if err := f(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
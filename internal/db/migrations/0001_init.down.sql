@@ -0,0 +1,3 @@
+DROP TRIGGER IF EXISTS update_active_item_on_delete;
+DROP TABLE IF EXISTS items;
+DROP TABLE IF EXISTS users;
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS item_sources;
+DROP TABLE IF EXISTS integrations;
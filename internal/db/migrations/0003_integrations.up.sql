@@ -0,0 +1,23 @@
+-- The feed readers a user syncs entries from, one of each kind. The items
+-- they brought remember their entry, to be marked read back in the reader.
+CREATE TABLE IF NOT EXISTS integrations (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER NOT NULL,
+    kind TEXT NOT NULL,
+    base_url TEXT NOT NULL,
+    token TEXT NOT NULL,
+    fetch_filter TEXT NOT NULL DEFAULT 'starred',
+    mark_read BOOLEAN NOT NULL DEFAULT 0,
+    last_sync_ts INTEGER NULL,
+    UNIQUE(user_id, kind),
+    FOREIGN KEY(user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS item_sources (
+    item_id INTEGER PRIMARY KEY,
+    integration_id INTEGER NOT NULL,
+    external_id TEXT NOT NULL,
+    synced_read BOOLEAN NOT NULL DEFAULT 0,
+    FOREIGN KEY(item_id) REFERENCES items(id) ON DELETE CASCADE,
+    FOREIGN KEY(integration_id) REFERENCES integrations(id) ON DELETE CASCADE
+);
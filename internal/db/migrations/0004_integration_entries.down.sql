@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS integration_entries;
+ALTER TABLE integrations DROP COLUMN username;
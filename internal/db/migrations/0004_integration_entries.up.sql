@@ -0,0 +1,10 @@
+-- Fever and Google Reader log in with a username. The entries seen of an
+-- integration are remembered, for an item deleted not to come back.
+ALTER TABLE integrations ADD COLUMN username TEXT NOT NULL DEFAULT '';
+
+CREATE TABLE IF NOT EXISTS integration_entries (
+    integration_id INTEGER NOT NULL,
+    external_id TEXT NOT NULL,
+    PRIMARY KEY(integration_id, external_id),
+    FOREIGN KEY(integration_id) REFERENCES integrations(id) ON DELETE CASCADE
+);
@@ -0,0 +1,17 @@
+-- The chats of bots linked to a user, and the short lived codes a chat is
+-- linked with.
+CREATE TABLE IF NOT EXISTS chat_links (
+    platform TEXT NOT NULL,
+    chat_id TEXT NOT NULL,
+    user_id INTEGER NOT NULL,
+    linked_ts INTEGER NOT NULL,
+    PRIMARY KEY(platform, chat_id),
+    FOREIGN KEY(user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS link_codes (
+    code TEXT PRIMARY KEY,
+    user_id INTEGER NOT NULL,
+    expires_ts INTEGER NOT NULL,
+    FOREIGN KEY(user_id) REFERENCES users(id) ON DELETE CASCADE
+);
@@ -0,0 +1,2 @@
+-- library or read; the page a user opens on "/" and after logging in.
+ALTER TABLE users ADD COLUMN landing_page TEXT NOT NULL DEFAULT 'library';
@@ -0,0 +1,10 @@
+-- Sessions are kept on the server, for them to expire when idle and to be
+-- revoked.
+CREATE TABLE IF NOT EXISTS sessions (
+    id TEXT PRIMARY KEY,
+    user_id INTEGER NOT NULL,
+    user_agent TEXT NOT NULL,
+    created_ts INTEGER NOT NULL,
+    last_seen_ts INTEGER NOT NULL,
+    FOREIGN KEY(user_id) REFERENCES users(id) ON DELETE CASCADE
+);
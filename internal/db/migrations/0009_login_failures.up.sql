@@ -0,0 +1,7 @@
+-- Failed logins in a row per account, locked for a while after too many.
+CREATE TABLE IF NOT EXISTS login_failures (
+    user_id INTEGER PRIMARY KEY,
+    failures INTEGER NOT NULL,
+    locked_until_ts INTEGER NULL,
+    FOREIGN KEY(user_id) REFERENCES users(id) ON DELETE CASCADE
+);
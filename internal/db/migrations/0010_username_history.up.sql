@@ -0,0 +1,10 @@
+-- The renames of each account, an old username isn't taken by someone else
+-- for a while.
+CREATE TABLE IF NOT EXISTS username_history (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER NOT NULL,
+    old_username TEXT NOT NULL,
+    new_username TEXT NOT NULL,
+    changed_ts INTEGER NOT NULL,
+    FOREIGN KEY(user_id) REFERENCES users(id) ON DELETE CASCADE
+);
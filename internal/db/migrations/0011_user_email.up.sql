@@ -0,0 +1,12 @@
+-- An optional address for the account, verified by a link mailed to it.
+-- Only the hash of the link's token is kept.
+ALTER TABLE users ADD COLUMN email TEXT NULL;
+ALTER TABLE users ADD COLUMN email_verified_ts INTEGER NULL;
+
+CREATE TABLE IF NOT EXISTS email_verifications (
+    token_hash TEXT PRIMARY KEY,
+    user_id INTEGER NOT NULL,
+    email TEXT NOT NULL,
+    expires_ts INTEGER NOT NULL,
+    FOREIGN KEY(user_id) REFERENCES users(id) ON DELETE CASCADE
+);
@@ -0,0 +1,19 @@
+DROP TABLE IF EXISTS audit_log;
+
+-- A column with a foreign key can't be dropped, the table is rebuilt
+-- without it. Impersonation sessions go with it.
+CREATE TABLE sessions_new (
+    id TEXT PRIMARY KEY,
+    user_id INTEGER NOT NULL,
+    user_agent TEXT NOT NULL,
+    created_ts INTEGER NOT NULL,
+    last_seen_ts INTEGER NOT NULL,
+    FOREIGN KEY(user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+INSERT INTO sessions_new (id, user_id, user_agent, created_ts, last_seen_ts)
+SELECT id, user_id, user_agent, created_ts, last_seen_ts FROM sessions
+WHERE impersonator_id IS NULL;
+DROP TABLE sessions;
+ALTER TABLE sessions_new RENAME TO sessions;
+
+ALTER TABLE users DROP COLUMN is_admin;
@@ -0,0 +1,16 @@
+-- Admins can act as another user, the session says who is behind it. What
+-- they do to accounts is written to the audit log.
+ALTER TABLE users ADD COLUMN is_admin BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE sessions ADD COLUMN impersonator_id INTEGER NULL REFERENCES users(id) ON DELETE CASCADE;
+
+CREATE TABLE IF NOT EXISTS audit_log (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    actor_id INTEGER NOT NULL,
+    user_id INTEGER NOT NULL,
+    action TEXT NOT NULL,
+    detail TEXT NOT NULL,
+    ip TEXT NOT NULL,
+    ts INTEGER NOT NULL,
+    FOREIGN KEY(actor_id) REFERENCES users(id) ON DELETE CASCADE,
+    FOREIGN KEY(user_id) REFERENCES users(id) ON DELETE CASCADE
+);
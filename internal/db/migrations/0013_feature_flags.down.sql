@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS user_feature_flags;
+DROP TABLE IF EXISTS feature_flags;
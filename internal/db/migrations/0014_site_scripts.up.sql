@@ -0,0 +1,9 @@
+-- Scripts admins write for the pages of a domain, run on them after the
+-- fetch.
+CREATE TABLE IF NOT EXISTS site_scripts (
+    domain TEXT PRIMARY KEY,
+    source TEXT NOT NULL,
+    updated_by INTEGER NOT NULL,
+    updated_ts INTEGER NOT NULL,
+    FOREIGN KEY(updated_by) REFERENCES users(id) ON DELETE CASCADE
+);
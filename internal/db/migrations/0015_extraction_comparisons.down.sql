@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS extraction_comparisons;
+DROP TABLE IF EXISTS domain_extractors;
@@ -0,0 +1,18 @@
+-- The extractor picked for each domain, and the results of running them
+-- all side by side it was picked from.
+CREATE TABLE IF NOT EXISTS domain_extractors (
+    domain TEXT PRIMARY KEY,
+    extractor TEXT NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS extraction_comparisons (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    url TEXT NOT NULL,
+    extractor TEXT NOT NULL,
+    words INTEGER NOT NULL,
+    similarity REAL NOT NULL,
+    duration_ms INTEGER NOT NULL,
+    error TEXT NOT NULL,
+    picked BOOLEAN NOT NULL,
+    created_ts INTEGER NOT NULL
+);
@@ -0,0 +1,4 @@
+ALTER TABLE items DROP COLUMN next_fetch_ts;
+ALTER TABLE items DROP COLUMN fetch_attempts;
+ALTER TABLE items DROP COLUMN fetch_error;
+ALTER TABLE items DROP COLUMN status;
@@ -0,0 +1,7 @@
+-- pending, fetching, ready or failed; items are fetched in the background
+-- once next_fetch_ts passes. Items saved before were fetched on the spot,
+-- they are ready.
+ALTER TABLE items ADD COLUMN status TEXT NOT NULL DEFAULT 'ready';
+ALTER TABLE items ADD COLUMN fetch_error TEXT NULL;
+ALTER TABLE items ADD COLUMN fetch_attempts INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE items ADD COLUMN next_fetch_ts INTEGER NULL;
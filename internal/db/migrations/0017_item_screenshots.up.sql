@@ -0,0 +1,9 @@
+-- Screenshots of pages no extractor could read, served in place of the
+-- content.
+CREATE TABLE IF NOT EXISTS item_screenshots (
+    item_id INTEGER PRIMARY KEY,
+    image BLOB NOT NULL,
+    content_type TEXT NOT NULL,
+    captured_ts INTEGER NOT NULL,
+    FOREIGN KEY(item_id) REFERENCES items(id) ON DELETE CASCADE
+);
@@ -0,0 +1,11 @@
+-- The chapters of serial items, found on their table of contents.
+-- chapters is a JSON list of {url, title}, chapter_minutes the reading time
+-- of the last chapter read.
+CREATE TABLE IF NOT EXISTS chapter_lists (
+    item_id INTEGER PRIMARY KEY,
+    toc_url TEXT NOT NULL,
+    chapters TEXT NOT NULL,
+    chapter_minutes INTEGER NOT NULL DEFAULT 0,
+    fetched_ts INTEGER NOT NULL,
+    FOREIGN KEY(item_id) REFERENCES items(id) ON DELETE CASCADE
+);
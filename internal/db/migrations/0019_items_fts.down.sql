@@ -0,0 +1,2 @@
+DROP TRIGGER IF EXISTS items_fts_delete;
+DROP TABLE IF EXISTS items_fts;
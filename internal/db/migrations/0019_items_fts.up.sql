@@ -0,0 +1,14 @@
+-- Full-text index of item titles and extracted text, the rowid is the item
+-- id. FTS5 is built into go-sqlite3 with the sqlite_fts5 build tag.
+CREATE VIRTUAL TABLE IF NOT EXISTS items_fts USING fts5(
+    title,
+    content,
+    tokenize = 'porter unicode61 remove_diacritics 2'
+);
+
+CREATE TRIGGER IF NOT EXISTS items_fts_delete
+AFTER DELETE ON items
+FOR EACH ROW
+BEGIN
+    DELETE FROM items_fts WHERE rowid = OLD.id;
+END;
@@ -0,0 +1,10 @@
+-- Where the reader was in an item: the paragraph of the part of the page at
+-- url. It's forgotten once the item moves on to another page.
+CREATE TABLE IF NOT EXISTS item_progress (
+    item_id INTEGER PRIMARY KEY,
+    url TEXT NOT NULL,
+    part INTEGER NOT NULL,
+    paragraph INTEGER NOT NULL,
+    updated_ts INTEGER NOT NULL,
+    FOREIGN KEY(item_id) REFERENCES items(id) ON DELETE CASCADE
+);
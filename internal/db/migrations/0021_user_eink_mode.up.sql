@@ -0,0 +1,3 @@
+-- off, grayscale or dither; how the image proxy turns the images of the
+-- reader for e-ink screens.
+ALTER TABLE users ADD COLUMN eink_mode TEXT NOT NULL DEFAULT 'off';
@@ -0,0 +1,12 @@
+-- The pages an item moved through, like the chapters of a serial, for going
+-- back to one. items.url is still the page being read.
+CREATE TABLE IF NOT EXISTS chapters (
+    id INTEGER PRIMARY KEY,
+    item_id INTEGER NOT NULL,
+    url TEXT NOT NULL,
+    title TEXT NOT NULL DEFAULT '',
+    first_visited_ts INTEGER NOT NULL,
+    visited_ts INTEGER NOT NULL,
+    UNIQUE(item_id, url),
+    FOREIGN KEY(item_id) REFERENCES items(id) ON DELETE CASCADE
+);
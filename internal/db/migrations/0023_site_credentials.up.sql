@@ -0,0 +1,11 @@
+-- Cookies a user's pages of a site are fetched with, for sites behind a
+-- login. cookies is a Cookie header, the domain covers its subdomains.
+CREATE TABLE IF NOT EXISTS site_credentials (
+    id INTEGER PRIMARY KEY,
+    user_id INTEGER NOT NULL,
+    domain TEXT NOT NULL,
+    cookies TEXT NOT NULL,
+    updated_ts INTEGER NOT NULL,
+    UNIQUE(user_id, domain),
+    FOREIGN KEY(user_id) REFERENCES users(id) ON DELETE CASCADE
+);
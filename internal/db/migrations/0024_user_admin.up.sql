@@ -0,0 +1,5 @@
+-- Accounts disabled by an admin can't log in, their sessions and tokens stop
+-- working. must_change_password keeps a user on the account page until they
+-- set a new password, after an admin forced a reset.
+ALTER TABLE users ADD COLUMN disabled_ts INTEGER NULL;
+ALTER TABLE users ADD COLUMN must_change_password BOOLEAN NOT NULL DEFAULT FALSE;
@@ -0,0 +1,4 @@
+-- Where an item stands for its reader: unread, archived or favorite. Apart
+-- from read_ts, which only says when it was last opened, and from status,
+-- which is the state of its fetch.
+ALTER TABLE items ADD COLUMN state TEXT NOT NULL DEFAULT 'unread';
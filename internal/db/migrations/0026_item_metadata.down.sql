@@ -0,0 +1,4 @@
+ALTER TABLE items DROP COLUMN word_count;
+ALTER TABLE items DROP COLUMN published_ts;
+ALTER TABLE items DROP COLUMN site_name;
+ALTER TABLE items DROP COLUMN author;
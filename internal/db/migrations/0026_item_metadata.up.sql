@@ -0,0 +1,7 @@
+-- What the extractor found about a fetched page, for the library to show
+-- without the content at hand. The reading time is estimated from
+-- word_count.
+ALTER TABLE items ADD COLUMN author TEXT NULL;
+ALTER TABLE items ADD COLUMN site_name TEXT NULL;
+ALTER TABLE items ADD COLUMN published_ts INTEGER NULL;
+ALTER TABLE items ADD COLUMN word_count INTEGER NULL;
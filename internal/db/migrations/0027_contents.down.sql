@@ -0,0 +1,5 @@
+DROP TRIGGER IF EXISTS delete_content_on_item_update;
+DROP TRIGGER IF EXISTS delete_content_on_item_delete;
+DROP INDEX IF EXISTS idx_items_content_hash;
+ALTER TABLE items DROP COLUMN content_hash;
+DROP TABLE contents;
@@ -0,0 +1,33 @@
+-- Uploaded content is stored once however many users save the same page,
+-- keyed by the hash of its URL and HTML, and the items refer to it. Items
+-- uploaded before keep their uploaded_html_brotli.
+CREATE TABLE contents (
+    hash TEXT PRIMARY KEY, -- hex SHA-256 of the URL and the sanitized HTML
+    url TEXT NOT NULL,
+    html_brotli BLOB NOT NULL,
+    created_ts INTEGER NOT NULL
+);
+
+ALTER TABLE items ADD COLUMN content_hash TEXT NULL;
+CREATE INDEX idx_items_content_hash ON items(content_hash);
+
+-- Content goes with the last item referring to it.
+CREATE TRIGGER IF NOT EXISTS delete_content_on_item_delete
+AFTER DELETE ON items
+FOR EACH ROW
+WHEN OLD.content_hash IS NOT NULL
+BEGIN
+    DELETE FROM contents
+    WHERE hash = OLD.content_hash
+    AND NOT EXISTS (SELECT 1 FROM items WHERE content_hash = OLD.content_hash);
+END;
+
+CREATE TRIGGER IF NOT EXISTS delete_content_on_item_update
+AFTER UPDATE OF content_hash ON items
+FOR EACH ROW
+WHEN OLD.content_hash IS NOT NULL AND OLD.content_hash IS NOT NEW.content_hash
+BEGIN
+    DELETE FROM contents
+    WHERE hash = OLD.content_hash
+    AND NOT EXISTS (SELECT 1 FROM items WHERE content_hash = OLD.content_hash);
+END;
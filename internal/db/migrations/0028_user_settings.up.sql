@@ -0,0 +1,12 @@
+-- Reading preferences, rendered into the CSS of the reader. Users without
+-- a row read with the defaults.
+CREATE TABLE IF NOT EXISTS user_settings (
+    user_id INTEGER PRIMARY KEY,
+    font_family TEXT NOT NULL DEFAULT 'bookerly',
+    font_size INTEGER NOT NULL DEFAULT 120, -- percent of the root font size
+    line_height INTEGER NOT NULL DEFAULT 150, -- percent of the font size
+    margin TEXT NOT NULL DEFAULT 'normal',
+    justify BOOLEAN NOT NULL DEFAULT FALSE,
+    theme TEXT NOT NULL DEFAULT 'light',
+    FOREIGN KEY(user_id) REFERENCES users(id) ON DELETE CASCADE
+);
@@ -0,0 +1,17 @@
+-- Passages readers keep. A highlight is found again in the content by its
+-- quote and the text just before it, which survive refetches that shift
+-- offsets around.
+CREATE TABLE IF NOT EXISTS highlights (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER NOT NULL,
+    item_id INTEGER NOT NULL,
+    quote TEXT NOT NULL,
+    prefix TEXT NOT NULL DEFAULT '',
+    note TEXT NULL,
+    created_ts INTEGER NOT NULL,
+    FOREIGN KEY(user_id) REFERENCES users(id) ON DELETE CASCADE,
+    FOREIGN KEY(item_id) REFERENCES items(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_highlights_item ON highlights(item_id);
+CREATE INDEX IF NOT EXISTS idx_highlights_user ON highlights(user_id, created_ts);
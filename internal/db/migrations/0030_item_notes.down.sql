@@ -0,0 +1,20 @@
+DROP TRIGGER IF EXISTS items_fts_delete;
+
+CREATE VIRTUAL TABLE items_fts_old USING fts5(
+    title,
+    content,
+    tokenize = 'porter unicode61 remove_diacritics 2'
+);
+INSERT INTO items_fts_old (rowid, title, content)
+SELECT rowid, title, content FROM items_fts;
+DROP TABLE items_fts;
+ALTER TABLE items_fts_old RENAME TO items_fts;
+
+CREATE TRIGGER IF NOT EXISTS items_fts_delete
+AFTER DELETE ON items
+FOR EACH ROW
+BEGIN
+    DELETE FROM items_fts WHERE rowid = OLD.id;
+END;
+
+ALTER TABLE items DROP COLUMN note;
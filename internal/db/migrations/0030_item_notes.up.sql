@@ -0,0 +1,23 @@
+-- A freeform note per item, searched with its title and text. FTS5 tables
+-- can't gain columns, the index is rebuilt with one for notes.
+ALTER TABLE items ADD COLUMN note TEXT NULL;
+
+DROP TRIGGER IF EXISTS items_fts_delete;
+
+CREATE VIRTUAL TABLE items_fts_new USING fts5(
+    title,
+    content,
+    note,
+    tokenize = 'porter unicode61 remove_diacritics 2'
+);
+INSERT INTO items_fts_new (rowid, title, content)
+SELECT rowid, title, content FROM items_fts;
+DROP TABLE items_fts;
+ALTER TABLE items_fts_new RENAME TO items_fts;
+
+CREATE TRIGGER IF NOT EXISTS items_fts_delete
+AFTER DELETE ON items
+FOR EACH ROW
+BEGIN
+    DELETE FROM items_fts WHERE rowid = OLD.id;
+END;
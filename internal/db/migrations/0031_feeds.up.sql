@@ -0,0 +1,20 @@
+-- The RSS and Atom feeds a user follows, their new entries are added to the
+-- library on each sync. The entries seen of a feed are remembered, like
+-- the ones of integrations, for an item deleted not to come back.
+CREATE TABLE IF NOT EXISTS feeds (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER NOT NULL,
+    url TEXT NOT NULL,
+    title TEXT NOT NULL DEFAULT '',
+    created_ts INTEGER NOT NULL,
+    last_sync_ts INTEGER NULL,
+    UNIQUE(user_id, url),
+    FOREIGN KEY(user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS feed_entries (
+    feed_id INTEGER NOT NULL,
+    entry_id TEXT NOT NULL,
+    PRIMARY KEY(feed_id, entry_id),
+    FOREIGN KEY(feed_id) REFERENCES feeds(id) ON DELETE CASCADE
+);
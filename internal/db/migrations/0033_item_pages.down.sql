@@ -0,0 +1,24 @@
+DROP TRIGGER IF EXISTS delete_content_on_item_page_delete;
+DROP TABLE IF EXISTS item_pages;
+
+DROP TRIGGER IF EXISTS delete_content_on_item_delete;
+CREATE TRIGGER delete_content_on_item_delete
+AFTER DELETE ON items
+FOR EACH ROW
+WHEN OLD.content_hash IS NOT NULL
+BEGIN
+    DELETE FROM contents
+    WHERE hash = OLD.content_hash
+    AND NOT EXISTS (SELECT 1 FROM items WHERE content_hash = OLD.content_hash);
+END;
+
+DROP TRIGGER IF EXISTS delete_content_on_item_update;
+CREATE TRIGGER delete_content_on_item_update
+AFTER UPDATE OF content_hash ON items
+FOR EACH ROW
+WHEN OLD.content_hash IS NOT NULL AND OLD.content_hash IS NOT NEW.content_hash
+BEGIN
+    DELETE FROM contents
+    WHERE hash = OLD.content_hash
+    AND NOT EXISTS (SELECT 1 FROM items WHERE content_hash = OLD.content_hash);
+END;
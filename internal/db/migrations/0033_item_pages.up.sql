@@ -0,0 +1,50 @@
+-- Items read page by page from stored content, like the chapters of an
+-- uploaded book. Each page refers to its content like uploaded items do,
+-- and the item's URL is the page being read.
+CREATE TABLE IF NOT EXISTS item_pages (
+    item_id INTEGER NOT NULL,
+    position INTEGER NOT NULL,
+    url TEXT NOT NULL,
+    title TEXT NOT NULL DEFAULT '',
+    content_hash TEXT NOT NULL,
+    PRIMARY KEY(item_id, position),
+    UNIQUE(item_id, url),
+    FOREIGN KEY(item_id) REFERENCES items(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_item_pages_content_hash ON item_pages(content_hash);
+
+-- Content goes with the last item or page referring to it.
+DROP TRIGGER IF EXISTS delete_content_on_item_delete;
+CREATE TRIGGER delete_content_on_item_delete
+AFTER DELETE ON items
+FOR EACH ROW
+WHEN OLD.content_hash IS NOT NULL
+BEGIN
+    DELETE FROM contents
+    WHERE hash = OLD.content_hash
+    AND NOT EXISTS (SELECT 1 FROM items WHERE content_hash = OLD.content_hash)
+    AND NOT EXISTS (SELECT 1 FROM item_pages WHERE content_hash = OLD.content_hash);
+END;
+
+DROP TRIGGER IF EXISTS delete_content_on_item_update;
+CREATE TRIGGER delete_content_on_item_update
+AFTER UPDATE OF content_hash ON items
+FOR EACH ROW
+WHEN OLD.content_hash IS NOT NULL AND OLD.content_hash IS NOT NEW.content_hash
+BEGIN
+    DELETE FROM contents
+    WHERE hash = OLD.content_hash
+    AND NOT EXISTS (SELECT 1 FROM items WHERE content_hash = OLD.content_hash)
+    AND NOT EXISTS (SELECT 1 FROM item_pages WHERE content_hash = OLD.content_hash);
+END;
+
+CREATE TRIGGER IF NOT EXISTS delete_content_on_item_page_delete
+AFTER DELETE ON item_pages
+FOR EACH ROW
+BEGIN
+    DELETE FROM contents
+    WHERE hash = OLD.content_hash
+    AND NOT EXISTS (SELECT 1 FROM items WHERE content_hash = OLD.content_hash)
+    AND NOT EXISTS (SELECT 1 FROM item_pages WHERE content_hash = OLD.content_hash);
+END;
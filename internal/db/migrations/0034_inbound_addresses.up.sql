@@ -0,0 +1,9 @@
+-- The address each user gets for mail to their library, newsletters
+-- mostly. Only its local part is kept, the domain is the instance's. A
+-- user has one address at a time, a new one replaces it.
+CREATE TABLE IF NOT EXISTS inbound_addresses (
+    local_part TEXT PRIMARY KEY,
+    user_id INTEGER NOT NULL UNIQUE,
+    created_ts INTEGER NOT NULL,
+    FOREIGN KEY(user_id) REFERENCES users(id) ON DELETE CASCADE
+);
@@ -0,0 +1,92 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"runtime"
+	"time"
+)
+
+// busyTimeout is how long a connection waits for another one's write lock
+// before failing with "database is locked".
+const busyTimeout = 5 * time.Second
+
+// Open opens the SQLite database at path. It's in WAL mode, where reads
+// don't wait for writes, with foreign keys enforced. Transactions take the
+// write lock when they begin, so one that reads and then writes waits for
+// the lock instead of failing with "database is locked".
+func Open(ctx context.Context, path string) (*sql.DB, error) {
+	dsn := fmt.Sprintf("%s?_journal_mode=WAL&_foreign_keys=on&_busy_timeout=%d&_synchronous=NORMAL&_txlock=immediate",
+		path, busyTimeout.Milliseconds())
+	sqlDB, err := sql.Open("sqlite3", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database: %w", err)
+	}
+
+	conns := max(4, runtime.NumCPU())
+	if path == "" || path == ":memory:" {
+		// Every connection to these would be a database of its own.
+		conns = 1
+	}
+	sqlDB.SetMaxOpenConns(conns)
+	sqlDB.SetMaxIdleConns(conns)
+	sqlDB.SetConnMaxIdleTime(10 * time.Minute)
+
+	if err := sqlDB.PingContext(ctx); err != nil {
+		sqlDB.Close()
+		return nil, fmt.Errorf("failed to open database: %w", err)
+	}
+	return sqlDB, nil
+}
+
+// CheckIntegrity fails on a corrupt database. Rows left pointing to deleted
+// ones, from before foreign keys were enforced, are only logged.
+func CheckIntegrity(ctx context.Context, sqlDB *sql.DB, logger *slog.Logger) error {
+	rows, err := sqlDB.QueryContext(ctx, "PRAGMA quick_check")
+	if err != nil {
+		return fmt.Errorf("failed to check database: %w", err)
+	}
+	var problems []string
+	for rows.Next() {
+		var result string
+		if err := rows.Scan(&result); err != nil {
+			rows.Close()
+			return fmt.Errorf("failed to check database: %w", err)
+		}
+		if result != "ok" {
+			problems = append(problems, result)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to check database: %w", err)
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("database is corrupt, restore it from a backup: %v", problems)
+	}
+
+	rows, err = sqlDB.QueryContext(ctx, "PRAGMA foreign_key_check")
+	if err != nil {
+		return fmt.Errorf("failed to check foreign keys: %w", err)
+	}
+	defer rows.Close()
+	violations := map[string]int{}
+	for rows.Next() {
+		var table, parent string
+		var rowID sql.NullInt64
+		var fkID int64
+		if err := rows.Scan(&table, &rowID, &parent, &fkID); err != nil {
+			return fmt.Errorf("failed to check foreign keys: %w", err)
+		}
+		violations[table+" -> "+parent]++
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to check foreign keys: %w", err)
+	}
+	for tables, count := range violations {
+		logger.Warn("Rows pointing to deleted rows", "tables", tables, "count", count)
+	}
+	return nil
+}
@@ -14,9 +14,8 @@ SELECT * FROM users WHERE username = ?;
 
 -- name: UsersOwnsItem :one
 SELECT EXISTS(
-    SELECT 1 FROM users u
-    JOIN items i ON u.id = i.user_id
-    WHERE u.username = ? AND i.id = ?
+    SELECT 1 FROM items i
+    WHERE i.user_id = ? AND i.id = ?
 );
 
 -- name: UsersSetActiveItem :exec
@@ -24,6 +23,36 @@ UPDATE users
 SET active_item_id = ?
 WHERE id = ?;
 
+-- name: UsersSetLandingPage :exec
+UPDATE users
+SET landing_page = ?
+WHERE id = ?;
+
+-- name: UsersSetEinkMode :exec
+UPDATE users
+SET eink_mode = ?
+WHERE id = ?;
+
+-----------------------------
+
+-- name: UserSettingsGet :one
+SELECT * FROM user_settings
+WHERE user_id = ?;
+
+-- name: UserSettingsSet :exec
+INSERT INTO user_settings (
+  user_id, font_family, font_size, line_height, margin, justify, theme
+) VALUES (
+  ?, ?, ?, ?, ?, ?, ?
+)
+ON CONFLICT(user_id) DO UPDATE SET
+  font_family = excluded.font_family,
+  font_size = excluded.font_size,
+  line_height = excluded.line_height,
+  margin = excluded.margin,
+  justify = excluded.justify,
+  theme = excluded.theme;
+
 -----------------------------
 
 -- name: ItemsListPerUser :many
@@ -54,6 +83,26 @@ SET read_ts = ?
 WHERE id = ?
 RETURNING url;
 
+-- name: ItemsSetRead :exec
+UPDATE items
+SET read_ts = ?
+WHERE id = ?;
+
+-- name: ItemsSetState :execrows
+UPDATE items
+SET state = ?
+WHERE id = ? AND user_id = ?;
+
+-- name: ItemsSetNote :execrows
+UPDATE items
+SET note = ?
+WHERE id = ? AND user_id = ?;
+
+-- name: ItemsSetMetadata :exec
+UPDATE items
+SET author = ?, site_name = ?, published_ts = ?, word_count = ?
+WHERE id = ?;
+
 -- name: ItemsUpdateTitle :one
 UPDATE items
 SET title = ?
@@ -65,13 +114,717 @@ UPDATE items
 SET url = ?
 WHERE id = ?;
 
+-- name: ItemsEnqueueFetch :exec
+UPDATE items
+SET status = 'pending', fetch_error = NULL, fetch_attempts = 0, next_fetch_ts = ?
+WHERE id = ?;
+
+-- name: ItemsClaimFetch :one
+UPDATE items
+SET status = 'fetching'
+WHERE id = (
+    SELECT id FROM items
+    WHERE status IN ('pending', 'failed') AND next_fetch_ts <= ?
+    ORDER BY next_fetch_ts
+    LIMIT 1
+)
+RETURNING *;
+
+-- name: ItemsFetchDone :exec
+UPDATE items
+SET status = 'ready', fetch_error = NULL, next_fetch_ts = NULL
+WHERE id = ?;
+
+-- name: ItemsFetchFailed :exec
+UPDATE items
+SET status = 'failed', fetch_error = ?, fetch_attempts = fetch_attempts + 1, next_fetch_ts = ?
+WHERE id = ?;
+
+-- name: ItemsResetFetching :exec
+UPDATE items
+SET status = 'pending'
+WHERE status = 'fetching';
+
 -- name: ItemsAddWithUploadedContent :one
 INSERT INTO items (
-  user_id, title, url, added_ts, uploaded_html_brotli
+  user_id, title, url, added_ts, content_hash
 ) VALUES (
   ?, ?, ?, ?, ?
 )
 ON CONFLICT(user_id, url) DO UPDATE SET
+  title = excluded.title,
+  content_hash = excluded.content_hash,
+  uploaded_html_brotli = NULL,
+  status = 'ready',
+  fetch_error = NULL,
+  fetch_attempts = 0,
+  next_fetch_ts = NULL
+RETURNING id;
+
+-- name: ContentsAdd :exec
+INSERT INTO contents (hash, url, html_brotli, created_ts)
+VALUES (?, ?, ?, ?)
+ON CONFLICT(hash) DO NOTHING;
+
+-- name: ContentsGet :one
+SELECT html_brotli FROM contents
+WHERE hash = ?;
+
+-- name: ItemsFindByUrl :one
+SELECT id FROM items
+WHERE user_id = ? AND url = ?;
+
+-----------------------------
+
+-- name: TagsUpsert :one
+INSERT INTO tags (user_id, name) VALUES (?, ?)
+ON CONFLICT(user_id, name) DO UPDATE SET name = excluded.name
+RETURNING id;
+
+-- name: ItemTagsAdd :exec
+INSERT OR IGNORE INTO item_tags (item_id, tag_id) VALUES (?, ?);
+
+-- name: ItemTagsListPerUser :many
+SELECT it.item_id, t.name FROM item_tags it
+JOIN tags t ON t.id = it.tag_id
+WHERE t.user_id = ?
+ORDER BY t.name;
+
+-- name: ItemTagsDelete :exec
+DELETE FROM item_tags
+WHERE item_id = sqlc.arg(item_id) AND tag_id IN (
+    SELECT id FROM tags WHERE user_id = sqlc.arg(user_id) AND name = sqlc.arg(name)
+);
+
+-----------------------------
+
+-- name: IntegrationsUpsert :exec
+INSERT INTO integrations (
+  user_id, kind, base_url, username, token, fetch_filter, mark_read
+) VALUES (
+  ?, ?, ?, ?, ?, ?, ?
+)
+ON CONFLICT(user_id, kind) DO UPDATE SET
+  base_url = excluded.base_url,
+  username = excluded.username,
+  token = excluded.token,
+  fetch_filter = excluded.fetch_filter,
+  mark_read = excluded.mark_read;
+
+-- name: IntegrationsGet :one
+SELECT * FROM integrations
+WHERE user_id = ? AND kind = ? LIMIT 1;
+
+-- name: IntegrationsList :many
+SELECT * FROM integrations;
+
+-- name: IntegrationsDelete :exec
+DELETE FROM integrations
+WHERE user_id = ? AND kind = ?;
+
+-- name: IntegrationsSetSynced :exec
+UPDATE integrations
+SET last_sync_ts = ?
+WHERE id = ?;
+
+-- name: IntegrationEntriesSeen :one
+SELECT EXISTS(
+    SELECT 1 FROM integration_entries
+    WHERE integration_id = ? AND external_id = ?
+);
+
+-- name: IntegrationEntriesAdd :exec
+INSERT OR IGNORE INTO integration_entries (
+  integration_id, external_id
+) VALUES (
+  ?, ?
+);
+
+-- name: ItemSourcesAdd :exec
+INSERT OR IGNORE INTO item_sources (
+  item_id, integration_id, external_id
+) VALUES (
+  ?, ?, ?
+);
+
+-- name: ItemSourcesListReadUnsynced :many
+SELECT s.item_id, s.external_id FROM item_sources s
+JOIN items i ON i.id = s.item_id
+WHERE s.integration_id = ? AND s.synced_read = 0 AND i.read_ts IS NOT NULL;
+
+-- name: ItemSourcesSetSyncedRead :exec
+UPDATE item_sources
+SET synced_read = 1
+WHERE item_id = ?;
+
+-----------------------------
+
+-- name: LinkCodesAdd :exec
+INSERT INTO link_codes (code, user_id, expires_ts) VALUES (?, ?, ?);
+
+-- name: LinkCodesConsume :one
+DELETE FROM link_codes
+WHERE code = ? AND expires_ts > ?
+RETURNING user_id;
+
+-- name: ChatLinksUpsert :exec
+INSERT INTO chat_links (
+  platform, chat_id, user_id, linked_ts
+) VALUES (
+  ?, ?, ?, ?
+)
+ON CONFLICT(platform, chat_id) DO UPDATE SET
   user_id = excluded.user_id,
-  uploaded_html_brotli = excluded.uploaded_html_brotli
+  linked_ts = excluded.linked_ts;
+
+-- name: ChatLinksGetUser :one
+SELECT user_id FROM chat_links
+WHERE platform = ? AND chat_id = ?;
+
+-- name: ChatLinksListPerUser :many
+SELECT * FROM chat_links
+WHERE user_id = ?
+ORDER BY linked_ts DESC;
+
+-- name: ChatLinksDelete :exec
+DELETE FROM chat_links
+WHERE platform = ? AND chat_id = ? AND user_id = ?;
+
+-----------------------------
+
+-- name: ApiTokensAdd :exec
+INSERT INTO api_tokens (user_id, name, token_hash, created_ts) VALUES (?, ?, ?, ?);
+
+-- name: ApiTokensListPerUser :many
+SELECT * FROM api_tokens
+WHERE user_id = ?
+ORDER BY created_ts DESC;
+
+-- name: ApiTokensDelete :exec
+DELETE FROM api_tokens
+WHERE id = ? AND user_id = ?;
+
+-- name: ApiTokensDeleteByName :exec
+DELETE FROM api_tokens
+WHERE user_id = ? AND name = ?;
+
+-- name: ApiTokensSetUsed :exec
+UPDATE api_tokens
+SET last_used_ts = ?
+WHERE token_hash = ?;
+
+-- name: UsersGetByApiToken :one
+SELECT u.* FROM users u
+JOIN api_tokens t ON t.user_id = u.id
+WHERE t.token_hash = ?;
+
+-- name: SessionsAdd :exec
+INSERT INTO sessions (id, user_id, user_agent, created_ts, last_seen_ts, impersonator_id) VALUES (?, ?, ?, ?, ?, ?);
+
+-- name: SessionsGet :one
+SELECT * FROM sessions
+WHERE id = ?;
+
+-- name: SessionsListPerUser :many
+SELECT * FROM sessions
+WHERE user_id = ?
+ORDER BY last_seen_ts DESC;
+
+-- name: SessionsTouch :exec
+UPDATE sessions
+SET last_seen_ts = ?
+WHERE id = ?;
+
+-- name: SessionsDelete :exec
+DELETE FROM sessions
+WHERE id = ?;
+
+-- name: SessionsDeletePerUser :exec
+DELETE FROM sessions
+WHERE user_id = ?;
+
+-- name: SessionsDeleteOthersPerUser :exec
+DELETE FROM sessions
+WHERE user_id = ? AND id != ?;
+
+-- name: SessionsTrimPerUser :exec
+DELETE FROM sessions
+WHERE user_id = ? AND id NOT IN (
+    SELECT s.id FROM sessions s
+    WHERE s.user_id = ?
+    ORDER BY s.last_seen_ts DESC, s.rowid DESC
+    LIMIT ?
+);
+
+-- name: SessionsDeleteStale :exec
+DELETE FROM sessions
+WHERE created_ts < ? OR last_seen_ts < ?;
+
+-- name: UsersGetBySession :one
+SELECT u.* FROM users u
+JOIN sessions s ON s.user_id = u.id
+WHERE s.id = ? AND u.disabled_ts IS NULL;
+
+-- name: LoginFailuresGet :one
+SELECT * FROM login_failures
+WHERE user_id = ?;
+
+-- name: LoginFailuresAdd :one
+INSERT INTO login_failures (user_id, failures) VALUES (?, 1)
+ON CONFLICT(user_id) DO UPDATE SET failures = failures + 1
+RETURNING failures;
+
+-- name: LoginFailuresLock :exec
+UPDATE login_failures
+SET failures = 0, locked_until_ts = ?
+WHERE user_id = ?;
+
+-- name: LoginFailuresClear :exec
+DELETE FROM login_failures
+WHERE user_id = ?;
+
+-- name: UsersSetPassword :exec
+UPDATE users
+SET password = ?
+WHERE id = ?;
+
+-- name: UsersGet :one
+SELECT * FROM users WHERE id = ?;
+
+-- name: UsersDelete :exec
+DELETE FROM users WHERE id = ?;
+
+-- name: UsersSetUsername :exec
+UPDATE users
+SET username = ?
+WHERE id = ?;
+
+-- name: UsernameHistoryAdd :exec
+INSERT INTO username_history (user_id, old_username, new_username, changed_ts) VALUES (?, ?, ?, ?);
+
+-- name: UsernameHistoryListPerUser :many
+SELECT * FROM username_history
+WHERE user_id = ?
+ORDER BY changed_ts DESC;
+
+-- name: UsersSetEmail :exec
+UPDATE users
+SET email = ?, email_verified_ts = ?
+WHERE id = ?;
+
+-- name: EmailVerificationsAdd :exec
+INSERT INTO email_verifications (token_hash, user_id, email, expires_ts) VALUES (?, ?, ?, ?);
+
+-- name: EmailVerificationsGet :one
+SELECT * FROM email_verifications
+WHERE token_hash = ?;
+
+-- name: EmailVerificationsGetPerUser :one
+SELECT * FROM email_verifications
+WHERE user_id = ? AND expires_ts > ?
+ORDER BY expires_ts DESC
+LIMIT 1;
+
+-- name: EmailVerificationsDeletePerUser :exec
+DELETE FROM email_verifications
+WHERE user_id = ?;
+
+-- name: UsersSetAdminByName :exec
+UPDATE users
+SET is_admin = TRUE
+WHERE username = ?;
+
+-- name: UsersListWithUsage :many
+SELECT u.id, u.username, u.created_at, u.is_admin, u.disabled_ts, u.must_change_password,
+    (SELECT COUNT(*) FROM items i WHERE i.user_id = u.id) AS item_count,
+    (SELECT CAST(COALESCE(SUM(LENGTH(COALESCE(c.html_brotli, i.uploaded_html_brotli))), 0) AS INTEGER) FROM items i LEFT JOIN contents c ON c.hash = i.content_hash WHERE i.user_id = u.id) AS uploaded_bytes,
+    (SELECT CAST(COALESCE(SUM(LENGTH(sc.image)), 0) AS INTEGER) FROM item_screenshots sc JOIN items i ON i.id = sc.item_id WHERE i.user_id = u.id) AS screenshot_bytes
+FROM users u
+ORDER BY u.username;
+
+-- name: UsersSetDisabled :exec
+UPDATE users
+SET disabled_ts = ?
+WHERE id = ?;
+
+-- name: UsersSetMustChangePassword :exec
+UPDATE users
+SET must_change_password = ?
+WHERE id = ?;
+
+-- name: AuditLogAdd :exec
+INSERT INTO audit_log (actor_id, user_id, action, detail, ip, ts) VALUES (?, ?, ?, ?, ?, ?);
+
+-- name: AuditLogList :many
+SELECT a.*, actor.username AS actor_username, u.username AS username FROM audit_log a
+JOIN users actor ON actor.id = a.actor_id
+JOIN users u ON u.id = a.user_id
+ORDER BY a.ts DESC, a.id DESC
+LIMIT ?;
+
+-- name: FeatureFlagsList :many
+SELECT * FROM feature_flags;
+
+-- name: FeatureFlagsSet :exec
+INSERT INTO feature_flags (name, enabled) VALUES (?, ?)
+ON CONFLICT(name) DO UPDATE SET enabled = excluded.enabled;
+
+-- name: UserFeatureFlagsListPerUser :many
+SELECT * FROM user_feature_flags
+WHERE user_id = ?;
+
+-- name: UserFeatureFlagsList :many
+SELECT f.*, u.username FROM user_feature_flags f
+JOIN users u ON u.id = f.user_id
+ORDER BY u.username, f.name;
+
+-- name: UserFeatureFlagsSet :exec
+INSERT INTO user_feature_flags (user_id, name, enabled) VALUES (?, ?, ?)
+ON CONFLICT(user_id, name) DO UPDATE SET enabled = excluded.enabled;
+
+-- name: UserFeatureFlagsDelete :exec
+DELETE FROM user_feature_flags
+WHERE user_id = ? AND name = ?;
+
+-- name: SiteScriptsList :many
+SELECT s.*, u.username FROM site_scripts s
+JOIN users u ON u.id = s.updated_by
+ORDER BY s.domain;
+
+-- name: SiteScriptsGet :one
+SELECT * FROM site_scripts
+WHERE domain = ?;
+
+-- name: SiteScriptsSet :exec
+INSERT INTO site_scripts (domain, source, updated_by, updated_ts) VALUES (?, ?, ?, ?)
+ON CONFLICT(domain) DO UPDATE SET source = excluded.source, updated_by = excluded.updated_by, updated_ts = excluded.updated_ts;
+
+-- name: SiteScriptsDelete :exec
+DELETE FROM site_scripts
+WHERE domain = ?;
+
+-- name: DomainExtractorsList :many
+SELECT * FROM domain_extractors
+ORDER BY domain;
+
+-- name: DomainExtractorsGet :one
+SELECT extractor FROM domain_extractors
+WHERE domain = ?;
+
+-- name: DomainExtractorsSet :exec
+INSERT INTO domain_extractors (domain, extractor) VALUES (?, ?)
+ON CONFLICT(domain) DO UPDATE SET extractor = excluded.extractor;
+
+-- name: DomainExtractorsDelete :exec
+DELETE FROM domain_extractors
+WHERE domain = ?;
+
+-- name: ExtractionComparisonsAdd :exec
+INSERT INTO extraction_comparisons (url, extractor, words, similarity, duration_ms, error, picked, created_ts)
+VALUES (?, ?, ?, ?, ?, ?, ?, ?);
+
+-- name: ExtractionComparisonsList :many
+SELECT * FROM extraction_comparisons
+ORDER BY created_ts DESC, id DESC
+LIMIT ?;
+
+-----------------------------
+
+-- name: ItemScreenshotsSet :exec
+INSERT INTO item_screenshots (item_id, image, content_type, captured_ts)
+VALUES (?, ?, ?, ?)
+ON CONFLICT(item_id) DO UPDATE SET
+  image = excluded.image,
+  content_type = excluded.content_type,
+  captured_ts = excluded.captured_ts;
+
+-- name: ItemScreenshotsGet :one
+SELECT * FROM item_screenshots
+WHERE item_id = ?;
+
+-- name: ItemScreenshotsExists :one
+SELECT EXISTS(
+    SELECT 1 FROM item_screenshots
+    WHERE item_id = ?
+);
+
+-- name: ItemScreenshotsDelete :exec
+DELETE FROM item_screenshots
+WHERE item_id = ?;
+
+-----------------------------
+
+-- name: ChapterListsGet :one
+SELECT * FROM chapter_lists
+WHERE item_id = ?;
+
+-- name: ChapterListsListPerUser :many
+SELECT c.* FROM chapter_lists c
+JOIN items i ON i.id = c.item_id
+WHERE i.user_id = ?;
+
+-- name: ChapterListsSet :exec
+INSERT INTO chapter_lists (item_id, toc_url, chapters, fetched_ts)
+VALUES (?, ?, ?, ?)
+ON CONFLICT(item_id) DO UPDATE SET
+  toc_url = excluded.toc_url,
+  chapters = excluded.chapters,
+  fetched_ts = excluded.fetched_ts;
+
+-- name: ChapterListsSetMinutes :exec
+UPDATE chapter_lists
+SET chapter_minutes = ?
+WHERE item_id = ?;
+
+-----------------------------
+
+-- name: ItemProgressGet :one
+SELECT * FROM item_progress
+WHERE item_id = ?;
+
+-- name: ItemProgressSet :exec
+INSERT INTO item_progress (item_id, url, part, paragraph, updated_ts)
+VALUES (?, ?, ?, ?, ?)
+ON CONFLICT(item_id) DO UPDATE SET
+  url = excluded.url,
+  part = excluded.part,
+  paragraph = excluded.paragraph,
+  updated_ts = excluded.updated_ts;
+
+-----------------------------
+
+-- name: ChaptersVisit :exec
+INSERT INTO chapters (item_id, url, title, first_visited_ts, visited_ts)
+VALUES (?, ?, ?, ?, ?)
+ON CONFLICT(item_id, url) DO UPDATE SET
+  title = CASE WHEN excluded.title = '' THEN chapters.title ELSE excluded.title END,
+  visited_ts = excluded.visited_ts;
+
+-- name: ChaptersSetTitle :exec
+UPDATE chapters
+SET title = ?
+WHERE item_id = ? AND url = ?;
+
+-- name: ChaptersList :many
+SELECT * FROM chapters
+WHERE item_id = ?
+ORDER BY first_visited_ts, id;
+
+-- name: ChaptersGet :one
+SELECT * FROM chapters
+WHERE id = ? AND item_id = ?;
+
+-----------------------------
+
+-- name: SiteCredentialsSet :exec
+INSERT INTO site_credentials (user_id, domain, cookies, updated_ts)
+VALUES (?, ?, ?, ?)
+ON CONFLICT(user_id, domain) DO UPDATE SET
+  cookies = excluded.cookies,
+  updated_ts = excluded.updated_ts;
+
+-- name: SiteCredentialsListPerUser :many
+SELECT * FROM site_credentials
+WHERE user_id = ?
+ORDER BY domain;
+
+-- name: SiteCredentialsDelete :exec
+DELETE FROM site_credentials
+WHERE id = ? AND user_id = ?;
+
+-----------------------------
+
+-- name: ItemsSearchIndexDelete :exec
+DELETE FROM items_fts
+WHERE rowid = sqlc.arg(item_id);
+
+-- name: ItemsSearchIndexAdd :exec
+INSERT INTO items_fts (rowid, title, content, note)
+VALUES (sqlc.arg(item_id), sqlc.arg(title), sqlc.arg(content), sqlc.arg(note));
+
+-- name: ItemsSearchIndexSetNote :execrows
+UPDATE items_fts
+SET note = sqlc.arg(note)
+WHERE rowid = sqlc.arg(item_id);
+
+-- name: ItemsSearch :many
+SELECT i.id, snippet(items_fts, -1, '', '', '…', 24) AS snippet
+FROM items_fts
+JOIN items i ON i.id = items_fts.rowid
+WHERE items_fts MATCH sqlc.arg(query) AND i.user_id = sqlc.arg(user_id)
+ORDER BY bm25(items_fts, 5.0, 1.0, 2.0)
+LIMIT sqlc.arg(limit);
+
+-----------------------------
+
+-- name: HighlightsAdd :one
+INSERT INTO highlights (
+  user_id, item_id, quote, prefix, note, created_ts
+) VALUES (
+  ?, ?, ?, ?, ?, ?
+)
 RETURNING id;
+
+-- name: HighlightsListPerItem :many
+SELECT * FROM highlights
+WHERE item_id = ?
+ORDER BY id;
+
+-- name: HighlightsListPerUser :many
+SELECT h.id, h.item_id, h.quote, h.note, h.created_ts, i.title, i.url
+FROM highlights h
+JOIN items i ON i.id = h.item_id
+WHERE h.user_id = ?
+ORDER BY i.added_ts DESC, h.item_id, h.id;
+
+-- name: HighlightsDelete :execrows
+DELETE FROM highlights
+WHERE id = ? AND user_id = ?;
+
+-----------------------------
+
+-- name: JobsAdd :exec
+INSERT INTO jobs (kind, payload, run_ts, created_ts, updated_ts)
+SELECT sqlc.arg(kind), sqlc.arg(payload), sqlc.arg(run_ts), sqlc.arg(created_ts), sqlc.arg(created_ts)
+WHERE NOT EXISTS (
+    SELECT 1 FROM jobs
+    WHERE kind = sqlc.arg(kind) AND payload = sqlc.arg(payload) AND status IN ('pending', 'running')
+);
+
+-- name: JobsClaim :one
+UPDATE jobs
+SET status = 'running', updated_ts = sqlc.arg(now)
+WHERE id = (
+    SELECT id FROM jobs
+    WHERE status = 'pending' AND run_ts <= sqlc.arg(now)
+    ORDER BY run_ts, id
+    LIMIT 1
+)
+RETURNING *;
+
+-- name: JobsDone :exec
+UPDATE jobs
+SET status = 'done', error = NULL, attempts = attempts + 1, updated_ts = ?
+WHERE id = ?;
+
+-- name: JobsFailed :exec
+UPDATE jobs
+SET status = ?, error = ?, attempts = attempts + 1, run_ts = ?, updated_ts = ?
+WHERE id = ?;
+
+-- name: JobsResetRunning :exec
+UPDATE jobs
+SET status = 'pending'
+WHERE status = 'running';
+
+-- name: JobsRetry :execrows
+UPDATE jobs
+SET status = 'pending', attempts = 0, run_ts = ?, updated_ts = ?
+WHERE id = ? AND status = 'failed';
+
+-- name: JobsList :many
+SELECT * FROM jobs
+ORDER BY id DESC
+LIMIT ?;
+
+-- name: JobsCount :many
+SELECT status, COUNT(*) AS count
+FROM jobs
+GROUP BY status;
+
+-- name: JobsDeleteFinished :execrows
+DELETE FROM jobs
+WHERE status IN ('done', 'failed') AND updated_ts < ?;
+
+-----------------------------
+
+-- name: ItemPagesDelete :exec
+DELETE FROM item_pages
+WHERE item_id = ?;
+
+-- name: ItemPagesAdd :exec
+INSERT INTO item_pages (
+  item_id, position, url, title, content_hash
+) VALUES (
+  ?, ?, ?, ?, ?
+);
+
+-- name: ItemPagesGet :one
+SELECT p.position, p.title, p.content_hash,
+    (SELECT prev.url FROM item_pages prev
+     WHERE prev.item_id = p.item_id AND prev.position < p.position
+     ORDER BY prev.position DESC LIMIT 1) AS prev_url,
+    (SELECT next.url FROM item_pages next
+     WHERE next.item_id = p.item_id AND next.position > p.position
+     ORDER BY next.position LIMIT 1) AS next_url
+FROM item_pages p
+WHERE p.item_id = ? AND p.url = ?;
+
+-----------------------------
+
+-- name: InboundAddressesUpsert :exec
+INSERT INTO inbound_addresses (
+  local_part, user_id, created_ts
+) VALUES (
+  ?, ?, ?
+)
+ON CONFLICT(user_id) DO UPDATE SET
+  local_part = excluded.local_part,
+  created_ts = excluded.created_ts;
+
+-- name: InboundAddressesGetPerUser :one
+SELECT local_part FROM inbound_addresses
+WHERE user_id = ?;
+
+-- name: InboundAddressesGetUser :one
+SELECT user_id FROM inbound_addresses
+WHERE local_part = ?;
+
+-----------------------------
+
+-- name: FeedsAdd :execrows
+INSERT INTO feeds (
+  user_id, url, title, created_ts
+) VALUES (
+  ?, ?, ?, ?
+)
+ON CONFLICT(user_id, url) DO NOTHING;
+
+-- name: FeedsGet :one
+SELECT * FROM feeds
+WHERE id = ?;
+
+-- name: FeedsListPerUser :many
+SELECT * FROM feeds
+WHERE user_id = ?
+ORDER BY title COLLATE NOCASE, url;
+
+-- name: FeedsCountPerUser :one
+SELECT COUNT(*) FROM feeds
+WHERE user_id = ?;
+
+-- name: FeedsList :many
+SELECT * FROM feeds;
+
+-- name: FeedsDelete :exec
+DELETE FROM feeds
+WHERE id = ? AND user_id = ?;
+
+-- name: FeedsSetSynced :exec
+UPDATE feeds SET last_sync_ts = ?
+WHERE id = ?;
+
+-- name: FeedsSetTitle :exec
+UPDATE feeds SET title = ?
+WHERE id = ? AND title = '';
+
+-- name: FeedEntriesSeen :one
+SELECT COUNT(*) FROM feed_entries
+WHERE feed_id = ? AND entry_id = ?;
+
+-- name: FeedEntriesAdd :exec
+INSERT OR IGNORE INTO feed_entries (
+  feed_id, entry_id
+) VALUES (
+  ?, ?
+);
@@ -0,0 +1,219 @@
+package matrix
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/egemengol/kindlepathy/internal/core"
+)
+
+const platform = "matrix"
+
+// syncTimeout is how long a /sync long poll may wait for new events.
+const syncTimeout = 30 * time.Second
+
+// Bot adds links posted in Matrix rooms to the library of the user the room
+// is linked to. It joins rooms it is invited to, so it works for DMs and
+// shared rooms alike.
+type Bot struct {
+	homeserver string
+	token      string
+	userID     string
+	core       *core.Core
+	logger     *slog.Logger
+	httpClient *http.Client
+	txnID      atomic.Int64
+}
+
+func NewBot(homeserver, token string, c *core.Core, logger *slog.Logger) *Bot {
+	return &Bot{
+		homeserver: strings.TrimRight(homeserver, "/"),
+		token:      token,
+		core:       c,
+		logger:     logger,
+		httpClient: &http.Client{
+			Timeout: syncTimeout + 10*time.Second,
+		},
+	}
+}
+
+type event struct {
+	Type    string `json:"type"`
+	Sender  string `json:"sender"`
+	Content struct {
+		MsgType string `json:"msgtype"`
+		Body    string `json:"body"`
+	} `json:"content"`
+}
+
+type syncResponse struct {
+	NextBatch string `json:"next_batch"`
+	Rooms     struct {
+		Join map[string]struct {
+			Timeline struct {
+				Events []event `json:"events"`
+			} `json:"timeline"`
+		} `json:"join"`
+		Invite map[string]json.RawMessage `json:"invite"`
+	} `json:"rooms"`
+}
+
+// Run syncs with the homeserver until ctx is cancelled. Messages sent while
+// the bot was offline are skipped.
+func (b *Bot) Run(ctx context.Context) {
+	var whoami struct {
+		UserID string `json:"user_id"`
+	}
+	if err := b.request(ctx, "GET", "/account/whoami", nil, &whoami); err != nil {
+		b.logger.Error("matrix whoami failed", "error", err)
+		return
+	}
+	b.userID = whoami.UserID
+	b.logger.Info("Matrix bot started", "userID", b.userID)
+
+	since := ""
+	for {
+		resp, err := b.sync(ctx, since)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			b.logger.Warn("matrix sync failed", "error", err)
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(5 * time.Second):
+			}
+			continue
+		}
+
+		for roomID := range resp.Rooms.Invite {
+			if err := b.request(ctx, "POST", "/join/"+url.PathEscape(roomID), struct{}{}, nil); err != nil {
+				b.logger.Warn("matrix join failed", "error", err, "roomID", roomID)
+			}
+		}
+		// The first sync only establishes where to start from.
+		if since != "" {
+			for roomID, room := range resp.Rooms.Join {
+				for _, ev := range room.Timeline.Events {
+					if ev.Type != "m.room.message" || ev.Sender == b.userID || ev.Content.MsgType != "m.text" {
+						continue
+					}
+					b.handleMessage(ctx, roomID, ev.Content.Body)
+				}
+			}
+		}
+		since = resp.NextBatch
+	}
+}
+
+func (b *Bot) handleMessage(ctx context.Context, roomID, text string) {
+	text = strings.TrimSpace(text)
+
+	if command, arg, ok := strings.Cut(text, " "); ok && command == "!link" {
+		if _, err := b.core.LinkChat(ctx, platform, roomID, strings.TrimSpace(arg), time.Now()); err != nil {
+			b.reply(ctx, roomID, "Could not link this room: "+err.Error())
+			return
+		}
+		b.reply(ctx, roomID, "Linked! Links posted here will be added to your library.")
+		return
+	}
+	if strings.HasPrefix(text, "!") {
+		b.reply(ctx, roomID, "Post a link to add it to your library. To link this room, generate a code on the Integrations page and send !link <code>.")
+		return
+	}
+
+	summaries, err := b.core.AddItemFromChat(ctx, platform, roomID, text, time.Now())
+	if errors.Is(err, core.ErrChatNotLinked) {
+		// Shared rooms see plenty of chatter, only nag when there is a link.
+		if len(core.ExtractURLs(text)) > 0 {
+			b.reply(ctx, roomID, "This room is not linked yet. Generate a code on the Integrations page and send !link <code>.")
+		}
+		return
+	}
+	for _, summary := range summaries {
+		reply := summary.Title
+		if summary.ReadingMinutes > 0 {
+			reply += fmt.Sprintf(" (%d min read)", summary.ReadingMinutes)
+		}
+		b.reply(ctx, roomID, "Added: "+reply)
+	}
+	if err != nil {
+		b.logger.Warn("failed to add item from matrix", "error", err, "roomID", roomID)
+		b.reply(ctx, roomID, "Failed to add: "+err.Error())
+	}
+}
+
+func (b *Bot) sync(ctx context.Context, since string) (*syncResponse, error) {
+	query := url.Values{}
+	if since == "" {
+		query.Set("filter", `{"room":{"timeline":{"limit":0}}}`)
+	} else {
+		query.Set("since", since)
+		query.Set("timeout", strconv.FormatInt(syncTimeout.Milliseconds(), 10))
+	}
+
+	var resp syncResponse
+	if err := b.request(ctx, "GET", "/sync?"+query.Encode(), nil, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// reply sends a notice, which other bots are expected not to respond to.
+func (b *Bot) reply(ctx context.Context, roomID, text string) {
+	body := map[string]string{
+		"msgtype": "m.notice",
+		"body":    text,
+	}
+	txnID := strconv.FormatInt(time.Now().UnixNano(), 10) + "-" + strconv.FormatInt(b.txnID.Add(1), 10)
+	path := "/rooms/" + url.PathEscape(roomID) + "/send/m.room.message/" + txnID
+	if err := b.request(ctx, "PUT", path, body, nil); err != nil {
+		b.logger.Warn("matrix send failed", "error", err, "roomID", roomID)
+	}
+}
+
+func (b *Bot) request(ctx context.Context, method, path string, body any, out any) error {
+	var reqBody io.Reader
+	if body != nil {
+		bodyBytes, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reqBody = bytes.NewReader(bodyBytes)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, b.homeserver+"/_matrix/client/v3"+path, reqBody)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+b.token)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := b.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("matrix returned status %d", resp.StatusCode)
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
@@ -0,0 +1,114 @@
+package password
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha1"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"math"
+	"os"
+)
+
+// Bloom is a bloom filter of the SHA-1 hashes of breached passwords, as
+// published by Have I Been Pwned. The filter of the full list is over a
+// gigabyte, so it is read from disk on every check instead of loaded.
+//
+// The file is the magic "KPBLOOM1", the number of hash functions k and the
+// number of bits m as big endian uint64s, then the bits.
+type Bloom struct {
+	r io.ReaderAt
+	k uint64
+	m uint64
+}
+
+var bloomMagic = []byte("KPBLOOM1")
+
+const bloomHeaderLen = 24
+
+// OpenBloom opens a filter written by BuildBloom.
+func OpenBloom(path string) (*Bloom, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	header := make([]byte, bloomHeaderLen)
+	if _, err := f.ReadAt(header, 0); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("failed to read bloom filter header: %w", err)
+	}
+	if !bytes.Equal(header[:8], bloomMagic) {
+		f.Close()
+		return nil, fmt.Errorf("%s is not a bloom filter", path)
+	}
+	return &Bloom{
+		r: f,
+		k: binary.BigEndian.Uint64(header[8:16]),
+		m: binary.BigEndian.Uint64(header[16:24]),
+	}, nil
+}
+
+// bloomIndexes derives the k bit positions of a SHA-1 hash by double hashing.
+func bloomIndexes(sum []byte, k, m uint64) []uint64 {
+	h1 := binary.BigEndian.Uint64(sum[0:8])
+	h2 := binary.BigEndian.Uint64(sum[8:16]) | 1
+	indexes := make([]uint64, k)
+	for i := range indexes {
+		indexes[i] = (h1 + uint64(i)*h2) % m
+	}
+	return indexes
+}
+
+// Contains reports whether the password is likely in the filter.
+func (b *Bloom) Contains(password string) (bool, error) {
+	sum := sha1.Sum([]byte(password))
+	buf := make([]byte, 1)
+	for _, idx := range bloomIndexes(sum[:], b.k, b.m) {
+		if _, err := b.r.ReadAt(buf, bloomHeaderLen+int64(idx/8)); err != nil {
+			return false, err
+		}
+		if buf[0]&(1<<(idx%8)) == 0 {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// BuildBloom writes a filter of n hashes with the given false positive rate.
+// The hashes are read from the "SHA1:count" lines of the Have I Been Pwned
+// download.
+func BuildBloom(hashes io.Reader, n uint64, falsePositive float64, w io.Writer) error {
+	m := uint64(math.Ceil(-float64(n) * math.Log(falsePositive) / (math.Ln2 * math.Ln2)))
+	k := uint64(math.Max(1, math.Round(float64(m)/float64(n)*math.Ln2)))
+	bits := make([]byte, (m+7)/8)
+
+	scanner := bufio.NewScanner(hashes)
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if len(line) < 40 {
+			continue
+		}
+		sum, err := hex.DecodeString(string(line[:40]))
+		if err != nil {
+			return fmt.Errorf("invalid hash line %q: %w", line, err)
+		}
+		for _, idx := range bloomIndexes(sum, k, m) {
+			bits[idx/8] |= 1 << (idx % 8)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	header := make([]byte, bloomHeaderLen)
+	copy(header, bloomMagic)
+	binary.BigEndian.PutUint64(header[8:16], k)
+	binary.BigEndian.PutUint64(header[16:24], m)
+	if _, err := w.Write(header); err != nil {
+		return err
+	}
+	_, err := w.Write(bits)
+	return err
+}
@@ -0,0 +1,157 @@
+package password
+
+import (
+	_ "embed"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Policy decides which new passwords are acceptable.
+type Policy struct {
+	MinLength int
+	// MinScore is the lowest acceptable Strength, from 0 to 4.
+	MinScore int
+	// Breached holds passwords from known data breaches, optional.
+	Breached *Bloom
+}
+
+func DefaultPolicy() Policy {
+	return Policy{
+		MinLength: 8,
+		MinScore:  2,
+	}
+}
+
+var (
+	ErrTooShort = errors.New("password is too short")
+	ErrTooWeak  = errors.New("password is too easy to guess")
+	ErrBreached = errors.New("password appears in a known data breach, choose another one")
+)
+
+// Check returns why the password is not acceptable, nil if it is. The user
+// inputs, like the username, count as easy to guess.
+func (p Policy) Check(password string, userInputs ...string) error {
+	if utf8.RuneCountInString(password) < p.MinLength {
+		return fmt.Errorf("%w, use at least %d characters", ErrTooShort, p.MinLength)
+	}
+	if Strength(password, userInputs...) < p.MinScore {
+		return ErrTooWeak
+	}
+	if p.Breached != nil {
+		breached, err := p.Breached.Contains(password)
+		if err != nil {
+			return fmt.Errorf("failed to check breached passwords: %w", err)
+		}
+		if breached {
+			return ErrBreached
+		}
+	}
+	return nil
+}
+
+//go:embed common.txt
+var commonText string
+
+// common maps the most used passwords to their rank.
+var common = func() map[string]int {
+	m := make(map[string]int)
+	for i, w := range strings.Fields(commonText) {
+		m[w] = i + 1
+	}
+	return m
+}()
+
+// Strength scores the password from 0 to 4 like zxcvbn does, from an
+// estimate of the guesses needed: 10^3, 10^6, 10^8 and 10^10 are the
+// thresholds. The estimate knows common passwords, the user inputs,
+// repeated characters and sequences, far less than zxcvbn.
+func Strength(password string, userInputs ...string) int {
+	bits := guessBits(password, userInputs)
+	thresholds := []float64{3, 6, 8, 10}
+	score := 0
+	for _, t := range thresholds {
+		if bits >= t*math.Log2(10) {
+			score++
+		}
+	}
+	return score
+}
+
+// guessBits is log2 of the estimated guesses.
+func guessBits(password string, userInputs []string) float64 {
+	lower := strings.ToLower(password)
+
+	// The longest known word in the password is guessed by its rank, the
+	// rest by brute force.
+	word, rank := "", 0
+	for w, r := range common {
+		longer := len(w) > len(word) || len(w) == len(word) && r < rank
+		if longer && strings.Contains(lower, w) {
+			word, rank = w, r
+		}
+	}
+	for _, input := range userInputs {
+		input = strings.ToLower(input)
+		if len(input) >= 3 && len(input) > len(word) && strings.Contains(lower, input) {
+			word, rank = input, 1
+		}
+	}
+	if word == "" {
+		return bruteForceBits(password)
+	}
+
+	i := strings.Index(lower, word)
+	rest := password[:i] + password[i+len(word):]
+	// one more bit for the capitalization of the word
+	return math.Log2(float64(rank)+1) + 1 + bruteForceBits(rest)
+}
+
+// bruteForceBits counts each character by the size of the character classes
+// used, except repeats and steps of a sequence (aaa, abc, 321) which count a
+// single bit.
+func bruteForceBits(s string) float64 {
+	var lower, upper, digit, symbol, other bool
+	for _, r := range s {
+		switch {
+		case r >= 'a' && r <= 'z':
+			lower = true
+		case r >= 'A' && r <= 'Z':
+			upper = true
+		case r >= '0' && r <= '9':
+			digit = true
+		case r < 128 && unicode.IsPrint(r):
+			symbol = true
+		default:
+			other = true
+		}
+	}
+	charset := 0
+	for _, class := range []struct {
+		used bool
+		size int
+	}{{lower, 26}, {upper, 26}, {digit, 10}, {symbol, 33}, {other, 100}} {
+		if class.used {
+			charset += class.size
+		}
+	}
+	if charset == 0 {
+		return 0
+	}
+
+	perChar := math.Log2(float64(charset))
+	bits := 0.0
+	prev := rune(-1)
+	for _, r := range s {
+		if prev >= 0 && (r == prev || r == prev+1 || r == prev-1) {
+			bits++
+		} else {
+			bits += perChar
+		}
+		prev = r
+	}
+	return bits
+}
@@ -0,0 +1,494 @@
+package pdf
+
+import (
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"math"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Extract reads PDFs the other way around, for the papers and reports
+// uploaded to be read: the text of the pages is put back together into
+// headings and paragraphs, by the size of the fonts and the space between
+// the lines. Running headers and page numbers are dropped, and so are the
+// images.
+
+var (
+	ErrNotPDF    = errors.New("not a PDF file")
+	ErrEncrypted = errors.New("the PDF is encrypted")
+	ErrNoText    = errors.New("no text found in the PDF, scanned pages can't be read")
+)
+
+// Document is the text of a PDF as HTML.
+type Document struct {
+	Title   string
+	Author  string
+	Created *time.Time
+	HTML    string
+	Pages   int
+}
+
+// maxPages bounds the pages read of a document.
+const maxPages = 2000
+
+// Extract reads the text of the PDF.
+func Extract(r io.ReaderAt, size int64) (*Document, error) {
+	data := make([]byte, size)
+	if _, err := r.ReadAt(data, 0); err != nil && err != io.EOF {
+		return nil, fmt.Errorf("failed to read PDF: %w", err)
+	}
+	start := strings.Index(string(data[:min(len(data), 1024)]), "%PDF-")
+	if start < 0 {
+		return nil, ErrNotPDF
+	}
+
+	f := parseFile(data[start:])
+	if f.trailer[name("Encrypt")] != nil {
+		return nil, ErrEncrypted
+	}
+	doc := &Document{}
+	info := f.dict(f.trailer[name("Info")])
+	doc.Title = textOf(f.resolve(info[name("Title")]))
+	doc.Author = textOf(f.resolve(info[name("Author")]))
+	doc.Created = pdfDate(textOf(f.resolve(info[name("CreationDate")])))
+
+	var pages [][]line
+	for _, page := range f.pages() {
+		if len(pages) == maxPages {
+			break
+		}
+		p := &pageReader{f: f, fonts: map[ref]*textFont{}}
+		p.gs = graphicsState{ctm: identity, hScale: 1}
+		resources := f.dict(page[name("Resources")])
+		for _, content := range f.contents(page) {
+			p.content(content, resources)
+		}
+		pages = append(pages, lines(p.runs))
+	}
+	doc.Pages = len(pages)
+
+	title, contentHTML := pagesHTML(pages)
+	if contentHTML == "" {
+		return nil, ErrNoText
+	}
+	if !usableTitle(doc.Title) {
+		doc.Title = title
+	}
+	doc.HTML = contentHTML
+	return doc, nil
+}
+
+// pages returns the pages in order, with the resources they inherit.
+func (f *file) pages() []dict {
+	root := f.dict(f.trailer[name("Root")])
+	if root == nil {
+		for _, v := range f.objects {
+			if d, ok := v.(dict); ok && d[name("Type")] == name("Catalog") {
+				root = d
+				break
+			}
+		}
+	}
+	var pages []dict
+	seen := map[ref]bool{}
+	var walk func(v any, resources any, depth int)
+	walk = func(v any, resources any, depth int) {
+		if r, ok := v.(ref); ok {
+			if seen[r] {
+				return
+			}
+			seen[r] = true
+		}
+		node := f.dict(v)
+		if node == nil || depth > 64 || len(pages) >= maxPages {
+			return
+		}
+		if r, ok := node[name("Resources")]; ok {
+			resources = r
+		}
+		kids, isTree := f.resolve(node[name("Kids")]).(array)
+		if !isTree {
+			page := dict{}
+			for k, v := range node {
+				page[k] = v
+			}
+			page[name("Resources")] = resources
+			pages = append(pages, page)
+			return
+		}
+		for _, kid := range kids {
+			walk(kid, resources, depth+1)
+		}
+	}
+	walk(root[name("Pages")], nil, 0)
+	return pages
+}
+
+// contents returns the decoded content streams of the page.
+func (f *file) contents(page dict) [][]byte {
+	var streams []*stream
+	switch v := f.resolve(page[name("Contents")]).(type) {
+	case *stream:
+		streams = append(streams, v)
+	case array:
+		for _, s := range v {
+			if s, ok := f.resolve(s).(*stream); ok {
+				streams = append(streams, s)
+			}
+		}
+	}
+	var contents [][]byte
+	for _, s := range streams {
+		if data, err := f.decode(s); err == nil {
+			contents = append(contents, data)
+		}
+	}
+	// A page split over several streams is one content stream.
+	if len(contents) > 1 {
+		var joined []byte
+		for _, c := range contents {
+			joined = append(joined, c...)
+			joined = append(joined, '\n')
+		}
+		return [][]byte{joined}
+	}
+	return contents
+}
+
+// textOf decodes a text string of the document, UTF-16 when it starts with
+// a byte order mark.
+func textOf(v any) string {
+	s, _ := v.(string)
+	if strings.HasPrefix(s, "\xFE\xFF") {
+		return strings.TrimSpace(utf16Text(s[2:]))
+	}
+	if utf8.ValidString(s) {
+		return strings.TrimSpace(s)
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		b.WriteString(winAnsiEncoding[s[i]])
+	}
+	return strings.TrimSpace(b.String())
+}
+
+// pdfDate parses a date like D:20240131120000+01'00'.
+func pdfDate(s string) *time.Time {
+	s = strings.TrimPrefix(s, "D:")
+	if len(s) < 8 {
+		return nil
+	}
+	digits := s
+	for i, c := range s {
+		if c < '0' || c > '9' {
+			digits = s[:i]
+			break
+		}
+	}
+	layout := "20060102150405"[:min(len(digits), 14)]
+	t, err := time.Parse(layout, digits[:len(layout)])
+	if err != nil {
+		return nil
+	}
+	return &t
+}
+
+// usableTitle tells whether the title the document was given is the one of
+// its text rather than the name of a file or left empty.
+func usableTitle(title string) bool {
+	lower := strings.ToLower(title)
+	if len(title) < 3 || strings.HasPrefix(lower, "microsoft word") || strings.HasPrefix(lower, "untitled") {
+		return false
+	}
+	for _, ext := range []string{".doc", ".docx", ".tex", ".dvi", ".pdf", ".indd", ".odt"} {
+		if strings.HasSuffix(lower, ext) {
+			return false
+		}
+	}
+	return true
+}
+
+// line is the runs of text on one baseline of a column.
+type line struct {
+	x, y, endX float64
+	size       float64
+	text       string
+}
+
+// lines joins the runs of a page into lines, in the order they were shown,
+// which is the reading order in the PDFs of most writers, columns
+// included.
+func lines(runs []run) []line {
+	var out []line
+	for _, r := range runs {
+		text := ligatures.Replace(r.text)
+		if n := len(out); n > 0 {
+			l := &out[n-1]
+			sameLine := math.Abs(r.y-l.y) < max(l.size, r.size)*0.4 && r.x > l.endX-l.size
+			if sameLine {
+				if r.x-l.endX > min(l.size, r.size)/6 && !strings.HasSuffix(l.text, " ") && !strings.HasPrefix(text, " ") {
+					l.text += " "
+				}
+				l.text += text
+				l.endX = max(l.endX, r.endX)
+				// Superscripts and footnote marks don't make a line
+				// smaller.
+				l.size = max(l.size, r.size)
+				continue
+			}
+		}
+		out = append(out, line{x: r.x, y: r.y, endX: r.endX, size: r.size, text: text})
+	}
+	for i := range out {
+		out[i].text = strings.Join(strings.Fields(out[i].text), " ")
+	}
+	return slices.DeleteFunc(out, func(l line) bool { return l.text == "" })
+}
+
+var digitRuns = regexp.MustCompile(`\d+`)
+
+// dropRunningLines drops the headers and footers repeated on the pages,
+// page numbers included: the first and last lines of the pages that are
+// the same on most of them once the numbers are left out, and no larger
+// than the body text, the title on the first page often is the header of
+// the others.
+func dropRunningLines(pages [][]line, body float64) {
+	if len(pages) < 3 {
+		return
+	}
+	key := func(l line) string {
+		return strings.ToLower(digitRuns.ReplaceAllString(l.text, "#"))
+	}
+	counts := map[string]int{}
+	for _, page := range pages {
+		seen := map[string]bool{}
+		for _, l := range edgeLines(page) {
+			if k := key(l); !seen[k] {
+				seen[k] = true
+				counts[k]++
+			}
+		}
+	}
+	// Headers changing with the chapter are found by their page numbers,
+	// counting up from a page to the next.
+	numbered := func(i int, n int) bool {
+		if i < 0 || i >= len(pages) {
+			return false
+		}
+		return slices.ContainsFunc(edgeLines(pages[i]), func(l line) bool {
+			m, ok := pageNumber(l.text)
+			return ok && m == n
+		})
+	}
+	for i, page := range pages {
+		edges := edgeLines(page)
+		pages[i] = slices.DeleteFunc(page, func(l line) bool {
+			if !slices.Contains(edges, l) || l.size > body*1.1 {
+				return false
+			}
+			if n, ok := pageNumber(l.text); ok && (numbered(i-1, n-1) || numbered(i+1, n+1)) {
+				return true
+			}
+			return counts[key(l)] >= max(3, len(pages)/2)
+		})
+	}
+}
+
+// pageNumber returns the number the line starts or ends with.
+func pageNumber(text string) (int, bool) {
+	fields := strings.Fields(text)
+	if len(fields) == 0 {
+		return 0, false
+	}
+	for _, field := range []string{fields[0], fields[len(fields)-1]} {
+		if n, err := strconv.Atoi(field); err == nil {
+			return n, true
+		}
+	}
+	return 0, false
+}
+
+// edgeLines are the top two and bottom two lines of the page.
+func edgeLines(page []line) []line {
+	sorted := slices.Clone(page)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].y > sorted[j].y })
+	if len(sorted) <= 4 {
+		return sorted
+	}
+	return append(sorted[:2:2], sorted[len(sorted)-2:]...)
+}
+
+// textBlock is a heading or a paragraph.
+type textBlock struct {
+	size    float64
+	heading bool
+	text    string
+}
+
+// pagesHTML lays the lines of the pages out as headings and paragraphs,
+// returning the first top level heading as the title.
+func pagesHTML(pages [][]line) (title, contentHTML string) {
+	// The body text is in the size most of the text is in.
+	chars := map[float64]int{}
+	for _, page := range pages {
+		for _, l := range page {
+			chars[math.Round(l.size*2)/2] += len(l.text)
+		}
+	}
+	body, most := 0.0, 0
+	for size, n := range chars {
+		if n > most || (n == most && size < body) {
+			body, most = size, n
+		}
+	}
+	if most == 0 {
+		return "", ""
+	}
+	dropRunningLines(pages, body)
+	spacing := lineSpacing(pages, body)
+
+	var blocks []*textBlock
+	var prev *line
+	for _, page := range pages {
+		right := columnRights(page)
+		for i := range page {
+			l := &page[i]
+			heading := l.size >= body*1.15 && len(l.text) < 200
+			last := (*textBlock)(nil)
+			if len(blocks) > 0 {
+				last = blocks[len(blocks)-1]
+			}
+
+			// Whether the line goes on with the block before.
+			joins := false
+			switch {
+			case last == nil || prev == nil:
+			case heading != last.heading || math.Abs(l.size-prev.size) > body*0.1:
+			case heading:
+				joins = prev.y-l.y > 0 && prev.y-l.y < l.size*1.6
+			case prev != nil && prev.y-l.y > 0 && prev.y-l.y < l.size*3:
+				// The next line of the column: a new paragraph after a gap,
+				// an indented line or a line ending short, at the end of a
+				// sentence unless it's much shorter than the column.
+				column := columnOf(prev.x, right)
+				gap := prev.y-l.y > spacing*1.4
+				// The lines of list items hang, rather than the first
+				// being indented.
+				indented := l.x > prev.x+body*0.8 && !listItem.MatchString(last.text)
+				short := (prev.endX < right[column]-body*2.5 && endsSentence(prev.text)) ||
+					prev.endX < column+(right[column]-column)*0.7
+				joins = !gap && !indented && !short && !listItem.MatchString(l.text)
+			default:
+				// The top of the next column or page goes on with the
+				// paragraph when it was cut mid-sentence.
+				joins = !endsSentence(last.text) || startsLower(l.text)
+			}
+
+			if joins {
+				last.text = joinLines(last.text, l.text)
+			} else {
+				blocks = append(blocks, &textBlock{size: l.size, heading: heading, text: l.text})
+			}
+			prev = l
+		}
+	}
+
+	// The larger headings are the higher level ones.
+	var sizes []float64
+	for _, b := range blocks {
+		if b.heading && !slices.Contains(sizes, math.Round(b.size)) {
+			sizes = append(sizes, math.Round(b.size))
+		}
+	}
+	sort.Sort(sort.Reverse(sort.Float64Slice(sizes)))
+
+	var out strings.Builder
+	for _, b := range blocks {
+		text := html.EscapeString(b.text)
+		if !b.heading {
+			fmt.Fprintf(&out, "<p>%s</p>\n", text)
+			continue
+		}
+		level := min(3, slices.Index(sizes, math.Round(b.size))+1)
+		if title == "" && level == 1 {
+			title = b.text
+		}
+		fmt.Fprintf(&out, "<h%d>%s</h%d>\n", level, text, level)
+	}
+	return title, out.String()
+}
+
+// lineSpacing returns the usual distance between the lines of body text.
+func lineSpacing(pages [][]line, body float64) float64 {
+	var gaps []float64
+	for _, page := range pages {
+		for i := 1; i < len(page); i++ {
+			gap := page[i-1].y - page[i].y
+			if math.Abs(page[i].size-body) < body*0.1 && gap > 0 && gap < body*3 {
+				gaps = append(gaps, gap)
+			}
+		}
+	}
+	if len(gaps) == 0 {
+		return body * 1.2
+	}
+	slices.Sort(gaps)
+	return gaps[len(gaps)/2]
+}
+
+// columnRights returns the right edges of the columns of the page, by the
+// left edge of the column, where the lines of a paragraph end.
+func columnRights(page []line) map[float64]float64 {
+	rights := map[float64]float64{}
+	for _, l := range page {
+		column := columnOf(l.x, rights)
+		rights[column] = max(rights[column], l.endX)
+	}
+	return rights
+}
+
+// columnOf returns the left edge of the column the line starting at x is
+// in, near enough.
+func columnOf(x float64, rights map[float64]float64) float64 {
+	for column := range rights {
+		if math.Abs(column-x) < 30 {
+			return column
+		}
+	}
+	return math.Round(x)
+}
+
+// listItem matches the text of a list item, starting with a bullet or a
+// number.
+var listItem = regexp.MustCompile(`^([•◦▪‣∙*–-]|\(?(\d{1,2}|[a-z])[.)]) `)
+
+func endsSentence(text string) bool {
+	r, _ := utf8.DecodeLastRuneInString(text)
+	return strings.ContainsRune(".!?:;\"”’)", r)
+}
+
+func startsLower(text string) bool {
+	r, _ := utf8.DecodeRuneInString(text)
+	return unicode.IsLower(r)
+}
+
+// joinLines joins the next line to the text, putting back together the
+// words hyphenated at the end of the line.
+func joinLines(text, next string) string {
+	if strings.HasSuffix(text, "-") && startsLower(next) {
+		before, _ := utf8.DecodeLastRuneInString(text[:len(text)-1])
+		if unicode.IsLetter(before) {
+			return text[:len(text)-1] + next
+		}
+	}
+	return text + " " + next
+}
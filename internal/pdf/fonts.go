@@ -0,0 +1,161 @@
+package pdf
+
+import "unicode/utf8"
+
+// Only the standard 14 fonts are used, which every PDF reader ships with, so
+// nothing has to be embedded. Their widths are in 1/1000 em for the
+// printable ASCII range, from the Adobe font metrics.
+
+var timesRomanWidths = [95]int{
+	250, 333, 408, 500, 500, 833, 778, 180, 333, 333, 500, 564, 250, 333, 250, 278,
+	500, 500, 500, 500, 500, 500, 500, 500, 500, 500, 278, 278, 564, 564, 564, 444,
+	921, 722, 667, 667, 722, 611, 556, 722, 722, 333, 389, 722, 611, 889, 722, 722,
+	556, 722, 667, 556, 611, 722, 722, 944, 722, 722, 611, 333, 278, 333, 469, 500,
+	333, 444, 500, 444, 500, 444, 333, 500, 500, 278, 278, 500, 278, 778, 500, 500,
+	500, 500, 333, 389, 278, 500, 500, 722, 500, 500, 444, 480, 200, 480, 541,
+}
+
+var timesBoldWidths = [95]int{
+	250, 333, 555, 500, 500, 1000, 833, 278, 333, 333, 500, 570, 250, 333, 250, 278,
+	500, 500, 500, 500, 500, 500, 500, 500, 500, 500, 333, 333, 570, 570, 570, 500,
+	930, 722, 667, 722, 722, 667, 611, 778, 778, 389, 500, 778, 667, 944, 722, 778,
+	611, 778, 722, 556, 667, 722, 722, 1000, 722, 722, 667, 333, 278, 333, 581, 500,
+	333, 500, 556, 444, 556, 444, 333, 500, 556, 278, 333, 556, 278, 833, 556, 500,
+	556, 556, 444, 389, 333, 556, 500, 722, 500, 500, 444, 394, 220, 394, 520,
+}
+
+var helveticaWidths = [95]int{
+	278, 278, 355, 556, 556, 889, 667, 191, 333, 333, 389, 584, 278, 333, 278, 278,
+	556, 556, 556, 556, 556, 556, 556, 556, 556, 556, 278, 278, 584, 584, 584, 556,
+	1015, 667, 667, 722, 722, 667, 611, 778, 722, 278, 500, 667, 556, 833, 722, 778,
+	667, 778, 722, 667, 611, 722, 667, 944, 667, 667, 611, 278, 278, 278, 469, 556,
+	333, 556, 556, 500, 556, 556, 278, 556, 556, 222, 222, 500, 222, 833, 556, 556,
+	556, 556, 333, 500, 278, 556, 500, 722, 500, 500, 500, 334, 260, 334, 584,
+}
+
+var helveticaBoldWidths = [95]int{
+	278, 333, 474, 556, 556, 889, 722, 238, 333, 333, 389, 584, 278, 333, 278, 278,
+	556, 556, 556, 556, 556, 556, 556, 556, 556, 556, 333, 333, 584, 584, 584, 611,
+	975, 722, 722, 722, 722, 667, 611, 778, 722, 278, 556, 722, 611, 833, 722, 778,
+	667, 778, 722, 667, 611, 722, 667, 944, 667, 667, 611, 333, 278, 333, 584, 556,
+	333, 556, 611, 556, 611, 556, 333, 611, 611, 278, 278, 556, 278, 889, 611, 611,
+	611, 611, 389, 556, 333, 611, 556, 778, 556, 556, 500, 389, 280, 389, 584,
+}
+
+// latinBase maps the accented letters of Latin-1 (0xC0-0xFF) to a similar
+// looking ASCII letter, which is close enough for measuring.
+const latinBase = "AAAAAAACEEEEIIIIDNOOOOOxOUUUUYPsaaaaaaaceeeeiiiidnooooo/ouuuuypy"
+
+// winAnsiSpecials are the characters outside Latin-1 that WinAnsiEncoding
+// has a code for, common in articles.
+var winAnsiSpecials = map[rune]byte{
+	'€': 0x80, '‚': 0x82, '„': 0x84, '…': 0x85, '†': 0x86, '‡': 0x87, '‰': 0x89,
+	'‹': 0x8B, '‘': 0x91, '’': 0x92, '“': 0x93, '”': 0x94, '•': 0x95, '–': 0x96,
+	'—': 0x97, '™': 0x99, '›': 0x9B,
+}
+
+var specialWidths = map[byte]int{
+	0x80: 500, 0x82: 333, 0x84: 444, 0x85: 1000, 0x86: 500, 0x87: 500, 0x89: 1000,
+	0x8B: 333, 0x91: 333, 0x92: 333, 0x93: 444, 0x94: 444, 0x95: 350, 0x96: 500,
+	0x97: 1000, 0x99: 980, 0x9B: 333,
+}
+
+type font struct {
+	name   string // resource name in the page, e.g. F1
+	base   string // e.g. Times-Roman
+	widths *[95]int
+}
+
+type style struct {
+	bold   bool
+	italic bool
+	mono   bool
+}
+
+type family struct {
+	regular, bold, italic, boldItalic *font
+}
+
+var families = map[string]family{
+	"serif": {
+		regular:    &font{name: "F1", base: "Times-Roman", widths: &timesRomanWidths},
+		bold:       &font{name: "F2", base: "Times-Bold", widths: &timesBoldWidths},
+		italic:     &font{name: "F3", base: "Times-Italic", widths: &timesRomanWidths},
+		boldItalic: &font{name: "F4", base: "Times-BoldItalic", widths: &timesBoldWidths},
+	},
+	"sans": {
+		regular:    &font{name: "F1", base: "Helvetica", widths: &helveticaWidths},
+		bold:       &font{name: "F2", base: "Helvetica-Bold", widths: &helveticaBoldWidths},
+		italic:     &font{name: "F3", base: "Helvetica-Oblique", widths: &helveticaWidths},
+		boldItalic: &font{name: "F4", base: "Helvetica-BoldOblique", widths: &helveticaBoldWidths},
+	},
+}
+
+var courier = &font{name: "F5", base: "Courier"}
+
+func (f family) font(s style) *font {
+	switch {
+	case s.mono:
+		return courier
+	case s.bold && s.italic:
+		return f.boldItalic
+	case s.bold:
+		return f.bold
+	case s.italic:
+		return f.italic
+	default:
+		return f.regular
+	}
+}
+
+func (f family) fonts() []*font {
+	return []*font{f.regular, f.bold, f.italic, f.boldItalic, courier}
+}
+
+func (f *font) charWidth(b byte) int {
+	if f.widths == nil {
+		return 600
+	}
+	switch {
+	case b >= 32 && b <= 126:
+		return f.widths[b-32]
+	case b >= 0xC0:
+		return f.widths[latinBase[b-0xC0]-32]
+	}
+	if w, ok := specialWidths[b]; ok {
+		return w
+	}
+	return f.widths['n'-32]
+}
+
+// width returns the width of WinAnsi encoded text in points.
+func (f *font) width(text []byte, size float64) float64 {
+	total := 0
+	for _, b := range text {
+		total += f.charWidth(b)
+	}
+	return float64(total) * size / 1000
+}
+
+// encode converts text to WinAnsiEncoding, which the standard fonts use.
+// Characters it can't represent become '?'.
+func encode(s string) []byte {
+	out := make([]byte, 0, len(s))
+	for len(s) > 0 {
+		r, size := utf8.DecodeRuneInString(s)
+		s = s[size:]
+		switch {
+		case r == ' ' || r == '\u00a0':
+			out = append(out, ' ')
+		case r >= 32 && r <= 126, r >= 0xA1 && r <= 0xFF:
+			out = append(out, byte(r))
+		case winAnsiSpecials[r] != 0:
+			out = append(out, winAnsiSpecials[r])
+		case r == '\u200b' || r == '\u00ad' || r < 32:
+			// zero width space, soft hyphen and control characters
+		default:
+			out = append(out, '?')
+		}
+	}
+	return out
+}
@@ -0,0 +1,130 @@
+package pdf
+
+import "strings"
+
+// The encodings of simple fonts, as the text of each code.
+var standardEncoding, winAnsiEncoding, macRomanEncoding [256]string
+
+// standardEncodingHigh are the codes above ASCII of StandardEncoding, by
+// their glyph names.
+var standardEncodingHigh = map[byte]string{
+	0xA1: "exclamdown", 0xA2: "cent", 0xA3: "sterling", 0xA4: "fraction", 0xA5: "yen",
+	0xA6: "florin", 0xA7: "section", 0xA8: "currency", 0xA9: "quotesingle",
+	0xAA: "quotedblleft", 0xAB: "guillemotleft", 0xAC: "guilsinglleft",
+	0xAD: "guilsinglright", 0xAE: "fi", 0xAF: "fl", 0xB1: "endash", 0xB2: "dagger",
+	0xB3: "daggerdbl", 0xB4: "periodcentered", 0xB6: "paragraph", 0xB7: "bullet",
+	0xB8: "quotesinglbase", 0xB9: "quotedblbase", 0xBA: "quotedblright",
+	0xBB: "guillemotright", 0xBC: "ellipsis", 0xBD: "perthousand", 0xBF: "questiondown",
+	0xC1: "grave", 0xC2: "acute", 0xC3: "circumflex", 0xC4: "tilde", 0xC5: "macron",
+	0xC6: "breve", 0xC7: "dotaccent", 0xC8: "dieresis", 0xCA: "ring", 0xCB: "cedilla",
+	0xCD: "hungarumlaut", 0xCE: "ogonek", 0xCF: "caron", 0xD0: "emdash", 0xE1: "AE",
+	0xE3: "ordfeminine", 0xE8: "Lslash", 0xE9: "Oslash", 0xEA: "OE", 0xEB: "ordmasculine",
+	0xF1: "ae", 0xF5: "dotlessi", 0xF8: "lslash", 0xF9: "oslash", 0xFA: "oe", 0xFB: "germandbls",
+}
+
+// winAnsiHigh are the codes of WinAnsiEncoding from 0x80 to 0x9F.
+const winAnsiHigh = "€\x00‚ƒ„…†‡ˆ‰Š‹Œ\x00Ž\x00\x00‘’“”•–—˜™š›œ\x00žŸ"
+
+// macRomanHigh are the codes of MacRomanEncoding from 0x80 on.
+const macRomanHigh = "ÄÅÇÉÑÖÜáàâäãåçéèêëíìîïñóòôöõúùûü†°¢£§•¶ß®©™´¨≠ÆØ∞±≤≥¥µ∂∑∏π∫ªºΩæø¿¡¬√ƒ≈∆«»…\u00A0ÀÃÕŒœ–—“”‘’÷◊ÿŸ⁄€‹›\uFB01\uFB02‡·‚„‰ÂÊÁËÈÍÎÏÌÓÔ\uF8FFÒÚÛÙıˆ˜¯˘˙˚¸˝˛ˇ"
+
+func init() {
+	for code := 32; code < 127; code++ {
+		standardEncoding[code] = string(rune(code))
+		winAnsiEncoding[code] = string(rune(code))
+		macRomanEncoding[code] = string(rune(code))
+	}
+	standardEncoding['\''] = "’"
+	standardEncoding['`'] = "‘"
+	for code, glyphName := range standardEncodingHigh {
+		standardEncoding[code] = glyphNames[glyphName]
+	}
+	for i, r := range []rune(winAnsiHigh) {
+		if r != 0 {
+			winAnsiEncoding[0x80+i] = string(r)
+		}
+	}
+	for code := 0xA0; code < 256; code++ {
+		winAnsiEncoding[code] = string(rune(code))
+	}
+	winAnsiEncoding[0xAD] = ""
+	for i, r := range []rune(macRomanHigh) {
+		macRomanEncoding[0x80+i] = string(r)
+	}
+}
+
+// glyphNames are the text of the glyph names of the Adobe glyph list that
+// aren't the name of a letter, as much of it as articles use.
+var glyphNames = map[string]string{
+	"space": " ", "nbspace": " ", "nonbreakingspace": " ", "exclam": "!", "quotedbl": "\"",
+	"numbersign": "#", "dollar": "$", "percent": "%", "ampersand": "&", "quotesingle": "'",
+	"quoteright": "’", "quoteleft": "‘", "parenleft": "(", "parenright": ")", "asterisk": "*",
+	"plus": "+", "comma": ",", "hyphen": "-", "sfthyphen": "", "period": ".", "slash": "/",
+	"zero": "0", "one": "1", "two": "2", "three": "3", "four": "4", "five": "5", "six": "6",
+	"seven": "7", "eight": "8", "nine": "9", "colon": ":", "semicolon": ";", "less": "<",
+	"equal": "=", "greater": ">", "question": "?", "at": "@", "bracketleft": "[",
+	"backslash": "\\", "bracketright": "]", "asciicircum": "^", "underscore": "_",
+	"grave": "`", "braceleft": "{", "bar": "|", "braceright": "}", "asciitilde": "~",
+	"exclamdown": "¡", "cent": "¢", "sterling": "£", "fraction": "⁄", "yen": "¥",
+	"florin": "ƒ", "section": "§", "currency": "¤", "quotedblleft": "“", "quotedblright": "”",
+	"guillemotleft": "«", "guillemotright": "»", "guilsinglleft": "‹", "guilsinglright": "›",
+	"fi": "fi", "fl": "fl", "ff": "ff", "ffi": "ffi", "ffl": "ffl", "endash": "–",
+	"emdash": "—", "dagger": "†", "daggerdbl": "‡", "periodcentered": "·", "paragraph": "¶",
+	"bullet": "•", "quotesinglbase": "‚", "quotedblbase": "„", "ellipsis": "…",
+	"perthousand": "‰", "questiondown": "¿", "acute": "´", "circumflex": "ˆ", "tilde": "˜",
+	"macron": "¯", "breve": "˘", "dotaccent": "˙", "dieresis": "¨", "ring": "˚",
+	"cedilla": "¸", "hungarumlaut": "˝", "ogonek": "˛", "caron": "ˇ", "AE": "Æ", "ae": "æ",
+	"OE": "Œ", "oe": "œ", "Oslash": "Ø", "oslash": "ø", "Lslash": "Ł", "lslash": "ł",
+	"dotlessi": "ı", "dotlessj": "ȷ", "germandbls": "ß", "ordfeminine": "ª",
+	"ordmasculine": "º", "copyright": "©", "registered": "®", "trademark": "™",
+	"degree": "°", "plusminus": "±", "multiply": "×", "divide": "÷", "minus": "−",
+	"logicalnot": "¬", "onehalf": "½", "onequarter": "¼", "threequarters": "¾",
+	"Eth": "Ð", "eth": "ð", "Thorn": "Þ", "thorn": "þ", "Euro": "€", "visiblespace": "␣",
+	"alpha": "α", "beta": "β", "gamma": "γ", "delta": "δ", "epsilon": "ε", "zeta": "ζ",
+	"eta": "η", "theta": "θ", "iota": "ι", "kappa": "κ", "lambda": "λ", "mu": "µ",
+	"nu": "ν", "xi": "ξ", "omicron": "ο", "pi": "π", "rho": "ρ", "sigma": "σ", "tau": "τ",
+	"upsilon": "υ", "phi": "φ", "chi": "χ", "psi": "ψ", "omega": "ω", "Gamma": "Γ",
+	"Delta": "Δ", "Theta": "Θ", "Lambda": "Λ", "Xi": "Ξ", "Pi": "Π", "Sigma": "Σ",
+	"Upsilon": "Υ", "Phi": "Φ", "Psi": "Ψ", "Omega": "Ω", "infinity": "∞",
+	"summation": "∑", "product": "∏", "integral": "∫", "radical": "√", "lessequal": "≤",
+	"greaterequal": "≥", "notequal": "≠", "approxequal": "≈", "element": "∈",
+	"arrowright": "→", "arrowleft": "←", "arrowboth": "↔", "partialdiff": "∂",
+	"nabla": "∇", "similar": "∼", "proportional": "∝", "circlemultiply": "⊗",
+}
+
+// accents are the accented letters of the glyph names made of a letter and
+// an accent, like eacute.
+var accents = map[string]string{
+	"acute":      "AÁEÉIÍOÓUÚYÝaáeéiíoóuúyýcćnńsśzźCĆNŃSŚZŹ",
+	"grave":      "AÀEÈIÌOÒUÙaàeèiìoòuù",
+	"circumflex": "AÂEÊIÎOÔUÛaâeêiîoôuû",
+	"dieresis":   "AÄEËIÏOÖUÜaäeëiïoöuüyÿYŸ",
+	"tilde":      "AÃNÑOÕaãnñoõ",
+	"ring":       "AÅaåUŮuů",
+	"cedilla":    "CÇcçSŞsşTŢtţ",
+	"caron":      "SŠsšZŽzžCČcčRŘrřEĚeěNŇnňDĎdďTŤtť",
+	"breve":      "GĞgğAĂaă",
+	"dotaccent":  "IİZŻzżEĖeė",
+	"ogonek":     "AĄaąEĘeę",
+}
+
+func accentedLetter(glyphName string) (string, bool) {
+	if len(glyphName) < 2 {
+		return "", false
+	}
+	letters, ok := accents[glyphName[1:]]
+	if !ok {
+		return "", false
+	}
+	runes := []rune(letters)
+	for i := 0; i+1 < len(runes); i += 2 {
+		if string(runes[i]) == glyphName[:1] {
+			return string(runes[i+1]), true
+		}
+	}
+	return "", false
+}
+
+// ligatures spells out the ligatures and other presentation forms some
+// fonts map their glyphs to.
+var ligatures = strings.NewReplacer("ﬀ", "ff", "ﬁ", "fi", "ﬂ", "fl", "ﬃ", "ffi", "ﬄ", "ffl", "ﬅ", "st", "ﬆ", "st", "\u00ad", "")
@@ -0,0 +1,537 @@
+package pdf
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/zlib"
+	"encoding/ascii85"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+)
+
+// The reading side only knows as much of the file format as it takes to get
+// at the text of the pages. The objects are found by scanning the file
+// rather than through the cross-reference table, which is often broken and
+// doesn't have to be understood that way, and the ones packed in object
+// streams are read out of them.
+
+type name string
+
+type ref struct {
+	id, gen int
+}
+
+type dict map[name]any
+
+type array []any
+
+type stream struct {
+	dict dict
+	data []byte
+}
+
+// keyword is an operator of a content stream, or obj, stream and such in a
+// file.
+type keyword string
+
+// delim is one of the delimiters of arrays and dictionaries.
+type delim string
+
+// maxStreamBytes bounds a decoded stream, against compression bombs.
+const maxStreamBytes = 64 << 20
+
+var errUnsupportedFilter = errors.New("unsupported filter")
+
+type lexer struct {
+	data []byte
+	pos  int
+	// refs makes "1 0 R" a reference, content streams have none.
+	refs bool
+}
+
+func isSpace(b byte) bool {
+	return b == ' ' || b == '\n' || b == '\r' || b == '\t' || b == '\f' || b == 0
+}
+
+func isDelim(b byte) bool {
+	return bytes.IndexByte([]byte("()<>[]{}/%"), b) >= 0
+}
+
+func (l *lexer) skipSpace() {
+	for l.pos < len(l.data) {
+		switch b := l.data[l.pos]; {
+		case isSpace(b):
+			l.pos++
+		case b == '%':
+			for l.pos < len(l.data) && l.data[l.pos] != '\n' && l.data[l.pos] != '\r' {
+				l.pos++
+			}
+		default:
+			return
+		}
+	}
+}
+
+// next returns the next token: a value, a keyword or a delimiter.
+func (l *lexer) next() (any, error) {
+	l.skipSpace()
+	if l.pos >= len(l.data) {
+		return nil, io.EOF
+	}
+	b := l.data[l.pos]
+	switch {
+	case b == '/':
+		return l.name(), nil
+	case b == '(':
+		return l.literalString(), nil
+	case b == '<':
+		if l.pos+1 < len(l.data) && l.data[l.pos+1] == '<' {
+			l.pos += 2
+			return delim("<<"), nil
+		}
+		return l.hexString(), nil
+	case b == '>':
+		l.pos++
+		if l.pos < len(l.data) && l.data[l.pos] == '>' {
+			l.pos++
+		}
+		return delim(">>"), nil
+	case b == '[' || b == ']' || b == '{' || b == '}':
+		l.pos++
+		return delim(string(b)), nil
+	case b == ')':
+		l.pos++
+		return l.next()
+	}
+
+	start := l.pos
+	for l.pos < len(l.data) && !isSpace(l.data[l.pos]) && !isDelim(l.data[l.pos]) {
+		l.pos++
+	}
+	word := string(l.data[start:l.pos])
+	switch word {
+	case "true":
+		return true, nil
+	case "false":
+		return false, nil
+	case "null":
+		return nil, nil
+	}
+	if c := word[0]; c == '+' || c == '-' || c == '.' || (c >= '0' && c <= '9') {
+		if f, err := strconv.ParseFloat(word, 64); err == nil {
+			return f, nil
+		}
+		return 0.0, nil
+	}
+	return keyword(word), nil
+}
+
+func (l *lexer) name() name {
+	l.pos++
+	var b []byte
+	for l.pos < len(l.data) && !isSpace(l.data[l.pos]) && !isDelim(l.data[l.pos]) {
+		c := l.data[l.pos]
+		if c == '#' && l.pos+2 < len(l.data) {
+			if v, err := strconv.ParseUint(string(l.data[l.pos+1:l.pos+3]), 16, 8); err == nil {
+				b = append(b, byte(v))
+				l.pos += 3
+				continue
+			}
+		}
+		b = append(b, c)
+		l.pos++
+	}
+	return name(b)
+}
+
+func (l *lexer) literalString() string {
+	l.pos++
+	var b []byte
+	depth := 0
+	for l.pos < len(l.data) {
+		c := l.data[l.pos]
+		l.pos++
+		switch c {
+		case '(':
+			depth++
+		case ')':
+			if depth == 0 {
+				return string(b)
+			}
+			depth--
+		case '\\':
+			if l.pos >= len(l.data) {
+				return string(b)
+			}
+			c = l.data[l.pos]
+			l.pos++
+			switch c {
+			case 'n':
+				c = '\n'
+			case 'r':
+				c = '\r'
+			case 't':
+				c = '\t'
+			case 'b':
+				c = '\b'
+			case 'f':
+				c = '\f'
+			case '\r':
+				if l.pos < len(l.data) && l.data[l.pos] == '\n' {
+					l.pos++
+				}
+				continue
+			case '\n':
+				continue
+			default:
+				if c >= '0' && c <= '7' {
+					v := int(c - '0')
+					for i := 0; i < 2 && l.pos < len(l.data) && l.data[l.pos] >= '0' && l.data[l.pos] <= '7'; i++ {
+						v = v*8 + int(l.data[l.pos]-'0')
+						l.pos++
+					}
+					c = byte(v)
+				}
+			}
+		}
+		b = append(b, c)
+	}
+	return string(b)
+}
+
+func (l *lexer) hexString() string {
+	l.pos++
+	var digits []byte
+	for l.pos < len(l.data) && l.data[l.pos] != '>' {
+		if c := l.data[l.pos]; !isSpace(c) {
+			digits = append(digits, c)
+		}
+		l.pos++
+	}
+	l.pos++
+	if len(digits)%2 == 1 {
+		digits = append(digits, '0')
+	}
+	b := make([]byte, len(digits)/2)
+	n, _ := hex.Decode(b, digits)
+	return string(b[:n])
+}
+
+// object reads a whole value, arrays and dictionaries included. Keywords
+// and closing delimiters are returned as they are.
+func (l *lexer) object() (any, error) {
+	tok, err := l.next()
+	if err != nil {
+		return nil, err
+	}
+	switch t := tok.(type) {
+	case delim:
+		switch t {
+		case "[":
+			var a array
+			for {
+				v, err := l.object()
+				if err != nil {
+					return a, err
+				}
+				if d, ok := v.(delim); ok && (d == "]" || d == ">>") {
+					return a, nil
+				}
+				a = append(a, v)
+			}
+		case "<<":
+			d := dict{}
+			for {
+				k, err := l.object()
+				if err != nil {
+					return d, err
+				}
+				if end, ok := k.(delim); ok && (end == ">>" || end == "]") {
+					return d, nil
+				}
+				key, ok := k.(name)
+				if !ok {
+					continue
+				}
+				v, err := l.object()
+				if err != nil {
+					return d, err
+				}
+				if end, ok := v.(delim); ok && end == ">>" {
+					return d, nil
+				}
+				d[key] = v
+			}
+		}
+	case float64:
+		if !l.refs {
+			return t, nil
+		}
+		// "1 0 R" is a reference, anything else leaves the number alone.
+		save := l.pos
+		if gen, err := l.next(); err == nil {
+			if g, ok := gen.(float64); ok {
+				if r, err := l.next(); err == nil && r == keyword("R") {
+					return ref{int(t), int(g)}, nil
+				}
+			}
+		}
+		l.pos = save
+	}
+	return tok, nil
+}
+
+// file is the objects of a PDF, by number.
+type file struct {
+	objects map[int]any
+	// trailer has the entries of the trailers and cross-reference streams,
+	// the last one winning.
+	trailer dict
+}
+
+var objHeader = regexp.MustCompile(`(\d+)[ \t\r\n\f\x00]+(\d+)[ \t\r\n\f\x00]+obj\b`)
+
+func parseFile(data []byte) *file {
+	f := &file{objects: map[int]any{}, trailer: dict{}}
+	var objStreams []*stream
+	for pos := 0; pos < len(data); {
+		m := objHeader.FindSubmatchIndex(data[pos:])
+		if m == nil {
+			break
+		}
+		id, _ := strconv.Atoi(string(data[pos+m[2] : pos+m[3]]))
+		l := &lexer{data: data, pos: pos + m[1], refs: true}
+		v, err := l.object()
+		if err != nil {
+			break
+		}
+		if d, ok := v.(dict); ok {
+			save := l.pos
+			if tok, _ := l.next(); tok == keyword("stream") {
+				s := &stream{dict: d, data: streamData(data, l, d)}
+				v = s
+				switch d[name("Type")] {
+				case name("ObjStm"):
+					objStreams = append(objStreams, s)
+				case name("XRef"):
+					for k, v := range d {
+						f.trailer[k] = v
+					}
+				}
+			} else {
+				l.pos = save
+			}
+		}
+		f.objects[id] = v
+		pos = l.pos
+	}
+
+	for _, i := range trailerIndexes(data) {
+		l := &lexer{data: data, pos: i, refs: true}
+		if d, ok := mustObject(l).(dict); ok {
+			for k, v := range d {
+				f.trailer[k] = v
+			}
+		}
+	}
+
+	for _, s := range objStreams {
+		f.readObjectStream(s)
+	}
+	return f
+}
+
+func mustObject(l *lexer) any {
+	v, _ := l.object()
+	return v
+}
+
+func trailerIndexes(data []byte) []int {
+	var indexes []int
+	for pos := 0; ; {
+		i := bytes.Index(data[pos:], []byte("trailer"))
+		if i < 0 {
+			return indexes
+		}
+		pos += i + len("trailer")
+		indexes = append(indexes, pos)
+	}
+}
+
+// streamData returns the data of the stream starting after the stream
+// keyword, leaving the lexer after endstream. The length is trusted when it
+// is right, and endstream looked for otherwise.
+func streamData(data []byte, l *lexer, d dict) []byte {
+	start := l.pos
+	if start < len(data) && data[start] == '\r' {
+		start++
+	}
+	if start < len(data) && data[start] == '\n' {
+		start++
+	}
+	if length, ok := d[name("Length")].(float64); ok && length >= 0 {
+		end := start + int(length)
+		if end <= len(data) {
+			rest := &lexer{data: data, pos: end}
+			if tok, _ := rest.next(); tok == keyword("endstream") {
+				l.pos = rest.pos
+				return data[start:end]
+			}
+		}
+	}
+	i := bytes.Index(data[start:], []byte("endstream"))
+	if i < 0 {
+		l.pos = len(data)
+		return data[start:]
+	}
+	end := start + i
+	l.pos = end + len("endstream")
+	for end > start && (data[end-1] == '\n' || data[end-1] == '\r') {
+		end--
+	}
+	return data[start:end]
+}
+
+// readObjectStream adds the objects packed in the stream, unless they were
+// found in the file itself.
+func (f *file) readObjectStream(s *stream) {
+	data, err := f.decode(s)
+	if err != nil {
+		return
+	}
+	n, _ := f.resolve(s.dict[name("N")]).(float64)
+	first, _ := f.resolve(s.dict[name("First")]).(float64)
+	header := &lexer{data: data}
+	for range int(n) {
+		id, _ := mustObject(header).(float64)
+		offset, _ := mustObject(header).(float64)
+		at := int(first) + int(offset)
+		if at < 0 || at >= len(data) {
+			continue
+		}
+		if _, ok := f.objects[int(id)]; ok {
+			continue
+		}
+		f.objects[int(id)] = mustObject(&lexer{data: data, pos: at, refs: true})
+	}
+}
+
+// resolve follows references to the object they are to.
+func (f *file) resolve(v any) any {
+	for range 32 {
+		r, ok := v.(ref)
+		if !ok {
+			return v
+		}
+		v = f.objects[r.id]
+	}
+	return nil
+}
+
+func (f *file) dict(v any) dict {
+	switch v := f.resolve(v).(type) {
+	case dict:
+		return v
+	case *stream:
+		return v.dict
+	}
+	return nil
+}
+
+func (f *file) array(v any) array {
+	a, _ := f.resolve(v).(array)
+	return a
+}
+
+func (f *file) number(v any) float64 {
+	n, _ := f.resolve(v).(float64)
+	return n
+}
+
+// decode returns the data of the stream with its filters undone.
+func (f *file) decode(s *stream) ([]byte, error) {
+	var filters []name
+	switch v := f.resolve(s.dict[name("Filter")]).(type) {
+	case name:
+		filters = []name{v}
+	case array:
+		for _, filter := range v {
+			if n, ok := f.resolve(filter).(name); ok {
+				filters = append(filters, n)
+			}
+		}
+	}
+	data := s.data
+	for _, filter := range filters {
+		var err error
+		switch filter {
+		case "FlateDecode", "Fl":
+			data, err = inflate(data)
+		case "ASCIIHexDecode", "AHx":
+			data, err = asciiHexDecode(data)
+		case "ASCII85Decode", "A85":
+			data, err = ascii85Decode(data)
+		default:
+			return nil, fmt.Errorf("%w: %s", errUnsupportedFilter, filter)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return data, nil
+}
+
+// inflate undoes the Flate filter, keeping what could be read of a
+// truncated stream.
+func inflate(data []byte) ([]byte, error) {
+	var r io.Reader
+	zr, err := zlib.NewReader(bytes.NewReader(data))
+	if err == nil {
+		r = zr
+	} else if len(data) > 2 {
+		// Some writers get the zlib header wrong, the deflate data after it
+		// is fine.
+		r = flate.NewReader(bytes.NewReader(data[2:]))
+	} else {
+		return nil, err
+	}
+	out, err := io.ReadAll(io.LimitReader(r, maxStreamBytes))
+	if err != nil && len(out) == 0 {
+		return nil, fmt.Errorf("failed to inflate stream: %w", err)
+	}
+	return out, nil
+}
+
+func asciiHexDecode(data []byte) ([]byte, error) {
+	var digits []byte
+	for _, c := range data {
+		if c == '>' {
+			break
+		}
+		if !isSpace(c) {
+			digits = append(digits, c)
+		}
+	}
+	if len(digits)%2 == 1 {
+		digits = append(digits, '0')
+	}
+	out := make([]byte, len(digits)/2)
+	_, err := hex.Decode(out, digits)
+	return out, err
+}
+
+func ascii85Decode(data []byte) ([]byte, error) {
+	if i := bytes.Index(data, []byte("~>")); i >= 0 {
+		data = data[:i]
+	}
+	data = bytes.TrimPrefix(bytes.TrimSpace(data), []byte("<~"))
+	out, err := io.ReadAll(io.LimitReader(ascii85.NewDecoder(bytes.NewReader(data)), maxStreamBytes))
+	if err != nil && len(out) == 0 {
+		return nil, err
+	}
+	return out, nil
+}
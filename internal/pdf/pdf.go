@@ -0,0 +1,528 @@
+// Package pdf typesets the clean HTML of an article into a text PDF. It
+// handles the structure readability leaves behind (headings, paragraphs,
+// lists, quotes, code and inline emphasis) and drops images. Extract goes
+// the other way, from the text of a PDF to HTML.
+package pdf
+
+import (
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"strings"
+	"unicode/utf16"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+type PageSize struct {
+	Width, Height float64 // points
+}
+
+var PageSizes = map[string]PageSize{
+	"a4":     {595.28, 841.89},
+	"a5":     {419.53, 595.28},
+	"a6":     {297.64, 419.53},
+	"letter": {612, 792},
+	"legal":  {612, 1008},
+}
+
+const mm = 72 / 25.4
+
+type Options struct {
+	PageSize PageSize
+	Margin   float64 // points
+	Font     string  // "serif" or "sans"
+	FontSize float64 // points
+}
+
+func DefaultOptions() Options {
+	return Options{
+		PageSize: PageSizes["a4"],
+		Margin:   20 * mm,
+		Font:     "serif",
+		FontSize: 11,
+	}
+}
+
+// MarginMM converts a margin in millimeters to points.
+func MarginMM(v float64) float64 {
+	return v * mm
+}
+
+// Render writes the article as a PDF to w.
+func Render(w io.Writer, title, source, contentHTML string, opts Options) error {
+	fam, ok := families[opts.Font]
+	if !ok {
+		return fmt.Errorf("unknown font: %q", opts.Font)
+	}
+	// Keep at least half the page width for text.
+	if opts.FontSize <= 0 || opts.Margin < 0 || 4*opts.Margin > opts.PageSize.Width {
+		return fmt.Errorf("invalid page layout")
+	}
+
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(contentHTML))
+	if err != nil {
+		return fmt.Errorf("failed to parse content: %w", err)
+	}
+	p := &parser{}
+	p.addText(title, context{heading: 1, style: style{bold: true}})
+	p.flush()
+	p.addText(source, context{source: true})
+	p.flush()
+	p.walk(doc.Selection, context{})
+	p.flush()
+
+	l := &layout{opts: opts, family: fam}
+	l.newPage()
+	for _, b := range p.blocks {
+		l.block(b)
+	}
+	l.pageNumbers()
+	return write(w, l.pages, fam.fonts(), opts.PageSize, title)
+}
+
+// token is a run of text without whitespace in a single style.
+type token struct {
+	text        []byte
+	style       style
+	spaceBefore bool
+}
+
+type block struct {
+	heading int // 1-6, or 0 for body text
+	indent  int
+	bullet  string
+	pre     bool
+	source  bool
+	tokens  []token
+	lines   []string // for pre blocks
+}
+
+type context struct {
+	style   style
+	heading int
+	indent  int
+	pre     bool
+	source  bool
+}
+
+type parser struct {
+	blocks       []*block
+	cur          *block
+	pendingSpace bool
+	bullet       string
+}
+
+func (p *parser) flush() {
+	if p.cur != nil {
+		p.blocks = append(p.blocks, p.cur)
+	}
+	p.cur = nil
+	p.pendingSpace = false
+}
+
+// current returns the block being built, starting one if there is none.
+// Blocks start lazily so that empty wrappers don't produce empty space.
+func (p *parser) current(ctx context) *block {
+	if p.cur == nil {
+		p.cur = &block{
+			heading: ctx.heading,
+			indent:  ctx.indent,
+			bullet:  p.bullet,
+			pre:     ctx.pre,
+			source:  ctx.source,
+		}
+		p.bullet = ""
+	}
+	return p.cur
+}
+
+func (p *parser) addText(text string, ctx context) {
+	if ctx.pre {
+		if p.cur == nil && strings.TrimSpace(text) == "" {
+			return
+		}
+		b := p.current(ctx)
+		text = strings.ReplaceAll(text, "\t", "    ")
+		if len(b.lines) == 0 {
+			b.lines = []string{""}
+		}
+		parts := strings.Split(text, "\n")
+		b.lines[len(b.lines)-1] += parts[0]
+		b.lines = append(b.lines, parts[1:]...)
+		return
+	}
+
+	startsWithSpace := text != "" && strings.TrimLeft(text, " \t\n\r ") != text
+	endsWithSpace := text != "" && strings.TrimRight(text, " \t\n\r ") != text
+	words := strings.Fields(text)
+	if len(words) == 0 {
+		if startsWithSpace {
+			p.pendingSpace = true
+		}
+		return
+	}
+
+	b := p.current(ctx)
+	for i, word := range words {
+		spaceBefore := i > 0 || startsWithSpace || p.pendingSpace
+		b.tokens = append(b.tokens, token{
+			text:        encode(word),
+			style:       ctx.style,
+			spaceBefore: spaceBefore && len(b.tokens) > 0,
+		})
+	}
+	p.pendingSpace = endsWithSpace
+}
+
+func (p *parser) walk(s *goquery.Selection, ctx context) {
+	s.Contents().Each(func(_ int, child *goquery.Selection) {
+		name := goquery.NodeName(child)
+		switch name {
+		case "#text":
+			p.addText(child.Text(), ctx)
+		case "script", "style", "noscript", "svg", "img", "picture", "video", "iframe", "button":
+		case "br":
+			if ctx.pre {
+				p.addText("\n", ctx)
+			} else {
+				p.flush()
+			}
+		case "b", "strong":
+			inner := ctx
+			inner.style.bold = true
+			p.walk(child, inner)
+		case "i", "em", "cite":
+			inner := ctx
+			inner.style.italic = true
+			p.walk(child, inner)
+		case "code", "kbd", "samp", "tt":
+			inner := ctx
+			inner.style.mono = true
+			p.walk(child, inner)
+		case "h1", "h2", "h3", "h4", "h5", "h6":
+			p.flush()
+			inner := ctx
+			inner.heading = int(name[1] - '0')
+			inner.style.bold = true
+			p.walk(child, inner)
+			p.flush()
+		case "pre":
+			p.flush()
+			inner := ctx
+			inner.pre = true
+			inner.style.mono = true
+			p.walk(child, inner)
+			p.flush()
+		case "blockquote":
+			p.flush()
+			inner := ctx
+			inner.indent++
+			p.walk(child, inner)
+			p.flush()
+		case "ul", "ol":
+			p.flush()
+			inner := ctx
+			inner.indent++
+			n := 0
+			child.Children().Each(func(_ int, li *goquery.Selection) {
+				if goquery.NodeName(li) != "li" {
+					p.walk(li, inner)
+					return
+				}
+				n++
+				p.flush()
+				if name == "ol" {
+					p.bullet = fmt.Sprintf("%d.", n)
+				} else {
+					p.bullet = "\x95"
+				}
+				p.walk(li, inner)
+				p.flush()
+			})
+		case "p", "div", "section", "article", "header", "footer", "aside", "figure", "figcaption",
+			"table", "tr", "dl", "dt", "dd", "hr", "main", "nav", "details", "summary":
+			p.flush()
+			p.walk(child, ctx)
+			p.flush()
+		default:
+			p.walk(child, ctx)
+		}
+	})
+}
+
+type layout struct {
+	opts   Options
+	family family
+	pages  []*bytes.Buffer
+	page   *bytes.Buffer
+	y      float64
+}
+
+func (l *layout) newPage() {
+	l.page = &bytes.Buffer{}
+	l.pages = append(l.pages, l.page)
+	l.y = l.opts.PageSize.Height - l.opts.Margin
+}
+
+func (l *layout) bottom() float64 {
+	// Leave room for the page number below the text.
+	return l.opts.Margin + l.opts.FontSize
+}
+
+// advance moves down by a line, breaking the page if it doesn't fit.
+func (l *layout) advance(lineHeight float64) {
+	if l.y-lineHeight < l.bottom() {
+		l.newPage()
+	}
+	l.y -= lineHeight
+}
+
+func (l *layout) space(h float64) {
+	// Space at the top of a page is dropped.
+	if l.y < l.opts.PageSize.Height-l.opts.Margin {
+		l.y -= h
+	}
+}
+
+func (l *layout) text(x float64, f *font, size float64, text []byte) {
+	fmt.Fprintf(l.page, "BT /%s %.2f Tf 1 0 0 1 %.2f %.2f Tm (%s) Tj ET\n", f.name, size, x, l.y, escape(text))
+}
+
+// pageNumbers adds "n / total" centered in the bottom margin of each page.
+func (l *layout) pageNumbers() {
+	f := l.family.regular
+	size := l.opts.FontSize * 0.75
+	for i, page := range l.pages {
+		number := []byte(fmt.Sprintf("%d / %d", i+1, len(l.pages)))
+		x := (l.opts.PageSize.Width - f.width(number, size)) / 2
+		y := max(l.opts.Margin/2, size)
+		fmt.Fprintf(page, "BT /%s %.2f Tf 1 0 0 1 %.2f %.2f Tm (%s) Tj ET\n", f.name, size, x, y, escape(number))
+	}
+}
+
+var headingScale = [7]float64{1, 1.7, 1.4, 1.2, 1.1, 1, 1}
+
+func (l *layout) block(b *block) {
+	size := l.opts.FontSize * headingScale[b.heading]
+	if b.pre {
+		size *= 0.85
+	}
+	if b.source {
+		size *= 0.8
+	}
+	lineHeight := size * 1.4
+	if b.heading > 0 {
+		lineHeight = size * 1.2
+		l.space(size * 0.6)
+	}
+
+	left := l.opts.Margin + float64(b.indent)*l.opts.FontSize*1.5
+	right := l.opts.PageSize.Width - l.opts.Margin
+	textLeft := left
+	if b.bullet != "" {
+		textLeft += l.opts.FontSize * 1.5
+	}
+
+	first := true
+	drawBullet := func() {
+		if first && b.bullet != "" {
+			l.text(left, l.family.regular, size, []byte(b.bullet))
+		}
+		first = false
+	}
+
+	if b.pre {
+		f := courier
+		maxChars := max(1, int((right-textLeft)/(f.width([]byte("m"), size))))
+		for _, line := range b.lines {
+			encoded := encode(line)
+			for {
+				l.advance(lineHeight)
+				chunk := encoded[:min(len(encoded), maxChars)]
+				l.text(textLeft, f, size, chunk)
+				encoded = encoded[len(chunk):]
+				if len(encoded) == 0 {
+					break
+				}
+			}
+		}
+		l.space(size * 0.6)
+		return
+	}
+
+	for _, line := range l.breakLines(b.tokens, size, right-textLeft) {
+		l.advance(lineHeight)
+		drawBullet()
+		// Consecutive tokens in the same font are drawn as a single string,
+		// so the spaces survive copying text out of the PDF.
+		x := textLeft
+		var run []byte
+		var runFont *font
+		drawRun := func() {
+			if len(run) > 0 {
+				l.text(x, runFont, size, run)
+				x += runFont.width(run, size)
+			}
+			run = nil
+		}
+		for i, t := range line {
+			f := l.family.font(t.style)
+			if f != runFont {
+				drawRun()
+				runFont = f
+			}
+			if i > 0 && t.spaceBefore {
+				if len(run) > 0 {
+					run = append(run, ' ')
+				} else {
+					x += f.width([]byte(" "), size)
+				}
+			}
+			run = append(run, t.text...)
+		}
+		drawRun()
+	}
+	l.space(size * 0.6)
+}
+
+// breakLines fills lines greedily, only breaking where there was whitespace.
+// Words too long for a line are split.
+func (l *layout) breakLines(tokens []token, size, width float64) [][]token {
+	var lines [][]token
+	var line []token
+	x := 0.0
+	for i := 0; i < len(tokens); {
+		// A word is a run of tokens without whitespace between them.
+		j := i + 1
+		for j < len(tokens) && !tokens[j].spaceBefore {
+			j++
+		}
+		word := tokens[i:j]
+		wordWidth := 0.0
+		for _, t := range word {
+			wordWidth += l.family.font(t.style).width(t.text, size)
+		}
+
+		gap := 0.0
+		if len(line) > 0 {
+			gap = l.family.font(word[0].style).width([]byte(" "), size)
+		}
+		switch {
+		case x+gap+wordWidth <= width:
+			line = append(line, word...)
+			x += gap + wordWidth
+			i = j
+		case len(line) > 0:
+			lines = append(lines, line)
+			line = nil
+			x = 0
+		default:
+			// Split an overlong word at the last byte that fits.
+			t := word[0]
+			f := l.family.font(t.style)
+			n := 1
+			for n < len(t.text) && f.width(t.text[:n+1], size) <= width {
+				n++
+			}
+			if n >= len(t.text) {
+				lines = append(lines, []token{t})
+				i++
+				if i < len(tokens) {
+					tokens[i].spaceBefore = true
+				}
+				continue
+			}
+			lines = append(lines, []token{{text: t.text[:n], style: t.style}})
+			tokens[i] = token{text: t.text[n:], style: t.style, spaceBefore: true}
+		}
+	}
+	if len(line) > 0 {
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+func escape(text []byte) string {
+	var b strings.Builder
+	for _, c := range text {
+		switch {
+		case c == '(' || c == ')' || c == '\\':
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		case c < 32 || c > 126:
+			fmt.Fprintf(&b, "\\%03o", c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+// textString encodes a string for the document info dictionary.
+func textString(s string) string {
+	var b strings.Builder
+	b.WriteString("<FEFF")
+	for _, u := range utf16.Encode([]rune(s)) {
+		fmt.Fprintf(&b, "%04X", u)
+	}
+	b.WriteString(">")
+	return b.String()
+}
+
+// write assembles the PDF objects. Object 1 is the catalog, 2 the page tree,
+// then the fonts, the info dictionary and a page and content stream per page.
+func write(w io.Writer, pages []*bytes.Buffer, fonts []*font, size PageSize, title string) error {
+	var out bytes.Buffer
+	var offsets []int
+	obj := func(body string) {
+		offsets = append(offsets, out.Len())
+		fmt.Fprintf(&out, "%d 0 obj\n%s\nendobj\n", len(offsets), body)
+	}
+
+	out.WriteString("%PDF-1.4\n%\xe2\xe3\xcf\xd3\n")
+
+	fontsStart := 3
+	infoID := fontsStart + len(fonts)
+	pagesStart := infoID + 1
+
+	var kids, fontRefs strings.Builder
+	for i := range pages {
+		fmt.Fprintf(&kids, "%d 0 R ", pagesStart+2*i)
+	}
+	for i, f := range fonts {
+		fmt.Fprintf(&fontRefs, "/%s %d 0 R ", f.name, fontsStart+i)
+	}
+
+	obj("<< /Type /Catalog /Pages 2 0 R >>")
+	obj(fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d /MediaBox [0 0 %.2f %.2f] >>", kids.String(), len(pages), size.Width, size.Height))
+	for _, f := range fonts {
+		obj(fmt.Sprintf("<< /Type /Font /Subtype /Type1 /BaseFont /%s /Encoding /WinAnsiEncoding >>", f.base))
+	}
+	obj(fmt.Sprintf("<< /Title %s /Producer (Kindlepathy) >>", textString(title)))
+
+	for i, page := range pages {
+		var compressed bytes.Buffer
+		zw := zlib.NewWriter(&compressed)
+		if _, err := zw.Write(page.Bytes()); err != nil {
+			return err
+		}
+		if err := zw.Close(); err != nil {
+			return err
+		}
+
+		obj(fmt.Sprintf("<< /Type /Page /Parent 2 0 R /Resources << /Font << %s>> >> /Contents %d 0 R >>", fontRefs.String(), pagesStart+2*i+1))
+		obj(fmt.Sprintf("<< /Length %d /Filter /FlateDecode >>\nstream\n%s\nendstream", compressed.Len(), compressed.Bytes()))
+	}
+
+	xref := out.Len()
+	fmt.Fprintf(&out, "xref\n0 %d\n0000000000 65535 f \n", len(offsets)+1)
+	for _, offset := range offsets {
+		fmt.Fprintf(&out, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(&out, "trailer\n<< /Size %d /Root 1 0 R /Info %d 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(offsets)+1, infoID, xref)
+
+	_, err := w.Write(out.Bytes())
+	return err
+}
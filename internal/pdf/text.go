@@ -0,0 +1,634 @@
+package pdf
+
+import (
+	"math"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+)
+
+// textFont turns the codes of shown strings into text and widths.
+type textFont struct {
+	// codeRanges are the code space of a composite font, the lengths of its
+	// codes. Simple fonts have one byte codes.
+	codeRanges []codeRange
+	toUnicode  map[uint32]string
+	// encoding is the text of the codes of a simple font without a
+	// ToUnicode map.
+	encoding *[256]string
+	widths   map[uint32]float64
+	// defaultWidth is the width of the codes without one, in thousandths
+	// of the font size.
+	defaultWidth float64
+	// scale converts the widths to thousandths of the font size, Type 3
+	// fonts have their own units.
+	scale float64
+}
+
+type codeRange struct {
+	lo, hi []byte
+}
+
+// glyph is a shown code.
+type glyph struct {
+	text  string
+	width float64
+	// space is set on the single byte code 32, which the word spacing
+	// applies to.
+	space bool
+}
+
+func (tf *textFont) codeLength(s string) int {
+	for _, r := range tf.codeRanges {
+		n := len(r.lo)
+		if n == 0 || n > len(s) {
+			continue
+		}
+		if s[:n] >= string(r.lo) && s[:n] <= string(r.hi) {
+			return n
+		}
+	}
+	if len(tf.codeRanges) > 0 {
+		return min(len(s), len(tf.codeRanges[0].lo))
+	}
+	return 1
+}
+
+func (tf *textFont) glyphs(s string) []glyph {
+	var glyphs []glyph
+	for len(s) > 0 {
+		n := max(1, tf.codeLength(s))
+		var code uint32
+		for i := range n {
+			code = code<<8 | uint32(s[i])
+		}
+		s = s[n:]
+
+		g := glyph{space: n == 1 && code == 32}
+		if text, ok := tf.toUnicode[code]; ok {
+			g.text = text
+		} else if tf.encoding != nil && code < 256 {
+			g.text = tf.encoding[code]
+		}
+		w, ok := tf.widths[code]
+		if !ok {
+			w = tf.defaultWidth
+		}
+		g.width = w * tf.scale
+		glyphs = append(glyphs, g)
+	}
+	return glyphs
+}
+
+// loadFont reads a font dictionary of a page.
+func (f *file) loadFont(d dict) *textFont {
+	tf := &textFont{widths: map[uint32]float64{}, defaultWidth: 500, scale: 1}
+	subtype, _ := f.resolve(d[name("Subtype")]).(name)
+	if tounicode, ok := f.resolve(d[name("ToUnicode")]).(*stream); ok {
+		if data, err := f.decode(tounicode); err == nil {
+			tf.toUnicode, tf.codeRanges = parseCMap(data)
+		}
+	}
+
+	if subtype == "Type0" {
+		// The codes of composite fonts are two bytes, unless the ToUnicode
+		// map says otherwise, and their text has to come from the map.
+		if len(tf.codeRanges) == 0 {
+			tf.codeRanges = []codeRange{{lo: []byte{0, 0}, hi: []byte{0xFF, 0xFF}}}
+		}
+		tf.defaultWidth = 1000
+		descendants := f.array(d[name("DescendantFonts")])
+		if len(descendants) == 0 {
+			return tf
+		}
+		cid := f.dict(descendants[0])
+		if dw, ok := f.resolve(cid[name("DW")]).(float64); ok {
+			tf.defaultWidth = dw
+		}
+		w := f.array(cid[name("W")])
+		for i := 0; i+1 < len(w); {
+			first := f.number(w[i])
+			if widths, ok := f.resolve(w[i+1]).(array); ok {
+				for j, width := range widths {
+					tf.widths[uint32(first)+uint32(j)] = f.number(width)
+				}
+				i += 2
+				continue
+			}
+			if i+2 >= len(w) {
+				break
+			}
+			last, width := f.number(w[i+1]), f.number(w[i+2])
+			for code := first; code <= last && code-first < 1<<16; code++ {
+				tf.widths[uint32(code)] = width
+			}
+			i += 3
+		}
+		return tf
+	}
+
+	// Simple fonts have one byte codes, whatever the ToUnicode map says.
+	tf.codeRanges = nil
+	tf.encoding = f.simpleEncoding(d)
+	firstChar := f.number(d[name("FirstChar")])
+	for i, width := range f.array(d[name("Widths")]) {
+		tf.widths[uint32(firstChar)+uint32(i)] = f.number(width)
+	}
+	if len(tf.widths) == 0 {
+		// The standard fonts go without widths.
+		base, _ := f.resolve(d[name("BaseFont")]).(name)
+		std := standardFont(string(base))
+		for code := 32; code < 256; code++ {
+			tf.widths[uint32(code)] = float64(std.charWidth(byte(code)))
+		}
+	}
+	if subtype == "Type3" {
+		if m := f.array(d[name("FontMatrix")]); len(m) > 0 {
+			tf.scale = f.number(m[0]) * 1000
+		}
+	}
+	return tf
+}
+
+// standardFont returns the font of the ones the package writes with that
+// has the widths closest to the standard font.
+func standardFont(base string) *font {
+	switch {
+	case strings.Contains(base, "Courier"):
+		return courier
+	case strings.Contains(base, "Helvetica") || strings.Contains(base, "Arial"):
+		if strings.Contains(base, "Bold") {
+			return families["sans"].bold
+		}
+		return families["sans"].regular
+	case strings.Contains(base, "Bold"):
+		return families["serif"].bold
+	}
+	return families["serif"].regular
+}
+
+func (f *file) simpleEncoding(d dict) *[256]string {
+	encoding := &standardEncoding
+	var differences array
+	switch e := f.resolve(d[name("Encoding")]).(type) {
+	case name:
+		encoding = baseEncoding(e)
+	case dict:
+		if base, ok := f.resolve(e[name("BaseEncoding")]).(name); ok {
+			encoding = baseEncoding(base)
+		}
+		differences = f.array(e[name("Differences")])
+	}
+	if len(differences) == 0 {
+		return encoding
+	}
+	custom := *encoding
+	code := 0
+	for _, v := range differences {
+		switch v := f.resolve(v).(type) {
+		case float64:
+			code = int(v)
+		case name:
+			if code >= 0 && code < 256 {
+				custom[code] = glyphText(string(v))
+			}
+			code++
+		}
+	}
+	return &custom
+}
+
+func baseEncoding(n name) *[256]string {
+	switch n {
+	case "WinAnsiEncoding":
+		return &winAnsiEncoding
+	case "MacRomanEncoding":
+		return &macRomanEncoding
+	}
+	return &standardEncoding
+}
+
+// parseCMap reads the mappings and the code space of a ToUnicode map.
+func parseCMap(data []byte) (map[uint32]string, []codeRange) {
+	mappings := map[uint32]string{}
+	var ranges []codeRange
+	l := &lexer{data: data}
+	var operands []any
+	for {
+		v, err := l.object()
+		if err != nil {
+			break
+		}
+		k, ok := v.(keyword)
+		if !ok {
+			operands = append(operands, v)
+			continue
+		}
+		switch k {
+		case "endcodespacerange":
+			for i := 0; i+1 < len(operands); i += 2 {
+				lo, _ := operands[i].(string)
+				hi, _ := operands[i+1].(string)
+				if lo != "" && len(lo) == len(hi) {
+					ranges = append(ranges, codeRange{[]byte(lo), []byte(hi)})
+				}
+			}
+		case "endbfchar":
+			for i := 0; i+1 < len(operands); i += 2 {
+				src, _ := operands[i].(string)
+				dst, _ := operands[i+1].(string)
+				mappings[codeOf(src)] = utf16Text(dst)
+			}
+		case "endbfrange":
+			for i := 0; i+2 < len(operands); i += 3 {
+				lo, _ := operands[i].(string)
+				hi, _ := operands[i+1].(string)
+				first, last := codeOf(lo), codeOf(hi)
+				if last < first || last-first > 1<<16 {
+					continue
+				}
+				switch dst := operands[i+2].(type) {
+				case string:
+					// The last byte of the destination counts up.
+					base := []byte(dst)
+					for j := range last - first + 1 {
+						mappings[first+j] = utf16Text(string(base))
+						if len(base) > 0 {
+							base[len(base)-1]++
+						}
+					}
+				case array:
+					for j, d := range dst {
+						if s, ok := d.(string); ok && first+uint32(j) <= last {
+							mappings[first+uint32(j)] = utf16Text(s)
+						}
+					}
+				}
+			}
+		}
+		operands = operands[:0]
+	}
+	return mappings, ranges
+}
+
+func codeOf(s string) uint32 {
+	var code uint32
+	for i := 0; i < len(s) && i < 4; i++ {
+		code = code<<8 | uint32(s[i])
+	}
+	return code
+}
+
+func utf16Text(s string) string {
+	units := make([]uint16, 0, len(s)/2)
+	for i := 0; i+1 < len(s); i += 2 {
+		units = append(units, uint16(s[i])<<8|uint16(s[i+1]))
+	}
+	return string(utf16.Decode(units))
+}
+
+// matrix is a PDF transformation matrix, [a b c d e f].
+type matrix [6]float64
+
+var identity = matrix{1, 0, 0, 1, 0, 0}
+
+// mul returns m applied before n.
+func (m matrix) mul(n matrix) matrix {
+	return matrix{
+		m[0]*n[0] + m[1]*n[2],
+		m[0]*n[1] + m[1]*n[3],
+		m[2]*n[0] + m[3]*n[2],
+		m[2]*n[1] + m[3]*n[3],
+		m[4]*n[0] + m[5]*n[2] + n[4],
+		m[4]*n[1] + m[5]*n[3] + n[5],
+	}
+}
+
+// run is text shown in one go, on one baseline of a page.
+type run struct {
+	x, y, endX float64
+	size       float64
+	text       string
+}
+
+type graphicsState struct {
+	ctm       matrix
+	font      *textFont
+	fontSize  float64
+	charSpace float64
+	wordSpace float64
+	hScale    float64
+	leading   float64
+	rise      float64
+}
+
+// pageReader interprets the content streams of a page, collecting the runs
+// of text in the order they are shown.
+type pageReader struct {
+	f     *file
+	fonts map[ref]*textFont
+	runs  []run
+	depth int
+
+	gs     graphicsState
+	stack  []graphicsState
+	tm     matrix
+	tlm    matrix
+	cur    *run
+	spaced bool
+}
+
+// maxFormDepth bounds the nesting of form XObjects.
+const maxFormDepth = 8
+
+func (p *pageReader) font(resources dict, fontName name) *textFont {
+	v := p.f.dict(resources[name("Font")])[fontName]
+	// Fonts are kept by reference, the few given in place are read again.
+	r, isRef := v.(ref)
+	if tf, ok := p.fonts[r]; ok && isRef {
+		return tf
+	}
+	d := p.f.dict(v)
+	if d == nil {
+		return nil
+	}
+	tf := p.f.loadFont(d)
+	if isRef {
+		p.fonts[r] = tf
+	}
+	return tf
+}
+
+func (p *pageReader) content(data []byte, resources dict) {
+	l := &lexer{data: data}
+	var operands []any
+	for {
+		v, err := l.object()
+		if err != nil {
+			break
+		}
+		op, ok := v.(keyword)
+		if !ok {
+			operands = append(operands, v)
+			continue
+		}
+		p.operator(l, op, operands, resources)
+		operands = operands[:0]
+	}
+	p.endRun()
+}
+
+func numbers(operands []any, n int) ([]float64, bool) {
+	if len(operands) < n {
+		return nil, false
+	}
+	out := make([]float64, n)
+	for i, v := range operands[len(operands)-n:] {
+		f, ok := v.(float64)
+		if !ok {
+			return nil, false
+		}
+		out[i] = f
+	}
+	return out, true
+}
+
+func (p *pageReader) operator(l *lexer, op keyword, operands []any, resources dict) {
+	switch op {
+	case "q":
+		p.stack = append(p.stack, p.gs)
+	case "Q":
+		if len(p.stack) > 0 {
+			p.gs = p.stack[len(p.stack)-1]
+			p.stack = p.stack[:len(p.stack)-1]
+		}
+	case "cm":
+		if n, ok := numbers(operands, 6); ok {
+			p.gs.ctm = matrix(n).mul(p.gs.ctm)
+		}
+	case "BT":
+		p.tm, p.tlm = identity, identity
+	case "ET":
+		p.endRun()
+	case "Tf":
+		if len(operands) >= 2 {
+			fontName, _ := operands[len(operands)-2].(name)
+			p.gs.font = p.font(resources, fontName)
+			p.gs.fontSize, _ = operands[len(operands)-1].(float64)
+		}
+	case "Tc":
+		if n, ok := numbers(operands, 1); ok {
+			p.gs.charSpace = n[0]
+		}
+	case "Tw":
+		if n, ok := numbers(operands, 1); ok {
+			p.gs.wordSpace = n[0]
+		}
+	case "Tz":
+		if n, ok := numbers(operands, 1); ok {
+			p.gs.hScale = n[0] / 100
+		}
+	case "TL":
+		if n, ok := numbers(operands, 1); ok {
+			p.gs.leading = n[0]
+		}
+	case "Ts":
+		if n, ok := numbers(operands, 1); ok {
+			p.gs.rise = n[0]
+		}
+	case "Td", "TD":
+		if n, ok := numbers(operands, 2); ok {
+			if op == "TD" {
+				p.gs.leading = -n[1]
+			}
+			p.moveLine(n[0], n[1])
+		}
+	case "Tm":
+		if n, ok := numbers(operands, 6); ok {
+			p.tm, p.tlm = matrix(n), matrix(n)
+		}
+	case "T*":
+		p.moveLine(0, -p.gs.leading)
+	case "Tj":
+		if len(operands) > 0 {
+			s, _ := operands[len(operands)-1].(string)
+			p.show(s)
+		}
+	case "'", "\"":
+		if op == "\"" {
+			if n, ok := numbers(operands[:max(0, len(operands)-1)], 2); ok {
+				p.gs.wordSpace, p.gs.charSpace = n[0], n[1]
+			}
+		}
+		p.moveLine(0, -p.gs.leading)
+		if len(operands) > 0 {
+			s, _ := operands[len(operands)-1].(string)
+			p.show(s)
+		}
+	case "TJ":
+		if len(operands) == 0 {
+			return
+		}
+		a, _ := operands[len(operands)-1].(array)
+		for _, v := range a {
+			switch v := v.(type) {
+			case string:
+				p.show(v)
+			case float64:
+				p.tm = matrix{1, 0, 0, 1, -v / 1000 * p.gs.fontSize * p.gs.hScale, 0}.mul(p.tm)
+				// Words are often set apart by moving on rather than
+				// with spaces.
+				if v < -200 {
+					p.spaced = true
+				}
+			}
+		}
+	case "Do":
+		if len(operands) > 0 {
+			xobjectName, _ := operands[len(operands)-1].(name)
+			p.form(p.f.resolve(p.f.dict(resources[name("XObject")])[xobjectName]), resources)
+		}
+	case "ID":
+		skipInlineImage(l)
+	}
+}
+
+// form reads the text of a form XObject.
+func (p *pageReader) form(v any, resources dict) {
+	s, ok := v.(*stream)
+	if !ok || s.dict[name("Subtype")] != name("Form") || p.depth >= maxFormDepth {
+		return
+	}
+	data, err := p.f.decode(s)
+	if err != nil {
+		return
+	}
+	if r := p.f.dict(s.dict[name("Resources")]); r != nil {
+		resources = r
+	}
+	p.endRun()
+	saved, savedTm, savedTlm := p.gs, p.tm, p.tlm
+	if m := p.f.array(s.dict[name("Matrix")]); len(m) == 6 {
+		var n matrix
+		for i := range n {
+			n[i] = p.f.number(m[i])
+		}
+		p.gs.ctm = n.mul(p.gs.ctm)
+	}
+	p.depth++
+	p.content(data, resources)
+	p.depth--
+	p.gs, p.tm, p.tlm = saved, savedTm, savedTlm
+}
+
+// skipInlineImage skips the data of an inline image, up to EI.
+func skipInlineImage(l *lexer) {
+	for l.pos < len(l.data) {
+		i := strings.Index(string(l.data[l.pos:]), "EI")
+		if i < 0 {
+			l.pos = len(l.data)
+			return
+		}
+		at := l.pos + i
+		l.pos = at + 2
+		if at > 0 && isSpace(l.data[at-1]) && (l.pos >= len(l.data) || isSpace(l.data[l.pos])) {
+			return
+		}
+	}
+}
+
+func (p *pageReader) moveLine(tx, ty float64) {
+	p.tlm = matrix{1, 0, 0, 1, tx, ty}.mul(p.tlm)
+	p.tm = p.tlm
+}
+
+// show adds the text of a string to the run, moving on by its width.
+func (p *pageReader) show(s string) {
+	if p.gs.font == nil {
+		return
+	}
+	for _, g := range p.gs.font.glyphs(s) {
+		trm := matrix{p.gs.fontSize * p.gs.hScale, 0, 0, p.gs.fontSize, 0, p.gs.rise}.mul(p.tm).mul(p.gs.ctm)
+		x, y := trm[4], trm[5]
+		size := math.Hypot(trm[2], trm[3])
+		// Text that isn't upright, like the arXiv stamp in the margin, is
+		// left out.
+		if math.Abs(trm[1]) > math.Abs(trm[0])/10 || trm[0] <= 0 {
+			p.endRun()
+		} else {
+			if p.cur != nil && (math.Abs(y-p.cur.y) > size/4 || x < p.cur.endX-size) {
+				p.endRun()
+			}
+			if p.cur == nil {
+				p.cur = &run{x: x, y: y, endX: x, size: size}
+			} else if p.spaced || x-p.cur.endX > size/6 {
+				p.cur.text += " "
+			}
+			// A run is as large as its largest text, bullets and
+			// superscripts are smaller.
+			p.cur.size = max(p.cur.size, size)
+			p.cur.text += g.text
+		}
+		p.spaced = false
+
+		advance := g.width/1000*p.gs.fontSize + p.gs.charSpace
+		if g.space {
+			advance += p.gs.wordSpace
+		}
+		p.tm = matrix{1, 0, 0, 1, advance * p.gs.hScale, 0}.mul(p.tm)
+		if p.cur != nil {
+			p.cur.endX = p.tm.mul(p.gs.ctm)[4]
+		}
+	}
+}
+
+func (p *pageReader) endRun() {
+	if p.cur != nil && strings.TrimSpace(p.cur.text) != "" {
+		p.runs = append(p.runs, *p.cur)
+	}
+	p.cur = nil
+}
+
+// glyphText returns the text of a glyph by its name: the names of the
+// Adobe glyph list the fonts of articles use, uniXXXX and uXXXX names, and
+// names of a letter.
+func glyphText(glyphName string) string {
+	if i := strings.IndexByte(glyphName, '.'); i > 0 {
+		glyphName = glyphName[:i]
+	}
+	if text, ok := glyphNames[glyphName]; ok {
+		return text
+	}
+	if len(glyphName) == 1 {
+		return glyphName
+	}
+	if strings.Contains(glyphName, "_") {
+		var b strings.Builder
+		for _, part := range strings.Split(glyphName, "_") {
+			b.WriteString(glyphText(part))
+		}
+		return b.String()
+	}
+	for _, prefix := range []string{"uni", "u"} {
+		digits, ok := strings.CutPrefix(glyphName, prefix)
+		if !ok || len(digits) < 4 {
+			continue
+		}
+		if prefix == "uni" && len(digits)%4 == 0 {
+			var b strings.Builder
+			for i := 0; i < len(digits); i += 4 {
+				if v, err := strconv.ParseUint(digits[i:i+4], 16, 16); err == nil {
+					b.WriteRune(rune(v))
+				}
+			}
+			return b.String()
+		}
+		if v, err := strconv.ParseUint(digits, 16, 32); err == nil {
+			return string(rune(v))
+		}
+	}
+	if text, ok := accentedLetter(glyphName); ok {
+		return text
+	}
+	return ""
+}
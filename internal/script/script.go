@@ -0,0 +1,85 @@
+// Package script runs small, sandboxed Starlark scripts on go.starlark.net.
+// Scripts can't load modules or touch the system, they only see the
+// globals the host gives them, and they run under step and time limits.
+package script
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"go.starlark.net/starlark"
+	"go.starlark.net/syntax"
+)
+
+type Limits struct {
+	// MaxSteps caps the instructions a script executes.
+	MaxSteps uint64
+	Timeout  time.Duration
+}
+
+var DefaultLimits = Limits{
+	MaxSteps: 1_000_000,
+	Timeout:  time.Second,
+}
+
+// MaxSourceLength is the longest script accepted.
+const MaxSourceLength = 16 << 10
+
+// fileOptions lets scripts loop and branch at the top level, and assign a
+// global more than once, they're a few lines with no need for functions.
+var fileOptions = &syntax.FileOptions{
+	TopLevelControl: true,
+	GlobalReassign:  true,
+}
+
+type Program struct {
+	src string
+}
+
+// Parse checks that a script compiles. Any name that isn't a Starlark
+// builtin or defined by the script is taken to be a global of the host, Run
+// compiles again against the host's globals.
+func Parse(src string) (*Program, error) {
+	if len(src) > MaxSourceLength {
+		return nil, fmt.Errorf("script is longer than %d bytes", MaxSourceLength)
+	}
+	isGlobal := func(name string) bool { return !starlark.Universe.Has(name) }
+	if _, _, err := starlark.SourceProgramOptions(fileOptions, "script", src, isGlobal); err != nil {
+		return nil, err
+	}
+	return &Program{src: src}, nil
+}
+
+// Run executes the program with the host's globals added to the Starlark
+// builtins. Globals assigned by the script are discarded, and so is what it
+// prints.
+func (p *Program) Run(ctx context.Context, globals starlark.StringDict, limits Limits) (err error) {
+	// A bug in a host function must not take the server down with a script.
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("script panicked: %v", r)
+		}
+	}()
+
+	ctx, cancel := context.WithTimeout(ctx, limits.Timeout)
+	defer cancel()
+
+	thread := &starlark.Thread{
+		Name:  "script",
+		Print: func(*starlark.Thread, string) {},
+	}
+	thread.SetMaxExecutionSteps(limits.MaxSteps)
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			thread.Cancel(ctx.Err().Error())
+		case <-done:
+		}
+	}()
+
+	_, err = starlark.ExecFileOptions(fileOptions, thread, "script", p.src, globals)
+	return err
+}
@@ -0,0 +1,392 @@
+package server
+
+import (
+	_ "embed"
+	"errors"
+	"html/template"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/egemengol/kindlepathy/internal/core"
+	pw "github.com/egemengol/kindlepathy/internal/password"
+)
+
+//go:embed account.html
+var TEMPLATE_ACCOUNT string
+
+// GET /settings/account
+func handleAccountGet(c *core.Core, auth *AuthService, logger *slog.Logger) http.Handler {
+	tmpl := template.Must(template.New("account").Parse(TEMPLATE_ACCOUNT))
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authedUser, err := auth.GetAuthenticatedUser(r)
+		if err != nil {
+			auth.HandleAuthError(w, r, err)
+			return
+		}
+
+		sessions, err := auth.ListSessions(r.Context(), authedUser.ID)
+		if err != nil {
+			logger.Error("Error listing sessions", "error", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		type sessionView struct {
+			UserAgent string
+			Created   time.Time
+			LastSeen  time.Time
+			Current   bool
+		}
+		sessionViews := make([]sessionView, len(sessions))
+		for i, s := range sessions {
+			sessionViews[i] = sessionView{
+				UserAgent: s.UserAgent,
+				Created:   time.Unix(s.CreatedTs, 0),
+				LastSeen:  time.Unix(s.LastSeenTs, 0),
+				Current:   s.ID == authedUser.SessionID,
+			}
+		}
+
+		usernameChanges, err := c.ListUsernameChanges(r.Context(), authedUser.ID)
+		if err != nil {
+			logger.Error("Error listing username changes", "error", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		nextUsernameChange, err := c.NextUsernameChange(r.Context(), authedUser.ID, time.Now())
+		if err != nil {
+			logger.Error("Error getting username cooldown", "error", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		email, err := c.GetAccountEmail(r.Context(), authedUser.ID, time.Now())
+		if err != nil {
+			logger.Error("Error getting account email", "error", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		var audit []AuditEntry
+		var instanceFlags core.FlagSet
+		var flagOverrides []core.UserFlagOverride
+		var siteScripts []core.SiteScript
+		var domainExtractors []core.DomainExtractor
+		var comparisons []core.ExtractionComparison
+		if authedUser.IsAdmin {
+			audit, err = auth.ListAudit(r.Context(), 50)
+			if err != nil {
+				logger.Error("Error listing audit log", "error", err)
+				http.Error(w, "Internal server error", http.StatusInternalServerError)
+				return
+			}
+			instanceFlags, err = c.InstanceFlags(r.Context())
+			if err != nil {
+				logger.Error("Error listing feature flags", "error", err)
+				http.Error(w, "Internal server error", http.StatusInternalServerError)
+				return
+			}
+			flagOverrides, err = c.ListUserFlagOverrides(r.Context())
+			if err != nil {
+				logger.Error("Error listing feature flags", "error", err)
+				http.Error(w, "Internal server error", http.StatusInternalServerError)
+				return
+			}
+			siteScripts, err = c.ListSiteScripts(r.Context())
+			if err != nil {
+				logger.Error("Error listing site scripts", "error", err)
+				http.Error(w, "Internal server error", http.StatusInternalServerError)
+				return
+			}
+			domainExtractors, err = c.ListDomainExtractors(r.Context())
+			if err != nil {
+				logger.Error("Error listing domain extractors", "error", err)
+				http.Error(w, "Internal server error", http.StatusInternalServerError)
+				return
+			}
+			comparisons, err = c.ListExtractionComparisons(r.Context(), 30)
+			if err != nil {
+				logger.Error("Error listing extraction comparisons", "error", err)
+				http.Error(w, "Internal server error", http.StatusInternalServerError)
+				return
+			}
+		}
+
+		data := struct {
+			Username           string
+			MustChangePassword bool
+			UsernameChanges    []core.UsernameChange
+			NextUsernameChange *time.Time
+			Email              *core.AccountEmail
+			CanVerifyEmail     bool
+			LandingPage        string
+			EinkMode           string
+			Sessions           []sessionView
+			Flags              core.FlagSet
+			KnownFlags         []core.Flag
+			IsAdmin            bool
+			Audit              []AuditEntry
+			InstanceFlags      core.FlagSet
+			FlagOverrides      []core.UserFlagOverride
+			SiteScripts        []core.SiteScript
+			Extractors         []string
+			DomainExtractors   []core.DomainExtractor
+			Comparisons        []core.ExtractionComparison
+			Build              core.BuildInfo
+			Update             *core.Release
+		}{
+			Username:           authedUser.Username,
+			MustChangePassword: authedUser.MustChangePassword,
+			UsernameChanges:    usernameChanges,
+			NextUsernameChange: nextUsernameChange,
+			Email:              email,
+			CanVerifyEmail:     c.EmailVerificationConfigured(),
+			LandingPage:        authedUser.LandingPage,
+			EinkMode:           authedUser.EinkMode,
+			Sessions:           sessionViews,
+			Flags:              authedUser.Flags,
+			KnownFlags:         core.Flags,
+			IsAdmin:            authedUser.IsAdmin,
+			Audit:              audit,
+			InstanceFlags:      instanceFlags,
+			FlagOverrides:      flagOverrides,
+			SiteScripts:        siteScripts,
+			Extractors:         core.ExtractorNames,
+			DomainExtractors:   domainExtractors,
+			Comparisons:        comparisons,
+			Build:              core.GetBuildInfo(),
+			Update:             c.AvailableUpdate(),
+		}
+
+		if err := tmpl.ExecuteTemplate(w, "account", data); err != nil {
+			logger.Error("Error executing template", "error", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+	})
+}
+
+// POST /settings/account/landing
+func handleAccountLandingPost(c *core.Core, auth *AuthService, logger *slog.Logger) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authedUser, err := auth.GetAuthenticatedUser(r)
+		if err != nil {
+			auth.HandleAuthError(w, r, err)
+			return
+		}
+
+		if err := c.SetLandingPage(r.Context(), authedUser.ID, r.FormValue("landing_page")); err != nil {
+			logger.Warn("Error setting landing page", "error", err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		http.Redirect(w, r, "/settings/account", http.StatusSeeOther)
+	})
+}
+
+// POST /settings/account/eink
+func handleAccountEinkPost(c *core.Core, auth *AuthService, logger *slog.Logger) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authedUser, err := auth.GetAuthenticatedUser(r)
+		if err != nil {
+			auth.HandleAuthError(w, r, err)
+			return
+		}
+
+		if err := c.SetEinkMode(r.Context(), authedUser.ID, r.FormValue("eink_mode")); err != nil {
+			logger.Warn("Error setting e-ink mode", "error", err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		http.Redirect(w, r, "/settings/account", http.StatusSeeOther)
+	})
+}
+
+// POST /settings/account/logout-everywhere
+func handleLogoutEverywherePost(auth *AuthService, logger *slog.Logger) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authedUser, err := auth.GetAuthenticatedUser(r)
+		if err != nil {
+			auth.HandleAuthError(w, r, err)
+			return
+		}
+
+		if err := auth.EndAllSessions(r.Context(), authedUser.ID); err != nil {
+			logger.Error("Error ending sessions", "error", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+	})
+}
+
+// POST /settings/account/password
+func handleAccountPasswordPost(auth *AuthService, logger *slog.Logger) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authedUser, err := auth.GetAuthenticatedUser(r)
+		if err != nil {
+			auth.HandleAuthError(w, r, err)
+			return
+		}
+		if authedUser.Impersonator != nil {
+			http.Error(w, "Not while impersonating", http.StatusForbidden)
+			return
+		}
+
+		newPassword := r.FormValue("new_password")
+		if newPassword != r.FormValue("confirm_password") {
+			http.Error(w, "Passwords do not match", http.StatusBadRequest)
+			return
+		}
+
+		err = auth.ChangePassword(r.Context(), authedUser, r.FormValue("current_password"), newPassword)
+		if errors.Is(err, ErrWrongPassword) {
+			http.Error(w, "Wrong current password", http.StatusForbidden)
+			return
+		}
+		if errors.Is(err, pw.ErrTooShort) || errors.Is(err, pw.ErrTooWeak) || errors.Is(err, pw.ErrBreached) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			logger.Error("Error changing password", "error", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		http.Redirect(w, r, "/settings/account", http.StatusSeeOther)
+	})
+}
+
+// POST /settings/account/delete - Delete the account and everything in it,
+// confirmed with the password
+func handleAccountDeletePost(c *core.Core, auth *AuthService, logger *slog.Logger) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authedUser, err := auth.GetAuthenticatedUser(r)
+		if err != nil {
+			auth.HandleAuthError(w, r, err)
+			return
+		}
+		if authedUser.Impersonator != nil {
+			http.Error(w, "Not while impersonating", http.StatusForbidden)
+			return
+		}
+
+		err = auth.ConfirmPassword(r.Context(), authedUser.ID, r.FormValue("password"))
+		if errors.Is(err, ErrWrongPassword) {
+			http.Error(w, "Wrong password", http.StatusForbidden)
+			return
+		}
+		if err != nil {
+			logger.Error("Error confirming password", "error", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		if err := c.DeleteAccount(r.Context(), authedUser.ID); err != nil {
+			logger.Error("Error deleting account", "error", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		// The sessions went with the account, this clears the cookie.
+		if err := auth.EndSession(w, r); err != nil {
+			logger.Warn("Error ending session of deleted account", "error", err)
+		}
+
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+	})
+}
+
+// POST /settings/account/username
+func handleAccountUsernamePost(c *core.Core, auth *AuthService, logger *slog.Logger) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authedUser, err := auth.GetAuthenticatedUser(r)
+		if err != nil {
+			auth.HandleAuthError(w, r, err)
+			return
+		}
+		if authedUser.Impersonator != nil {
+			http.Error(w, "Not while impersonating", http.StatusForbidden)
+			return
+		}
+
+		err = c.ChangeUsername(r.Context(), authedUser.ID, r.FormValue("username"), time.Now())
+		if err != nil {
+			if errors.Is(err, core.ErrUsernameTaken) {
+				http.Error(w, err.Error(), http.StatusConflict)
+				return
+			}
+			logger.Warn("Error changing username", "error", err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		http.Redirect(w, r, "/settings/account", http.StatusSeeOther)
+	})
+}
+
+// POST /settings/account/email - Send a verification link to a new address
+func handleAccountEmailPost(c *core.Core, auth *AuthService, logger *slog.Logger) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authedUser, err := auth.GetAuthenticatedUser(r)
+		if err != nil {
+			auth.HandleAuthError(w, r, err)
+			return
+		}
+		if authedUser.Impersonator != nil {
+			http.Error(w, "Not while impersonating", http.StatusForbidden)
+			return
+		}
+
+		err = c.StartEmailVerification(r.Context(), authedUser.ID, r.FormValue("email"), time.Now())
+		if err != nil {
+			logger.Warn("Error starting email verification", "error", err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		http.Redirect(w, r, "/settings/account", http.StatusSeeOther)
+	})
+}
+
+// POST /settings/account/email/remove
+func handleAccountEmailRemovePost(c *core.Core, auth *AuthService, logger *slog.Logger) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authedUser, err := auth.GetAuthenticatedUser(r)
+		if err != nil {
+			auth.HandleAuthError(w, r, err)
+			return
+		}
+		if authedUser.Impersonator != nil {
+			http.Error(w, "Not while impersonating", http.StatusForbidden)
+			return
+		}
+
+		if err := c.RemoveEmail(r.Context(), authedUser.ID); err != nil {
+			logger.Error("Error removing email", "error", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		http.Redirect(w, r, "/settings/account", http.StatusSeeOther)
+	})
+}
+
+// GET /settings/account/email/verify?token= - The link of the verification email,
+// which may be opened without being logged in
+func handleAccountEmailVerify(c *core.Core, logger *slog.Logger) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := c.VerifyEmail(r.Context(), r.URL.Query().Get("token"), time.Now()); err != nil {
+			logger.Warn("Error verifying email", "error", err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		http.Redirect(w, r, "/settings/account", http.StatusSeeOther)
+	})
+}
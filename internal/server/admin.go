@@ -0,0 +1,239 @@
+package server
+
+import (
+	"context"
+	_ "embed"
+	"errors"
+	"fmt"
+	"html/template"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/egemengol/kindlepathy/internal/core"
+	db "github.com/egemengol/kindlepathy/internal/db/generated"
+	pw "github.com/egemengol/kindlepathy/internal/password"
+)
+
+// admin.go is the user administration of the admin panel: disabled accounts
+// can't log in, and a forced reset keeps the user on the account page until
+// they choose a new password. Every action goes to the audit log.
+
+//go:embed admin.html
+var TEMPLATE_ADMIN string
+
+var errSelf = errors.New("not on your own account")
+
+// DisableAccount stops the user from logging in and ends their sessions.
+// Their API tokens stop working while the account is disabled.
+func (a *AuthService) DisableAccount(ctx context.Context, admin AuthenticatedUser, userID int64, ip string) error {
+	if userID == admin.ID {
+		return errSelf
+	}
+	err := a.queries.UsersSetDisabled(ctx, db.UsersSetDisabledParams{DisabledTs: time.Now().Unix(), ID: userID})
+	if err != nil {
+		return fmt.Errorf("failed to disable account: %w", err)
+	}
+	if err := a.EndAllSessions(ctx, userID); err != nil {
+		return fmt.Errorf("failed to end sessions: %w", err)
+	}
+	return a.audit(ctx, admin.ID, userID, "account_disabled", "", ip)
+}
+
+// EnableAccount lets a disabled user log in again.
+func (a *AuthService) EnableAccount(ctx context.Context, admin AuthenticatedUser, userID int64, ip string) error {
+	err := a.queries.UsersSetDisabled(ctx, db.UsersSetDisabledParams{DisabledTs: nil, ID: userID})
+	if err != nil {
+		return fmt.Errorf("failed to enable account: %w", err)
+	}
+	return a.audit(ctx, admin.ID, userID, "account_enabled", "", ip)
+}
+
+// ForcePasswordReset logs the user out everywhere and has them choose a new
+// password on their next login. A temporary password, when given, replaces
+// theirs, for users who forgot it.
+func (a *AuthService) ForcePasswordReset(ctx context.Context, admin AuthenticatedUser, userID int64, temporary, ip string) error {
+	if userID == admin.ID {
+		return errSelf
+	}
+	user, err := a.queries.UsersGet(ctx, userID)
+	if err != nil {
+		return fmt.Errorf("failed to get user: %w", err)
+	}
+	detail := ""
+	if temporary != "" {
+		if err := a.CheckNewPassword(temporary, user.Username); err != nil {
+			return err
+		}
+		hash, err := a.HashPassword(temporary)
+		if err != nil {
+			return fmt.Errorf("failed to hash password: %w", err)
+		}
+		err = a.queries.UsersSetPassword(ctx, db.UsersSetPasswordParams{Password: hash, ID: userID})
+		if err != nil {
+			return fmt.Errorf("failed to set password: %w", err)
+		}
+		if err := a.UnlockAccount(ctx, userID); err != nil {
+			return fmt.Errorf("failed to unlock account: %w", err)
+		}
+		detail = "temporary password set"
+	}
+	err = a.queries.UsersSetMustChangePassword(ctx, db.UsersSetMustChangePasswordParams{MustChangePassword: true, ID: userID})
+	if err != nil {
+		return fmt.Errorf("failed to force password reset: %w", err)
+	}
+	if err := a.EndAllSessions(ctx, userID); err != nil {
+		return fmt.Errorf("failed to end sessions: %w", err)
+	}
+	return a.audit(ctx, admin.ID, userID, "password_reset", detail, ip)
+}
+
+// mustChangePasswordAllows is what a user who has to choose a new password
+// can still do.
+func mustChangePasswordAllows(r *http.Request) bool {
+	return r.URL.Path == "/settings/account" || r.URL.Path == "/settings/account/password"
+}
+
+// formatBytes shows a size in the largest unit it has a whole one of.
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+type cacheView struct {
+	core.CacheStats
+	Size string
+}
+
+// GET /admin
+func handleAdminGet(c *core.Core, auth *AuthService, logger *slog.Logger) http.Handler {
+	tmpl := template.Must(template.New("admin").Parse(TEMPLATE_ADMIN))
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authedUser, err := auth.GetAuthenticatedUser(r)
+		if err != nil {
+			auth.HandleAuthError(w, r, err)
+			return
+		}
+		if !authedUser.IsAdmin {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+
+		users, err := c.ListUsers(r.Context())
+		if err != nil {
+			logger.Error("Error listing users", "error", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		type userView struct {
+			core.UserUsage
+			Storage string
+			Self    bool
+		}
+		views := make([]userView, len(users))
+		var items, storage int64
+		for i, u := range users {
+			views[i] = userView{UserUsage: u, Storage: formatBytes(u.StorageBytes), Self: u.ID == authedUser.ID}
+			items += u.Items
+			storage += u.StorageBytes
+		}
+
+		// The page cache, nil when it's off.
+		var cache *cacheView
+		stats, err := c.CacheStats()
+		if err == nil {
+			cache = &cacheView{CacheStats: *stats, Size: formatBytes(stats.LSMBytes + stats.VlogBytes)}
+		} else if !errors.Is(err, core.ErrNoCache) {
+			logger.Warn("Error getting cache stats", "error", err)
+		}
+
+		jobs, err := c.CountJobs(r.Context())
+		if err != nil {
+			logger.Warn("Error counting jobs", "error", err)
+		}
+
+		data := struct {
+			Users   []userView
+			Items   int64
+			Storage string
+			Cache   *cacheView
+			Jobs    map[string]int64
+		}{
+			Users:   views,
+			Items:   items,
+			Storage: formatBytes(storage),
+			Cache:   cache,
+			Jobs:    jobs,
+		}
+
+		if err := tmpl.ExecuteTemplate(w, "admin", data); err != nil {
+			logger.Error("Error executing template", "error", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+	})
+}
+
+// POST /admin/users/{id}/{action} - Disable, enable or force a password
+// reset of an account
+func handleAdminUserPost(auth *AuthService, logger *slog.Logger) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authedUser, err := auth.GetAuthenticatedUser(r)
+		if err != nil {
+			auth.HandleAuthError(w, r, err)
+			return
+		}
+		if !authedUser.IsAdmin || authedUser.Impersonator != nil {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+
+		userID, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+		if err != nil {
+			http.Error(w, "Invalid user ID", http.StatusBadRequest)
+			return
+		}
+		user, err := auth.queries.UsersGet(r.Context(), userID)
+		if err != nil {
+			http.Error(w, "User not found", http.StatusNotFound)
+			return
+		}
+
+		action := r.PathValue("action")
+		ip := auth.clientIP(r)
+		switch action {
+		case "disable":
+			err = auth.DisableAccount(r.Context(), authedUser, user.ID, ip)
+		case "enable":
+			err = auth.EnableAccount(r.Context(), authedUser, user.ID, ip)
+		case "reset-password":
+			err = auth.ForcePasswordReset(r.Context(), authedUser, user.ID, r.FormValue("password"), ip)
+		default:
+			http.Error(w, "Not found", http.StatusNotFound)
+			return
+		}
+		if errors.Is(err, errSelf) || errors.Is(err, pw.ErrTooShort) || errors.Is(err, pw.ErrTooWeak) || errors.Is(err, pw.ErrBreached) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			logger.Error("Error administering account", "action", action, "error", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		logger.Warn("Admin changed account", "admin", authedUser.Username, "user", user.Username, "action", action, "ip", ip)
+
+		http.Redirect(w, r, "/admin", http.StatusSeeOther)
+	})
+}
@@ -0,0 +1,102 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/egemengol/kindlepathy/internal/core"
+	db "github.com/egemengol/kindlepathy/internal/db/generated"
+)
+
+// api.go contains the token authentication of the JSON API, and its few
+// routes outside of v1
+
+// newAPIAuthMiddleware authenticates requests with an "Authorization: Bearer"
+// API token instead of a session cookie. Its errors are answered by fail, in
+// the format of the API.
+func newAPIAuthMiddleware(c *core.Core, queries *db.Queries, logger *slog.Logger, fail func(w http.ResponseWriter, status int, message string)) func(h http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if !ok || token == "" {
+				fail(w, http.StatusUnauthorized, "API token required")
+				return
+			}
+
+			tokenHash := core.HashAPIToken(token)
+			user, err := queries.UsersGetByApiToken(r.Context(), tokenHash)
+			if err != nil {
+				fail(w, http.StatusUnauthorized, "Invalid API token")
+				return
+			}
+			if refusal := appLoginRefusal(user); refusal != "" {
+				fail(w, http.StatusForbidden, refusal)
+				return
+			}
+			err = queries.ApiTokensSetUsed(r.Context(), db.ApiTokensSetUsedParams{
+				LastUsedTs: time.Now().Unix(),
+				TokenHash:  tokenHash,
+			})
+			if err != nil {
+				logger.Warn("Error updating token last use", "error", err)
+			}
+
+			var activeItemID *int64
+			if id, ok := user.ActiveItemID.(int64); ok {
+				activeItemID = &id
+			}
+
+			authedUser := AuthenticatedUser{
+				ID:           user.ID,
+				Username:     user.Username,
+				ActiveItemID: activeItemID,
+				LandingPage:  user.LandingPage,
+				IsAdmin:      user.IsAdmin,
+				EinkMode:     user.EinkMode,
+			}
+
+			authedUser.Flags, err = c.UserFlags(r.Context(), user.ID)
+			if err != nil {
+				logger.Error("Error resolving feature flags", "error", err)
+				fail(w, http.StatusInternalServerError, "Internal server error")
+				return
+			}
+
+			ctx := context.WithValue(r.Context(), userContextKey, authedUser)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// GET /api/flags - The feature flags of the user
+func handleAPIFlagsGet(auth *AuthService) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authedUser, err := auth.GetAuthenticatedUser(r)
+		if err != nil {
+			http.Error(w, "API token required", http.StatusUnauthorized)
+			return
+		}
+		writeJSON(w, http.StatusOK, authedUser.Flags)
+	})
+}
+
+// GET /api/v1/version - The version of the server, and the newer release
+// when the update check found one
+func handleAPIVersionGet(c *core.Core) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, struct {
+			core.BuildInfo
+			Update *core.Release `json:"update,omitempty"`
+		}{core.GetBuildInfo(), c.AvailableUpdate()})
+	})
+}
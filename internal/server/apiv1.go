@@ -0,0 +1,582 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/egemengol/kindlepathy/internal/core"
+	db "github.com/egemengol/kindlepathy/internal/db/generated"
+)
+
+// apiv1.go contains the versioned JSON API for third-party clients, token
+// authenticated like the CLI's. Every error is answered with the same
+// envelope, {"error": {"code": "not_found", "message": "Item not found"}}.
+
+// The codes of the errors of the v1 API.
+const (
+	apiCodeBadRequest   = "bad_request"
+	apiCodeUnauthorized = "unauthorized"
+	apiCodeForbidden    = "forbidden"
+	apiCodeNotFound     = "not_found"
+	apiCodeConflict     = "conflict"
+	apiCodeFetchFailed  = "fetch_failed"
+	apiCodeInternal     = "internal"
+	// apiCodeUnavailable is for features the server isn't set up for.
+	apiCodeUnavailable = "unavailable"
+)
+
+type apiError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+func writeAPIError(w http.ResponseWriter, status int, code, message string) {
+	writeJSON(w, status, struct {
+		Error apiError `json:"error"`
+	}{apiError{Code: code, Message: message}})
+}
+
+// writeAPIAuthError answers the errors of the token middleware.
+func writeAPIAuthError(w http.ResponseWriter, status int, message string) {
+	code := apiCodeUnauthorized
+	switch status {
+	case http.StatusForbidden:
+		code = apiCodeForbidden
+	case http.StatusInternalServerError:
+		code = apiCodeInternal
+	}
+	writeAPIError(w, status, code, message)
+}
+
+// APIv1Item is an item as the v1 API lists it.
+type APIv1Item struct {
+	ID     int64      `json:"id"`
+	Title  string     `json:"title"`
+	URL    string     `json:"url"`
+	Added  time.Time  `json:"added"`
+	Read   *time.Time `json:"read"`
+	Active bool       `json:"active"`
+	Tags   []string   `json:"tags"`
+	// State is unread, archived or favorite.
+	State string `json:"state"`
+	// Status is pending, fetching, ready or failed, FetchError says why the
+	// last fetch failed.
+	Status         string     `json:"status"`
+	FetchError     string     `json:"fetch_error,omitempty"`
+	Note           string     `json:"note,omitempty"`
+	Author         string     `json:"author,omitempty"`
+	SiteName       string     `json:"site_name,omitempty"`
+	Published      *time.Time `json:"published,omitempty"`
+	ReadingMinutes int        `json:"reading_minutes,omitempty"`
+	// Chapters is the position of the current chapter, for serials with a
+	// known chapter list.
+	Chapters *core.ChapterProgress `json:"chapters,omitempty"`
+}
+
+func apiV1ItemFrom(item core.Item) APIv1Item {
+	tags := item.Tags
+	if tags == nil {
+		tags = []string{}
+	}
+	return APIv1Item{
+		ID:             item.ID,
+		Title:          item.Title,
+		URL:            item.URL,
+		Added:          item.AddedTs,
+		Read:           item.ReadTs,
+		Active:         item.IsActive,
+		Tags:           tags,
+		State:          item.State,
+		Status:         item.Status,
+		FetchError:     item.FetchError,
+		Note:           item.Note,
+		Author:         item.Author,
+		SiteName:       item.SiteName,
+		Published:      item.PublishedAt,
+		ReadingMinutes: item.ReadingMinutes,
+		Chapters:       item.Chapters,
+	}
+}
+
+// APIv1Content is the cleaned page of an item, and the chapters around it.
+type APIv1Content struct {
+	ID             int64      `json:"id"`
+	Title          string     `json:"title"`
+	URL            string     `json:"url"`
+	Byline         string     `json:"byline,omitempty"`
+	SiteName       string     `json:"site_name,omitempty"`
+	Published      *time.Time `json:"published,omitempty"`
+	ReadingMinutes int        `json:"reading_minutes"`
+	HTML           string     `json:"html"`
+	// Next and Previous are the URLs of the chapters around this one, empty
+	// when there are none.
+	Next     string `json:"next,omitempty"`
+	Previous string `json:"previous,omitempty"`
+	// NextConfidence and PreviousConfidence are how sure the guesses of
+	// the links are, from 0 to 1, absent when they weren't guessed. The
+	// alternatives are the other likely links of unsure guesses.
+	NextConfidence       float64               `json:"next_confidence,omitempty"`
+	PreviousConfidence   float64               `json:"previous_confidence,omitempty"`
+	NextAlternatives     []core.NavLink        `json:"next_alternatives,omitempty"`
+	PreviousAlternatives []core.NavLink        `json:"previous_alternatives,omitempty"`
+	Chapters             *core.ChapterProgress `json:"chapters,omitempty"`
+}
+
+// apiV1ItemID parses the {id} of the request and checks that the user owns
+// the item, answering the request when they don't.
+func apiV1ItemID(w http.ResponseWriter, r *http.Request, auth *AuthService, user AuthenticatedUser) (int64, bool) {
+	itemID, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, apiCodeBadRequest, "Invalid item ID")
+		return 0, false
+	}
+	if err := auth.RequireOwnership(r.Context(), user.ID, itemID); err != nil {
+		switch err.Error() {
+		case "item not found":
+			writeAPIError(w, http.StatusNotFound, apiCodeNotFound, "Item not found")
+		case "you do not own this item":
+			writeAPIError(w, http.StatusForbidden, apiCodeForbidden, "You do not own this item")
+		default:
+			writeAPIError(w, http.StatusInternalServerError, apiCodeInternal, "Internal server error")
+		}
+		return 0, false
+	}
+	return itemID, true
+}
+
+// writeAPIv1Item answers with the item as it is now.
+func writeAPIv1Item(w http.ResponseWriter, r *http.Request, c *core.Core, logger *slog.Logger, userID, itemID int64, status int) {
+	item, err := c.GetItem(r.Context(), userID, itemID)
+	if err != nil {
+		logger.Error("Error getting item", "error", err)
+		writeAPIError(w, http.StatusInternalServerError, apiCodeInternal, "Internal server error")
+		return
+	}
+	writeJSON(w, status, apiV1ItemFrom(*item))
+}
+
+// writeAPIv1ReadError answers a failed read, a failed fetch being the
+// page's fault rather than the server's.
+func writeAPIv1ReadError(w http.ResponseWriter, logger *slog.Logger, err error) {
+	logger.Error("Error reading item", "error", err)
+	var fetchErr *core.FetchError
+	if errors.As(err, &fetchErr) {
+		writeAPIError(w, http.StatusBadGateway, apiCodeFetchFailed, fetchErr.Reason())
+		return
+	}
+	writeAPIError(w, http.StatusInternalServerError, apiCodeInternal, "Internal server error")
+}
+
+// GET /api/v1/items - The items of the library, ?state= and ?tag= filter
+// them
+func handleAPIv1ItemsGet(c *core.Core, auth *AuthService, logger *slog.Logger) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authedUser, err := auth.GetAuthenticatedUser(r)
+		if err != nil {
+			writeAPIError(w, http.StatusUnauthorized, apiCodeUnauthorized, "API token required")
+			return
+		}
+
+		state := r.URL.Query().Get("state")
+		if state != "" && !core.ValidItemState(state) {
+			writeAPIError(w, http.StatusBadRequest, apiCodeBadRequest, "Invalid state, expected unread, archived or favorite")
+			return
+		}
+		tag := r.URL.Query().Get("tag")
+
+		items, err := c.ListItems(r.Context(), authedUser.ID)
+		if err != nil {
+			logger.Error("Error listing items", "error", err)
+			writeAPIError(w, http.StatusInternalServerError, apiCodeInternal, "Internal server error")
+			return
+		}
+
+		apiItems := []APIv1Item{}
+		for _, item := range items {
+			if state != "" && item.State != state || tag != "" && !slices.Contains(item.Tags, tag) {
+				continue
+			}
+			apiItems = append(apiItems, apiV1ItemFrom(item))
+		}
+		writeJSON(w, http.StatusOK, apiItems)
+	})
+}
+
+// POST /api/v1/items - Add an item from the "url" of the JSON body and make
+// it the active item
+func handleAPIv1ItemsPost(c *core.Core, auth *AuthService, logger *slog.Logger) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authedUser, err := auth.GetAuthenticatedUser(r)
+		if err != nil {
+			writeAPIError(w, http.StatusUnauthorized, apiCodeUnauthorized, "API token required")
+			return
+		}
+
+		var body struct {
+			URL  string   `json:"url"`
+			Tags []string `json:"tags"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.URL == "" {
+			writeAPIError(w, http.StatusBadRequest, apiCodeBadRequest, "Invalid request body, expected {\"url\": ...}")
+			return
+		}
+
+		itemID, err := c.AddItemWithTitleSetActive(r.Context(), authedUser.ID, body.URL, time.Now())
+		if err != nil {
+			logger.Warn("Error adding item", "error", err)
+			writeAPIError(w, http.StatusBadRequest, apiCodeBadRequest, "Failed to add item: "+err.Error())
+			return
+		}
+		if err := c.AddTags(r.Context(), authedUser.ID, itemID, body.Tags); err != nil {
+			logger.Error("Error tagging item", "error", err)
+			writeAPIError(w, http.StatusInternalServerError, apiCodeInternal, "Internal server error")
+			return
+		}
+		writeAPIv1Item(w, r, c, logger, authedUser.ID, itemID, http.StatusCreated)
+	})
+}
+
+// GET /api/v1/items/{id}
+func handleAPIv1ItemGet(c *core.Core, auth *AuthService, logger *slog.Logger) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authedUser, err := auth.GetAuthenticatedUser(r)
+		if err != nil {
+			writeAPIError(w, http.StatusUnauthorized, apiCodeUnauthorized, "API token required")
+			return
+		}
+		itemID, ok := apiV1ItemID(w, r, auth, authedUser)
+		if !ok {
+			return
+		}
+		writeAPIv1Item(w, r, c, logger, authedUser.ID, itemID, http.StatusOK)
+	})
+}
+
+// PATCH /api/v1/items/{id} - Change the title, state, note, tags or read
+// time of an item, or make it the active one. Fields left out of the JSON
+// body are kept.
+func handleAPIv1ItemPatch(c *core.Core, auth *AuthService, logger *slog.Logger) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authedUser, err := auth.GetAuthenticatedUser(r)
+		if err != nil {
+			writeAPIError(w, http.StatusUnauthorized, apiCodeUnauthorized, "API token required")
+			return
+		}
+		itemID, ok := apiV1ItemID(w, r, auth, authedUser)
+		if !ok {
+			return
+		}
+
+		var body struct {
+			Title  *string   `json:"title"`
+			State  *string   `json:"state"`
+			Note   *string   `json:"note"`
+			Tags   *[]string `json:"tags"`
+			Read   *bool     `json:"read"`
+			Active *bool     `json:"active"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			writeAPIError(w, http.StatusBadRequest, apiCodeBadRequest, "Invalid request body")
+			return
+		}
+		if body.Title != nil && *body.Title == "" {
+			writeAPIError(w, http.StatusBadRequest, apiCodeBadRequest, "Title can't be empty")
+			return
+		}
+		if body.State != nil && !core.ValidItemState(*body.State) {
+			writeAPIError(w, http.StatusBadRequest, apiCodeBadRequest, "Invalid state, expected unread, archived or favorite")
+			return
+		}
+		// Another item being made active is how one stops being active.
+		if body.Active != nil && !*body.Active {
+			writeAPIError(w, http.StatusBadRequest, apiCodeBadRequest, "Active can only be set to true")
+			return
+		}
+
+		item, err := c.GetItem(r.Context(), authedUser.ID, itemID)
+		if err != nil {
+			logger.Error("Error getting item", "error", err)
+			writeAPIError(w, http.StatusInternalServerError, apiCodeInternal, "Internal server error")
+			return
+		}
+
+		if body.Note != nil {
+			if err := c.SetItemNote(r.Context(), authedUser.ID, itemID, *body.Note); err != nil {
+				logger.Warn("Error setting item note", "error", err, "itemID", itemID)
+				writeAPIError(w, http.StatusBadRequest, apiCodeBadRequest, err.Error())
+				return
+			}
+		}
+		err = func() error {
+			if body.Title != nil {
+				if err := c.SetItemTitle(r.Context(), itemID, *body.Title); err != nil {
+					return err
+				}
+			}
+			if body.State != nil {
+				if err := c.SetItemState(r.Context(), authedUser.ID, itemID, *body.State); err != nil {
+					return err
+				}
+			}
+			if body.Tags != nil {
+				var removed []string
+				for _, tag := range item.Tags {
+					if !slices.Contains(*body.Tags, tag) {
+						removed = append(removed, tag)
+					}
+				}
+				if err := c.RemoveTags(r.Context(), authedUser.ID, itemID, removed); err != nil {
+					return err
+				}
+				if err := c.AddTags(r.Context(), authedUser.ID, itemID, *body.Tags); err != nil {
+					return err
+				}
+			}
+			if body.Read != nil && *body.Read != (item.ReadTs != nil) {
+				var readTs *time.Time
+				if *body.Read {
+					now := time.Now()
+					readTs = &now
+				}
+				if err := c.SetItemRead(r.Context(), itemID, readTs); err != nil {
+					return err
+				}
+			}
+			if body.Active != nil {
+				return auth.queries.UsersSetActiveItem(r.Context(), db.UsersSetActiveItemParams{
+					ActiveItemID: itemID,
+					ID:           authedUser.ID,
+				})
+			}
+			return nil
+		}()
+		if err != nil {
+			logger.Error("Error updating item", "error", err, "itemID", itemID)
+			writeAPIError(w, http.StatusInternalServerError, apiCodeInternal, "Internal server error")
+			return
+		}
+		writeAPIv1Item(w, r, c, logger, authedUser.ID, itemID, http.StatusOK)
+	})
+}
+
+// DELETE /api/v1/items/{id}
+func handleAPIv1ItemDelete(c *core.Core, auth *AuthService, logger *slog.Logger) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authedUser, err := auth.GetAuthenticatedUser(r)
+		if err != nil {
+			writeAPIError(w, http.StatusUnauthorized, apiCodeUnauthorized, "API token required")
+			return
+		}
+		itemID, ok := apiV1ItemID(w, r, auth, authedUser)
+		if !ok {
+			return
+		}
+
+		if err := c.DeleteItem(r.Context(), itemID); err != nil {
+			logger.Error("Error deleting item", "error", err)
+			writeAPIError(w, http.StatusInternalServerError, apiCodeInternal, "Internal server error")
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
+
+// GET /api/v1/items/{id}/content - The cleaned page of the item, fetched
+// first when it isn't cached
+func handleAPIv1ItemContent(c *core.Core, auth *AuthService, logger *slog.Logger) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authedUser, err := auth.GetAuthenticatedUser(r)
+		if err != nil {
+			writeAPIError(w, http.StatusUnauthorized, apiCodeUnauthorized, "API token required")
+			return
+		}
+		itemID, ok := apiV1ItemID(w, r, auth, authedUser)
+		if !ok {
+			return
+		}
+
+		clean, err := c.ReadItem(r.Context(), itemID, time.Now())
+		if err != nil {
+			writeAPIv1ReadError(w, logger, err)
+			return
+		}
+		summary, err := c.GetItemSummary(r.Context(), itemID)
+		if err != nil {
+			logger.Error("Error getting item", "error", err)
+			writeAPIError(w, http.StatusInternalServerError, apiCodeInternal, "Internal server error")
+			return
+		}
+		chapters, err := c.ReadChapterProgress(r.Context(), itemID, clean, time.Now())
+		if err != nil {
+			logger.Warn("Error getting chapter progress", "error", err)
+		}
+
+		writeJSON(w, http.StatusOK, APIv1Content{
+			ID:             itemID,
+			Title:          clean.Title,
+			URL:            summary.URL,
+			Byline:         clean.Byline,
+			SiteName:       clean.SiteName,
+			Published:      core.ParsePublishedTime(clean.PublishedTime),
+			ReadingMinutes: core.EstimateReadingMinutes(clean.ContentHTML),
+			HTML:           clean.ContentHTML,
+			Next:           clean.NavNext,
+			Previous:       clean.NavPrev,
+			Chapters:       chapters,
+
+			NextConfidence:       clean.NavNextConfidence,
+			PreviousConfidence:   clean.NavPrevConfidence,
+			NextAlternatives:     clean.NavNextAlternatives,
+			PreviousAlternatives: clean.NavPrevAlternatives,
+		})
+	})
+}
+
+// POST /api/v1/items/{id}/navigate - Move the item to the "next" or
+// "previous" chapter, or the URL of one of their alternatives, the "to" of
+// the JSON body, answering with the item
+func handleAPIv1ItemNavigate(c *core.Core, auth *AuthService, logger *slog.Logger) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authedUser, err := auth.GetAuthenticatedUser(r)
+		if err != nil {
+			writeAPIError(w, http.StatusUnauthorized, apiCodeUnauthorized, "API token required")
+			return
+		}
+		itemID, ok := apiV1ItemID(w, r, auth, authedUser)
+		if !ok {
+			return
+		}
+
+		var body struct {
+			To string `json:"to"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.To == "" {
+			writeAPIError(w, http.StatusBadRequest, apiCodeBadRequest, "Invalid request body, expected {\"to\": \"next\"}, {\"to\": \"previous\"} or the url of an alternative")
+			return
+		}
+
+		clean, err := c.ReadItem(r.Context(), itemID, time.Now())
+		if err != nil {
+			writeAPIv1ReadError(w, logger, err)
+			return
+		}
+		var target string
+		switch body.To {
+		case "next":
+			target = clean.NavNext
+		case "previous":
+			target = clean.NavPrev
+		default:
+			// The reader picks another link than an unsure guess.
+			for _, link := range slices.Concat(clean.NavNextAlternatives, clean.NavPrevAlternatives) {
+				if link.URL == body.To {
+					target = link.URL
+				}
+			}
+			if target == "" {
+				writeAPIError(w, http.StatusBadRequest, apiCodeBadRequest, "Not an alternative of the item's next or previous chapter")
+				return
+			}
+		}
+		if target == "" {
+			writeAPIError(w, http.StatusConflict, apiCodeConflict, "There is no "+body.To+" chapter")
+			return
+		}
+		if err := c.NavigateItem(r.Context(), itemID, core.RelativizeURL(target)); err != nil {
+			logger.Error("Error navigating item", "error", err)
+			writeAPIError(w, http.StatusInternalServerError, apiCodeInternal, "Internal server error")
+			return
+		}
+		writeAPIv1Item(w, r, c, logger, authedUser.ID, itemID, http.StatusOK)
+	})
+}
+
+// GET /api/v1/items/{id}/text - The cleaned page of the item as plain text
+func handleAPIv1ItemText(c *core.Core, auth *AuthService, logger *slog.Logger) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authedUser, err := auth.GetAuthenticatedUser(r)
+		if err != nil {
+			writeAPIError(w, http.StatusUnauthorized, apiCodeUnauthorized, "API token required")
+			return
+		}
+		itemID, ok := apiV1ItemID(w, r, auth, authedUser)
+		if !ok {
+			return
+		}
+
+		clean, err := c.ReadItem(r.Context(), itemID, time.Now())
+		if err != nil {
+			writeAPIv1ReadError(w, logger, err)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		fmt.Fprintf(w, "%s\n\n%s\n", clean.Title, core.HTMLToText(clean.ContentHTML))
+	})
+}
+
+// GET /api/v1/items/{id}/epub - The item as an EPUB
+func handleAPIv1ItemEPUB(c *core.Core, auth *AuthService, logger *slog.Logger) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authedUser, err := auth.GetAuthenticatedUser(r)
+		if err != nil {
+			writeAPIError(w, http.StatusUnauthorized, apiCodeUnauthorized, "API token required")
+			return
+		}
+		itemID, ok := apiV1ItemID(w, r, auth, authedUser)
+		if !ok {
+			return
+		}
+
+		var buf bytes.Buffer
+		if err := c.ExportEPUB(r.Context(), itemID, &buf); err != nil {
+			writeAPIv1ReadError(w, logger, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/epub+zip")
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%d.epub"`, itemID))
+		w.Write(buf.Bytes())
+	})
+}
+
+// POST /api/v1/items/{id}/send - Queue the item to be mailed to the "to"
+// address of the JSON body, a Send to Kindle address mostly
+func handleAPIv1ItemSend(c *core.Core, auth *AuthService, logger *slog.Logger) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authedUser, err := auth.GetAuthenticatedUser(r)
+		if err != nil {
+			writeAPIError(w, http.StatusUnauthorized, apiCodeUnauthorized, "API token required")
+			return
+		}
+		itemID, ok := apiV1ItemID(w, r, auth, authedUser)
+		if !ok {
+			return
+		}
+
+		var body struct {
+			To string `json:"to"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.To == "" {
+			writeAPIError(w, http.StatusBadRequest, apiCodeBadRequest, "Invalid request body, expected {\"to\": ...}")
+			return
+		}
+
+		err = c.QueueEmailItem(r.Context(), itemID, body.To, time.Now())
+		if errors.Is(err, core.ErrMailNotConfigured) {
+			writeAPIError(w, http.StatusNotImplemented, apiCodeUnavailable, err.Error())
+			return
+		}
+		if err != nil {
+			logger.Warn("Error emailing item", "error", err, "itemID", itemID)
+			writeAPIError(w, http.StatusBadRequest, apiCodeBadRequest, err.Error())
+			return
+		}
+		w.WriteHeader(http.StatusAccepted)
+	})
+}
@@ -6,8 +6,11 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
+	"net/netip"
 
+	"github.com/egemengol/kindlepathy/internal/core"
 	db "github.com/egemengol/kindlepathy/internal/db/generated"
+	"github.com/egemengol/kindlepathy/internal/password"
 	"github.com/gorilla/sessions"
 )
 
@@ -19,18 +22,154 @@ type AuthenticatedUser struct {
 	ID           int64
 	Username     string
 	ActiveItemID *int64
+	LandingPage  string
+	IsAdmin      bool
+	EinkMode     string
+	// SessionID is the stored ID of the login session, empty for API tokens.
+	SessionID string
+	// Impersonator is set when an admin is using the app as this user.
+	Impersonator *Impersonator
+	Flags        core.FlagSet
+	// MustChangePassword is set after an admin forced a password reset.
+	MustChangePassword bool
 }
 
 type AuthService struct {
 	queries      *db.Queries
 	sessionStore *sessions.CookieStore
+	policy       SessionPolicy
+	lockout      LockoutPolicy
+	passwords    password.Params
+	newPasswords password.Policy
+	loginLimit   *rateLimiter
+	signupLimit  *rateLimiter
+	fetchLimit   *rateLimiter
+	// extensionOrigins may call the extension endpoints with credentials.
+	extensionOrigins []string
+	// trustedProxies may set X-Forwarded-For.
+	trustedProxies []netip.Prefix
+	// basicLogins are the passwords of OPDS apps that were right.
+	basicLogins *loginCache
 }
 
-func NewAuthService(queries *db.Queries, sessionStore *sessions.CookieStore) *AuthService {
+// AuthConfig holds the per-instance authentication settings.
+type AuthConfig struct {
+	Sessions       SessionPolicy
+	Lockout        LockoutPolicy
+	Passwords      password.Params
+	PasswordPolicy password.Policy
+	RateLimits     RateLimitPolicy
+	// ExtensionOrigins may call the extension endpoints with the user's
+	// cookies.
+	ExtensionOrigins []string
+	// TrustedProxies are the reverse proxies whose X-Forwarded-For is
+	// believed, none by default.
+	TrustedProxies []netip.Prefix
+}
+
+func DefaultAuthConfig() AuthConfig {
+	return AuthConfig{
+		Sessions:         DefaultSessionPolicy(),
+		Lockout:          DefaultLockoutPolicy(),
+		Passwords:        password.DefaultParams(),
+		PasswordPolicy:   password.DefaultPolicy(),
+		RateLimits:       DefaultRateLimitPolicy(),
+		ExtensionOrigins: DefaultExtensionOrigins,
+	}
+}
+
+func NewAuthService(queries *db.Queries, sessionStore *sessions.CookieStore, config AuthConfig) *AuthService {
 	return &AuthService{
-		queries:      queries,
-		sessionStore: sessionStore,
+		queries:          queries,
+		sessionStore:     sessionStore,
+		policy:           config.Sessions,
+		lockout:          config.Lockout,
+		passwords:        config.Passwords,
+		newPasswords:     config.PasswordPolicy,
+		loginLimit:       newRateLimiter(config.RateLimits.Login),
+		signupLimit:      newRateLimiter(config.RateLimits.Signup),
+		fetchLimit:       newRateLimiter(config.RateLimits.Fetch),
+		extensionOrigins: config.ExtensionOrigins,
+		trustedProxies:   config.TrustedProxies,
+		basicLogins:      newLoginCache(opdsLoginTTL),
+	}
+}
+
+// CheckNewPassword returns why a password chosen by the user is not
+// acceptable, nil if it is.
+func (a *AuthService) CheckNewPassword(plain, username string) error {
+	return a.newPasswords.Check(plain, username, "kindlepathy")
+}
+
+// HashPassword hashes a new password with the instance's argon2id parameters.
+func (a *AuthService) HashPassword(plain string) (string, error) {
+	return password.Hash(plain, a.passwords)
+}
+
+// CheckPassword verifies the user's password. Hashes made with bcrypt or
+// with older parameters are replaced on a successful check.
+func (a *AuthService) CheckPassword(ctx context.Context, user db.User, plain string) (bool, error) {
+	ok, err := password.Verify(plain, user.Password)
+	if err != nil || !ok {
+		return false, err
+	}
+	if password.NeedsRehash(user.Password, a.passwords) {
+		hash, err := a.HashPassword(plain)
+		if err != nil {
+			return true, err
+		}
+		err = a.queries.UsersSetPassword(ctx, db.UsersSetPasswordParams{Password: hash, ID: user.ID})
+		if err != nil {
+			return true, fmt.Errorf("failed to rehash password: %w", err)
+		}
+	}
+	return true, nil
+}
+
+// ErrWrongPassword is returned when the password confirming a change to the
+// account isn't the user's.
+var ErrWrongPassword = errors.New("wrong password")
+
+// ConfirmPassword checks the password the user gave to confirm a change to
+// their account.
+func (a *AuthService) ConfirmPassword(ctx context.Context, userID int64, plain string) error {
+	user, err := a.queries.UsersGet(ctx, userID)
+	if err != nil {
+		return fmt.Errorf("failed to get user: %w", err)
 	}
+	// A failed rehash still confirms the password.
+	ok, err := a.CheckPassword(ctx, user, plain)
+	if !ok && err != nil {
+		return fmt.Errorf("failed to check password: %w", err)
+	}
+	if !ok {
+		return ErrWrongPassword
+	}
+	return nil
+}
+
+// ChangePassword replaces the user's password once the current one is
+// confirmed, and applies the session policy.
+func (a *AuthService) ChangePassword(ctx context.Context, user AuthenticatedUser, current, new string) error {
+	if err := a.ConfirmPassword(ctx, user.ID, current); err != nil {
+		return err
+	}
+	if err := a.CheckNewPassword(new, user.Username); err != nil {
+		return err
+	}
+	hash, err := a.HashPassword(new)
+	if err != nil {
+		return fmt.Errorf("failed to hash password: %w", err)
+	}
+	err = a.queries.UsersSetPassword(ctx, db.UsersSetPasswordParams{Password: hash, ID: user.ID})
+	if err != nil {
+		return fmt.Errorf("failed to set password: %w", err)
+	}
+	err = a.queries.UsersSetMustChangePassword(ctx, db.UsersSetMustChangePasswordParams{MustChangePassword: false, ID: user.ID})
+	if err != nil {
+		return fmt.Errorf("failed to clear password reset: %w", err)
+	}
+	return a.PasswordChanged(ctx, user)
 }
 
 // GetAuthenticatedUser extracts user information from the request context.
@@ -43,10 +182,10 @@ func (a *AuthService) GetAuthenticatedUser(r *http.Request) (AuthenticatedUser,
 }
 
 // RequireOwnership checks if the user owns the specified item
-func (a *AuthService) RequireOwnership(ctx context.Context, username string, itemID int64) error {
+func (a *AuthService) RequireOwnership(ctx context.Context, userID int64, itemID int64) error {
 	doesOwn, err := a.queries.UsersOwnsItem(ctx, db.UsersOwnsItemParams{
-		Username: username,
-		ID:       itemID,
+		UserID: userID,
+		ID:     itemID,
 	})
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
@@ -86,14 +225,28 @@ func (a *AuthService) HandleAuthError(w http.ResponseWriter, r *http.Request, er
 }
 
 func (a *AuthService) IsAuthenticated(r *http.Request) bool {
-	session, err := a.sessionStore.Get(r, "kindlepathy")
+	_, err := a.currentSession(r)
+	return err == nil
+}
+
+// LandingPath returns where "/" takes the logged in user of the request.
+func (a *AuthService) LandingPath(r *http.Request) string {
+	session, err := a.currentSession(r)
 	if err != nil {
-		return false
+		return "/library"
 	}
-
-	if auth, ok := session.Values["authenticated"].(bool); ok && auth {
-		return true
+	user, err := a.queries.UsersGetBySession(r.Context(), session.ID)
+	if err != nil {
+		return "/library"
 	}
+	return landingPath(user)
+}
 
-	return false
+// landingPath is the user's chosen landing page. The reader needs an active
+// item, so it falls back to the library.
+func landingPath(user db.User) string {
+	if user.LandingPage == core.LandingReader && user.ActiveItemID != nil {
+		return "/read"
+	}
+	return "/library"
 }
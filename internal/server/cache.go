@@ -0,0 +1,127 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/egemengol/kindlepathy/internal/core"
+)
+
+type cachePurgeRequest struct {
+	URL    string `json:"url"`
+	Domain string `json:"domain"`
+	Prefix string `json:"prefix"`
+}
+
+// requireAPIAdmin checks that the API token is an admin's.
+func requireAPIAdmin(w http.ResponseWriter, r *http.Request, auth *AuthService) (AuthenticatedUser, bool) {
+	authedUser, err := auth.GetAuthenticatedUser(r)
+	if err != nil {
+		http.Error(w, "API token required", http.StatusUnauthorized)
+		return authedUser, false
+	}
+	if !authedUser.IsAdmin {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return authedUser, false
+	}
+	return authedUser, true
+}
+
+// GET /api/admin/cache - Size of the page cache and its entries by kind
+func handleAPICacheGet(c *core.Core, auth *AuthService, logger *slog.Logger) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := requireAPIAdmin(w, r, auth); !ok {
+			return
+		}
+
+		stats, err := c.CacheStats()
+		if errors.Is(err, core.ErrNoCache) {
+			http.Error(w, "The cache is off", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			logger.Error("Error getting cache stats", "error", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, http.StatusOK, stats)
+	})
+}
+
+// POST /api/admin/cache/purge - Drop what was cached of a URL, of the URLs
+// starting with a prefix or of a domain
+func handleAPICachePurge(c *core.Core, auth *AuthService, logger *slog.Logger) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authedUser, ok := requireAPIAdmin(w, r, auth)
+		if !ok {
+			return
+		}
+
+		var req cachePurgeRequest
+		if strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") {
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				http.Error(w, "Invalid JSON", http.StatusBadRequest)
+				return
+			}
+		} else {
+			req.URL = r.FormValue("url")
+			req.Domain = r.FormValue("domain")
+			req.Prefix = r.FormValue("prefix")
+		}
+		req.URL = strings.TrimSpace(req.URL)
+		req.Domain = strings.TrimSpace(req.Domain)
+		req.Prefix = strings.TrimSpace(req.Prefix)
+
+		var purged int
+		var err error
+		switch {
+		case req.URL != "" && req.Domain == "" && req.Prefix == "":
+			purged, err = c.PurgeCacheURL(req.URL)
+		case req.Domain != "" && req.URL == "" && req.Prefix == "":
+			purged, err = c.PurgeCacheDomain(req.Domain)
+		case req.Prefix != "" && req.URL == "" && req.Domain == "":
+			purged, err = c.PurgeCachePrefix(req.Prefix)
+		default:
+			http.Error(w, "One of url, domain or prefix is required", http.StatusBadRequest)
+			return
+		}
+		if errors.Is(err, core.ErrNoCache) {
+			http.Error(w, "The cache is off", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			logger.Error("Error purging cache", "error", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		logger.Info("Purged cache", "admin", authedUser.Username, "url", req.URL, "domain", req.Domain, "prefix", req.Prefix, "purged", purged)
+		writeJSON(w, http.StatusOK, map[string]int{"purged": purged})
+	})
+}
+
+// POST /api/admin/cache/gc - Collect the garbage of the cache now, rather
+// than on its schedule
+func handleAPICacheGC(c *core.Core, auth *AuthService, logger *slog.Logger) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authedUser, ok := requireAPIAdmin(w, r, auth)
+		if !ok {
+			return
+		}
+
+		rewritten, err := c.CollectCacheGarbage()
+		if errors.Is(err, core.ErrNoCache) {
+			http.Error(w, "The cache is off", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			logger.Error("Error collecting cache garbage", "error", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		logger.Info("Collected cache garbage", "admin", authedUser.Username, "files", rewritten)
+		writeJSON(w, http.StatusOK, map[string]int{"rewritten": rewritten})
+	})
+}
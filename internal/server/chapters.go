@@ -0,0 +1,97 @@
+package server
+
+import (
+	"errors"
+	"html/template"
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"github.com/egemengol/kindlepathy/internal/core"
+)
+
+type chaptersData struct {
+	Item *core.Item
+	TOC  *core.TableOfContents
+}
+
+// GET /library/{id}/chapters - The chapter list of the item, to jump to any
+// chapter
+func handleLibraryItemChaptersGet(c *core.Core, auth *AuthService, logger *slog.Logger) http.Handler {
+	tmpl := template.Must(template.New("library").Parse(TEMPLATE_LIBRARY))
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authedUser, err := auth.GetAuthenticatedUser(r)
+		if err != nil {
+			auth.HandleAuthError(w, r, err)
+			return
+		}
+
+		itemID, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+		if err != nil {
+			http.Error(w, "Invalid item ID", http.StatusBadRequest)
+			return
+		}
+		if err := auth.RequireOwnership(r.Context(), authedUser.ID, itemID); err != nil {
+			auth.HandleAuthError(w, r, err)
+			return
+		}
+
+		item, err := c.GetItem(r.Context(), authedUser.ID, itemID)
+		if err != nil {
+			logger.Error("Error getting item", "error", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		toc, err := c.ItemTableOfContents(r.Context(), itemID)
+		if err != nil {
+			logger.Error("Error getting chapter list", "error", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		if err := tmpl.ExecuteTemplate(w, "library-chapters", chaptersData{Item: item, TOC: toc}); err != nil {
+			logger.Error("Error executing template", "error", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+	})
+}
+
+// POST /library/{id}/chapters - Jump to the chapter of the list at url
+func handleLibraryItemChaptersPost(c *core.Core, auth *AuthService, logger *slog.Logger) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authedUser, err := auth.GetAuthenticatedUser(r)
+		if err != nil {
+			auth.HandleAuthError(w, r, err)
+			return
+		}
+
+		itemID, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+		if err != nil {
+			http.Error(w, "Invalid item ID", http.StatusBadRequest)
+			return
+		}
+		if err := auth.RequireOwnership(r.Context(), authedUser.ID, itemID); err != nil {
+			auth.HandleAuthError(w, r, err)
+			return
+		}
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, "Failed to parse form", http.StatusBadRequest)
+			return
+		}
+
+		err = c.JumpToListedChapter(r.Context(), itemID, r.Form.Get("url"))
+		if errors.Is(err, core.ErrChapterNotFound) {
+			http.Error(w, "Chapter not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			logger.Error("Error jumping to chapter", "error", err, "itemID", itemID)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		http.Redirect(w, r, "/read/"+strconv.FormatInt(itemID, 10), http.StatusSeeOther)
+	})
+}
@@ -0,0 +1,50 @@
+package server
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/egemengol/kindlepathy/internal/core"
+)
+
+type debugExtractRequest struct {
+	URL  string `json:"url"`
+	HTML string `json:"html"`
+}
+
+// POST /debug/extract - Dry run the extraction of a URL, or of raw HTML
+// given with the URL it came from, for admins developing site rules
+func handleDebugExtractPost(c *core.Core, auth *AuthService, logger *slog.Logger) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authedUser, err := auth.GetAuthenticatedUser(r)
+		if err != nil {
+			http.Error(w, "API token required", http.StatusUnauthorized)
+			return
+		}
+		if !authedUser.IsAdmin {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+
+		var req debugExtractRequest
+		if strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") {
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				http.Error(w, "Invalid JSON", http.StatusBadRequest)
+				return
+			}
+		} else {
+			req.URL = r.FormValue("url")
+			req.HTML = r.FormValue("html")
+		}
+
+		report, err := c.DebugExtract(r.Context(), strings.TrimSpace(req.URL), req.HTML)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		logger.Debug("Dry run extraction", "admin", authedUser.Username, "url", req.URL, "error", report.Error)
+		writeJSON(w, http.StatusOK, report)
+	})
+}
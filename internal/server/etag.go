@@ -0,0 +1,45 @@
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// The reader answers with an ETag of everything the page is rendered from,
+// so reopening a chapter on the Kindle revalidates instead of downloading it
+// again.
+
+// readerETag hashes the template and its data. The data is encoded straight
+// into the hash, long chapters aren't copied for it.
+func readerETag(template string, data any) (string, error) {
+	h := sha256.New()
+	io.WriteString(h, template)
+	if err := json.NewEncoder(h).Encode(data); err != nil {
+		return "", err
+	}
+	return `"` + hex.EncodeToString(h.Sum(nil)[:16]) + `"`, nil
+}
+
+// notModified sets the validation headers of the page, and answers 304 when
+// the client already has it.
+func notModified(w http.ResponseWriter, r *http.Request, etag string) bool {
+	// Kept, but checked with the server each time: nav or the content may
+	// have changed since.
+	w.Header().Set("Cache-Control", "private, no-cache")
+	w.Header().Del("Pragma")
+	w.Header().Del("Expires")
+	w.Header().Set("ETag", etag)
+
+	for _, candidate := range strings.Split(r.Header.Get("If-None-Match"), ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == etag || candidate == "*" {
+			w.WriteHeader(http.StatusNotModified)
+			return true
+		}
+	}
+	return false
+}
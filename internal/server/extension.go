@@ -1,30 +1,24 @@
 package server
 
 import (
+	"bytes"
 	"encoding/json"
+	"fmt"
 	"log/slog"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/egemengol/kindlepathy/internal/core"
-	"github.com/gorilla/sessions"
 )
 
 // extension.go contains endpoints and middleware specific to the extension client
 
 // handleExtensionCheckAuth is a CORS-enabled endpoint to check authentication status
-func handleExtensionCheckAuth(logger *slog.Logger, sessionStore *sessions.CookieStore) http.Handler {
+func handleExtensionCheckAuth(auth *AuthService) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		session, err := sessionStore.Get(r, "kindlepathy")
-		if err != nil {
-			logger.Error("Error getting session", "error", err)
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
-		}
-
 		// Check if the user is authenticated
-		auth, ok := session.Values["authenticated"].(bool)
-		if !ok || !auth {
+		if !auth.IsAuthenticated(r) {
 			w.WriteHeader(http.StatusUnauthorized)
 			return
 		}
@@ -41,7 +35,22 @@ type ExtensionArticle struct {
 	URL string `json:"url"`
 }
 
-// handleExtensionPostContent handles cleaned content submission from the extension
+// maxExtensionBatch is the most articles the extension can send at once.
+const maxExtensionBatch = 100
+
+// ExtensionResult tells the extension what became of an article: the item
+// it was added as, or updated, when its URL was already in the library.
+type ExtensionResult struct {
+	URL     string `json:"url"`
+	ItemID  int64  `json:"item_id,omitempty"`
+	Created bool   `json:"created"`
+	Error   string `json:"error,omitempty"`
+}
+
+// handleExtensionPostContent handles cleaned content submission from the
+// extension, an article or an array of them. A single article is answered
+// with 201 when it's new and 200 when it updated an item, an array with the
+// result of each.
 func handleExtensionPostContent(logger *slog.Logger, c *core.Core, auth *AuthService) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		// Get user from context (populated by auth middleware)
@@ -52,40 +61,114 @@ func handleExtensionPostContent(logger *slog.Logger, c *core.Core, auth *AuthSer
 		}
 
 		// Parse request body
-		var content ExtensionArticle
-		if err := json.NewDecoder(r.Body).Decode(&content); err != nil {
+		var body json.RawMessage
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
 			logger.Error("Error decoding request body", "error", err)
 			http.Error(w, "Invalid request body", http.StatusBadRequest)
 			return
 		}
-
-		// Add item with uploaded content
-		_, err = c.AddItemWithUploadedContent(r.Context(), authedUser.ID, content.Article.Title, content.URL, content.Article.Content, time.Now())
+		batch := bytes.HasPrefix(body, []byte("["))
+		var articles []ExtensionArticle
+		if batch {
+			err = json.Unmarshal(body, &articles)
+		} else {
+			articles = make([]ExtensionArticle, 1)
+			err = json.Unmarshal(body, &articles[0])
+		}
 		if err != nil {
-			logger.Error("Error adding item with uploaded content", "error", err)
-			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			logger.Error("Error decoding request body", "error", err)
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		if len(articles) > maxExtensionBatch {
+			http.Error(w, fmt.Sprintf("At most %d articles at once", maxExtensionBatch), http.StatusRequestEntityTooLarge)
 			return
 		}
 
-		w.WriteHeader(http.StatusCreated)
+		results := make([]ExtensionResult, len(articles))
+		for i, article := range articles {
+			results[i].URL = article.URL
+			results[i].ItemID, results[i].Created, err = c.AddItemWithUploadedContent(r.Context(), authedUser.ID, article.Article.Title, article.URL, article.Article.Content, time.Now())
+			if err != nil {
+				if !batch {
+					logger.Error("Error adding item with uploaded content", "error", err)
+					http.Error(w, "Internal server error", http.StatusInternalServerError)
+					return
+				}
+				logger.Warn("Error adding item with uploaded content", "error", err, "url", article.URL)
+				results[i].Error = err.Error()
+			}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if batch {
+			json.NewEncoder(w).Encode(results)
+			return
+		}
+		if results[0].Created {
+			w.WriteHeader(http.StatusCreated)
+		}
+		json.NewEncoder(w).Encode(results[0])
 	})
 }
 
-// newCORSMiddleware creates a middleware that adds CORS headers to responses
-func newExtensionCORSMiddleware(logger *slog.Logger) func(http.Handler) http.Handler {
+// DefaultExtensionOrigins are the origins of the published extension. Firefox
+// gives every install an origin of its own, so any moz-extension:// one is
+// allowed: websites can't have one, only other extensions.
+var DefaultExtensionOrigins = []string{
+	"chrome-extension://eclacjdfoacbmgoiongjpmlaangpmbac",
+	"moz-extension://*",
+}
+
+var extensionSchemes = []string{"chrome-extension://", "moz-extension://", "safari-web-extension://"}
+
+// CheckExtensionOrigin returns why origin can't be allowed to call the
+// extension endpoints, nil if it can. Only extension origins can, or all of
+// a browser's, like moz-extension://*.
+func CheckExtensionOrigin(origin string) error {
+	for _, scheme := range extensionSchemes {
+		if id, ok := strings.CutPrefix(origin, scheme); ok && id != "" && !strings.ContainsAny(id, "/ ") && (id == "*" || !strings.Contains(id, "*")) {
+			return nil
+		}
+	}
+	return fmt.Errorf("invalid extension origin %q, expected one like chrome-extension://<id> or moz-extension://*", origin)
+}
+
+// extensionOriginAllowed matches the Origin of a request against the
+// allow-list.
+func extensionOriginAllowed(origins []string, origin string) bool {
+	for _, allowed := range origins {
+		if scheme, ok := strings.CutSuffix(allowed, "*"); ok && strings.HasPrefix(origin, scheme) && len(origin) > len(scheme) {
+			return true
+		}
+		if origin == allowed {
+			return true
+		}
+	}
+	return false
+}
+
+// newExtensionCORSMiddleware lets the extension call the endpoints with the
+// user's cookies. Requests from other origins are refused, browsers would
+// otherwise send the cookies along with a page's requests to them.
+// Requests without an Origin aren't from a page, and pass.
+func newExtensionCORSMiddleware(logger *slog.Logger, origins []string) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			// Log the request method and path
-			// logger.Info("CORS middleware invoked", "method", r.Method, "path", r.URL.Path)
+			w.Header().Add("Vary", "Origin")
 
-			// Get the origin from the request
 			origin := r.Header.Get("Origin")
-
-			// Set CORS headers
-			w.Header().Set("Access-Control-Allow-Origin", origin) // Allow the specific origin
-			w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-			w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
-			w.Header().Set("Access-Control-Allow-Credentials", "true") // Allow credentials
+			if origin != "" {
+				if !extensionOriginAllowed(origins, origin) {
+					logger.Warn("Refused extension request from origin", "origin", origin, "path", r.URL.Path)
+					http.Error(w, "Origin not allowed", http.StatusForbidden)
+					return
+				}
+				w.Header().Set("Access-Control-Allow-Origin", origin)
+				w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
+				w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+				w.Header().Set("Access-Control-Allow-Credentials", "true")
+			}
 
 			// Handle preflight requests
 			if r.Method == "OPTIONS" {
@@ -0,0 +1,82 @@
+package server
+
+import (
+	"log/slog"
+	"net/http"
+
+	"github.com/egemengol/kindlepathy/internal/core"
+)
+
+// POST /admin/extractors - Pin the extractor of a domain
+func handleAdminExtractorPost(c *core.Core, auth *AuthService, logger *slog.Logger) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authedUser, err := auth.GetAuthenticatedUser(r)
+		if err != nil {
+			auth.HandleAuthError(w, r, err)
+			return
+		}
+		if !authedUser.IsAdmin {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+
+		domain, extractor := r.FormValue("domain"), r.FormValue("extractor")
+		if err := c.SetDomainExtractor(r.Context(), domain, extractor); err != nil {
+			logger.Warn("Error setting domain extractor", "error", err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		logger.Info("Domain extractor set", "admin", authedUser.Username, "domain", domain, "extractor", extractor)
+
+		http.Redirect(w, r, "/settings/account", http.StatusSeeOther)
+	})
+}
+
+// POST /admin/extractors/delete - Go back to the default extractor for a domain
+func handleAdminExtractorDeletePost(c *core.Core, auth *AuthService, logger *slog.Logger) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authedUser, err := auth.GetAuthenticatedUser(r)
+		if err != nil {
+			auth.HandleAuthError(w, r, err)
+			return
+		}
+		if !authedUser.IsAdmin {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+
+		domain := r.FormValue("domain")
+		if err := c.SetDomainExtractor(r.Context(), domain, ""); err != nil {
+			logger.Warn("Error resetting domain extractor", "error", err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		logger.Info("Domain extractor reset", "admin", authedUser.Username, "domain", domain)
+
+		http.Redirect(w, r, "/settings/account", http.StatusSeeOther)
+	})
+}
+
+// POST /admin/readability/reload - Restart the readability sidecar, after its binary was replaced
+func handleAdminReadabilityReloadPost(c *core.Core, auth *AuthService, logger *slog.Logger) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authedUser, err := auth.GetAuthenticatedUser(r)
+		if err != nil {
+			auth.HandleAuthError(w, r, err)
+			return
+		}
+		if !authedUser.IsAdmin {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+
+		if err := c.ReloadReadability(r.Context()); err != nil {
+			logger.Error("Error reloading readability", "error", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		logger.Info("Readability reloaded", "admin", authedUser.Username)
+
+		http.Redirect(w, r, "/settings/account", http.StatusSeeOther)
+	})
+}
@@ -0,0 +1,125 @@
+package server
+
+import (
+	"bytes"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/egemengol/kindlepathy/internal/core"
+)
+
+// feeds.go lets users follow feeds from the integrations page, and move
+// them from and to their RSS reader as OPML.
+
+// POST /feeds - Follow a feed
+func handleFeedsPost(c *core.Core, auth *AuthService, logger *slog.Logger) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authedUser, err := auth.GetAuthenticatedUser(r)
+		if err != nil {
+			auth.HandleAuthError(w, r, err)
+			return
+		}
+
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, "Failed to parse form", http.StatusBadRequest)
+			return
+		}
+
+		if _, err := c.AddFeed(r.Context(), authedUser.ID, r.Form.Get("url"), r.Form.Get("title"), time.Now()); err != nil {
+			logger.Warn("Error adding feed", "error", err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		http.Redirect(w, r, "/settings/integrations", http.StatusSeeOther)
+	})
+}
+
+// POST /feeds/{id}/delete
+func handleFeedsDelete(c *core.Core, auth *AuthService, logger *slog.Logger) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authedUser, err := auth.GetAuthenticatedUser(r)
+		if err != nil {
+			auth.HandleAuthError(w, r, err)
+			return
+		}
+
+		feedID, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+		if err != nil {
+			http.Error(w, "Invalid feed ID", http.StatusBadRequest)
+			return
+		}
+
+		if err := c.DeleteFeed(r.Context(), authedUser.ID, feedID); err != nil {
+			logger.Error("Error deleting feed", "error", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		http.Redirect(w, r, "/settings/integrations", http.StatusSeeOther)
+	})
+}
+
+// POST /feeds/import - Follow the feeds of an OPML export
+func handleFeedsImport(c *core.Core, auth *AuthService, logger *slog.Logger) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authedUser, err := auth.GetAuthenticatedUser(r)
+		if err != nil {
+			auth.HandleAuthError(w, r, err)
+			return
+		}
+
+		r.Body = http.MaxBytesReader(w, r.Body, 5<<20)
+		if err := r.ParseMultipartForm(5 << 20); err != nil {
+			http.Error(w, "Failed to parse form, OPML files can be at most 5 MB", http.StatusBadRequest)
+			return
+		}
+		file, _, err := r.FormFile("opml")
+		if err != nil {
+			http.Error(w, "OPML file is required", http.StatusBadRequest)
+			return
+		}
+		defer file.Close()
+
+		result, err := c.ImportOPML(r.Context(), authedUser.ID, file, time.Now())
+		if err != nil {
+			if errors.Is(err, core.ErrNotOPML) {
+				http.Error(w, "Failed to import: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+			logger.Error("Error importing OPML", "error", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		for _, failure := range result.Failed {
+			logger.Warn("Failed to import feed", "url", failure.URL, "error", failure.Error)
+		}
+
+		http.Redirect(w, r, "/settings/integrations", http.StatusSeeOther)
+	})
+}
+
+// GET /feeds/export - The feeds followed as an OPML file
+func handleFeedsExport(c *core.Core, auth *AuthService, logger *slog.Logger) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authedUser, err := auth.GetAuthenticatedUser(r)
+		if err != nil {
+			auth.HandleAuthError(w, r, err)
+			return
+		}
+
+		var buf bytes.Buffer
+		if err := c.ExportOPML(r.Context(), authedUser.ID, &buf, time.Now()); err != nil {
+			logger.Error("Error exporting OPML", "error", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/x-opml; charset=utf-8")
+		w.Header().Set("Content-Disposition", `attachment; filename="kindlepathy-feeds.opml"`)
+		w.Write(buf.Bytes())
+	})
+}
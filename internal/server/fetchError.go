@@ -0,0 +1,60 @@
+package server
+
+import (
+	_ "embed"
+	"errors"
+	"fmt"
+	"html/template"
+	"log/slog"
+	"net/http"
+
+	"github.com/egemengol/kindlepathy/internal/core"
+)
+
+//go:embed fetch_error.html
+var TEMPLATE_FETCH_ERROR string
+
+// renderFetchError explains why the item couldn't be read and what to try
+// instead, in place of a bare 500.
+func renderFetchError(w http.ResponseWriter, r *http.Request, c *core.Core, tmpl *template.Template, logger *slog.Logger, itemID int64, readErr error) {
+	data := struct {
+		Reason     string
+		Detail     string
+		URL        string
+		RetryURL   string
+		ArchiveURL string
+		// ScreenshotURL keeps the page as a screenshot, if configured.
+		ScreenshotURL string
+	}{
+		Reason:   "Something went wrong reading the page.",
+		Detail:   readErr.Error(),
+		RetryURL: r.URL.RequestURI(),
+	}
+	status := http.StatusInternalServerError
+
+	var fetchErr *core.FetchError
+	if errors.As(readErr, &fetchErr) {
+		data.Reason = fetchErr.Reason()
+		status = http.StatusBadGateway
+		if fetchErr.Kind == core.FetchErrorNotFound {
+			status = http.StatusNotFound
+		}
+	}
+	if c.ScreenshotsConfigured() {
+		data.ScreenshotURL = fmt.Sprintf("/library/%d/screenshot", itemID)
+	}
+	if summary, err := c.GetItemSummary(r.Context(), itemID); err == nil {
+		data.URL = summary.URL
+	}
+	if r.URL.Query().Get("source") != "archive" {
+		data.ArchiveURL = fmt.Sprintf("/read/%d?source=archive", itemID)
+	} else {
+		// Trying again from the archive goes back to the site.
+		data.RetryURL = fmt.Sprintf("/read/%d", itemID)
+	}
+
+	w.WriteHeader(status)
+	if err := tmpl.Execute(w, data); err != nil {
+		logger.Error("Error executing template", "error", err)
+	}
+}
@@ -0,0 +1,81 @@
+package server
+
+import (
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/egemengol/kindlepathy/internal/core"
+	db "github.com/egemengol/kindlepathy/internal/db/generated"
+)
+
+// POST /admin/flags - Turn a feature flag on or off for the instance
+func handleAdminFlagPost(c *core.Core, auth *AuthService, logger *slog.Logger) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authedUser, err := auth.GetAuthenticatedUser(r)
+		if err != nil {
+			auth.HandleAuthError(w, r, err)
+			return
+		}
+		if !authedUser.IsAdmin {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+
+		name := r.FormValue("name")
+		enabled := r.FormValue("enabled") == "on"
+		if err := c.SetInstanceFlag(r.Context(), name, enabled); err != nil {
+			logger.Warn("Error setting feature flag", "error", err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		logger.Info("Feature flag set", "admin", authedUser.Username, "flag", name, "enabled", enabled)
+
+		http.Redirect(w, r, "/settings/account", http.StatusSeeOther)
+	})
+}
+
+// POST /admin/flags/user - Override a feature flag for a user, "default"
+// removes the override
+func handleAdminUserFlagPost(c *core.Core, auth *AuthService, queries *db.Queries, logger *slog.Logger) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authedUser, err := auth.GetAuthenticatedUser(r)
+		if err != nil {
+			auth.HandleAuthError(w, r, err)
+			return
+		}
+		if !authedUser.IsAdmin {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+
+		user, err := queries.UsersGetByName(r.Context(), strings.TrimSpace(r.FormValue("username")))
+		if err != nil {
+			http.Error(w, "User not found", http.StatusNotFound)
+			return
+		}
+
+		var enabled *bool
+		switch r.FormValue("state") {
+		case "on":
+			enabled = new(bool)
+			*enabled = true
+		case "off":
+			enabled = new(bool)
+		case "default":
+		default:
+			http.Error(w, "Invalid state", http.StatusBadRequest)
+			return
+		}
+
+		name := r.FormValue("name")
+		if err := c.SetUserFlag(r.Context(), user.ID, name, enabled); err != nil {
+			logger.Warn("Error setting user feature flag", "error", err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		logger.Info("User feature flag set", "admin", authedUser.Username, "user", user.Username, "flag", name, "state", r.FormValue("state"))
+
+		http.Redirect(w, r, "/settings/account", http.StatusSeeOther)
+	})
+}
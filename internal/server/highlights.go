@@ -0,0 +1,145 @@
+package server
+
+import (
+	"database/sql"
+	_ "embed"
+	"errors"
+	"html/template"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/egemengol/kindlepathy/internal/core"
+)
+
+//go:embed highlights.html
+var TEMPLATE_HIGHLIGHTS string
+
+// POST /read/{id}/highlight - Keep the selected passage, given as its quote
+// and the text before it, with an optional note
+func handleReadHighlightPost(c *core.Core, auth *AuthService, logger *slog.Logger) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authedUser, err := auth.GetAuthenticatedUser(r)
+		if err != nil {
+			auth.HandleAuthError(w, r, err)
+			return
+		}
+
+		itemID, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+		if err != nil {
+			http.Error(w, "Invalid item ID", http.StatusBadRequest)
+			return
+		}
+		if err := auth.RequireOwnership(r.Context(), authedUser.ID, itemID); err != nil {
+			auth.HandleAuthError(w, r, err)
+			return
+		}
+
+		id, err := c.AddHighlight(r.Context(), authedUser.ID, itemID, r.FormValue("quote"), r.FormValue("prefix"), r.FormValue("note"), time.Now())
+		if err != nil {
+			logger.Warn("Error adding highlight", "error", err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		writeJSON(w, http.StatusCreated, map[string]int64{"id": id})
+	})
+}
+
+// renderHighlights marks the highlights of the item in its content. The
+// content is read without them when they can't be rendered.
+func renderHighlights(r *http.Request, c *core.Core, logger *slog.Logger, itemID int64, contentHTML string) string {
+	highlights, err := c.ListItemHighlights(r.Context(), itemID)
+	if err != nil {
+		logger.Warn("Error listing highlights", "error", err)
+		return contentHTML
+	}
+	marked, err := core.RenderHighlights(contentHTML, highlights)
+	if err != nil {
+		logger.Warn("Error rendering highlights", "error", err)
+		return contentHTML
+	}
+	return marked
+}
+
+// GET /highlights - Review the highlights of every item
+func handleHighlightsGet(c *core.Core, auth *AuthService, logger *slog.Logger) http.Handler {
+	tmpl := template.Must(template.New("highlights").Parse(TEMPLATE_HIGHLIGHTS))
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authedUser, err := auth.GetAuthenticatedUser(r)
+		if err != nil {
+			auth.HandleAuthError(w, r, err)
+			return
+		}
+
+		items, err := c.ListHighlights(r.Context(), authedUser.ID)
+		if err != nil {
+			logger.Error("Error listing highlights", "error", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		data := struct {
+			Items []core.ItemHighlights
+		}{
+			Items: items,
+		}
+		if err := tmpl.ExecuteTemplate(w, "highlights", data); err != nil {
+			logger.Error("Error executing template", "error", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+	})
+}
+
+// POST /highlights/{id}/delete
+func handleHighlightDeletePost(c *core.Core, auth *AuthService, logger *slog.Logger) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authedUser, err := auth.GetAuthenticatedUser(r)
+		if err != nil {
+			auth.HandleAuthError(w, r, err)
+			return
+		}
+
+		highlightID, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+		if err != nil {
+			http.Error(w, "Invalid highlight ID", http.StatusBadRequest)
+			return
+		}
+		err = c.DeleteHighlight(r.Context(), authedUser.ID, highlightID)
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, "Highlight not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			logger.Error("Error deleting highlight", "error", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		http.Redirect(w, r, "/highlights", http.StatusSeeOther)
+	})
+}
+
+// GET /highlights/export.md - Every highlight as Markdown
+func handleHighlightsExport(c *core.Core, auth *AuthService, logger *slog.Logger) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authedUser, err := auth.GetAuthenticatedUser(r)
+		if err != nil {
+			auth.HandleAuthError(w, r, err)
+			return
+		}
+
+		items, err := c.ListHighlights(r.Context(), authedUser.ID)
+		if err != nil {
+			logger.Error("Error listing highlights", "error", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/markdown; charset=utf-8")
+		w.Header().Set("Content-Disposition", `attachment; filename="highlights.md"`)
+		w.Write([]byte(core.HighlightsMarkdown(items)))
+	})
+}
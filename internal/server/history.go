@@ -0,0 +1,98 @@
+package server
+
+import (
+	"errors"
+	"html/template"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/egemengol/kindlepathy/internal/core"
+)
+
+type historyData struct {
+	Item    *core.Item
+	History []core.ChapterVisit
+}
+
+// GET /library/{id}/history - The pages the item has been on
+func handleLibraryItemHistoryGet(c *core.Core, auth *AuthService, logger *slog.Logger) http.Handler {
+	tmpl := template.Must(template.New("library").Parse(TEMPLATE_LIBRARY))
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authedUser, err := auth.GetAuthenticatedUser(r)
+		if err != nil {
+			auth.HandleAuthError(w, r, err)
+			return
+		}
+
+		itemID, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+		if err != nil {
+			http.Error(w, "Invalid item ID", http.StatusBadRequest)
+			return
+		}
+		if err := auth.RequireOwnership(r.Context(), authedUser.ID, itemID); err != nil {
+			auth.HandleAuthError(w, r, err)
+			return
+		}
+
+		item, err := c.GetItem(r.Context(), authedUser.ID, itemID)
+		if err != nil {
+			logger.Error("Error getting item", "error", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		history, err := c.ChapterHistory(r.Context(), itemID)
+		if err != nil {
+			logger.Error("Error getting chapter history", "error", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		if err := tmpl.ExecuteTemplate(w, "library-history", historyData{Item: item, History: history}); err != nil {
+			logger.Error("Error executing template", "error", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+	})
+}
+
+// POST /library/{id}/history/{chapter} - Go back to a page of the history
+func handleLibraryItemHistoryPost(c *core.Core, auth *AuthService, logger *slog.Logger) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authedUser, err := auth.GetAuthenticatedUser(r)
+		if err != nil {
+			auth.HandleAuthError(w, r, err)
+			return
+		}
+
+		itemID, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+		if err != nil {
+			http.Error(w, "Invalid item ID", http.StatusBadRequest)
+			return
+		}
+		chapterID, err := strconv.ParseInt(r.PathValue("chapter"), 10, 64)
+		if err != nil {
+			http.Error(w, "Invalid chapter ID", http.StatusBadRequest)
+			return
+		}
+		if err := auth.RequireOwnership(r.Context(), authedUser.ID, itemID); err != nil {
+			auth.HandleAuthError(w, r, err)
+			return
+		}
+
+		err = c.JumpToChapter(r.Context(), itemID, chapterID, time.Now())
+		if errors.Is(err, core.ErrChapterNotFound) {
+			http.Error(w, "Chapter not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			logger.Error("Error jumping to chapter", "error", err, "itemID", itemID)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		http.Redirect(w, r, "/read/"+strconv.FormatInt(itemID, 10), http.StatusSeeOther)
+	})
+}
@@ -0,0 +1,58 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"github.com/egemengol/kindlepathy/internal/core"
+)
+
+// GET /img - An image of an article, fetched by the server for the Kindle
+func handleImageProxy(c *core.Core, auth *AuthService, logger *slog.Logger) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, err := auth.GetAuthenticatedUser(r); err != nil {
+			auth.HandleAuthError(w, r, err)
+			return
+		}
+
+		src := r.URL.Query().Get("src")
+		if src == "" {
+			http.Error(w, "Missing src", http.StatusBadRequest)
+			return
+		}
+
+		image, err := c.ProxyImage(r.Context(), src, r.URL.Query().Get("ref"), r.URL.Query().Get("eink"))
+		if errors.Is(err, core.ErrInvalidImageURL) {
+			http.Error(w, "Invalid src", http.StatusBadRequest)
+			return
+		}
+		if errors.Is(err, core.ErrNotAnImage) {
+			http.Error(w, "Not an image", http.StatusUnsupportedMediaType)
+			return
+		}
+		if err != nil {
+			logger.Warn("Error proxying image", "error", err, "src", src)
+			http.Error(w, "Failed to fetch image", http.StatusBadGateway)
+			return
+		}
+
+		w.Header().Set("Content-Type", image.ContentType)
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		w.Header().Set("Cache-Control", "private, max-age=604800")
+		w.Write(image.Data)
+	})
+}
+
+// proxyImages points the images of the item's content to the image proxy,
+// in the user's e-ink mode.
+func proxyImages(ctx context.Context, c *core.Core, logger *slog.Logger, user AuthenticatedUser, itemID int64, content string) string {
+	var pageURL string
+	if summary, err := c.GetItemSummary(ctx, itemID); err != nil {
+		logger.Warn("Error getting item for the image proxy", "error", err)
+	} else {
+		pageURL = summary.URL
+	}
+	return c.ProxyImages(content, pageURL, user.EinkMode)
+}
@@ -0,0 +1,244 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"html/template"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	db "github.com/egemengol/kindlepathy/internal/db/generated"
+)
+
+// impersonation.go lets admins use the app as another user to debug their
+// reports. The admin gets a separate, short session of the user, every
+// request of which goes to the audit log, and every page shows a banner.
+// The login of the account, its password, username and email, and deleting
+// it stay the user's.
+
+const impersonationMaxAge = time.Hour
+
+// audit records an action of the actor on the user's account.
+func (a *AuthService) audit(ctx context.Context, actorID, userID int64, action, detail, ip string) error {
+	return a.queries.AuditLogAdd(ctx, db.AuditLogAddParams{
+		ActorID: actorID,
+		UserID:  userID,
+		Action:  action,
+		Detail:  detail,
+		Ip:      ip,
+		Ts:      time.Now().Unix(),
+	})
+}
+
+// StartImpersonation switches the admin's cookie to a new session of the
+// target user, remembering the admin's own session to return to.
+func (a *AuthService) StartImpersonation(w http.ResponseWriter, r *http.Request, admin AuthenticatedUser, target db.User) error {
+	session, err := a.sessionStore.Get(r, "kindlepathy")
+	if err != nil {
+		return err
+	}
+	adminSessionID, ok := session.Values["session_id"].(string)
+	if !ok {
+		return fmt.Errorf("user not found in session")
+	}
+
+	id, err := a.addSession(r, target.ID, admin.ID, time.Now())
+	if err != nil {
+		return err
+	}
+	if err := a.audit(r.Context(), admin.ID, target.ID, "impersonation_start", "", a.clientIP(r)); err != nil {
+		return fmt.Errorf("failed to audit impersonation: %w", err)
+	}
+
+	session.Values = map[interface{}]interface{}{
+		"session_id":       id,
+		"admin_session_id": adminSessionID,
+	}
+	return session.Save(r, w)
+}
+
+// StopImpersonation ends the impersonated session and returns the admin to
+// their own.
+func (a *AuthService) StopImpersonation(w http.ResponseWriter, r *http.Request, user AuthenticatedUser) error {
+	session, err := a.sessionStore.Get(r, "kindlepathy")
+	if err != nil {
+		return err
+	}
+	if err := a.queries.SessionsDelete(r.Context(), user.SessionID); err != nil {
+		return fmt.Errorf("failed to delete session: %w", err)
+	}
+	if err := a.audit(r.Context(), user.Impersonator.ID, user.ID, "impersonation_stop", "", a.clientIP(r)); err != nil {
+		return fmt.Errorf("failed to audit impersonation: %w", err)
+	}
+
+	adminSessionID, _ := session.Values["admin_session_id"].(string)
+	session.Values = map[interface{}]interface{}{"session_id": adminSessionID}
+	return session.Save(r, w)
+}
+
+// Impersonator is the admin behind an impersonated session.
+type Impersonator struct {
+	ID       int64
+	Username string
+}
+
+// impersonated audits the requests of an impersonated session and marks its
+// pages with a banner.
+func impersonated(auth *AuthService, logger *slog.Logger, user AuthenticatedUser, w http.ResponseWriter, r *http.Request, next http.Handler) {
+	detail := r.Method + " " + r.URL.RequestURI()
+	if err := auth.audit(r.Context(), user.Impersonator.ID, user.ID, "impersonated_request", detail, auth.clientIP(r)); err != nil {
+		// Nothing happens unaudited.
+		logger.Error("Error auditing impersonated request", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	bw := &bannerWriter{ResponseWriter: w, status: http.StatusOK}
+	next.ServeHTTP(bw, r)
+
+	body := bw.buf.Bytes()
+	if w.Header().Get("Content-Type") == "" && len(body) > 0 {
+		// What net/http would do on the first write.
+		w.Header().Set("Content-Type", http.DetectContentType(body))
+	}
+	if strings.HasPrefix(w.Header().Get("Content-Type"), "text/html") {
+		body = insertBanner(body, user)
+		w.Header().Del("Content-Length")
+	}
+	w.WriteHeader(bw.status)
+	w.Write(body)
+}
+
+var impersonationBanner = template.Must(template.New("banner").Parse(`<form method="post" action="/admin/impersonate/stop" style="position:sticky;top:0;z-index:1000;margin:0;padding:8px 16px;background:#b00020;color:#fff;font:14px sans-serif;text-align:center">
+Viewing as <strong>{{.Username}}</strong>, every action is logged. Signed in as admin {{.Impersonator.Username}}.
+<button type="submit">Stop impersonating</button>
+</form>`))
+
+// insertBanner puts the banner right after the body tag of the page.
+func insertBanner(page []byte, user AuthenticatedUser) []byte {
+	var banner bytes.Buffer
+	if err := impersonationBanner.Execute(&banner, user); err != nil {
+		return page
+	}
+	i := bytes.Index(bytes.ToLower(page), []byte("<body"))
+	if i < 0 {
+		return append(banner.Bytes(), page...)
+	}
+	end := bytes.IndexByte(page[i:], '>')
+	if end < 0 {
+		return page
+	}
+	at := i + end + 1
+	out := make([]byte, 0, len(page)+banner.Len())
+	out = append(out, page[:at]...)
+	out = append(out, banner.Bytes()...)
+	return append(out, page[at:]...)
+}
+
+// bannerWriter holds the response back so the banner can be added.
+type bannerWriter struct {
+	http.ResponseWriter
+	buf    bytes.Buffer
+	status int
+}
+
+func (bw *bannerWriter) WriteHeader(status int) {
+	bw.status = status
+}
+
+func (bw *bannerWriter) Write(b []byte) (int, error) {
+	return bw.buf.Write(b)
+}
+
+// POST /admin/impersonate
+func handleImpersonatePost(auth *AuthService, queries *db.Queries, logger *slog.Logger) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authedUser, err := auth.GetAuthenticatedUser(r)
+		if err != nil {
+			auth.HandleAuthError(w, r, err)
+			return
+		}
+		if !authedUser.IsAdmin || authedUser.Impersonator != nil {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+
+		target, err := queries.UsersGetByName(r.Context(), strings.TrimSpace(r.FormValue("username")))
+		if err != nil {
+			http.Error(w, "User not found", http.StatusNotFound)
+			return
+		}
+		if target.ID == authedUser.ID {
+			http.Error(w, "You cannot impersonate yourself", http.StatusBadRequest)
+			return
+		}
+		if target.DisabledTs != nil {
+			http.Error(w, "The account is disabled", http.StatusBadRequest)
+			return
+		}
+
+		if err := auth.StartImpersonation(w, r, authedUser, target); err != nil {
+			logger.Error("Error starting impersonation", "error", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		logger.Warn("Admin started impersonating", "admin", authedUser.Username, "user", target.Username, "ip", auth.clientIP(r))
+
+		http.Redirect(w, r, landingPath(target), http.StatusSeeOther)
+	})
+}
+
+// POST /admin/impersonate/stop
+func handleImpersonateStopPost(auth *AuthService, logger *slog.Logger) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authedUser, err := auth.GetAuthenticatedUser(r)
+		if err != nil {
+			auth.HandleAuthError(w, r, err)
+			return
+		}
+		if authedUser.Impersonator == nil {
+			http.Error(w, "Not impersonating", http.StatusBadRequest)
+			return
+		}
+
+		if err := auth.StopImpersonation(w, r, authedUser); err != nil {
+			logger.Error("Error stopping impersonation", "error", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		http.Redirect(w, r, "/settings/account", http.StatusSeeOther)
+	})
+}
+
+// AuditEntry is a line of the audit log as shown to admins.
+type AuditEntry struct {
+	Actor  string
+	User   string
+	Action string
+	Detail string
+	IP     string
+	Time   time.Time
+}
+
+func (a *AuthService) ListAudit(ctx context.Context, limit int) ([]AuditEntry, error) {
+	rows, err := a.queries.AuditLogList(ctx, int64(limit))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list audit log: %w", err)
+	}
+	entries := make([]AuditEntry, len(rows))
+	for i, row := range rows {
+		entries[i] = AuditEntry{
+			Actor:  row.ActorUsername,
+			User:   row.Username,
+			Action: row.Action,
+			Detail: row.Detail,
+			IP:     row.Ip,
+			Time:   time.Unix(row.Ts, 0),
+		}
+	}
+	return entries, nil
+}
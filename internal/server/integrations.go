@@ -0,0 +1,441 @@
+package server
+
+import (
+	_ "embed"
+	"encoding/json"
+	"html/template"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/egemengol/kindlepathy/internal/core"
+)
+
+//go:embed integrations.html
+var TEMPLATE_INTEGRATIONS string
+
+type integrationSource struct {
+	Kind          string
+	Name          string
+	Description   string
+	URLHint       string
+	TokenLabel    string
+	NeedsUsername bool
+	Config        *core.Integration
+}
+
+var integrationSources = []integrationSource{
+	{
+		Kind:        core.IntegrationMiniflux,
+		Name:        "Miniflux",
+		Description: "Pull starred or unread entries from a Miniflux instance.",
+		URLHint:     "https://miniflux.example.com",
+		TokenLabel:  "API token",
+	},
+	{
+		Kind:          core.IntegrationFever,
+		Name:          "Fever API",
+		Description:   "Pull saved or unread items from any backend speaking the Fever API, such as FreshRSS or Tiny Tiny RSS.",
+		URLHint:       "https://freshrss.example.com/api/fever.php",
+		TokenLabel:    "API password",
+		NeedsUsername: true,
+	},
+	{
+		Kind:          core.IntegrationGReader,
+		Name:          "Google Reader API",
+		Description:   "Pull starred or unread items from any backend speaking the Google Reader API, such as FreshRSS.",
+		URLHint:       "https://freshrss.example.com/api/greader.php",
+		TokenLabel:    "API password",
+		NeedsUsername: true,
+	},
+}
+
+// GET /settings/integrations
+func handleIntegrationsGet(c *core.Core, auth *AuthService, logger *slog.Logger) http.Handler {
+	tmpl := template.Must(template.New("integrations").Parse(TEMPLATE_INTEGRATIONS))
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authedUser, err := auth.GetAuthenticatedUser(r)
+		if err != nil {
+			auth.HandleAuthError(w, r, err)
+			return
+		}
+
+		renderIntegrations(w, r, c, tmpl, logger, authedUser.ID, "")
+	})
+}
+
+// renderIntegrations renders the settings page. A freshly created API token
+// is passed in to be shown once, it is never stored in plain text.
+func renderIntegrations(w http.ResponseWriter, r *http.Request, c *core.Core, tmpl *template.Template, logger *slog.Logger, userID int64, newToken string) {
+	var sources []integrationSource
+	for _, source := range integrationSources {
+		var err error
+		source.Config, err = c.GetIntegration(r.Context(), userID, source.Kind)
+		if err != nil {
+			logger.Error("Error getting integration", "error", err, "kind", source.Kind)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		sources = append(sources, source)
+	}
+
+	feeds, err := c.ListFeeds(r.Context(), userID)
+	if err != nil {
+		logger.Error("Error listing feeds", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	chatLinks, err := c.ListChatLinks(r.Context(), userID)
+	if err != nil {
+		logger.Error("Error listing chat links", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	apiTokens, err := c.ListAPITokens(r.Context(), userID)
+	if err != nil {
+		logger.Error("Error listing API tokens", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	siteCredentials, err := c.ListSiteCredentials(r.Context(), userID)
+	if err != nil {
+		logger.Error("Error listing site credentials", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	inboundAddress, err := c.InboundAddress(r.Context(), userID)
+	if err != nil {
+		logger.Error("Error getting inbound address", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	data := struct {
+		Sources         []integrationSource
+		Feeds           []core.Feed
+		ChatLinks       []core.ChatLink
+		LinkCode        string
+		APITokens       []core.APIToken
+		NewToken        string
+		ServerURL       string
+		Bookmarklet     template.URL
+		SiteCredentials []core.SiteCredential
+		InboundDomain   string
+		InboundAddress  string
+	}{
+		Sources:         sources,
+		Feeds:           feeds,
+		ChatLinks:       chatLinks,
+		LinkCode:        r.URL.Query().Get("code"),
+		APITokens:       apiTokens,
+		NewToken:        newToken,
+		ServerURL:       requestBaseURL(r),
+		Bookmarklet:     bookmarklet(requestBaseURL(r)),
+		SiteCredentials: siteCredentials,
+		InboundDomain:   c.InboundDomain(),
+		InboundAddress:  inboundAddress,
+	}
+
+	if err := tmpl.ExecuteTemplate(w, "integrations", data); err != nil {
+		logger.Error("Error executing template", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+}
+
+// requestBaseURL guesses the URL the instance is reached at, for showing
+// in setup instructions.
+func requestBaseURL(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https" {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host
+}
+
+// bookmarklet is a javascript: link that sends the page it's clicked on to
+// GET /add of the instance.
+func bookmarklet(serverURL string) template.URL {
+	quoted, _ := json.Marshal(serverURL + "/add?url=")
+	return template.URL("javascript:void(location.href=" + string(quoted) + "+encodeURIComponent(location.href))")
+}
+
+// POST /settings/integrations/{kind}
+func handleIntegrationsPost(c *core.Core, auth *AuthService, logger *slog.Logger) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authedUser, err := auth.GetAuthenticatedUser(r)
+		if err != nil {
+			auth.HandleAuthError(w, r, err)
+			return
+		}
+
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, "Failed to parse form", http.StatusBadRequest)
+			return
+		}
+
+		err = c.SaveIntegration(r.Context(), authedUser.ID, core.Integration{
+			Kind:        r.PathValue("kind"),
+			BaseURL:     r.Form.Get("base_url"),
+			Username:    r.Form.Get("username"),
+			Token:       r.Form.Get("token"),
+			FetchFilter: r.Form.Get("fetch_filter"),
+			MarkRead:    r.Form.Get("mark_read") != "",
+		})
+		if err != nil {
+			logger.Warn("Error saving integration", "error", err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		http.Redirect(w, r, "/settings/integrations", http.StatusSeeOther)
+	})
+}
+
+// POST /settings/integrations/{kind}/sync
+func handleIntegrationsSync(c *core.Core, auth *AuthService, logger *slog.Logger) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authedUser, err := auth.GetAuthenticatedUser(r)
+		if err != nil {
+			auth.HandleAuthError(w, r, err)
+			return
+		}
+
+		if err := c.SyncIntegration(r.Context(), authedUser.ID, r.PathValue("kind"), time.Now()); err != nil {
+			logger.Warn("Error syncing integration", "error", err)
+			http.Error(w, "Sync failed: "+err.Error(), http.StatusBadGateway)
+			return
+		}
+
+		http.Redirect(w, r, "/library", http.StatusSeeOther)
+	})
+}
+
+// POST /settings/integrations/{kind}/delete
+func handleIntegrationsDelete(c *core.Core, auth *AuthService, logger *slog.Logger) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authedUser, err := auth.GetAuthenticatedUser(r)
+		if err != nil {
+			auth.HandleAuthError(w, r, err)
+			return
+		}
+
+		if err := c.DeleteIntegration(r.Context(), authedUser.ID, r.PathValue("kind")); err != nil {
+			logger.Error("Error deleting integration", "error", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		http.Redirect(w, r, "/settings/integrations", http.StatusSeeOther)
+	})
+}
+
+// POST /settings/chats/code - Generate a code for linking a chat bot
+func handleChatLinkCodePost(c *core.Core, auth *AuthService, logger *slog.Logger) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authedUser, err := auth.GetAuthenticatedUser(r)
+		if err != nil {
+			auth.HandleAuthError(w, r, err)
+			return
+		}
+
+		code, err := c.CreateLinkCode(r.Context(), authedUser.ID, time.Now())
+		if err != nil {
+			logger.Error("Error creating link code", "error", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		http.Redirect(w, r, "/settings/integrations?code="+code, http.StatusSeeOther)
+	})
+}
+
+// POST /settings/chats/unlink
+func handleChatUnlinkPost(c *core.Core, auth *AuthService, logger *slog.Logger) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authedUser, err := auth.GetAuthenticatedUser(r)
+		if err != nil {
+			auth.HandleAuthError(w, r, err)
+			return
+		}
+
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, "Failed to parse form", http.StatusBadRequest)
+			return
+		}
+
+		if err := c.UnlinkChat(r.Context(), authedUser.ID, r.Form.Get("platform"), r.Form.Get("chat_id")); err != nil {
+			logger.Error("Error unlinking chat", "error", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		http.Redirect(w, r, "/settings/integrations", http.StatusSeeOther)
+	})
+}
+
+// POST /settings/newsletters/address - Give the user a new address for
+// newsletters, replacing the one they had
+func handleInboundAddressPost(c *core.Core, auth *AuthService, logger *slog.Logger) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authedUser, err := auth.GetAuthenticatedUser(r)
+		if err != nil {
+			auth.HandleAuthError(w, r, err)
+			return
+		}
+
+		if c.InboundDomain() == "" {
+			http.Error(w, "Receiving mail is not configured on this server", http.StatusNotFound)
+			return
+		}
+		if _, err := c.NewInboundAddress(r.Context(), authedUser.ID, time.Now()); err != nil {
+			logger.Error("Error creating inbound address", "error", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		http.Redirect(w, r, "/settings/integrations", http.StatusSeeOther)
+	})
+}
+
+// POST /settings/tokens - Create an API token and show it once
+func handleAPITokensPost(c *core.Core, auth *AuthService, logger *slog.Logger) http.Handler {
+	tmpl := template.Must(template.New("integrations").Parse(TEMPLATE_INTEGRATIONS))
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authedUser, err := auth.GetAuthenticatedUser(r)
+		if err != nil {
+			auth.HandleAuthError(w, r, err)
+			return
+		}
+
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, "Failed to parse form", http.StatusBadRequest)
+			return
+		}
+
+		token, err := c.CreateAPIToken(r.Context(), authedUser.ID, r.Form.Get("name"), time.Now())
+		if err != nil {
+			logger.Warn("Error creating API token", "error", err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		renderIntegrations(w, r, c, tmpl, logger, authedUser.ID, token)
+	})
+}
+
+// POST /settings/tokens/{id}/delete
+func handleAPITokensDelete(c *core.Core, auth *AuthService, logger *slog.Logger) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authedUser, err := auth.GetAuthenticatedUser(r)
+		if err != nil {
+			auth.HandleAuthError(w, r, err)
+			return
+		}
+
+		tokenID, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+		if err != nil {
+			http.Error(w, "Invalid token ID", http.StatusBadRequest)
+			return
+		}
+
+		if err := c.DeleteAPIToken(r.Context(), authedUser.ID, tokenID); err != nil {
+			logger.Error("Error deleting API token", "error", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		http.Redirect(w, r, "/settings/integrations", http.StatusSeeOther)
+	})
+}
+
+// POST /settings/cookies - Store the cookies of a site, pasted as a Cookie
+// header
+func handleSiteCookiesPost(c *core.Core, auth *AuthService, logger *slog.Logger) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authedUser, err := auth.GetAuthenticatedUser(r)
+		if err != nil {
+			auth.HandleAuthError(w, r, err)
+			return
+		}
+
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, "Failed to parse form", http.StatusBadRequest)
+			return
+		}
+
+		err = c.SetSiteCookies(r.Context(), authedUser.ID, r.Form.Get("domain"), r.Form.Get("cookies"), time.Now())
+		if err != nil {
+			logger.Warn("Error storing site cookies", "error", err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		http.Redirect(w, r, "/settings/integrations", http.StatusSeeOther)
+	})
+}
+
+// POST /settings/cookies/import - Store the cookies of a cookies.txt export
+func handleSiteCookiesImport(c *core.Core, auth *AuthService, logger *slog.Logger) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authedUser, err := auth.GetAuthenticatedUser(r)
+		if err != nil {
+			auth.HandleAuthError(w, r, err)
+			return
+		}
+
+		r.Body = http.MaxBytesReader(w, r.Body, 2<<20)
+		if err := r.ParseMultipartForm(1 << 20); err != nil {
+			http.Error(w, "Failed to parse form, cookies.txt files can be at most 2 MB", http.StatusBadRequest)
+			return
+		}
+		file, _, err := r.FormFile("cookies")
+		if err != nil {
+			http.Error(w, "cookies.txt file is required", http.StatusBadRequest)
+			return
+		}
+		defer file.Close()
+
+		sites, err := c.ImportCookiesTxt(r.Context(), authedUser.ID, file, time.Now())
+		if err != nil {
+			logger.Warn("Error importing cookies", "error", err)
+			http.Error(w, "Failed to import: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		logger.Info("Imported cookies", "userID", authedUser.ID, "sites", sites)
+
+		http.Redirect(w, r, "/settings/integrations", http.StatusSeeOther)
+	})
+}
+
+// POST /settings/cookies/{id}/delete
+func handleSiteCookiesDelete(c *core.Core, auth *AuthService, logger *slog.Logger) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authedUser, err := auth.GetAuthenticatedUser(r)
+		if err != nil {
+			auth.HandleAuthError(w, r, err)
+			return
+		}
+
+		credentialID, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+		if err != nil {
+			http.Error(w, "Invalid site ID", http.StatusBadRequest)
+			return
+		}
+
+		if err := c.DeleteSiteCredential(r.Context(), authedUser.ID, credentialID); err != nil {
+			logger.Error("Error deleting site credential", "error", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		http.Redirect(w, r, "/settings/integrations", http.StatusSeeOther)
+	})
+}
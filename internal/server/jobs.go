@@ -0,0 +1,98 @@
+package server
+
+import (
+	"database/sql"
+	_ "embed"
+	"errors"
+	"html/template"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/egemengol/kindlepathy/internal/core"
+)
+
+//go:embed jobs.html
+var TEMPLATE_JOBS string
+
+// jobsListed is how many of the latest jobs the status page shows.
+const jobsListed = 200
+
+// GET /admin/jobs - The background jobs, how many are waiting and why the
+// last ones failed
+func handleAdminJobsGet(c *core.Core, auth *AuthService, logger *slog.Logger) http.Handler {
+	tmpl := template.Must(template.New("jobs").Parse(TEMPLATE_JOBS))
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authedUser, err := auth.GetAuthenticatedUser(r)
+		if err != nil {
+			auth.HandleAuthError(w, r, err)
+			return
+		}
+		if !authedUser.IsAdmin {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+
+		counts, err := c.CountJobs(r.Context())
+		if err != nil {
+			logger.Error("Error counting jobs", "error", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		jobs, err := c.ListJobs(r.Context(), jobsListed)
+		if err != nil {
+			logger.Error("Error listing jobs", "error", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		data := struct {
+			Counts map[string]int64
+			Jobs   []core.Job
+		}{
+			Counts: counts,
+			Jobs:   jobs,
+		}
+		if err := tmpl.ExecuteTemplate(w, "jobs", data); err != nil {
+			logger.Error("Error executing template", "error", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+	})
+}
+
+// POST /admin/jobs/{id}/retry - Run a failed job again
+func handleAdminJobRetryPost(c *core.Core, auth *AuthService, logger *slog.Logger) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authedUser, err := auth.GetAuthenticatedUser(r)
+		if err != nil {
+			auth.HandleAuthError(w, r, err)
+			return
+		}
+		if !authedUser.IsAdmin {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+
+		jobID, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+		if err != nil {
+			http.Error(w, "Invalid job ID", http.StatusBadRequest)
+			return
+		}
+		err = c.RetryJob(r.Context(), jobID, time.Now())
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, "Failed job not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			logger.Error("Error retrying job", "error", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		logger.Info("Job retried", "admin", authedUser.Username, "jobID", jobID)
+
+		http.Redirect(w, r, "/admin/jobs", http.StatusSeeOther)
+	})
+}
@@ -1,20 +1,45 @@
 package server
 
 import (
+	"archive/zip"
+	"bytes"
 	_ "embed"
+	"errors"
+	"fmt"
 	"html/template"
+	"io"
 	"log/slog"
+	"mime"
 	"net/http"
+	"net/url"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/egemengol/kindlepathy/internal/core"
 	db "github.com/egemengol/kindlepathy/internal/db/generated"
+	"github.com/egemengol/kindlepathy/internal/pdf"
 )
 
 //go:embed library.html
 var TEMPLATE_LIBRARY string
 
+type libraryData struct {
+	Items           []core.Item
+	ContinueReading *core.ItemSummary
+	SeriesView      bool
+	Series          []core.Series
+	// State is the state the items are filtered by, all of them when empty.
+	State string
+	// SearchView shows the results of searching for Query instead of the
+	// items.
+	SearchView bool
+	Query      string
+	Results    []core.SearchResult
+	// Added is what became of the URLs of a bulk add.
+	Added []core.AddResult
+}
+
 // GET /library
 func handleLibraryGet(c *core.Core, auth *AuthService, logger *slog.Logger) http.Handler {
 	tmpl := template.Must(template.New("library").Parse(TEMPLATE_LIBRARY))
@@ -30,19 +55,95 @@ func handleLibraryGet(c *core.Core, auth *AuthService, logger *slog.Logger) http
 			return
 		}
 
-		items, err := c.ListItems(r.Context(), authedUser.ID)
+		renderLibrary(w, r, c, tmpl, logger, authedUser.ID, nil)
+	})
+}
+
+// renderLibrary renders the library page. The results of a bulk add are
+// passed in to be shown once.
+func renderLibrary(w http.ResponseWriter, r *http.Request, c *core.Core, tmpl *template.Template, logger *slog.Logger, userID int64, added []core.AddResult) {
+	items, err := c.ListItems(r.Context(), userID)
+	if err != nil {
+		logger.Error("Error listing items", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	// ?status= shows only the unread, archived or favorite items.
+	state := r.URL.Query().Get("status")
+	if state != "" {
+		if !core.ValidItemState(state) {
+			http.Error(w, "Invalid status", http.StatusBadRequest)
+			return
+		}
+		items = core.FilterItemsByState(items, state)
+	}
+
+	continueReading, err := c.GetContinueReading(r.Context(), userID)
+	if err != nil {
+		logger.Error("Error getting continue reading item", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	// ?view=series groups the chapters saved as items into series.
+	var series []core.Series
+	seriesView := r.URL.Query().Get("view") == "series"
+	if seriesView {
+		series, err = c.ListSeries(r.Context(), userID)
 		if err != nil {
-			logger.Error("Error listing items", "error", err)
+			logger.Error("Error listing series", "error", err)
 			http.Error(w, "Internal server error", http.StatusInternalServerError)
 			return
 		}
+	}
+
+	data := libraryData{
+		Items:           items,
+		ContinueReading: continueReading,
+		SeriesView:      seriesView,
+		Series:          series,
+		State:           state,
+		Added:           added,
+	}
+
+	if err := tmpl.ExecuteTemplate(w, "library", data); err != nil {
+		logger.Error("Error executing template", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+}
+
+// GET /library/search - Search the titles and text of the items
+func handleLibrarySearch(c *core.Core, auth *AuthService, logger *slog.Logger) http.Handler {
+	tmpl := template.Must(template.New("library").Parse(TEMPLATE_LIBRARY))
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "no-cache, no-store, must-revalidate")
+
+		authedUser, err := auth.GetAuthenticatedUser(r)
+		if err != nil {
+			auth.HandleAuthError(w, r, err)
+			return
+		}
 
-		data := struct {
-			Items []core.Item
-		}{
-			Items: items,
+		query := strings.TrimSpace(r.URL.Query().Get("q"))
+		if query == "" {
+			http.Redirect(w, r, "/library", http.StatusSeeOther)
+			return
+		}
+		results, err := c.SearchItems(r.Context(), authedUser.ID, query)
+		if err != nil {
+			logger.Error("Error searching items", "error", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
 		}
 
+		data := libraryData{
+			SearchView: true,
+			Query:      query,
+			Results:    results,
+		}
 		if err := tmpl.ExecuteTemplate(w, "library", data); err != nil {
 			logger.Error("Error executing template", "error", err)
 			http.Error(w, "Internal server error", http.StatusInternalServerError)
@@ -51,8 +152,11 @@ func handleLibraryGet(c *core.Core, auth *AuthService, logger *slog.Logger) http
 	})
 }
 
-// POST /library - Add new item
+// POST /library - Add new item, or a list of them, one URL per line of the
+// url field or of an uploaded text file
 func handleLibraryPost(c *core.Core, auth *AuthService, logger *slog.Logger) http.Handler {
+	tmpl := template.Must(template.New("library").Parse(TEMPLATE_LIBRARY))
+
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		authedUser, err := auth.GetAuthenticatedUser(r)
 		if err != nil {
@@ -60,12 +164,57 @@ func handleLibraryPost(c *core.Core, auth *AuthService, logger *slog.Logger) htt
 			return
 		}
 
-		if err := r.ParseForm(); err != nil {
-			http.Error(w, "Failed to parse form", http.StatusBadRequest)
+		r.Body = http.MaxBytesReader(w, r.Body, 4<<20)
+		if err := r.ParseMultipartForm(1 << 20); err != nil && !errors.Is(err, http.ErrNotMultipart) {
+			http.Error(w, "Failed to parse form, lists can be at most 4 MB", http.StatusBadRequest)
+			return
+		}
+
+		list := io.Reader(strings.NewReader(r.Form.Get("url")))
+		file, _, err := r.FormFile("file")
+		if err == nil {
+			defer file.Close()
+			list = io.MultiReader(list, strings.NewReader("\n"), file)
+		}
+		urls, err := core.ParseURLList(list)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if len(urls) == 0 {
+			http.Error(w, "URL is required", http.StatusBadRequest)
+			return
+		}
+		if len(urls) > 1 || file != nil {
+			added := c.AddItems(r.Context(), authedUser.ID, urls, time.Now())
+			renderLibrary(w, r, c, tmpl, logger, authedUser.ID, added)
+			return
+		}
+		url := urls[0]
+
+		_, err = c.AddItemWithTitleSetActive(r.Context(), authedUser.ID, url, time.Now())
+		if err != nil {
+			logger.Error("Error adding item", "error", err, "url", url)
+			http.Error(w, "Failed to add item", http.StatusInternalServerError)
 			return
 		}
 
-		url := r.Form.Get("url")
+		http.Redirect(w, r, "/library", http.StatusSeeOther)
+	})
+}
+
+// GET /add?url= - Add an item and start reading it, for the bookmarklet and
+// for devices without the extension. A GET so it's a plain link, anyone who
+// gets a logged in user to open one adds a page to their library, no worse.
+func handleAddGet(c *core.Core, auth *AuthService, logger *slog.Logger) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authedUser, err := auth.GetAuthenticatedUser(r)
+		if err != nil {
+			auth.HandleAuthError(w, r, err)
+			return
+		}
+
+		url := r.URL.Query().Get("url")
 		if url == "" {
 			http.Error(w, "URL is required", http.StatusBadRequest)
 			return
@@ -78,6 +227,234 @@ func handleLibraryPost(c *core.Core, auth *AuthService, logger *slog.Logger) htt
 			return
 		}
 
+		http.Redirect(w, r, "/read", http.StatusSeeOther)
+	})
+}
+
+// POST /library/series/backfill - Add the missing chapters of a series
+func handleLibrarySeriesBackfill(c *core.Core, auth *AuthService, logger *slog.Logger) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authedUser, err := auth.GetAuthenticatedUser(r)
+		if err != nil {
+			auth.HandleAuthError(w, r, err)
+			return
+		}
+
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, "Failed to parse form", http.StatusBadRequest)
+			return
+		}
+		key := r.Form.Get("series")
+		if key == "" {
+			http.Error(w, "Series is required", http.StatusBadRequest)
+			return
+		}
+
+		added, err := c.BackfillSeries(r.Context(), authedUser.ID, key, time.Now())
+		if err != nil {
+			logger.Error("Error backfilling series", "error", err, "series", key)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		logger.Info("Backfilled series", "series", key, "added", added)
+
+		http.Redirect(w, r, "/library?view=series", http.StatusSeeOther)
+	})
+}
+
+// POST /library/{id}/previous - Mark the earlier chapters of a series read
+// or unread
+func handleLibraryItemPrevious(c *core.Core, auth *AuthService, logger *slog.Logger) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authedUser, err := auth.GetAuthenticatedUser(r)
+		if err != nil {
+			auth.HandleAuthError(w, r, err)
+			return
+		}
+
+		itemID, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+		if err != nil {
+			http.Error(w, "Invalid item ID", http.StatusBadRequest)
+			return
+		}
+		if err := auth.RequireOwnership(r.Context(), authedUser.ID, itemID); err != nil {
+			auth.HandleAuthError(w, r, err)
+			return
+		}
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, "Failed to parse form", http.StatusBadRequest)
+			return
+		}
+
+		changed, err := c.MarkPreviousChapters(r.Context(), authedUser.ID, itemID, r.Form.Get("read") == "1", time.Now())
+		if err != nil {
+			logger.Error("Error marking previous chapters", "error", err, "itemID", itemID)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		logger.Info("Marked previous chapters", "itemID", itemID, "changed", changed)
+
+		http.Redirect(w, r, "/library?view=series", http.StatusSeeOther)
+	})
+}
+
+// GET /library/{id} - A single library item, polled while it's fetched
+func handleLibraryItemGet(c *core.Core, auth *AuthService, logger *slog.Logger) http.Handler {
+	tmpl := template.Must(template.New("library").Parse(TEMPLATE_LIBRARY))
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authedUser, err := auth.GetAuthenticatedUser(r)
+		if err != nil {
+			auth.HandleAuthError(w, r, err)
+			return
+		}
+
+		itemID, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+		if err != nil {
+			http.Error(w, "Invalid item ID", http.StatusBadRequest)
+			return
+		}
+		if err := auth.RequireOwnership(r.Context(), authedUser.ID, itemID); err != nil {
+			auth.HandleAuthError(w, r, err)
+			return
+		}
+
+		item, err := c.GetItem(r.Context(), authedUser.ID, itemID)
+		if err != nil {
+			logger.Error("Error getting item", "error", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		if err := tmpl.ExecuteTemplate(w, "library-item", item); err != nil {
+			logger.Error("Error executing template", "error", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+	})
+}
+
+// POST /library/{id}/state - Archive an item, make it a favorite or mark it
+// unread again
+func handleLibraryItemState(c *core.Core, auth *AuthService, logger *slog.Logger) http.Handler {
+	tmpl := template.Must(template.New("library").Parse(TEMPLATE_LIBRARY))
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authedUser, err := auth.GetAuthenticatedUser(r)
+		if err != nil {
+			auth.HandleAuthError(w, r, err)
+			return
+		}
+
+		itemID, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+		if err != nil {
+			http.Error(w, "Invalid item ID", http.StatusBadRequest)
+			return
+		}
+		if err := auth.RequireOwnership(r.Context(), authedUser.ID, itemID); err != nil {
+			auth.HandleAuthError(w, r, err)
+			return
+		}
+
+		state := r.FormValue("state")
+		if !core.ValidItemState(state) {
+			http.Error(w, "Invalid state", http.StatusBadRequest)
+			return
+		}
+		if err := c.SetItemState(r.Context(), authedUser.ID, itemID, state); err != nil {
+			logger.Error("Error setting item state", "error", err, "itemID", itemID)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		if r.Header.Get("HX-Request") == "" {
+			http.Redirect(w, r, "/library", http.StatusSeeOther)
+			return
+		}
+		item, err := c.GetItem(r.Context(), authedUser.ID, itemID)
+		if err != nil {
+			logger.Error("Error getting item", "error", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		if err := tmpl.ExecuteTemplate(w, "library-item", item); err != nil {
+			logger.Error("Error executing template", "error", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+	})
+}
+
+// POST /library/{id}/note - Set the note of an item, an empty one removes it
+func handleLibraryItemNote(c *core.Core, auth *AuthService, logger *slog.Logger) http.Handler {
+	tmpl := template.Must(template.New("library").Parse(TEMPLATE_LIBRARY))
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authedUser, err := auth.GetAuthenticatedUser(r)
+		if err != nil {
+			auth.HandleAuthError(w, r, err)
+			return
+		}
+
+		itemID, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+		if err != nil {
+			http.Error(w, "Invalid item ID", http.StatusBadRequest)
+			return
+		}
+		if err := auth.RequireOwnership(r.Context(), authedUser.ID, itemID); err != nil {
+			auth.HandleAuthError(w, r, err)
+			return
+		}
+
+		if err := c.SetItemNote(r.Context(), authedUser.ID, itemID, r.FormValue("note")); err != nil {
+			logger.Warn("Error setting item note", "error", err, "itemID", itemID)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if r.Header.Get("HX-Request") == "" {
+			http.Redirect(w, r, "/library", http.StatusSeeOther)
+			return
+		}
+		item, err := c.GetItem(r.Context(), authedUser.ID, itemID)
+		if err != nil {
+			logger.Error("Error getting item", "error", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		if err := tmpl.ExecuteTemplate(w, "library-item", item); err != nil {
+			logger.Error("Error executing template", "error", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+	})
+}
+
+// POST /library/{id}/retry - Fetch a failed item again
+func handleLibraryItemRetry(c *core.Core, auth *AuthService, logger *slog.Logger) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authedUser, err := auth.GetAuthenticatedUser(r)
+		if err != nil {
+			auth.HandleAuthError(w, r, err)
+			return
+		}
+
+		itemID, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+		if err != nil {
+			http.Error(w, "Invalid item ID", http.StatusBadRequest)
+			return
+		}
+		if err := auth.RequireOwnership(r.Context(), authedUser.ID, itemID); err != nil {
+			auth.HandleAuthError(w, r, err)
+			return
+		}
+
+		if err := c.RetryItem(r.Context(), itemID, time.Now()); err != nil {
+			logger.Error("Error retrying item", "error", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
 		http.Redirect(w, r, "/library", http.StatusSeeOther)
 	})
 }
@@ -173,3 +550,369 @@ func handleLibraryItemDelete(c *core.Core, auth *AuthService, logger *slog.Logge
 		}
 	})
 }
+
+// POST /import/{format} - Import an export from another service, POST /import
+// tells the format from the file
+func handleLibraryImport(c *core.Core, auth *AuthService, logger *slog.Logger) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authedUser, err := auth.GetAuthenticatedUser(r)
+		if err != nil {
+			auth.HandleAuthError(w, r, err)
+			return
+		}
+
+		r.Body = http.MaxBytesReader(w, r.Body, core.MaxImportBytes+1<<20)
+		if err := r.ParseMultipartForm(32 << 20); err != nil {
+			http.Error(w, "Failed to parse form, exports can be at most 100 MB", http.StatusBadRequest)
+			return
+		}
+
+		file, header, err := r.FormFile("archive")
+		if err != nil {
+			http.Error(w, "Export file is required", http.StatusBadRequest)
+			return
+		}
+		defer file.Close()
+
+		fetch := r.FormValue("fetch") != ""
+
+		format := r.PathValue("format")
+		if format == "" {
+			head := make([]byte, 4096)
+			n, _ := file.ReadAt(head, 0)
+			format = core.DetectImportFormat(header.Filename, head[:n])
+			if format == "" {
+				http.Error(w, "Unknown export format", http.StatusBadRequest)
+				return
+			}
+		}
+
+		var result *core.ImportResult
+		switch format {
+		case "omnivore":
+			archive, zipErr := zip.NewReader(file, header.Size)
+			if zipErr != nil {
+				http.Error(w, "Export archive is not a valid zip file", http.StatusBadRequest)
+				return
+			}
+			result, err = c.ImportOmnivore(r.Context(), authedUser.ID, archive, time.Now())
+		case "linkding":
+			result, err = c.ImportLinkding(r.Context(), authedUser.ID, file, fetch, time.Now())
+		case "shaarli":
+			result, err = c.ImportShaarli(r.Context(), authedUser.ID, file, fetch, time.Now())
+		case "pocket":
+			result, err = c.ImportPocket(r.Context(), authedUser.ID, file, fetch, time.Now())
+		case "instapaper":
+			result, err = c.ImportInstapaper(r.Context(), authedUser.ID, file, fetch, time.Now())
+		case "wallabag":
+			result, err = c.ImportWallabag(r.Context(), authedUser.ID, file, fetch, time.Now())
+		default:
+			http.Error(w, "Unknown import format", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			logger.Error("Error importing export", "error", err, "format", format)
+			http.Error(w, "Failed to import: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		for _, failure := range result.Failed {
+			logger.Warn("Failed to import item", "url", failure.URL, "error", failure.Error)
+		}
+
+		http.Redirect(w, r, "/library", http.StatusSeeOther)
+	})
+}
+
+// POST /library/book - Upload an EPUB book, read chapter by chapter
+func handleLibraryBookUpload(c *core.Core, auth *AuthService, logger *slog.Logger) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authedUser, err := auth.GetAuthenticatedUser(r)
+		if err != nil {
+			auth.HandleAuthError(w, r, err)
+			return
+		}
+
+		r.Body = http.MaxBytesReader(w, r.Body, core.MaxBookBytes+1<<20)
+		if err := r.ParseMultipartForm(32 << 20); err != nil {
+			http.Error(w, "Failed to parse form, books can be at most 50 MB", http.StatusBadRequest)
+			return
+		}
+
+		file, header, err := r.FormFile("book")
+		if err != nil {
+			http.Error(w, "Book file is required", http.StatusBadRequest)
+			return
+		}
+		defer file.Close()
+
+		if _, err := c.AddBook(r.Context(), authedUser.ID, file, header.Size, time.Now()); err != nil {
+			logger.Warn("Error adding book", "error", err, "filename", header.Filename)
+			http.Error(w, "Failed to add book: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		http.Redirect(w, r, "/library", http.StatusSeeOther)
+	})
+}
+
+// POST /library/upload - Upload a PDF, read as an article
+func handleLibraryUpload(c *core.Core, auth *AuthService, logger *slog.Logger) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authedUser, err := auth.GetAuthenticatedUser(r)
+		if err != nil {
+			auth.HandleAuthError(w, r, err)
+			return
+		}
+
+		r.Body = http.MaxBytesReader(w, r.Body, core.MaxPDFBytes+1<<20)
+		if err := r.ParseMultipartForm(32 << 20); err != nil {
+			http.Error(w, "Failed to parse form, PDFs can be at most 50 MB", http.StatusBadRequest)
+			return
+		}
+
+		file, header, err := r.FormFile("file")
+		if err != nil {
+			http.Error(w, "PDF file is required", http.StatusBadRequest)
+			return
+		}
+		defer file.Close()
+
+		if _, err := c.AddPDF(r.Context(), authedUser.ID, file, header.Size, header.Filename, time.Now()); err != nil {
+			logger.Warn("Error adding PDF", "error", err, "filename", header.Filename)
+			http.Error(w, "Failed to add PDF: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		http.Redirect(w, r, "/library", http.StatusSeeOther)
+	})
+}
+
+// POST /library/paste - Add pasted plain text or Markdown, with a title
+func handleLibraryPaste(c *core.Core, auth *AuthService, logger *slog.Logger) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authedUser, err := auth.GetAuthenticatedUser(r)
+		if err != nil {
+			auth.HandleAuthError(w, r, err)
+			return
+		}
+
+		r.Body = http.MaxBytesReader(w, r.Body, 2*core.MaxTextBytes)
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, "Failed to parse form, texts can be at most 1 MB", http.StatusBadRequest)
+			return
+		}
+
+		format := r.PostFormValue("format")
+		if format == "" {
+			format = core.TextFormatMarkdown
+		}
+		if _, err := c.AddText(r.Context(), authedUser.ID, r.PostFormValue("title"), r.PostFormValue("text"), format, time.Now()); err != nil {
+			logger.Warn("Error adding pasted text", "error", err)
+			http.Error(w, "Failed to add text: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		http.Redirect(w, r, "/library", http.StatusSeeOther)
+	})
+}
+
+// POST /library/{id}/email - Queue the item to be sent to an address, taken
+// from the HTMX prompt or a "to" form field
+func handleLibraryItemEmail(c *core.Core, auth *AuthService, logger *slog.Logger) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authedUser, err := auth.GetAuthenticatedUser(r)
+		if err != nil {
+			auth.HandleAuthError(w, r, err)
+			return
+		}
+
+		itemID, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+		if err != nil {
+			http.Error(w, "Invalid item ID", http.StatusBadRequest)
+			return
+		}
+		if err := auth.RequireOwnership(r.Context(), authedUser.ID, itemID); err != nil {
+			auth.HandleAuthError(w, r, err)
+			return
+		}
+
+		to := r.Header.Get("HX-Prompt")
+		if to == "" {
+			to = r.FormValue("to")
+		}
+
+		err = c.QueueEmailItem(r.Context(), itemID, to, time.Now())
+		if errors.Is(err, core.ErrMailNotConfigured) {
+			http.Error(w, err.Error(), http.StatusNotImplemented)
+			return
+		}
+		if err != nil {
+			logger.Warn("Error emailing item", "error", err, "itemID", itemID)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		w.WriteHeader(http.StatusAccepted)
+	})
+}
+
+// GET /library/{id}/export.pdf - Typeset the item as a PDF. The layout can
+// be changed with the size (a4, a5, a6, letter, legal), margin (mm), font
+// (serif, sans) and fontsize (pt) query parameters.
+func handleLibraryItemPDF(c *core.Core, auth *AuthService, logger *slog.Logger) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authedUser, err := auth.GetAuthenticatedUser(r)
+		if err != nil {
+			auth.HandleAuthError(w, r, err)
+			return
+		}
+
+		itemID, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+		if err != nil {
+			http.Error(w, "Invalid item ID", http.StatusBadRequest)
+			return
+		}
+		if err := auth.RequireOwnership(r.Context(), authedUser.ID, itemID); err != nil {
+			auth.HandleAuthError(w, r, err)
+			return
+		}
+
+		opts, err := pdfOptionsFromQuery(r.URL.Query())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		summary, err := c.GetItemSummary(r.Context(), itemID)
+		if err != nil {
+			logger.Error("Error getting item", "error", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		clean, err := c.GetItemContent(r.Context(), itemID)
+		if err != nil {
+			logger.Error("Error getting item content", "error", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		var buf bytes.Buffer
+		if err := pdf.Render(&buf, clean.Title, summary.URL, clean.ContentHTML, opts); err != nil {
+			logger.Error("Error rendering PDF", "error", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/pdf")
+		w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": core.Slugify(clean.Title) + ".pdf"}))
+		w.Write(buf.Bytes())
+	})
+}
+
+func pdfOptionsFromQuery(query url.Values) (pdf.Options, error) {
+	opts := pdf.DefaultOptions()
+	if v := query.Get("size"); v != "" {
+		size, ok := pdf.PageSizes[strings.ToLower(v)]
+		if !ok {
+			return opts, fmt.Errorf("unknown page size: %q", v)
+		}
+		opts.PageSize = size
+	}
+	if v := query.Get("margin"); v != "" {
+		margin, err := strconv.ParseFloat(v, 64)
+		if err != nil || margin < 0 || margin > 50 {
+			return opts, fmt.Errorf("margin must be between 0 and 50 mm")
+		}
+		opts.Margin = pdf.MarginMM(margin)
+	}
+	if v := query.Get("font"); v != "" {
+		opts.Font = v
+	}
+	if v := query.Get("fontsize"); v != "" {
+		fontSize, err := strconv.ParseFloat(v, 64)
+		if err != nil || fontSize < 6 || fontSize > 24 {
+			return opts, fmt.Errorf("fontsize must be between 6 and 24 pt")
+		}
+		opts.FontSize = fontSize
+	}
+	return opts, nil
+}
+
+// GET /library/{id}/export.md
+func handleLibraryItemMarkdown(c *core.Core, auth *AuthService, logger *slog.Logger) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authedUser, err := auth.GetAuthenticatedUser(r)
+		if err != nil {
+			auth.HandleAuthError(w, r, err)
+			return
+		}
+
+		itemID, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+		if err != nil {
+			http.Error(w, "Invalid item ID", http.StatusBadRequest)
+			return
+		}
+		if err := auth.RequireOwnership(r.Context(), authedUser.ID, itemID); err != nil {
+			auth.HandleAuthError(w, r, err)
+			return
+		}
+
+		md, err := c.GetItemMarkdown(r.Context(), authedUser.ID, itemID)
+		if err != nil {
+			logger.Error("Error exporting item", "error", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/markdown; charset=utf-8")
+		w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": md.Filename}))
+		w.Write([]byte(md.Content))
+	})
+}
+
+// GET /library/export.zip - Markdown notes for the whole library
+func handleLibraryExportMarkdown(c *core.Core, auth *AuthService, logger *slog.Logger) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authedUser, err := auth.GetAuthenticatedUser(r)
+		if err != nil {
+			auth.HandleAuthError(w, r, err)
+			return
+		}
+
+		var buf bytes.Buffer
+		if err := c.ExportMarkdown(r.Context(), authedUser.ID, &buf); err != nil {
+			logger.Error("Error exporting library", "error", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/zip")
+		w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": "kindlepathy-notes.zip"}))
+		w.Write(buf.Bytes())
+	})
+}
+
+// GET /library/export-site.zip - The library as a static site, ?tag= for
+// the items with a tag
+func handleLibraryExportSite(c *core.Core, auth *AuthService, logger *slog.Logger) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authedUser, err := auth.GetAuthenticatedUser(r)
+		if err != nil {
+			auth.HandleAuthError(w, r, err)
+			return
+		}
+
+		// Streamed rather than buffered, with the images a library export
+		// gets large. An error midway leaves a truncated zip.
+		tag := strings.TrimSpace(r.URL.Query().Get("tag"))
+		filename := "kindlepathy-site.zip"
+		if tag != "" {
+			filename = "kindlepathy-site-" + core.Slugify(tag) + ".zip"
+		}
+		w.Header().Set("Content-Type", "application/zip")
+		w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": filename}))
+		if err := c.ExportSite(r.Context(), authedUser.ID, tag, w); err != nil {
+			logger.Error("Error exporting library as a site", "error", err)
+		}
+	})
+}
@@ -0,0 +1,133 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+	"time"
+
+	"github.com/egemengol/kindlepathy/internal/core"
+	db "github.com/egemengol/kindlepathy/internal/db/generated"
+)
+
+// LockoutPolicy temporarily locks an account after repeated failed logins.
+type LockoutPolicy struct {
+	// Threshold is the number of failed logins in a row that locks the
+	// account. Zero disables lockouts.
+	Threshold int
+	Duration  time.Duration
+}
+
+func DefaultLockoutPolicy() LockoutPolicy {
+	return LockoutPolicy{
+		Threshold: 5,
+		Duration:  15 * time.Minute,
+	}
+}
+
+// LockedUntil returns when the lock on the account ends, nil if it isn't
+// locked.
+func (a *AuthService) LockedUntil(ctx context.Context, userID int64, now time.Time) (*time.Time, error) {
+	failures, err := a.queries.LoginFailuresGet(ctx, userID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get login failures: %w", err)
+	}
+	ts, ok := failures.LockedUntilTs.(int64)
+	if !ok {
+		return nil, nil
+	}
+	until := time.Unix(ts, 0)
+	if !until.After(now) {
+		return nil, nil
+	}
+	return &until, nil
+}
+
+// LoginFailed counts a failed login, locking the account once the policy's
+// threshold is reached. It reports whether the account got locked.
+func (a *AuthService) LoginFailed(ctx context.Context, userID int64, now time.Time) (bool, error) {
+	if a.lockout.Threshold <= 0 {
+		return false, nil
+	}
+	failures, err := a.queries.LoginFailuresAdd(ctx, userID)
+	if err != nil {
+		return false, fmt.Errorf("failed to count login failure: %w", err)
+	}
+	if failures < int64(a.lockout.Threshold) {
+		return false, nil
+	}
+	err = a.queries.LoginFailuresLock(ctx, db.LoginFailuresLockParams{
+		LockedUntilTs: now.Add(a.lockout.Duration).Unix(),
+		UserID:        userID,
+	})
+	if err != nil {
+		return false, fmt.Errorf("failed to lock account: %w", err)
+	}
+	return true, nil
+}
+
+// UnlockAccount forgets the failed logins of the user, lifting any lock.
+// Successful logins do the same.
+func (a *AuthService) UnlockAccount(ctx context.Context, userID int64) error {
+	return a.queries.LoginFailuresClear(ctx, userID)
+}
+
+// clientIP is the address the request came from. X-Forwarded-For is only
+// trusted from the proxies in TrustedProxies, and read from the right, the
+// proxies appending to it: the address is the first hop that isn't one of
+// them, the left-most one when they all are.
+func (a *AuthService) clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	if !a.trustedProxy(host) {
+		return host
+	}
+	hops := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(hops[i])
+		if hop == "" {
+			continue
+		}
+		host = hop
+		if !a.trustedProxy(hop) {
+			break
+		}
+	}
+	return host
+}
+
+// trustedProxy tells whether the address is one of TrustedProxies.
+func (a *AuthService) trustedProxy(host string) bool {
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, prefix := range a.trustedProxies {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// notifyLockout tells the owner of a locked account about it, if they have
+// an email address.
+func notifyLockout(c *core.Core, logger *slog.Logger, userID int64, ip string, now time.Time) {
+	body := fmt.Sprintf("Your Kindlepathy account was locked after repeated failed logins from %s, at %s.\n\nIf this wasn't you, someone may be guessing your password. Consider changing it.", ip, now.Format(time.RFC1123))
+	err := c.MailUser(context.Background(), userID, "Your account was locked", body, now)
+	if err != nil && !errors.Is(err, core.ErrNoAccountEmail) && !errors.Is(err, core.ErrMailNotConfigured) {
+		logger.Error("Failed to send lockout notification", "error", err)
+	}
+}
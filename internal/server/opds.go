@@ -0,0 +1,415 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/egemengol/kindlepathy/internal/core"
+	db "github.com/egemengol/kindlepathy/internal/db/generated"
+)
+
+// opds.go serves the library as an OPDS 1.2 catalog, for e-reader apps that
+// browse catalogs, like KOReader and Moon+ Reader. Items are downloaded as
+// EPUBs. The apps log in with HTTP basic auth, the password being an API
+// token or the account's password, which is remembered for a few minutes
+// once right.
+
+const (
+	opdsNavigationType  = "application/atom+xml;profile=opds-catalog;kind=navigation"
+	opdsAcquisitionType = "application/atom+xml;profile=opds-catalog;kind=acquisition"
+	opdsPerPage         = 50
+)
+
+type opdsLink struct {
+	Rel   string `xml:"rel,attr"`
+	Href  string `xml:"href,attr"`
+	Type  string `xml:"type,attr,omitempty"`
+	Title string `xml:"title,attr,omitempty"`
+}
+
+type opdsCategory struct {
+	Term  string `xml:"term,attr"`
+	Label string `xml:"label,attr"`
+}
+
+type opdsAuthor struct {
+	Name string `xml:"name"`
+}
+
+type opdsEntry struct {
+	Title      string         `xml:"title"`
+	ID         string         `xml:"id"`
+	Updated    string         `xml:"updated"`
+	Author     *opdsAuthor    `xml:"author"`
+	Content    string         `xml:"content,omitempty"`
+	Categories []opdsCategory `xml:"category"`
+	Links      []opdsLink     `xml:"link"`
+}
+
+type opdsFeed struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	ID      string      `xml:"id"`
+	Title   string      `xml:"title"`
+	Updated string      `xml:"updated"`
+	Links   []opdsLink  `xml:"link"`
+	Entries []opdsEntry `xml:"entry"`
+}
+
+func writeOPDS(w http.ResponseWriter, contentType string, feed opdsFeed) {
+	w.Header().Set("Content-Type", contentType)
+	w.Write([]byte(xml.Header))
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	enc.Encode(feed)
+}
+
+// newOPDSAuthMiddleware authenticates requests with HTTP basic auth, which
+// is what OPDS readers support.
+func newOPDSAuthMiddleware(c *core.Core, queries *db.Queries, auth *AuthService, logger *slog.Logger) func(h http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("WWW-Authenticate", `Basic realm="kindlepathy", charset="UTF-8"`)
+			username, password, ok := r.BasicAuth()
+			if !ok {
+				http.Error(w, "Authentication required", http.StatusUnauthorized)
+				return
+			}
+
+			// Tokens are checked first, they're cheap to check and don't
+			// count towards the lockout.
+			user, err := queries.UsersGetByApiToken(r.Context(), core.HashAPIToken(password))
+			if err != nil || user.Username != username {
+				user, ok = basicLogin(w, r, c, logger, queries, auth, username, password)
+				if !ok {
+					return
+				}
+			}
+			w.Header().Del("WWW-Authenticate")
+			if refusal := appLoginRefusal(user); refusal != "" {
+				http.Error(w, refusal, http.StatusForbidden)
+				return
+			}
+
+			var activeItemID *int64
+			if id, ok := user.ActiveItemID.(int64); ok {
+				activeItemID = &id
+			}
+
+			authedUser := AuthenticatedUser{
+				ID:           user.ID,
+				Username:     user.Username,
+				ActiveItemID: activeItemID,
+				LandingPage:  user.LandingPage,
+				IsAdmin:      user.IsAdmin,
+				EinkMode:     user.EinkMode,
+			}
+
+			authedUser.Flags, err = c.UserFlags(r.Context(), user.ID)
+			if err != nil {
+				logger.Error("Error resolving feature flags", "error", err)
+				http.Error(w, "Internal server error", http.StatusInternalServerError)
+				return
+			}
+
+			ctx := context.WithValue(r.Context(), userContextKey, authedUser)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// opdsLoginTTL is how long a password that was right is taken without
+// checking it again.
+const opdsLoginTTL = 5 * time.Minute
+
+// basicLogin checks the account password an app sent. The apps send it with
+// every request, so a password that was right is remembered for a few
+// minutes rather than hashed again each time, and only the wrong ones count
+// towards the login rate limit.
+func basicLogin(w http.ResponseWriter, r *http.Request, c *core.Core, logger *slog.Logger, queries *db.Queries, auth *AuthService, username, password string) (db.User, bool) {
+	now := time.Now()
+	keys := loginLimitKeys(auth, r, username)
+	if auth.loginLimit.exhausted(w, now, keys...) {
+		return db.User{}, false
+	}
+	if userID, passwordHash, ok := auth.basicLogins.get(username, password, now); ok {
+		// Changing the password, disabling the account or locking it ends
+		// what was remembered.
+		user, err := queries.UsersGet(r.Context(), userID)
+		if err == nil && user.Password == passwordHash && user.DisabledTs == nil {
+			lockedUntil, err := auth.LockedUntil(r.Context(), user.ID, now)
+			if err == nil && lockedUntil == nil {
+				return user, true
+			}
+		}
+		auth.basicLogins.forget(username, password)
+	}
+	user, ok := verifyLogin(w, r, c, logger, queries, auth, username, password)
+	if !ok {
+		auth.loginLimit.spend(now, keys...)
+		return db.User{}, false
+	}
+	auth.basicLogins.remember(username, password, user, now)
+	return user, true
+}
+
+// loginCache remembers the passwords that were right for a while, by a
+// hash of the username and password keyed with a secret of the process.
+type loginCache struct {
+	secret []byte
+	ttl    time.Duration
+
+	mu     sync.Mutex
+	logins map[[sha256.Size]byte]cachedLogin
+}
+
+type cachedLogin struct {
+	userID int64
+	// passwordHash is the user's hash when the password was checked.
+	passwordHash string
+	expires      time.Time
+}
+
+func newLoginCache(ttl time.Duration) *loginCache {
+	secret := make([]byte, 32)
+	rand.Read(secret)
+	return &loginCache{secret: secret, ttl: ttl, logins: map[[sha256.Size]byte]cachedLogin{}}
+}
+
+func (lc *loginCache) key(username, password string) [sha256.Size]byte {
+	mac := hmac.New(sha256.New, lc.secret)
+	mac.Write([]byte(username + "\x00" + password))
+	return [sha256.Size]byte(mac.Sum(nil))
+}
+
+func (lc *loginCache) get(username, password string, now time.Time) (int64, string, bool) {
+	lc.mu.Lock()
+	defer lc.mu.Unlock()
+	login, ok := lc.logins[lc.key(username, password)]
+	if !ok || now.After(login.expires) {
+		return 0, "", false
+	}
+	return login.userID, login.passwordHash, true
+}
+
+func (lc *loginCache) remember(username, password string, user db.User, now time.Time) {
+	lc.mu.Lock()
+	defer lc.mu.Unlock()
+	for key, login := range lc.logins {
+		if now.After(login.expires) {
+			delete(lc.logins, key)
+		}
+	}
+	lc.logins[lc.key(username, password)] = cachedLogin{userID: user.ID, passwordHash: user.Password, expires: now.Add(lc.ttl)}
+}
+
+func (lc *loginCache) forget(username, password string) {
+	lc.mu.Lock()
+	defer lc.mu.Unlock()
+	delete(lc.logins, lc.key(username, password))
+}
+
+// GET /opds - The catalog root, leading to the unread items, all of them and
+// the tags
+func handleOPDSRoot(c *core.Core, auth *AuthService, logger *slog.Logger) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authedUser, err := auth.GetAuthenticatedUser(r)
+		if err != nil {
+			http.Error(w, "Authentication required", http.StatusUnauthorized)
+			return
+		}
+
+		items, err := c.ListItems(r.Context(), authedUser.ID)
+		if err != nil {
+			logger.Error("Error listing items", "error", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		var tags []string
+		for _, item := range items {
+			for _, tag := range item.Tags {
+				if !slices.Contains(tags, tag) {
+					tags = append(tags, tag)
+				}
+			}
+		}
+		slices.Sort(tags)
+
+		now := time.Now().UTC().Format(time.RFC3339)
+		navEntry := func(id, title, href, content string) opdsEntry {
+			return opdsEntry{
+				Title:   title,
+				ID:      "urn:kindlepathy:" + id,
+				Updated: now,
+				Content: content,
+				Links:   []opdsLink{{Rel: "subsection", Href: href, Type: opdsAcquisitionType}},
+			}
+		}
+		entries := []opdsEntry{
+			navEntry("unread", "Unread", "/opds/unread", "Items not read yet, newest first"),
+			navEntry("all", "All items", "/opds/all", "The whole library, newest first"),
+		}
+		for _, tag := range tags {
+			entries = append(entries, navEntry("tag:"+url.PathEscape(tag), "Tag: "+tag, "/opds/tags/"+url.PathEscape(tag), ""))
+		}
+
+		writeOPDS(w, opdsNavigationType, opdsFeed{
+			ID:      "urn:kindlepathy:root",
+			Title:   "Kindlepathy",
+			Updated: now,
+			Links: []opdsLink{
+				{Rel: "self", Href: "/opds", Type: opdsNavigationType},
+				{Rel: "start", Href: "/opds", Type: opdsNavigationType},
+			},
+			Entries: entries,
+		})
+	})
+}
+
+// GET /opds/unread, /opds/all and /opds/tags/{tag} - Items to download, a
+// page at a time
+func handleOPDSItems(c *core.Core, auth *AuthService, logger *slog.Logger) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authedUser, err := auth.GetAuthenticatedUser(r)
+		if err != nil {
+			http.Error(w, "Authentication required", http.StatusUnauthorized)
+			return
+		}
+
+		items, err := c.ListItems(r.Context(), authedUser.ID)
+		if err != nil {
+			logger.Error("Error listing items", "error", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		title := "All items"
+		tag := r.PathValue("tag")
+		switch {
+		case tag != "":
+			title = "Tag: " + tag
+			items = slices.DeleteFunc(items, func(item core.Item) bool { return !slices.Contains(item.Tags, tag) })
+		case strings.HasSuffix(r.URL.Path, "/unread"):
+			title = "Unread"
+			items = slices.DeleteFunc(items, func(item core.Item) bool { return item.ReadTs != nil })
+		}
+
+		page, err := strconv.Atoi(r.URL.Query().Get("page"))
+		if err != nil || page < 1 {
+			page = 1
+		}
+		start := min((page-1)*opdsPerPage, len(items))
+		end := min(start+opdsPerPage, len(items))
+
+		entries := make([]opdsEntry, 0, end-start)
+		for _, item := range items[start:end] {
+			entries = append(entries, opdsEntryFrom(item))
+		}
+		pageLink := func(rel string, page int) opdsLink {
+			return opdsLink{Rel: rel, Href: fmt.Sprintf("%s?page=%d", r.URL.EscapedPath(), page), Type: opdsAcquisitionType}
+		}
+		links := []opdsLink{
+			pageLink("self", page),
+			{Rel: "start", Href: "/opds", Type: opdsNavigationType},
+			{Rel: "up", Href: "/opds", Type: opdsNavigationType},
+		}
+		if page > 1 {
+			links = append(links, pageLink("previous", page-1))
+		}
+		if end < len(items) {
+			links = append(links, pageLink("next", page+1))
+		}
+
+		writeOPDS(w, opdsAcquisitionType, opdsFeed{
+			ID:      "urn:kindlepathy:" + strings.TrimPrefix(r.URL.EscapedPath(), "/opds/"),
+			Title:   title,
+			Updated: time.Now().UTC().Format(time.RFC3339),
+			Links:   links,
+			Entries: entries,
+		})
+	})
+}
+
+func opdsEntryFrom(item core.Item) opdsEntry {
+	updated := item.AddedTs
+	if item.ReadTs != nil && item.ReadTs.After(updated) {
+		updated = *item.ReadTs
+	}
+	entry := opdsEntry{
+		Title:   item.Title,
+		ID:      fmt.Sprintf("urn:kindlepathy:item:%d", item.ID),
+		Updated: updated.UTC().Format(time.RFC3339),
+		Links: []opdsLink{
+			{Rel: "http://opds-spec.org/acquisition", Href: fmt.Sprintf("/opds/items/%d.epub", item.ID), Type: "application/epub+zip"},
+			{Rel: "alternate", Href: item.URL, Type: "text/html"},
+		},
+	}
+	if entry.Title == "" {
+		entry.Title = item.URL
+	}
+	if u, err := url.Parse(item.URL); err == nil {
+		entry.Author = &opdsAuthor{Name: u.Host}
+	}
+	for _, tag := range item.Tags {
+		entry.Categories = append(entry.Categories, opdsCategory{Term: tag, Label: tag})
+	}
+	return entry
+}
+
+// GET /opds/items/{file} - An item as an EPUB, {file} being its ID and .epub
+func handleOPDSItemEPUB(c *core.Core, auth *AuthService, logger *slog.Logger) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authedUser, err := auth.GetAuthenticatedUser(r)
+		if err != nil {
+			http.Error(w, "Authentication required", http.StatusUnauthorized)
+			return
+		}
+		id, ok := strings.CutSuffix(r.PathValue("file"), ".epub")
+		if !ok {
+			http.Error(w, "Not found", http.StatusNotFound)
+			return
+		}
+		itemID, err := strconv.ParseInt(id, 10, 64)
+		if err != nil {
+			http.Error(w, "Invalid item ID", http.StatusBadRequest)
+			return
+		}
+		if err := auth.RequireOwnership(r.Context(), authedUser.ID, itemID); err != nil {
+			auth.HandleAuthError(w, r, err)
+			return
+		}
+		writeEPUB(w, r, c, logger, itemID)
+	})
+}
+
+// writeEPUB answers with the item as an EPUB. It's built in memory, a failed
+// fetch is an error instead of half a book.
+func writeEPUB(w http.ResponseWriter, r *http.Request, c *core.Core, logger *slog.Logger, itemID int64) {
+	var buf bytes.Buffer
+	if err := c.ExportEPUB(r.Context(), itemID, &buf); err != nil {
+		logger.Error("Error exporting EPUB", "error", err)
+		var fetchErr *core.FetchError
+		if errors.As(err, &fetchErr) {
+			http.Error(w, fetchErr.Reason(), http.StatusBadGateway)
+			return
+		}
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/epub+zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%d.epub"`, itemID))
+	w.Write(buf.Bytes())
+}
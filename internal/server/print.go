@@ -0,0 +1,46 @@
+package server
+
+import (
+	_ "embed"
+	"html/template"
+	"log/slog"
+	"net/http"
+
+	"github.com/egemengol/kindlepathy/internal/core"
+)
+
+//go:embed print.html
+var TEMPLATE_PRINT string
+
+// renderPrint renders an item for printing or saving to PDF from a desktop
+// browser. Unlike the reader it doesn't mark the item read.
+func renderPrint(w http.ResponseWriter, r *http.Request, c *core.Core, tmpl, tmplError *template.Template, logger *slog.Logger, itemID int64) {
+	summary, err := c.GetItemSummary(r.Context(), itemID)
+	if err != nil {
+		logger.Error("Error getting item", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	clean, err := c.GetItemContent(r.Context(), itemID)
+	if err != nil {
+		logger.Error("Error getting item content", "error", err)
+		renderFetchError(w, r, c, tmplError, logger, itemID, err)
+		return
+	}
+
+	data := struct {
+		Title   string
+		URL     string
+		Content template.HTML
+	}{
+		Title:   clean.Title,
+		URL:     summary.URL,
+		Content: template.HTML(clean.ContentHTML),
+	}
+
+	if err := tmpl.Execute(w, data); err != nil {
+		logger.Error("Error executing template", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+}
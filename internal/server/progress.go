@@ -0,0 +1,87 @@
+package server
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/egemengol/kindlepathy/internal/core"
+)
+
+// POST /read/{id}/progress - Save where the reader is in the item
+func handleReadProgressPost(c *core.Core, auth *AuthService, logger *slog.Logger) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authedUser, err := auth.GetAuthenticatedUser(r)
+		if err != nil {
+			auth.HandleAuthError(w, r, err)
+			return
+		}
+
+		itemID, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+		if err != nil {
+			http.Error(w, "Invalid item ID", http.StatusBadRequest)
+			return
+		}
+		if err := auth.RequireOwnership(r.Context(), authedUser.ID, itemID); err != nil {
+			auth.HandleAuthError(w, r, err)
+			return
+		}
+
+		part, err := strconv.Atoi(r.FormValue("part"))
+		if err != nil {
+			http.Error(w, "Invalid part", http.StatusBadRequest)
+			return
+		}
+		paragraph, err := strconv.Atoi(r.FormValue("paragraph"))
+		if err != nil {
+			http.Error(w, "Invalid paragraph", http.StatusBadRequest)
+			return
+		}
+		progress := core.ReadingProgress{Part: part, Paragraph: paragraph}
+		if err := c.SaveProgress(r.Context(), itemID, progress, time.Now()); err != nil {
+			logger.Warn("Error saving progress", "error", err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
+
+// POST /read/{id}/note - Set the note of the item from the reader, which is
+// opened again
+func handleReadNotePost(c *core.Core, auth *AuthService, logger *slog.Logger) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authedUser, err := auth.GetAuthenticatedUser(r)
+		if err != nil {
+			auth.HandleAuthError(w, r, err)
+			return
+		}
+
+		itemID, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+		if err != nil {
+			http.Error(w, "Invalid item ID", http.StatusBadRequest)
+			return
+		}
+		if err := auth.RequireOwnership(r.Context(), authedUser.ID, itemID); err != nil {
+			auth.HandleAuthError(w, r, err)
+			return
+		}
+
+		if err := c.SetItemNote(r.Context(), authedUser.ID, itemID, r.FormValue("note")); err != nil {
+			logger.Warn("Error setting item note", "error", err, "itemID", itemID)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		http.Redirect(w, r, fmt.Sprintf("/read/%d", itemID), http.StatusSeeOther)
+	})
+}
+
+// resumeAt is the paragraph to open the part at, where the reader left it.
+func resumeAt(progress *core.ReadingProgress, part readPart) int {
+	if progress == nil || progress.Part != part.Number {
+		return 0
+	}
+	return progress.Paragraph
+}
@@ -0,0 +1,179 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ratelimit.go keeps a public instance from being brute-forced or used as a
+// fetch proxy. Requests are counted in token buckets per IP and per account,
+// kept in memory: a restart forgets them, which the lockout doesn't.
+
+// RateLimit allows Requests every Per, in bursts of up to Requests. Zero
+// Requests disables it.
+type RateLimit struct {
+	Requests int
+	Per      time.Duration
+}
+
+// ParseRateLimit parses a limit like 10/m, 5/h or 100/15m, 0 disables it.
+func ParseRateLimit(s string) (RateLimit, error) {
+	if s == "0" {
+		return RateLimit{}, nil
+	}
+	requests, per, ok := strings.Cut(s, "/")
+	n, err := strconv.Atoi(requests)
+	if !ok || err != nil || n < 1 {
+		return RateLimit{}, fmt.Errorf("invalid rate limit %q, expected requests/period like 10/m", s)
+	}
+	var d time.Duration
+	switch per {
+	case "s":
+		d = time.Second
+	case "m":
+		d = time.Minute
+	case "h":
+		d = time.Hour
+	default:
+		d, err = time.ParseDuration(per)
+		if err != nil || d <= 0 {
+			return RateLimit{}, fmt.Errorf("invalid rate limit %q, expected requests/period like 10/m", s)
+		}
+	}
+	return RateLimit{Requests: n, Per: d}, nil
+}
+
+// RateLimitPolicy limits the requests that are costly or guess at secrets.
+type RateLimitPolicy struct {
+	// Login limits password checks, of the login form and of the apps that
+	// log in with passwords.
+	Login RateLimit
+	// Signup limits new accounts.
+	Signup RateLimit
+	// Fetch limits requests that make the server fetch pages or images.
+	Fetch RateLimit
+}
+
+func DefaultRateLimitPolicy() RateLimitPolicy {
+	return RateLimitPolicy{
+		Login:  RateLimit{Requests: 10, Per: time.Minute},
+		Signup: RateLimit{Requests: 5, Per: time.Hour},
+		Fetch:  RateLimit{Requests: 120, Per: time.Minute},
+	}
+}
+
+type rateLimiter struct {
+	limit   RateLimit
+	mu      sync.Mutex
+	buckets map[string]*tokenBucket
+	swept   time.Time
+}
+
+type tokenBucket struct {
+	tokens  float64
+	updated time.Time
+}
+
+func newRateLimiter(limit RateLimit) *rateLimiter {
+	return &rateLimiter{limit: limit, buckets: map[string]*tokenBucket{}}
+}
+
+// allow takes a request from the key's bucket. Without one left, it returns
+// how long until there is.
+func (l *rateLimiter) allow(key string, now time.Time) (bool, time.Duration) {
+	return l.take(key, now, true)
+}
+
+// take returns whether the key's bucket has a request left, taking it when
+// spend is set, and how long until there is one when it hasn't.
+func (l *rateLimiter) take(key string, now time.Time, spend bool) (bool, time.Duration) {
+	if l.limit.Requests <= 0 {
+		return true, 0
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.sweep(now)
+
+	interval := float64(l.limit.Per) / float64(l.limit.Requests)
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &tokenBucket{tokens: float64(l.limit.Requests)}
+		l.buckets[key] = b
+	} else {
+		b.tokens = min(float64(l.limit.Requests), b.tokens+float64(now.Sub(b.updated))/interval)
+	}
+	b.updated = now
+	if b.tokens >= 1 {
+		if spend {
+			b.tokens--
+		}
+		return true, 0
+	}
+	return false, time.Duration((1 - b.tokens) * interval)
+}
+
+// sweep forgets the buckets that have filled up again, which are the same
+// as new ones.
+func (l *rateLimiter) sweep(now time.Time) {
+	if now.Sub(l.swept) < time.Minute {
+		return
+	}
+	l.swept = now
+	for key, b := range l.buckets {
+		if now.Sub(b.updated) >= l.limit.Per {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// limited answers the request with 429 when any of the keys is out of
+// requests.
+func (l *rateLimiter) limited(w http.ResponseWriter, now time.Time, keys ...string) bool {
+	for _, key := range keys {
+		if ok, wait := l.allow(key, now); !ok {
+			w.Header().Set("Retry-After", strconv.Itoa(int(wait.Seconds())+1))
+			http.Error(w, "Too many requests, try again later", http.StatusTooManyRequests)
+			return true
+		}
+	}
+	return false
+}
+
+// exhausted is limited without taking requests, for counting only the
+// failed ones with spend.
+func (l *rateLimiter) exhausted(w http.ResponseWriter, now time.Time, keys ...string) bool {
+	for _, key := range keys {
+		if ok, wait := l.take(key, now, false); !ok {
+			w.Header().Set("Retry-After", strconv.Itoa(int(wait.Seconds())+1))
+			http.Error(w, "Too many requests, try again later", http.StatusTooManyRequests)
+			return true
+		}
+	}
+	return false
+}
+
+// spend takes a request from the buckets of the keys.
+func (l *rateLimiter) spend(now time.Time, keys ...string) {
+	for _, key := range keys {
+		l.take(key, now, true)
+	}
+}
+
+// rateLimited limits the requests of each IP, and of each user once
+// authenticated.
+func (a *AuthService) rateLimited(l *rateLimiter, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		keys := []string{"ip:" + a.clientIP(r)}
+		if user, err := a.GetAuthenticatedUser(r); err == nil {
+			keys = append(keys, fmt.Sprintf("user:%d", user.ID))
+		}
+		if l.limited(w, time.Now(), keys...) {
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,180 @@
+package server
+
+import (
+	_ "embed"
+	"fmt"
+	"html/template"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/egemengol/kindlepathy/internal/core"
+)
+
+//go:embed reading_settings.html
+var TEMPLATE_READING_SETTINGS string
+
+// readerFonts are the font stacks of the fonts of the reader. The Kindle
+// has Bookerly built in, other devices load it from /static/fonts.
+var readerFonts = map[string]string{
+	core.FontBookerly:  "'Bookerly', serif",
+	core.FontSerif:     "Georgia, 'Times New Roman', serif",
+	core.FontSansSerif: "Helvetica, Arial, sans-serif",
+	core.FontMonospace: "'Courier New', monospace",
+}
+
+// readerMargins are the padding on the sides of the text.
+var readerMargins = map[string]string{
+	core.MarginNarrow: "0.5rem",
+	core.MarginNormal: "1rem",
+	core.MarginWide:   "2.5rem",
+}
+
+// readerDarkTheme is white on black, with the grays of the light theme
+// turned around.
+const readerDarkTheme = `body { background: black; color: white; }
+a { color: #ddd; }
+.header { background-color: #333; border-bottom-color: #666; }
+.header-title, .js-enabled .library-link { color: #eee; }
+.header-chapters, .byline { color: #aaa; }
+.js-enabled .library-link, .font-button, .nav-button { color: #ddd; border-color: #aaa; }
+.js-enabled .library-link:hover, .font-button:hover, .nav-button:hover { background-color: #222; }
+.nav-buttons, .nav-debug, .item-note { border-color: #444; }
+mark.highlight { background-color: #444; border-bottom-color: #ccc; }
+`
+
+// readerStyle renders the reading settings into CSS, on top of the style of
+// the reader. Every value comes from a fixed set or is a number, nothing of
+// the user's ends up in it as is.
+func readerStyle(s core.ReadingSettings) template.CSS {
+	var b strings.Builder
+	fmt.Fprintf(&b, ":root { --font-size: %.2frem; }\n", float64(s.FontSize)/100)
+	fmt.Fprintf(&b, "body { font-family: %s; line-height: %.2f; }\n", readerFonts[s.FontFamily], float64(s.LineHeight)/100)
+	fmt.Fprintf(&b, ".content { padding: 0 %s; }\n", readerMargins[s.Margin])
+	if s.Justify {
+		b.WriteString(".content { text-align: justify; hyphens: auto; }\n")
+	}
+	if s.Theme == core.ThemeDark {
+		b.WriteString(readerDarkTheme)
+	}
+	return template.CSS(b.String())
+}
+
+// readingStyle returns the style of the reader for the user, the default
+// one when their settings can't be read.
+func readingStyle(r *http.Request, c *core.Core, logger *slog.Logger, userID int64) template.CSS {
+	settings, err := c.GetReadingSettings(r.Context(), userID)
+	if err != nil {
+		logger.Warn("Error getting reading settings", "error", err)
+		settings = core.DefaultReadingSettings
+	}
+	return readerStyle(settings)
+}
+
+// GET /settings/reading
+func handleReadingSettingsGet(c *core.Core, auth *AuthService, logger *slog.Logger) http.Handler {
+	tmpl := template.Must(template.New("reading_settings").Parse(TEMPLATE_READING_SETTINGS))
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authedUser, err := auth.GetAuthenticatedUser(r)
+		if err != nil {
+			auth.HandleAuthError(w, r, err)
+			return
+		}
+
+		settings, err := c.GetReadingSettings(r.Context(), authedUser.ID)
+		if err != nil {
+			logger.Error("Error getting reading settings", "error", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		data := struct {
+			Settings      core.ReadingSettings
+			Fonts         []string
+			Margins       []string
+			Themes        []string
+			MinFontSize   int
+			MaxFontSize   int
+			MinLineHeight int
+			MaxLineHeight int
+		}{
+			Settings:      settings,
+			Fonts:         core.ReadingFonts,
+			Margins:       core.ReadingMargins,
+			Themes:        core.ReadingThemes,
+			MinFontSize:   core.MinFontSize,
+			MaxFontSize:   core.MaxFontSize,
+			MinLineHeight: core.MinLineHeight,
+			MaxLineHeight: core.MaxLineHeight,
+		}
+
+		if err := tmpl.ExecuteTemplate(w, "reading_settings", data); err != nil {
+			logger.Error("Error executing template", "error", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+	})
+}
+
+// POST /settings/reading
+func handleReadingSettingsPost(c *core.Core, auth *AuthService, logger *slog.Logger) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authedUser, err := auth.GetAuthenticatedUser(r)
+		if err != nil {
+			auth.HandleAuthError(w, r, err)
+			return
+		}
+
+		fontSize, err := strconv.Atoi(r.FormValue("font_size"))
+		if err != nil {
+			http.Error(w, "Invalid font size", http.StatusBadRequest)
+			return
+		}
+		lineHeight, err := strconv.Atoi(r.FormValue("line_height"))
+		if err != nil {
+			http.Error(w, "Invalid line height", http.StatusBadRequest)
+			return
+		}
+		settings := core.ReadingSettings{
+			FontFamily: r.FormValue("font_family"),
+			FontSize:   fontSize,
+			LineHeight: lineHeight,
+			Margin:     r.FormValue("margin"),
+			Justify:    r.FormValue("justify") == "on",
+			Theme:      r.FormValue("theme"),
+		}
+		if err := c.SetReadingSettings(r.Context(), authedUser.ID, settings); err != nil {
+			logger.Warn("Error setting reading settings", "error", err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		http.Redirect(w, r, "/settings/reading", http.StatusSeeOther)
+	})
+}
+
+// POST /settings/reading/font-size - The A- and A+ buttons of the reader
+func handleReadingFontSizePost(c *core.Core, auth *AuthService, logger *slog.Logger) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authedUser, err := auth.GetAuthenticatedUser(r)
+		if err != nil {
+			auth.HandleAuthError(w, r, err)
+			return
+		}
+
+		fontSize, err := strconv.Atoi(r.FormValue("font_size"))
+		if err != nil {
+			http.Error(w, "Invalid font size", http.StatusBadRequest)
+			return
+		}
+		if err := c.SetReadingFontSize(r.Context(), authedUser.ID, fontSize); err != nil {
+			logger.Error("Error setting font size", "error", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
@@ -0,0 +1,82 @@
+package server
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/egemengol/kindlepathy/internal/core"
+)
+
+// GET /library/{id}/screenshot - The screenshot an unreadable item is kept as
+func handleLibraryItemScreenshotGet(c *core.Core, auth *AuthService, logger *slog.Logger) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authedUser, err := auth.GetAuthenticatedUser(r)
+		if err != nil {
+			auth.HandleAuthError(w, r, err)
+			return
+		}
+
+		itemID, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+		if err != nil {
+			http.Error(w, "Invalid item ID", http.StatusBadRequest)
+			return
+		}
+		if err := auth.RequireOwnership(r.Context(), authedUser.ID, itemID); err != nil {
+			auth.HandleAuthError(w, r, err)
+			return
+		}
+
+		shot, err := c.GetItemScreenshot(r.Context(), itemID)
+		if err != nil {
+			logger.Error("Error getting screenshot", "error", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		if shot == nil {
+			http.Error(w, "No screenshot", http.StatusNotFound)
+			return
+		}
+
+		w.Header().Set("Content-Type", shot.ContentType)
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		w.Header().Set("Cache-Control", "private, max-age=86400")
+		w.Header().Set("Last-Modified", shot.Captured.UTC().Format(http.TimeFormat))
+		w.Write(shot.Image)
+	})
+}
+
+// POST /library/{id}/screenshot - Keep the item as a screenshot of its page
+func handleLibraryItemScreenshotPost(c *core.Core, auth *AuthService, logger *slog.Logger) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authedUser, err := auth.GetAuthenticatedUser(r)
+		if err != nil {
+			auth.HandleAuthError(w, r, err)
+			return
+		}
+
+		itemID, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+		if err != nil {
+			http.Error(w, "Invalid item ID", http.StatusBadRequest)
+			return
+		}
+		if err := auth.RequireOwnership(r.Context(), authedUser.ID, itemID); err != nil {
+			auth.HandleAuthError(w, r, err)
+			return
+		}
+
+		if !c.ScreenshotsConfigured() {
+			http.Error(w, "Screenshots are not configured", http.StatusNotImplemented)
+			return
+		}
+		if err := c.CaptureItemScreenshot(r.Context(), itemID, time.Now()); err != nil {
+			logger.Warn("Error taking screenshot", "error", err, "itemID", itemID)
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+
+		http.Redirect(w, r, fmt.Sprintf("/read/%d", itemID), http.StatusSeeOther)
+	})
+}
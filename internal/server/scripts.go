@@ -0,0 +1,59 @@
+package server
+
+import (
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/egemengol/kindlepathy/internal/core"
+)
+
+// POST /admin/scripts - Store the site script of a domain
+func handleAdminScriptPost(c *core.Core, auth *AuthService, logger *slog.Logger) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authedUser, err := auth.GetAuthenticatedUser(r)
+		if err != nil {
+			auth.HandleAuthError(w, r, err)
+			return
+		}
+		if !authedUser.IsAdmin {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+
+		domain := r.FormValue("domain")
+		if err := c.SetSiteScript(r.Context(), authedUser.ID, domain, r.FormValue("source"), time.Now()); err != nil {
+			logger.Warn("Error storing site script", "error", err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		logger.Info("Site script stored", "admin", authedUser.Username, "domain", domain)
+
+		http.Redirect(w, r, "/settings/account", http.StatusSeeOther)
+	})
+}
+
+// POST /admin/scripts/delete - Remove the site script of a domain
+func handleAdminScriptDeletePost(c *core.Core, auth *AuthService, logger *slog.Logger) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authedUser, err := auth.GetAuthenticatedUser(r)
+		if err != nil {
+			auth.HandleAuthError(w, r, err)
+			return
+		}
+		if !authedUser.IsAdmin {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+
+		domain := r.FormValue("domain")
+		if err := c.DeleteSiteScript(r.Context(), domain); err != nil {
+			logger.Error("Error deleting site script", "error", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		logger.Info("Site script deleted", "admin", authedUser.Username, "domain", domain)
+
+		http.Redirect(w, r, "/settings/account", http.StatusSeeOther)
+	})
+}
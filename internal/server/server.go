@@ -7,86 +7,230 @@ import (
 	"errors"
 	"fmt"
 	"html/template"
+	"io/fs"
 	"log/slog"
 	"net/http"
-	"path/filepath"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/egemengol/kindlepathy/internal/core"
 	db "github.com/egemengol/kindlepathy/internal/db/generated"
+	pw "github.com/egemengol/kindlepathy/internal/password"
+	"github.com/egemengol/kindlepathy/web"
 	"github.com/gorilla/sessions"
-	"golang.org/x/crypto/bcrypt"
 )
 
 //go:embed read.html
 var TEMPLATE_READ string
 
-func NewServer(core *core.Core, logger *slog.Logger, queries *db.Queries, sessionStoreSecret []byte) http.Handler {
+// NewServer serves the site, with the pages and static files of assets, the
+// built-in ones when nil.
+func NewServer(core *core.Core, logger *slog.Logger, queries *db.Queries, sessionStoreSecret []byte, authConfig AuthConfig, assets fs.FS) http.Handler {
 	sessionStore := sessions.NewCookieStore(sessionStoreSecret)
 	sessionStore.Options = &sessions.Options{
 		Path:     "/",
-		MaxAge:   86400 * 7, // 7 days
+		MaxAge:   int(authConfig.Sessions.MaxAge.Seconds()),
 		HttpOnly: true,
 	}
 
 	mux := http.NewServeMux()
 
-	addRoutes(mux, core, logger, queries, sessionStore)
+	if assets == nil {
+		assets = web.Assets("")
+	}
+	addRoutes(mux, core, logger, queries, NewAuthService(queries, sessionStore, authConfig), assets)
 
 	return mux
 }
 
-func addRoutes(mux *http.ServeMux, c *core.Core, logger *slog.Logger, queries *db.Queries, sessionStore *sessions.CookieStore) {
-	fs := http.FileServer(http.Dir("web/static"))
-	mux.Handle("/static/", http.StripPrefix("/static/", fs))
-
-	auth := NewAuthService(queries, sessionStore)
+func addRoutes(mux *http.ServeMux, c *core.Core, logger *slog.Logger, queries *db.Queries, auth *AuthService, assets fs.FS) {
+	static, err := fs.Sub(assets, "static")
+	if err != nil {
+		panic(err)
+	}
+	mux.Handle("/static/", http.StripPrefix("/static/", http.FileServerFS(static)))
 
 	mux.HandleFunc("GET /login", func(w http.ResponseWriter, r *http.Request) {
-		http.ServeFile(w, r, filepath.Join("web", "login.html"))
+		http.ServeFileFS(w, r, assets, "login.html")
 	})
-	mux.Handle("POST /login", handleLoginPost(logger, queries, sessionStore))
+	mux.Handle("POST /login", handleLoginPost(c, logger, queries, auth))
 
 	mux.HandleFunc("GET /signup", func(w http.ResponseWriter, r *http.Request) {
-		http.ServeFile(w, r, filepath.Join("web", "signup.html"))
+		http.ServeFileFS(w, r, assets, "signup.html")
 	})
-	mux.Handle("POST /signup", handleSignupPost(logger, queries))
-	mux.Handle("/logout", handleLogout(sessionStore))
+	mux.Handle("POST /signup", auth.rateLimited(auth.signupLimit, handleSignupPost(logger, queries, auth)))
+	mux.Handle("/logout", handleLogout(logger, auth))
 
 	mux.HandleFunc("/privacy", func(w http.ResponseWriter, r *http.Request) {
-		http.ServeFile(w, r, filepath.Join("web", "privacy.html"))
+		http.ServeFileFS(w, r, assets, "privacy.html")
 	})
 
-	authMiddleware := newAuthMiddleware(sessionStore, queries)
+	authMiddleware := newAuthMiddleware(c, auth, queries, logger)
+	// Routes that make the server fetch pages or images, which would make it
+	// an open proxy unlimited.
+	fetchLimited := func(h http.Handler) http.Handler {
+		return auth.rateLimited(auth.fetchLimit, h)
+	}
 
+	mux.Handle("GET /library/{id}", authMiddleware(handleLibraryItemGet(c, auth, logger)))
 	mux.Handle("DELETE /library/{id}", authMiddleware(handleLibraryItemDelete(c, auth, logger)))
 	mux.Handle("PATCH /library/{id}", authMiddleware(handleLibraryItemPatch(auth, logger)))
 	mux.Handle("GET /library", authMiddleware(handleLibraryGet(c, auth, logger)))
-	mux.Handle("POST /library", authMiddleware(handleLibraryPost(c, auth, logger)))
-
-	corsMiddleware := newExtensionCORSMiddleware(logger)
-	mux.Handle("GET /ext/check-auth", corsMiddleware(handleExtensionCheckAuth(logger, sessionStore)))
+	mux.Handle("GET /library/search", authMiddleware(handleLibrarySearch(c, auth, logger)))
+	mux.Handle("POST /library", authMiddleware(fetchLimited(handleLibraryPost(c, auth, logger))))
+	mux.Handle("GET /add", authMiddleware(fetchLimited(handleAddGet(c, auth, logger))))
+	mux.Handle("POST /import", authMiddleware(fetchLimited(handleLibraryImport(c, auth, logger))))
+	mux.Handle("POST /import/{format}", authMiddleware(fetchLimited(handleLibraryImport(c, auth, logger))))
+	mux.Handle("POST /library/series/backfill", authMiddleware(fetchLimited(handleLibrarySeriesBackfill(c, auth, logger))))
+	mux.Handle("POST /library/{id}/previous", authMiddleware(handleLibraryItemPrevious(c, auth, logger)))
+	mux.Handle("GET /library/{id}/history", authMiddleware(handleLibraryItemHistoryGet(c, auth, logger)))
+	mux.Handle("POST /library/{id}/history/{chapter}", authMiddleware(handleLibraryItemHistoryPost(c, auth, logger)))
+	mux.Handle("GET /library/{id}/chapters", authMiddleware(handleLibraryItemChaptersGet(c, auth, logger)))
+	mux.Handle("POST /library/{id}/chapters", authMiddleware(handleLibraryItemChaptersPost(c, auth, logger)))
+	mux.Handle("POST /library/{id}/state", authMiddleware(handleLibraryItemState(c, auth, logger)))
+	mux.Handle("POST /library/upload", authMiddleware(handleLibraryUpload(c, auth, logger)))
+	mux.Handle("POST /library/book", authMiddleware(handleLibraryBookUpload(c, auth, logger)))
+	mux.Handle("POST /library/paste", authMiddleware(handleLibraryPaste(c, auth, logger)))
+	mux.Handle("POST /library/{id}/note", authMiddleware(handleLibraryItemNote(c, auth, logger)))
+	mux.Handle("POST /library/{id}/retry", authMiddleware(fetchLimited(handleLibraryItemRetry(c, auth, logger))))
+	mux.Handle("GET /library/{id}/screenshot", authMiddleware(handleLibraryItemScreenshotGet(c, auth, logger)))
+	mux.Handle("POST /library/{id}/screenshot", authMiddleware(fetchLimited(handleLibraryItemScreenshotPost(c, auth, logger))))
+	mux.Handle("POST /library/{id}/email", authMiddleware(fetchLimited(handleLibraryItemEmail(c, auth, logger))))
+	mux.Handle("GET /library/{id}/export.pdf", authMiddleware(fetchLimited(handleLibraryItemPDF(c, auth, logger))))
+	mux.Handle("GET /library/{id}/export.md", authMiddleware(fetchLimited(handleLibraryItemMarkdown(c, auth, logger))))
+	mux.Handle("GET /library/export.zip", authMiddleware(fetchLimited(handleLibraryExportMarkdown(c, auth, logger))))
+	mux.Handle("GET /library/export-site.zip", authMiddleware(fetchLimited(handleLibraryExportSite(c, auth, logger))))
+	mux.Handle("GET /highlights", authMiddleware(handleHighlightsGet(c, auth, logger)))
+	mux.Handle("GET /highlights/export.md", authMiddleware(handleHighlightsExport(c, auth, logger)))
+	mux.Handle("POST /highlights/{id}/delete", authMiddleware(handleHighlightDeletePost(c, auth, logger)))
+
+	mux.Handle("GET /settings/account", authMiddleware(handleAccountGet(c, auth, logger)))
+	mux.Handle("POST /settings/account/username", authMiddleware(handleAccountUsernamePost(c, auth, logger)))
+	mux.Handle("POST /settings/account/email", authMiddleware(handleAccountEmailPost(c, auth, logger)))
+	mux.Handle("POST /settings/account/email/remove", authMiddleware(handleAccountEmailRemovePost(c, auth, logger)))
+	mux.Handle("GET /settings/account/email/verify", handleAccountEmailVerify(c, logger))
+	mux.Handle("GET /admin", authMiddleware(handleAdminGet(c, auth, logger)))
+	mux.Handle("GET /admin/jobs", authMiddleware(handleAdminJobsGet(c, auth, logger)))
+	mux.Handle("POST /admin/jobs/{id}/retry", authMiddleware(handleAdminJobRetryPost(c, auth, logger)))
+	mux.Handle("POST /admin/users/{id}/{action}", authMiddleware(handleAdminUserPost(auth, logger)))
+	mux.Handle("POST /admin/impersonate", authMiddleware(handleImpersonatePost(auth, queries, logger)))
+	mux.Handle("POST /admin/impersonate/stop", authMiddleware(handleImpersonateStopPost(auth, logger)))
+	mux.Handle("POST /admin/flags", authMiddleware(handleAdminFlagPost(c, auth, logger)))
+	mux.Handle("POST /admin/flags/user", authMiddleware(handleAdminUserFlagPost(c, auth, queries, logger)))
+	mux.Handle("POST /admin/scripts", authMiddleware(handleAdminScriptPost(c, auth, logger)))
+	mux.Handle("POST /admin/scripts/delete", authMiddleware(handleAdminScriptDeletePost(c, auth, logger)))
+	mux.Handle("POST /admin/extractors", authMiddleware(handleAdminExtractorPost(c, auth, logger)))
+	mux.Handle("POST /admin/extractors/delete", authMiddleware(handleAdminExtractorDeletePost(c, auth, logger)))
+	mux.Handle("POST /admin/readability/reload", authMiddleware(handleAdminReadabilityReloadPost(c, auth, logger)))
+	mux.Handle("POST /settings/account/landing", authMiddleware(handleAccountLandingPost(c, auth, logger)))
+	mux.Handle("POST /settings/account/eink", authMiddleware(handleAccountEinkPost(c, auth, logger)))
+	mux.Handle("GET /settings/reading", authMiddleware(handleReadingSettingsGet(c, auth, logger)))
+	mux.Handle("POST /settings/reading", authMiddleware(handleReadingSettingsPost(c, auth, logger)))
+	mux.Handle("POST /settings/reading/font-size", authMiddleware(handleReadingFontSizePost(c, auth, logger)))
+	mux.Handle("POST /settings/account/logout-everywhere", authMiddleware(handleLogoutEverywherePost(auth, logger)))
+	mux.Handle("POST /settings/account/password", authMiddleware(handleAccountPasswordPost(auth, logger)))
+	mux.Handle("POST /settings/account/delete", authMiddleware(handleAccountDeletePost(c, auth, logger)))
+	mux.Handle("GET /settings/integrations", authMiddleware(handleIntegrationsGet(c, auth, logger)))
+	mux.Handle("POST /settings/integrations/{kind}", authMiddleware(handleIntegrationsPost(c, auth, logger)))
+	mux.Handle("POST /settings/integrations/{kind}/sync", authMiddleware(fetchLimited(handleIntegrationsSync(c, auth, logger))))
+	mux.Handle("POST /settings/integrations/{kind}/delete", authMiddleware(handleIntegrationsDelete(c, auth, logger)))
+	mux.Handle("POST /feeds", authMiddleware(fetchLimited(handleFeedsPost(c, auth, logger))))
+	mux.Handle("POST /feeds/{id}/delete", authMiddleware(handleFeedsDelete(c, auth, logger)))
+	mux.Handle("POST /feeds/import", authMiddleware(fetchLimited(handleFeedsImport(c, auth, logger))))
+	mux.Handle("GET /feeds/export", authMiddleware(handleFeedsExport(c, auth, logger)))
+	mux.Handle("POST /settings/chats/code", authMiddleware(handleChatLinkCodePost(c, auth, logger)))
+	mux.Handle("POST /settings/chats/unlink", authMiddleware(handleChatUnlinkPost(c, auth, logger)))
+	mux.Handle("POST /settings/newsletters/address", authMiddleware(handleInboundAddressPost(c, auth, logger)))
+	mux.Handle("POST /settings/tokens", authMiddleware(handleAPITokensPost(c, auth, logger)))
+	mux.Handle("POST /settings/tokens/{id}/delete", authMiddleware(handleAPITokensDelete(c, auth, logger)))
+	mux.Handle("POST /settings/cookies", authMiddleware(handleSiteCookiesPost(c, auth, logger)))
+	mux.Handle("POST /settings/cookies/import", authMiddleware(handleSiteCookiesImport(c, auth, logger)))
+	mux.Handle("POST /settings/cookies/{id}/delete", authMiddleware(handleSiteCookiesDelete(c, auth, logger)))
+
+	// Token authenticated routes outside of the versioned API
+	apiAuthMiddleware := newAPIAuthMiddleware(c, queries, logger, func(w http.ResponseWriter, status int, message string) {
+		http.Error(w, message, status)
+	})
+	mux.Handle("GET /api/flags", apiAuthMiddleware(handleAPIFlagsGet(auth)))
+	mux.Handle("POST /debug/extract", apiAuthMiddleware(fetchLimited(handleDebugExtractPost(c, auth, logger))))
+	mux.Handle("GET /api/admin/cache", apiAuthMiddleware(handleAPICacheGet(c, auth, logger)))
+	mux.Handle("POST /api/admin/cache/purge", apiAuthMiddleware(handleAPICachePurge(c, auth, logger)))
+	mux.Handle("POST /api/admin/cache/gc", apiAuthMiddleware(handleAPICacheGC(c, auth, logger)))
+
+	// Versioned JSON API for third-party clients
+	apiV1AuthMiddleware := newAPIAuthMiddleware(c, queries, logger, writeAPIAuthError)
+	mux.Handle("GET /api/v1/version", apiV1AuthMiddleware(handleAPIVersionGet(c)))
+	mux.Handle("GET /api/v1/items", apiV1AuthMiddleware(handleAPIv1ItemsGet(c, auth, logger)))
+	mux.Handle("POST /api/v1/items", apiV1AuthMiddleware(fetchLimited(handleAPIv1ItemsPost(c, auth, logger))))
+	mux.Handle("GET /api/v1/items/{id}", apiV1AuthMiddleware(handleAPIv1ItemGet(c, auth, logger)))
+	mux.Handle("PATCH /api/v1/items/{id}", apiV1AuthMiddleware(handleAPIv1ItemPatch(c, auth, logger)))
+	mux.Handle("DELETE /api/v1/items/{id}", apiV1AuthMiddleware(handleAPIv1ItemDelete(c, auth, logger)))
+	mux.Handle("GET /api/v1/items/{id}/content", apiV1AuthMiddleware(fetchLimited(handleAPIv1ItemContent(c, auth, logger))))
+	mux.Handle("POST /api/v1/items/{id}/navigate", apiV1AuthMiddleware(fetchLimited(handleAPIv1ItemNavigate(c, auth, logger))))
+	mux.Handle("GET /api/v1/items/{id}/text", apiV1AuthMiddleware(fetchLimited(handleAPIv1ItemText(c, auth, logger))))
+	mux.Handle("GET /api/v1/items/{id}/epub", apiV1AuthMiddleware(fetchLimited(handleAPIv1ItemEPUB(c, auth, logger))))
+	mux.Handle("POST /api/v1/items/{id}/send", apiV1AuthMiddleware(fetchLimited(handleAPIv1ItemSend(c, auth, logger))))
+	mux.Handle("/api/v1/", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeAPIError(w, http.StatusNotFound, apiCodeNotFound, "No such endpoint")
+	}))
+	// The routes of the CLI before v1, for the clients not updated yet
+	mux.Handle("GET /api/items", apiV1AuthMiddleware(handleAPIv1ItemsGet(c, auth, logger)))
+	mux.Handle("POST /api/items", apiV1AuthMiddleware(fetchLimited(handleAPIv1ItemsPost(c, auth, logger))))
+	mux.Handle("GET /api/items/{id}/text", apiV1AuthMiddleware(fetchLimited(handleAPIv1ItemText(c, auth, logger))))
+	mux.Handle("GET /api/items/{id}/epub", apiV1AuthMiddleware(fetchLimited(handleAPIv1ItemEPUB(c, auth, logger))))
+	mux.Handle("POST /api/items/{id}/send", apiV1AuthMiddleware(fetchLimited(handleAPIv1ItemSend(c, auth, logger))))
+
+	// Wallabag v2 API for its apps, both with and without the .json of its
+	// routes
+	mux.Handle("POST /oauth/v2/token", handleWallabagToken(c, logger, queries, auth))
+	for _, suffix := range []string{"", ".json"} {
+		mux.Handle("GET /api/version"+suffix, handleWallabagVersion())
+		mux.Handle("GET /api/info"+suffix, handleWallabagInfo())
+		mux.Handle("GET /api/entries"+suffix, apiAuthMiddleware(handleWallabagEntriesGet(c, auth, logger)))
+		mux.Handle("POST /api/entries"+suffix, apiAuthMiddleware(fetchLimited(handleWallabagEntriesPost(c, auth, logger))))
+		mux.Handle("GET /api/entries/exists"+suffix, apiAuthMiddleware(handleWallabagEntriesExists(c, auth, logger)))
+	}
+	mux.Handle("GET /api/entries/{entry}", apiAuthMiddleware(handleWallabagEntryGet(c, auth, logger)))
+	mux.Handle("PATCH /api/entries/{entry}", apiAuthMiddleware(handleWallabagEntryPatch(c, auth, logger)))
+	mux.Handle("DELETE /api/entries/{entry}", apiAuthMiddleware(handleWallabagEntryDelete(c, auth, logger)))
+	mux.Handle("GET /api/entries/{entry}/{export}", apiAuthMiddleware(fetchLimited(handleWallabagEntryExport(c, auth, logger))))
+
+	// OPDS catalog for e-reader apps
+	opdsAuthMiddleware := newOPDSAuthMiddleware(c, queries, auth, logger)
+	mux.Handle("GET /opds", opdsAuthMiddleware(handleOPDSRoot(c, auth, logger)))
+	mux.Handle("GET /opds/unread", opdsAuthMiddleware(handleOPDSItems(c, auth, logger)))
+	mux.Handle("GET /opds/all", opdsAuthMiddleware(handleOPDSItems(c, auth, logger)))
+	mux.Handle("GET /opds/tags/{tag}", opdsAuthMiddleware(handleOPDSItems(c, auth, logger)))
+	mux.Handle("GET /opds/items/{file}", opdsAuthMiddleware(fetchLimited(handleOPDSItemEPUB(c, auth, logger))))
+
+	corsMiddleware := newExtensionCORSMiddleware(logger, auth.extensionOrigins)
+	mux.Handle("GET /ext/check-auth", corsMiddleware(handleExtensionCheckAuth(auth)))
 	mux.Handle("POST /ext/article", corsMiddleware(authMiddleware(handleExtensionPostContent(logger, c, auth))))
 
 	/////////////
 
-	mux.Handle("GET /read/{id}", authMiddleware(handleRead(c, auth, logger)))
-	mux.Handle("GET /read", authMiddleware(handleReadActive(c, auth, logger)))
-	mux.Handle("POST /read/{id}", authMiddleware(handleReadNav(c, auth, logger)))
-	mux.Handle("POST /read", authMiddleware(handleReadNavActive(c, auth, logger)))
+	mux.Handle("GET /read/{id}", authMiddleware(fetchLimited(handleRead(c, auth, logger))))
+	mux.Handle("GET /read", authMiddleware(fetchLimited(handleReadActive(c, auth, logger))))
+	mux.Handle("POST /read/{id}", authMiddleware(fetchLimited(handleReadNav(c, auth, logger))))
+	mux.Handle("POST /read/{id}/progress", authMiddleware(handleReadProgressPost(c, auth, logger)))
+	mux.Handle("POST /read/{id}/highlight", authMiddleware(handleReadHighlightPost(c, auth, logger)))
+	mux.Handle("POST /read/{id}/note", authMiddleware(handleReadNotePost(c, auth, logger)))
+	mux.Handle("POST /read", authMiddleware(fetchLimited(handleReadNavActive(c, auth, logger))))
+	mux.Handle("GET "+core.ImageProxyPath, authMiddleware(fetchLimited(handleImageProxy(c, auth, logger))))
 
 	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
 		if auth.IsAuthenticated(r) {
-			http.Redirect(w, r, "/library", http.StatusSeeOther)
+			http.Redirect(w, r, auth.LandingPath(r), http.StatusSeeOther)
 			return
 		}
-		http.ServeFile(w, r, filepath.Join("web", "index.html"))
+		http.ServeFileFS(w, r, assets, "index.html")
 	})
 }
 
 func handleReadActive(c *core.Core, auth *AuthService, logger *slog.Logger) http.Handler {
 	tmpl := template.Must(template.New("read").Parse(TEMPLATE_READ))
+	tmplError := template.Must(template.New("fetch_error").Parse(TEMPLATE_FETCH_ERROR))
 
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "text/html; charset=utf-8")
@@ -108,7 +252,7 @@ func handleReadActive(c *core.Core, auth *AuthService, logger *slog.Logger) http
 		activeItemID := *authedUser.ActiveItemID
 
 		// Check ownership
-		if err := auth.RequireOwnership(r.Context(), authedUser.Username, activeItemID); err != nil {
+		if err := auth.RequireOwnership(r.Context(), authedUser.ID, activeItemID); err != nil {
 			auth.HandleAuthError(w, r, err)
 			return
 		}
@@ -116,27 +260,94 @@ func handleReadActive(c *core.Core, auth *AuthService, logger *slog.Logger) http
 		itemScs, err := c.ReadItem(r.Context(), activeItemID, time.Now())
 		if err != nil {
 			logger.Error("Error reading item", "error", err)
-			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			renderFetchError(w, r, c, tmplError, logger, activeItemID, err)
 			return
 		}
 
+		// ?debug=nav shows how the nav links were picked.
+		var navDebug *core.NavReport
+		var navDebugError string
+		if r.URL.Query().Get("debug") == "nav" {
+			navDebug, err = c.DebugNav(r.Context(), activeItemID)
+			if err != nil {
+				navDebugError = err.Error()
+			}
+		}
+
+		progress, err := c.GetProgress(r.Context(), activeItemID)
+		if err != nil {
+			logger.Warn("Error getting reading progress", "error", err)
+		}
+		content, part := contentPart(r, renderHighlights(r, c, logger, activeItemID, itemScs.ContentHTML), progress)
+		content = proxyImages(r.Context(), c, logger, authedUser, activeItemID, content)
+
+		chapters, err := c.ReadChapterProgress(r.Context(), activeItemID, itemScs, time.Now())
+		if err != nil {
+			logger.Warn("Error getting chapter progress", "error", err)
+		}
+		note, err := c.GetItemNote(r.Context(), activeItemID)
+		if err != nil {
+			logger.Warn("Error getting item note", "error", err)
+		}
+
 		data := struct {
-			Title   string
-			Content template.HTML
-			NavNext string
-			NavPrev string
-			ItemID  int64
+			Title          string
+			Byline         string
+			SiteName       string
+			Published      *time.Time
+			ReadingMinutes int
+			Content        template.HTML
+			NavNext        string
+			NavPrev        string
+			ItemID         int64
+			Chapters       *core.ChapterProgress
+			Part           readPart
+			Resume         int
+			NavDebug       *core.NavReport
+			NavDebugError  string
+			NavUnsure      *navUnsure
+			ChapterIndex   bool
+			Fallback       string
+			Degraded       bool
+			Original       bool
+			HasOriginal    bool
+			Style          template.CSS
+			Note           string
 		}{
-			Title:   itemScs.Title,
-			Content: template.HTML(itemScs.ContentHTML),
-			NavNext: core.RelativizeURL(itemScs.NavNext),
-			NavPrev: core.RelativizeURL(itemScs.NavPrev),
-			ItemID:  activeItemID,
+			Title:          itemScs.Title,
+			Byline:         itemScs.Byline,
+			SiteName:       itemScs.SiteName,
+			Published:      core.ParsePublishedTime(itemScs.PublishedTime),
+			ReadingMinutes: core.EstimateReadingMinutes(itemScs.ContentHTML),
+			Content:        template.HTML(content),
+			NavNext:        core.RelativizeURL(itemScs.NavNext),
+			NavPrev:        core.RelativizeURL(itemScs.NavPrev),
+			ItemID:         activeItemID,
+			Chapters:       chapters,
+			Part:           part,
+			Resume:         resumeAt(progress, part),
+			NavDebug:       navDebug,
+			NavDebugError:  navDebugError,
+			NavUnsure:      unsureNav(itemScs),
+			ChapterIndex:   itemScs.ChapterIndex,
+			Fallback:       itemScs.Fallback,
+			Degraded:       itemScs.Degraded,
+			HasOriginal:    hasOriginal(r.Context(), c, logger, activeItemID),
+			Style:          readingStyle(r, c, logger, authedUser.ID),
+			Note:           note,
+		}
+
+		etag, err := readerETag(TEMPLATE_READ, data)
+		if err != nil {
+			logger.Error("Error computing ETag", "error", err)
+		} else if notModified(w, r, etag) {
+			return
 		}
 
-		if err := tmpl.Execute(w, data); err != nil {
+		// Long chapters are streamed, the top of the page shows before the
+		// rest arrives.
+		if err := tmpl.Execute(newFlushWriter(w), data); err != nil {
 			logger.Error("Error executing template", "error", err)
-			http.Error(w, "Internal server error", http.StatusInternalServerError)
 			return
 		}
 	})
@@ -144,6 +355,8 @@ func handleReadActive(c *core.Core, auth *AuthService, logger *slog.Logger) http
 
 func handleRead(c *core.Core, auth *AuthService, logger *slog.Logger) http.Handler {
 	tmpl := template.Must(template.New("read").Parse(TEMPLATE_READ))
+	tmplPrint := template.Must(template.New("print").Parse(TEMPLATE_PRINT))
+	tmplError := template.Must(template.New("fetch_error").Parse(TEMPLATE_FETCH_ERROR))
 
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "text/html; charset=utf-8")
@@ -165,40 +378,214 @@ func handleRead(c *core.Core, auth *AuthService, logger *slog.Logger) http.Handl
 			return
 		}
 
-		if err := auth.RequireOwnership(r.Context(), authedUser.Username, itemIDInt); err != nil {
+		if err := auth.RequireOwnership(r.Context(), authedUser.ID, itemIDInt); err != nil {
 			auth.HandleAuthError(w, r, err)
 			return
 		}
 
-		itemScs, err := c.ReadItem(r.Context(), itemIDInt, time.Now())
+		if r.URL.Query().Get("format") == "print" {
+			renderPrint(w, r, c, tmplPrint, tmplError, logger, itemIDInt)
+			return
+		}
+
+		// ?source=archive reads the archived copy, for pages that are gone
+		// or blocked, ?mode=original the page as the site serves it, for
+		// what the extractors took out.
+		read, original := c.ReadItem, false
+		switch {
+		case r.URL.Query().Get("source") == "archive":
+			read = c.ReadItemFromArchive
+		case r.URL.Query().Get("mode") == "original":
+			read, original = c.ReadItemOriginal, true
+		}
+		itemScs, err := read(r.Context(), itemIDInt, time.Now())
+		if errors.Is(err, core.ErrNoOriginal) {
+			http.Error(w, "This item has no original page", http.StatusNotFound)
+			return
+		}
 		if err != nil {
 			logger.Error("Error reading item", "error", err)
-			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			renderFetchError(w, r, c, tmplError, logger, itemIDInt, err)
 			return
 		}
 
+		// ?debug=nav shows how the nav links were picked.
+		var navDebug *core.NavReport
+		var navDebugError string
+		if r.URL.Query().Get("debug") == "nav" {
+			navDebug, err = c.DebugNav(r.Context(), itemIDInt)
+			if err != nil {
+				navDebugError = err.Error()
+			}
+		}
+
+		progress, err := c.GetProgress(r.Context(), itemIDInt)
+		if err != nil {
+			logger.Warn("Error getting reading progress", "error", err)
+		}
+		// The original is read whole, without highlights, their places are
+		// the clean's.
+		content, part := itemScs.ContentHTML, readPart{}
+		if !original {
+			content, part = contentPart(r, renderHighlights(r, c, logger, itemIDInt, itemScs.ContentHTML), progress)
+		}
+		content = proxyImages(r.Context(), c, logger, authedUser, itemIDInt, content)
+
+		chapters, err := c.ReadChapterProgress(r.Context(), itemIDInt, itemScs, time.Now())
+		if err != nil {
+			logger.Warn("Error getting chapter progress", "error", err)
+		}
+		note, err := c.GetItemNote(r.Context(), itemIDInt)
+		if err != nil {
+			logger.Warn("Error getting item note", "error", err)
+		}
+
 		data := struct {
-			Title   string
-			Content template.HTML
-			NavNext string
-			NavPrev string
-			ItemID  int64
+			Title          string
+			Byline         string
+			SiteName       string
+			Published      *time.Time
+			ReadingMinutes int
+			Content        template.HTML
+			NavNext        string
+			NavPrev        string
+			ItemID         int64
+			Chapters       *core.ChapterProgress
+			Part           readPart
+			Resume         int
+			NavDebug       *core.NavReport
+			NavDebugError  string
+			NavUnsure      *navUnsure
+			ChapterIndex   bool
+			Fallback       string
+			Degraded       bool
+			Original       bool
+			HasOriginal    bool
+			Style          template.CSS
+			Note           string
 		}{
-			Title:   itemScs.Title,
-			Content: template.HTML(itemScs.ContentHTML),
-			NavNext: core.RelativizeURL(itemScs.NavNext),
-			NavPrev: core.RelativizeURL(itemScs.NavPrev),
-			ItemID:  itemIDInt,
+			Title:          itemScs.Title,
+			Byline:         itemScs.Byline,
+			SiteName:       itemScs.SiteName,
+			Published:      core.ParsePublishedTime(itemScs.PublishedTime),
+			ReadingMinutes: core.EstimateReadingMinutes(itemScs.ContentHTML),
+			Content:        template.HTML(content),
+			NavNext:        core.RelativizeURL(itemScs.NavNext),
+			NavPrev:        core.RelativizeURL(itemScs.NavPrev),
+			ItemID:         itemIDInt,
+			Chapters:       chapters,
+			Part:           part,
+			Resume:         resumeAt(progress, part),
+			NavDebug:       navDebug,
+			NavDebugError:  navDebugError,
+			NavUnsure:      unsureNav(itemScs),
+			ChapterIndex:   itemScs.ChapterIndex,
+			Fallback:       itemScs.Fallback,
+			Degraded:       itemScs.Degraded,
+			Original:       original,
+			HasOriginal:    hasOriginal(r.Context(), c, logger, itemIDInt),
+			Style:          readingStyle(r, c, logger, authedUser.ID),
+			Note:           note,
+		}
+
+		etag, err := readerETag(TEMPLATE_READ, data)
+		if err != nil {
+			logger.Error("Error computing ETag", "error", err)
+		} else if notModified(w, r, etag) {
+			return
 		}
 
-		if err := tmpl.Execute(w, data); err != nil {
+		// Long chapters are streamed, the top of the page shows before the
+		// rest arrives.
+		if err := tmpl.Execute(newFlushWriter(w), data); err != nil {
 			logger.Error("Error executing template", "error", err)
-			http.Error(w, "Internal server error", http.StatusInternalServerError)
 			return
 		}
 	})
 }
 
+// readPart is the part of a long chapter being read.
+type readPart struct {
+	Number int
+	Total  int
+}
+
+// Prev is the number of the previous part, zero on the first.
+func (p readPart) Prev() int {
+	return p.Number - 1
+}
+
+// Next is the number of the next part, zero on the last.
+func (p readPart) Next() int {
+	if p.Number >= p.Total {
+		return 0
+	}
+	return p.Number + 1
+}
+
+// navUnsure tells the reader which nav links were guessed without much to
+// go on, with the other links they could be.
+type navUnsure struct {
+	Next        bool
+	Prev        bool
+	NextChoices []core.NavLink
+	PrevChoices []core.NavLink
+}
+
+// unsureNav returns the unsure nav links of the clean, nil when there are
+// none.
+func unsureNav(clean *core.Clean) *navUnsure {
+	unsure := func(confidence float64) bool {
+		return confidence > 0 && confidence < core.NavUnsureBelow
+	}
+	choices := func(links []core.NavLink) []core.NavLink {
+		relative := make([]core.NavLink, len(links))
+		for i, link := range links {
+			relative[i] = core.NavLink{URL: core.RelativizeURL(link.URL), Text: link.Text}
+		}
+		return relative
+	}
+	n := &navUnsure{
+		Next: clean.NavNext != "" && unsure(clean.NavNextConfidence),
+		Prev: clean.NavPrev != "" && unsure(clean.NavPrevConfidence),
+	}
+	if !n.Next && !n.Prev {
+		return nil
+	}
+	if n.Next {
+		n.NextChoices = choices(clean.NavNextAlternatives)
+	}
+	if n.Prev {
+		n.PrevChoices = choices(clean.NavPrevAlternatives)
+	}
+	return n
+}
+
+// hasOriginal tells whether the item's page can be read as the site serves
+// it, for the reader's toggle.
+func hasOriginal(ctx context.Context, c *core.Core, logger *slog.Logger, itemID int64) bool {
+	ok, err := c.ItemHasOriginal(ctx, itemID)
+	if err != nil {
+		logger.Warn("Error checking for the original page", "error", err)
+	}
+	return ok
+}
+
+// contentPart picks the ?part= of content too long to read at once, by
+// default the one the reader was on, or the first.
+func contentPart(r *http.Request, contentHTML string, progress *core.ReadingProgress) (string, readPart) {
+	parts := core.SplitContent(contentHTML, core.MaxPartBytes)
+	number, err := strconv.Atoi(r.URL.Query().Get("part"))
+	if err != nil || number < 1 {
+		number = 1
+		if progress != nil && !r.URL.Query().Has("part") {
+			number = progress.Part
+		}
+	}
+	number = min(number, len(parts))
+	return parts[number-1], readPart{Number: number, Total: len(parts)}
+}
+
 func navigateItemShared(ctx context.Context, c *core.Core, queries *db.Queries, itemID int64, targetPath string) error {
 	if targetPath != "" && (len(targetPath) == 0 || targetPath[0] != '/') {
 		return fmt.Errorf("invalid target path: %s", targetPath)
@@ -231,7 +618,7 @@ func handleReadNavActive(c *core.Core, auth *AuthService, logger *slog.Logger) h
 		}
 
 		// Check ownership
-		if err := auth.RequireOwnership(r.Context(), authedUser.Username, itemID); err != nil {
+		if err := auth.RequireOwnership(r.Context(), authedUser.ID, itemID); err != nil {
 			auth.HandleAuthError(w, r, err)
 			return
 		}
@@ -274,7 +661,7 @@ func handleReadNav(c *core.Core, auth *AuthService, logger *slog.Logger) http.Ha
 			return
 		}
 
-		if err := auth.RequireOwnership(r.Context(), authedUser.Username, itemIDInt); err != nil {
+		if err := auth.RequireOwnership(r.Context(), authedUser.ID, itemIDInt); err != nil {
 			auth.HandleAuthError(w, r, err)
 			return
 		}
@@ -295,44 +682,117 @@ func handleReadNav(c *core.Core, auth *AuthService, logger *slog.Logger) http.Ha
 	})
 }
 
-func handleLoginPost(logger *slog.Logger, queries *db.Queries, sessionStore *sessions.CookieStore) http.Handler {
+func handleLoginPost(c *core.Core, logger *slog.Logger, queries *db.Queries, auth *AuthService) http.Handler {
 	return http.HandlerFunc(
 		func(w http.ResponseWriter, r *http.Request) {
 			username := r.FormValue("username")
 			providedPassword := r.FormValue("password")
 
-			hashedPassword, err := queries.UsersGetPassword(r.Context(), username)
-			if err != nil {
-				if errors.Is(err, sql.ErrNoRows) {
-					http.Error(w, "Invalid credentials", http.StatusUnauthorized)
-					return
-				}
-				logger.Error("Failed to get password", "username", username, "error", err)
-				http.Error(w, "Internal server error", http.StatusInternalServerError)
-				return
-			}
-			err = bcrypt.CompareHashAndPassword([]byte(hashedPassword), []byte(providedPassword))
-			if err != nil {
-				http.Error(w, "Invalid credentials", http.StatusUnauthorized)
+			user, ok := checkLogin(w, r, c, logger, queries, auth, username, providedPassword)
+			if !ok {
 				return
 			}
 
-			session, err := sessionStore.Get(r, "kindlepathy")
-			if err != nil {
-				http.Error(w, err.Error(), http.StatusInternalServerError)
+			if err := auth.StartSession(w, r, user.ID); err != nil {
+				logger.Error("Failed to start session", "username", username, "error", err)
+				http.Error(w, "Internal server error", http.StatusInternalServerError)
 				return
 			}
 
-			session.Values["authenticated"] = true
-			session.Values["username"] = username
-			session.Save(r, w)
-
-			http.Redirect(w, r, "/library", http.StatusSeeOther)
+			http.Redirect(w, r, landingPath(user), http.StatusSeeOther)
 		},
 	)
 }
 
-func handleSignupPost(logger *slog.Logger, queries *db.Queries) http.Handler {
+// checkLogin checks the credentials of a login, counting failures towards
+// the lockout. It answers the request itself when they don't check out.
+func checkLogin(w http.ResponseWriter, r *http.Request, c *core.Core, logger *slog.Logger, queries *db.Queries, auth *AuthService, username, providedPassword string) (db.User, bool) {
+	if auth.loginLimit.limited(w, time.Now(), loginLimitKeys(auth, r, username)...) {
+		return db.User{}, false
+	}
+	return verifyLogin(w, r, c, logger, queries, auth, username, providedPassword)
+}
+
+// loginLimitKeys are the buckets a password check of the username counts
+// in. Limited per username as well as per IP, a password checked from many
+// addresses is still guessed at slowly.
+func loginLimitKeys(auth *AuthService, r *http.Request, username string) []string {
+	return []string{"ip:" + auth.clientIP(r), "user:" + strings.ToLower(username)}
+}
+
+// verifyLogin is checkLogin without the rate limit.
+func verifyLogin(w http.ResponseWriter, r *http.Request, c *core.Core, logger *slog.Logger, queries *db.Queries, auth *AuthService, username, providedPassword string) (db.User, bool) {
+	user, err := queries.UsersGetByName(r.Context(), username)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, "Invalid credentials", http.StatusUnauthorized)
+			return db.User{}, false
+		}
+		logger.Error("Failed to get user", "username", username, "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return db.User{}, false
+	}
+
+	now := time.Now()
+	lockedUntil, err := auth.LockedUntil(r.Context(), user.ID, now)
+	if err != nil {
+		logger.Error("Failed to check lockout", "username", username, "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return db.User{}, false
+	}
+	if lockedUntil != nil {
+		w.Header().Set("Retry-After", strconv.Itoa(int(lockedUntil.Sub(now).Seconds())+1))
+		http.Error(w, "Too many failed logins, the account is locked for a while", http.StatusTooManyRequests)
+		return db.User{}, false
+	}
+
+	ok, err := auth.CheckPassword(r.Context(), user, providedPassword)
+	if err != nil {
+		if !ok {
+			logger.Error("Failed to check password", "username", username, "error", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return db.User{}, false
+		}
+		logger.Warn("Failed to upgrade password hash", "username", username, "error", err)
+	}
+	if !ok {
+		locked, err := auth.LoginFailed(r.Context(), user.ID, now)
+		if err != nil {
+			logger.Error("Failed to record login failure", "username", username, "error", err)
+		}
+		if locked {
+			ip := auth.clientIP(r)
+			logger.Warn("Account locked after failed logins", "username", username, "ip", ip)
+			go notifyLockout(c, logger, user.ID, ip, now)
+		}
+		http.Error(w, "Invalid credentials", http.StatusUnauthorized)
+		return db.User{}, false
+	}
+	if user.DisabledTs != nil {
+		http.Error(w, "This account is disabled", http.StatusForbidden)
+		return db.User{}, false
+	}
+	if err := auth.UnlockAccount(r.Context(), user.ID); err != nil {
+		logger.Warn("Failed to clear login failures", "username", username, "error", err)
+	}
+	return user, true
+}
+
+// appLoginRefusal says why the user can't use an API token or an app's
+// login, empty when they can. Besides disabled accounts, it's the ones an
+// admin forced a password reset of, until the new password is set through
+// the web login.
+func appLoginRefusal(user db.User) string {
+	if user.DisabledTs != nil {
+		return "This account is disabled"
+	}
+	if user.MustChangePassword {
+		return "A new password must be set on the account page first"
+	}
+	return ""
+}
+
+func handleSignupPost(logger *slog.Logger, queries *db.Queries, auth *AuthService) http.Handler {
 	return http.HandlerFunc(
 		func(w http.ResponseWriter, r *http.Request) {
 			username := r.FormValue("username")
@@ -349,6 +809,16 @@ func handleSignupPost(logger *slog.Logger, queries *db.Queries) http.Handler {
 				return
 			}
 
+			if err := auth.CheckNewPassword(password, username); err != nil {
+				if errors.Is(err, pw.ErrTooShort) || errors.Is(err, pw.ErrTooWeak) || errors.Is(err, pw.ErrBreached) {
+					http.Error(w, err.Error(), http.StatusBadRequest)
+					return
+				}
+				logger.Error("Error checking password", "error", err)
+				http.Error(w, "Internal server error", http.StatusInternalServerError)
+				return
+			}
+
 			_, err := queries.UsersGetByName(r.Context(), username)
 			if err == nil {
 				http.Error(w, "Username already exists", http.StatusConflict)
@@ -360,14 +830,14 @@ func handleSignupPost(logger *slog.Logger, queries *db.Queries) http.Handler {
 				return
 			}
 
-			hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+			hashedPassword, err := auth.HashPassword(password)
 			if err != nil {
 				logger.Error("Error hashing password", "error", err)
 				http.Error(w, "Internal server error", http.StatusInternalServerError)
 				return
 			}
 
-			_, err = queries.UsersAdd(r.Context(), db.UsersAddParams{Username: username, Password: string(hashedPassword)})
+			_, err = queries.UsersAdd(r.Context(), db.UsersAddParams{Username: username, Password: hashedPassword})
 			if err != nil {
 				logger.Error("Error creating user", "error", err)
 				http.Error(w, "Internal server error", http.StatusInternalServerError)
@@ -379,21 +849,10 @@ func handleSignupPost(logger *slog.Logger, queries *db.Queries) http.Handler {
 	)
 }
 
-func handleLogout(sessionStore *sessions.CookieStore) http.Handler {
+func handleLogout(logger *slog.Logger, auth *AuthService) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		session, err := sessionStore.Get(r, "kindlepathy")
-		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
-		}
-
-		// Clear session values
-		session.Values["authenticated"] = false
-		session.Values["username"] = ""
-
-		// Save the session
-		err = session.Save(r, w)
-		if err != nil {
+		if err := auth.EndSession(w, r); err != nil {
+			logger.Error("Failed to end session", "error", err)
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
@@ -403,27 +862,17 @@ func handleLogout(sessionStore *sessions.CookieStore) http.Handler {
 	})
 }
 
-func newAuthMiddleware(sessionStore *sessions.CookieStore, queries *db.Queries) func(h http.Handler) http.Handler {
+func newAuthMiddleware(c *core.Core, auth *AuthService, queries *db.Queries, logger *slog.Logger) func(h http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			session, err := sessionStore.Get(r, "kindlepathy")
+			// Expired, revoked and missing sessions all need a new login.
+			session, err := auth.currentSession(r)
 			if err != nil {
-				http.Error(w, err.Error(), http.StatusInternalServerError)
-				return
-			}
-
-			if auth, ok := session.Values["authenticated"].(bool); !ok || !auth {
 				http.Redirect(w, r, "/login", http.StatusSeeOther)
 				return
 			}
 
-			username, ok := session.Values["username"].(string)
-			if !ok {
-				http.Redirect(w, r, "/login", http.StatusSeeOther)
-				return
-			}
-
-			user, err := queries.UsersGetByName(r.Context(), username)
+			user, err := queries.UsersGetBySession(r.Context(), session.ID)
 			if err != nil {
 				// If user in session doesn't exist in DB, treat as logged out.
 				http.Redirect(w, r, "/login", http.StatusSeeOther)
@@ -441,7 +890,36 @@ func newAuthMiddleware(sessionStore *sessions.CookieStore, queries *db.Queries)
 				ID:           user.ID,
 				Username:     user.Username,
 				ActiveItemID: activeItemID,
+				LandingPage:  user.LandingPage,
+				IsAdmin:      user.IsAdmin,
+				EinkMode:     user.EinkMode,
+				SessionID:    session.ID,
+			}
+
+			authedUser.Flags, err = c.UserFlags(r.Context(), user.ID)
+			if err != nil {
+				logger.Error("Error resolving feature flags", "error", err)
+				http.Error(w, "Internal server error", http.StatusInternalServerError)
+				return
+			}
+
+			if impersonatorID, ok := session.ImpersonatorID.(int64); ok {
+				impersonator, err := queries.UsersGet(r.Context(), impersonatorID)
+				if err != nil {
+					http.Redirect(w, r, "/login", http.StatusSeeOther)
+					return
+				}
+				authedUser.Impersonator = &Impersonator{ID: impersonator.ID, Username: impersonator.Username}
+				ctx := context.WithValue(r.Context(), userContextKey, authedUser)
+				impersonated(auth, logger, authedUser, w, r.WithContext(ctx), next)
+				return
+			}
+
+			if user.MustChangePassword && !mustChangePasswordAllows(r) {
+				http.Redirect(w, r, "/settings/account", http.StatusSeeOther)
+				return
 			}
+			authedUser.MustChangePassword = user.MustChangePassword
 
 			ctx := context.WithValue(r.Context(), userContextKey, authedUser)
 			next.ServeHTTP(w, r.WithContext(ctx))
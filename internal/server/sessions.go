@@ -0,0 +1,204 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"time"
+
+	db "github.com/egemengol/kindlepathy/internal/db/generated"
+)
+
+// sessions.go keeps login sessions on the server. The cookie only carries a
+// random session ID, so sessions can be expired and revoked.
+
+// SessionPolicy is the per-instance policy for login sessions.
+type SessionPolicy struct {
+	// MaxAge is the absolute lifetime of a session, however active it is.
+	MaxAge time.Duration
+	// IdleTimeout logs out sessions that have not been used for that long.
+	// Zero disables it.
+	IdleTimeout time.Duration
+	// MaxPerUser is the number of concurrent sessions a user can have, the
+	// least recently used ones are logged out. Zero means no limit.
+	MaxPerUser int
+	// LogoutOnPasswordChange ends every other session of a user when they
+	// change their password.
+	LogoutOnPasswordChange bool
+}
+
+func DefaultSessionPolicy() SessionPolicy {
+	return SessionPolicy{
+		MaxAge:                 7 * 24 * time.Hour,
+		LogoutOnPasswordChange: true,
+	}
+}
+
+// sessionTouchInterval limits how often the last use of a session is written.
+const sessionTouchInterval = time.Minute
+
+func (p SessionPolicy) expired(s db.Session, now time.Time) bool {
+	maxAge := p.MaxAge
+	if s.ImpersonatorID != nil {
+		maxAge = min(maxAge, impersonationMaxAge)
+	}
+	if now.Sub(time.Unix(s.CreatedTs, 0)) > maxAge {
+		return true
+	}
+	return p.IdleTimeout > 0 && now.Sub(time.Unix(s.LastSeenTs, 0)) > p.IdleTimeout
+}
+
+// hashSessionID is what gets stored, a leaked database doesn't leak sessions.
+func hashSessionID(id string) string {
+	sum := sha256.Sum256([]byte(id))
+	return hex.EncodeToString(sum[:])
+}
+
+// StartSession records a new session for the user and puts its ID in the
+// session cookie.
+func (a *AuthService) StartSession(w http.ResponseWriter, r *http.Request, userID int64) error {
+	session, err := a.sessionStore.Get(r, "kindlepathy")
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	idleCutoff := int64(0)
+	if a.policy.IdleTimeout > 0 {
+		idleCutoff = now.Add(-a.policy.IdleTimeout).Unix()
+	}
+	err = a.queries.SessionsDeleteStale(r.Context(), db.SessionsDeleteStaleParams{
+		CreatedTs:  now.Add(-a.policy.MaxAge).Unix(),
+		LastSeenTs: idleCutoff,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to delete stale sessions: %w", err)
+	}
+
+	id, err := a.addSession(r, userID, nil, now)
+	if err != nil {
+		return err
+	}
+
+	if a.policy.MaxPerUser > 0 {
+		err = a.queries.SessionsTrimPerUser(r.Context(), db.SessionsTrimPerUserParams{
+			UserID:   userID,
+			UserID_2: userID,
+			Limit:    int64(a.policy.MaxPerUser),
+		})
+		if err != nil {
+			return fmt.Errorf("failed to limit sessions: %w", err)
+		}
+	}
+
+	session.Values = map[interface{}]interface{}{"session_id": id}
+	return session.Save(r, w)
+}
+
+// addSession stores a new session and returns its ID for the cookie.
+func (a *AuthService) addSession(r *http.Request, userID int64, impersonatorID interface{}, now time.Time) (string, error) {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", fmt.Errorf("failed to generate session id: %w", err)
+	}
+	id := hex.EncodeToString(raw)
+
+	err := a.queries.SessionsAdd(r.Context(), db.SessionsAddParams{
+		ID:             hashSessionID(id),
+		UserID:         userID,
+		UserAgent:      r.UserAgent(),
+		CreatedTs:      now.Unix(),
+		LastSeenTs:     now.Unix(),
+		ImpersonatorID: impersonatorID,
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to add session: %w", err)
+	}
+	return id, nil
+}
+
+// currentSession returns the server-side session of the request, ending it
+// if the policy says it has expired.
+func (a *AuthService) currentSession(r *http.Request) (db.Session, error) {
+	session, err := a.sessionStore.Get(r, "kindlepathy")
+	if err != nil {
+		return db.Session{}, err
+	}
+	id, ok := session.Values["session_id"].(string)
+	if !ok || id == "" {
+		return db.Session{}, fmt.Errorf("user not found in session")
+	}
+
+	s, err := a.queries.SessionsGet(r.Context(), hashSessionID(id))
+	if err != nil {
+		return db.Session{}, fmt.Errorf("user not found in session")
+	}
+
+	now := time.Now()
+	if a.policy.expired(s, now) {
+		a.queries.SessionsDelete(r.Context(), s.ID)
+		return db.Session{}, fmt.Errorf("user not found in session")
+	}
+	if now.Sub(time.Unix(s.LastSeenTs, 0)) >= sessionTouchInterval {
+		err = a.queries.SessionsTouch(r.Context(), db.SessionsTouchParams{
+			LastSeenTs: now.Unix(),
+			ID:         s.ID,
+		})
+		if err != nil {
+			return db.Session{}, fmt.Errorf("failed to update session: %w", err)
+		}
+		s.LastSeenTs = now.Unix()
+	}
+	return s, nil
+}
+
+// EndSession logs out the session of the request.
+func (a *AuthService) EndSession(w http.ResponseWriter, r *http.Request) error {
+	session, err := a.sessionStore.Get(r, "kindlepathy")
+	if err != nil {
+		return err
+	}
+	if id, ok := session.Values["session_id"].(string); ok {
+		if err := a.queries.SessionsDelete(r.Context(), hashSessionID(id)); err != nil {
+			return fmt.Errorf("failed to delete session: %w", err)
+		}
+	}
+	session.Values = map[interface{}]interface{}{}
+	return session.Save(r, w)
+}
+
+// EndAllSessions logs the user out everywhere, including the current session.
+func (a *AuthService) EndAllSessions(ctx context.Context, userID int64) error {
+	return a.queries.SessionsDeletePerUser(ctx, userID)
+}
+
+// PasswordChanged applies the policy after the user changed their password,
+// logging out every session but the one that changed it.
+func (a *AuthService) PasswordChanged(ctx context.Context, user AuthenticatedUser) error {
+	if !a.policy.LogoutOnPasswordChange {
+		return nil
+	}
+	return a.queries.SessionsDeleteOthersPerUser(ctx, db.SessionsDeleteOthersPerUserParams{
+		UserID: user.ID,
+		ID:     user.SessionID,
+	})
+}
+
+// ListSessions returns the active sessions of the user, most recent first.
+func (a *AuthService) ListSessions(ctx context.Context, userID int64) ([]db.Session, error) {
+	sessions, err := a.queries.SessionsListPerUser(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list sessions: %w", err)
+	}
+	now := time.Now()
+	active := sessions[:0]
+	for _, s := range sessions {
+		if !a.policy.expired(s, now) {
+			active = append(active, s)
+		}
+	}
+	return active, nil
+}
@@ -0,0 +1,46 @@
+package server
+
+import (
+	"errors"
+	"io"
+	"net/http"
+)
+
+// streamChunk is how much of a page is sent at a time. The reader's head is
+// smaller, so the header and the first paragraphs go out together and the
+// Kindle paints them while the rest of a long chapter is on its way.
+const streamChunk = 16 << 10
+
+// flushWriter sends the response every streamChunk bytes instead of when the
+// server's buffer happens to fill, splitting large writes like the content.
+type flushWriter struct {
+	w       io.Writer
+	rc      *http.ResponseController
+	pending int
+}
+
+func newFlushWriter(w http.ResponseWriter) *flushWriter {
+	return &flushWriter{w: w, rc: http.NewResponseController(w)}
+}
+
+func (f *flushWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		chunk := p[:min(len(p), streamChunk-f.pending)]
+		n, err := f.w.Write(chunk)
+		written += n
+		if err != nil {
+			return written, err
+		}
+		p = p[n:]
+		f.pending += n
+		if f.pending >= streamChunk {
+			// Writers that can't flush still get the whole page.
+			if err := f.rc.Flush(); err != nil && !errors.Is(err, http.ErrNotSupported) {
+				return written, err
+			}
+			f.pending = 0
+		}
+	}
+	return written, nil
+}
@@ -0,0 +1,124 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	_ "embed"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/egemengol/kindlepathy/internal/core"
+	migrate "github.com/egemengol/kindlepathy/internal/db"
+	db "github.com/egemengol/kindlepathy/internal/db/generated"
+	_ "github.com/mattn/go-sqlite3"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// A database created before versioned migrations has the schema of
+// testdata/baseline_schema.sql and bcrypt hashed passwords. Migrated, its
+// users still log in and find their library.
+
+//go:embed testdata/baseline_schema.sql
+var baselineSchema string
+
+func TestMigrateBaselineAndLogIn(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "kindlepathy.db")
+
+	old, err := sql.Open("sqlite3", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte("hunter22"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, stmt := range []string{
+		baselineSchema,
+		"INSERT INTO users (id, username, password) VALUES (1, 'old', '" + string(hash) + "')",
+		"INSERT INTO items (user_id, title, url, added_ts, read_ts) VALUES (1, 'An old item', 'https://example.com/old', 1700000000, 1700000100)",
+		"UPDATE users SET active_item_id = 1",
+	} {
+		if _, err := old.ExecContext(ctx, stmt); err != nil {
+			t.Fatalf("failed to build the baseline database: %v", err)
+		}
+	}
+	old.Close()
+
+	sqlDB, err := migrate.Open(ctx, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sqlDB.Close()
+	if err := migrate.Migrate(ctx, sqlDB); err != nil {
+		t.Fatalf("failed to migrate the baseline database: %v", err)
+	}
+	migrations, err := migrate.Migrations()
+	if err != nil {
+		t.Fatal(err)
+	}
+	version, err := migrate.SchemaVersion(ctx, sqlDB)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if latest := migrations[len(migrations)-1].Version; version != latest {
+		t.Fatalf("schema version is %d after migrating, want %d", version, latest)
+	}
+
+	queries := db.New(sqlDB)
+	c := core.NewCore(http.DefaultClient, nil, queries, slog.Default(), nil, nil)
+	srv := httptest.NewServer(NewServer(c, slog.Default(), queries, []byte("01234567890123456789012345678901"), DefaultAuthConfig(), nil))
+	defer srv.Close()
+	client := srv.Client()
+	client.CheckRedirect = func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }
+
+	resp, err := client.PostForm(srv.URL+"/login", url.Values{"username": {"old"}, "password": {"hunter22"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusSeeOther {
+		t.Fatalf("login answered %d, want %d", resp.StatusCode, http.StatusSeeOther)
+	}
+	cookies := resp.Cookies()
+	if len(cookies) == 0 {
+		t.Fatal("login set no session cookie")
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL+"/library", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, cookie := range cookies {
+		req.AddCookie(cookie)
+	}
+	resp, err = client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("library answered %d, want %d: %s", resp.StatusCode, http.StatusOK, body)
+	}
+	if !strings.Contains(string(body), "An old item") {
+		t.Fatal("library doesn't list the item saved before migrating")
+	}
+
+	user, err := queries.UsersGetByName(ctx, "old")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.LandingPage != core.LandingLibrary || user.EinkMode != core.EinkOff || user.IsAdmin {
+		t.Fatalf("migrated user has landing page %q, e-ink mode %q and admin %v", user.LandingPage, user.EinkMode, user.IsAdmin)
+	}
+}
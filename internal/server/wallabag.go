@@ -0,0 +1,637 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/egemengol/kindlepathy/internal/core"
+	db "github.com/egemengol/kindlepathy/internal/db/generated"
+)
+
+// wallabag.go speaks enough of the Wallabag v2 API for its apps, like the
+// KOReader plugin, to use kindlepathy as their server. Entries are items:
+// archived is read, and starred is the "starred" tag.
+//
+// The OAuth access tokens are API tokens, one per client that logged in.
+// They don't expire, the refresh token is the access token again. Any client
+// ID and secret are accepted, there are no clients to register.
+
+const (
+	wallabagVersion    = "2.6.0"
+	wallabagStarredTag = "starred"
+	// wallabagTokenLifetime is what clients are told, tokens last until
+	// they're deleted or the client logs in again.
+	wallabagTokenLifetime = 365 * 24 * 60 * 60
+	wallabagTimeFormat    = "2006-01-02T15:04:05-0700"
+	wallabagPerPage       = 30
+)
+
+type wallabagLink struct {
+	Href string `json:"href"`
+}
+
+type wallabagTag struct {
+	Label string `json:"label"`
+	Slug  string `json:"slug"`
+}
+
+type wallabagEntry struct {
+	ID          int64                   `json:"id"`
+	URL         string                  `json:"url"`
+	GivenURL    string                  `json:"given_url"`
+	Title       string                  `json:"title"`
+	Content     string                  `json:"content"`
+	IsArchived  int                     `json:"is_archived"`
+	IsStarred   int                     `json:"is_starred"`
+	IsPublic    bool                    `json:"is_public"`
+	Tags        []wallabagTag           `json:"tags"`
+	CreatedAt   string                  `json:"created_at"`
+	UpdatedAt   string                  `json:"updated_at"`
+	ArchivedAt  *string                 `json:"archived_at"`
+	StarredAt   *string                 `json:"starred_at"`
+	ReadingTime int                     `json:"reading_time"`
+	DomainName  string                  `json:"domain_name"`
+	Mimetype    string                  `json:"mimetype"`
+	UserID      int64                   `json:"user_id"`
+	UserName    string                  `json:"user_name"`
+	Links       map[string]wallabagLink `json:"_links"`
+}
+
+func wallabagTime(t time.Time) string {
+	return t.Format(wallabagTimeFormat)
+}
+
+func wallabagEntryFrom(item core.Item, user AuthenticatedUser, content string) wallabagEntry {
+	entry := wallabagEntry{
+		ID:         item.ID,
+		URL:        item.URL,
+		GivenURL:   item.URL,
+		Title:      item.Title,
+		Content:    content,
+		Tags:       []wallabagTag{},
+		CreatedAt:  wallabagTime(item.AddedTs),
+		UpdatedAt:  wallabagTime(item.AddedTs),
+		Mimetype:   "text/html",
+		UserID:     user.ID,
+		UserName:   user.Username,
+		Links:      map[string]wallabagLink{"self": {Href: fmt.Sprintf("/api/entries/%d", item.ID)}},
+		DomainName: item.URL,
+	}
+	if entry.Title == "" {
+		entry.Title = item.URL
+	}
+	if u, err := url.Parse(item.URL); err == nil {
+		entry.DomainName = u.Host
+	}
+	if item.ReadTs != nil {
+		archived := wallabagTime(*item.ReadTs)
+		entry.IsArchived = 1
+		entry.ArchivedAt = &archived
+		entry.UpdatedAt = archived
+	}
+	for _, tag := range item.Tags {
+		if tag == wallabagStarredTag {
+			entry.IsStarred = 1
+		}
+		entry.Tags = append(entry.Tags, wallabagTag{Label: tag, Slug: core.Slugify(tag)})
+	}
+	if content != "" {
+		entry.ReadingTime = core.EstimateReadingMinutes(content)
+	}
+	return entry
+}
+
+// wallabagParams returns the parameters of a request, Wallabag clients send
+// them as a form or as JSON.
+func wallabagParams(r *http.Request) (url.Values, error) {
+	if !strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") {
+		if err := r.ParseForm(); err != nil {
+			return nil, err
+		}
+		return r.Form, nil
+	}
+
+	var body map[string]any
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		return nil, err
+	}
+	params := r.URL.Query()
+	for key, value := range body {
+		switch value := value.(type) {
+		case string:
+			params.Set(key, value)
+		case float64:
+			params.Set(key, strconv.FormatFloat(value, 'f', -1, 64))
+		case bool:
+			params.Set(key, "0")
+			if value {
+				params.Set(key, "1")
+			}
+		case []any:
+			var values []string
+			for _, v := range value {
+				values = append(values, fmt.Sprint(v))
+			}
+			params.Set(key, strings.Join(values, ","))
+		}
+	}
+	return params, nil
+}
+
+func wallabagTags(s string) []string {
+	var tags []string
+	for _, tag := range strings.Split(s, ",") {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
+
+// wallabagEntryID parses the {entry} of /api/entries/{entry}.json.
+func wallabagEntryID(r *http.Request) (int64, error) {
+	return strconv.ParseInt(strings.TrimSuffix(r.PathValue("entry"), ".json"), 10, 64)
+}
+
+func writeWallabagError(w http.ResponseWriter, status int, code, description string) {
+	writeJSON(w, status, map[string]string{"error": code, "error_description": description})
+}
+
+// POST /oauth/v2/token - An access token for the password or the refresh
+// token
+func handleWallabagToken(c *core.Core, logger *slog.Logger, queries *db.Queries, auth *AuthService) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		params, err := wallabagParams(r)
+		if err != nil {
+			writeWallabagError(w, http.StatusBadRequest, "invalid_request", "Invalid request body")
+			return
+		}
+
+		var token string
+		switch params.Get("grant_type") {
+		case "password":
+			user, ok := checkLogin(w, r, c, logger, queries, auth, params.Get("username"), params.Get("password"))
+			if !ok {
+				return
+			}
+			if refusal := appLoginRefusal(user); refusal != "" {
+				writeWallabagError(w, http.StatusForbidden, "access_denied", refusal)
+				return
+			}
+			name := "Wallabag"
+			if clientID := params.Get("client_id"); clientID != "" {
+				name += ": " + clientID
+			}
+			token, err = c.RenewAPIToken(r.Context(), user.ID, name, time.Now())
+			if err != nil {
+				logger.Error("Error creating API token", "error", err)
+				http.Error(w, "Internal server error", http.StatusInternalServerError)
+				return
+			}
+		case "refresh_token":
+			token = params.Get("refresh_token")
+			user, err := queries.UsersGetByApiToken(r.Context(), core.HashAPIToken(token))
+			if err != nil {
+				writeWallabagError(w, http.StatusBadRequest, "invalid_grant", "Invalid refresh token")
+				return
+			}
+			if refusal := appLoginRefusal(user); refusal != "" {
+				writeWallabagError(w, http.StatusForbidden, "access_denied", refusal)
+				return
+			}
+		default:
+			writeWallabagError(w, http.StatusBadRequest, "unsupported_grant_type", "Only the password and refresh_token grants are supported")
+			return
+		}
+
+		writeJSON(w, http.StatusOK, map[string]any{
+			"access_token":  token,
+			"expires_in":    wallabagTokenLifetime,
+			"token_type":    "bearer",
+			"scope":         nil,
+			"refresh_token": token,
+		})
+	})
+}
+
+// GET /api/entries - The entries, filtered and paginated
+func handleWallabagEntriesGet(c *core.Core, auth *AuthService, logger *slog.Logger) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authedUser, err := auth.GetAuthenticatedUser(r)
+		if err != nil {
+			http.Error(w, "API token required", http.StatusUnauthorized)
+			return
+		}
+		params := r.URL.Query()
+
+		items, err := c.ListItems(r.Context(), authedUser.ID)
+		if err != nil {
+			logger.Error("Error listing items", "error", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		tags := wallabagTags(params.Get("tags"))
+		var since time.Time
+		if ts, err := strconv.ParseInt(params.Get("since"), 10, 64); err == nil {
+			since = time.Unix(ts, 0)
+		}
+		updated := func(item core.Item) time.Time {
+			if item.ReadTs != nil && item.ReadTs.After(item.AddedTs) {
+				return *item.ReadTs
+			}
+			return item.AddedTs
+		}
+		items = slices.DeleteFunc(items, func(item core.Item) bool {
+			switch params.Get("archive") {
+			case "0":
+				if item.ReadTs != nil {
+					return true
+				}
+			case "1":
+				if item.ReadTs == nil {
+					return true
+				}
+			}
+			switch params.Get("starred") {
+			case "0":
+				if slices.Contains(item.Tags, wallabagStarredTag) {
+					return true
+				}
+			case "1":
+				if !slices.Contains(item.Tags, wallabagStarredTag) {
+					return true
+				}
+			}
+			for _, tag := range tags {
+				if !slices.Contains(item.Tags, tag) {
+					return true
+				}
+			}
+			if domain := params.Get("domain_name"); domain != "" {
+				if u, err := url.Parse(item.URL); err != nil || u.Host != domain {
+					return true
+				}
+			}
+			return updated(item).Before(since)
+		})
+
+		sortKey := func(item core.Item) time.Time { return item.AddedTs }
+		switch params.Get("sort") {
+		case "updated":
+			sortKey = updated
+		case "archived":
+			sortKey = func(item core.Item) time.Time {
+				if item.ReadTs == nil {
+					return time.Time{}
+				}
+				return *item.ReadTs
+			}
+		}
+		slices.SortStableFunc(items, func(a, b core.Item) int {
+			if params.Get("order") == "asc" {
+				return sortKey(a).Compare(sortKey(b))
+			}
+			return sortKey(b).Compare(sortKey(a))
+		})
+
+		page, err := strconv.Atoi(params.Get("page"))
+		if err != nil || page < 1 {
+			page = 1
+		}
+		perPage, err := strconv.Atoi(params.Get("perPage"))
+		if err != nil || perPage < 1 {
+			perPage = wallabagPerPage
+		}
+		total := len(items)
+		pages := max(1, (total+perPage-1)/perPage)
+		start := min((page-1)*perPage, total)
+		end := min(start+perPage, total)
+
+		entries := make([]wallabagEntry, 0, end-start)
+		for _, item := range items[start:end] {
+			var content string
+			if params.Get("detail") != "metadata" {
+				content, err = c.LocalItemContent(r.Context(), item.ID)
+				if err != nil {
+					logger.Warn("Error getting item content", "error", err, "itemID", item.ID)
+				}
+			}
+			entries = append(entries, wallabagEntryFrom(item, authedUser, content))
+		}
+
+		pageLink := func(page int) wallabagLink {
+			query := r.URL.Query()
+			query.Set("page", strconv.Itoa(page))
+			query.Set("perPage", strconv.Itoa(perPage))
+			return wallabagLink{Href: r.URL.Path + "?" + query.Encode()}
+		}
+		links := map[string]wallabagLink{
+			"self":  pageLink(page),
+			"first": pageLink(1),
+			"last":  pageLink(pages),
+		}
+		if page < pages {
+			links["next"] = pageLink(page + 1)
+		}
+		writeJSON(w, http.StatusOK, map[string]any{
+			"page":      page,
+			"limit":     perPage,
+			"pages":     pages,
+			"total":     total,
+			"_links":    links,
+			"_embedded": map[string]any{"items": entries},
+		})
+	})
+}
+
+// writeWallabagEntry answers with the entry, its content only when it's at
+// hand unless fetch is set.
+func writeWallabagEntry(w http.ResponseWriter, r *http.Request, c *core.Core, logger *slog.Logger, user AuthenticatedUser, itemID int64, fetch bool) {
+	item, err := c.GetItem(r.Context(), user.ID, itemID)
+	if err != nil {
+		logger.Error("Error getting item", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	var content string
+	if fetch {
+		if clean, err := c.GetItemContent(r.Context(), itemID); err != nil {
+			logger.Warn("Error getting item content", "error", err, "itemID", itemID)
+		} else {
+			content = clean.ContentHTML
+		}
+	} else {
+		content, err = c.LocalItemContent(r.Context(), itemID)
+		if err != nil {
+			logger.Warn("Error getting item content", "error", err, "itemID", itemID)
+		}
+	}
+	writeJSON(w, http.StatusOK, wallabagEntryFrom(*item, user, content))
+}
+
+// GET /api/entries/{entry} - An entry with its content
+func handleWallabagEntryGet(c *core.Core, auth *AuthService, logger *slog.Logger) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authedUser, err := auth.GetAuthenticatedUser(r)
+		if err != nil {
+			http.Error(w, "API token required", http.StatusUnauthorized)
+			return
+		}
+		itemID, err := wallabagEntryID(r)
+		if err != nil {
+			http.Error(w, "Invalid entry ID", http.StatusBadRequest)
+			return
+		}
+		if err := auth.RequireOwnership(r.Context(), authedUser.ID, itemID); err != nil {
+			auth.HandleAuthError(w, r, err)
+			return
+		}
+		writeWallabagEntry(w, r, c, logger, authedUser, itemID, true)
+	})
+}
+
+// POST /api/entries - Add an entry, with its content when the client sends it
+func handleWallabagEntriesPost(c *core.Core, auth *AuthService, logger *slog.Logger) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authedUser, err := auth.GetAuthenticatedUser(r)
+		if err != nil {
+			http.Error(w, "API token required", http.StatusUnauthorized)
+			return
+		}
+		params, err := wallabagParams(r)
+		if err != nil || params.Get("url") == "" {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+
+		now := time.Now()
+		rawurl, title := params.Get("url"), params.Get("title")
+		var itemID int64
+		if content := params.Get("content"); content != "" {
+			if title == "" {
+				title = rawurl
+			}
+			itemID, _, err = c.AddItemWithUploadedContent(r.Context(), authedUser.ID, title, rawurl, content, now)
+		} else {
+			itemID, err = c.AddItemWithTitleSetActive(r.Context(), authedUser.ID, rawurl, now)
+			if err == nil && title != "" {
+				err = c.SetItemTitle(r.Context(), itemID, title)
+			}
+		}
+		if err != nil {
+			logger.Error("Error adding item", "error", err)
+			http.Error(w, fmt.Sprintf("Failed to add item: %v", err), http.StatusBadRequest)
+			return
+		}
+
+		if err := updateWallabagEntry(r, c, authedUser, itemID, params, now); err != nil {
+			logger.Error("Error updating item", "error", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		writeWallabagEntry(w, r, c, logger, authedUser, itemID, false)
+	})
+}
+
+// updateWallabagEntry applies the tags, archive and starred parameters.
+func updateWallabagEntry(r *http.Request, c *core.Core, user AuthenticatedUser, itemID int64, params url.Values, now time.Time) error {
+	if err := c.AddTags(r.Context(), user.ID, itemID, wallabagTags(params.Get("tags"))); err != nil {
+		return err
+	}
+	switch params.Get("archive") {
+	case "1":
+		item, err := c.GetItem(r.Context(), user.ID, itemID)
+		if err != nil {
+			return err
+		}
+		if item.ReadTs == nil {
+			if err := c.SetItemRead(r.Context(), itemID, &now); err != nil {
+				return err
+			}
+		}
+	case "0":
+		if err := c.SetItemRead(r.Context(), itemID, nil); err != nil {
+			return err
+		}
+	}
+	switch params.Get("starred") {
+	case "1":
+		return c.AddTags(r.Context(), user.ID, itemID, []string{wallabagStarredTag})
+	case "0":
+		return c.RemoveTags(r.Context(), user.ID, itemID, []string{wallabagStarredTag})
+	}
+	return nil
+}
+
+// PATCH /api/entries/{entry} - Change the title, tags, archived or starred
+func handleWallabagEntryPatch(c *core.Core, auth *AuthService, logger *slog.Logger) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authedUser, err := auth.GetAuthenticatedUser(r)
+		if err != nil {
+			http.Error(w, "API token required", http.StatusUnauthorized)
+			return
+		}
+		itemID, err := wallabagEntryID(r)
+		if err != nil {
+			http.Error(w, "Invalid entry ID", http.StatusBadRequest)
+			return
+		}
+		if err := auth.RequireOwnership(r.Context(), authedUser.ID, itemID); err != nil {
+			auth.HandleAuthError(w, r, err)
+			return
+		}
+		params, err := wallabagParams(r)
+		if err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+
+		if title := params.Get("title"); title != "" {
+			if err := c.SetItemTitle(r.Context(), itemID, title); err != nil {
+				logger.Error("Error updating item", "error", err)
+				http.Error(w, "Internal server error", http.StatusInternalServerError)
+				return
+			}
+		}
+		if err := updateWallabagEntry(r, c, authedUser, itemID, params, time.Now()); err != nil {
+			logger.Error("Error updating item", "error", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		writeWallabagEntry(w, r, c, logger, authedUser, itemID, false)
+	})
+}
+
+// DELETE /api/entries/{entry} - Delete an entry, answering with it
+func handleWallabagEntryDelete(c *core.Core, auth *AuthService, logger *slog.Logger) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authedUser, err := auth.GetAuthenticatedUser(r)
+		if err != nil {
+			http.Error(w, "API token required", http.StatusUnauthorized)
+			return
+		}
+		itemID, err := wallabagEntryID(r)
+		if err != nil {
+			http.Error(w, "Invalid entry ID", http.StatusBadRequest)
+			return
+		}
+		if err := auth.RequireOwnership(r.Context(), authedUser.ID, itemID); err != nil {
+			auth.HandleAuthError(w, r, err)
+			return
+		}
+
+		item, err := c.GetItem(r.Context(), authedUser.ID, itemID)
+		if err != nil {
+			logger.Error("Error getting item", "error", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		if err := c.DeleteItem(r.Context(), itemID); err != nil {
+			logger.Error("Error deleting item", "error", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, http.StatusOK, wallabagEntryFrom(*item, authedUser, ""))
+	})
+}
+
+// GET /api/entries/exists - Whether the URLs are saved, or their entry IDs
+// with return_id=1
+func handleWallabagEntriesExists(c *core.Core, auth *AuthService, logger *slog.Logger) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authedUser, err := auth.GetAuthenticatedUser(r)
+		if err != nil {
+			http.Error(w, "API token required", http.StatusUnauthorized)
+			return
+		}
+		params := r.URL.Query()
+
+		items, err := c.ListItems(r.Context(), authedUser.ID)
+		if err != nil {
+			logger.Error("Error listing items", "error", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		byURL := make(map[string]int64, len(items))
+		for _, item := range items {
+			byURL[item.URL] = item.ID
+		}
+		exists := func(rawurl string) any {
+			id, ok := byURL[rawurl]
+			if params.Get("return_id") != "1" {
+				return ok
+			}
+			if !ok {
+				return nil
+			}
+			return id
+		}
+
+		if urls := params["urls[]"]; len(urls) > 0 {
+			results := make(map[string]any, len(urls))
+			for _, rawurl := range urls {
+				results[rawurl] = exists(rawurl)
+			}
+			writeJSON(w, http.StatusOK, results)
+			return
+		}
+		if params.Get("url") == "" {
+			http.Error(w, "url is required", http.StatusBadRequest)
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]any{"exists": exists(params.Get("url"))})
+	})
+}
+
+// GET /api/entries/{entry}/export.epub - An entry as an EPUB, which is how
+// KOReader downloads entries
+func handleWallabagEntryExport(c *core.Core, auth *AuthService, logger *slog.Logger) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authedUser, err := auth.GetAuthenticatedUser(r)
+		if err != nil {
+			http.Error(w, "API token required", http.StatusUnauthorized)
+			return
+		}
+		itemID, err := wallabagEntryID(r)
+		if err != nil {
+			http.Error(w, "Invalid entry ID", http.StatusBadRequest)
+			return
+		}
+		if r.PathValue("export") != "export.epub" {
+			http.Error(w, "Only EPUB exports are supported", http.StatusNotFound)
+			return
+		}
+		if err := auth.RequireOwnership(r.Context(), authedUser.ID, itemID); err != nil {
+			auth.HandleAuthError(w, r, err)
+			return
+		}
+
+		writeEPUB(w, r, c, logger, itemID)
+	})
+}
+
+// GET /api/version - The Wallabag version the API is compatible with
+func handleWallabagVersion() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, wallabagVersion)
+	})
+}
+
+// GET /api/info - The name and version of the server
+func handleWallabagInfo() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]any{
+			"appname":              "wallabag",
+			"version":              wallabagVersion,
+			"allowed_registration": false,
+		})
+	})
+}
@@ -0,0 +1,342 @@
+package smtpd
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/egemengol/kindlepathy/internal/core"
+)
+
+const (
+	// commandTimeout is how long a client may take to send a command, and
+	// dataTimeout to send a message.
+	commandTimeout = 5 * time.Minute
+	dataTimeout    = 10 * time.Minute
+	// addTimeout is how long adding a message to a library may take,
+	// readability included.
+	addTimeout = 2 * time.Minute
+	// maxLineBytes is the longest command line, RFC 5321 allows 512.
+	maxLineBytes = 4096
+	// maxRecipients is how many recipients a message may have.
+	maxRecipients = 100
+	// maxConnections is how many clients are served at once.
+	maxConnections = 32
+)
+
+// Server receives mail for the users' addresses and adds it to their
+// libraries. It speaks as much SMTP as mail servers need to deliver
+// messages, without TLS or authentication: it's meant to be the MX of the
+// inbound domain, or to be relayed to by one.
+type Server struct {
+	listener net.Listener
+	domain   string
+	core     *core.Core
+	logger   *slog.Logger
+	conns    chan struct{}
+}
+
+// Listen starts listening on addr, for mail to the addresses at domain.
+func Listen(addr, domain string, c *core.Core, logger *slog.Logger) (*Server, error) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen for mail: %w", err)
+	}
+	return &Server{
+		listener: listener,
+		domain:   domain,
+		core:     c,
+		logger:   logger,
+		conns:    make(chan struct{}, maxConnections),
+	}, nil
+}
+
+// Addr returns the address the server listens on.
+func (s *Server) Addr() net.Addr {
+	return s.listener.Addr()
+}
+
+// Serve accepts connections until ctx is cancelled, and waits for the
+// ones being served.
+func (s *Server) Serve(ctx context.Context) {
+	s.logger.Info("SMTP server started", "addr", s.listener.Addr().String(), "domain", s.domain)
+	go func() {
+		<-ctx.Done()
+		s.listener.Close()
+	}()
+
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			if ctx.Err() != nil || errors.Is(err, net.ErrClosed) {
+				return
+			}
+			s.logger.Warn("smtp accept failed", "error", err)
+			time.Sleep(time.Second)
+			continue
+		}
+		select {
+		case s.conns <- struct{}{}:
+		default:
+			fmt.Fprintf(conn, "421 %s Too many connections, try again later\r\n", s.domain)
+			conn.Close()
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-s.conns }()
+			s.serveConn(ctx, conn)
+		}()
+	}
+}
+
+// session is the state of a connection: the client's greeting and the
+// message being sent.
+type session struct {
+	conn       net.Conn
+	r          *bufio.Reader
+	w          *bufio.Writer
+	helo       string
+	from       string
+	hasFrom    bool
+	recipients []int64
+}
+
+func (s *Server) serveConn(ctx context.Context, conn net.Conn) {
+	defer conn.Close()
+	// On shutdown the client waiting for a reply is let go.
+	stop := context.AfterFunc(ctx, func() { conn.SetReadDeadline(time.Now()) })
+	defer stop()
+	sess := &session{
+		conn: conn,
+		r:    bufio.NewReaderSize(conn, maxLineBytes),
+		w:    bufio.NewWriter(conn),
+	}
+	sess.reply(220, s.domain+" ESMTP Kindlepathy")
+
+	for ctx.Err() == nil {
+		conn.SetReadDeadline(time.Now().Add(commandTimeout))
+		line, err := sess.r.ReadSlice('\n')
+		if errors.Is(err, bufio.ErrBufferFull) {
+			sess.reply(500, "Line too long")
+			return
+		}
+		if err != nil {
+			if ctx.Err() != nil {
+				break
+			}
+			return
+		}
+		verb, arg, _ := strings.Cut(strings.TrimRight(string(line), "\r\n"), " ")
+		arg = strings.TrimSpace(arg)
+
+		switch strings.ToUpper(verb) {
+		case "HELO", "EHLO":
+			if arg == "" {
+				sess.reply(501, "Domain name required")
+				continue
+			}
+			sess.reset()
+			sess.helo = arg
+			if strings.EqualFold(verb, "HELO") {
+				sess.reply(250, s.domain)
+				continue
+			}
+			sess.reply(250, s.domain, "SIZE "+strconv.Itoa(core.MaxNewsletterBytes), "8BITMIME", "PIPELINING")
+		case "MAIL":
+			s.mail(sess, arg)
+		case "RCPT":
+			s.rcpt(ctx, sess, arg)
+		case "DATA":
+			if !s.data(ctx, sess) {
+				return
+			}
+		case "RSET":
+			sess.reset()
+			sess.reply(250, "OK")
+		case "NOOP":
+			sess.reply(250, "OK")
+		case "VRFY":
+			sess.reply(252, "Cannot verify, but will accept the message")
+		case "HELP":
+			sess.reply(214, "Send mail to the address shown in Kindlepathy's settings")
+		case "QUIT":
+			sess.reply(221, s.domain+" Bye")
+			return
+		default:
+			sess.reply(502, "Command not implemented")
+		}
+	}
+	sess.reply(421, s.domain+" Shutting down")
+}
+
+func (s *Server) mail(sess *session, arg string) {
+	if sess.helo == "" {
+		sess.reply(503, "Send HELO or EHLO first")
+		return
+	}
+	if sess.hasFrom {
+		sess.reply(503, "Sender already given")
+		return
+	}
+	from, params, ok := parsePath(arg, "FROM:")
+	if !ok {
+		sess.reply(501, "Syntax: MAIL FROM:<address>")
+		return
+	}
+	for _, param := range params {
+		key, value, _ := strings.Cut(param, "=")
+		if strings.EqualFold(key, "SIZE") {
+			if size, err := strconv.Atoi(value); err == nil && size > core.MaxNewsletterBytes {
+				sess.reply(552, "Message too large")
+				return
+			}
+		}
+	}
+	sess.from = from
+	sess.hasFrom = true
+	sess.reply(250, "OK")
+}
+
+func (s *Server) rcpt(ctx context.Context, sess *session, arg string) {
+	if !sess.hasFrom {
+		sess.reply(503, "Send MAIL first")
+		return
+	}
+	to, _, ok := parsePath(arg, "TO:")
+	if !ok || to == "" {
+		sess.reply(501, "Syntax: RCPT TO:<address>")
+		return
+	}
+	if len(sess.recipients) >= maxRecipients {
+		sess.reply(452, "Too many recipients")
+		return
+	}
+	userID, err := s.core.InboundRecipient(ctx, to)
+	if errors.Is(err, core.ErrUnknownRecipient) {
+		sess.reply(550, "No such user here")
+		return
+	}
+	if err != nil {
+		s.logger.Warn("failed to find mail recipient", "error", err)
+		sess.reply(451, "Temporary failure, try again later")
+		return
+	}
+	for _, id := range sess.recipients {
+		if id == userID {
+			sess.reply(250, "OK")
+			return
+		}
+	}
+	sess.recipients = append(sess.recipients, userID)
+	sess.reply(250, "OK")
+}
+
+// data reads a message and adds it to the recipients' libraries. It
+// returns false when the connection can't go on.
+func (s *Server) data(ctx context.Context, sess *session) bool {
+	if len(sess.recipients) == 0 {
+		sess.reply(503, "Send RCPT first")
+		return true
+	}
+	sess.reply(354, "End data with <CR><LF>.<CR><LF>")
+	sess.conn.SetReadDeadline(time.Now().Add(dataTimeout))
+	dot := textproto.NewReader(sess.r).DotReader()
+	msg, err := io.ReadAll(io.LimitReader(dot, core.MaxNewsletterBytes+1))
+	if err != nil {
+		return false
+	}
+	if len(msg) > core.MaxNewsletterBytes {
+		if _, err := io.Copy(io.Discard, dot); err != nil {
+			return false
+		}
+		sess.reset()
+		sess.reply(552, "Message too large")
+		return true
+	}
+
+	code, text := 250, "OK"
+	for _, userID := range sess.recipients {
+		addCtx, cancel := context.WithTimeout(ctx, addTimeout)
+		_, err := s.core.AddNewsletter(addCtx, userID, bytes.NewReader(msg), time.Now())
+		cancel()
+		if err == nil {
+			continue
+		}
+		// A message one recipient couldn't take is sent again, the others
+		// get the same item again.
+		if errors.Is(err, core.ErrInvalidMessage) {
+			s.logger.Warn("rejected mail", "error", err, "userID", userID, "from", sess.from)
+			if code == 250 {
+				code, text = 554, "Message rejected: "+err.Error()
+			}
+			continue
+		}
+		s.logger.Warn("failed to add mail", "error", err, "userID", userID, "from", sess.from)
+		code, text = 451, "Temporary failure, try again later"
+	}
+	sess.reset()
+	sess.reply(code, text)
+	return true
+}
+
+// reset forgets the message being sent, keeping the greeting.
+func (sess *session) reset() {
+	sess.from = ""
+	sess.hasFrom = false
+	sess.recipients = nil
+}
+
+// reply sends a reply, on several lines when there are several texts.
+func (sess *session) reply(code int, texts ...string) {
+	sess.conn.SetWriteDeadline(time.Now().Add(commandTimeout))
+	for i, text := range texts {
+		sep := "-"
+		if i == len(texts)-1 {
+			sep = " "
+		}
+		text = strings.NewReplacer("\r", " ", "\n", " ").Replace(text)
+		fmt.Fprintf(sess.w, "%d%s%s\r\n", code, sep, text)
+	}
+	sess.w.Flush()
+}
+
+// parsePath parses the argument of MAIL and RCPT, like
+// "FROM:<a@example.com> SIZE=1000", returning the address and the
+// parameters after it.
+func parsePath(arg, prefix string) (string, []string, bool) {
+	if len(arg) < len(prefix) || !strings.EqualFold(arg[:len(prefix)], prefix) {
+		return "", nil, false
+	}
+	rest := strings.TrimSpace(arg[len(prefix):])
+	if !strings.HasPrefix(rest, "<") {
+		// Some clients leave out the brackets.
+		path, params, _ := strings.Cut(rest, " ")
+		return path, strings.Fields(params), path != ""
+	}
+	end := strings.IndexByte(rest, '>')
+	if end < 0 {
+		return "", nil, false
+	}
+	path := rest[1:end]
+	// Source routes, <@relay:a@example.com>, are ignored.
+	if strings.HasPrefix(path, "@") {
+		if _, after, ok := strings.Cut(path, ":"); ok {
+			path = after
+		}
+	}
+	return path, strings.Fields(rest[end+1:]), true
+}
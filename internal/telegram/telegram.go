@@ -0,0 +1,279 @@
+package telegram
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/egemengol/kindlepathy/internal/core"
+)
+
+const platform = "telegram"
+
+// pollTimeout is how long a getUpdates long poll may wait for new messages.
+const pollTimeout = 50 * time.Second
+
+// Bot adds links sent to a Telegram bot to the library of the linked user,
+// and replies with the link to read them, or the item being read as an
+// EPUB. It uses long polling, so no public webhook URL is needed.
+type Bot struct {
+	token string
+	// publicURL is where the instance is reached, for the links to read
+	// items. Replies have no links without it.
+	publicURL  string
+	core       *core.Core
+	logger     *slog.Logger
+	httpClient *http.Client
+}
+
+func NewBot(token, publicURL string, c *core.Core, logger *slog.Logger) *Bot {
+	return &Bot{
+		token:     token,
+		publicURL: strings.TrimSuffix(publicURL, "/"),
+		core:      c,
+		logger:    logger,
+		httpClient: &http.Client{
+			Timeout: pollTimeout + 10*time.Second,
+		},
+	}
+}
+
+type update struct {
+	UpdateID int64    `json:"update_id"`
+	Message  *message `json:"message"`
+}
+
+type message struct {
+	Chat struct {
+		ID int64 `json:"id"`
+	} `json:"chat"`
+	Text string `json:"text"`
+}
+
+// Run polls for updates until ctx is cancelled.
+func (b *Bot) Run(ctx context.Context) {
+	b.logger.Info("Telegram bot started")
+	var offset int64
+	for {
+		updates, err := b.getUpdates(ctx, offset)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			b.logger.Warn("telegram getUpdates failed", "error", err)
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(5 * time.Second):
+			}
+			continue
+		}
+
+		for _, u := range updates {
+			offset = u.UpdateID + 1
+			if u.Message == nil || u.Message.Text == "" {
+				continue
+			}
+			b.handleMessage(ctx, u.Message)
+		}
+	}
+}
+
+func (b *Bot) handleMessage(ctx context.Context, msg *message) {
+	chatID := strconv.FormatInt(msg.Chat.ID, 10)
+	text := strings.TrimSpace(msg.Text)
+
+	if command, arg, ok := strings.Cut(text, " "); ok && (command == "/start" || command == "/link") {
+		if _, err := b.core.LinkChat(ctx, platform, chatID, strings.TrimSpace(arg), time.Now()); err != nil {
+			b.reply(ctx, msg.Chat.ID, "Could not link this chat: "+err.Error())
+			return
+		}
+		b.reply(ctx, msg.Chat.ID, "Linked! Send me links and I'll add them to your library.")
+		return
+	}
+	switch command, _, _ := strings.Cut(text, " "); command {
+	case "/read":
+		b.replyReading(ctx, msg.Chat.ID, chatID)
+		return
+	case "/epub":
+		b.sendEPUB(ctx, msg.Chat.ID, chatID)
+		return
+	}
+	if strings.HasPrefix(text, "/") {
+		b.reply(ctx, msg.Chat.ID, "Send me a link to add it to your library. /read links to what you're reading and /epub sends it as an EPUB. To link this chat, generate a code on the Integrations page and send /link <code>.")
+		return
+	}
+
+	summaries, err := b.core.AddItemFromChat(ctx, platform, chatID, text, time.Now())
+	if errors.Is(err, core.ErrChatNotLinked) {
+		b.reply(ctx, msg.Chat.ID, "This chat is not linked yet. Generate a code on the Integrations page and send /link <code>.")
+		return
+	}
+	for _, summary := range summaries {
+		b.reply(ctx, msg.Chat.ID, "Added: "+b.describe(summary))
+	}
+	if err != nil {
+		b.logger.Warn("failed to add item from telegram", "error", err, "chatID", chatID)
+		b.reply(ctx, msg.Chat.ID, "Failed to add: "+err.Error())
+		return
+	}
+	if len(summaries) == 0 {
+		b.reply(ctx, msg.Chat.ID, "I couldn't find a link in that message.")
+	}
+}
+
+// describe returns the title of an item, with its reading time and the
+// link to read it.
+func (b *Bot) describe(summary *core.ItemSummary) string {
+	text := summary.Title
+	if summary.ReadingMinutes > 0 {
+		text += fmt.Sprintf(" (%d min read)", summary.ReadingMinutes)
+	}
+	if b.publicURL != "" {
+		text += fmt.Sprintf("\n%s/read/%d", b.publicURL, summary.ID)
+	}
+	return text
+}
+
+// reading returns the item the chat's user is reading, replying why when
+// there's none.
+func (b *Bot) reading(ctx context.Context, chatID int64, chat string) *core.ItemSummary {
+	userID, err := b.core.ChatUser(ctx, platform, chat)
+	if errors.Is(err, core.ErrChatNotLinked) {
+		b.reply(ctx, chatID, "This chat is not linked yet. Generate a code on the Integrations page and send /link <code>.")
+		return nil
+	}
+	if err != nil {
+		b.logger.Warn("failed to get telegram chat user", "error", err, "chatID", chat)
+		b.reply(ctx, chatID, "Something went wrong, try again later.")
+		return nil
+	}
+	summary, err := b.core.GetContinueReading(ctx, userID)
+	if err != nil {
+		b.logger.Warn("failed to get item being read", "error", err, "userID", userID)
+		b.reply(ctx, chatID, "Something went wrong, try again later.")
+		return nil
+	}
+	if summary == nil {
+		b.reply(ctx, chatID, "You're not reading anything yet, send me a link.")
+	}
+	return summary
+}
+
+func (b *Bot) replyReading(ctx context.Context, chatID int64, chat string) {
+	if summary := b.reading(ctx, chatID, chat); summary != nil {
+		b.reply(ctx, chatID, "Reading: "+b.describe(summary))
+	}
+}
+
+// sendEPUB sends the item being read as an EPUB.
+func (b *Bot) sendEPUB(ctx context.Context, chatID int64, chat string) {
+	summary := b.reading(ctx, chatID, chat)
+	if summary == nil {
+		return
+	}
+	var epub bytes.Buffer
+	if err := b.core.ExportEPUB(ctx, summary.ID, &epub); err != nil {
+		b.logger.Warn("failed to export EPUB for telegram", "error", err, "itemID", summary.ID)
+		b.reply(ctx, chatID, "Failed to make the EPUB: "+err.Error())
+		return
+	}
+
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	mw.WriteField("chat_id", strconv.FormatInt(chatID, 10))
+	mw.WriteField("caption", summary.Title)
+	part, err := mw.CreateFormFile("document", core.Slugify(summary.Title)+".epub")
+	if err != nil {
+		return
+	}
+	part.Write(epub.Bytes())
+	if err := mw.Close(); err != nil {
+		return
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", b.apiURL("sendDocument"), &body)
+	if err != nil {
+		return
+	}
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	if err := b.do(req, nil); err != nil {
+		b.logger.Warn("telegram sendDocument failed", "error", err, "chatID", chatID)
+	}
+}
+
+func (b *Bot) getUpdates(ctx context.Context, offset int64) ([]update, error) {
+	query := url.Values{}
+	query.Set("timeout", strconv.Itoa(int(pollTimeout.Seconds())))
+	query.Set("offset", strconv.FormatInt(offset, 10))
+	query.Set("allowed_updates", `["message"]`)
+
+	req, err := http.NewRequestWithContext(ctx, "GET", b.apiURL("getUpdates")+"?"+query.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var result struct {
+		OK          bool     `json:"ok"`
+		Description string   `json:"description"`
+		Result      []update `json:"result"`
+	}
+	if err := b.do(req, &result); err != nil {
+		return nil, err
+	}
+	if !result.OK {
+		return nil, fmt.Errorf("telegram error: %s", result.Description)
+	}
+	return result.Result, nil
+}
+
+func (b *Bot) reply(ctx context.Context, chatID int64, text string) {
+	body, err := json.Marshal(map[string]any{
+		"chat_id": chatID,
+		"text":    text,
+	})
+	if err != nil {
+		return
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", b.apiURL("sendMessage"), bytes.NewReader(body))
+	if err != nil {
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if err := b.do(req, nil); err != nil {
+		b.logger.Warn("telegram sendMessage failed", "error", err, "chatID", chatID)
+	}
+}
+
+func (b *Bot) apiURL(method string) string {
+	return fmt.Sprintf("https://api.telegram.org/bot%s/%s", b.token, method)
+}
+
+func (b *Bot) do(req *http.Request, out any) error {
+	resp, err := b.httpClient.Do(req)
+	if err != nil {
+		// The request URL contains the bot token, keep it out of the logs.
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			return urlErr.Err
+		}
+		return err
+	}
+	defer resp.Body.Close()
+
+	if out == nil {
+		if resp.StatusCode != http.StatusOK {
+			return fmt.Errorf("telegram returned status %d", resp.StatusCode)
+		}
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
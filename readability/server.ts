@@ -2,6 +2,11 @@ import { Readability } from "@mozilla/readability";
 import { JSDOM } from "jsdom";
 import { Logger, type ILogObj } from "tslog";
 import type { Serve, Server } from "bun";
+import { createInterface } from "node:readline";
+
+// With --stdio, requests come in as JSON-RPC over stdin and stdout instead of
+// HTTP, so stdout is kept for responses and logs go to stderr.
+const stdio = process.argv.includes("--stdio");
 
 const logger: Logger<ILogObj> = new Logger({
   name: "ReadabilityService",
@@ -10,8 +15,28 @@ const logger: Logger<ILogObj> = new Logger({
     : 3,
   type: "pretty",
   prettyLogTemplate: "{{name}}\t{{dateIsoStr}}\t{{logLevelName}}\t",
+  overwrite: stdio
+    ? {
+        transportFormatted: (logMetaMarkup, logArgs, logErrors) => {
+          console.error(logMetaMarkup, ...logArgs, ...logErrors);
+        },
+      }
+    : undefined,
 });
 
+function parseArticle(htmlContent: string, documentUrl?: string) {
+  const start = performance.now();
+  const dom = new JSDOM(htmlContent, { url: documentUrl });
+  const afterDom = performance.now();
+  logger.debug(`Article dom in ${(afterDom - start).toFixed(2)}ms`);
+  const reader = new Readability(dom.window.document);
+  const article = reader.parse();
+  logger.debug(
+    `Article parsing completed in ${(performance.now() - afterDom).toFixed(2)}ms`,
+  );
+  return article;
+}
+
 async function handleFetch(req: Request): Promise<Response> {
   // ... (handleFetch implementation remains the same) ...
   if (req.method !== "POST") {
@@ -36,15 +61,7 @@ async function handleFetch(req: Request): Promise<Response> {
     }
 
     const documentUrl = req.headers.get("x-document-url") || undefined;
-    const start = performance.now();
-    const dom = new JSDOM(htmlContent, { url: documentUrl });
-    const afterDom = performance.now();
-    logger.debug(`Article dom in ${(afterDom - start).toFixed(2)}ms`);
-    const reader = new Readability(dom.window.document);
-    const article = reader.parse();
-    logger.debug(
-      `Article parsing completed in ${(performance.now() - afterDom).toFixed(2)}ms`,
-    );
+    const article = parseArticle(htmlContent, documentUrl);
 
     if (!article) {
       // Readability couldn't parse anything meaningful
@@ -83,6 +100,53 @@ function handleError(error: Error): Response {
   });
 }
 
+// --- JSON-RPC over stdio ---
+// A request per line: {"jsonrpc":"2.0","id":1,"method":"parse","params":{"html":"...","url":"..."}}
+// answered by a line with the same id and the article, or null, as result.
+function handleRpc(line: string): object {
+  let request: any;
+  try {
+    request = JSON.parse(line);
+  } catch {
+    return { jsonrpc: "2.0", id: null, error: { code: -32700, message: "Parse error" } };
+  }
+  const id = request?.id ?? null;
+  if (request?.method !== "parse") {
+    return { jsonrpc: "2.0", id, error: { code: -32601, message: "Method not found" } };
+  }
+  const html = request.params?.html;
+  if (typeof html !== "string" || !html) {
+    return {
+      jsonrpc: "2.0",
+      id,
+      error: { code: -32602, message: "Request body cannot be empty" },
+    };
+  }
+  try {
+    const article = parseArticle(html, request.params.url || undefined);
+    if (article) {
+      logger.info(`Article parsed successfully: ${article.title}`);
+    }
+    return { jsonrpc: "2.0", id, result: article };
+  } catch (error: unknown) {
+    logger.error(`Failed to process article`, error);
+    return { jsonrpc: "2.0", id, error: { code: -32000, message: "Processing Failed" } };
+  }
+}
+
+async function serveStdio() {
+  const lines = createInterface({ input: process.stdin, crlfDelay: Infinity });
+  for await (const line of lines) {
+    if (!line.trim()) {
+      continue;
+    }
+    process.stdout.write(JSON.stringify(handleRpc(line)) + "\n");
+  }
+  // The parent closes stdin to stop the sidecar, no signals needed.
+  logger.info("stdin closed, exiting");
+  process.exit(0);
+}
+
 // --- Server Options ---
 let serverOptions = {
   fetch: handleFetch,
@@ -92,12 +156,12 @@ let serverOptions = {
   unix: undefined as string | undefined,
 };
 
-// --- Argument Parsing (remains the same) ---
+// --- Argument Parsing ---
 const udsArgIndex = process.argv.indexOf("--uds");
 if (udsArgIndex !== -1 && process.argv.length > udsArgIndex + 1) {
   serverOptions.unix = process.argv[udsArgIndex + 1];
   logger.info(`Configured to listen on UDS: ${serverOptions.unix}`);
-} else {
+} else if (!stdio) {
   serverOptions.port = parseInt(process.env.PORT || "3000", 10);
   serverOptions.hostname = process.env.HOSTNAME || "0.0.0.0";
   logger.info(
@@ -105,9 +169,15 @@ if (udsArgIndex !== -1 && process.argv.length > udsArgIndex + 1) {
   );
 }
 
-// --- Start Server (remains the same) ---
-const server: Server = Bun.serve(serverOptions as Serve<typeof serverOptions>);
-logger.info(`Server process started successfully`);
+// --- Start Server ---
+let server: Server | undefined;
+if (stdio) {
+  logger.info(`Configured to serve JSON-RPC on stdio`);
+  serveStdio();
+} else {
+  server = Bun.serve(serverOptions as Serve<typeof serverOptions>);
+  logger.info(`Server process started successfully`);
+}
 
 // --- Shutdown Handler ---
 // This is the critical part for test cleanup
@@ -119,7 +189,8 @@ const shutdown = async (signal: string) => {
   try {
     // Attempt to stop the server gracefully first
     // Use a timeout for stopping the server gracefully, e.g., 500ms
-    const stopPromise = server.stop(true); // true = close connections immediately
+    // There's no server to stop in stdio mode.
+    const stopPromise = server ? server.stop(true) : Promise.resolve(); // true = close connections immediately
     const timeoutPromise = new Promise(
       (_, reject) =>
         setTimeout(() => reject(new Error("server.stop() timed out")), 500), // 500ms timeout
@@ -0,0 +1,37 @@
+// Package web holds the pages and static files of the site, built into the
+// binary so it runs from any directory.
+package web
+
+import (
+	"embed"
+	"errors"
+	"io/fs"
+	"os"
+)
+
+//go:embed *.html static
+var files embed.FS
+
+// Assets returns the pages and static files. Files in overrideDir, laid out
+// like this directory, take the place of the built-in ones, for theming.
+func Assets(overrideDir string) fs.FS {
+	if overrideDir == "" {
+		return files
+	}
+	return overlayFS{os.DirFS(overrideDir), files}
+}
+
+// overlayFS opens a file from the first of its file systems that has it.
+type overlayFS []fs.FS
+
+func (o overlayFS) Open(name string) (fs.File, error) {
+	var err error
+	for _, fsys := range o {
+		var f fs.File
+		f, err = fsys.Open(name)
+		if !errors.Is(err, fs.ErrNotExist) {
+			return f, err
+		}
+	}
+	return nil, err
+}